@@ -0,0 +1,360 @@
+// Package bench measures the latency of queries run through gorm-cache against the same queries run without it,
+// on the storages it supports, for several result sizes and concurrencies. Runs are reproducible: the queries
+// of a run follow a sequence drawn from Config.Seed, so two runs of the same Config issue the same queries.
+//
+// The queries read a table of their own, TableName, created and filled on the database by Run and dropped
+// once it is done, so it may be run against a copy of a production database to get numbers on its hardware.
+// See cmd/gormcache-bench for a command line running it.
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// TableName the table the queries of the benchmarks read
+const TableName = "gorm_cache_bench"
+
+// Row a row of TableName, Payload pads it to a size typical of a row of an application
+type Row struct {
+	ID      int64  `gorm:"column:id;primaryKey"`
+	Name    string `gorm:"column:name"`
+	Value   int64  `gorm:"column:value"`
+	Payload string `gorm:"column:payload"`
+}
+
+func (Row) TableName() string {
+	return TableName
+}
+
+// Backend a storage queries are cached in
+type Backend struct {
+	Name string
+	// NewStorage returns a storage for a run, each run caches in a new one. Nil runs the queries without cache
+	NewStorage func() storage.DataStorage
+}
+
+// Uncached runs the queries on the database alone, the baseline the other backends compare to
+func Uncached() Backend {
+	return Backend{Name: "uncached"}
+}
+
+// Memory caches the queries in process
+func Memory() Backend {
+	return Backend{Name: "memory", NewStorage: func() storage.DataStorage {
+		return storage.NewMem(storage.DefaultMemStoreConfig)
+	}}
+}
+
+// Redis caches the queries in redis, each run under a key prefix of its own
+func Redis(client *redis.Client) Backend {
+	return Backend{Name: "redis", NewStorage: func() storage.DataStorage {
+		return storage.NewRedis(&storage.RedisStoreConfig{Client: client})
+	}}
+}
+
+// Config a benchmark, zero fields take their defaults
+type Config struct {
+	// Open opens a *gorm.DB on the database for a run, the cache of the run is attached to it. Required
+	Open func() (*gorm.DB, error)
+	// Backends the storages compared, Uncached and Memory if empty
+	Backends []Backend
+
+	// Rows number of rows of TableName. 0 represents 10000
+	Rows int
+	// PayloadBytes size of the payload of each row. 0 represents 256
+	PayloadBytes int
+	// ResultSizes rows returned by the queries of each run: 1 looks a row up by primary key, served by the
+	// primary cache, more select a range of ids, served by the search cache. Empty represents 1, 10 and 100
+	ResultSizes []int
+	// Concurrency goroutines running the queries of each run at once. Empty represents 1, 8 and 64
+	Concurrency []int
+	// Queries run by each run. 0 represents 10000
+	Queries int
+	// Keys distinct queries the queries of a run are drawn from, fewer keys hit the cache more often.
+	// 0 represents 1000
+	Keys int
+	// Seed of the sequence of queries. Runs of the same seed issue the same queries
+	Seed int64
+	// TTL in ms of the entries cached. 0 represents 60000, longer than a run
+	TTL int64
+}
+
+func (c *Config) withDefaults() Config {
+	conf := *c
+	if len(conf.Backends) == 0 {
+		conf.Backends = []Backend{Uncached(), Memory()}
+	}
+	if conf.Rows <= 0 {
+		conf.Rows = 10000
+	}
+	if conf.PayloadBytes <= 0 {
+		conf.PayloadBytes = 256
+	}
+	if len(conf.ResultSizes) == 0 {
+		conf.ResultSizes = []int{1, 10, 100}
+	}
+	if len(conf.Concurrency) == 0 {
+		conf.Concurrency = []int{1, 8, 64}
+	}
+	if conf.Queries <= 0 {
+		conf.Queries = 10000
+	}
+	if conf.Keys <= 0 {
+		conf.Keys = 1000
+	}
+	if conf.TTL <= 0 {
+		conf.TTL = 60000
+	}
+	return conf
+}
+
+// Result the measures of a run, a backend queried for results of a size at a concurrency
+type Result struct {
+	Backend     string        `json:"backend"`
+	ResultSize  int           `json:"resultSize"`
+	Concurrency int           `json:"concurrency"`
+	Queries     int           `json:"queries"`
+	Errors      int           `json:"errors"`
+	Elapsed     time.Duration `json:"elapsed"`
+	P50         time.Duration `json:"p50"`
+	P95         time.Duration `json:"p95"`
+	P99         time.Duration `json:"p99"`
+	HitRate     float64       `json:"hitRate"` // 0 for uncached runs
+}
+
+// QPS queries run per second
+func (r Result) QPS() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Queries) / r.Elapsed.Seconds()
+}
+
+// Run creates and fills TableName, runs the queries of every backend, result size and concurrency of conf,
+// and drops the table. Runs stop early if ctx is done
+func Run(ctx context.Context, conf *Config) ([]Result, error) {
+	if conf == nil || conf.Open == nil {
+		return nil, errors.New("bench: Config.Open is required")
+	}
+	c := conf.withDefaults()
+
+	db, err := c.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer closeDB(db)
+	if err = Prepare(db, c.Rows, c.PayloadBytes); err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = Cleanup(db)
+	}()
+
+	results := make([]Result, 0, len(c.Backends)*len(c.ResultSizes)*len(c.Concurrency))
+	for _, backend := range c.Backends {
+		for _, size := range c.ResultSizes {
+			for _, concurrency := range c.Concurrency {
+				if err = ctx.Err(); err != nil {
+					return results, err
+				}
+				result, err := runOne(ctx, &c, backend, size, concurrency)
+				if err != nil {
+					return results, fmt.Errorf("bench: run %s with %d rows at concurrency %d: %w",
+						backend.Name, size, concurrency, err)
+				}
+				results = append(results, result)
+			}
+		}
+	}
+	return results, nil
+}
+
+// Prepare creates TableName on db, or empties it, and fills it with rows of payloadBytes of payload
+func Prepare(db *gorm.DB, rows int, payloadBytes int) error {
+	if err := db.AutoMigrate(&Row{}); err != nil {
+		return err
+	}
+	if err := db.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&Row{}).Error; err != nil {
+		return err
+	}
+	payload := strings.Repeat("x", payloadBytes)
+	batch := make([]Row, 0, 1000)
+	for id := 1; id <= rows; id++ {
+		batch = append(batch, Row{ID: int64(id), Name: fmt.Sprintf("row-%d", id), Value: int64(id), Payload: payload})
+		if len(batch) == cap(batch) || id == rows {
+			if err := db.Create(&batch).Error; err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	return nil
+}
+
+// Cleanup drops TableName
+func Cleanup(db *gorm.DB) error {
+	return db.Migrator().DropTable(&Row{})
+}
+
+// Query runs the query returning size rows from id start: a lookup by primary key for a single row, a range
+// of ids for more
+func Query(db *gorm.DB, start int64, size int) error {
+	if size <= 1 {
+		return db.Where("id = ?", start).First(&Row{}).Error
+	}
+	rows := make([]Row, 0, size)
+	return db.Where("id BETWEEN ? AND ?", start, start+int64(size)-1).Find(&rows).Error
+}
+
+// Starts returns the first ids of the queries of a run, drawn from keys distinct ones by seed, so that the
+// ranges of size rows stay within the rows of the table
+func Starts(seed int64, queries int, keys int, rows int, size int) []int64 {
+	span := rows - size + 1
+	if span < 1 {
+		span = 1
+	}
+	if keys > span {
+		keys = span
+	}
+	r := rand.New(rand.NewSource(seed))
+	// the keys are spread over the table, the queries pick among them
+	keyStarts := make([]int64, keys)
+	for idx := range keyStarts {
+		keyStarts[idx] = int64(r.Intn(span)) + 1
+	}
+	starts := make([]int64, queries)
+	for idx := range starts {
+		starts[idx] = keyStarts[r.Intn(keys)]
+	}
+	return starts
+}
+
+// runOne runs the queries of a backend for results of size at concurrency, on a db of its own
+func runOne(ctx context.Context, c *Config, backend Backend, size int, concurrency int) (Result, error) {
+	db, err := c.Open()
+	if err != nil {
+		return Result{}, err
+	}
+	defer closeDB(db)
+
+	var gormCache cache.Cache
+	if backend.NewStorage != nil {
+		gormCache, err = cache.NewGorm2Cache(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelAll,
+			CacheStorage: backend.NewStorage(),
+			CacheTTL:     c.TTL,
+		})
+		if err != nil {
+			return Result{}, err
+		}
+		if closer, ok := gormCache.(interface{ Close() error }); ok {
+			defer func() {
+				_ = closer.Close()
+			}()
+		}
+		if err = db.Use(gormCache); err != nil {
+			return Result{}, err
+		}
+	}
+
+	starts := Starts(c.Seed, c.Queries, c.Keys, c.Rows, size)
+	latencies := make([]time.Duration, len(starts))
+	var next int64 = -1
+	var errCount int64
+	var wg sync.WaitGroup
+	begin := time.Now()
+	for worker := 0; worker < concurrency; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			session := db.WithContext(ctx)
+			for {
+				idx := atomic.AddInt64(&next, 1)
+				if idx >= int64(len(starts)) || ctx.Err() != nil {
+					return
+				}
+				queryStart := time.Now()
+				if err := Query(session, starts[idx], size); err != nil {
+					atomic.AddInt64(&errCount, 1)
+				}
+				latencies[idx] = time.Since(queryStart)
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(begin)
+
+	sort.Slice(latencies, func(i, j int) bool {
+		return latencies[i] < latencies[j]
+	})
+	result := Result{
+		Backend:     backend.Name,
+		ResultSize:  size,
+		Concurrency: concurrency,
+		Queries:     len(starts),
+		Errors:      int(errCount),
+		Elapsed:     elapsed,
+		P50:         percentile(latencies, 0.50),
+		P95:         percentile(latencies, 0.95),
+		P99:         percentile(latencies, 0.99),
+	}
+	if gormCache != nil {
+		result.HitRate = gormCache.HitRate()
+	}
+	return result, nil
+}
+
+// percentile returns the latency p of the sorted latencies are below
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(float64(len(sorted)-1) * p)
+	return sorted[idx]
+}
+
+func closeDB(db *gorm.DB) {
+	if sqlDB, err := db.DB(); err == nil {
+		_ = sqlDB.Close()
+	}
+}
+
+// WriteResults writes the results as a table, each run next to the speedup of its p50 over the uncached run
+// of the same result size and concurrency
+func WriteResults(w io.Writer, results []Result) error {
+	type runKey struct{ size, concurrency int }
+	baselines := make(map[runKey]Result)
+	for _, result := range results {
+		if result.Backend == Uncached().Name {
+			baselines[runKey{result.ResultSize, result.Concurrency}] = result
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	_, _ = fmt.Fprintln(tw, "backend\trows\tconcurrency\tqps\tp50\tp95\tp99\thit rate\terrors\tp50 speedup\t")
+	for _, result := range results {
+		speedup := "-"
+		if baseline, ok := baselines[runKey{result.ResultSize, result.Concurrency}]; ok && result.P50 > 0 {
+			speedup = fmt.Sprintf("%.1fx", float64(baseline.P50)/float64(result.P50))
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%d\t%d\t%.0f\t%v\t%v\t%v\t%.1f%%\t%d\t%s\t\n", result.Backend, result.ResultSize,
+			result.Concurrency, result.QPS(), result.P50, result.P95, result.P99, result.HitRate*100, result.Errors, speedup)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,112 @@
+// Command gormcache-bench compares the latency of queries run with and without gorm-cache on a database of
+// yours, e.g.
+//
+//	go run ./bench/cmd/gormcache-bench -driver sqlite -dsn bench.db -redis localhost:6379
+//
+// It creates, fills and finally drops the table bench.TableName, it touches no other table. sqlite is built in,
+// mysql and postgres are built with their tag once their driver is added to the module:
+//
+//	go get gorm.io/driver/mysql
+//	go run -tags mysql ./bench/cmd/gormcache-bench -driver mysql -dsn "user:pass@tcp(127.0.0.1:3306)/db"
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/glebarez/sqlite"
+	"github.com/joykk/gorm-cache/bench"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// dialectors opens the databases of the drivers built in, by the name of the driver
+var dialectors = map[string]func(dsn string) gorm.Dialector{
+	"sqlite": sqlite.Open,
+}
+
+func main() {
+	driver := flag.String("driver", "sqlite", "database driver, one of "+strings.Join(driverNames(), ", "))
+	dsn := flag.String("dsn", "gormcache-bench.db", "data source name of the database")
+	redisAddr := flag.String("redis", "", "address of a redis to benchmark too, e.g. localhost:6379")
+	rows := flag.Int("rows", 10000, "rows of the bench table")
+	payload := flag.Int("payload", 256, "bytes of payload of each row")
+	sizes := flag.String("sizes", "1,10,100", "comma separated rows returned by the queries")
+	concurrency := flag.String("concurrency", "1,8,64", "comma separated goroutines querying at once")
+	queries := flag.Int("queries", 10000, "queries of each run")
+	keys := flag.Int("keys", 1000, "distinct queries the queries of a run are drawn from")
+	seed := flag.Int64("seed", 1, "seed of the sequence of queries")
+	asJSON := flag.Bool("json", false, "print the results as json")
+	flag.Parse()
+
+	dialector, ok := dialectors[*driver]
+	if !ok {
+		fail(fmt.Errorf("unknown driver %s, built with: %s", *driver, strings.Join(driverNames(), ", ")))
+	}
+	conf := &bench.Config{
+		Open: func() (*gorm.DB, error) {
+			return gorm.Open(dialector(*dsn), &gorm.Config{Logger: logger.Discard})
+		},
+		Backends:     []bench.Backend{bench.Uncached(), bench.Memory()},
+		Rows:         *rows,
+		PayloadBytes: *payload,
+		ResultSizes:  parseInts("sizes", *sizes),
+		Concurrency:  parseInts("concurrency", *concurrency),
+		Queries:      *queries,
+		Keys:         *keys,
+		Seed:         *seed,
+	}
+	if *redisAddr != "" {
+		client := redis.NewClient(&redis.Options{Addr: *redisAddr})
+		defer client.Close()
+		conf.Backends = append(conf.Backends, bench.Redis(client))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	results, err := bench.Run(ctx, conf)
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(results)
+	} else {
+		_ = bench.WriteResults(os.Stdout, results)
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+func driverNames() []string {
+	names := make([]string, 0, len(dialectors))
+	for name := range dialectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func parseInts(name string, value string) []int {
+	ints := make([]int, 0)
+	for _, part := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || n <= 0 {
+			fail(fmt.Errorf("invalid -%s %q", name, value))
+		}
+		ints = append(ints, n)
+	}
+	return ints
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "gormcache-bench:", err)
+	os.Exit(1)
+}
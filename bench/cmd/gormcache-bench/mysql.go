@@ -0,0 +1,9 @@
+//go:build mysql
+
+package main
+
+import "gorm.io/driver/mysql"
+
+func init() {
+	dialectors["mysql"] = mysql.Open
+}
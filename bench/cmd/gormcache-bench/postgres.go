@@ -0,0 +1,9 @@
+//go:build postgres
+
+package main
+
+import "gorm.io/driver/postgres"
+
+func init() {
+	dialectors["postgres"] = postgres.Open
+}
@@ -0,0 +1,241 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/joykk/gorm-cache/storage"
+	jsoniter "github.com/json-iterator/go"
+)
+
+const (
+	// adminDefaultLimit is the number of keys listed when the request doesn't set one
+	adminDefaultLimit = 100
+	// adminMaxLimit caps the keys listed by a request, listing scans the storage
+	adminMaxLimit = 10000
+)
+
+var errAdminUnsupported = errors.New("cache storage doesn't support this operation")
+
+type adminKeys struct {
+	Prefix string   `json:"prefix"`
+	Keys   []string `json:"keys"`
+}
+
+type adminKey struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	TTL   *int64 `json:"ttl,omitempty"` // ms left before the key expires, 0 if it never does, unset if unknown
+}
+
+type adminError struct {
+	Error string `json:"error"`
+}
+
+// AdminHandler returns an http.Handler to inspect and purge the cache, to be mounted on an internal
+// port, e.g. mux.Handle("/cache/", http.StripPrefix("/cache", c.AdminHandler())). It serves:
+//
+//	GET  /stats                               the StatsSnapshot
+//	GET  /health                              the HealthStatus, with status 503 if unhealthy
+//	GET  /keys?table=&kind=primary|search     the keys of a table, or of any prefix with ?prefix=, up to ?limit=
+//	GET  /key?key=                            the value of a key and its TTL
+//	POST /purge?table=  or  POST /purge?key=  drops the cache of a table, or a single key
+//
+// Only the keys of this instance can be read or purged. Listing keys and TTLs requires the storage to
+// implement storage.KeyLister and storage.TTLReader. The handler isn't authenticated, nor should it be exposed.
+func (c *Gorm2Cache) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", c.adminStats)
+	mux.HandleFunc("/health", c.adminHealth)
+	mux.HandleFunc("/keys", c.adminKeys)
+	mux.HandleFunc("/key", c.adminKey)
+	mux.HandleFunc("/purge", c.adminPurge)
+	return mux
+}
+
+func (c *Gorm2Cache) adminStats(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, c.StatsSnapshot())
+}
+
+func (c *Gorm2Cache) adminHealth(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	status, err := c.HealthCheck(r.Context())
+	if err != nil {
+		writeAdminJSON(w, http.StatusServiceUnavailable, status)
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, status)
+}
+
+func (c *Gorm2Cache) adminKeys(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	query := r.URL.Query()
+	limit := adminDefaultLimit
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			writeAdminError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", value))
+			return
+		}
+		if limit > adminMaxLimit {
+			limit = adminMaxLimit
+		}
+	}
+
+	var prefix string
+	switch table := query.Get("table"); {
+	case table != "" && query.Get("prefix") != "":
+		writeAdminError(w, http.StatusBadRequest, errors.New("table and prefix are exclusive"))
+		return
+	case table != "":
+		switch kind := query.Get("kind"); kind {
+		case "", "primary":
+			prefix = c.keys.PrimaryCachePrefix(c.instanceId(r.Context()), table) + ":"
+		case "search":
+			prefix = c.keys.SearchCachePrefix(c.instanceId(r.Context()), table) + ":"
+		default:
+			writeAdminError(w, http.StatusBadRequest, fmt.Errorf("invalid kind %q", kind))
+			return
+		}
+	default:
+		prefix = query.Get("prefix")
+		if prefix == "" {
+			prefix = c.adminNamespace()
+		}
+		if !strings.HasPrefix(prefix, c.adminNamespace()) {
+			writeAdminError(w, http.StatusBadRequest, fmt.Errorf("prefix must start with %s", c.adminNamespace()))
+			return
+		}
+	}
+
+	ctx := r.Context()
+	lister, ok := c.storageFor(ctx).(storage.KeyLister)
+	if !ok {
+		writeAdminError(w, http.StatusNotImplemented, errAdminUnsupported)
+		return
+	}
+	keys, err := lister.ListKeysWithPrefix(ctx, prefix, limit)
+	if c.countError(ctx, err) != nil {
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if keys == nil {
+		keys = []string{}
+	}
+	writeAdminJSON(w, http.StatusOK, adminKeys{Prefix: prefix, Keys: keys})
+}
+
+func (c *Gorm2Cache) adminKey(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	key, ok := c.adminKeyParam(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	value, err := c.storageFor(ctx).GetValue(ctx, key)
+	if errors.Is(err, storage.ErrCacheNotFound) {
+		writeAdminError(w, http.StatusNotFound, err)
+		return
+	}
+	if c.countError(ctx, err) != nil {
+		writeAdminError(w, http.StatusInternalServerError, err)
+		return
+	}
+	result := adminKey{Key: key, Value: value}
+	if reader, ok := c.storageFor(ctx).(storage.TTLReader); ok {
+		// the key may expire between both reads, its value is still reported
+		if ttl, err := reader.KeyTTL(ctx, key); err == nil {
+			result.TTL = &ttl
+		} else if c.countError(ctx, err); !errors.Is(err, storage.ErrCacheNotFound) {
+			c.Logger.CtxError(ctx, "[AdminHandler] read ttl of key %s error: %v", key, err)
+		}
+	}
+	writeAdminJSON(w, http.StatusOK, result)
+}
+
+func (c *Gorm2Cache) adminPurge(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost, http.MethodDelete) {
+		return
+	}
+	ctx := r.Context()
+	query := r.URL.Query()
+	switch table := query.Get("table"); {
+	case table != "" && query.Get("key") != "":
+		writeAdminError(w, http.StatusBadRequest, errors.New("table and key are exclusive"))
+	case table != "":
+		c.Logger.CtxInfo(ctx, "[AdminHandler] purge cache of table %s", table)
+		if err := c.InvalidateTable(ctx, table); err != nil {
+			writeAdminError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		key, ok := c.adminKeyParam(w, r)
+		if !ok {
+			return
+		}
+		c.Logger.CtxInfo(ctx, "[AdminHandler] purge key %s", key)
+		if err := c.countError(ctx, c.storageFor(ctx).DeleteKey(ctx, key)); err != nil {
+			writeAdminError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// adminNamespace the prefix every key of this instance starts with
+func (c *Gorm2Cache) adminNamespace() string {
+	return c.keys.Prefix(c.InstanceId) + ":"
+}
+
+// adminKeyParam reads the key of the request, rejecting the keys of other instances sharing the storage
+func (c *Gorm2Cache) adminKeyParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		writeAdminError(w, http.StatusBadRequest, errors.New("key or table required"))
+		return "", false
+	}
+	if !strings.HasPrefix(key, c.adminNamespace()) {
+		writeAdminError(w, http.StatusBadRequest, fmt.Errorf("key must start with %s", c.adminNamespace()))
+		return "", false
+	}
+	return key, true
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeAdminError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	return false
+}
+
+func writeAdminError(w http.ResponseWriter, status int, err error) {
+	writeAdminJSON(w, status, adminError{Error: err.Error()})
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v interface{}) {
+	data, err := jsoniter.ConfigCompatibleWithStandardLibrary.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
@@ -1,44 +1,126 @@
 package cache
 
 import (
+	"context"
+	"sync"
+
 	"github.com/joykk/gorm-cache/config"
 	"gorm.io/gorm"
 )
 
+// AfterCreate invalidates the primary cache entries of the newly created rows and the search cache of the table,
+// or caches the rows in place of their entries with config.WriteThrough.
+//
+// Invalidations are issued synchronously after each statement, in statement order, so within a batch or
+// transaction that creates and then deletes the same row the cache ends up reflecting the committed state.
+// Within a transaction they are issued once it commits, and not at all if it rolls back.
+// All callback-driven mutations are deletions, so even with AsyncWrite (where they may run out of order)
+// the net effect is the same: no entry is left behind for the affected keys.
+//
+// The create branch of FirstOrCreate runs through here as well, dropping the empty result
+// its lookup may have cached, so a following FirstOrCreate finds the row instead of creating it again.
+// The created row is cached in the primary cache in place of its entry, see isFirstOrCreate.
 func (c *Gorm2Cache) AfterCreate(cache *Gorm2Cache) func(db *gorm.DB) {
 	return func(db *gorm.DB) {
 		if db.RowsAffected == 0 {
 			return // no rows affected, no need to invalidate cache
 		}
 
-		tableName := ""
-		if db.Statement.Schema != nil {
-			tableName = db.Statement.Schema.Table
-		} else {
-			tableName = db.Statement.Table
-		}
+		tableName := getTableName(db)
 		ctx := db.Statement.Context
 
+		if db.Error == nil {
+			cache.markTableWritten(db, tableName)
+			if matchTable(tableName, cache.Config.BloomFilter.Tables) {
+				primaryKeys, _ := cache.getObjectsAfterLoad(db)
+				cache.addToBloomFilter(tableName, primaryKeys)
+			}
+		}
+
 		if db.Error == nil && cache.Config.InvalidateWhenUpdate && c.ShouldCache(db, tableName) {
-			if cache.Config.CacheLevel == config.CacheLevelAll || cache.Config.CacheLevel == config.CacheLevelOnlySearch {
-				invalidSearchCache := func() {
+			var wg sync.WaitGroup
+			wg.Add(2)
+			failures := &invalidationFailures{}
+
+			go func() {
+				defer wg.Done()
+
+				if cache.primaryCacheEnabled(tableName) &&
+					!cache.isKeylessModel(db) {
+					// A created row may reuse the primary key of a row that was cached before
+					// (e.g. deleted and re-inserted in the same batch), so drop any leftover entry.
+					primaryKeys, objects := cache.getObjectsAfterLoad(db)
+					if len(primaryKeys) == 0 {
+						return
+					}
+					if cache.writesThrough(db) && createdRowsKnown(db) &&
+						cache.writeThrough(db, tableName, primaryKeys, failures) {
+						return
+					}
+					if isFirstOrCreate(db) && len(primaryKeys) == len(objects) && !isPartialSelect(db) {
+						// overwritten in a single write, the "record not found" of the lookup included
+						if write := cache.primaryCacheWrite(db, tableName, primaryKeys, objects); write != nil {
+							if err := write(ctx); err != nil {
+								failures.add(err, func(ctx context.Context) error {
+									return cache.BatchInvalidatePrimaryCache(ctx, tableName, primaryKeys)
+								})
+							}
+							return
+						}
+					}
+					cache.Logger.CtxInfo(ctx, "[AfterCreate] now start to invalidate cache for primary keys: %v", primaryKeys)
+					err := cache.BatchInvalidatePrimaryCache(ctx, tableName, primaryKeys)
+					if err != nil {
+						failures.add(err, func(ctx context.Context) error {
+							return cache.BatchInvalidatePrimaryCache(ctx, tableName, primaryKeys)
+						})
+						cache.Logger.CtxError(ctx, "[AfterCreate] invalidating cache for primary keys: %v error: %v",
+							primaryKeys, err)
+						return
+					}
+					cache.Logger.CtxInfo(ctx, "[AfterCreate] invalidating cache for primary keys: %v finished.", primaryKeys)
+				}
+			}()
+
+			go func() {
+				defer wg.Done()
+
+				if cache.searchCacheEnabled(tableName) {
 					// We invalidate search cache here,
 					// because any newly created objects may cause search cache results to be outdated and invalid.
 					cache.Logger.CtxInfo(ctx, "[AfterCreate] now start to invalidate search cache for table: %s", tableName)
 					err := cache.InvalidateSearchCache(ctx, tableName)
 					if err != nil {
+						failures.add(err, func(ctx context.Context) error {
+							return cache.InvalidateSearchCache(ctx, tableName)
+						})
 						cache.Logger.CtxError(ctx, "[AfterCreate] invalidating search cache for table %s error: %v",
 							tableName, err)
 						return
 					}
 					cache.Logger.CtxInfo(ctx, "[AfterCreate] invalidating search cache for table: %s finished.", tableName)
 				}
-				if cache.Config.AsyncWrite {
-					go invalidSearchCache()
-				} else {
-					invalidSearchCache()
-				}
+			}()
+
+			// a failing write can only be reported while the callback is running
+			if !cache.Config.AsyncWrite || cache.Config.OnInvalidationFailure == config.InvalidationFailureFailWrite {
+				wg.Wait()
+				cache.handleInvalidationFailures(db, failures)
+			} else {
+				go func() {
+					wg.Wait()
+					cache.handleInvalidationFailures(db, failures)
+				}()
 			}
 		}
 	}
 }
+
+// isFirstOrCreate reports whether a create is the create branch of FirstOrCreate, which creates the row its
+// lookup didn't find with the statement of the lookup: INSERT has no WHERE, only a create chained after Where
+// carries one. The row then holds what the lookup would load, it is cached in the primary cache. Conditions
+// passed to FirstOrCreate inline only reach the lookup, such creates are invalidated as other creates are
+func isFirstOrCreate(db *gorm.DB) bool {
+	_, ok := db.Statement.Clauses["WHERE"]
+	return ok
+}
@@ -1,49 +1,64 @@
 package cache
 
 import (
+	"context"
 	"sync"
 
 	"github.com/joykk/gorm-cache/config"
 	"gorm.io/gorm"
 )
 
+// AfterDelete invalidates the cache of the rows deleted, soft deletes included: gorm runs them through the
+// delete callbacks as an UPDATE of their gorm.DeletedAt column, restoring them with Unscoped is an update
 func (c *Gorm2Cache) AfterDelete(cache *Gorm2Cache) func(db *gorm.DB) {
 	return func(db *gorm.DB) {
 		if db.RowsAffected == 0 {
 			return // no rows affected, no need to invalidate cache
 		}
 
-		tableName := ""
-		if db.Statement.Schema != nil {
-			tableName = db.Statement.Schema.Table
-		} else {
-			tableName = db.Statement.Table
-		}
+		tableName := getTableName(db)
 		ctx := db.Statement.Context
 
+		if db.Error == nil {
+			cache.markTableWritten(db, tableName)
+		}
+
 		if db.Error == nil && cache.Config.InvalidateWhenUpdate && c.ShouldCache(db, tableName) {
 			var wg sync.WaitGroup
 			wg.Add(2)
+			failures := &invalidationFailures{}
 
 			go func() {
 				defer wg.Done()
 
-				if cache.Config.CacheLevel == config.CacheLevelAll || cache.Config.CacheLevel == config.CacheLevelOnlyPrimary {
-					primaryKeys := getPrimaryKeysFromWhereClause(db)
+				if cache.primaryCacheEnabled(tableName) &&
+					!cache.isKeylessModel(db) {
+					primaryKeys := cache.getPrimaryKeysFromWhereClause(db)
 					if len(primaryKeys) > 0 {
 						cache.Logger.CtxInfo(ctx, "[AfterDelete] now start to invalidate cache for primary keys: %v",
 							primaryKeys)
 						err := cache.BatchInvalidatePrimaryCache(ctx, tableName, primaryKeys)
 						if err != nil {
+							failures.add(err, func(ctx context.Context) error {
+								return cache.BatchInvalidatePrimaryCache(ctx, tableName, primaryKeys)
+							})
 							cache.Logger.CtxError(ctx, "[AfterDelete] invalidating cache for primary keys: %v error: %v",
 								primaryKeys, err)
 							return
 						}
 						cache.Logger.CtxInfo(ctx, "[AfterDelete] invalidating cache for primary keys: %v finished.", primaryKeys)
+						// a missing marker only costs a query, the write doesn't fail
+						if err := cache.setSoftDeleted(ctx, db, tableName, primaryKeys); err != nil {
+							cache.Logger.CtxError(ctx, "[AfterDelete] caching soft deleted primary keys: %v error: %v",
+								primaryKeys, err)
+						}
 					} else {
 						cache.Logger.CtxInfo(ctx, "[AfterDelete] now start to invalidate all primary cache for table: %s", tableName)
 						err := cache.InvalidateAllPrimaryCache(ctx, tableName)
 						if err != nil {
+							failures.add(err, func(ctx context.Context) error {
+								return cache.InvalidateAllPrimaryCache(ctx, tableName)
+							})
 							cache.Logger.CtxError(ctx, "[AfterDelete] invalidating primary cache for table %s error: %v",
 								tableName, err)
 							return
@@ -57,10 +72,18 @@ func (c *Gorm2Cache) AfterDelete(cache *Gorm2Cache) func(db *gorm.DB) {
 			go func() {
 				defer wg.Done()
 
-				if cache.Config.CacheLevel == config.CacheLevelAll || cache.Config.CacheLevel == config.CacheLevelOnlySearch {
+				if cache.searchCacheEnabled(tableName) {
+					// with PreciseSearchInvalidation only the results containing the rows written are dropped
+					var primaryKeys []string
+					if !cache.isKeylessModel(db) {
+						primaryKeys = cache.getPrimaryKeysFromWhereClause(db)
+					}
 					cache.Logger.CtxInfo(ctx, "[AfterDelete] now start to invalidate search cache for table: %s", tableName)
-					err := cache.InvalidateSearchCache(ctx, tableName)
+					err := cache.InvalidateSearchDependents(ctx, tableName, primaryKeys)
 					if err != nil {
+						failures.add(err, func(ctx context.Context) error {
+							return cache.InvalidateSearchDependents(ctx, tableName, primaryKeys)
+						})
 						cache.Logger.CtxError(ctx, "[AfterDelete] invalidating search cache for table %s error: %v",
 							tableName, err)
 						return
@@ -69,8 +92,17 @@ func (c *Gorm2Cache) AfterDelete(cache *Gorm2Cache) func(db *gorm.DB) {
 				}
 			}()
 
-			if !cache.Config.AsyncWrite {
+			// a failing write can only be reported while the callback is running
+			if !cache.Config.AsyncWrite || cache.Config.OnInvalidationFailure == config.InvalidationFailureFailWrite {
 				wg.Wait()
+				cache.handleInvalidationFailures(db, failures)
+				cache.clearDirtyMarks(db, failures)
+			} else {
+				go func() {
+					wg.Wait()
+					cache.handleInvalidationFailures(db, failures)
+					cache.clearDirtyMarks(db, failures)
+				}()
 			}
 		}
 	}
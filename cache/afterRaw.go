@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/joykk/gorm-cache/config"
+	"gorm.io/gorm"
+)
+
+// rawWriteRegexp matches the statements writing a single table, capturing the table
+var rawWriteRegexp = regexp.MustCompile(`(?is)^\s*(?:insert(?:\s+or\s+\w+)?(?:\s+ignore)?\s+into|replace\s+into|` +
+	`update(?:\s+or\s+\w+)?(?:\s+low_priority)?(?:\s+ignore)?|delete\s+from|truncate(?:\s+table)?)\s+([^\s(;]+)`)
+
+// AfterRaw invalidates the search and primary cache of the table written by a raw statement (db.Exec),
+// whose rows can't be told apart, so all of them are dropped. The table is recognized in INSERT, REPLACE,
+// UPDATE, DELETE and TRUNCATE statements, for others (e.g. starting with a WITH clause) use InvalidateTable.
+func (c *Gorm2Cache) AfterRaw(cache *Gorm2Cache) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		if db.Error != nil || db.RowsAffected == 0 {
+			return
+		}
+
+		ctx := db.Statement.Context
+		tableName := rawWriteTable(db.Statement.SQL.String())
+		if tableName == "" {
+			cache.Logger.CtxInfo(ctx, "[AfterRaw] no table written by sql: %s", db.Statement.SQL.String())
+			return
+		}
+		cache.markTableWritten(db, tableName)
+		// the rows inserted can't be told, the bloom filter is built again
+		cache.dropBloomFilter(tableName)
+
+		if !cache.Config.InvalidateWhenUpdate || !c.ShouldCache(db, tableName) {
+			return
+		}
+		failures := &invalidationFailures{}
+		invalidate := func() {
+			cache.Logger.CtxInfo(ctx, "[AfterRaw] now start to invalidate cache for table: %s", tableName)
+			err := cache.InvalidateTable(ctx, tableName)
+			if err != nil {
+				failures.add(err, func(ctx context.Context) error {
+					return cache.InvalidateTable(ctx, tableName)
+				})
+				cache.Logger.CtxError(ctx, "[AfterRaw] invalidating cache for table %s error: %v", tableName, err)
+			} else {
+				cache.Logger.CtxInfo(ctx, "[AfterRaw] invalidating cache for table: %s finished.", tableName)
+			}
+			cache.handleInvalidationFailures(db, failures)
+		}
+
+		// a failing write can only be reported while the callback is running
+		if !cache.Config.AsyncWrite || cache.Config.OnInvalidationFailure == config.InvalidationFailureFailWrite {
+			invalidate()
+		} else {
+			go invalidate()
+		}
+	}
+}
+
+// rawWriteTable returns the unquoted table written by a raw statement, "" if it isn't recognized
+func rawWriteTable(sql string) string {
+	match := rawWriteRegexp.FindStringSubmatch(sql)
+	if match == nil {
+		return ""
+	}
+	return tableNameFromExpr(match[1])
+}
@@ -1,10 +1,13 @@
 package cache
 
 import (
+	"context"
+	"reflect"
 	"sync"
 
 	"github.com/joykk/gorm-cache/config"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func (c *Gorm2Cache) AfterUpdate(cache *Gorm2Cache) func(db *gorm.DB) {
@@ -13,30 +16,38 @@ func (c *Gorm2Cache) AfterUpdate(cache *Gorm2Cache) func(db *gorm.DB) {
 			return // no rows affected, no need to invalidate cache
 		}
 
-		tableName := ""
-		if db.Statement.Schema != nil {
-			tableName = db.Statement.Schema.Table
-		} else {
-			tableName = db.Statement.Table
-		}
+		tableName := getTableName(db)
 		ctx := db.Statement.Context
 
+		if db.Error == nil {
+			cache.markTableWritten(db, tableName)
+		}
+
 		if db.Error == nil && cache.Config.InvalidateWhenUpdate && c.ShouldCache(db, tableName) {
 			var wg sync.WaitGroup
 			wg.Add(2)
+			failures := &invalidationFailures{}
 
 			go func() {
 				defer wg.Done()
 
-				if cache.Config.CacheLevel == config.CacheLevelAll || cache.Config.CacheLevel == config.CacheLevelOnlyPrimary {
-					primaryKeys := getPrimaryKeysFromWhereClause(db)
+				if cache.primaryCacheEnabled(tableName) &&
+					!cache.isKeylessModel(db) {
+					primaryKeys := cache.getUpdatedPrimaryKeys(db)
 					cache.Logger.CtxInfo(ctx, "[AfterUpdate] parse primary keys = %v", primaryKeys)
 
+					if len(primaryKeys) > 0 && cache.writesThrough(db) && isWholeRowSave(db) &&
+						cache.writeThrough(db, tableName, primaryKeys, failures) {
+						return
+					}
 					if len(primaryKeys) > 0 {
 						cache.Logger.CtxInfo(ctx, "[AfterUpdate] now start to invalidate cache for primary keys: %+v",
 							primaryKeys)
 						err := cache.BatchInvalidatePrimaryCache(ctx, tableName, primaryKeys)
 						if err != nil {
+							failures.add(err, func(ctx context.Context) error {
+								return cache.BatchInvalidatePrimaryCache(ctx, tableName, primaryKeys)
+							})
 							cache.Logger.CtxError(ctx, "[AfterUpdate] invalidating primary cache for key %v error: %v",
 								primaryKeys, err)
 							return
@@ -46,6 +57,9 @@ func (c *Gorm2Cache) AfterUpdate(cache *Gorm2Cache) func(db *gorm.DB) {
 						cache.Logger.CtxInfo(ctx, "[AfterUpdate] now start to invalidate all primary cache for table: %s", tableName)
 						err := cache.InvalidateAllPrimaryCache(ctx, tableName)
 						if err != nil {
+							failures.add(err, func(ctx context.Context) error {
+								return cache.InvalidateAllPrimaryCache(ctx, tableName)
+							})
 							cache.Logger.CtxError(ctx, "[AfterUpdate] invalidating primary cache for table %s error: %v",
 								tableName, err)
 							return
@@ -58,10 +72,18 @@ func (c *Gorm2Cache) AfterUpdate(cache *Gorm2Cache) func(db *gorm.DB) {
 			go func() {
 				defer wg.Done()
 
-				if cache.Config.CacheLevel == config.CacheLevelAll || cache.Config.CacheLevel == config.CacheLevelOnlySearch {
+				if cache.searchCacheEnabled(tableName) {
+					// with PreciseSearchInvalidation only the results containing the rows written are dropped
+					var primaryKeys []string
+					if !cache.isKeylessModel(db) {
+						primaryKeys = cache.getUpdatedPrimaryKeys(db)
+					}
 					cache.Logger.CtxInfo(ctx, "[AfterUpdate] now start to invalidate search cache for table: %s", tableName)
-					err := cache.InvalidateSearchCache(ctx, tableName)
+					err := cache.InvalidateSearchDependents(ctx, tableName, primaryKeys)
 					if err != nil {
+						failures.add(err, func(ctx context.Context) error {
+							return cache.InvalidateSearchDependents(ctx, tableName, primaryKeys)
+						})
 						cache.Logger.CtxError(ctx, "[AfterUpdate] invalidating search cache for table %s error: %v",
 							tableName, err)
 						return
@@ -70,9 +92,81 @@ func (c *Gorm2Cache) AfterUpdate(cache *Gorm2Cache) func(db *gorm.DB) {
 				}
 			}()
 
-			if !cache.Config.AsyncWrite {
+			// a failing write can only be reported while the callback is running
+			if !cache.Config.AsyncWrite || cache.Config.OnInvalidationFailure == config.InvalidationFailureFailWrite {
 				wg.Wait()
+				cache.handleInvalidationFailures(db, failures)
+				cache.clearDirtyMarks(db, failures)
+			} else {
+				go func() {
+					wg.Wait()
+					cache.handleInvalidationFailures(db, failures)
+					cache.clearDirtyMarks(db, failures)
+				}()
+			}
+		}
+	}
+}
+
+// getUpdatedPrimaryKeys returns the primary keys of the rows an update writes: those its WHERE clause or
+// destination restrict it to, along with the keys it assigns, e.g. Updates(map[string]interface{}{"id": 2}),
+// whose rows may have been cached as not found. nil if they can't be told, the whole table is invalidated then
+func (c *Gorm2Cache) getUpdatedPrimaryKeys(db *gorm.DB) []string {
+	primaryKeys := c.getPrimaryKeysFromWhereClause(db)
+	if len(primaryKeys) == 0 {
+		return nil
+	}
+	assigned, ok := c.assignedPrimaryKeys(db)
+	if !ok {
+		return nil
+	}
+	return uniqueStringSlice(append(primaryKeys, assigned...))
+}
+
+// assignedPrimaryKeys returns the primary keys an update assigns, read from its destination since gorm drops
+// the SET clause once the update ran. false if they can't be told: a composite key partly assigned, or a key
+// computed by sql (e.g. gorm.Expr("id + 1000"))
+func (c *Gorm2Cache) assignedPrimaryKeys(db *gorm.DB) ([]string, bool) {
+	fields := c.primaryFields(db)
+	values := make([]interface{}, 0)
+	switch dest := db.Statement.Dest.(type) {
+	case map[string]interface{}:
+		for column, value := range dest {
+			for _, field := range fields {
+				if column == field.DBName || column == field.Name {
+					values = append(values, value)
+				}
+			}
+		}
+	default:
+		// the primary key of the model updated is its condition, a struct of the model set to it assigns its key
+		destValue := reflect.Indirect(reflect.ValueOf(dest))
+		if dest == db.Statement.Model || destValue.Kind() != reflect.Struct ||
+			db.Statement.Schema == nil || destValue.Type() != db.Statement.Schema.ModelType {
+			return nil, true
+		}
+		for _, field := range fields {
+			if value, isZero := field.ValueOf(db.Statement.Context, destValue); !isZero {
+				values = append(values, value)
 			}
 		}
 	}
+	if len(values) == 0 {
+		return nil, true
+	}
+	if len(fields) > 1 {
+		return nil, false
+	}
+	primaryKeys := make([]string, 0, len(values))
+	for _, value := range values {
+		if _, ok := value.(clause.Expression); ok {
+			return nil, false
+		}
+		keys := extractStringsFromVar(value)
+		if len(keys) != 1 {
+			return nil, false
+		}
+		primaryKeys = append(primaryKeys, keys[0])
+	}
+	return primaryKeys, true
 }
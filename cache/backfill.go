@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/joykk/gorm-cache/util"
+	"gorm.io/gorm"
+)
+
+const keyColumnKey = "gorm:cache:key_column"
+
+// WithKeyColumn names the column holding the primary key of map results, e.g.
+// db.Model(&User{}).Find(&rows) into []map[string]interface{}, so that they backfill the primary cache.
+// Results of model structs need no option, they are keyed by the primary field of the schema.
+// Rows are rebuilt as model objects, rows lacking the key or any column of the model are skipped.
+func WithKeyColumn(db *gorm.DB, column string) *gorm.DB {
+	return db.Set(keyColumnKey, column)
+}
+
+func getKeyColumn(db *gorm.DB) string {
+	val, ok := db.Get(keyColumnKey)
+	if !ok {
+		return ""
+	}
+	column, _ := val.(string)
+	return column
+}
+
+// mapRows returns the rows of a map or map slice destination, nil for other destinations
+func mapRows(destValue reflect.Value) []map[string]interface{} {
+	switch dest := destValue.Interface().(type) {
+	case map[string]interface{}:
+		return []map[string]interface{}{dest}
+	case []map[string]interface{}:
+		return dest
+	}
+	return nil
+}
+
+// backfillPrimaryFromMaps caches map rows in the primary cache under the value of keyColumn
+func (c *Gorm2Cache) backfillPrimaryFromMaps(ctx context.Context, db *gorm.DB, tableName string,
+	rows []map[string]interface{}, keyColumn string) {
+	if !c.primaryCacheEnabled(tableName) {
+		return
+	}
+	if db.Statement.Schema == nil {
+		c.Logger.CtxInfo(ctx, "[backfillPrimaryFromMaps] no model for table %s, not cached", tableName)
+		return
+	}
+	if c.tooManyRows(tableName, len(rows)) {
+		return
+	}
+
+	kvs := make([]util.Kv, 0, len(rows))
+	for _, row := range rows {
+		key, ok := row[keyColumn]
+		if !ok || key == nil || reflect.ValueOf(key).IsZero() {
+			continue
+		}
+		object, ok := rowToModel(ctx, db, row)
+		if !ok {
+			continue
+		}
+		jsonStr, err := c.serializer.Marshal(object)
+		if err != nil {
+			c.Logger.CtxError(ctx, "[backfillPrimaryFromMaps] object %v cannot marshal, not cached", object)
+			continue
+		}
+		if c.tooLarge(jsonStr) {
+			continue
+		}
+		kvs = append(kvs, util.Kv{Key: fmt.Sprintf("%v", reflect.Indirect(reflect.ValueOf(key))), Value: string(jsonStr)})
+	}
+	if len(kvs) == 0 {
+		return
+	}
+	if err := c.BatchSetPrimaryKeyCache(ctx, tableName, kvs); err != nil {
+		c.Logger.CtxError(ctx, "[backfillPrimaryFromMaps] batch set primary key cache error: %v", err)
+	}
+}
+
+// rowToModel builds a model object from a map row, a partial row is reported as not ok
+// since caching it would serve the missing fields as zero values
+func rowToModel(ctx context.Context, db *gorm.DB, row map[string]interface{}) (interface{}, bool) {
+	object := reflect.New(db.Statement.Schema.ModelType)
+	for _, field := range db.Statement.Schema.Fields {
+		if field.DBName == "" {
+			continue
+		}
+		value, ok := row[field.DBName]
+		if !ok {
+			return nil, false
+		}
+		if err := field.Set(ctx, object.Elem(), value); err != nil {
+			return nil, false
+		}
+	}
+	return object.Interface(), true
+}
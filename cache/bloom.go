@@ -0,0 +1,209 @@
+package cache
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/joykk/gorm-cache/util"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	defaultBloomExpectedItems     = 1000000
+	defaultBloomFalsePositiveRate = 0.01
+)
+
+// bloomFilter a bloom filter of primary keys, safe for concurrent use
+type bloomFilter struct {
+	bits   []uint64
+	m      uint64 // number of bits
+	hashes uint64
+}
+
+// newBloomFilter returns a filter letting through about p of the keys not added once n keys are
+func newBloomFilter(n int, p float64) *bloomFilter {
+	if n <= 0 {
+		n = defaultBloomExpectedItems
+	}
+	if p <= 0 || p >= 1 {
+		p = defaultBloomFalsePositiveRate
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	hashes := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+	return &bloomFilter{bits: make([]uint64, (m+63)/64), m: m, hashes: hashes}
+}
+
+// positions derives the bits of key from the two halves of its hash
+func (f *bloomFilter) positions(key string, fn func(word int, mask uint64) bool) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum&math.MaxUint32, sum>>32|1
+	for i := uint64(0); i < f.hashes; i++ {
+		bit := (h1 + i*h2) % f.m
+		if !fn(int(bit/64), 1<<(bit%64)) {
+			return
+		}
+	}
+}
+
+func (f *bloomFilter) add(key string) {
+	f.positions(key, func(word int, mask uint64) bool {
+		for {
+			old := atomic.LoadUint64(&f.bits[word])
+			if old&mask != 0 || atomic.CompareAndSwapUint64(&f.bits[word], old, old|mask) {
+				return true
+			}
+		}
+	})
+}
+
+// mayContain reports false if key was never added
+func (f *bloomFilter) mayContain(key string) bool {
+	contains := true
+	f.positions(key, func(word int, mask uint64) bool {
+		contains = atomic.LoadUint64(&f.bits[word])&mask != 0
+		return contains
+	})
+	return contains
+}
+
+// bloomGuard the filter of a table of Config.BloomFilter, and the one being built in its place
+type bloomGuard struct {
+	mu         sync.Mutex
+	filter     *bloomFilter // nil until built, or once dropped
+	building   *bloomFilter // fed the keys created while the table is scanned too, nil if not building
+	builtAt    time.Time
+	generation int // bumped when the filter is dropped, a build started before is discarded
+	fields     []*schema.Field
+}
+
+// rejectsPrimaryKeys reports whether none of primaryKeys exists in the table according to its filter of
+// Config.BloomFilter, starting to build the filter if the table has none yet or it is due for a rebuild
+func (c *Gorm2Cache) rejectsPrimaryKeys(db *gorm.DB, tableName string, primaryKeys []string) bool {
+	if !matchTable(tableName, c.Config.BloomFilter.Tables) {
+		return false
+	}
+	v, ok := c.bloomGuards.Load(tableName)
+	if !ok {
+		v, _ = c.bloomGuards.LoadOrStore(tableName, &bloomGuard{fields: c.primaryFields(db)})
+	}
+	guard := v.(*bloomGuard)
+
+	guard.mu.Lock()
+	filter := guard.filter
+	rebuild := c.Config.BloomFilter.RebuildInterval > 0 &&
+		time.Since(guard.builtAt) >= time.Duration(c.Config.BloomFilter.RebuildInterval)*time.Millisecond
+	if guard.building == nil && (filter == nil || rebuild) {
+		guard.building = newBloomFilter(c.Config.BloomFilter.ExpectedItems, c.Config.BloomFilter.FalsePositiveRate)
+		go c.buildBloomFilter(tableName, guard, guard.building, guard.generation)
+	}
+	guard.mu.Unlock()
+
+	if filter == nil {
+		return false
+	}
+	for _, primaryKey := range primaryKeys {
+		if filter.mayContain(primaryKey) {
+			return false
+		}
+	}
+	c.IncrBloomFilterRejectCount()
+	return true
+}
+
+// buildBloomFilter adds the primary keys of every row of the table to filter, which replaces the filter of
+// the table unless it was dropped meanwhile
+func (c *Gorm2Cache) buildBloomFilter(tableName string, guard *bloomGuard, filter *bloomFilter, generation int) {
+	ctx := context.Background()
+	err := c.scanPrimaryKeys(ctx, tableName, guard.fields, filter.add)
+
+	guard.mu.Lock()
+	defer guard.mu.Unlock()
+	guard.building = nil
+	// a failed build is tried again after RebuildInterval, or on the next lookup of a table without filter
+	guard.builtAt = time.Now()
+	if err != nil {
+		c.Logger.CtxError(ctx, "[buildBloomFilter] scan primary keys of table %s error: %v", tableName, err)
+		return
+	}
+	if generation == guard.generation {
+		guard.filter = filter
+	}
+}
+
+// scanPrimaryKeys calls fn with the primary key of every row of the table, soft deleted ones included
+func (c *Gorm2Cache) scanPrimaryKeys(ctx context.Context, tableName string, fields []*schema.Field, fn func(primaryKey string)) error {
+	if c.db == nil {
+		return util.ErrNotAttached
+	}
+	columns := make([]string, 0, len(fields))
+	for _, field := range fields {
+		columns = append(columns, field.DBName)
+	}
+	rows, err := c.db.Session(&gorm.Session{NewDB: true, Context: ctx}).Table(tableName).Select(columns).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	values := make([]interface{}, len(fields))
+	for idx, field := range fields {
+		values[idx] = reflect.New(field.FieldType).Interface()
+	}
+	parts := make([]interface{}, len(fields))
+	for rows.Next() {
+		if err = rows.Scan(values...); err != nil {
+			return err
+		}
+		for idx, value := range values {
+			parts[idx] = reflect.Indirect(reflect.ValueOf(value)).Interface()
+		}
+		fn(util.JoinPrimaryKey(parts...))
+	}
+	return rows.Err()
+}
+
+// addToBloomFilter adds the primary keys of rows created to the filter of the table, and to the one being built
+func (c *Gorm2Cache) addToBloomFilter(tableName string, primaryKeys []string) {
+	v, ok := c.bloomGuards.Load(tableName)
+	if !ok || len(primaryKeys) == 0 {
+		return
+	}
+	guard := v.(*bloomGuard)
+	guard.mu.Lock()
+	defer guard.mu.Unlock()
+	for _, filter := range []*bloomFilter{guard.filter, guard.building} {
+		if filter == nil {
+			continue
+		}
+		for _, primaryKey := range primaryKeys {
+			filter.add(primaryKey)
+		}
+	}
+}
+
+// dropBloomFilter drops the filter of the table after rows were written that it can't tell, e.g. by a raw
+// statement, its lookups are let through until the filter is built again
+func (c *Gorm2Cache) dropBloomFilter(tableName string) {
+	v, ok := c.bloomGuards.Load(tableName)
+	if !ok {
+		return
+	}
+	guard := v.(*bloomGuard)
+	guard.mu.Lock()
+	defer guard.mu.Unlock()
+	guard.filter = nil
+	guard.generation++
+}
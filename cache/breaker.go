@@ -0,0 +1,122 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/joykk/gorm-cache/storage"
+)
+
+const defaultCircuitBreakerCooldown = 5000
+
+// circuitBreaker stops queries from using the storage once Config.CircuitBreakerThreshold storage operations
+// failed in a row, until the cooldown elapses. A query is then let through to probe the storage, one per
+// cooldown, until a storage operation succeeds and closes the breaker again
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int       // storage operations failed in a row
+	open      bool      // whether the breaker is open
+	openUntil time.Time // when the next query may probe the storage, while the breaker is open
+	trips     uint64    // how many times the breaker opened
+}
+
+func newCircuitBreaker(threshold int, cooldown int64) *circuitBreaker {
+	if cooldown <= 0 {
+		cooldown = defaultCircuitBreakerCooldown
+	}
+	return &circuitBreaker{threshold: threshold, cooldown: time.Duration(cooldown) * time.Millisecond}
+}
+
+// allow reports whether a query may use the storage, letting one through per cooldown while the breaker is open
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.open {
+		return true
+	}
+	now := time.Now()
+	if now.Before(b.openUntil) {
+		return false
+	}
+	// the probe may not reach the storage, the next one comes after another cooldown either way
+	b.openUntil = now.Add(b.cooldown)
+	return true
+}
+
+// record counts the outcome of a storage operation, returning whether it opened or closed the breaker
+func (b *circuitBreaker) record(err error) (changed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		b.failures = 0
+		changed = b.open
+		b.open = false
+		return changed
+	}
+	b.failures++
+	if b.open || b.failures < b.threshold {
+		return false
+	}
+	b.open = true
+	b.openUntil = time.Now().Add(b.cooldown)
+	b.trips++
+	return true
+}
+
+func (b *circuitBreaker) state() (open bool, trips uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.open, b.trips
+}
+
+// storageAvailable reports whether queries may use the storage, false while Config.CircuitBreakerThreshold
+// storage failures in a row keep the breaker open
+func (c *Gorm2Cache) storageAvailable() bool {
+	return c.breaker == nil || c.breaker.allow()
+}
+
+// recordStorageResult feeds the outcome of a storage operation to the circuit breaker. Misses are successes,
+// and operations canceled by their caller say nothing about the storage
+func (c *Gorm2Cache) recordStorageResult(ctx context.Context, err error) {
+	if c.breaker == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+	if errors.Is(err, storage.ErrCacheNotFound) {
+		err = nil
+	}
+	if !c.breaker.record(err) {
+		return
+	}
+	open, _ := c.breaker.state()
+	if open {
+		c.Logger.CtxError(ctx, "[circuitBreaker] %d storage operations failed in a row, queries bypass the cache "+
+			"for %v: %v", c.breaker.threshold, c.breaker.cooldown, err)
+	} else {
+		c.Logger.CtxInfo(ctx, "[circuitBreaker] storage recovered, queries use the cache again")
+	}
+	c.observeCircuitBreak(ctx, open)
+}
+
+// CircuitOpen reports whether queries bypass the cache, the storage having failed
+// Config.CircuitBreakerThreshold times in a row. Always false without CircuitBreakerThreshold
+func (c *Gorm2Cache) CircuitOpen() bool {
+	if c.breaker == nil {
+		return false
+	}
+	open, _ := c.breaker.state()
+	return open
+}
+
+// CircuitTripCount returns how many times the circuit breaker opened
+func (c *Gorm2Cache) CircuitTripCount() uint64 {
+	if c.breaker == nil {
+		return 0
+	}
+	_, trips := c.breaker.state()
+	return trips
+}
@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+
+	"github.com/hashicorp/go-multierror"
+
+	"github.com/joykk/gorm-cache/storage"
+)
+
+// broadcast publishes an invalidation of this instance to the others, failing to do so is only logged
+// as the local invalidation still runs
+func (c *Gorm2Cache) broadcast(ctx context.Context, invalidation storage.Invalidation) {
+	if c.Config.Broadcaster == nil {
+		return
+	}
+	invalidation.Source = c.broadcastSource
+	if invalidation.Kind != storage.InvalidateTenant {
+		invalidation.Tenant = c.tenant(ctx)
+	}
+	if err := c.Config.Broadcaster.Publish(ctx, invalidation); err != nil {
+		c.Logger.CtxError(ctx, "[broadcast] publish invalidation of table %s error: %v", invalidation.Table, err)
+	}
+}
+
+// newBroadcastSource returns the random token an instance sets as the Source of its broadcasts. The InstanceId
+// can't tell the instances apart: the processes sharing a remote tier share their InstanceId as well
+func newBroadcastSource() string {
+	random := make([]byte, 8)
+	if _, err := crand.Read(random); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(random)
+}
+
+func (c *Gorm2Cache) subscribe() error {
+	unsubscribe, err := c.Config.Broadcaster.Subscribe(context.Background(), c.applyInvalidation)
+	if err != nil {
+		return err
+	}
+	c.unsubscribe = unsubscribe
+	return nil
+}
+
+// applyInvalidation runs an invalidation broadcast by another instance on the keys of this one
+func (c *Gorm2Cache) applyInvalidation(invalidation storage.Invalidation) {
+	if invalidation.Source == c.broadcastSource {
+		return
+	}
+	ctx := context.Background()
+	if invalidation.Tenant != "" {
+		ctx = ContextWithTenant(ctx, invalidation.Tenant)
+	}
+	var err error
+	switch invalidation.Kind {
+	case storage.InvalidateSearch:
+		err = c.invalidateSearchCache(ctx, invalidation.Table)
+	case storage.InvalidatePrimaryKeys:
+		// the keys may be those of rows the other instance created
+		c.addToBloomFilter(invalidation.Table, invalidation.PrimaryKeys)
+		err = c.batchInvalidatePrimaryCache(ctx, invalidation.Table, invalidation.PrimaryKeys)
+	case storage.InvalidateAllPrimary:
+		err = c.invalidateAllPrimaryCache(ctx, invalidation.Table)
+	case storage.InvalidateSearchDependents:
+		err = c.invalidateSearchDependents(ctx, invalidation.Table, invalidation.PrimaryKeys)
+	case storage.InvalidateTenant:
+		err = c.invalidateTenant(ctx, invalidation.Tenant)
+	case storage.InvalidateSearchQuery:
+		err = c.invalidateSearchKey(ctx, invalidation.Table,
+			c.keys.SearchCachePrefix(c.instanceId(ctx), invalidation.Table)+":"+invalidation.Query)
+	}
+	if err != nil {
+		c.Logger.CtxError(ctx, "[applyInvalidation] invalidate table %s from %s error: %v",
+			invalidation.Table, invalidation.Source, err)
+	}
+}
+
+// Close waits for the cache writes queued by Config.AsyncWrite, stops refreshing the keys of
+// Config.HotKeyRefresh, stops applying the invalidations broadcast by other instances and closes the storages
+// implementing storage.Closer, e.g. saving a memory store to its snapshot file
+func (c *Gorm2Cache) Close() error {
+	if c.writer != nil {
+		c.writer.close()
+	}
+	if c.hotKeys != nil {
+		c.hotKeys.close()
+	}
+	var err error
+	if c.unsubscribe != nil {
+		err = c.unsubscribe()
+	}
+	if closeErr := c.closeStorages(); closeErr != nil {
+		err = multierror.Append(err, closeErr)
+	}
+	return err
+}
+
+func (c *Gorm2Cache) closeStorages() error {
+	var err error
+	closeStore := func(store storage.DataStorage) {
+		if closer, ok := store.(storage.Closer); ok {
+			if closeErr := closer.Close(); closeErr != nil {
+				err = multierror.Append(err, closeErr)
+			}
+		}
+	}
+	closeStore(c.cache)
+	c.shards.Range(func(_, value interface{}) bool {
+		if s := value.(*shard); s.err == nil {
+			closeStore(s.store)
+		}
+		return true
+	})
+	return err
+}
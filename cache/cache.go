@@ -2,6 +2,14 @@ package cache
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
 	"github.com/joykk/gorm-cache/config"
 	"github.com/joykk/gorm-cache/storage"
 	"github.com/joykk/gorm-cache/util"
@@ -26,6 +34,8 @@ type Cache interface {
 	AttachToDB(db *gorm.DB)
 
 	ResetCache() error
+	Ping(ctx context.Context) error
+	HealthCheck(ctx context.Context) (HealthStatus, error)
 	StatsAccessor
 }
 
@@ -34,9 +44,46 @@ type Gorm2Cache struct {
 	Logger     util.LoggerInterface
 	InstanceId string
 
-	db       *gorm.DB
-	cache    storage.DataStorage
-	hitCount int64
+	eventLogger util.StructuredLogger // the Logger if it logs the events of Config.EventLogLevels, else nil
+
+	db         *gorm.DB
+	cache      storage.DataStorage
+	serializer *versionedSerializer
+	hitCount   int64
+
+	keys            util.Keys // builds the keys of the instance with Config.KeyGenerator
+	primaryCacheKey util.PrimaryKeyFunc
+	searchCacheKey  util.SearchKeyFunc
+
+	tagMu        sync.Mutex
+	dependencyMu sync.Mutex // guards the index of Config.PreciseSearchInvalidation
+
+	primaryFlight Group
+	searchFlight  Group // the loads shared by Config.EnableSingleFlight
+
+	tables sync.Map // names of the tables cached so far, for diagnostics
+	shards sync.Map // storages returned by Config.ShardRouter, to *shard
+
+	unsubscribe     func() error // stops applying the invalidations of Config.Broadcaster
+	broadcastSource string       // random token set as the Source of the broadcasts of the instance
+
+	prefetchSlots chan struct{}
+	prefetching   sync.Map // search keys of the hits whose prefetch is running
+	revalidating  sync.Map // search keys of the stale hits whose revalidation is running
+
+	verifySlots chan struct{} // bounds the verifications of Config.Verifier
+
+	hotKeys *hotKeyRefresher // refreshes the hottest search keys of Config.HotKeyRefresh, nil if off
+
+	bloomGuards sync.Map // table name to the *bloomGuard of Config.BloomFilter
+
+	searchDebounce sync.Map // debounceKey to the unix ns Config.InvalidationDebounce of its table ends at, *int64
+
+	writer *asyncWriter // runs the cache writes of Config.AsyncWrite
+
+	breaker *circuitBreaker // bypasses the storage after Config.CircuitBreakerThreshold failures, nil if 0
+
+	consecutiveErrors int64 // storage operations failed in a row, for Config.StorageErrorHandler
 
 	*stats
 }
@@ -46,21 +93,57 @@ func (c *Gorm2Cache) Name() string {
 }
 
 func (c *Gorm2Cache) Initialize(db *gorm.DB) (err error) {
-	err = db.Callback().Create().After("gorm:create").Register("gorm:cache:after_create", c.AfterCreate(c))
+	c.db = db
+
+	// a failed invalidation can only fail the write while its transaction is still open
+	commit := ""
+	if c.Config.OnInvalidationFailure == config.InvalidationFailureFailWrite {
+		commit = "gorm:commit_or_rollback_transaction"
+	}
+
+	afterCreate, afterDelete, afterUpdate, afterRaw := c.AfterCreate(c), c.AfterDelete(c), c.AfterUpdate(c), c.AfterRaw(c)
+	if c.invalidateOnCommit() {
+		// transactions begun through the pool buffer the invalidations of their writes until they commit
+		pool := &txPool{ConnPool: db.ConnPool}
+		if db.Statement.ConnPool == db.ConnPool {
+			db.Statement.ConnPool = pool
+		}
+		db.ConnPool = pool
+		afterCreate, afterDelete = c.afterCommit(afterCreate), c.afterCommit(afterDelete)
+		afterUpdate, afterRaw = c.afterCommit(afterUpdate), c.afterCommit(afterRaw)
+	}
+
+	err = db.Callback().Create().After("gorm:create").Before(commit).Register("gorm:cache:after_create", afterCreate)
+	if err != nil {
+		return err
+	}
+
+	err = db.Callback().Delete().After("gorm:delete").Before(commit).Register("gorm:cache:after_delete", afterDelete)
 	if err != nil {
 		return err
 	}
 
-	err = db.Callback().Delete().After("gorm:delete").Register("gorm:cache:after_delete", c.AfterDelete(c))
+	err = db.Callback().Update().After("gorm:update").Before(commit).Register("gorm:cache:after_update", afterUpdate)
 	if err != nil {
 		return err
 	}
 
-	err = db.Callback().Update().After("gorm:update").Register("gorm:cache:after_update", c.AfterUpdate(c))
+	err = db.Callback().Raw().After("gorm:raw").Register("gorm:cache:after_raw", afterRaw)
 	if err != nil {
 		return err
 	}
 
+	if c.Config.DirtyMarkTTL > 0 {
+		err = db.Callback().Update().Before("gorm:update").Register("gorm:cache:before_update", c.markDirty)
+		if err != nil {
+			return err
+		}
+		err = db.Callback().Delete().Before("gorm:delete").Register("gorm:cache:before_delete", c.markDirty)
+		if err != nil {
+			return err
+		}
+	}
+
 	err = newQueryHandler(c).Bind(db)
 	if err != nil {
 		return err
@@ -74,36 +157,172 @@ func (c *Gorm2Cache) AttachToDB(db *gorm.DB) {
 }
 
 func (c *Gorm2Cache) Init() error {
-	c.InstanceId = util.GenInstanceId()
+	c.InstanceId = c.Config.InstanceId
+	if c.InstanceId == "" {
+		c.InstanceId = util.GenInstanceId()
+	}
+	if c.Config.Database != "" {
+		// not ":", storages deleting keys by prefix (e.g. memcached) count on the segments of the keys
+		c.InstanceId += "." + c.Config.Database
+	}
 
 	if c.Config.CacheStorage != nil {
 		c.cache = c.Config.CacheStorage
 	} else {
 		c.cache = storage.NewMem(storage.DefaultMemStoreConfig)
 	}
+	c.cache = c.wrapStorage(c.cache)
+
+	if c.Config.Serializer != nil {
+		c.serializer = newVersionedSerializer(c.Config.Serializer, c.Config.CacheVersion)
+	} else {
+		c.serializer = newVersionedSerializer(jsonSerializer{}, c.Config.CacheVersion)
+	}
+
+	c.keys = util.NewKeys(c.Config.KeyGenerator)
+	if c.Config.SQLNormalizer != nil {
+		c.keys.Normalizer = c.Config.SQLNormalizer
+	}
+	c.primaryCacheKey = c.keys.PrimaryCacheKey
+	if c.Config.PrimaryKeyFunc != nil {
+		c.primaryCacheKey = c.Config.PrimaryKeyFunc
+	}
+	c.searchCacheKey = c.keys.SearchCacheKey
+	if c.Config.SearchKeyFunc != nil {
+		c.searchCacheKey = c.Config.SearchKeyFunc
+	}
 
 	if c.Config.DebugLogger == nil {
 		c.Config.DebugLogger = &util.DefaultLogger{}
 	}
 	c.Logger = c.Config.DebugLogger
 	c.Logger.SetIsDebug(c.Config.DebugMode)
+	c.eventLogger, _ = c.Logger.(util.StructuredLogger)
+
+	prefetchConcurrency := c.Config.PrefetchConcurrency
+	if prefetchConcurrency <= 0 {
+		prefetchConcurrency = 1
+	}
+	c.prefetchSlots = make(chan struct{}, prefetchConcurrency)
+	verifyConcurrency := c.Config.Verifier.Concurrency
+	if verifyConcurrency <= 0 {
+		verifyConcurrency = 1
+	}
+	c.verifySlots = make(chan struct{}, verifyConcurrency)
+
+	if c.Config.AsyncWrite {
+		c.writer = newAsyncWriter(c.Config.AsyncWriteWorkers, c.Config.AsyncWriteQueueSize)
+	}
+	if c.Config.CircuitBreakerThreshold > 0 {
+		c.breaker = newCircuitBreaker(c.Config.CircuitBreakerThreshold, c.Config.CircuitBreakerCooldown)
+	}
+
+	err := c.cache.Init(c.storageConfig())
+	if err != nil {
+		c.Logger.CtxError(context.Background(), "[Init] cache init error: %v", err)
+		return err
+	}
+
+	if c.Config.Broadcaster != nil {
+		c.broadcastSource = newBroadcastSource()
+		if err = c.subscribe(); err != nil {
+			c.Logger.CtxError(context.Background(), "[Init] subscribe to invalidations error: %v", err)
+			return err
+		}
+	}
 
-	err := c.cache.Init(&storage.Config{
+	if c.Config.PublishExpvar {
+		if err = c.publishExpvar(); err != nil {
+			c.Logger.CtxError(context.Background(), "[Init] publish expvar error: %v", err)
+			return err
+		}
+	}
+
+	if c.Config.HotKeyRefresh.TopN > 0 {
+		c.hotKeys = newHotKeyRefresher(c.Config.HotKeyRefresh.TopN, c.Config.HotKeyRefresh.Interval)
+		go c.runHotKeyRefresh()
+	}
+	return nil
+}
+
+func (c *Gorm2Cache) storageConfig() *storage.Config {
+	return &storage.Config{
 		TTL:    c.Config.CacheTTL,
+		Jitter: c.Config.TTLJitter,
 		Debug:  c.Config.DebugMode,
 		Logger: c.Logger,
+	}
+}
+
+// wrapStorage applies the configured value chunking, encryption and compression to a storage
+func (c *Gorm2Cache) wrapStorage(s storage.DataStorage) storage.DataStorage {
+	if c.Config.ChunkSize > 0 {
+		// chunks are cut from the values as stored, compressed and encrypted
+		s = storage.NewChunked(&storage.ChunkedStoreConfig{Storage: s, ChunkSize: c.Config.ChunkSize})
+	}
+	if keys := c.encryptionKeys(); keys != nil {
+		s = storage.NewEncrypted(&storage.EncryptedStoreConfig{Storage: s, Keys: keys})
+	}
+	if c.Config.Compression == "" {
+		return s
+	}
+	// compressed values are encrypted, encrypted ones wouldn't compress
+	return storage.NewCompressed(&storage.CompressedStoreConfig{
+		Storage:   s,
+		Codec:     c.Config.Compression,
+		Threshold: c.Config.CompressionThreshold,
 	})
-	if err != nil {
-		c.Logger.CtxError(context.Background(), "[Init] cache init error: %v", err)
-		return err
+}
+
+func (c *Gorm2Cache) encryptionKeys() storage.KeyProvider {
+	if c.Config.EncryptionKeyProvider != nil {
+		return c.Config.EncryptionKeyProvider
+	}
+	if c.Config.EncryptionKey != nil {
+		return storage.StaticKey(c.Config.EncryptionKey)
 	}
 	return nil
 }
 
+// shard is a storage returned by Config.ShardRouter, wrapped and initialized on first use
+type shard struct {
+	once  sync.Once
+	store storage.DataStorage
+	err   error
+}
+
+// storageFor returns the storage serving ctx, picked by Config.ShardRouter if set
+func (c *Gorm2Cache) storageFor(ctx context.Context) storage.DataStorage {
+	if c.Config.ShardRouter == nil {
+		return c.cache
+	}
+	store := c.Config.ShardRouter(ctx)
+	if store == nil {
+		return c.cache
+	}
+	v, _ := c.shards.LoadOrStore(store, &shard{})
+	s := v.(*shard)
+	s.once.Do(func() {
+		s.store = c.wrapStorage(store)
+		s.err = s.store.Init(c.storageConfig())
+		if s.err != nil {
+			c.Logger.CtxError(ctx, "[storageFor] shard storage init error: %v", s.err)
+		}
+	})
+	return s.store
+}
+
+// ResetCache cleans the default storage and every shard storage used so far
 func (c *Gorm2Cache) ResetCache() error {
 	c.stats.ResetHitCount()
 	ctx := context.Background()
 	err := c.cache.CleanCache(ctx)
+	c.shards.Range(func(_, value interface{}) bool {
+		if shardErr := value.(*shard).store.CleanCache(ctx); shardErr != nil {
+			err = multierror.Append(err, shardErr)
+		}
+		return true
+	})
 	if err != nil {
 		c.Logger.CtxError(ctx, "[ResetCache] reset cache error: %v", err)
 		return err
@@ -111,66 +330,513 @@ func (c *Gorm2Cache) ResetCache() error {
 	return nil
 }
 
+// Ping checks that the default storage and every shard storage used so far are reachable, within the
+// deadline of ctx, e.g. for a readiness probe: queries still fall back to the database while they aren't
+func (c *Gorm2Cache) Ping(ctx context.Context) error {
+	err := c.cache.Ping(ctx)
+	c.shards.Range(func(_, value interface{}) bool {
+		s := value.(*shard)
+		shardErr := s.err
+		if shardErr == nil {
+			shardErr = s.store.Ping(ctx)
+		}
+		if shardErr != nil {
+			err = multierror.Append(err, shardErr)
+		}
+		return true
+	})
+	return err
+}
+
+// readContext bounds a cache read by Config.ReadTimeout
+func (c *Gorm2Cache) readContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, c.readTimeout())
+}
+
+// writeContext bounds a cache population by Config.WriteTimeout
+func (c *Gorm2Cache) writeContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.Config.WriteTimeout > 0 {
+		return withTimeout(ctx, c.Config.WriteTimeout)
+	}
+	return withTimeout(ctx, c.Config.StorageTimeout)
+}
+
+// storageContext bounds any other storage operation, e.g. an invalidation, by Config.StorageTimeout
+func (c *Gorm2Cache) storageContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, c.Config.StorageTimeout)
+}
+
+func (c *Gorm2Cache) readTimeout() int64 {
+	if c.Config.ReadTimeout > 0 {
+		return c.Config.ReadTimeout
+	}
+	return c.Config.StorageTimeout
+}
+
+func withTimeout(ctx context.Context, timeout int64) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, time.Duration(timeout)*time.Millisecond)
+}
+
+// countError counts failed storage operations, a cache miss is not a failure
+func (c *Gorm2Cache) countError(ctx context.Context, err error) error {
+	c.recordStorageResult(ctx, err)
+	if err == nil || errors.Is(err, storage.ErrCacheNotFound) {
+		atomic.StoreInt64(&c.consecutiveErrors, 0)
+		return err
+	}
+	c.IncrErrorCount()
+	c.observeError(ctx, err)
+	if errors.Is(err, context.Canceled) {
+		// given up by the caller, it tells nothing about the storage
+		return err
+	}
+	consecutive := atomic.AddInt64(&c.consecutiveErrors, 1)
+	if handler := c.Config.StorageErrorHandler; handler != nil {
+		c.runHook(ctx, "StorageErrorHandler", func() { handler(ctx, err, consecutive) })
+	}
+	return err
+}
+
 func (c *Gorm2Cache) InvalidateSearchCache(ctx context.Context, tableName string) error {
-	return c.cache.DeleteKeysWithPrefix(ctx, util.GenSearchCachePrefix(c.InstanceId, tableName))
+	c.broadcast(ctx, storage.Invalidation{Kind: storage.InvalidateSearch, Table: tableName})
+	return c.invalidateSearchCache(ctx, tableName)
+}
+
+func (c *Gorm2Cache) invalidateSearchCache(ctx context.Context, tableName string) error {
+	if c.debounceSearchInvalidation(ctx, tableName) {
+		c.Logger.CtxInfo(ctx, "[invalidateSearchCache] search cache of table %s invalidated recently, skipped", tableName)
+		return nil
+	}
+	c.searchFlight.forgetTable(tableName)
+	ctx, span := c.startSpan(ctx, spanSearchInvalidate, tableName)
+	c.IncrInvalidationCount()
+	prefix := c.keys.SearchCachePrefix(c.instanceId(ctx), tableName)
+	var err error
+	if c.versionedSearch() {
+		_, err = c.bumpSearchVersion(ctx, tableName)
+	} else {
+		deleteCtx, cancel := c.storageContext(ctx)
+		err = c.countError(ctx, c.storageFor(deleteCtx).DeleteKeysWithPrefix(deleteCtx, prefix))
+		cancel()
+	}
+	endSpan(span, err)
+	if err == nil {
+		c.countTableInvalidation(tableName, kindSearch)
+		c.observeInvalidation(ctx, tableName, prefix+":")
+	}
+	return err
+}
+
+// InvalidateSearchCacheByQuery drops the search cache entry of a single query of the table, keyed by its sql and
+// vars as SetSearchCache keys it, e.g. when application code knows which list view a write changed. Entries of
+// queries keyed along with tags, preloads or joined tables aren't matched, InvalidateSearchCache drops them all
+func (c *Gorm2Cache) InvalidateSearchCacheByQuery(ctx context.Context, tableName string, sql string, vars ...interface{}) error {
+	versioned, err := c.versionedSQL(ctx, []string{tableName}, sql)
+	if err != nil {
+		return err
+	}
+	key := c.searchCacheKey(c.instanceId(ctx), tableName, versioned, vars...)
+	prefix := c.keys.SearchCachePrefix(c.instanceId(ctx), tableName) + ":"
+	if query := strings.TrimPrefix(key, prefix); query != key && !c.versionedSearch() {
+		c.broadcast(ctx, storage.Invalidation{Kind: storage.InvalidateSearchQuery, Table: tableName, Query: query})
+	} else {
+		// the other instances can't build the key under their own prefix or search versions
+		c.broadcast(ctx, storage.Invalidation{Kind: storage.InvalidateSearch, Table: tableName})
+	}
+	return c.invalidateSearchKey(ctx, tableName, key)
+}
+
+func (c *Gorm2Cache) invalidateSearchKey(ctx context.Context, tableName string, key string) error {
+	ctx, span := c.startSpan(ctx, spanSearchInvalidate, tableName)
+	c.IncrInvalidationCount()
+	deleteCtx, cancel := c.storageContext(ctx)
+	err := c.countError(ctx, c.storageFor(deleteCtx).DeleteKey(deleteCtx, key))
+	cancel()
+	endSpan(span, err)
+	if err == nil {
+		c.countTableInvalidation(tableName, kindSearch)
+		c.observeInvalidation(ctx, tableName, key)
+	}
+	return err
 }
 
 func (c *Gorm2Cache) InvalidatePrimaryCache(ctx context.Context, tableName string, primaryKey string) error {
-	return c.cache.DeleteKey(ctx, util.GenPrimaryCacheKey(c.InstanceId, tableName, primaryKey))
+	c.broadcast(ctx, storage.Invalidation{Kind: storage.InvalidatePrimaryKeys, Table: tableName, PrimaryKeys: []string{primaryKey}})
+	ctx, span := c.startSpan(ctx, spanPrimaryInvalidate, tableName)
+	span.SetAttribute("gorm-cache.keys", 1)
+	c.IncrInvalidationCount()
+	cacheKey := c.primaryCacheKey(c.instanceId(ctx), tableName, primaryKey)
+	c.bumpPrimaryVersion(ctx, tableName, primaryKey)
+	deleteCtx, cancel := c.storageContext(ctx)
+	err := c.countError(ctx, c.storageFor(deleteCtx).DeleteKey(deleteCtx, cacheKey))
+	cancel()
+	endSpan(span, err)
+	if err == nil {
+		c.countTableInvalidation(tableName, kindPrimary)
+		c.observeInvalidation(ctx, tableName, cacheKey)
+	}
+	return err
 }
 
 func (c *Gorm2Cache) BatchInvalidatePrimaryCache(ctx context.Context, tableName string, primaryKeys []string) error {
+	c.broadcast(ctx, storage.Invalidation{Kind: storage.InvalidatePrimaryKeys, Table: tableName, PrimaryKeys: primaryKeys})
+	return c.batchInvalidatePrimaryCache(ctx, tableName, primaryKeys)
+}
+
+func (c *Gorm2Cache) batchInvalidatePrimaryCache(ctx context.Context, tableName string, primaryKeys []string) error {
 	cacheKeys := make([]string, 0, len(primaryKeys))
 	for _, primaryKey := range primaryKeys {
-		cacheKeys = append(cacheKeys, util.GenPrimaryCacheKey(c.InstanceId, tableName, primaryKey))
+		cacheKeys = append(cacheKeys, c.primaryCacheKey(c.instanceId(ctx), tableName, primaryKey))
+	}
+	ctx, span := c.startSpan(ctx, spanPrimaryInvalidate, tableName)
+	span.SetAttribute("gorm-cache.keys", len(cacheKeys))
+	c.IncrInvalidationCount()
+	c.bumpPrimaryVersion(ctx, tableName, primaryKeys...)
+	deleteCtx, cancel := c.storageContext(ctx)
+	var err error
+	for _, chunk := range util.Chunk(cacheKeys, c.Config.BatchSize) {
+		if err = c.countError(ctx, c.storageFor(deleteCtx).BatchDeleteKeys(deleteCtx, chunk)); err != nil {
+			break
+		}
+	}
+	cancel()
+	endSpan(span, err)
+	if err == nil {
+		c.countTableInvalidation(tableName, kindPrimary)
+		c.observeInvalidation(ctx, tableName, cacheKeys...)
 	}
-	return c.cache.BatchDeleteKeys(ctx, cacheKeys)
+	return err
 }
 
 func (c *Gorm2Cache) InvalidateAllPrimaryCache(ctx context.Context, tableName string) error {
-	return c.cache.DeleteKeysWithPrefix(ctx, util.GenPrimaryCachePrefix(c.InstanceId, tableName))
+	c.broadcast(ctx, storage.Invalidation{Kind: storage.InvalidateAllPrimary, Table: tableName})
+	return c.invalidateAllPrimaryCache(ctx, tableName)
+}
+
+func (c *Gorm2Cache) invalidateAllPrimaryCache(ctx context.Context, tableName string) error {
+	ctx, span := c.startSpan(ctx, spanPrimaryInvalidate, tableName)
+	span.SetAttribute("gorm-cache.all", true)
+	c.IncrInvalidationCount()
+	prefix := c.keys.PrimaryCachePrefix(c.instanceId(ctx), tableName)
+	c.bumpPrimaryVersion(ctx, tableName)
+	// rows may have been inserted without going through gorm, e.g. before InvalidateTable
+	c.dropBloomFilter(tableName)
+	deleteCtx, cancel := c.storageContext(ctx)
+	err := c.countError(ctx, c.storageFor(deleteCtx).DeleteKeysWithPrefix(deleteCtx, prefix))
+	cancel()
+	endSpan(span, err)
+	if err == nil {
+		c.countTableInvalidation(tableName, kindPrimary)
+		c.observeInvalidation(ctx, tableName, prefix+":")
+	}
+	return err
+}
+
+// EvictionCount returns how many entries the storage evicted to make room for new ones,
+// 0 if it doesn't implement storage.EvictionCounter
+func (c *Gorm2Cache) EvictionCount() uint64 {
+	if counter, ok := c.cache.(storage.EvictionCounter); ok {
+		return counter.EvictionCount()
+	}
+	return 0
+}
+
+// CountPrimaryCache returns how many primary cache entries of a table are stored, e.g. to diagnose
+// memory bloat or check an invalidation. It fails with util.ErrNotCountable if the storage doesn't
+// implement storage.KeyCounter
+func (c *Gorm2Cache) CountPrimaryCache(ctx context.Context, tableName string) (int64, error) {
+	return c.countKeys(ctx, c.keys.PrimaryCachePrefix(c.instanceId(ctx), tableName))
+}
+
+// CountSearchCache returns how many search cache entries of a table are stored, as CountPrimaryCache
+func (c *Gorm2Cache) CountSearchCache(ctx context.Context, tableName string) (int64, error) {
+	return c.countKeys(ctx, c.keys.SearchCachePrefix(c.instanceId(ctx), tableName))
+}
+
+func (c *Gorm2Cache) countKeys(ctx context.Context, prefix string) (int64, error) {
+	counter, ok := c.storageFor(ctx).(storage.KeyCounter)
+	if !ok {
+		return 0, util.ErrNotCountable
+	}
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+	count, err := counter.CountKeysWithPrefix(ctx, prefix+":")
+	return count, c.countError(ctx, err)
+}
+
+// InvalidateTable drops the search and primary cache of a table, e.g. after a write gorm can't
+// attribute to it, like a raw statement whose table isn't recognized
+func (c *Gorm2Cache) InvalidateTable(ctx context.Context, tableName string) error {
+	var result error
+	if err := c.InvalidateSearchCache(ctx, tableName); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if err := c.InvalidateAllPrimaryCache(ctx, tableName); err != nil {
+		result = multierror.Append(result, err)
+	}
+	return result
 }
 
 func (c *Gorm2Cache) BatchPrimaryKeyExists(ctx context.Context, tableName string, primaryKeys []string) (bool, error) {
 	cacheKeys := make([]string, 0, len(primaryKeys))
 	for _, primaryKey := range primaryKeys {
-		cacheKeys = append(cacheKeys, util.GenPrimaryCacheKey(c.InstanceId, tableName, primaryKey))
+		cacheKeys = append(cacheKeys, c.primaryCacheKey(c.instanceId(ctx), tableName, primaryKey))
 	}
-	return c.cache.BatchKeyExist(ctx, cacheKeys)
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+	exists, err := c.storageFor(ctx).BatchKeyExist(ctx, cacheKeys)
+	return exists, c.countError(ctx, err)
 }
 
 func (c *Gorm2Cache) SearchKeyExists(ctx context.Context, tableName string, SQL string, vars ...interface{}) (bool, error) {
-	cacheKey := util.GenSearchCacheKey(c.InstanceId, tableName, SQL, vars...)
-	return c.cache.KeyExists(ctx, cacheKey)
+	cacheKey := c.searchCacheKey(c.instanceId(ctx), tableName, SQL, vars...)
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+	exists, err := c.storageFor(ctx).KeyExists(ctx, cacheKey)
+	return exists, c.countError(ctx, err)
 }
 
+// BatchSetPrimaryKeyCache caches the objects of kvs by primary key, kvs without a ttl use the ttl of the table.
+// Under config.PrimaryCacheVersioning, objects of rows written since the version carried by ctx are skipped
 func (c *Gorm2Cache) BatchSetPrimaryKeyCache(ctx context.Context, tableName string, kvs []util.Kv) error {
+	c.tables.Store(tableName, struct{}{})
+	version, versioned := primaryVersionFrom(ctx)
+	versioner := c.primaryVersioner(ctx)
+	var versionKeys [][]string
+	if versioned && versioner != nil {
+		versionKeys = make([][]string, 0, len(kvs))
+	}
 	for idx, kv := range kvs {
-		kvs[idx].Key = util.GenPrimaryCacheKey(c.InstanceId, tableName, kv.Key)
+		if versionKeys != nil {
+			versionKeys = append(versionKeys, c.primaryVersionKeys(ctx, tableName, kv.Key))
+		}
+		kvs[idx].Key = c.primaryCacheKey(c.instanceId(ctx), tableName, kv.Key)
+		if kv.TTL <= 0 {
+			kvs[idx].TTL = c.tableTTL(tableName)
+		}
+	}
+	ctx, span := c.startSpan(ctx, spanPrimarySet, tableName)
+	span.SetAttribute("gorm-cache.keys", len(kvs))
+	ctx, cancel := c.writeContext(ctx)
+	defer cancel()
+	var err error
+	for start, chunk := range util.Chunk(kvs, c.Config.BatchSize) {
+		if versionKeys != nil {
+			chunk, err = c.setPrimaryKeysIfVersion(ctx, versioner, chunk, versionKeys[start*c.Config.BatchSize:], version)
+		} else {
+			err = c.storageFor(ctx).BatchSetKeys(ctx, chunk)
+		}
+		if err = c.countError(ctx, err); err != nil {
+			break
+		}
+		c.countTableSets(tableName, kindPrimary, len(chunk))
+		if c.Config.Hooks.OnSet != nil {
+			keys := make([]string, 0, len(chunk))
+			for _, kv := range chunk {
+				keys = append(keys, kv.Key)
+			}
+			c.observeSet(ctx, tableName, keys...)
+		}
 	}
-	return c.cache.BatchSetKeys(ctx, kvs)
+	endSpan(span, err)
+	return err
 }
 
 func (c *Gorm2Cache) SetSearchCache(ctx context.Context, cacheValue string, tableName string,
 	sql string, vars ...interface{}) error {
-	key := util.GenSearchCacheKey(c.InstanceId, tableName, sql, vars...)
-	return c.cache.SetKey(ctx, util.Kv{
+	return c.SetSearchCacheWithTTL(ctx, cacheValue, 0, tableName, sql, vars...)
+}
+
+// SetSearchCacheWithTTL caches a search result for ttl ms, 0 uses the TableTTL of the table or CacheTTL
+func (c *Gorm2Cache) SetSearchCacheWithTTL(ctx context.Context, cacheValue string, ttl int64, tableName string,
+	sql string, vars ...interface{}) error {
+	c.tables.Store(tableName, struct{}{})
+	if ttl <= 0 {
+		ttl = c.tableTTL(tableName)
+	}
+	key := c.searchCacheKey(c.instanceId(ctx), tableName, sql, vars...)
+	ctx, span := c.startSpan(ctx, spanSearchSet, tableName)
+	ctx, cancel := c.writeContext(ctx)
+	defer cancel()
+	err := c.countError(ctx, c.storageFor(ctx).SetKey(ctx, util.Kv{
 		Key:   key,
 		Value: cacheValue,
-	})
+		TTL:   ttl,
+	}))
+	if err == nil {
+		c.countTableSets(tableName, kindSearch, 1)
+		c.observeSet(ctx, tableName, key)
+	}
+	endSpan(span, err)
+	return err
 }
 
 func (c *Gorm2Cache) GetSearchCache(ctx context.Context, tableName string, sql string, vars ...interface{}) (string, error) {
-	key := util.GenSearchCacheKey(c.InstanceId, tableName, sql, vars...)
-	return c.cache.GetValue(ctx, key)
+	key := c.searchCacheKey(c.instanceId(ctx), tableName, sql, vars...)
+	ctx, span := c.startSpan(ctx, spanSearchGet, tableName)
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+	value, err := c.storageFor(ctx).GetValue(ctx, key)
+	err = c.countError(ctx, err)
+	span.SetAttribute("gorm-cache.hit", err == nil)
+	endSpan(span, err)
+	return value, err
 }
 
 func (c *Gorm2Cache) BatchGetPrimaryCache(ctx context.Context, tableName string, primaryKeys []string) ([]string, error) {
 	cacheKeys := make([]string, 0, len(primaryKeys))
 	for _, primaryKey := range primaryKeys {
-		cacheKeys = append(cacheKeys, util.GenPrimaryCacheKey(c.InstanceId, tableName, primaryKey))
+		cacheKeys = append(cacheKeys, c.primaryCacheKey(c.instanceId(ctx), tableName, primaryKey))
+	}
+	ctx, span := c.startSpan(ctx, spanPrimaryGet, tableName)
+	span.SetAttribute("gorm-cache.keys", len(cacheKeys))
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+	values, err := c.batchGetValues(ctx, cacheKeys)
+	err = c.countError(ctx, err)
+	span.SetAttribute("gorm-cache.hit", err == nil && len(values) == len(cacheKeys) && !util.ContainString("", values))
+	endSpan(span, err)
+	return values, err
+}
+
+// WarmPrimaryCache caches records, a model struct or a slice of them (or of pointers to them), in the primary
+// cache of tableName as a query loading them would, e.g. to preload hot rows on startup. The table of the
+// model is used if tableName is empty. Records with a zero primary key are skipped.
+func (c *Gorm2Cache) WarmPrimaryCache(ctx context.Context, tableName string, records interface{}) error {
+	if c.db == nil {
+		return util.ErrNotAttached
+	}
+	db := c.db.Session(&gorm.Session{NewDB: true, Context: ctx})
+	db.Statement.Dest = records
+	if err := db.Statement.Parse(records); err != nil {
+		return err
+	}
+	if c.isKeylessModel(db) {
+		return fmt.Errorf("model %s has no primary key to cache it by", db.Statement.Schema.Name)
+	}
+	if tableName == "" {
+		tableName = db.Statement.Schema.Table
+	}
+
+	primaryKeys, objects := c.getObjectsAfterLoad(db)
+	kvs := make([]util.Kv, 0, len(objects))
+	for i, object := range objects {
+		value, err := c.serializer.Marshal(object)
+		if err != nil {
+			return err
+		}
+		kvs = append(kvs, util.Kv{Key: primaryKeys[i], Value: string(value)})
+	}
+	if len(kvs) == 0 {
+		return nil
 	}
-	return c.cache.BatchGetValues(ctx, cacheKeys)
+	return c.BatchSetPrimaryKeyCache(ctx, tableName, kvs)
+}
+
+// Warm runs queries to cache their results as they would be on a miss, in the primary and search caches
+// alike, e.g. to preload hot queries on startup so that the first requests don't all hit the database.
+// Each query is built and run by its func on a new session of the attached db, e.g.
+//
+//	func(db *gorm.DB) *gorm.DB { return db.Where("status = ?", "active").Find(&[]User{}) }
+//
+// Warm-ups skip the cache read, results already cached are replaced. The errors of all queries are returned.
+func (c *Gorm2Cache) Warm(ctx context.Context, queries ...func(db *gorm.DB) *gorm.DB) error {
+	if c.db == nil {
+		return util.ErrNotAttached
+	}
+	var result error
+	for _, query := range queries {
+		db := c.db.Session(&gorm.Session{NewDB: true, Context: ctx}).Set(reloadKey, true)
+		// a record not found is cached as well
+		if tx := query(db); tx != nil && tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			result = multierror.Append(result, tx.Error)
+		}
+	}
+	return result
+}
+
+// batchGetValues reads keys in chunks of at most Config.BatchSize, returning the values in the order of keys
+func (c *Gorm2Cache) batchGetValues(ctx context.Context, keys []string) ([]string, error) {
+	chunks := util.Chunk(keys, c.Config.BatchSize)
+	if len(chunks) == 1 {
+		return c.storageFor(ctx).BatchGetValues(ctx, keys)
+	}
+	values := make([]string, 0, len(keys))
+	for _, chunk := range chunks {
+		chunkValues, err := c.storageFor(ctx).BatchGetValues(ctx, chunk)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, chunkValues...)
+	}
+	return values, nil
+}
+
+// GetOrSetPrimary returns the primary cache value of primaryKey in tableName.
+// On a miss, loader is invoked once for all concurrent callers of the same key,
+// and its result is cached before being returned to every one of them.
+// While the circuit breaker is open, loader is invoked right away and its result isn't cached.
+func (c *Gorm2Cache) GetOrSetPrimary(ctx context.Context, tableName string, primaryKey string,
+	loader func() (string, error)) (string, error) {
+	if !c.storageAvailable() {
+		return loader()
+	}
+	store := c.storageFor(ctx)
+	cacheKey := c.primaryCacheKey(c.instanceId(ctx), tableName, primaryKey)
+	value, err := store.GetValue(ctx, cacheKey)
+	// a cached "record not found" is left to the loader, the key may have been created by other means
+	if err == nil && value == recordNotFound {
+		err = storage.ErrCacheNotFound
+	}
+	if err == nil {
+		c.countHit(hitPrimary)
+		c.countTableLookup(tableName, kindPrimary, true)
+		c.observeLookup(ctx, tableName, true, func() string { return cacheKey })
+		return value, nil
+	}
+	if !errors.Is(err, storage.ErrCacheNotFound) {
+		_ = c.countError(ctx, err)
+		c.Logger.CtxError(ctx, "[GetOrSetPrimary] get primary cache for key %s error: %v", cacheKey, err)
+	}
+	c.IncrMissCount()
+	c.countTableLookup(tableName, kindPrimary, false)
+	c.observeLookup(ctx, tableName, false, func() string { return cacheKey })
+
+	// flights are per storage, shards must not share loaded values
+	flightKey := fmt.Sprintf("%p:%s", store, cacheKey)
+	v, err, _ := c.primaryFlight.Do(flightKey, func() (interface{}, error) {
+		// the value may have been cached by a flight that just finished
+		if value, err := store.GetValue(ctx, cacheKey); err == nil && value != recordNotFound {
+			return value, nil
+		}
+		version, versioned := c.readPrimaryVersion(ctx, tableName)
+		value, err := loader()
+		if err != nil {
+			return "", err
+		}
+		c.tables.Store(tableName, struct{}{})
+		kvs := []util.Kv{{Key: cacheKey, Value: value, TTL: c.tableTTL(tableName)}}
+		if versioner, ok := store.(storage.Versioner); ok && versioned {
+			kvs, err = c.setPrimaryKeysIfVersion(ctx, versioner, kvs,
+				[][]string{c.primaryVersionKeys(ctx, tableName, primaryKey)}, version)
+		} else {
+			err = store.SetKey(ctx, kvs[0])
+		}
+		if err = c.countError(ctx, err); err != nil {
+			c.Logger.CtxError(ctx, "[GetOrSetPrimary] set primary cache for key %s error: %v", cacheKey, err)
+		} else if len(kvs) > 0 {
+			c.countTableSets(tableName, kindPrimary, 1)
+			c.observeSet(ctx, tableName, cacheKey)
+		}
+		return value, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return v.(string), nil
 }
 
 const InstanceCacheType = "InstanceCacheType"
@@ -182,11 +848,10 @@ func UseCache(db *gorm.DB) *gorm.DB {
 	return db
 }
 
-// DisableCache
-// Deprecated: 设置本次查询不使用缓存 单次逻辑有BUG，设置无效，当Update是禁用cache时，Update不会清理过期的缓存，会导致脏读。
+// DisableCache 设置本次查询不使用缓存，与 NoCache 相同：只作用于查询，写入仍会清理缓存，不会导致脏读。
+// Deprecated: 使用 NoCache
 func DisableCache(db *gorm.DB) *gorm.DB {
-	//return db.Set(InstanceCacheType, -1)
-	return db
+	return NoCache(db)
 }
 
 func (c *Gorm2Cache) ShouldCache(_ *gorm.DB, tableName string) bool {
@@ -202,11 +867,11 @@ func (c *Gorm2Cache) ShouldCache(_ *gorm.DB, tableName string) bool {
 	//	}
 	//	// 其它cache走配置
 	//}
+	if matchTable(tableName, c.Config.DisableTables) {
+		return false
+	}
 	if len(c.Config.Tables) == 0 {
 		return true
 	}
-	if util.ContainString(tableName, c.Config.DisableTables) {
-		return false
-	}
-	return util.ContainString(tableName, c.Config.Tables)
+	return matchTable(tableName, c.Config.Tables)
 }
@@ -0,0 +1,21 @@
+package cache
+
+import "gorm.io/gorm"
+
+const cacheOnlyKey = "gorm:cache:cache_only"
+
+// CacheOnly returns a session whose queries are answered from the cache only.
+// On a cache miss the database is not queried, the dest is left untouched
+// and the query fails with util.ErrCacheOnlyMiss.
+func CacheOnly(db *gorm.DB) *gorm.DB {
+	return db.Set(cacheOnlyKey, true).Session(&gorm.Session{})
+}
+
+func isCacheOnly(db *gorm.DB) bool {
+	if val, ok := db.Get(cacheOnlyKey); ok {
+		if cacheOnly, _ := val.(bool); cacheOnly {
+			return true
+		}
+	}
+	return contextSettings(db).CacheOnly
+}
@@ -0,0 +1,42 @@
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/joykk/gorm-cache/storage"
+)
+
+// debounceKey a table of a storage and tenant, shards and tenants are invalidated on their own
+type debounceKey struct {
+	store  storage.DataStorage
+	tenant string
+	table  string
+}
+
+// debounceSearchInvalidation restarts the Config.InvalidationDebounce window of the table, reporting whether
+// the invalidation of its search cache can be skipped: the previous one ran within the window, and nothing was
+// search cached since
+func (c *Gorm2Cache) debounceSearchInvalidation(ctx context.Context, tableName string) bool {
+	if c.Config.InvalidationDebounce <= 0 {
+		return false
+	}
+	now := time.Now().UnixNano()
+	end := now + c.Config.InvalidationDebounce*int64(time.Millisecond)
+	v, loaded := c.searchDebounce.LoadOrStore(debounceKey{store: c.storageFor(ctx), tenant: c.tenant(ctx), table: tableName}, &end)
+	if !loaded {
+		return false
+	}
+	return atomic.SwapInt64(v.(*int64), end) > now
+}
+
+// searchCacheSuspended reports whether results of the table aren't search cached, its search cache being
+// invalidated within the Config.InvalidationDebounce window
+func (c *Gorm2Cache) searchCacheSuspended(ctx context.Context, tableName string) bool {
+	if c.Config.InvalidationDebounce <= 0 {
+		return false
+	}
+	v, ok := c.searchDebounce.Load(debounceKey{store: c.storageFor(ctx), tenant: c.tenant(ctx), table: tableName})
+	return ok && atomic.LoadInt64(v.(*int64)) > time.Now().UnixNano()
+}
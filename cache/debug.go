@@ -0,0 +1,149 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/joykk/gorm-cache/storage"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// debugBundleMaxTables caps the tables sampled into a debug bundle
+const debugBundleMaxTables = 100
+
+// DebugBundle is the diagnostic report attached to bug reports, it never carries cached values
+type DebugBundle struct {
+	GeneratedAt time.Time       `json:"generatedAt"`
+	Config      *ConfigSnapshot `json:"config"`
+	Stats       StatsSnapshot   `json:"stats"`
+	Inventory   Inventory       `json:"inventory"`
+}
+
+type StatsSnapshot struct {
+	HitCount          uint64    `json:"hitCount"`
+	HitCounts         HitCounts `json:"hitCounts"`
+	MissCount         uint64    `json:"missCount"`
+	LookupCount       uint64    `json:"lookupCount"`
+	HitRate           float64   `json:"hitRate"`
+	InvalidationCount uint64    `json:"invalidationCount"`
+	ErrorCount        uint64    `json:"errorCount"`
+	DroppedWriteCount uint64    `json:"droppedWriteCount"`
+	SkippedTooLarge   uint64    `json:"skippedTooLarge"`
+	ShadowDivergence  uint64    `json:"shadowDivergence"`
+	VerifiedCount     uint64    `json:"verifiedCount"`
+	VerifyDivergence  uint64    `json:"verifyDivergence"`
+	LockedBypasses    uint64    `json:"lockedBypasses"`
+	WriteQueueDepth   int       `json:"writeQueueDepth"`
+	CircuitOpen       bool      `json:"circuitOpen"`
+	CircuitTripCount  uint64    `json:"circuitTripCount"`
+	EvictionCount     uint64    `json:"evictionCount"`
+
+	// SingleFlightInFlight the queries sharing each single flight load in progress, see Gorm2Cache.SingleFlightInFlight
+	SingleFlightInFlight map[string]int `json:"singleFlightInFlight,omitempty"`
+
+	Tables map[string]TableStats `json:"tables"` // the counts of each table cached or invalidated so far
+}
+
+// Inventory samples the tables cached so far, key counts are only
+// reported when the storage implements storage.KeyCounter
+type Inventory struct {
+	Countable bool             `json:"countable"`
+	Truncated bool             `json:"truncated"`
+	Tables    []TableInventory `json:"tables"`
+}
+
+type TableInventory struct {
+	Table         string `json:"table"`
+	PrimaryPrefix string `json:"primaryPrefix"`
+	SearchPrefix  string `json:"searchPrefix"`
+	PrimaryCount  *int64 `json:"primaryCount,omitempty"`
+	SearchCount   *int64 `json:"searchCount,omitempty"`
+	CountError    string `json:"countError,omitempty"`
+}
+
+// DebugBundle packages the redacted config, the stats and a sample of the cache inventory
+// into a JSON document, so that maintainers can diagnose a setup without guessing it.
+func (c *Gorm2Cache) DebugBundle(ctx context.Context) (io.Reader, error) {
+	bundle := &DebugBundle{
+		GeneratedAt: time.Now(),
+		Config:      c.ConfigSnapshot(),
+		Stats:       c.StatsSnapshot(),
+		Inventory:   c.inventory(ctx),
+	}
+	// cached values are encoded with the gormCache tag key, the bundle follows the json tags
+	data, err := jsoniter.ConfigCompatibleWithStandardLibrary.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		c.Logger.CtxError(ctx, "[DebugBundle] marshal debug bundle error: %v", err)
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+// StatsSnapshot returns the counts of the cache, in total and for each table, to be logged or exported
+func (c *Gorm2Cache) StatsSnapshot() StatsSnapshot {
+	return StatsSnapshot{
+		HitCount:          c.HitCount(),
+		HitCounts:         c.HitCounts(),
+		MissCount:         c.MissCount(),
+		LookupCount:       c.LookupCount(),
+		HitRate:           c.HitRate(),
+		InvalidationCount: c.InvalidationCount(),
+		ErrorCount:        c.ErrorCount(),
+		DroppedWriteCount: c.DroppedWriteCount(),
+		SkippedTooLarge:   c.SkippedTooLargeCount(),
+		ShadowDivergence:  c.ShadowDivergenceCount(),
+		VerifiedCount:     c.VerifiedCount(),
+		VerifyDivergence:  c.VerifyDivergenceCount(),
+		LockedBypasses:    c.LockedReadBypassCount(),
+		WriteQueueDepth:   c.WriteQueueDepth(),
+		CircuitOpen:       c.CircuitOpen(),
+		CircuitTripCount:  c.CircuitTripCount(),
+		EvictionCount:     c.EvictionCount(),
+
+		SingleFlightInFlight: c.SingleFlightInFlight(),
+		Tables:               c.tableStats(),
+	}
+}
+
+func (c *Gorm2Cache) inventory(ctx context.Context) Inventory {
+	tableNames := make([]string, 0)
+	c.tables.Range(func(key, _ interface{}) bool {
+		tableNames = append(tableNames, key.(string))
+		return true
+	})
+	sort.Strings(tableNames)
+
+	counter, countable := c.storageFor(ctx).(storage.KeyCounter)
+	inventory := Inventory{
+		Countable: countable,
+		Tables:    make([]TableInventory, 0, len(tableNames)),
+	}
+	if len(tableNames) > debugBundleMaxTables {
+		tableNames = tableNames[:debugBundleMaxTables]
+		inventory.Truncated = true
+	}
+	for _, tableName := range tableNames {
+		table := TableInventory{
+			Table:         tableName,
+			PrimaryPrefix: c.keys.PrimaryCachePrefix(c.instanceId(ctx), tableName),
+			SearchPrefix:  c.keys.SearchCachePrefix(c.instanceId(ctx), tableName),
+		}
+		if countable {
+			primaryCount, err := counter.CountKeysWithPrefix(ctx, table.PrimaryPrefix+":")
+			if err == nil {
+				var searchCount int64
+				searchCount, err = counter.CountKeysWithPrefix(ctx, table.SearchPrefix+":")
+				table.PrimaryCount, table.SearchCount = &primaryCount, &searchCount
+			}
+			if err != nil {
+				table.PrimaryCount, table.SearchCount = nil, nil
+				table.CountError = err.Error()
+			}
+		}
+		inventory.Tables = append(inventory.Tables, table)
+	}
+	return inventory
+}
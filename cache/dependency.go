@@ -0,0 +1,218 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+)
+
+// searchDependencies an index of Config.PreciseSearchInvalidation: the search cache keys of the entries
+// containing a row, to the unix ms they expire at, 0 if they don't
+type searchDependencies map[string]int64
+
+func (c *Gorm2Cache) preciseSearchInvalidation() bool {
+	return c.Config.PreciseSearchInvalidation && !c.Config.RefreshTTLOnHit
+}
+
+// dependencyKeys returns the keys of the indexes of the rows of the primary keys, the index of the entries
+// whose rows aren't known without primary keys
+func (c *Gorm2Cache) dependencyKeys(ctx context.Context, tableName string, primaryKeys []string) []string {
+	return c.indexKeys(ctx, tableName, primaryKeys, c.keys.SearchDependencyKey)
+}
+
+// dependencySetKeys returns the keys of the indexes of dependencyKeys kept as sets
+func (c *Gorm2Cache) dependencySetKeys(ctx context.Context, tableName string, primaryKeys []string) []string {
+	return c.indexKeys(ctx, tableName, primaryKeys, c.keys.SearchDependencySetKey)
+}
+
+func (c *Gorm2Cache) indexKeys(ctx context.Context, tableName string, primaryKeys []string,
+	key func(instanceId string, tableName string, primaryKey string) string) []string {
+	if len(primaryKeys) == 0 {
+		return []string{key(c.instanceId(ctx), tableName, "")}
+	}
+	keys := make([]string, 0, len(primaryKeys))
+	for _, primaryKey := range primaryKeys {
+		keys = append(keys, key(c.instanceId(ctx), tableName, primaryKey))
+	}
+	return keys
+}
+
+func (c *Gorm2Cache) getSearchDependencies(ctx context.Context, indexKeys []string) ([]searchDependencies, error) {
+	indexes := make([]searchDependencies, 0, len(indexKeys))
+	for _, chunk := range util.Chunk(indexKeys, c.Config.BatchSize) {
+		values, err := c.storageFor(ctx).BatchGetValues(ctx, chunk)
+		if err != nil {
+			return nil, err
+		}
+		for _, value := range values {
+			index := make(searchDependencies)
+			if value != "" {
+				if err = json.Unmarshal([]byte(value), &index); err != nil {
+					return nil, err
+				}
+			}
+			indexes = append(indexes, index)
+		}
+	}
+	return indexes, nil
+}
+
+// indexSearchDependencies records the search cache entry of sql, cached for ttl ms, in the index of each of
+// the rows of its result. Entries of unknown rows, primaryKeys nil, are recorded in the index of the table.
+// An entry failing to be indexed is dropped, as a write of its rows wouldn't invalidate it.
+func (c *Gorm2Cache) indexSearchDependencies(ctx context.Context, tableName string, sql string, vars []interface{},
+	ttl int64, primaryKeys []string) error {
+	if !c.preciseSearchInvalidation() {
+		return nil
+	}
+	cacheKey := c.searchCacheKey(c.instanceId(ctx), tableName, sql, vars...)
+	err := c.addSearchDependencies(ctx, tableName, cacheKey, ttl, primaryKeys)
+	if err == nil {
+		return nil
+	}
+	c.Logger.CtxError(ctx, "[indexSearchDependencies] index search cache for sql %s error: %v", sql, err)
+	return c.countError(ctx, c.storageFor(ctx).DeleteKey(ctx, cacheKey))
+}
+
+// addSearchDependencies adds cacheKey to the indexes of the rows. Storages implementing storage.SetStore keep
+// them as sets added to atomically, so that the instances sharing the storage don't lose each other's entries.
+// The others keep them as values rewritten under the lock of the instance, which only holds in a single process
+func (c *Gorm2Cache) addSearchDependencies(ctx context.Context, tableName string, cacheKey string, ttl int64,
+	primaryKeys []string) error {
+	ttl = c.entryTTL(tableName, ttl)
+	if setStore, ok := c.storageFor(ctx).(storage.SetStore); ok {
+		for _, setKey := range c.dependencySetKeys(ctx, tableName, primaryKeys) {
+			if err := c.countError(ctx, setStore.AddToSet(ctx, setKey, []string{cacheKey}, ttl)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	now := time.Now().UnixMilli()
+	expireAt := int64(0)
+	if ttl > 0 {
+		expireAt = now + ttl
+	}
+	indexKeys := c.dependencyKeys(ctx, tableName, primaryKeys)
+
+	c.dependencyMu.Lock()
+	defer c.dependencyMu.Unlock()
+
+	indexes, err := c.getSearchDependencies(ctx, indexKeys)
+	if err != nil {
+		return c.countError(ctx, err)
+	}
+	kvs := make([]util.Kv, 0, len(indexKeys))
+	for i, index := range indexes {
+		index[cacheKey] = expireAt
+		indexTTL := pruneIndex(index, now)
+		data, err := json.Marshal(index)
+		if err != nil {
+			return err
+		}
+		kvs = append(kvs, util.Kv{Key: indexKeys[i], Value: string(data), TTL: indexTTL})
+	}
+	for _, chunk := range util.Chunk(kvs, c.Config.BatchSize) {
+		if err = c.countError(ctx, c.storageFor(ctx).BatchSetKeys(ctx, chunk)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneIndex drops the expired entries of an index of cache keys to the unix ms they expire at (0 for never),
+// and returns the ttl in ms the index is stored with: it lives as long as its last entry, 0 for forever
+func pruneIndex(index map[string]int64, now int64) int64 {
+	indexTTL := int64(0)
+	for key, at := range index {
+		if at == 0 {
+			indexTTL = -1
+			continue
+		}
+		if at <= now {
+			delete(index, key)
+		} else if indexTTL >= 0 && at-now > indexTTL {
+			indexTTL = at - now
+		}
+	}
+	if indexTTL < 0 {
+		return 0
+	}
+	return indexTTL
+}
+
+// InvalidateSearchDependents drops the search cache entries of the table containing the rows of the
+// primary keys, along with those whose rows aren't known. It drops the whole search cache of the table
+// like InvalidateSearchCache without Config.PreciseSearchInvalidation, primary keys or a readable index.
+func (c *Gorm2Cache) InvalidateSearchDependents(ctx context.Context, tableName string, primaryKeys []string) error {
+	if !c.preciseSearchInvalidation() || len(primaryKeys) == 0 {
+		return c.InvalidateSearchCache(ctx, tableName)
+	}
+	c.broadcast(ctx, storage.Invalidation{Kind: storage.InvalidateSearchDependents, Table: tableName, PrimaryKeys: primaryKeys})
+	return c.invalidateSearchDependents(ctx, tableName, primaryKeys)
+}
+
+func (c *Gorm2Cache) invalidateSearchDependents(ctx context.Context, tableName string, primaryKeys []string) error {
+	if !c.preciseSearchInvalidation() || len(primaryKeys) == 0 {
+		return c.invalidateSearchCache(ctx, tableName)
+	}
+	// entries whose rows aren't known may contain any row
+	indexKeys := append(c.dependencyKeys(ctx, tableName, primaryKeys), c.dependencyKeys(ctx, tableName, nil)...)
+	setStore, sets := c.storageFor(ctx).(storage.SetStore)
+	if !sets {
+		c.dependencyMu.Lock()
+		defer c.dependencyMu.Unlock()
+	}
+
+	searchKeys := make([]string, 0)
+	seen := make(map[string]struct{})
+	add := func(key string) {
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			searchKeys = append(searchKeys, key)
+		}
+	}
+	// indexes kept as values are read on set stores too, they may have been written by a wrapper of the store
+	indexes, err := c.getSearchDependencies(ctx, indexKeys)
+	for _, index := range indexes {
+		for key := range index {
+			add(key)
+		}
+	}
+	if err == nil && sets {
+		setKeys := append(c.dependencySetKeys(ctx, tableName, primaryKeys), c.dependencySetKeys(ctx, tableName, nil)...)
+		for _, setKey := range setKeys {
+			var members []string
+			if members, err = setStore.SetMembers(ctx, setKey); err != nil {
+				break
+			}
+			for _, key := range members {
+				add(key)
+			}
+		}
+		indexKeys = append(indexKeys, setKeys...)
+	}
+	if err != nil {
+		c.Logger.CtxError(ctx, "[invalidateSearchDependents] read search dependencies of table %s error: %v, "+
+			"invalidating its whole search cache", tableName, c.countError(ctx, err))
+		return c.invalidateSearchCache(ctx, tableName)
+	}
+
+	ctx, span := c.startSpan(ctx, spanSearchInvalidate, tableName)
+	span.SetAttribute("gorm-cache.keys", len(searchKeys))
+	c.IncrInvalidationCount()
+	for _, chunk := range util.Chunk(append(searchKeys, indexKeys...), c.Config.BatchSize) {
+		if err = c.countError(ctx, c.storageFor(ctx).BatchDeleteKeys(ctx, chunk)); err != nil {
+			break
+		}
+	}
+	endSpan(span, err)
+	if err == nil {
+		c.countTableInvalidation(tableName, kindSearch)
+		c.observeInvalidation(ctx, tableName, searchKeys...)
+	}
+	return err
+}
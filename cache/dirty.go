@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"reflect"
+
+	"github.com/joykk/gorm-cache/util"
+	"gorm.io/gorm"
+)
+
+const dirtyKeysKey = "gorm:cache:dirty_keys"
+
+// dirtyMark the value of the marks set by markDirty, only their presence matters
+const dirtyMark = "1"
+
+// markDirty marks the primary keys of the rows an update or delete is about to write, see Config.DirtyMarkTTL.
+// It runs before the write, the keys marked are kept on the statement for clearDirtyMarks
+func (c *Gorm2Cache) markDirty(db *gorm.DB) {
+	if db.Error != nil {
+		return
+	}
+	tableName := getTableName(db)
+	if !c.ShouldCache(db, tableName) || c.isKeylessModel(db) {
+		return
+	}
+	primaryKeys := c.dirtyPrimaryKeys(db)
+	if len(primaryKeys) == 0 {
+		return
+	}
+	ctx := db.Statement.Context
+	kvs := make([]util.Kv, 0, len(primaryKeys))
+	for _, primaryKey := range primaryKeys {
+		kvs = append(kvs, util.Kv{
+			Key:   c.keys.DirtyMarkKey(c.instanceId(ctx), tableName, primaryKey),
+			Value: dirtyMark,
+			TTL:   c.Config.DirtyMarkTTL,
+		})
+	}
+	// an unmarked write is only exposed to the race the marks close, it isn't failed
+	if err := c.countError(ctx, c.storageFor(ctx).BatchSetKeys(ctx, kvs)); err != nil {
+		c.Logger.CtxError(ctx, "[markDirty] mark primary keys %v of table %s error: %v", primaryKeys, tableName, err)
+		return
+	}
+	db.InstanceSet(dirtyKeysKey, kvs)
+}
+
+// dirtyPrimaryKeys returns the primary keys of the rows a write is about to write: those of its WHERE clause,
+// of its model, which gorm only turns into conditions when running the write, and those an update assigns
+func (c *Gorm2Cache) dirtyPrimaryKeys(db *gorm.DB) []string {
+	primaryKeys := c.getPrimaryKeysFromWhereClause(db)
+	fields := c.primaryFields(db)
+	values := make([]reflect.Value, 0)
+	switch db.Statement.ReflectValue.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < db.Statement.ReflectValue.Len(); i++ {
+			values = append(values, db.Statement.ReflectValue.Index(i))
+		}
+	case reflect.Struct:
+		values = append(values, db.Statement.ReflectValue)
+	}
+	for _, value := range values {
+		value = reflect.Indirect(value)
+		if value.Kind() != reflect.Struct {
+			continue
+		}
+		parts := make([]interface{}, 0, len(fields))
+		allZero := true
+		for _, field := range fields {
+			part, isZero := field.ValueOf(db.Statement.Context, value)
+			parts = append(parts, part)
+			allZero = allZero && isZero
+		}
+		if !allZero {
+			primaryKeys = append(primaryKeys, util.JoinPrimaryKey(parts...))
+		}
+	}
+	if assigned, ok := c.assignedPrimaryKeys(db); ok {
+		primaryKeys = append(primaryKeys, assigned...)
+	}
+	return uniqueStringSlice(primaryKeys)
+}
+
+// clearDirtyMarks clears the marks set by markDirty once the write invalidated the cache. Marks of a write
+// failing to invalidate are left to expire, the rows cached may still be stale until then
+func (c *Gorm2Cache) clearDirtyMarks(db *gorm.DB, failures *invalidationFailures) {
+	marked, ok := db.InstanceGet(dirtyKeysKey)
+	if !ok {
+		return
+	}
+	failures.mu.Lock()
+	failed := failures.err != nil
+	failures.mu.Unlock()
+	if failed {
+		return
+	}
+	ctx := db.Statement.Context
+	kvs := marked.([]util.Kv)
+	keys := make([]string, 0, len(kvs))
+	for _, kv := range kvs {
+		keys = append(keys, kv.Key)
+	}
+	if err := c.countError(ctx, c.storageFor(ctx).BatchDeleteKeys(ctx, keys)); err != nil {
+		c.Logger.CtxError(ctx, "[clearDirtyMarks] clear marks %v error: %v", keys, err)
+	}
+}
+
+// isDirty reports whether a lookup by primary key reads rows marked by a write still running, see
+// Config.DirtyMarkTTL. Marks that can't be read are taken as set
+func (c *Gorm2Cache) isDirty(db *gorm.DB, tableName string) bool {
+	if c.Config.DirtyMarkTTL <= 0 || c.isKeylessModel(db) || !c.ShouldCache(db, tableName) {
+		return false
+	}
+	primaryKeys := c.getPrimaryKeysFromWhereClause(db)
+	if len(primaryKeys) == 0 {
+		return false
+	}
+	keys := make([]string, 0, len(primaryKeys))
+	for _, primaryKey := range primaryKeys {
+		keys = append(keys, c.keys.DirtyMarkKey(c.instanceId(db.Statement.Context), tableName, primaryKey))
+	}
+	ctx, cancel := c.readContext(db.Statement.Context)
+	defer cancel()
+	values, err := c.batchGetValues(ctx, keys)
+	if c.countError(ctx, err) != nil {
+		c.Logger.CtxError(ctx, "[isDirty] get marks of primary keys %v error: %v", primaryKeys, err)
+		return true
+	}
+	for _, value := range values {
+		if value != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultDistributedSingleFlightWait = 1000
+	// distributedSingleFlightPoll how often a query waiting on the load of another instance reads the search cache
+	distributedSingleFlightPoll = 20 * time.Millisecond
+
+	distributedLockKey = "gorm:cache:distributed_lock"
+)
+
+// distributedLock the lock of Config.DistributedSingleFlight held by a query loading from the database
+type distributedLock struct {
+	locker storage.Locker
+	key    string
+	token  string
+}
+
+// locker returns the storage locking the loads of Config.DistributedSingleFlight, nil if there is none
+func (c *Gorm2Cache) locker(ctx context.Context) storage.Locker {
+	if !c.Config.DistributedSingleFlight {
+		return nil
+	}
+	locker, _ := c.storageFor(ctx).(storage.Locker)
+	return locker
+}
+
+func (c *Gorm2Cache) distributedSingleFlightWait() int64 {
+	if c.Config.DistributedSingleFlightWait > 0 {
+		return c.Config.DistributedSingleFlightWait
+	}
+	return defaultDistributedSingleFlightWait
+}
+
+// awaitDistributedLoad takes the lock of loading the query missing the search cache, or waits for the
+// instance holding it to cache the result, reporting whether trySearchCache served it. The query loads
+// from the database when the lock is taken, the wait times out or the storage fails.
+func (c *Gorm2Cache) awaitDistributedLoad(db *gorm.DB, tableName string, sql string, trySearchCache func() bool) bool {
+	ctx := db.Statement.Context
+	locker := c.locker(ctx)
+	if locker == nil {
+		return false
+	}
+	wait := c.distributedSingleFlightWait()
+	key := c.keys.SingleFlightLockKey(c.instanceId(ctx), tableName, c.searchCacheKey(c.instanceId(ctx), tableName, sql, db.Statement.Vars...))
+	token := util.GenInstanceId()
+	deadline := time.Now().Add(time.Duration(wait) * time.Millisecond)
+	for {
+		locked, err := locker.TryLock(ctx, key, token, wait)
+		if c.countError(ctx, err) != nil {
+			c.Logger.CtxError(ctx, "[awaitDistributedLoad] lock sql %s error: %v, loading alone", sql, err)
+			return false
+		}
+		if locked {
+			db.InstanceSet(distributedLockKey, &distributedLock{locker: locker, key: key, token: token})
+			return false
+		}
+		if !time.Now().Before(deadline) {
+			c.Logger.CtxInfo(ctx, "[awaitDistributedLoad] waiting on the load of sql %s timed out, loading alone", sql)
+			return false
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(distributedSingleFlightPoll):
+		}
+		if trySearchCache() {
+			return true
+		}
+		if db.Error != nil {
+			return false
+		}
+	}
+}
+
+// releaseDistributedLock releases the lock the query took to load from the database, if any
+func (c *Gorm2Cache) releaseDistributedLock(db *gorm.DB) {
+	val, ok := db.InstanceGet(distributedLockKey)
+	if !ok {
+		return
+	}
+	lock := val.(*distributedLock)
+	ctx := db.Statement.Context
+	if err := c.countError(ctx, lock.locker.Unlock(detachedContext{ctx}, lock.key, lock.token)); err != nil {
+		c.Logger.CtxError(ctx, "[releaseDistributedLock] unlock %s error: %v", lock.key, err)
+	}
+}
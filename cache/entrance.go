@@ -6,12 +6,25 @@ import (
 	"github.com/joykk/gorm-cache/config"
 )
 
+// NewGorm2Cache returns a plugin caching the queries of the db it is used by. Each plugin is independent of the
+// others of the process: it keeps a copy of cacheConfig, its own stats, and keys namespaced by its InstanceId,
+// e.g. one for the main database and one for an analytics one, configured from the same base config:
+//
+//	mainCache, _ := cache.NewGorm2Cache(conf)
+//	analyticsConf := *conf
+//	analyticsConf.Database, analyticsConf.CacheStorage = "analytics", storage.NewRedis(...)
+//	analyticsCache, _ := cache.NewGorm2Cache(&analyticsConf)
+//
+// The storage of the config is shared as is, caches sharing it tell their keys apart by their InstanceId,
+// and the ttl the storage was first initialized with applies to all of them
 func NewGorm2Cache(cacheConfig *config.CacheConfig) (Cache, error) {
 	if cacheConfig == nil {
 		return nil, fmt.Errorf("you pass a nil config")
 	}
+	// defaults filled in by Init don't leak into the config of the caller, nor into the other caches built from it
+	conf := *cacheConfig
 	cache := &Gorm2Cache{
-		Config: cacheConfig,
+		Config: &conf,
 		stats:  &stats{},
 	}
 	err := cache.Init()
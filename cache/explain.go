@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	explainKey       = "gorm:cache:explain"
+	explainReportKey = "gorm:cache:explain_report"
+)
+
+// Explain returns a session whose queries report each decision the cache made about them, retrieved by
+// GetExplain on the result of the query, e.g. to find out why a query is never served from the cache
+func Explain(db *gorm.DB) *gorm.DB {
+	return db.Set(explainKey, true).Session(&gorm.Session{})
+}
+
+// GetExplain returns the report of a query run on a session of Explain, nil for other queries
+//
+//	tx := cache.Explain(db).Where("id = ?", 1).First(&user)
+//	fmt.Println(cache.GetExplain(tx))
+func GetExplain(db *gorm.DB) *ExplainReport {
+	return explainReport(db)
+}
+
+// ExplainReport the decisions the cache made about a query, in the order it made them
+type ExplainReport struct {
+	Table       string   `json:"table"`
+	ShouldCache bool     `json:"shouldCache"` // whether the table is cached, see Tables and DisableTables
+	Key         string   `json:"key"`         // the search cache key of the query, empty if the cache was bypassed
+	Outcome     string   `json:"outcome"`     // how the cache served the query, e.g. primary_hit, search_hit or miss
+	Skipped     []string `json:"skipped"`     // why the cache was not read or the result not cached
+	Decisions   []string `json:"decisions"`
+}
+
+func (r *ExplainReport) String() string {
+	if r == nil {
+		return ""
+	}
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "table %s: %s", r.Table, r.Outcome)
+	for _, decision := range r.Decisions {
+		b.WriteString("\n  - ")
+		b.WriteString(decision)
+	}
+	return b.String()
+}
+
+// decide records a decision, reports are nil for queries not explained
+func (r *ExplainReport) decide(format string, args ...interface{}) {
+	if r == nil {
+		return
+	}
+	r.Decisions = append(r.Decisions, fmt.Sprintf(format, args...))
+}
+
+// skip records why a cache was not read or written
+func (r *ExplainReport) skip(format string, args ...interface{}) {
+	if r == nil {
+		return
+	}
+	reason := fmt.Sprintf(format, args...)
+	r.Skipped = append(r.Skipped, reason)
+	r.Decisions = append(r.Decisions, "skipped: "+reason)
+}
+
+func isExplained(db *gorm.DB) bool {
+	if val, ok := db.Get(explainKey); ok {
+		explain, _ := val.(bool)
+		return explain
+	}
+	return false
+}
+
+func explainReport(db *gorm.DB) *ExplainReport {
+	if report, ok := db.InstanceGet(explainReportKey); ok {
+		return report.(*ExplainReport)
+	}
+	return nil
+}
+
+// startExplain attaches a report to the statement of an explained query, nil for other queries
+func (c *Gorm2Cache) startExplain(db *gorm.DB, tableName string) *ExplainReport {
+	if !isExplained(db) {
+		return nil
+	}
+	report := &ExplainReport{Table: tableName, ShouldCache: c.ShouldCache(db, tableName)}
+	switch {
+	case matchTable(tableName, c.Config.DisableTables):
+		report.skip("table %s is denied by DisableTables", tableName)
+	case len(c.Config.Tables) > 0 && !matchTable(tableName, c.Config.Tables):
+		report.skip("table %s is not allowed by Tables", tableName)
+	default:
+		report.decide("table %s is cached, primary cache %s, search cache %s", tableName,
+			enabledName(c.primaryCacheEnabled(tableName)), enabledName(c.searchCacheEnabled(tableName)))
+	}
+	db.InstanceSet(explainReportKey, report)
+	return report
+}
+
+func enabledName(enabled bool) string {
+	if enabled {
+		return "enabled"
+	}
+	return "disabled"
+}
@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+)
+
+// ExpvarName is the expvar map holding the counters of every cache publishing them
+const ExpvarName = "gorm-cache"
+
+var expvarMu sync.Mutex
+
+// getExpvarMap returns the gorm-cache map, publishing it on first use, expvarMu must be held
+func getExpvarMap() (*expvar.Map, error) {
+	v := expvar.Get(ExpvarName)
+	if v == nil {
+		return expvar.NewMap(ExpvarName), nil
+	}
+	m, ok := v.(*expvar.Map)
+	if !ok {
+		return nil, fmt.Errorf("expvar %s is already published as %T", ExpvarName, v)
+	}
+	return m, nil
+}
+
+// publishExpvar publishes the counters of c as gorm-cache.<instanceId>.{hits,misses,invalidations,errors}
+func (c *Gorm2Cache) publishExpvar() error {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+
+	m, err := getExpvarMap()
+	if err != nil {
+		return err
+	}
+	if m.Get(c.InstanceId) != nil {
+		return fmt.Errorf("expvar %s.%s is already published", ExpvarName, c.InstanceId)
+	}
+	m.Set(c.InstanceId, expvar.Func(func() interface{} {
+		return map[string]uint64{
+			"hits":             c.HitCount(),
+			"misses":           c.MissCount(),
+			"invalidations":    c.InvalidationCount(),
+			"errors":           c.ErrorCount(),
+			"droppedWrites":    c.DroppedWriteCount(),
+			"skippedTooLarge":  c.SkippedTooLargeCount(),
+			"shadowDivergence": c.ShadowDivergenceCount(),
+			"bloomRejects":     c.BloomFilterRejectCount(),
+			"verified":         c.VerifiedCount(),
+			"verifyDivergence": c.VerifyDivergenceCount(),
+			"lockedBypasses":   c.LockedReadBypassCount(),
+			"evictions":        c.EvictionCount(),
+			"writeQueueDepth":  uint64(c.WriteQueueDepth()),
+			"circuitTrips":     c.CircuitTripCount(),
+		}
+	}))
+	return nil
+}
@@ -0,0 +1,26 @@
+package cache
+
+import "gorm.io/gorm"
+
+// GenDO is implemented by the DO of the query code generated by gorm.io/gen, which wraps the *gorm.DB its
+// queries run on. The queries go through the callbacks of that db, so they are cached like any other
+type GenDO interface {
+	UnderlyingDB() *gorm.DB
+	ReplaceDB(db *gorm.DB)
+}
+
+// GenOptions applies per-query cache options, e.g. NoCache, CacheOnly or a closure calling WithTTL or WithTags,
+// to the db of a DO generated by gorm.io/gen and returns the DO, so that the query can be chained on:
+//
+//	user, err := cache.GenOptions(q.User.WithContext(ctx), cache.NoCache).Where(q.User.ID.Eq(1)).First()
+//
+// The DO is modified in place, it should be one of its own, as returned by WithContext, rather than the
+// shared q.User. Options carried by the context, see WithCacheCtx, need no helper.
+func GenOptions[T GenDO](do T, options ...func(db *gorm.DB) *gorm.DB) T {
+	db := do.UnderlyingDB()
+	for _, option := range options {
+		db = option(db)
+	}
+	do.ReplaceDB(db)
+	return do
+}
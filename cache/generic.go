@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/joykk/gorm-cache/util"
+	"gorm.io/gorm"
+)
+
+// FindCached runs db.Find with conds through the cache c is attached to and returns the rows as a []T, the
+// cached values being decoded straight into it, e.g.
+//
+//	users, err := cache.FindCached[User](ctx, db.Where("age > ?", 18), gormCache)
+//
+// A nil db queries a new session of the db c is attached to. util.ErrNotAttached is returned if c isn't
+// attached to db, whose queries wouldn't be cached by it.
+func FindCached[T any](ctx context.Context, db *gorm.DB, c *Gorm2Cache, conds ...interface{}) ([]T, error) {
+	db, err := c.cachedSession(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]T, 0)
+	if err := db.Find(&rows, conds...).Error; err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// FirstCached runs db.First with conds through the cache c is attached to and returns the row as a T,
+// see FindCached. gorm.ErrRecordNotFound is returned if there is none, cached or not.
+func FirstCached[T any](ctx context.Context, db *gorm.DB, c *Gorm2Cache, conds ...interface{}) (T, error) {
+	var row T
+	db, err := c.cachedSession(ctx, db)
+	if err != nil {
+		return row, err
+	}
+	err = db.First(&row, conds...).Error
+	return row, err
+}
+
+// LoadCached returns the row of primaryKey cached in the primary cache of the table of T, see LoadModel.
+// The database is never queried.
+func LoadCached[T any](ctx context.Context, c *Gorm2Cache, primaryKey ...interface{}) (T, error) {
+	var row T
+	err := c.LoadModel(ctx, &row, primaryKey...)
+	return row, err
+}
+
+// cachedSession returns db with ctx, or a new session of the db c is attached to if db is nil
+func (c *Gorm2Cache) cachedSession(ctx context.Context, db *gorm.DB) (*gorm.DB, error) {
+	if c.db == nil {
+		return nil, util.ErrNotAttached
+	}
+	if db == nil {
+		return c.db.Session(&gorm.Session{NewDB: true, Context: ctx}), nil
+	}
+	if plugin, ok := db.Config.Plugins[c.Name()]; !ok || plugin != c {
+		return nil, util.ErrNotAttached
+	}
+	return db.WithContext(ctx), nil
+}
@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+)
+
+// healthCheckTTL ms the sentinel key of HealthCheck lives for, should its delete fail
+const healthCheckTTL = 10000
+
+// HealthStatus the outcome of HealthCheck
+type HealthStatus struct {
+	Healthy bool `json:"healthy"`
+	// Latency of the slowest round trip to the storages checked
+	Latency     time.Duration `json:"latency"`
+	CircuitOpen bool          `json:"circuitOpen"`
+	Error       string        `json:"error,omitempty"`
+}
+
+// HealthCheck writes a sentinel key to the default storage and every shard storage used so far, and reads
+// it back, within the deadline of ctx, e.g. for a readiness probe. Unlike Ping it goes through the read and
+// write paths of the storages. A sentinel not read back, as with storage.Noop or an evicting storage, isn't
+// a failure, one read back with another value is. The returned error is that of the unhealthy status
+func (c *Gorm2Cache) HealthCheck(ctx context.Context) (HealthStatus, error) {
+	latency, err := c.roundTrip(ctx, c.cache)
+	c.shards.Range(func(_, value interface{}) bool {
+		s := value.(*shard)
+		shardErr := s.err
+		if shardErr == nil {
+			var shardLatency time.Duration
+			if shardLatency, shardErr = c.roundTrip(ctx, s.store); shardLatency > latency {
+				latency = shardLatency
+			}
+		}
+		if shardErr != nil {
+			err = multierror.Append(err, shardErr)
+		}
+		return true
+	})
+	status := HealthStatus{Healthy: err == nil, Latency: latency, CircuitOpen: c.CircuitOpen()}
+	if err != nil {
+		c.Logger.CtxError(ctx, "[HealthCheck] health check error: %v", err)
+		status.Error = err.Error()
+	}
+	return status, err
+}
+
+// roundTrip writes the sentinel key to store, reads it back and deletes it, returning how long it took
+func (c *Gorm2Cache) roundTrip(ctx context.Context, store storage.DataStorage) (time.Duration, error) {
+	value := strconv.FormatInt(time.Now().UnixNano(), 10)
+	key := c.keys.HealthCheckKey(c.InstanceId, value)
+	start := time.Now()
+	if err := store.SetKey(ctx, util.Kv{Key: key, Value: value, TTL: healthCheckTTL}); err != nil {
+		return time.Since(start), err
+	}
+	read, err := store.GetValue(ctx, key)
+	if err != nil && !errors.Is(err, storage.ErrCacheNotFound) {
+		return time.Since(start), err
+	}
+	latency := time.Since(start)
+	if err == nil && read != value {
+		return latency, fmt.Errorf("sentinel key read back as %q, written as %q", read, value)
+	}
+	return latency, store.DeleteKey(ctx, key)
+}
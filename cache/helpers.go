@@ -3,19 +3,97 @@ package cache
 import (
 	"context"
 	"fmt"
+	"path"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
 
+	"github.com/joykk/gorm-cache/util"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
 )
 
-// getPrimaryKeysFromWhereClause try to find primary keys from Eq and IN exprs in WHERE clause,
-// and get objects that are being operated
-func getPrimaryKeysFromWhereClause(db *gorm.DB) []string {
-	primaryKeys := make([]string, 0)
+// getTableName returns the table a statement operates on. For an aliased table
+// (e.g. Table("users AS u")) gorm keeps the alias in Statement.Table,
+// so the underlying table is taken from the table expression instead.
+func getTableName(db *gorm.DB) string {
+	if db.Statement.TableExpr != nil {
+		if tableName := tableNameFromExpr(db.Statement.TableExpr.SQL); tableName != "" {
+			return tableName
+		}
+	}
+	if db.Statement.Schema != nil {
+		return db.Statement.Schema.Table
+	}
+	return db.Statement.Table
+}
+
+// tableNameFromExpr extracts the unquoted table name from expressions like "`db`.`users` AS u",
+// subqueries and multiple tables can't be resolved and return ""
+func tableNameFromExpr(expr string) string {
+	if strings.ContainsAny(expr, "(,") {
+		return ""
+	}
+	fields := strings.Fields(expr)
+	if len(fields) == 0 {
+		return ""
+	}
+	tableName := fields[0]
+	if idx := strings.LastIndex(tableName, "."); idx >= 0 {
+		tableName = tableName[idx+1:]
+	}
+	return strings.Trim(tableName, "`\"[]")
+}
+
+// matchTable reports if tableName matches any of the patterns, either a name or a glob like orders_*
+func matchTable(tableName string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if pattern == tableName {
+			return true
+		}
+		if matched, err := path.Match(pattern, tableName); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// primaryFields returns the fields rows of the statement's model are keyed by in the primary cache: the columns
+// of Config.PrimaryKeyColumns for the table if set, else the primary key fields of the schema, nil if the model
+// lacks one of the columns
+func (c *Gorm2Cache) primaryFields(db *gorm.DB) []*schema.Field {
+	if db.Statement.Schema == nil {
+		return nil
+	}
+	columns, ok := c.Config.PrimaryKeyColumns[getTableName(db)]
+	if !ok {
+		return db.Statement.Schema.PrimaryFields
+	}
+	fields := make([]*schema.Field, 0, len(columns))
+	for _, column := range columns {
+		field := db.Statement.Schema.LookUpField(column)
+		if field == nil {
+			return nil
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// isKeylessModel reports if the statement operates on a model without primary key (e.g. a view or a join table),
+// such rows can't be addressed in the primary cache, so they rely on search caching and table wide invalidation only.
+// Without a schema the table may still have one, so it isn't considered keyless.
+func (c *Gorm2Cache) isKeylessModel(db *gorm.DB) bool {
+	return db.Statement.Schema != nil && len(c.primaryFields(db)) == 0
+}
 
+// getPrimaryKeysFromWhereClause try to find primary keys from Eq and IN exprs in WHERE clause,
+// and get objects that are being operated. The primary key of a composite key is only found if
+// every one of its columns is compared to a single value, or its columns together to a list of
+// tuples (the IN gorm adds when updating or deleting a slice of models).
+func (c *Gorm2Cache) getPrimaryKeysFromWhereClause(db *gorm.DB) []string {
 	cla, ok := db.Statement.Clauses["WHERE"]
 	if !ok {
 		return nil
@@ -24,32 +102,39 @@ func getPrimaryKeysFromWhereClause(db *gorm.DB) []string {
 	if !ok {
 		return nil
 	}
-	dbName := ""
-	if db.Statement.Schema == nil {
+	fields := c.primaryFields(db)
+	if len(fields) == 0 {
 		return nil
 	}
-	for _, field := range db.Statement.Schema.Fields {
-		if field.PrimaryKey {
-			dbName = field.DBName
-			break
-		}
-	}
-	if len(dbName) == 0 {
-		return nil
+	values := make(map[string][]string, len(fields))
+	for _, field := range fields {
+		values[field.DBName] = nil
 	}
+	var tuples []string
 	for _, expr := range where.Exprs {
+		// an OR group widens the rows matched beyond the primary keys found,
+		// e.g. Where("id = ?", 1).Or("id = ?", 2) would otherwise only yield 1
+		if _, ok := expr.(clause.OrConditions); ok {
+			return nil
+		}
 		eqExpr, ok := expr.(clause.Eq)
 		if ok {
-			if getColNameFromColumn(eqExpr.Column) == dbName {
-				primaryKeys = append(primaryKeys, fmt.Sprintf("%v", eqExpr.Value))
+			if column := getColNameFromColumn(eqExpr.Column); isPrimaryColumn(values, column) {
+				values[column] = append(values[column], fmt.Sprintf("%v", eqExpr.Value))
 			}
 			continue
 		}
 		inExpr, ok := expr.(clause.IN)
 		if ok {
-			if getColNameFromColumn(inExpr.Column) == dbName {
+			if columns, ok := inExpr.Column.([]clause.Column); ok {
+				if keys := tupleKeys(fields, columns, inExpr.Values); keys != nil {
+					tuples = append(tuples, keys...)
+				}
+				continue
+			}
+			if column := getColNameFromColumn(inExpr.Column); isPrimaryColumn(values, column) {
 				for _, val := range inExpr.Values {
-					primaryKeys = append(primaryKeys, fmt.Sprintf("%v", val))
+					values[column] = append(values[column], fmt.Sprintf("%v", val))
 				}
 			}
 		}
@@ -58,15 +143,81 @@ func getPrimaryKeysFromWhereClause(db *gorm.DB) []string {
 			ttype := getExprType(exprStruct)
 			//fmt.Printf("expr: %+v, ttype: %s\n", exprStruct, ttype)
 			if ttype == "in" || ttype == "eq" {
-				fieldName := getColNameFromExpr(exprStruct, ttype)
-				if fieldName == dbName {
-					pKeys := getPrimaryKeysFromExpr(exprStruct, ttype)
-					primaryKeys = append(primaryKeys, pKeys...)
+				column := unqualifiedColumn(db, getColNameFromExpr(exprStruct, ttype))
+				if isPrimaryColumn(values, column) {
+					values[column] = append(values[column], getPrimaryKeysFromExpr(exprStruct, ttype)...)
 				}
 			}
 		}
 	}
-	return uniqueStringSlice(primaryKeys)
+	if len(fields) == 1 {
+		return uniqueStringSlice(values[fields[0].DBName])
+	}
+	// the rows matched have one of the tuples as key, whatever the other conditions
+	if len(tuples) > 0 {
+		return uniqueStringSlice(tuples)
+	}
+	parts := make([]interface{}, 0, len(fields))
+	for _, field := range fields {
+		columnValues := uniqueStringSlice(values[field.DBName])
+		if len(columnValues) != 1 {
+			return nil
+		}
+		parts = append(parts, columnValues[0])
+	}
+	return []string{util.JoinPrimaryKey(parts...)}
+}
+
+// tupleKeys returns the composite primary keys of the tuples an IN compares columns to, nil if the columns
+// aren't those of the primary key
+func tupleKeys(fields []*schema.Field, columns []clause.Column, tuples []interface{}) []string {
+	if len(columns) != len(fields) {
+		return nil
+	}
+	positions := make([]int, len(fields))
+	for idx, field := range fields {
+		positions[idx] = -1
+		for position, column := range columns {
+			if column.Name == field.DBName {
+				positions[idx] = position
+			}
+		}
+		if positions[idx] < 0 {
+			return nil
+		}
+	}
+	keys := make([]string, 0, len(tuples))
+	for _, tuple := range tuples {
+		values, ok := tuple.([]interface{})
+		if !ok || len(values) != len(columns) {
+			return nil
+		}
+		parts := make([]interface{}, 0, len(fields))
+		for _, position := range positions {
+			parts = append(parts, values[position])
+		}
+		keys = append(keys, util.JoinPrimaryKey(parts...))
+	}
+	return keys
+}
+
+// unqualifiedColumn strips the quotes of a column name parsed from a condition, and its table if it is the
+// statement's one, e.g. `gorm_cache_model`.`id` becomes id. Columns of other tables keep their qualification,
+// so that they aren't taken for the primary key of the statement's model
+func unqualifiedColumn(db *gorm.DB, column string) string {
+	column = strings.NewReplacer("`", "", "\"", "", "[", "", "]", "").Replace(column)
+	if idx := strings.LastIndex(column, "."); idx >= 0 {
+		if !strings.EqualFold(column[:idx], getTableName(db)) {
+			return column
+		}
+		column = column[idx+1:]
+	}
+	return column
+}
+
+func isPrimaryColumn(values map[string][]string, column string) bool {
+	_, ok := values[column]
+	return ok
 }
 
 func getColNameFromColumn(col interface{}) string {
@@ -80,32 +231,33 @@ func getColNameFromColumn(col interface{}) string {
 	}
 }
 
-func hasOtherClauseExceptPrimaryField(db *gorm.DB) bool {
+func (c *Gorm2Cache) hasOtherClauseExceptPrimaryField(db *gorm.DB) bool {
 	cla, ok := db.Statement.Clauses["WHERE"]
 	if !ok {
 		return false
 	}
 	where, ok := cla.Expression.(clause.Where)
-	dbName := ""
-	for _, field := range db.Statement.Schema.Fields {
-		if field.PrimaryKey {
-			dbName = field.DBName
-		}
+	columns := make(map[string]bool)
+	for _, field := range c.primaryFields(db) {
+		columns[field.DBName] = true
 	}
-	if len(dbName) == 0 {
+	if len(columns) == 0 {
 		return true // return true to skip cache
 	}
 	for _, expr := range where.Exprs {
+		if isSoftDeleteScope(db, expr) {
+			continue
+		}
 		eqExpr, ok := expr.(clause.Eq)
 		if ok {
-			if getColNameFromColumn(eqExpr.Column) != dbName {
+			if !columns[getColNameFromColumn(eqExpr.Column)] {
 				return true
 			}
 			continue
 		}
 		inExpr, ok := expr.(clause.IN)
 		if ok {
-			if getColNameFromColumn(inExpr.Column) != dbName {
+			if !columns[getColNameFromColumn(inExpr.Column)] {
 				return true
 			}
 			continue
@@ -114,8 +266,8 @@ func hasOtherClauseExceptPrimaryField(db *gorm.DB) bool {
 		if ok {
 			ttype := getExprType(exprStruct)
 			if ttype == "in" || ttype == "eq" {
-				fieldName := getColNameFromExpr(exprStruct, ttype)
-				if fieldName != dbName {
+				fieldName := unqualifiedColumn(db, getColNameFromExpr(exprStruct, ttype))
+				if !columns[fieldName] {
 					return true
 				}
 				continue
@@ -128,12 +280,15 @@ func hasOtherClauseExceptPrimaryField(db *gorm.DB) bool {
 	return false
 }
 
+// connectorRegexp matches the logical operators joining several conditions
+var connectorRegexp = regexp.MustCompile(`(?i)\b(and|or)\b`)
+
 func getExprType(expr clause.Expr) string {
 	// delete spaces
 	sql := strings.Replace(strings.ToLower(expr.SQL), " ", "", -1)
 
-	// see if sql has more than one clause
-	hasConnector := strings.Contains(sql, "and") || strings.Contains(sql, "or")
+	// see if sql has more than one clause, names like gorm_cache_model or brand_id contain no connector
+	hasConnector := connectorRegexp.MatchString(expr.SQL)
 
 	if strings.Contains(sql, "=") && !hasConnector {
 		// possibly "id=?" or "id=123"
@@ -145,10 +300,10 @@ func getExprType(expr clause.Expr) string {
 			}
 		}
 	} else if strings.Contains(sql, "in") && !hasConnector {
-		// possibly "idIN(?)"
+		// possibly "idIN(?)" or "idIN?"
 		fields := strings.Split(sql, "in")
 		if len(fields) == 2 {
-			if len(fields[1]) > 1 && fields[1][0] == '(' && fields[1][len(fields[1])-1] == ')' {
+			if len(fields[1]) > 1 && fields[1][0] == '(' && fields[1][len(fields[1])-1] == ')' || fields[1] == "?" {
 				return "in"
 			}
 		}
@@ -192,9 +347,9 @@ func getPrimaryKeysFromExpr(expr clause.Expr, ttype string) []string {
 						primaryKeys = append(primaryKeys, strconv.FormatInt(number, 10))
 					}
 				}
-			} else if fields[1] == "(?)" {
-				for _, val := range expr.Vars {
-					primaryKeys = append(primaryKeys, fmt.Sprintf("%v", val))
+			} else if fields[1] == "?" {
+				for _, vvar := range expr.Vars {
+					primaryKeys = append(primaryKeys, extractStringsFromVar(vvar)...)
 				}
 			}
 		}
@@ -214,7 +369,9 @@ func getPrimaryKeysFromExpr(expr clause.Expr, ttype string) []string {
 	return primaryKeys
 }
 
-func getObjectsAfterLoad(db *gorm.DB) (primaryKeys []string, objects []interface{}) {
+// getObjectsAfterLoad returns the objects of the statement's dest along with their primary keys,
+// objects whose primary key columns are all zero (e.g. not created yet) are skipped
+func (c *Gorm2Cache) getObjectsAfterLoad(db *gorm.DB) (primaryKeys []string, objects []interface{}) {
 	primaryKeys = make([]string, 0)
 	values := make([]reflect.Value, 0)
 
@@ -229,24 +386,21 @@ func getObjectsAfterLoad(db *gorm.DB) (primaryKeys []string, objects []interface
 		values = append(values, destValue)
 	}
 
-	var valueOf func(context.Context, reflect.Value) (value interface{}, zero bool) = nil
-	if db.Statement.Schema != nil {
-		for _, field := range db.Statement.Schema.Fields {
-			if field.PrimaryKey {
-				valueOf = field.ValueOf
-				break
-			}
-		}
-	}
-
+	fields := c.primaryFields(db)
 	objects = make([]interface{}, 0, len(values))
 	for _, elemValue := range values {
-		if valueOf != nil {
-			primaryKey, isZero := valueOf(context.Background(), elemValue)
-			if isZero {
+		if len(fields) > 0 {
+			parts := make([]interface{}, 0, len(fields))
+			allZero := true
+			for _, field := range fields {
+				part, isZero := field.ValueOf(context.Background(), elemValue)
+				parts = append(parts, part)
+				allZero = allZero && isZero
+			}
+			if allZero {
 				continue
 			}
-			primaryKeys = append(primaryKeys, fmt.Sprintf("%v", primaryKey))
+			primaryKeys = append(primaryKeys, util.JoinPrimaryKey(parts...))
 		}
 		objects = append(objects, elemValue.Interface())
 	}
@@ -284,3 +438,165 @@ func extractStringsFromVar(v interface{}) []string {
 	}
 	return nil
 }
+
+// isModelDest reports whether dest holds objects of the statement's model,
+// that is a model struct or a slice/array of model structs (or pointers to them).
+// Primary keys can only be extracted from such destinations.
+func isModelDest(db *gorm.DB, destValue reflect.Value) bool {
+	if db.Statement.Schema == nil {
+		return false
+	}
+	destType := destValue.Type()
+	if destType.Kind() == reflect.Slice || destType.Kind() == reflect.Array {
+		destType = destType.Elem()
+	}
+	for destType.Kind() == reflect.Pointer {
+		destType = destType.Elem()
+	}
+	return destType == db.Statement.Schema.ModelType
+}
+
+// isPartialSelect reports whether the query only selects some of the columns of its model with Select or Omit,
+// or a SELECT clause added with Clauses. The primary cache holds whole rows, it neither serves nor caches the
+// rows of such queries. Rows omitting only fields tagged gormCache:"-" are whole rows as cached.
+func isPartialSelect(db *gorm.DB) bool {
+	for _, column := range db.Statement.Selects {
+		if column != "*" {
+			return true
+		}
+	}
+	for _, column := range db.Statement.Omits {
+		if db.Statement.Schema == nil {
+			return true
+		}
+		if field := db.Statement.Schema.LookUpField(column); field == nil || !isUncachedField(field) {
+			return true
+		}
+	}
+	return selectClauseIsPartial(db)
+}
+
+// selectClauseIsPartial reports whether the SELECT clause of the statement lacks a column of its model.
+// Listing every column, as with gorm's QueryFields, selects whole rows
+func selectClauseIsPartial(db *gorm.DB) bool {
+	c, ok := db.Statement.Clauses["SELECT"]
+	if !ok || c.Expression == nil {
+		return false
+	}
+	sel, ok := c.Expression.(clause.Select)
+	if !ok {
+		// an expression of its own, e.g. Clauses(clause.Select{Expression: ...})
+		expr, ok := c.Expression.(clause.Expr)
+		return !ok || strings.TrimSpace(expr.SQL) != "*"
+	}
+	if len(sel.Columns) == 0 {
+		return false
+	}
+	if db.Statement.Schema == nil {
+		return true
+	}
+	selected := make(map[string]bool, len(sel.Columns))
+	for _, column := range sel.Columns {
+		if column.Name == "*" && (column.Table == "" || column.Table == db.Statement.Table) {
+			return false
+		}
+		if column.Raw {
+			return true
+		}
+		selected[column.Name] = true
+	}
+	for _, field := range db.Statement.Schema.Fields {
+		if field.DBName != "" && field.Readable && !isUncachedField(field) && !selected[field.DBName] {
+			return true
+		}
+	}
+	return false
+}
+
+// isUncachedField reports whether the field is tagged gormCache:"-", e.g. a password or a blob, which the
+// default serializer leaves out of the cached values
+func isUncachedField(field *schema.Field) bool {
+	return field.DBName != "" && field.Tag.Get("gormCache") == "-"
+}
+
+func hasUncachedFields(s *schema.Schema) bool {
+	if s == nil {
+		return false
+	}
+	for _, field := range s.Fields {
+		if isUncachedField(field) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectsUncachedFields reports whether the query loads fields tagged gormCache:"-", of its model or of the
+// associations it preloads or joins. Cached values lack them, such queries are served by the database
+func selectsUncachedFields(db *gorm.DB) bool {
+	s := db.Statement.Schema
+	if s == nil {
+		return false
+	}
+	if hasUncachedFields(s) {
+		results, restricted := db.Statement.SelectAndOmitColumns(false, false)
+		for _, field := range s.Fields {
+			if !isUncachedField(field) {
+				continue
+			}
+			if selected, ok := results[field.DBName]; selected || (!ok && !restricted) {
+				return true
+			}
+		}
+	}
+	names := make([]string, 0, len(db.Statement.Preloads)+len(db.Statement.Joins))
+	for name := range db.Statement.Preloads {
+		names = append(names, name)
+	}
+	for _, join := range db.Statement.Joins {
+		names = append(names, join.Name)
+	}
+	for _, name := range names {
+		relations, _ := preloadRelations(s, name)
+		for _, rel := range relations {
+			if hasUncachedFields(rel.FieldSchema) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// aggregateFunction matches the aggregate functions of a select list
+var aggregateFunction = regexp.MustCompile(`(?i)\b(COUNT|SUM|AVG|MIN|MAX|GROUP_CONCAT|STRING_AGG|ARRAY_AGG)\s*\(`)
+
+// isAggregateQuery reports whether the query computes aggregates, like Count or a select of SUM(...), rather
+// than loading rows: its result depends on every row of the table, not on the rows it returns
+func isAggregateQuery(db *gorm.DB) bool {
+	if _, grouped := db.Statement.Clauses["GROUP BY"]; grouped {
+		return true
+	}
+	sql := db.Statement.SQL.String()
+	if from := strings.Index(strings.ToUpper(sql), " FROM "); from >= 0 {
+		sql = sql[:from]
+	}
+	return aggregateFunction.MatchString(sql)
+}
+
+// isScalarSliceDest reports whether dest is a slice of scalar values, like the destination of Pluck
+func isScalarSliceDest(destValue reflect.Value) bool {
+	if destValue.Kind() != reflect.Slice && destValue.Kind() != reflect.Array {
+		return false
+	}
+	elemType := destValue.Type().Elem()
+	for elemType.Kind() == reflect.Pointer {
+		elemType = elemType.Elem()
+	}
+	switch elemType.Kind() {
+	case reflect.Bool, reflect.String, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"context"
+	"sort"
+
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/util"
+)
+
+// runHook calls a hook of Config.Hooks, recovering its panic so that a buggy observer can't fail the query
+func (c *Gorm2Cache) runHook(ctx context.Context, name string, hook func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.Logger.CtxError(ctx, "[hooks] %s panic: %v", name, r)
+		}
+	}()
+	hook()
+}
+
+// observeLookup reports a cache hit or miss to Config.Hooks and logs it at its level of Config.EventLogLevels,
+// key is only built when a hook is set or the event logged
+func (c *Gorm2Cache) observeLookup(ctx context.Context, tableName string, hit bool, key func() string) {
+	name, hook, level := "OnMiss", c.Config.Hooks.OnMiss, c.Config.EventLogLevels.Miss
+	if hit {
+		name, hook, level = "OnHit", c.Config.Hooks.OnHit, c.Config.EventLogLevels.Hit
+	}
+	if hook == nil && !c.logsEvent(level) {
+		return
+	}
+	lookupKey := key()
+	if c.logsEvent(level) {
+		event := "cache miss"
+		if hit {
+			event = "cache hit"
+		}
+		c.eventLogger.CtxLog(ctx, level, event, util.Field{Key: "table", Value: tableName},
+			util.Field{Key: "key", Value: lookupKey})
+	}
+	if hook != nil {
+		c.runHook(ctx, name, func() { hook(ctx, tableName, lookupKey) })
+	}
+}
+
+// observeSet reports cache keys written to Config.Hooks
+func (c *Gorm2Cache) observeSet(ctx context.Context, tableName string, keys ...string) {
+	if hook := c.Config.Hooks.OnSet; hook != nil {
+		c.runHook(ctx, "OnSet", func() { hook(ctx, tableName, keys) })
+	}
+}
+
+// observeInvalidation reports invalidated cache keys, or key prefixes, to Config.Hooks and logs them at the
+// Invalidate level of Config.EventLogLevels
+func (c *Gorm2Cache) observeInvalidation(ctx context.Context, tableName string, keys ...string) {
+	if level := c.Config.EventLogLevels.Invalidate; c.logsEvent(level) {
+		c.eventLogger.CtxLog(ctx, level, "cache invalidate", util.Field{Key: "table", Value: tableName},
+			util.Field{Key: "keys", Value: keys})
+	}
+	if hook := c.Config.Hooks.OnInvalidate; hook != nil {
+		c.runHook(ctx, "OnInvalidate", func() { hook(ctx, tableName, keys) })
+	}
+}
+
+// observeError reports a failed storage operation to Config.Hooks
+func (c *Gorm2Cache) observeError(ctx context.Context, err error) {
+	if hook := c.Config.Hooks.OnError; hook != nil {
+		c.runHook(ctx, "OnError", func() { hook(ctx, err) })
+	}
+}
+
+func (c *Gorm2Cache) observeCircuitBreak(ctx context.Context, open bool) {
+	if hook := c.Config.Hooks.OnCircuitBreak; hook != nil {
+		c.runHook(ctx, "OnCircuitBreak", func() { hook(ctx, open) })
+	}
+}
+
+// logsEvent reports whether events of level are logged
+func (c *Gorm2Cache) logsEvent(level util.Level) bool {
+	return level != util.LevelOff && c.eventLogger != nil
+}
+
+// hookNames lists the hooks set, for the config snapshot
+func hookNames(hooks config.Hooks) []string {
+	names := make([]string, 0)
+	for name, set := range map[string]bool{
+		"OnHit":          hooks.OnHit != nil,
+		"OnMiss":         hooks.OnMiss != nil,
+		"OnSet":          hooks.OnSet != nil,
+		"OnInvalidate":   hooks.OnInvalidate != nil,
+		"OnError":        hooks.OnError != nil,
+		"OnOperation":    hooks.OnOperation != nil,
+		"OnCircuitBreak": hooks.OnCircuitBreak != nil,
+	} {
+		if set {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
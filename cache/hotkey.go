@@ -0,0 +1,156 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/joykk/gorm-cache/storage"
+	"gorm.io/gorm"
+)
+
+const defaultHotKeyRefreshInterval = 1000
+
+// hotKey a search key hit during the current interval of Config.HotKeyRefresh, and the query to refresh it with
+type hotKey struct {
+	hits     uint64
+	sql      string
+	query    *gorm.DB // a reusable copy of the first query hitting the key, see reloadQuery
+	destType reflect.Type
+}
+
+// hotKeyRefresher counts the hits of the search keys, and refreshes the hottest ones each interval
+type hotKeyRefresher struct {
+	keys     sync.Map // search key to *hotKey
+	topN     int
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+
+	closeOnce sync.Once
+}
+
+func newHotKeyRefresher(topN int, interval int64) *hotKeyRefresher {
+	if interval <= 0 {
+		interval = defaultHotKeyRefreshInterval
+	}
+	return &hotKeyRefresher{
+		topN:     topN,
+		interval: time.Duration(interval) * time.Millisecond,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// close stops refreshing, waiting for the running refresh to finish
+func (r *hotKeyRefresher) close() {
+	r.closeOnce.Do(func() {
+		close(r.stop)
+	})
+	<-r.done
+}
+
+// trackHotKey counts a search cache hit of the query of db for Config.HotKeyRefresh
+func (c *Gorm2Cache) trackHotKey(db *gorm.DB, tableName string, sql string) {
+	if c.hotKeys == nil || isReload(db) || isCacheOnly(db) {
+		return
+	}
+	key := c.searchCacheKey(c.instanceId(db.Statement.Context), tableName, sql, db.Statement.Vars...)
+	v, ok := c.hotKeys.keys.Load(key)
+	if !ok {
+		// the copy is only made the first time the key is hit, not on the path of every hit
+		v, _ = c.hotKeys.keys.LoadOrStore(key, &hotKey{
+			sql:      sql,
+			query:    reloadQuery(db).Session(&gorm.Session{}),
+			destType: reflect.Indirect(reflect.ValueOf(db.Statement.Dest)).Type(),
+		})
+	}
+	atomic.AddUint64(&v.(*hotKey).hits, 1)
+}
+
+// runHotKeyRefresh refreshes the hottest keys every interval until Close
+func (c *Gorm2Cache) runHotKeyRefresh() {
+	r := c.hotKeys
+	defer close(r.done)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			c.refreshHotKeys()
+		}
+	}
+}
+
+// refreshHotKeys runs the queries of the TopN keys hit the most since the last run again, if their entries
+// expire before the run after next. Keys not hit since are forgotten
+func (c *Gorm2Cache) refreshHotKeys() {
+	type candidate struct {
+		key  string
+		hot  *hotKey
+		hits uint64
+	}
+	candidates := make([]candidate, 0)
+	c.hotKeys.keys.Range(func(k, v interface{}) bool {
+		hot := v.(*hotKey)
+		hits := atomic.SwapUint64(&hot.hits, 0)
+		if hits == 0 {
+			c.hotKeys.keys.Delete(k)
+			return true
+		}
+		candidates = append(candidates, candidate{key: k.(string), hot: hot, hits: hits})
+		return true
+	})
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].hits > candidates[j].hits
+	})
+	if len(candidates) > c.hotKeys.topN {
+		candidates = candidates[:c.hotKeys.topN]
+	}
+
+	for _, cand := range candidates {
+		select {
+		case <-c.hotKeys.stop:
+			return
+		default:
+		}
+		ctx := cand.hot.query.Statement.Context
+		if !c.expiresWithin(ctx, cand.key, 2*c.hotKeys.interval) {
+			continue
+		}
+		// a stale hit may be revalidating the key already
+		if _, running := c.revalidating.LoadOrStore(cand.key, struct{}{}); running {
+			continue
+		}
+		dest := reflect.New(cand.hot.destType).Interface()
+		if err := cand.hot.query.Find(dest).Error; err != nil {
+			c.Logger.CtxError(ctx, "[refreshHotKeys] refresh search cache of sql %s error: %v", cand.hot.sql, err)
+		}
+		c.revalidating.Delete(cand.key)
+	}
+}
+
+// expiresWithin reports whether the entry of key expires within d, or is gone already. Storages that can't
+// read ttls report true
+func (c *Gorm2Cache) expiresWithin(ctx context.Context, key string, d time.Duration) bool {
+	reader, ok := c.storageFor(ctx).(storage.TTLReader)
+	if !ok {
+		return true
+	}
+	ttl, err := reader.KeyTTL(ctx, key)
+	if errors.Is(err, storage.ErrCacheNotFound) {
+		return true
+	}
+	if err != nil {
+		c.Logger.CtxError(ctx, "[expiresWithin] read ttl of key %s error: %v", key, err)
+		return false
+	}
+	// 0 never expires
+	return ttl > 0 && ttl <= d.Milliseconds()
+}
@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/util"
+	"gorm.io/gorm"
+)
+
+const (
+	invalidationRetryAttempts = 5
+	invalidationRetryBackoff  = 100 * time.Millisecond
+)
+
+// invalidationFailures collects the invalidations of a write that failed, to be handled
+// according to Config.OnInvalidationFailure once all of them have run
+type invalidationFailures struct {
+	mu      sync.Mutex
+	err     error
+	retries []func(ctx context.Context) error
+}
+
+func (f *invalidationFailures) add(err error, retry func(ctx context.Context) error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = multierror.Append(f.err, err)
+	f.retries = append(f.retries, retry)
+}
+
+// handleInvalidationFailures applies the configured policy to the failed invalidations of a write,
+// the failures themselves are already logged by the callbacks
+func (c *Gorm2Cache) handleInvalidationFailures(db *gorm.DB, failures *invalidationFailures) {
+	failures.mu.Lock()
+	defer failures.mu.Unlock()
+	if failures.err == nil {
+		return
+	}
+
+	switch c.Config.OnInvalidationFailure {
+	case config.InvalidationFailureRetryAsync:
+		ctx := detachedContext{db.Statement.Context}
+		for _, retry := range failures.retries {
+			go c.retryInvalidation(ctx, retry)
+		}
+	case config.InvalidationFailureFailWrite:
+		_ = db.AddError(fmt.Errorf("%w: %v", util.ErrInvalidationFailed, failures.err))
+	}
+}
+
+// retryInvalidation retries an invalidation with exponential backoff, giving up after invalidationRetryAttempts
+func (c *Gorm2Cache) retryInvalidation(ctx context.Context, retry func(ctx context.Context) error) {
+	backoff := invalidationRetryBackoff
+	var err error
+	for attempt := 1; attempt <= invalidationRetryAttempts; attempt++ {
+		time.Sleep(backoff)
+		if err = retry(ctx); err == nil {
+			c.Logger.CtxInfo(ctx, "[retryInvalidation] invalidation succeeded after %d retries", attempt)
+			return
+		}
+		backoff *= 2
+	}
+	c.Logger.CtxError(ctx, "[retryInvalidation] giving up invalidation after %d retries, error: %v",
+		invalidationRetryAttempts, err)
+}
+
+// detachedContext keeps the values of the write's context (e.g. for ShardRouter) but not its
+// cancellation, retries outlive the request that triggered them
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (deadline time.Time, ok bool) { return }
+func (detachedContext) Done() <-chan struct{}                   { return nil }
+func (detachedContext) Err() error                              { return nil }
+func (c detachedContext) Value(key interface{}) interface{}     { return c.parent.Value(key) }
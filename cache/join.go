@@ -0,0 +1,108 @@
+package cache
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// joinTable matches the table following each JOIN keyword of a raw join, e.g. Joins("LEFT JOIN orders ON ...")
+var joinTable = regexp.MustCompile(`(?i)\bJOIN\s+(\S+)`)
+
+// fromClause returns the FROM clause BuildQuerySQL built the joins of the query into
+func fromClause(db *gorm.DB) (clause.From, bool) {
+	c, ok := db.Statement.Clauses["FROM"]
+	if !ok {
+		return clause.From{}, false
+	}
+	from, ok := c.Expression.(clause.From)
+	return from, ok
+}
+
+// hasJoins reports whether the query joins other tables, its rows then aren't plain rows of the model
+func hasJoins(db *gorm.DB) bool {
+	from, ok := fromClause(db)
+	return ok && (len(from.Joins) > 0 || len(from.Tables) > 1)
+}
+
+// joinedTables returns the tables, other than the table of the query, it joins, either as associations,
+// e.g. Joins("Orders"), or raw joins. ok is false if the query can't be cached: a joined table can't be
+// parsed, e.g. a subquery, or isn't search cached
+func (c *Gorm2Cache) joinedTables(db *gorm.DB, tableName string) (tables []string, ok bool) {
+	from, found := fromClause(db)
+	if !found {
+		return nil, true
+	}
+	names := make([]string, 0, len(from.Tables)+len(from.Joins))
+	for _, table := range from.Tables {
+		if table.Raw || table.Name == "" || table.Name == clause.CurrentTable {
+			continue
+		}
+		names = append(names, table.Name)
+	}
+	for _, join := range from.Joins {
+		if join.Expression == nil {
+			names = append(names, join.Table.Name)
+			continue
+		}
+		expr, isNamed := join.Expression.(clause.NamedExpr)
+		if !isNamed {
+			return nil, false
+		}
+		matches := joinTable.FindAllStringSubmatch(expr.SQL, -1)
+		if len(matches) == 0 {
+			return nil, false
+		}
+		for _, match := range matches {
+			name := tableNameFromExpr(match[1])
+			if name == "" || strings.EqualFold(name, "LATERAL") {
+				return nil, false
+			}
+			names = append(names, name)
+		}
+	}
+
+	seen := map[string]bool{tableName: true}
+	for _, table := range names {
+		if seen[table] {
+			continue
+		}
+		if !c.ShouldCache(db, table) || !c.searchCacheEnabled(table) || isTableWrittenInSession(db, table) {
+			return nil, false
+		}
+		seen[table] = true
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	return tables, true
+}
+
+// relatedTables returns the tables, other than the table of the query, it preloads associations from or
+// joins, whose writes invalidate its search cache entry
+func (c *Gorm2Cache) relatedTables(db *gorm.DB, tableName string) ([]string, bool) {
+	preloads, ok := c.preloadTables(db, tableName)
+	if !ok {
+		return nil, false
+	}
+	joins, ok := c.joinedTables(db, tableName)
+	if !ok {
+		return nil, false
+	}
+	if len(joins) == 0 {
+		return preloads, true
+	}
+	seen := make(map[string]bool, len(preloads))
+	for _, table := range preloads {
+		seen[table] = true
+	}
+	for _, table := range joins {
+		if !seen[table] {
+			preloads = append(preloads, table)
+		}
+	}
+	sort.Strings(preloads)
+	return preloads, true
+}
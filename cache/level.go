@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"github.com/joykk/gorm-cache/config"
+)
+
+// cacheLevel returns the cache level of the table, its TableCacheLevel if listed there, else CacheLevel
+func (c *Gorm2Cache) cacheLevel(tableName string) config.CacheLevel {
+	if level, ok := c.Config.TableCacheLevel[tableName]; ok {
+		return level
+	}
+	return c.Config.CacheLevel
+}
+
+// primaryCacheEnabled reports whether the table is cached by primary key
+func (c *Gorm2Cache) primaryCacheEnabled(tableName string) bool {
+	level := c.cacheLevel(tableName)
+	return level == config.CacheLevelAll || level == config.CacheLevelOnlyPrimary
+}
+
+// searchCacheEnabled reports whether the queries of the table are search cached
+func (c *Gorm2Cache) searchCacheEnabled(tableName string) bool {
+	level := c.cacheLevel(tableName)
+	return level == config.CacheLevelAll || level == config.CacheLevelOnlySearch
+}
+
+// maxItemCnt returns the most rows of the table a query may return to be cached, 0 for no limit
+func (c *Gorm2Cache) maxItemCnt(tableName string) int64 {
+	if cnt, ok := c.Config.TableCacheMaxItemCnt[tableName]; ok {
+		return cnt
+	}
+	return c.Config.CacheMaxItemCnt
+}
+
+// tooManyRows reports whether a query returned more rows of the table than it may to be cached, counting
+// the result as skipped
+func (c *Gorm2Cache) tooManyRows(tableName string, rows int) bool {
+	if maxItemCnt := c.maxItemCnt(tableName); maxItemCnt == 0 || int64(rows) <= maxItemCnt {
+		return false
+	}
+	c.IncrSkippedTooLargeCount()
+	return true
+}
+
+// tooManySearchRows reports whether a search query returned more rows than Config.MaxCacheRows, counting
+// the result as skipped
+func (c *Gorm2Cache) tooManySearchRows(rows int) bool {
+	if c.Config.MaxCacheRows <= 0 || rows <= c.Config.MaxCacheRows {
+		return false
+	}
+	c.IncrSkippedTooLargeCount()
+	return true
+}
+
+// tooLarge reports whether a serialized value is larger than Config.MaxCacheValueBytes, counting it as skipped
+func (c *Gorm2Cache) tooLarge(value []byte) bool {
+	if c.Config.MaxCacheValueBytes <= 0 || len(value) <= c.Config.MaxCacheValueBytes {
+		return false
+	}
+	c.IncrSkippedTooLargeCount()
+	return true
+}
@@ -0,0 +1,36 @@
+package cache
+
+import (
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// shouldCacheLockedRead reports whether the query may use the cache as far as its locking clause is concerned,
+// queries without one always may. CacheLockedReadsByStrength takes precedence over CacheLockedReads.
+func (c *Gorm2Cache) shouldCacheLockedRead(db *gorm.DB) bool {
+	strength, locked := getLockingStrength(db)
+	if !locked {
+		return true
+	}
+	for configured, cache := range c.Config.CacheLockedReadsByStrength {
+		if strings.EqualFold(strings.TrimSpace(configured), strength) {
+			return cache
+		}
+	}
+	return c.Config.CacheLockedReads
+}
+
+// getLockingStrength returns the upper case strength of the locking clause, e.g. UPDATE for FOR UPDATE
+func getLockingStrength(db *gorm.DB) (string, bool) {
+	cla, ok := db.Statement.Clauses["FOR"]
+	if !ok {
+		return "", false
+	}
+	locking, ok := cla.Expression.(clause.Locking)
+	if !ok {
+		return "", true // a custom locking clause, its strength is unknown
+	}
+	return strings.ToUpper(strings.TrimSpace(locking.Strength)), true
+}
@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	"gorm.io/gorm"
+)
+
+// StoreModel caches model, a model struct or a slice of them, in the primary cache of its table, where queries
+// of the plugin find it, e.g. for code paths that load or build entities without gorm. Keys are built from the
+// schema of the model and the ttl is the one of its table, as for a query. Records with a zero primary key are
+// skipped.
+func (c *Gorm2Cache) StoreModel(ctx context.Context, model interface{}) error {
+	return c.WarmPrimaryCache(ctx, "", model)
+}
+
+// LoadModel fills dest, a pointer to a model struct, with the row of primaryKey cached in the primary cache of
+// its table, by StoreModel or by a query of the plugin. A composite primary key is given column by column, in
+// the order of the primary fields of the model (or of Config.PrimaryKeyColumns). storage.ErrCacheNotFound is
+// returned if the row isn't cached, or if the circuit breaker is open; LoadModel never queries the database.
+func (c *Gorm2Cache) LoadModel(ctx context.Context, dest interface{}, primaryKey ...interface{}) error {
+	if c.db == nil {
+		return util.ErrNotAttached
+	}
+	if reflect.Indirect(reflect.ValueOf(dest)).Kind() != reflect.Struct {
+		return fmt.Errorf("dest of LoadModel must point to a model struct, got %T", dest)
+	}
+	db := c.db.Session(&gorm.Session{NewDB: true, Context: ctx})
+	if err := db.Statement.Parse(dest); err != nil {
+		return err
+	}
+	fields := c.primaryFields(db)
+	if len(fields) == 0 {
+		return fmt.Errorf("model %s has no primary key to cache it by", db.Statement.Schema.Name)
+	}
+	if len(primaryKey) != len(fields) {
+		return fmt.Errorf("model %s has %d primary key columns, got %d values", db.Statement.Schema.Name,
+			len(fields), len(primaryKey))
+	}
+	tableName := db.Statement.Schema.Table
+	if !c.storageAvailable() {
+		return storage.ErrCacheNotFound
+	}
+
+	cacheKey := c.primaryCacheKey(c.instanceId(ctx), tableName, util.JoinPrimaryKey(primaryKey...))
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+	value, err := c.storageFor(ctx).GetValue(ctx, cacheKey)
+	// a cached "record not found" says nothing of rows stored by other means since
+	if err == nil && value == recordNotFound {
+		err = storage.ErrCacheNotFound
+	}
+	if err != nil {
+		if !errors.Is(err, storage.ErrCacheNotFound) {
+			_ = c.countError(ctx, err)
+			c.Logger.CtxError(ctx, "[LoadModel] get primary cache for key %s error: %v", cacheKey, err)
+		}
+		c.IncrMissCount()
+		c.countTableLookup(tableName, kindPrimary, false)
+		c.observeLookup(ctx, tableName, false, func() string { return cacheKey })
+		return err
+	}
+	if err := c.serializer.Unmarshal([]byte(value), dest); err != nil {
+		if errors.Is(err, util.ErrCacheVersionMismatch) {
+			c.IncrMissCount()
+			c.countTableLookup(tableName, kindPrimary, false)
+			c.observeLookup(ctx, tableName, false, func() string { return cacheKey })
+			return storage.ErrCacheNotFound
+		}
+		return err
+	}
+	c.countHit(hitPrimary)
+	c.countTableLookup(tableName, kindPrimary, true)
+	c.observeLookup(ctx, tableName, true, func() string { return cacheKey })
+	return nil
+}
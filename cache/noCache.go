@@ -0,0 +1,28 @@
+package cache
+
+import (
+	"regexp"
+
+	"gorm.io/gorm"
+)
+
+const noCacheKey = "gorm:cache:no_cache"
+
+// noCacheHint matches the /* nocache */ comment, case and spacing insensitive
+var noCacheHint = regexp.MustCompile(`(?i)/\*\s*nocache\s*\*/`)
+
+// NoCache returns a session whose queries neither read nor write the cache, whatever the table config,
+// e.g. to run one query fresh while debugging. A /* nocache */ comment in the SQL has the same effect.
+func NoCache(db *gorm.DB) *gorm.DB {
+	return db.Set(noCacheKey, true).Session(&gorm.Session{})
+}
+
+// shouldBypassCache reports whether the query asked explicitly not to use the cache
+func shouldBypassCache(db *gorm.DB, sql string) bool {
+	if val, ok := db.Get(noCacheKey); ok {
+		if noCache, _ := val.(bool); noCache {
+			return true
+		}
+	}
+	return contextSettings(db).NoCache || noCacheHint.MatchString(sql)
+}
@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// isPartialHit reports if the primary cache holds some of the rows of a query but not all of them,
+// keys cached as not found count as missing
+func isPartialHit(cacheValues []string) bool {
+	cached, missing := false, false
+	for _, value := range cacheValues {
+		if value == "" || value == recordNotFound {
+			missing = true
+		} else {
+			cached = true
+		}
+	}
+	return cached && missing
+}
+
+// loadMissingPrimaryRows completes a partial primary cache hit: the rows of the missing keys are loaded
+// with a single query and cached. It returns the values of the rows found, in the order of primaryKeys.
+func (c *Gorm2Cache) loadMissingPrimaryRows(db *gorm.DB, tableName string, primaryKeys []string,
+	cacheValues []string) ([]string, error) {
+	ctx := db.Statement.Context
+	// a composite key is only ever found for a single row, which can't be a partial hit
+	fields := c.primaryFields(db)
+	if len(fields) != 1 {
+		return nil, fmt.Errorf("table %s has no single column primary key", tableName)
+	}
+	primaryField := fields[0]
+	missing := make([]interface{}, 0, len(cacheValues))
+	for i, value := range cacheValues {
+		if value == "" || value == recordNotFound {
+			missing = append(missing, primaryKeys[i])
+		}
+	}
+
+	rows := reflect.New(reflect.SliceOf(db.Statement.Schema.ModelType))
+	loader := NoCache(db.Session(&gorm.Session{NewDB: true, Context: ctx})).Table(db.Statement.Table)
+	if db.Statement.Unscoped {
+		loader = loader.Unscoped()
+	}
+	err := loader.Where(clause.IN{
+		Column: clause.Column{Table: clause.CurrentTable, Name: primaryField.DBName},
+		Values: missing,
+	}).Find(rows.Interface()).Error
+	if err != nil {
+		return nil, err
+	}
+
+	loadedKeys := make([]string, 0, rows.Elem().Len())
+	loadedRows := make([]interface{}, 0, rows.Elem().Len())
+	loaded := make(map[string]string, rows.Elem().Len())
+	for i := 0; i < rows.Elem().Len(); i++ {
+		row := rows.Elem().Index(i)
+		primaryKey, _ := primaryField.ValueOf(ctx, row)
+		value, err := c.serializer.Marshal(row.Interface())
+		if err != nil {
+			return nil, err
+		}
+		key := fmt.Sprintf("%v", primaryKey)
+		loadedKeys = append(loadedKeys, key)
+		loadedRows = append(loadedRows, row.Interface())
+		loaded[key] = string(value)
+	}
+	if write := c.primaryCacheWrite(db, tableName, loadedKeys, loadedRows); write != nil {
+		if c.Config.AsyncWrite {
+			c.writeAsync(ctx, write)
+		} else {
+			_ = write(ctx)
+		}
+	}
+
+	values := make([]string, 0, len(cacheValues))
+	for i, value := range cacheValues {
+		if value == "" || value == recordNotFound {
+			value = loaded[primaryKeys[i]]
+		}
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const prefetchKey = "gorm:cache:prefetch"
+
+// NextPage is a Config.Prefetch loading the page following the one queried by db,
+// it skips queries without a LIMIT
+func NextPage(db *gorm.DB) *gorm.DB {
+	cla, ok := db.Statement.Clauses["LIMIT"]
+	if !ok {
+		return nil
+	}
+	limit, ok := cla.Expression.(clause.Limit)
+	if !ok || limit.Limit == nil || *limit.Limit <= 0 {
+		return nil
+	}
+	return db.Offset(limit.Offset + *limit.Limit)
+}
+
+func isPrefetch(db *gorm.DB) bool {
+	val, ok := db.Get(prefetchKey)
+	if !ok {
+		return false
+	}
+	prefetch, _ := val.(bool)
+	return prefetch
+}
+
+// prefetch runs the query Config.Prefetch derives from a search cache hit in background,
+// at most one per hit key at a time and Config.PrefetchConcurrency overall. Prefetches don't
+// prefetch in turn, and run outside the transaction and deadline of the hit query.
+func (c *Gorm2Cache) prefetch(db *gorm.DB, tableName string, sql string) {
+	if c.Config.Prefetch == nil || isPrefetch(db) || isCacheOnly(db) {
+		return
+	}
+	key := c.searchCacheKey(c.instanceId(db.Statement.Context), tableName, sql, db.Statement.Vars...)
+	if _, running := c.prefetching.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+	select {
+	case c.prefetchSlots <- struct{}{}:
+	default:
+		c.prefetching.Delete(key)
+		return
+	}
+	release := func() {
+		<-c.prefetchSlots
+		c.prefetching.Delete(key)
+	}
+
+	ctx := detachedContext{db.Statement.Context}
+	base := db.Session(&gorm.Session{Context: ctx})
+	// the copy keeps the rendered SQL of the hit query, it must be built again from the clauses
+	base.Statement.SQL.Reset()
+	base.Statement.Vars = nil
+	base.Statement.ConnPool = db.Config.ConnPool
+	next := c.Config.Prefetch(base)
+	if next == nil {
+		release()
+		return
+	}
+
+	dest := reflect.New(reflect.Indirect(reflect.ValueOf(db.Statement.Dest)).Type()).Interface()
+	go func() {
+		defer release()
+		if err := next.Set(prefetchKey, true).Find(dest).Error; err != nil {
+			c.Logger.CtxError(ctx, "[prefetch] prefetch after hit of sql %s error: %v", sql, err)
+		}
+	}()
+}
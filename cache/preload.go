@@ -0,0 +1,148 @@
+package cache
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// preloadedSQL prefixes the sql of a query preloading associations with their names, so that its results,
+// holding the associations, aren't shared with the same query preloading others or none
+func preloadedSQL(db *gorm.DB, sql string) string {
+	if len(db.Statement.Preloads) == 0 {
+		return sql
+	}
+	names := make([]string, 0, len(db.Statement.Preloads))
+	for name := range db.Statement.Preloads {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return "preload:" + strings.Join(names, ",") + ":" + sql
+}
+
+// preloadTables returns the tables, other than the table of the query, the associations it preloads are
+// loaded from, join tables included. ok is false if the query can't be cached: its preloads have conditions,
+// which can't be told apart in the cache key, aren't relationships of the schema, or are loaded from tables
+// that aren't search cached
+func (c *Gorm2Cache) preloadTables(db *gorm.DB, tableName string) (tables []string, ok bool) {
+	if len(db.Statement.Preloads) == 0 {
+		return nil, true
+	}
+	if db.Statement.Schema == nil {
+		return nil, false
+	}
+	seen := map[string]bool{tableName: true}
+	for name, conds := range db.Statement.Preloads {
+		if len(conds) > 0 {
+			return nil, false
+		}
+		relations, found := preloadRelations(db.Statement.Schema, name)
+		if !found {
+			return nil, false
+		}
+		for _, rel := range relations {
+			for _, table := range relationTables(rel) {
+				if seen[table] {
+					continue
+				}
+				if !c.ShouldCache(db, table) || !c.searchCacheEnabled(table) || isTableWrittenInSession(db, table) {
+					return nil, false
+				}
+				seen[table] = true
+				tables = append(tables, table)
+			}
+		}
+	}
+	sort.Strings(tables)
+	return tables, true
+}
+
+// preloadRelations returns the relationships a preload, e.g. "Orders.Items" or clause.Associations, goes through
+func preloadRelations(s *schema.Schema, name string) ([]*schema.Relationship, bool) {
+	relations := make([]*schema.Relationship, 0)
+	for _, part := range strings.Split(name, ".") {
+		if s == nil {
+			return nil, false
+		}
+		if part == clause.Associations {
+			for _, rel := range s.Relationships.Relations {
+				relations = append(relations, rel)
+			}
+			return relations, true
+		}
+		rel, ok := s.Relationships.Relations[part]
+		if !ok {
+			return nil, false
+		}
+		relations = append(relations, rel)
+		s = rel.FieldSchema
+	}
+	return relations, true
+}
+
+func relationTables(rel *schema.Relationship) []string {
+	tables := make([]string, 0, 2)
+	if rel.FieldSchema != nil {
+		tables = append(tables, rel.FieldSchema.Table)
+	}
+	if rel.JoinTable != nil {
+		tables = append(tables, rel.JoinTable.Table)
+	}
+	return tables
+}
+
+// getRelatedTables returns the tables BeforeQuery found the query preloading associations from or joining,
+// see relatedTables
+func getRelatedTables(db *gorm.DB) []string {
+	tables, _ := db.InstanceGet("gorm:cache:related_tables")
+	if tables == nil {
+		return nil
+	}
+	return tables.([]string)
+}
+
+// relatedCopiesCached reports whether the copies of a search cache entry preloading associations or joining
+// tables, cached in the search cache of each of the tables as RawScan results are, are all cached along with
+// it: a write to any of the tables drops its copy, and with it the entry
+func (c *Gorm2Cache) relatedCopiesCached(ctx context.Context, tables []string, sql string, vars []interface{},
+	cacheValue string) bool {
+	if len(tables) == 0 {
+		return true
+	}
+	keys := make([]string, 0, len(tables))
+	for _, table := range tables {
+		keys = append(keys, c.searchCacheKey(c.instanceId(ctx), table, sql, vars...))
+	}
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+	values, err := c.storageFor(ctx).BatchGetValues(ctx, keys)
+	if c.countError(ctx, err) != nil {
+		c.Logger.CtxError(ctx, "[relatedCopiesCached] get related copies for sql %s error: %v", sql, err)
+		return false
+	}
+	for _, value := range values {
+		if value != cacheValue {
+			return false
+		}
+	}
+	return true
+}
+
+// setRelatedCopies caches the copies of a search cache entry in its related tables, see relatedCopiesCached.
+// The copies aren't tied to rows, so with PreciseSearchInvalidation any write to their tables drops them
+func (c *Gorm2Cache) setRelatedCopies(ctx context.Context, tables []string, cacheValue string, ttl int64,
+	sql string, vars []interface{}) error {
+	for _, table := range tables {
+		if err := c.SetSearchCacheWithTTL(ctx, cacheValue, ttl, table, sql, vars...); err != nil {
+			return err
+		}
+		if err := c.indexSearchDependencies(ctx, table, sql, vars, ttl, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	"gorm.io/gorm"
+)
+
+const primaryVersionKey = "gorm:cache:primary_version"
+
+type primaryVersionCtxKey struct{}
+
+// primaryVersioner returns the storage keeping the versions of the rows, nil if config.PrimaryCacheVersioning is
+// off or the storage can't keep them
+func (c *Gorm2Cache) primaryVersioner(ctx context.Context) storage.Versioner {
+	if !c.Config.PrimaryCacheVersioning {
+		return nil
+	}
+	versioner, _ := c.storageFor(ctx).(storage.Versioner)
+	return versioner
+}
+
+// primaryVersionKeys returns the keys of the versions a cached row of the table is checked against, its own and
+// that of the writes invalidating the whole table
+func (c *Gorm2Cache) primaryVersionKeys(ctx context.Context, tableName string, primaryKey string) []string {
+	instanceId := c.instanceId(ctx)
+	return []string{
+		c.keys.PrimaryVersionKey(instanceId, tableName, primaryKey),
+		c.keys.PrimaryVersionKey(instanceId, tableName, ""),
+	}
+}
+
+// bumpPrimaryVersion moves the versions of the rows of the table past the version of the queries in flight, so
+// that the rows they loaded before the write aren't cached. Without primaryKeys the whole table is bumped
+func (c *Gorm2Cache) bumpPrimaryVersion(ctx context.Context, tableName string, primaryKeys ...string) {
+	versioner := c.primaryVersioner(ctx)
+	if versioner == nil {
+		return
+	}
+	instanceId := c.instanceId(ctx)
+	versionKeys := make([]string, 0, len(primaryKeys))
+	for _, primaryKey := range primaryKeys {
+		versionKeys = append(versionKeys, c.keys.PrimaryVersionKey(instanceId, tableName, primaryKey))
+	}
+	if len(primaryKeys) == 0 {
+		versionKeys = append(versionKeys, c.keys.PrimaryVersionKey(instanceId, tableName, ""))
+	}
+	writeCtx, cancel := c.storageContext(ctx)
+	defer cancel()
+	_, err := versioner.BumpVersion(writeCtx, c.keys.PrimarySequenceKey(instanceId, tableName), versionKeys, c.tableTTL(tableName))
+	if err = c.countError(ctx, err); err != nil {
+		// the keys are deleted all the same, only a query in flight may cache what it read before the write
+		c.Logger.CtxError(ctx, "[bumpPrimaryVersion] bump primary versions of table %s error: %v", tableName, err)
+	}
+}
+
+// readPrimaryVersion returns the version of the table, to be read before the rows are, so that the rows a write
+// bumps in between aren't cached. Not ok if versioning is off or the version can't be read
+func (c *Gorm2Cache) readPrimaryVersion(ctx context.Context, tableName string) (int64, bool) {
+	if c.primaryVersioner(ctx) == nil {
+		return 0, false
+	}
+	readCtx, cancel := c.readContext(ctx)
+	defer cancel()
+	value, err := c.storageFor(readCtx).GetValue(readCtx, c.keys.PrimarySequenceKey(c.instanceId(ctx), tableName))
+	if errors.Is(err, storage.ErrCacheNotFound) {
+		// never bumped, no row has a version yet
+		return 0, true
+	}
+	if err = c.countError(ctx, err); err != nil {
+		c.Logger.CtxError(ctx, "[readPrimaryVersion] read primary version of table %s error: %v", tableName, err)
+		return 0, false
+	}
+	version, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return version, true
+}
+
+// capturePrimaryVersion reads the version of the table before the query runs, see primaryVersionContext
+func (c *Gorm2Cache) capturePrimaryVersion(db *gorm.DB, ctx context.Context, tableName string) {
+	if version, ok := c.readPrimaryVersion(ctx, tableName); ok {
+		db.InstanceSet(primaryVersionKey, version)
+	}
+}
+
+// primaryVersionContext returns the context of the query carrying the version captured before it ran, the rows
+// it loaded are cached as of that version
+func primaryVersionContext(db *gorm.DB) context.Context {
+	ctx := db.Statement.Context
+	if version, ok := db.InstanceGet(primaryVersionKey); ok {
+		return withPrimaryVersion(ctx, version.(int64))
+	}
+	return ctx
+}
+
+func withPrimaryVersion(ctx context.Context, version int64) context.Context {
+	return context.WithValue(ctx, primaryVersionCtxKey{}, version)
+}
+
+func primaryVersionFrom(ctx context.Context) (int64, bool) {
+	version, ok := ctx.Value(primaryVersionCtxKey{}).(int64)
+	return version, ok
+}
+
+// setPrimaryKeysIfVersion writes the kvs whose rows weren't written since version, versionKeys[i] being the version
+// keys of kvs[i], returning those written
+func (c *Gorm2Cache) setPrimaryKeysIfVersion(ctx context.Context, versioner storage.Versioner, kvs []util.Kv,
+	versionKeys [][]string, version int64) ([]util.Kv, error) {
+	written, err := versioner.SetKeysIfVersion(ctx, kvs, versionKeys[:len(kvs)], version)
+	if err != nil {
+		return nil, err
+	}
+	set := make([]util.Kv, 0, len(kvs))
+	for idx, kv := range kvs {
+		if written[idx] {
+			set = append(set, kv)
+		} else {
+			c.Logger.CtxInfo(ctx, "[setPrimaryKeysIfVersion] key %s written since version %d, not cached", kv.Key, version)
+		}
+	}
+	return set, nil
+}
@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/hashicorp/go-multierror"
@@ -10,22 +11,24 @@ import (
 	"gorm.io/gorm"
 	"gorm.io/gorm/callbacks"
 	"reflect"
-	"strconv"
-	"strings"
 	"sync"
+	"time"
 )
 
+// recordNotFound is the value cached for queries which found no record
+const recordNotFound = "recordNotFound"
+
 // singleFlight 流程设计
 // 根据key lock住，等待结果。query before之前，会先判断是否有key，如果有，就等待结果，如果没有，就执行query before，然后执行query，然后把结果放到key里面，然后unlock，然后返回结果。
 // 等待完成后 进行一手返回 然后err设置为err.singleflightHit，afterQuery结束的时候进行一手检查
 
 func newQueryHandler(c *Gorm2Cache) *queryHandler {
-	return &queryHandler{cache: c}
+	return &queryHandler{cache: c, singleFlight: &c.searchFlight}
 }
 
 type queryHandler struct {
 	cache        *Gorm2Cache
-	singleFlight Group
+	singleFlight *Group
 }
 
 func (h *queryHandler) Bind(db *gorm.DB) error {
@@ -44,47 +47,171 @@ func (h *queryHandler) BeforeQuery() func(db *gorm.DB) {
 	cache := h.cache
 	return func(db *gorm.DB) {
 		callbacks.BuildQuerySQL(db)
-		tableName := ""
-		if db.Statement.Schema != nil {
-			tableName = db.Statement.Schema.Table
-		} else {
-			tableName = db.Statement.Table
+		tableName := getTableName(db)
+		ctx, span := cache.startSpan(db.Statement.Context, spanQuery, tableName)
+		report := cache.startExplain(db, tableName)
+		defer func() {
+			span.SetAttribute("gorm-cache.outcome", queryOutcome(db.Error))
+			span.End()
+			if report != nil {
+				report.Outcome = queryOutcome(db.Error)
+			}
+		}()
+		if cache.Config.MinQueryDuration > 0 {
+			defer func() {
+				// deferred first so that it runs last, once the lookups are done: the database runs the query next
+				db.InstanceSet("gorm:cache:query_start", time.Now())
+			}()
 		}
-		ctx := db.Statement.Context
 
-		sql := db.Statement.SQL.String()
+		// keys derive from the rendered SQL, so builder calls rendering the same SQL and vars share an entry
+		sql := taggedSQL(getTags(db), preloadedSQL(db, unscopedSQL(db, db.Statement.SQL.String())))
+		related, relatedCacheable := h.cache.relatedTables(db, tableName)
+		db.InstanceSet("gorm:cache:related_tables", related)
+
+		bypassReason := h.cache.bypassReason(db, tableName, sql, relatedCacheable)
+		bypass := bypassReason != ""
+		if bypass {
+			report.skip("cache bypassed, %s", bypassReason)
+			if !cache.shouldCacheLockedRead(db) && h.cache.ShouldCache(db, tableName) {
+				cache.IncrLockedReadBypassCount()
+			}
+		}
+		var versionFailure error
+		if !bypass && cache.versionedSearch() && cache.searchCacheEnabled(tableName) && h.cache.ShouldCache(db, tableName) {
+			versioned, err := cache.versionedSQL(ctx, append([]string{tableName}, related...), sql)
+			if err != nil {
+				// the entries of the query can't be told apart from those cached before the last write
+				cache.Logger.CtxError(ctx, "[BeforeQuery] get search versions of table %s error: %v", tableName, err)
+				versionFailure = cache.readFailure(err)
+				bypass = true
+				report.skip("cache bypassed, search versions of table %s unreadable: %v", tableName, err)
+			} else {
+				sql = versioned
+			}
+		}
 		db.InstanceSet("gorm:cache:sql", sql)
 		db.InstanceSet("gorm:cache:vars", db.Statement.Vars)
+		db.InstanceSet("gorm:cache:bypass", bypass)
+		if report != nil && !bypass {
+			report.Key = cache.searchCacheKey(cache.instanceId(ctx), tableName, sql, db.Statement.Vars...)
+			report.decide("search cache key %s", report.Key)
+		}
+		if versionFailure != nil {
+			db.Error = versionFailure
+			return
+		}
+		if !bypass && cache.primaryCacheEnabled(tableName) && h.cache.ShouldCache(db, tableName) {
+			// read before the rows are, a write bumping them in between keeps them from being cached
+			cache.capturePrimaryVersion(db, ctx, tableName)
+		}
+
+		// results are never served under shadow mode, the database answers what the cache can't
+		cacheOnly := isCacheOnly(db) && !cache.Config.ShadowMode
+		if cacheOnly {
+			defer func() {
+				// nothing was served from the cache, stop gorm from querying the database
+				if db.Error == nil {
+					db.Error = util.ErrCacheOnlyMiss
+				}
+			}()
+		}
 
-		if h.cache.ShouldCache(db, tableName) {
+		if isReload(db) {
+			// the query refreshes the cache, it loads from the database and caches as a miss would
+			report.decide("reloaded, the cache is not read")
+			return
+		}
+		if !bypass && h.cache.ShouldCache(db, tableName) && isTableWrittenInSession(db, tableName) {
+			report.skip("table %s was written earlier in the session", tableName)
+		}
+		if h.cache.ShouldCache(db, tableName) && !isTableWrittenInSession(db, tableName) && !bypass {
+			if cache.Config.ShadowMode {
+				// deferred first so that it runs last, once the lookup is counted
+				defer cache.shadowLookup(db)()
+			}
 			hit := false
+			partial := false // served by the primary cache and the database together, counted as a miss
+			kind := kindSearch
+			if !cache.searchCacheEnabled(tableName) {
+				kind = kindPrimary
+			}
 			defer func() {
-				if hit {
-					cache.IncrHitCount()
+				if hit && !partial {
+					cache.countHit(hitKindOf(db.Error))
 				} else {
 					cache.IncrMissCount()
 				}
+				cache.countTableLookup(tableName, kind, hit && !partial)
+				cache.observeLookup(ctx, tableName, hit && !partial, func() string {
+					return cache.searchCacheKey(cache.instanceId(ctx), tableName, sql, db.Statement.Vars...)
+				})
 			}()
 
+			if selectsUncachedFields(db) {
+				// the cached values lack the fields, the rows are loaded from the database
+				report.skip("selects fields tagged gormCache:\"-\"")
+				return
+			}
+			if cache.tryRequestCache(db, tableName, sql, related) {
+				hit = true
+				report.decide("served by the request cache")
+				return
+			}
+
 			// singleFlight Check
-			if h.cache.Config.EnableSingleFlight {
-				singleFlightKey := util.GenSingleFlightKey(tableName, sql, db.Statement.Vars...)
+			// cache only queries never load from the database, so they can neither lead nor share a flight
+			if h.cache.Config.EnableSingleFlight && !cacheOnly && !h.cache.Config.ShadowMode {
+				singleFlightKey := h.cache.singleFlightKey(db, tableName, sql)
 				h.singleFlight.mu.Lock()
 				if h.singleFlight.m == nil {
 					h.singleFlight.m = make(map[string]*call)
 				}
-				if c, ok := h.singleFlight.m[singleFlightKey]; ok {
+				c, ok := h.singleFlight.m[singleFlightKey]
+				switch {
+				case !ok:
+					searchKey := cache.searchCacheKey(cache.instanceId(ctx), tableName, sql, db.Statement.Vars...)
+					c = newCall(singleFlightKey, searchKey, append([]string{tableName}, related...))
+					h.singleFlight.m[singleFlightKey] = c
+					h.singleFlight.mu.Unlock()
+					db.InstanceSet("gorm:cache:query:single_flight_call", c)
+					report.decide("leads the single flight of key %s", singleFlightKey)
+				case h.cache.Config.SingleFlightMaxWaiters > 0 && c.dups >= h.cache.Config.SingleFlightMaxWaiters && !c.finished():
+					// enough queries wait on the load already, this one doesn't pile onto it
+					h.singleFlight.mu.Unlock()
+					report.decide("single flight of key %s is full", singleFlightKey)
+					if h.cache.Config.SingleFlightOverflow == config.SingleFlightOverflowFail {
+						db.Error = util.ErrSingleFlightBusy
+						return
+					}
+					h.cache.Logger.CtxInfo(ctx, "[BeforeQuery] single flight for key %v is full, loading alone", singleFlightKey)
+				default:
 					c.dups++
 					h.singleFlight.mu.Unlock()
-					c.wg.Wait()
+					// the waiter gives up on its own deadline, the load goes on for the others
+					if err := c.wait(ctx); err != nil {
+						h.singleFlight.mu.Lock()
+						c.dups--
+						h.singleFlight.mu.Unlock()
+						h.cache.Logger.CtxInfo(ctx, "[BeforeQuery] waiting on single flight for key %v: %v", singleFlightKey, err)
+						report.decide("gave up waiting on the single flight of key %s", singleFlightKey)
+						db.Error = err
+						return
+					}
+
+					if h.cache.Config.SingleFlightLeaderError == config.SingleFlightLeaderErrorRetry && isLoadFailure(c.err) {
+						h.cache.Logger.CtxInfo(ctx, "[BeforeQuery] single flight for key %v failed, loading alone", singleFlightKey)
+						break
+					}
 
 					// 临时糊一个拷贝在这里 性能可能并不是那么好
-					d, err := json.Marshal(c.dest)
+					// dests of the waiters may be of other types than the one of the leader, they aren't versioned
+					d, err := c.encoded(h.cache.serializer.Serializer)
 					if err != nil {
 						_ = db.AddError(err)
 						return
 					}
-					err = json.Unmarshal(d, db.Statement.Dest)
+					err = h.cache.serializer.Serializer.Unmarshal(d, db.Statement.Dest)
 					if err != nil {
 						_ = db.AddError(err)
 						return
@@ -96,27 +223,52 @@ func (h *queryHandler) BeforeQuery() func(db *gorm.DB) {
 						db.Error = multierror.Append(db.Error, c.err)
 					}
 					h.cache.Logger.CtxInfo(ctx, "[BeforeQuery] single flight hit for key %v", singleFlightKey)
+					report.decide("served by the single flight of key %s", singleFlightKey)
 					return
 				}
-				c := &call{key: singleFlightKey}
-				c.wg.Add(1)
-				h.singleFlight.m[singleFlightKey] = c
-				h.singleFlight.mu.Unlock()
-				db.InstanceSet("gorm:cache:query:single_flight_call", c)
 			}
 
 			tryPrimaryCache := func() (hit bool) {
-				primaryKeys := getPrimaryKeysFromWhereClause(db)
+				// primary cache values are rows without their associations, nor soft deleted
+				if cache.isKeylessModel(db) || len(db.Statement.Preloads) > 0 || hasJoins(db) || isUnscopedSoftDelete(db) {
+					report.skip("primary cache: keyless model, preloads, joins or unscoped soft deletes")
+					return
+				}
+				primaryKeys := cache.getPrimaryKeysFromWhereClause(db)
 				cache.Logger.CtxInfo(ctx, "[BeforeQuery] parse primary keys = %v", primaryKeys)
 
 				if len(primaryKeys) == 0 {
+					report.skip("primary cache: no primary key in the where clause")
+					return
+				}
+
+				// primary cache values are whole model rows, they can't fill other destinations (e.g. Pluck)
+				// nor the rows of a query selecting only some columns or aggregating them
+				if !isModelDest(db, reflect.Indirect(reflect.ValueOf(db.Statement.Dest))) || isPartialSelect(db) || isAggregateQuery(db) {
+					report.skip("primary cache: dest isn't the model, or the query selects only some columns or aggregates")
 					return
 				}
 
 				// if (IN primaryKeys)/(Eq primaryKey) are the only clauses
-				hasOtherClauseInWhere := hasOtherClauseExceptPrimaryField(db)
+				hasOtherClauseInWhere := cache.hasOtherClauseExceptPrimaryField(db)
 				if hasOtherClauseInWhere {
 					// if query has other clauses, it can only query the database
+					report.skip("primary cache: clauses other than the primary keys %v", primaryKeys)
+					return
+				}
+
+				if cache.rejectsPrimaryKeys(db, tableName, primaryKeys) {
+					// answered as the database would, without reading the storage
+					destValue := reflect.Indirect(reflect.ValueOf(db.Statement.Dest))
+					if destValue.Kind() == reflect.Slice {
+						destValue.Set(reflect.MakeSlice(destValue.Type(), 0, 0))
+						db.Error = util.PrimaryCacheHit
+					} else {
+						db.Error = util.RecordNotFoundCacheHit
+					}
+					db.RowsAffected = 0
+					hit = true
+					report.decide("bloom filter tells keys %v don't exist", primaryKeys)
 					return
 				}
 
@@ -124,35 +276,66 @@ func (h *queryHandler) BeforeQuery() func(db *gorm.DB) {
 				cacheValues, err := cache.BatchGetPrimaryCache(ctx, tableName, primaryKeys)
 				if err != nil {
 					cache.Logger.CtxError(ctx, "[BeforeQuery] get primary cache value for key %v error: %v", primaryKeys, err)
-					db.Error = nil
+					db.Error = cache.readFailure(err)
 					return
 				}
 				if len(cacheValues) != len(primaryKeys) {
 					db.Error = nil
+					report.decide("primary cache miss for keys %v", primaryKeys)
 					return
 				}
-				finalValue := ""
-
 				destKind := reflect.Indirect(reflect.ValueOf(db.Statement.Dest)).Kind()
-				if destKind == reflect.Struct && len(cacheValues) == 1 {
-					finalValue = cacheValues[0]
-				} else if (destKind == reflect.Array || destKind == reflect.Slice) && len(cacheValues) >= 1 {
-					finalValue = "[" + strings.Join(cacheValues, ",") + "]"
+				if (destKind == reflect.Slice || destKind == reflect.Array) && isPartialHit(cacheValues) {
+					// only the rows missing from the cache are loaded from the database
+					cacheValues, err = cache.loadMissingPrimaryRows(db, tableName, primaryKeys, cacheValues)
+					if err != nil {
+						cache.Logger.CtxError(ctx, "[BeforeQuery] load rows missing from primary cache %v error: %v", primaryKeys, err)
+						db.Error = nil
+						return
+					}
+					partial = true
+					report.decide("primary cache partial hit for keys %v, missing rows loaded from the database", primaryKeys)
+				} else if util.ContainString("", cacheValues) {
+					db.Error = nil
+					report.decide("primary cache miss for some of keys %v", primaryKeys)
+					return
 				}
-				if len(finalValue) == 0 {
+				if util.ContainString(recordNotFound, cacheValues) {
+					// only lookups of a single row cache that their key was not found
+					if destKind != reflect.Struct || len(cacheValues) != 1 {
+						db.Error = nil
+						return
+					}
+					db.Error = util.RecordNotFoundCacheHit
+					hit = true
+					report.decide("primary cache hit, key %v cached as not found", primaryKeys)
+					return
+				}
+				if !(destKind == reflect.Struct && len(cacheValues) == 1) &&
+					!((destKind == reflect.Array || destKind == reflect.Slice) && len(cacheValues) >= 1) {
 					cache.Logger.CtxError(ctx, "[BeforeQuery] length of cache values and dest not matched")
 					db.Error = util.ErrCacheUnmarshal
 					return
 				}
 
-				err = json.Unmarshal([]byte(finalValue), db.Statement.Dest)
+				err = cache.unmarshalRows(cacheValues, db.Statement.Dest)
+				if errors.Is(err, util.ErrCacheVersionMismatch) {
+					// rows cached by another version of the model are reloaded and cached again
+					cache.Logger.CtxInfo(ctx, "[BeforeQuery] primary cache of keys %v written for another version", primaryKeys)
+					db.Error = nil
+					report.decide("primary cache of keys %v written for another version of the model", primaryKeys)
+					return
+				}
 				if err != nil {
 					cache.Logger.CtxError(ctx, "[BeforeQuery] unmarshal final value error: %v", err)
 					db.Error = util.ErrCacheUnmarshal
 					return
 				}
 				db.Error = util.PrimaryCacheHit
+				db.RowsAffected = int64(len(cacheValues))
 				hit = true
+				report.decide("primary cache hit for keys %v", primaryKeys)
+				cache.refreshPrimaryTTL(db, tableName, primaryKeys)
 				return
 			}
 
@@ -162,24 +345,42 @@ func (h *queryHandler) BeforeQuery() func(db *gorm.DB) {
 				if err != nil {
 					if !errors.Is(err, storage.ErrCacheNotFound) {
 						cache.Logger.CtxError(ctx, "[BeforeQuery] get cache value for sql %s error: %v", sql, err)
+						report.decide("search cache read failed: %v", err)
+					} else {
+						report.decide("search cache miss")
 					}
-					db.Error = nil
+					db.Error = cache.readFailure(err)
 					return
 				}
 				cache.Logger.CtxInfo(ctx, "[BeforeQuery] get value: %s", cacheValue)
-				if cacheValue == "recordNotFound" { // 应对缓存穿透
+				if cacheValue == recordNotFound { // 应对缓存穿透
 					db.Error = util.RecordNotFoundCacheHit
 					hit = true
+					report.decide("search cache hit, query cached as not found")
+					cache.refreshSearchTTL(db, tableName, sql)
 					return
 				}
-				rowsAffectedPos := strings.Index(cacheValue, "|")
-				db.RowsAffected, err = strconv.ParseInt(cacheValue[:rowsAffectedPos], 10, 64)
+				var staleAt int64
+				var data string
+				db.RowsAffected, staleAt, data, err = parseSearchCacheValue(cacheValue)
 				if err != nil {
 					cache.Logger.CtxError(ctx, "[BeforeQuery] unmarshal rows affected cache error: %v", err)
 					db.Error = nil
 					return
 				}
-				err = json.Unmarshal([]byte(cacheValue[rowsAffectedPos+1:]), db.Statement.Dest)
+				if !cache.relatedCopiesCached(ctx, related, sql, db.Statement.Vars, cacheValue) {
+					// a table of the preloaded associations or joined was written since
+					db.Error = nil
+					report.decide("search cache stale, related tables %v written since", related)
+					return
+				}
+				err = cache.serializer.Unmarshal([]byte(data), db.Statement.Dest)
+				if errors.Is(err, util.ErrCacheVersionMismatch) {
+					cache.Logger.CtxInfo(ctx, "[BeforeQuery] search cache of sql %s written for another version", sql)
+					db.Error = nil
+					report.decide("search cache written for another version of the model")
+					return
+				}
 				if err != nil {
 					cache.Logger.CtxError(ctx, "[BeforeQuery] unmarshal search cache error: %v", err)
 					db.Error = nil
@@ -187,18 +388,34 @@ func (h *queryHandler) BeforeQuery() func(db *gorm.DB) {
 				}
 				db.Error = util.SearchCacheHit
 				hit = true
+				report.decide("search cache hit")
+				cache.refreshSearchTTL(db, tableName, sql)
+				cache.trackHotKey(db, tableName, sql)
+				if staleAt > 0 && time.Now().UnixMilli() >= staleAt {
+					db.InstanceSet(staleHitKey, true)
+					cache.revalidate(db, tableName, sql)
+				}
 				return
 			}
 
-			if cache.Config.CacheLevel == config.CacheLevelAll || cache.Config.CacheLevel == config.CacheLevelOnlyPrimary {
+			if cache.primaryCacheEnabled(tableName) {
 				if tryPrimaryCache() {
 					hit = true
+					kind = kindPrimary
+					return
+				}
+				if errors.Is(db.Error, util.ErrCacheStorage) {
 					return
 				}
 			}
-			if cache.Config.CacheLevel == config.CacheLevelAll || cache.Config.CacheLevel == config.CacheLevelOnlySearch {
+			if cache.searchCacheEnabled(tableName) {
 				if !hit && trySearchCache() {
 					hit = true
+					cache.prefetch(db, tableName, sql)
+				} else if !hit && !cacheOnly && !cache.Config.ShadowMode && db.Error == nil && cache.awaitDistributedLoad(db, tableName, sql, trySearchCache) {
+					// served the result another instance loaded
+					hit = true
+					report.decide("served the result another instance loaded")
 				}
 			}
 		}
@@ -208,112 +425,169 @@ func (h *queryHandler) BeforeQuery() func(db *gorm.DB) {
 func (h *queryHandler) AfterQuery() func(db *gorm.DB) {
 	cache := h.cache
 	return func(db *gorm.DB) {
+		defer cache.releaseDistributedLock(db)
+		if cache.Config.ShadowMode {
+			cache.compareShadow(db, getTableName(db))
+		} else if cache.Config.Verifier.SampleRate > 0 {
+			cache.verify(db, getTableName(db))
+		}
 		func() {
-			tableName := ""
-			if db.Statement.Schema != nil {
-				tableName = db.Statement.Schema.Table
-			} else {
-				tableName = db.Statement.Table
-			}
-			ctx := db.Statement.Context
+			tableName := getTableName(db)
+			ctx := primaryVersionContext(db)
 			sqlObj, _ := db.InstanceGet("gorm:cache:sql")
 			sql := sqlObj.(string)
 			varObj, _ := db.InstanceGet("gorm:cache:vars")
 			vars := varObj.([]interface{})
 
-			if !h.cache.ShouldCache(db, tableName) {
+			if bypass, _ := db.InstanceGet("gorm:cache:bypass"); bypass == true || !h.cache.ShouldCache(db, tableName) {
 				return
 			}
+			report := explainReport(db)
+			searchTTL, searchCacheable, ttlReason := cache.queryTTL(db, tableName)
+			if searchCacheable && (db.Error == nil || db.Error == gorm.ErrRecordNotFound) {
+				// only results about to be cached are submitted to the predicate, not cache hits
+				if !cache.shouldSearchCache(tableName, db.Statement.SQL.String(), vars) {
+					searchCacheable = false
+					report.skip("search cache: result rejected by SearchCachePredicate")
+				} else if !cache.slowQuery(db) {
+					searchCacheable = false
+					report.skip("search cache: query took less than MinQueryDuration")
+				}
+			} else if !searchCacheable && cache.searchCacheEnabled(tableName) {
+				report.skip("search cache: %s", ttlReason)
+			}
 
 			if db.Error == nil {
 				destValue := reflect.Indirect(reflect.ValueOf(db.Statement.Dest))
+				// rows loaded along with associations or joined ones aren't plain rows of the model
+				preloading := len(db.Statement.Preloads) > 0 || hasJoins(db)
+				unscoped := isUnscopedSoftDelete(db)
+				if keyColumn := getKeyColumn(db); keyColumn != "" && !cache.isKeylessModel(db) && !preloading && !unscoped {
+					if rows := mapRows(destValue); rows != nil {
+						cache.backfillPrimaryFromMaps(ctx, db, tableName, rows, keyColumn)
+					}
+				}
 				// 如果是结构体应该能提主键出来
 				// 如果是数组需要判断内部元素是不是结构体，不是结构体的都提不了主键
+				// 标量数组（如 Pluck）提不了主键，但可以走 search cache
 				if destValue.Kind() == reflect.Slice || destValue.Kind() == reflect.Array {
-					if (destValue.Type().Elem().Kind() == reflect.Pointer && destValue.Type().Elem().Elem().Kind() != reflect.Struct) ||
-						(destValue.Type().Elem().Kind() != reflect.Pointer && destValue.Type().Elem().Kind() != reflect.Struct) {
+					if ((destValue.Type().Elem().Kind() == reflect.Pointer && destValue.Type().Elem().Elem().Kind() != reflect.Struct) ||
+						(destValue.Type().Elem().Kind() != reflect.Pointer && destValue.Type().Elem().Kind() != reflect.Struct)) &&
+						!isScalarSliceDest(destValue) {
+						report.skip("dest of type %s can't be cached", destValue.Type())
 						return
 					}
 				}
 
 				// error is nil -> cache not hit, we cache newly retrieved data
-				primaryKeys, objects := getObjectsAfterLoad(db)
-
-				var wg sync.WaitGroup
-				wg.Add(2)
-
-				go func() {
-					defer wg.Done()
-
-					if cache.Config.CacheLevel == config.CacheLevelAll || cache.Config.CacheLevel == config.CacheLevelOnlySearch {
-						// cache search data
-						if cache.Config.CacheMaxItemCnt != 0 && int64(len(objects)) > cache.Config.CacheMaxItemCnt {
-							return
-						}
+				// only objects of the model carry primary keys
+				modelDest := isModelDest(db, destValue)
+				var primaryKeys []string
+				var objects []interface{}
+				if modelDest {
+					primaryKeys, objects = cache.getObjectsAfterLoad(db)
+				} else if destValue.Kind() == reflect.Slice || destValue.Kind() == reflect.Array {
+					for i := 0; i < destValue.Len(); i++ {
+						objects = append(objects, destValue.Index(i).Interface())
+					}
+				} else if destValue.IsValid() {
+					objects = append(objects, destValue.Interface())
+				}
 
-						cache.Logger.CtxInfo(ctx, "[AfterQuery] start to set search cache for sql: %s", sql)
-						cacheBytes, err := json.Marshal(db.Statement.Dest)
-						if err != nil {
-							cache.Logger.CtxError(ctx, "[AfterQuery] cannot marshal cache for sql: %s, not cached", sql)
-							return
-						}
-						cache.Logger.CtxInfo(ctx, "[AfterQuery] set cache: %v", string(cacheBytes))
-						err = cache.SetSearchCache(ctx, fmt.Sprintf("%d|", db.RowsAffected)+string(cacheBytes), tableName, sql, vars...)
-						if err != nil {
-							cache.Logger.CtxError(ctx, "[AfterQuery] set search cache for sql: %s error: %v", sql, err)
-							return
+				if db.RowsAffected == 0 {
+					// a valid query matching no row, cached apart from the "record not found" results
+					if searchCacheable && !cache.Config.CacheEmptyResults {
+						report.skip("search cache: empty results are not cached, see CacheEmptyResults")
+					}
+					searchCacheable = searchCacheable && cache.Config.CacheEmptyResults
+					searchTTL = cache.emptyTTL(searchTTL)
+				}
+				writes := make([]func(ctx context.Context) error, 0, 2)
+				if cache.searchCacheEnabled(tableName) && searchCacheable {
+					// results whose rows aren't all known, or aggregating them, are indexed as depending on every row
+					var dependencies []string
+					if modelDest && !cache.isKeylessModel(db) && len(primaryKeys) == len(objects) && !isAggregateQuery(db) {
+						dependencies = primaryKeys
+					}
+					if write := cache.searchCacheWrite(db, tableName, sql, vars, dependencies, len(objects), searchTTL); write != nil {
+						writes = append(writes, write)
+						report.decide("search cache written with %d rows", len(objects))
+					}
+				}
+				if cache.primaryCacheEnabled(tableName) {
+					if modelDest && !cache.isKeylessModel(db) && !isPartialSelect(db) && !isAggregateQuery(db) && !preloading && !unscoped &&
+						len(primaryKeys) == len(objects) {
+						if write := cache.primaryCacheWrite(db, tableName, primaryKeys, objects); write != nil {
+							writes = append(writes, write)
+							report.decide("primary cache written for keys %v", primaryKeys)
 						}
-						cache.Logger.CtxInfo(ctx, "[AfterQuery] sql %s cached", sql)
+					} else {
+						report.skip("primary cache: rows aren't whole rows of the model with their primary keys")
 					}
-				}()
-
-				go func() {
-					defer wg.Done()
+				}
 
-					if cache.Config.CacheLevel == config.CacheLevelAll || cache.Config.CacheLevel == config.CacheLevelOnlyPrimary {
-						// cache primary cache data
-						if len(primaryKeys) != len(objects) {
-							return
-						}
-						if cache.Config.CacheMaxItemCnt != 0 && int64(len(objects)) > cache.Config.CacheMaxItemCnt {
-							cache.Logger.CtxInfo(ctx, "[AfterQuery] objects length is more than max item count, not cached")
-							return
-						}
-						kvs := make([]util.Kv, 0, len(objects))
-						for i := 0; i < len(objects); i++ {
-							jsonStr, err := json.Marshal(objects[i])
-							if err != nil {
-								cache.Logger.CtxError(ctx, "[AfterQuery] object %v cannot marshal, not cached", objects[i])
-								continue
-							}
-							kvs = append(kvs, util.Kv{
-								Key:   primaryKeys[i],
-								Value: string(jsonStr),
-							})
-						}
-						cache.Logger.CtxInfo(ctx, "[AfterQuery] start to set primary cache for kvs: %+v", kvs)
-						err := cache.BatchSetPrimaryKeyCache(ctx, tableName, kvs)
-						if err != nil {
-							cache.Logger.CtxError(ctx, "[AfterQuery] batch set primary key cache for key %v error: %v",
-								primaryKeys, err)
-						}
+				if cache.Config.AsyncWrite {
+					for _, write := range writes {
+						cache.writeAsync(ctx, write)
 					}
-				}()
-				if !cache.Config.AsyncWrite {
-					wg.Wait()
+					return
+				}
+				var failures failedWrites
+				var wg sync.WaitGroup
+				for _, write := range writes {
+					wg.Add(1)
+					go func(write func(ctx context.Context) error) {
+						defer wg.Done()
+						if err := write(ctx); err != nil {
+							failures.add(err)
+						}
+					}(write)
+				}
+				wg.Wait()
+				if failures.err != nil {
+					report.decide("cache write failed: %v", failures.err)
+				}
+				if failures.err != nil && cache.failClosed() {
+					_ = db.AddError(storageFailure(failures.err))
 				}
 				return
 			}
 
 			// 应对缓存穿透 未来可能考虑使用其他过滤器实现：如布隆过滤器
-			if db.Error == gorm.ErrRecordNotFound && !cache.Config.DisableCachePenetrationProtect {
-				cache.Logger.CtxInfo(ctx, "[AfterQuery] set cache: %v", "recordNotFound")
-				err := cache.SetSearchCache(ctx, "recordNotFound", tableName, sql, vars...)
+			if db.Error == gorm.ErrRecordNotFound && cache.cachePenetrationProtected(tableName) {
+				var failures failedWrites
+				defer func() {
+					if failures.err != nil && cache.failClosed() {
+						_ = db.AddError(storageFailure(failures.err))
+					}
+				}()
+
+				if cache.primaryCacheEnabled(tableName) {
+					if err := cache.setPrimaryNotFound(db, tableName); err != nil {
+						failures.add(err)
+					}
+				}
+				if !searchCacheable {
+					return
+				}
+				if cache.searchCacheSuspended(ctx, tableName) {
+					report.skip("search cache: table %s invalidated recently, see InvalidationDebounce", tableName)
+					return
+				}
+				cache.Logger.CtxInfo(ctx, "[AfterQuery] set cache: %v", recordNotFound)
+				err := cache.SetSearchCacheWithTTL(ctx, recordNotFound, cache.emptyTTL(searchTTL), tableName, sql, vars...)
 				if err != nil {
 					cache.Logger.CtxError(ctx, "[AfterQuery] set search cache for sql: %s error: %v", sql, err)
+					failures.add(err)
+					return
+				}
+				if err = cache.indexSearchDependencies(ctx, tableName, sql, vars, cache.emptyTTL(searchTTL), nil); err != nil {
+					failures.add(err)
 					return
 				}
+				cache.indexTaggedSearchCache(ctx, db, cache.emptyTTL(searchTTL), tableName, sql, vars...)
 				cache.Logger.CtxInfo(ctx, "[AfterQuery] sql %s cached", sql)
+				report.decide("search cache written as not found")
 				return
 			}
 		}()
@@ -341,10 +615,167 @@ func (h *queryHandler) AfterQuery() func(db *gorm.DB) {
 		switch db.Error {
 		case util.RecordNotFoundCacheHit:
 			db.Error = gorm.ErrRecordNotFound
-		case util.SearchCacheHit, util.PrimaryCacheHit:
+		case util.SearchCacheHit, util.PrimaryCacheHit, util.RequestCacheHit:
 			db.Error = nil
 		}
+		cache.memoizeRequest(db)
+	}
+}
+
+// bypassReason names why the query neither reads nor writes the cache, empty if it may
+func (c *Gorm2Cache) bypassReason(db *gorm.DB, tableName string, sql string, relatedCacheable bool) string {
+	switch {
+	case shouldBypassCache(db, sql):
+		return "no cache requested by NoCache or a /* nocache */ hint"
+	case !c.shouldCacheLockedRead(db):
+		return "locking read, see CacheLockedReads"
+	case !c.shouldCacheInTransaction(db):
+		return "in a transaction, see CacheInTransaction"
+	case !relatedCacheable:
+		return "a preloaded or joined table isn't cached"
+	case !c.storageAvailable():
+		return "circuit breaker of the storage open"
+	case c.isDirty(db, tableName):
+		return "rows marked dirty by a write in progress"
 	}
+	return ""
+}
+
+// searchCacheWrite returns the write caching the result of a query in the search cache, nil if it isn't cached.
+// The result is serialized right away, the query may reuse its destination once it returns.
+func (c *Gorm2Cache) searchCacheWrite(db *gorm.DB, tableName string, sql string, vars []interface{},
+	primaryKeys []string, rows int, ttl int64) func(ctx context.Context) error {
+	ctx := db.Statement.Context
+	if c.tooManyRows(tableName, rows) {
+		c.Logger.CtxInfo(ctx, "[AfterQuery] %d rows of table %s are more than max item count %d, sql %s not cached",
+			rows, tableName, c.maxItemCnt(tableName), sql)
+		explainReport(db).skip("search cache: %d rows are more than the max item count %d", rows, c.maxItemCnt(tableName))
+		return nil
+	}
+	if c.tooManySearchRows(rows) {
+		c.Logger.CtxInfo(ctx, "[AfterQuery] %d rows of table %s are more than MaxCacheRows %d, sql %s not cached",
+			rows, tableName, c.Config.MaxCacheRows, sql)
+		explainReport(db).skip("search cache: %d rows are more than MaxCacheRows %d", rows, c.Config.MaxCacheRows)
+		return nil
+	}
+
+	c.Logger.CtxInfo(ctx, "[AfterQuery] start to set search cache for sql: %s", sql)
+	cacheBytes, err := c.serializer.Marshal(db.Statement.Dest)
+	if err != nil {
+		c.Logger.CtxError(ctx, "[AfterQuery] cannot marshal cache for sql: %s, not cached", sql)
+		explainReport(db).skip("search cache: result can't be serialized: %v", err)
+		return nil
+	}
+	if c.tooLarge(cacheBytes) {
+		c.Logger.CtxInfo(ctx, "[AfterQuery] result of %d bytes is larger than max value bytes, sql %s not cached",
+			len(cacheBytes), sql)
+		explainReport(db).skip("search cache: result of %d bytes is larger than MaxCacheValueBytes", len(cacheBytes))
+		return nil
+	}
+	c.Logger.CtxInfo(ctx, "[AfterQuery] set cache: %v", string(cacheBytes))
+	cacheValue, ttl := c.searchCacheValue(tableName, db.RowsAffected, cacheBytes, ttl)
+	tags := getTags(db)
+	related := getRelatedTables(db)
+	return func(ctx context.Context) error {
+		// checked when writing, an async write may be queued before the invalidation
+		for _, table := range append([]string{tableName}, related...) {
+			if c.searchCacheSuspended(ctx, table) {
+				c.Logger.CtxInfo(ctx, "[AfterQuery] search cache of table %s invalidated recently, sql %s not cached", table, sql)
+				return nil
+			}
+		}
+		err := c.SetSearchCacheWithTTL(ctx, cacheValue, ttl, tableName, sql, vars...)
+		if err != nil {
+			c.Logger.CtxError(ctx, "[AfterQuery] set search cache for sql: %s error: %v", sql, err)
+			return err
+		}
+		if err = c.setRelatedCopies(ctx, related, cacheValue, ttl, sql, vars); err != nil {
+			c.Logger.CtxError(ctx, "[AfterQuery] set related copies for sql: %s error: %v", sql, err)
+			return err
+		}
+		if err = c.indexSearchDependencies(ctx, tableName, sql, vars, ttl, primaryKeys); err != nil {
+			return err
+		}
+		c.indexSearchCacheTags(ctx, tags, ttl, tableName, sql, vars...)
+		c.Logger.CtxInfo(ctx, "[AfterQuery] sql %s cached", sql)
+		return nil
+	}
+}
+
+// primaryCacheWrite returns the write caching the objects loaded by a query in the primary cache,
+// nil if they aren't cached. The objects are serialized right away.
+func (c *Gorm2Cache) primaryCacheWrite(db *gorm.DB, tableName string, primaryKeys []string,
+	objects []interface{}) func(ctx context.Context) error {
+	ctx := db.Statement.Context
+	if c.tooManyRows(tableName, len(objects)) {
+		c.Logger.CtxInfo(ctx, "[AfterQuery] objects length is more than max item count, not cached")
+		explainReport(db).skip("primary cache: %d rows are more than the max item count %d", len(objects), c.maxItemCnt(tableName))
+		return nil
+	}
+	kvs := make([]util.Kv, 0, len(objects))
+	for i := 0; i < len(objects); i++ {
+		jsonStr, err := c.serializer.Marshal(objects[i])
+		if err != nil {
+			c.Logger.CtxError(ctx, "[AfterQuery] object %v cannot marshal, not cached", objects[i])
+			continue
+		}
+		if c.tooLarge(jsonStr) {
+			c.Logger.CtxInfo(ctx, "[AfterQuery] object of key %v is larger than max value bytes, not cached", primaryKeys[i])
+			explainReport(db).skip("primary cache: row of key %v is larger than MaxCacheValueBytes", primaryKeys[i])
+			continue
+		}
+		kvs = append(kvs, util.Kv{
+			Key:   primaryKeys[i],
+			Value: string(jsonStr),
+			TTL:   getTTL(db).Milliseconds(),
+		})
+	}
+	return func(ctx context.Context) error {
+		c.Logger.CtxInfo(ctx, "[AfterQuery] start to set primary cache for kvs: %+v", kvs)
+		err := c.BatchSetPrimaryKeyCache(ctx, tableName, kvs)
+		if err != nil {
+			c.Logger.CtxError(ctx, "[AfterQuery] batch set primary key cache for key %v error: %v", primaryKeys, err)
+		}
+		return err
+	}
+}
+
+// setPrimaryNotFound caches that the primary key looked up by a query returning no record doesn't exist,
+// for queries on a single primary key and nothing else
+func (c *Gorm2Cache) setPrimaryNotFound(db *gorm.DB, tableName string) error {
+	destValue := reflect.Indirect(reflect.ValueOf(db.Statement.Dest))
+	if c.isKeylessModel(db) || destValue.Kind() != reflect.Struct || !isModelDest(db, destValue) ||
+		isUnscopedSoftDelete(db) || c.hasOtherClauseExceptPrimaryField(db) {
+		return nil
+	}
+	primaryKeys := c.getPrimaryKeysFromWhereClause(db)
+	if len(primaryKeys) != 1 {
+		return nil
+	}
+	ctx := primaryVersionContext(db)
+	c.Logger.CtxInfo(ctx, "[AfterQuery] set primary cache for key %v: %v", primaryKeys[0], recordNotFound)
+	err := c.BatchSetPrimaryKeyCache(ctx, tableName, []util.Kv{{
+		Key:   primaryKeys[0],
+		Value: recordNotFound,
+		TTL:   c.emptyTTL(getTTL(db).Milliseconds()),
+	}})
+	if err != nil {
+		c.Logger.CtxError(ctx, "[AfterQuery] set primary cache for key %v error: %v", primaryKeys[0], err)
+	}
+	return err
+}
+
+// cachePenetrationProtected reports whether the "record not found" results of the table are cached
+func (c *Gorm2Cache) cachePenetrationProtected(tableName string) bool {
+	return !c.Config.DisableCachePenetrationProtect && !matchTable(tableName, c.Config.DisableCachePenetrationProtectTables)
+}
+
+// emptyTTL returns the ttl in ms of a cached "record not found" or empty result, EmptyCacheTTL if set or else ttl
+func (c *Gorm2Cache) emptyTTL(ttl int64) int64 {
+	if c.Config.EmptyCacheTTL > 0 {
+		return c.Config.EmptyCacheTTL
+	}
+	return ttl
 }
 
 func (h *queryHandler) fillCallAfterQuery(db *gorm.DB) {
@@ -353,12 +784,99 @@ func (h *queryHandler) fillCallAfterQuery(db *gorm.DB) {
 		c.dest = db.Statement.Dest
 		c.rowsAffected = db.RowsAffected
 		c.err = db.Error
-		c.wg.Done()
 
-		h.singleFlight.mu.Lock()
-		if !c.forgotten {
-			delete(h.singleFlight.m, c.key)
+		window := time.Duration(h.cache.Config.SingleFlightShareWindow) * time.Millisecond
+		if isLoadFailure(c.err) {
+			window = 0
+		} else if window > 0 {
+			// the result outlives the query, whose caller may reuse its destination
+			_, _ = c.encoded(h.cache.serializer.Serializer)
 		}
-		h.singleFlight.mu.Unlock()
+		h.singleFlight.finish(c, window)
+	}
+}
+
+// singleFlightKey identifies the loads a query may share: besides its search cache key it includes what the result
+// depends on outside of it, the connection pool (e.g. a transaction), the storage the context is
+// routed to and Config.SingleFlightScope
+func (c *Gorm2Cache) singleFlightKey(db *gorm.DB, tableName string, sql string) string {
+	ctx := db.Statement.Context
+	scope := ""
+	if c.Config.SingleFlightScope != nil {
+		scope = c.Config.SingleFlightScope(ctx)
+	}
+	return fmt.Sprintf("%p:%p:%s:%s", db.Statement.ConnPool, c.storageFor(ctx), scope,
+		c.searchCacheKey(c.instanceId(ctx), tableName, sql, db.Statement.Vars...))
+}
+
+// SingleFlightInFlight returns how many queries share each single flight load in progress, the one loading
+// included, by the search cache key of the load. Loads of identical queries told apart by their connection pool,
+// storage or Config.SingleFlightScope add up under the same key
+func (c *Gorm2Cache) SingleFlightInFlight() map[string]int {
+	return c.searchFlight.inFlight()
+}
+
+// shouldSearchCache reports whether Config.SearchCachePredicate lets the result of a statement be search cached
+func (c *Gorm2Cache) shouldSearchCache(tableName string, sql string, vars []interface{}) bool {
+	return c.Config.SearchCachePredicate == nil || c.Config.SearchCachePredicate(tableName, sql, vars)
+}
+
+// slowQuery reports whether the database took Config.MinQueryDuration or longer to serve the query
+func (c *Gorm2Cache) slowQuery(db *gorm.DB) bool {
+	if c.Config.MinQueryDuration <= 0 {
+		return true
+	}
+	start, ok := db.InstanceGet("gorm:cache:query_start")
+	if !ok {
+		return true
+	}
+	return time.Since(start.(time.Time)) >= time.Duration(c.Config.MinQueryDuration)*time.Millisecond
+}
+
+// isLoadFailure reports if a single flight load failed, results the waiters can share
+// (cache hits and record not found) are not failures
+func isLoadFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, shared := range []error{util.SearchCacheHit, util.PrimaryCacheHit, util.RequestCacheHit, util.RecordNotFoundCacheHit, gorm.ErrRecordNotFound} {
+		if errors.Is(err, shared) {
+			return false
+		}
+	}
+	return true
+}
+
+// failedWrites keeps the first storage error of the concurrent cache writes of a query
+type failedWrites struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (f *failedWrites) add(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err == nil {
+		f.err = err
+	}
+}
+
+// failClosed reports whether storage failures fail the queries, see Config.OnStorageError
+func (c *Gorm2Cache) failClosed() bool {
+	return !c.Config.ShadowMode && (c.Config.OnStorageError == config.StorageErrorFailClosed || c.Config.FailOnStorageError)
+}
+
+func storageFailure(err error) error {
+	return fmt.Errorf("%w: %v", util.ErrCacheStorage, err)
+}
+
+// readFailure returns the error a failed cache read leaves the query with: none to serve it from the
+// database as a miss, unless Config.OnStorageError fails closed and the storage failed for another reason
+// than a miss or ReadTimeout
+func (c *Gorm2Cache) readFailure(err error) error {
+	if !c.failClosed() || errors.Is(err, storage.ErrCacheNotFound) ||
+		(c.readTimeout() > 0 && errors.Is(err, context.DeadlineExceeded)) {
+		return nil
 	}
+	return storageFailure(err)
 }
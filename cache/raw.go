@@ -0,0 +1,137 @@
+package cache
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/joykk/gorm-cache/util"
+	"gorm.io/gorm"
+)
+
+// RawOptions declares how the result of a raw query is cached
+type RawOptions struct {
+	// Key identifies the query in the cache, the rendered SQL is used if empty.
+	// The query vars are always part of the cache key.
+	Key string
+	// Tables the result depends on, a write to any of them invalidates it
+	Tables []string
+}
+
+// RawScan runs db.Scan(dest) for a query built with db.Raw through the search cache.
+// gorm can't tell which tables a raw query reads, so they are declared in options:
+// the result is stored once under each table and only served while all copies exist,
+// so invalidating any of the tables drops it. Without tables the query isn't cached.
+func (c *Gorm2Cache) RawScan(db *gorm.DB, dest interface{}, options *RawOptions) error {
+	ctx := db.Statement.Context
+	if !c.shouldCacheRaw(db, options) {
+		if isCacheOnly(db) {
+			return util.ErrCacheOnlyMiss
+		}
+		return db.Scan(dest).Error
+	}
+
+	key := options.Key
+	if key == "" {
+		key = db.Statement.SQL.String()
+	}
+	key, err := c.versionedSQL(ctx, options.Tables, "raw:"+key)
+	if err != nil {
+		c.Logger.CtxError(ctx, "[RawScan] get search versions of tables %v error: %v", options.Tables, err)
+		if isCacheOnly(db) {
+			return util.ErrCacheOnlyMiss
+		}
+		return db.Scan(dest).Error
+	}
+	vars := db.Statement.Vars
+	cacheKeys := make([]string, 0, len(options.Tables))
+	for _, tableName := range options.Tables {
+		cacheKeys = append(cacheKeys, c.searchCacheKey(c.instanceId(ctx), tableName, key, vars...))
+	}
+
+	readCtx, cancel := c.readContext(ctx)
+	values, err := c.storageFor(readCtx).BatchGetValues(readCtx, cacheKeys)
+	cancel()
+	if c.countError(ctx, err) != nil {
+		c.Logger.CtxError(ctx, "[RawScan] get cache values for key %s error: %v", key, err)
+	}
+	if err == nil && !util.ContainString("", values) && allEqual(values) {
+		rowsAffectedPos := strings.Index(values[0], "|")
+		if rowsAffectedPos >= 0 && c.serializer.Unmarshal([]byte(values[0][rowsAffectedPos+1:]), dest) == nil {
+			c.countHit(hitSearch)
+			c.countTableLookup(options.Tables[0], kindSearch, true)
+			c.observeLookup(ctx, options.Tables[0], true, func() string { return cacheKeys[0] })
+			return nil
+		}
+		c.Logger.CtxError(ctx, "[RawScan] unmarshal cache for key %s error", key)
+	}
+	c.IncrMissCount()
+	c.countTableLookup(options.Tables[0], kindSearch, false)
+	c.observeLookup(ctx, options.Tables[0], false, func() string { return cacheKeys[0] })
+	if isCacheOnly(db) {
+		return util.ErrCacheOnlyMiss
+	}
+
+	result := db.Scan(dest)
+	if result.Error != nil {
+		return result.Error
+	}
+	cacheBytes, err := c.serializer.Marshal(dest)
+	if err != nil {
+		c.Logger.CtxError(ctx, "[RawScan] cannot marshal cache for key %s, not cached", key)
+		return nil
+	}
+	if c.tooLarge(cacheBytes) {
+		c.Logger.CtxInfo(ctx, "[RawScan] result of %d bytes is larger than max value bytes, key %s not cached",
+			len(cacheBytes), key)
+		return nil
+	}
+	value := fmt.Sprintf("%d|", result.RowsAffected) + string(cacheBytes)
+	// the result spans the tables, so it expires with the shortest lived of them
+	var ttl int64
+	for _, table := range options.Tables {
+		tableTTL := c.tableTTL(table)
+		if tableTTL == 0 {
+			tableTTL = c.Config.CacheTTL
+		}
+		if tableTTL > 0 && (ttl == 0 || tableTTL < ttl) {
+			ttl = tableTTL
+		}
+	}
+	kvs := make([]util.Kv, 0, len(cacheKeys))
+	for idx, cacheKey := range cacheKeys {
+		c.tables.Store(options.Tables[idx], struct{}{})
+		kvs = append(kvs, util.Kv{Key: cacheKey, Value: value, TTL: ttl})
+	}
+	writeCtx, cancel := c.writeContext(ctx)
+	defer cancel()
+	if err = c.countError(ctx, c.storageFor(writeCtx).BatchSetKeys(writeCtx, kvs)); err != nil {
+		c.Logger.CtxError(ctx, "[RawScan] set cache for key %s error: %v", key, err)
+		return nil
+	}
+	for idx, tableName := range options.Tables {
+		c.countTableSets(tableName, kindSearch, 1)
+		c.observeSet(ctx, tableName, cacheKeys[idx])
+	}
+	return nil
+}
+
+func (c *Gorm2Cache) shouldCacheRaw(db *gorm.DB, options *RawOptions) bool {
+	if options == nil || len(options.Tables) == 0 || !c.storageAvailable() {
+		return false
+	}
+	for _, tableName := range options.Tables {
+		if !c.searchCacheEnabled(tableName) || !c.ShouldCache(db, tableName) || isTableWrittenInSession(db, tableName) {
+			return false
+		}
+	}
+	return true
+}
+
+func allEqual(values []string) bool {
+	for _, value := range values[1:] {
+		if value != values[0] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	"gorm.io/gorm"
+)
+
+// refreshTTL restarts the ttl (in ms, 0 for the storage's) of the cache keys a query hit when
+// Config.RefreshTTLOnHit is set, in storages able to do so without rewriting the values
+func (c *Gorm2Cache) refreshTTL(ctx context.Context, keys []string, ttl int64) {
+	if !c.Config.RefreshTTLOnHit {
+		return
+	}
+	expirer, ok := c.storageFor(ctx).(storage.Expirer)
+	if !ok {
+		return
+	}
+	refresh := func(ctx context.Context) error {
+		ctx, cancel := c.writeContext(ctx)
+		defer cancel()
+		err := c.countError(ctx, expirer.ExpireKeys(ctx, keys, ttl))
+		if err != nil {
+			c.Logger.CtxError(ctx, "[refreshTTL] expire keys %v error: %v", keys, err)
+		}
+		return err
+	}
+	if c.Config.AsyncWrite {
+		c.writeAsync(ctx, refresh)
+		return
+	}
+	_ = refresh(ctx)
+}
+
+// refreshPrimaryTTL restarts the ttl of the primary cache of the keys hit by the query
+func (c *Gorm2Cache) refreshPrimaryTTL(db *gorm.DB, tableName string, primaryKeys []string) {
+	if !c.Config.RefreshTTLOnHit {
+		return
+	}
+	ttl := getTTL(db).Milliseconds()
+	if ttl <= 0 {
+		ttl = c.tableTTL(tableName)
+	}
+	keys := make([]string, 0, len(primaryKeys))
+	for _, primaryKey := range primaryKeys {
+		keys = append(keys, c.primaryCacheKey(c.instanceId(db.Statement.Context), tableName, primaryKey))
+	}
+	c.refreshTTL(db.Statement.Context, keys, ttl)
+}
+
+// refreshSearchTTL restarts the ttl of the search cache hit by the query
+func (c *Gorm2Cache) refreshSearchTTL(db *gorm.DB, tableName string, sql string) {
+	if !c.Config.RefreshTTLOnHit {
+		return
+	}
+	ttl, searchCacheable, _ := c.queryTTL(db, tableName)
+	if !searchCacheable {
+		return
+	}
+	key := c.searchCacheKey(c.instanceId(db.Statement.Context), tableName, sql, db.Statement.Vars...)
+	c.refreshTTL(db.Statement.Context, []string{key}, ttl)
+}
+
+// GetKeyTTL returns the time left before a cache key expires, 0 if it never expires. It fails with
+// storage.ErrCacheNotFound if the key isn't cached, and with util.ErrTTLUnsupported if the storage doesn't
+// implement storage.TTLReader
+func (c *Gorm2Cache) GetKeyTTL(ctx context.Context, key string) (time.Duration, error) {
+	reader, ok := c.storageFor(ctx).(storage.TTLReader)
+	if !ok {
+		return 0, util.ErrTTLUnsupported
+	}
+	ctx, cancel := c.readContext(ctx)
+	defer cancel()
+	ttl, err := reader.KeyTTL(ctx, key)
+	if err != nil {
+		return 0, c.countError(ctx, err)
+	}
+	return time.Duration(ttl) * time.Millisecond, nil
+}
+
+// TouchPrimaryKey makes the primary cache of a row expire newTTL from now without reloading it, e.g. to keep
+// the rows of a long-lived session cached while it uses them. newTTL not above 0 restarts the ttl the table
+// is cached with. It fails with storage.ErrCacheNotFound if the row isn't cached, and with
+// util.ErrTTLUnsupported if the storage doesn't implement storage.Expirer
+func (c *Gorm2Cache) TouchPrimaryKey(ctx context.Context, tableName string, primaryKey string, newTTL time.Duration) error {
+	expirer, ok := c.storageFor(ctx).(storage.Expirer)
+	if !ok {
+		return util.ErrTTLUnsupported
+	}
+	ttl := newTTL.Milliseconds()
+	if ttl <= 0 {
+		ttl = c.tableTTL(tableName)
+	}
+	key := c.primaryCacheKey(c.instanceId(ctx), tableName, primaryKey)
+	ctx, cancel := c.writeContext(ctx)
+	defer cancel()
+	// storages skip the keys that aren't cached, the row is looked up first to report it
+	exists, err := c.storageFor(ctx).KeyExists(ctx, key)
+	if err != nil {
+		return c.countError(ctx, err)
+	}
+	if !exists {
+		return storage.ErrCacheNotFound
+	}
+	if err = c.countError(ctx, expirer.ExpireKeys(ctx, []string{key}, ttl)); err != nil {
+		c.Logger.CtxError(ctx, "[TouchPrimaryKey] expire primary cache of key %s of table %s error: %v", primaryKey, tableName, err)
+	}
+	return err
+}
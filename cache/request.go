@@ -0,0 +1,145 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/joykk/gorm-cache/util"
+	"gorm.io/gorm"
+)
+
+const pendingRequestKey = "gorm:cache:request_pending"
+
+type requestCacheCtxKey struct{}
+
+// requestCache the results of the queries run with a context returned by WithRequestCache
+type requestCache struct {
+	mu      sync.Mutex
+	entries map[string]requestEntry
+}
+
+type requestEntry struct {
+	database string   // Config.Database of the cache memoizing the entry, writes to another database keep it
+	tables   []string // the table of the query and its related tables, a write to any of them drops the entry
+	data     []byte
+	rows     int64
+	notFound bool
+}
+
+// pendingRequest a query missing the request cache, memoized under key once it completes
+type pendingRequest struct {
+	key    string
+	tables []string
+}
+
+// WithRequestCache returns a context memoizing the results of the queries run with it, e.g. for the lifetime
+// of an HTTP request: repeated identical queries are served from memory, without reaching the cache storage.
+// Entries live as long as the context, whatever the ttl of the cache, and are dropped when the table of their
+// query, or a table it preloads or joins, is written with the context. Writes of other requests aren't seen,
+// the context must not outlive the request. Queries bypassing the cache or run in a transaction aren't memoized.
+func WithRequestCache(ctx context.Context) context.Context {
+	if requestCacheFrom(ctx) != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, requestCacheCtxKey{}, &requestCache{entries: make(map[string]requestEntry)})
+}
+
+func requestCacheFrom(ctx context.Context) *requestCache {
+	if ctx == nil {
+		return nil
+	}
+	rc, _ := ctx.Value(requestCacheCtxKey{}).(*requestCache)
+	return rc
+}
+
+func (rc *requestCache) get(key string) (requestEntry, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	entry, ok := rc.entries[key]
+	return entry, ok
+}
+
+func (rc *requestCache) set(key string, entry requestEntry) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.entries[key] = entry
+}
+
+// invalidate drops the entries of queries reading tableName of database. The entries of the caches of other
+// databases are kept, a table of the same name is another one there; the caches of the same database, whatever
+// their InstanceId, read the same table
+func (rc *requestCache) invalidate(database string, tableName string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	for key, entry := range rc.entries {
+		if entry.database == database && util.ContainString(tableName, entry.tables) {
+			delete(rc.entries, key)
+		}
+	}
+}
+
+// tryRequestCache serves the query from the request cache of its context, if any. On a miss the query is
+// marked to be memoized once it completes, see memoizeRequest
+func (c *Gorm2Cache) tryRequestCache(db *gorm.DB, tableName string, sql string, related []string) bool {
+	rc := requestCacheFrom(db.Statement.Context)
+	if rc == nil || inTransaction(db) {
+		return false
+	}
+	// keyed like the search cache, which tells instances, tables, preloads and tags apart
+	key := c.searchCacheKey(c.instanceId(db.Statement.Context), tableName, sql, db.Statement.Vars...)
+	entry, ok := rc.get(key)
+	if !ok {
+		db.InstanceSet(pendingRequestKey, pendingRequest{key: key, tables: append([]string{tableName}, related...)})
+		return false
+	}
+	if entry.notFound {
+		db.Error = util.RecordNotFoundCacheHit
+		return true
+	}
+	// dests of identical queries may be of other types, the entries aren't versioned
+	if err := c.serializer.Serializer.Unmarshal(entry.data, db.Statement.Dest); err != nil {
+		c.Logger.CtxError(db.Statement.Context, "[tryRequestCache] unmarshal request cache of sql %s error: %v", sql, err)
+		return false
+	}
+	db.RowsAffected = entry.rows
+	db.Error = util.RequestCacheHit
+	return true
+}
+
+// memoizeRequest keeps the result of a query tryRequestCache missed in the request cache of its context
+func (c *Gorm2Cache) memoizeRequest(db *gorm.DB) {
+	val, ok := db.InstanceGet(pendingRequestKey)
+	if !ok {
+		return
+	}
+	rc := requestCacheFrom(db.Statement.Context)
+	if rc == nil {
+		return
+	}
+	pending := val.(pendingRequest)
+	entry := requestEntry{database: c.Config.Database, tables: pending.tables}
+	switch {
+	case db.Error == nil:
+		data, err := c.serializer.Serializer.Marshal(db.Statement.Dest)
+		if err != nil {
+			c.Logger.CtxError(db.Statement.Context, "[memoizeRequest] marshal result error: %v", err)
+			return
+		}
+		entry.data = data
+		entry.rows = db.RowsAffected
+	case errors.Is(db.Error, gorm.ErrRecordNotFound):
+		entry.notFound = true
+	default:
+		return
+	}
+	rc.set(pending.key, entry)
+}
+
+// invalidateRequestCache drops the entries of the database of c reading tableName from the request cache of the
+// context of db
+func (c *Gorm2Cache) invalidateRequestCache(db *gorm.DB, tableName string) {
+	if rc := requestCacheFrom(db.Statement.Context); rc != nil {
+		rc.invalidate(c.Config.Database, tableName)
+	}
+}
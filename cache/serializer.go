@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/util"
+)
+
+// jsonSerializer is the default serializer, jsoniter configured with the gormCache struct tag
+type jsonSerializer struct{}
+
+func (jsonSerializer) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonSerializer) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// versionedSerializer prefixes the values of its serializer with a fingerprint of the fields of the type
+// marshaled and of config.CacheVersion, so that entries written before a change of the model or of the
+// version fail to unmarshal with util.ErrCacheVersionMismatch instead of filling the wrong fields
+type versionedSerializer struct {
+	config.Serializer
+	version      string
+	fingerprints sync.Map // reflect.Type -> []byte, the header of the values of the type
+}
+
+func newVersionedSerializer(serializer config.Serializer, version string) *versionedSerializer {
+	return &versionedSerializer{Serializer: serializer, version: version}
+}
+
+func (s *versionedSerializer) Marshal(v interface{}) ([]byte, error) {
+	data, err := s.Serializer.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	header := s.header(reflect.TypeOf(v))
+	return append(header[:len(header):len(header)], data...), nil
+}
+
+func (s *versionedSerializer) Unmarshal(data []byte, v interface{}) error {
+	header := s.header(reflect.TypeOf(v))
+	if !bytes.HasPrefix(data, header) {
+		return util.ErrCacheVersionMismatch
+	}
+	return s.Serializer.Unmarshal(data[len(header):], v)
+}
+
+// header returns the fingerprint of t and "|". Pointers, slices and arrays around the model are left out,
+// a row cached alone and in a search result, or read into a pointer or not, gets the same one
+func (s *versionedSerializer) header(t reflect.Type) []byte {
+	for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+		t = t.Elem()
+	}
+	if header, ok := s.fingerprints.Load(t); ok {
+		return header.([]byte)
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s.version + "\x00"))
+	if t != nil {
+		_, _ = h.Write([]byte(describeType(t, map[reflect.Type]bool{})))
+	}
+	header := []byte(fmt.Sprintf("%016x|", h.Sum64()))
+	s.fingerprints.Store(t, header)
+	return header
+}
+
+// describeType lists the exported fields of t with their types and tags, recursively
+func describeType(t reflect.Type, seen map[reflect.Type]bool) string {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return "*" + describeType(t.Elem(), seen)
+	case reflect.Slice:
+		return "[]" + describeType(t.Elem(), seen)
+	case reflect.Array:
+		return fmt.Sprintf("[%d]", t.Len()) + describeType(t.Elem(), seen)
+	case reflect.Map:
+		return "map[" + describeType(t.Key(), seen) + "]" + describeType(t.Elem(), seen)
+	case reflect.Struct:
+		if seen[t] {
+			return t.String()
+		}
+		seen[t] = true
+		b := strings.Builder{}
+		b.WriteString(t.String() + "{")
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			b.WriteString(fmt.Sprintf("%s %s %q;", field.Name, describeType(field.Type, seen), field.Tag))
+		}
+		b.WriteString("}")
+		return b.String()
+	}
+	return t.String()
+}
+
+// unmarshalRows fills dest with the primary cache values, each one a row serialized on its own.
+// dest points to a struct for a single value, or to a slice or array of structs or struct pointers.
+func (c *Gorm2Cache) unmarshalRows(values []string, dest interface{}) error {
+	destValue := reflect.Indirect(reflect.ValueOf(dest))
+	if destValue.Kind() == reflect.Struct {
+		return c.serializer.Unmarshal([]byte(values[0]), dest)
+	}
+
+	elemType := destValue.Type().Elem()
+	rows := reflect.MakeSlice(reflect.SliceOf(elemType), 0, len(values))
+	for _, value := range values {
+		row := reflect.New(elemType)
+		if elemType.Kind() == reflect.Ptr {
+			row.Elem().Set(reflect.New(elemType.Elem()))
+		}
+		if err := c.serializer.Unmarshal([]byte(value), row.Interface()); err != nil {
+			return err
+		}
+		rows = reflect.Append(rows, row.Elem())
+	}
+
+	if destValue.Kind() == reflect.Slice {
+		destValue.Set(rows)
+		return nil
+	}
+	for i := 0; i < destValue.Len(); i++ {
+		if i < rows.Len() {
+			destValue.Index(i).Set(rows.Index(i))
+		} else {
+			destValue.Index(i).Set(reflect.Zero(elemType))
+		}
+	}
+	return nil
+}
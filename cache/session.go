@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+const readYourWritesKey = "gorm:cache:read_your_writes"
+
+// writeTracker records tables written through a read-your-writes session
+type writeTracker struct {
+	mu     sync.RWMutex
+	tables map[string]struct{}
+}
+
+func (t *writeTracker) markWritten(tableName string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tables == nil {
+		t.tables = make(map[string]struct{})
+	}
+	t.tables[tableName] = struct{}{}
+}
+
+func (t *writeTracker) written(tableName string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	_, ok := t.tables[tableName]
+	return ok
+}
+
+func (t *writeTracker) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tables = nil
+}
+
+// ReadYourWrites returns a reusable session in which, once a table has been written,
+// subsequent reads of that table bypass the cache and go to the database,
+// so the session always sees its own writes even if invalidation raced.
+// Results read from the database are still written back to the cache.
+func ReadYourWrites(db *gorm.DB) *gorm.DB {
+	return db.Set(readYourWritesKey, &writeTracker{}).Session(&gorm.Session{})
+}
+
+// ClearReadYourWrites makes the session served by the cache again for all tables written so far
+func ClearReadYourWrites(db *gorm.DB) {
+	if tracker := getWriteTracker(db); tracker != nil {
+		tracker.reset()
+	}
+}
+
+func getWriteTracker(db *gorm.DB) *writeTracker {
+	val, ok := db.Get(readYourWritesKey)
+	if !ok {
+		return nil
+	}
+	tracker, _ := val.(*writeTracker)
+	return tracker
+}
+
+// markTableWritten records a write in the read-your-writes session of db, if any, and drops the entries
+// of c reading the table from the request cache of its context, and the single flight results still shared
+func (c *Gorm2Cache) markTableWritten(db *gorm.DB, tableName string) {
+	c.invalidateRequestCache(db, tableName)
+	c.searchFlight.forgetTable(tableName)
+	if tracker := getWriteTracker(db); tracker != nil {
+		tracker.markWritten(tableName)
+	}
+}
+
+// isTableWrittenInSession reports whether reads of tableName must bypass the cache for this session
+func isTableWrittenInSession(db *gorm.DB, tableName string) bool {
+	tracker := getWriteTracker(db)
+	return tracker != nil && tracker.written(tableName)
+}
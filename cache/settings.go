@@ -0,0 +1,52 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type cacheSettingsKey struct{}
+
+// CacheSettings per-request cache decisions carried by a context, see WithCacheCtx. Zero fields leave the
+// decision to the session and the config
+type CacheSettings struct {
+	NoCache   bool          // see NoCache
+	CacheOnly bool          // see CacheOnly
+	TTL       time.Duration // see WithTTL, not above 0 is ignored
+	Tags      []string      // see WithTags
+}
+
+// WithCacheCtx returns a context carrying the cache settings to the queries of the sessions using it, e.g. by
+// db.WithContext(ctx). Unlike NoCache, CacheOnly, WithTTL and WithTags, which are set on a session, they survive
+// sessions started with gorm.Session{NewDB: true} or by scopes. Settings of the session take precedence, tags
+// add up, and so do the settings of a context already carrying some
+func WithCacheCtx(ctx context.Context, settings CacheSettings) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if parent, ok := cacheSettingsFrom(ctx); ok {
+		settings.NoCache = settings.NoCache || parent.NoCache
+		settings.CacheOnly = settings.CacheOnly || parent.CacheOnly
+		if settings.TTL <= 0 {
+			settings.TTL = parent.TTL
+		}
+		settings.Tags = append(append([]string(nil), parent.Tags...), settings.Tags...)
+	}
+	return context.WithValue(ctx, cacheSettingsKey{}, settings)
+}
+
+func cacheSettingsFrom(ctx context.Context) (CacheSettings, bool) {
+	if ctx == nil {
+		return CacheSettings{}, false
+	}
+	settings, ok := ctx.Value(cacheSettingsKey{}).(CacheSettings)
+	return settings, ok
+}
+
+// contextSettings returns the cache settings carried by the context of the query
+func contextSettings(db *gorm.DB) CacheSettings {
+	settings, _ := cacheSettingsFrom(db.Statement.Context)
+	return settings
+}
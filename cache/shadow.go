@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"sort"
+
+	"github.com/joykk/gorm-cache/util"
+	"gorm.io/gorm"
+)
+
+const shadowResultKey = "gorm:cache:shadow_result"
+
+// shadowResult the result the cache would have served a query with under Config.ShadowMode
+type shadowResult struct {
+	notFound bool
+	values   [][]byte // the serialized rows, sorted
+}
+
+// shadowLookup swaps the destination of the query for an empty one of the same type while the cache looks the
+// query up, returning the function putting it back. A result found in the cache is set aside to be compared with
+// the one of the database, which serves the query whatever the lookup left it with, see compareShadow
+func (c *Gorm2Cache) shadowLookup(db *gorm.DB) func() {
+	dest, reflectValue, queryErr := db.Statement.Dest, db.Statement.ReflectValue, db.Error
+	var shadow reflect.Value
+	if destType := reflect.TypeOf(dest); destType != nil && destType.Kind() == reflect.Pointer {
+		shadow = reflect.New(destType.Elem())
+		db.Statement.Dest = shadow.Interface()
+		db.Statement.ReflectValue = shadow.Elem()
+	}
+	return func() {
+		db.Statement.Dest, db.Statement.ReflectValue = dest, reflectValue
+		switch {
+		case errors.Is(db.Error, util.RecordNotFoundCacheHit):
+			db.InstanceSet(shadowResultKey, shadowResult{notFound: true})
+		case shadow.IsValid() && (errors.Is(db.Error, util.PrimaryCacheHit) || errors.Is(db.Error, util.SearchCacheHit) ||
+			errors.Is(db.Error, util.RequestCacheHit)):
+			if values, err := c.shadowValues(shadow.Interface()); err == nil {
+				db.InstanceSet(shadowResultKey, shadowResult{values: values})
+			}
+		}
+		db.Error = queryErr
+		db.RowsAffected = 0
+	}
+}
+
+// compareShadow counts the query as divergent if the database served it another result than the cache would have
+func (c *Gorm2Cache) compareShadow(db *gorm.DB, tableName string) {
+	result, ok := db.InstanceGet(shadowResultKey)
+	if !ok || (db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound)) {
+		return
+	}
+	shadow := result.(shadowResult)
+	notFound := errors.Is(db.Error, gorm.ErrRecordNotFound)
+	diverged := shadow.notFound != (notFound || db.RowsAffected == 0)
+	if !shadow.notFound && !notFound {
+		values, err := c.shadowValues(db.Statement.Dest)
+		if err != nil {
+			return
+		}
+		diverged = !equalValues(values, shadow.values)
+	}
+	if diverged {
+		c.IncrShadowDivergenceCount()
+		c.Logger.CtxError(db.Statement.Context, "[AfterQuery] result of sql %s of table %s diverged from the cached one",
+			db.Statement.SQL.String(), tableName)
+	}
+}
+
+// shadowValues serializes the rows of dest apart, sorted, rows loaded from the primary cache don't come in the
+// order of the database
+func (c *Gorm2Cache) shadowValues(dest interface{}) ([][]byte, error) {
+	destValue := reflect.Indirect(reflect.ValueOf(dest))
+	if destValue.Kind() != reflect.Slice && destValue.Kind() != reflect.Array {
+		value, err := c.serializer.Serializer.Marshal(dest)
+		return [][]byte{value}, err
+	}
+	values := make([][]byte, 0, destValue.Len())
+	for i := 0; i < destValue.Len(); i++ {
+		value, err := c.serializer.Serializer.Marshal(destValue.Index(i).Interface())
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	sort.Slice(values, func(i, j int) bool { return bytes.Compare(values[i], values[j]) < 0 })
+	return values, nil
+}
+
+func equalValues(a [][]byte, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
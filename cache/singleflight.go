@@ -1,30 +1,78 @@
 package cache
 
-import "sync"
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/joykk/gorm-cache/config"
+)
 
 // call is an in-flight or completed singleflight.Do call
 type call struct {
-	wg sync.WaitGroup
+	done chan struct{} // closed once the final result is stored
 
 	key string
 
+	// name the call is reported under by inFlight, the key without what only tells calls apart, e.g. pointers
+	name string
+	// tables the result reads, a write to one of them drops the call while it is shared after completion
+	tables []string
+
 	// These fields will storage final result and will
-	// be written once before the WaitGroup is done
-	// and are only read after the WaitGroup is done.
+	// be written once before done is closed
+	// and are only read after done is closed.
 	dest         interface{}
 	rowsAffected int64
 	err          error
 
+	// the dest serialized once for all the callers receiving a copy of it, see encoded
+	encode  sync.Once
+	data    []byte
+	dataErr error
+
 	// forgotten indicates whether Forget was called with this call's key
 	// while the call was still in flight.
 	forgotten bool
 
 	// These fields are read and written with the singleFlight
-	// mutex held before the WaitGroup is done, and are read but
-	// not written after the WaitGroup is done.
+	// mutex held before done is closed, and are read but
+	// not written after done is closed.
 	dups int
 }
 
+func newCall(key string, name string, tables []string) *call {
+	return &call{done: make(chan struct{}), key: key, name: name, tables: tables}
+}
+
+// finished reports whether the final result of the call is stored
+func (c *call) finished() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// wait waits for the final result of the call, or for ctx to be done, whichever comes first
+func (c *call) wait(ctx context.Context) error {
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// encoded returns the dest serialized, only once however many callers receive a copy of it
+func (c *call) encoded(serializer config.Serializer) ([]byte, error) {
+	c.encode.Do(func() {
+		c.data, c.dataErr = serializer.Marshal(c.dest)
+	})
+	return c.data, c.dataErr
+}
+
 // Group represents a class of work and forms a namespace in
 // which units of work can be executed with duplicate suppression.
 type Group struct {
@@ -43,3 +91,87 @@ func (g *Group) Forget(key string) {
 	delete(g.m, key)
 	g.mu.Unlock()
 }
+
+// Do executes and returns the results of the given function, making
+// sure that only one execution is in-flight for a given key at a
+// time. If a duplicate comes in, the duplicate caller waits for the
+// original to complete and receives the same results.
+// The return value shared reports whether v was given to multiple callers.
+func (g *Group) Do(key string, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+	if c, ok := g.m[key]; ok {
+		c.dups++
+		g.mu.Unlock()
+		<-c.done
+		return c.dest, c.err, true
+	}
+	c := newCall(key, key, nil)
+	g.m[key] = c
+	g.mu.Unlock()
+
+	g.doCall(c, fn)
+	return c.dest, c.err, c.dups > 0
+}
+
+// doCall handles the single call for a key, waiters are released even if fn panics
+func (g *Group) doCall(c *call, fn func() (interface{}, error)) {
+	defer g.finish(c, 0)
+	c.dest, c.err = fn()
+}
+
+// finish releases the waiters of the call, whose result is stored, and keeps sharing it with the calls
+// of its key coming within window, unless it is forgotten before
+func (g *Group) finish(c *call, window time.Duration) {
+	close(c.done)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if c.forgotten {
+		return
+	}
+	if window <= 0 {
+		delete(g.m, c.key)
+		return
+	}
+	time.AfterFunc(window, func() {
+		g.mu.Lock()
+		if g.m[c.key] == c {
+			delete(g.m, c.key)
+		}
+		g.mu.Unlock()
+	})
+}
+
+// forgetTable forgets the completed calls still shared whose result reads the table, it was written
+func (g *Group) forgetTable(tableName string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for key, c := range g.m {
+		if !c.finished() {
+			continue
+		}
+		for _, table := range c.tables {
+			if table == tableName {
+				c.forgotten = true
+				delete(g.m, key)
+				break
+			}
+		}
+	}
+}
+
+// inFlight returns the number of callers of each call in flight, the one executing it included, by name
+func (g *Group) inFlight() map[string]int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	counts := make(map[string]int)
+	for _, c := range g.m {
+		if !c.finished() {
+			counts[c.name] += c.dups + 1
+		}
+	}
+	return counts
+}
@@ -0,0 +1,266 @@
+package cache
+
+import (
+	"fmt"
+
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+)
+
+// ConfigSnapshot is a redacted, serializable view of the active cache configuration
+type ConfigSnapshot struct {
+	InstanceId string `json:"instanceId"`
+	Database   string `json:"database"`
+
+	CacheLevel                           config.CacheLevel                    `json:"cacheLevel"`
+	ShadowMode                           bool                                 `json:"shadowMode"`
+	TableCacheLevel                      map[string]config.CacheLevel         `json:"tableCacheLevel"`
+	Tables                               []string                             `json:"tables"`
+	DisableTables                        []string                             `json:"disableTables"`
+	InvalidateWhenUpdate                 bool                                 `json:"invalidateWhenUpdate"`
+	PreciseSearchInvalidation            bool                                 `json:"preciseSearchInvalidation"`
+	WriteStrategy                        config.WriteStrategy                 `json:"writeStrategy"`
+	SearchInvalidation                   config.SearchInvalidationStrategy    `json:"searchInvalidation"`
+	PrimaryCacheVersioning               bool                                 `json:"primaryCacheVersioning"`
+	AsyncWrite                           bool                                 `json:"asyncWrite"`
+	AsyncWriteWorkers                    int                                  `json:"asyncWriteWorkers"`
+	AsyncWriteQueueSize                  int                                  `json:"asyncWriteQueueSize"`
+	CacheTTL                             int64                                `json:"cacheTTL"`
+	TableTTL                             map[string]int64                     `json:"tableTTL"`
+	TTLJitter                            float64                              `json:"ttlJitter"`
+	StaleWhileRevalidate                 int64                                `json:"staleWhileRevalidate"`
+	RefreshTTLOnHit                      bool                                 `json:"refreshTTLOnHit"`
+	FailOnStorageError                   bool                                 `json:"failOnStorageError"`
+	OnStorageError                       config.StorageErrorPolicy            `json:"onStorageError"`
+	StorageErrorHandler                  bool                                 `json:"storageErrorHandler"` // whether a handler is set
+	Broadcaster                          string                               `json:"broadcaster"`         // type of the broadcaster set, empty for none
+	CacheMaxItemCnt                      int64                                `json:"cacheMaxItemCnt"`
+	TableCacheMaxItemCnt                 map[string]int64                     `json:"tableCacheMaxItemCnt"`
+	MaxCacheRows                         int                                  `json:"maxCacheRows"`
+	BatchSize                            int                                  `json:"batchSize"`
+	DisableCachePenetrationProtect       bool                                 `json:"disableCachePenetrationProtect"`
+	DisableCachePenetrationProtectTables []string                             `json:"disableCachePenetrationProtectTables"`
+	EmptyCacheTTL                        int64                                `json:"emptyCacheTTL"`
+	CacheEmptyResults                    bool                                 `json:"cacheEmptyResults"`
+	SoftDeleteNegativeCache              bool                                 `json:"softDeleteNegativeCache"`
+	PrimaryKeyColumns                    map[string][]string                  `json:"primaryKeyColumns"`
+	KeyGenerator                         bool                                 `json:"keyGenerator"` // whether keys are built by custom funcs
+	PrimaryKeyFunc                       bool                                 `json:"primaryKeyFunc"`
+	SearchKeyFunc                        bool                                 `json:"searchKeyFunc"`
+	SQLNormalizer                        bool                                 `json:"sqlNormalizer"`
+	DebugMode                            bool                                 `json:"debugMode"`
+	EnableSingleFlight                   bool                                 `json:"enableSingleFlight"`
+	SingleFlightScope                    bool                                 `json:"singleFlightScope"` // whether loads are scoped by context
+	SingleFlightMaxWaiters               int                                  `json:"singleFlightMaxWaiters"`
+	SingleFlightOverflow                 config.SingleFlightOverflowPolicy    `json:"singleFlightOverflow"`
+	SingleFlightLeaderError              config.SingleFlightLeaderErrorPolicy `json:"singleFlightLeaderError"`
+	SingleFlightShareWindow              int64                                `json:"singleFlightShareWindow"`
+	DistributedSingleFlight              bool                                 `json:"distributedSingleFlight"`
+	DistributedSingleFlightWait          int64                                `json:"distributedSingleFlightWait"`
+	CacheLockedReads                     bool                                 `json:"cacheLockedReads"`
+	CacheLockedReadsByStrength           map[string]bool                      `json:"cacheLockedReadsByStrength"`
+	CacheInTransaction                   bool                                 `json:"cacheInTransaction"`
+	InvalidateOnCommit                   bool                                 `json:"invalidateOnCommit"`
+	PublishExpvar                        bool                                 `json:"publishExpvar"`
+	ShardRouter                          bool                                 `json:"shardRouter"` // whether storages are routed per request
+	TenantResolver                       bool                                 `json:"tenantResolver"`
+	Compression                          string                               `json:"compression"`
+	CompressionThreshold                 int                                  `json:"compressionThreshold"`
+	EncryptionKey                        string                               `json:"encryptionKey,omitempty"` // redacted
+	EncryptionKeyProvider                string                               `json:"encryptionKeyProvider"`
+	ChunkSize                            int                                  `json:"chunkSize"`
+	Serializer                           string                               `json:"serializer"` // type of the serializer set, empty for the default
+	CacheVersion                         string                               `json:"cacheVersion"`
+	Tracer                               string                               `json:"tracer"` // type of the tracer set, empty for none
+	Hooks                                []string                             `json:"hooks"`  // names of the hooks set
+	ReadTimeout                          int64                                `json:"readTimeout"`
+	StorageTimeout                       int64                                `json:"storageTimeout"`
+	MinQueryDuration                     int64                                `json:"minQueryDuration"`
+	CircuitBreakerThreshold              int                                  `json:"circuitBreakerThreshold"`
+	CircuitBreakerCooldown               int64                                `json:"circuitBreakerCooldown"`
+	WriteTimeout                         int64                                `json:"writeTimeout"`
+	InvalidationDebounce                 int64                                `json:"invalidationDebounce"`
+	MaxCacheValueBytes                   int                                  `json:"maxCacheValueBytes"`
+	DirtyMarkTTL                         int64                                `json:"dirtyMarkTTL"`
+	OnInvalidationFailure                config.InvalidationFailurePolicy     `json:"onInvalidationFailure"`
+	SearchCachePredicate                 bool                                 `json:"searchCachePredicate"`
+	VolatileOrderColumns                 map[string][]string                  `json:"volatileOrderColumns"`
+	VolatileOrderTTL                     int64                                `json:"volatileOrderTTL"`
+	AggregateCacheTTL                    int64                                `json:"aggregateCacheTTL"`
+	Prefetch                             bool                                 `json:"prefetch"` // whether a prefetch hook is set
+	PrefetchConcurrency                  int                                  `json:"prefetchConcurrency"`
+	HotKeyRefresh                        config.HotKeyRefresh                 `json:"hotKeyRefresh"`
+	BloomFilter                          config.BloomFilter                   `json:"bloomFilter"`
+	Verifier                             config.Verifier                      `json:"verifier"`
+	EventLogLevels                       config.EventLogLevels                `json:"eventLogLevels"`
+
+	Storage StorageSnapshot `json:"storage"`
+}
+
+// StorageSnapshot describes the storage backend, credentials are masked
+type StorageSnapshot struct {
+	Type     string                 `json:"type"`
+	Settings map[string]interface{} `json:"settings,omitempty"`
+}
+
+// ConfigSnapshot returns the effective configuration without exposing secrets,
+// so operators can confirm the deployed config matches expectations.
+func (c *Gorm2Cache) ConfigSnapshot() *ConfigSnapshot {
+	conf := c.Config
+	snapshot := &ConfigSnapshot{
+		InstanceId:                           c.InstanceId,
+		Database:                             conf.Database,
+		ShadowMode:                           conf.ShadowMode,
+		CacheLevel:                           conf.CacheLevel,
+		TableCacheLevel:                      copyTableCacheLevel(conf.TableCacheLevel),
+		Tables:                               append([]string(nil), conf.Tables...),
+		DisableTables:                        append([]string(nil), conf.DisableTables...),
+		InvalidateWhenUpdate:                 conf.InvalidateWhenUpdate,
+		PreciseSearchInvalidation:            conf.PreciseSearchInvalidation,
+		WriteStrategy:                        conf.WriteStrategy,
+		SearchInvalidation:                   conf.SearchInvalidation,
+		PrimaryCacheVersioning:               conf.PrimaryCacheVersioning,
+		AsyncWrite:                           conf.AsyncWrite,
+		AsyncWriteWorkers:                    conf.AsyncWriteWorkers,
+		AsyncWriteQueueSize:                  conf.AsyncWriteQueueSize,
+		CacheTTL:                             conf.CacheTTL,
+		TableTTL:                             copyTableInts(conf.TableTTL),
+		TTLJitter:                            conf.TTLJitter,
+		StaleWhileRevalidate:                 conf.StaleWhileRevalidate,
+		RefreshTTLOnHit:                      conf.RefreshTTLOnHit,
+		FailOnStorageError:                   conf.FailOnStorageError,
+		OnStorageError:                       conf.OnStorageError,
+		StorageErrorHandler:                  conf.StorageErrorHandler != nil,
+		Broadcaster:                          typeName(conf.Broadcaster),
+		CacheMaxItemCnt:                      conf.CacheMaxItemCnt,
+		TableCacheMaxItemCnt:                 copyTableInts(conf.TableCacheMaxItemCnt),
+		MaxCacheRows:                         conf.MaxCacheRows,
+		BatchSize:                            conf.BatchSize,
+		DisableCachePenetrationProtect:       conf.DisableCachePenetrationProtect,
+		DisableCachePenetrationProtectTables: append([]string(nil), conf.DisableCachePenetrationProtectTables...),
+		EmptyCacheTTL:                        conf.EmptyCacheTTL,
+		CacheEmptyResults:                    conf.CacheEmptyResults,
+		SoftDeleteNegativeCache:              conf.SoftDeleteNegativeCache,
+		PrimaryKeyColumns:                    copyTableColumns(conf.PrimaryKeyColumns),
+		KeyGenerator:                         conf.KeyGenerator != nil,
+		PrimaryKeyFunc:                       conf.PrimaryKeyFunc != nil,
+		SearchKeyFunc:                        conf.SearchKeyFunc != nil,
+		SQLNormalizer:                        conf.SQLNormalizer != nil,
+		DebugMode:                            conf.DebugMode,
+		EnableSingleFlight:                   conf.EnableSingleFlight,
+		SingleFlightScope:                    conf.SingleFlightScope != nil,
+		SingleFlightMaxWaiters:               conf.SingleFlightMaxWaiters,
+		SingleFlightOverflow:                 conf.SingleFlightOverflow,
+		SingleFlightLeaderError:              conf.SingleFlightLeaderError,
+		SingleFlightShareWindow:              conf.SingleFlightShareWindow,
+		DistributedSingleFlight:              conf.DistributedSingleFlight,
+		DistributedSingleFlightWait:          conf.DistributedSingleFlightWait,
+		CacheLockedReads:                     conf.CacheLockedReads,
+		CacheLockedReadsByStrength:           copyStrengths(conf.CacheLockedReadsByStrength),
+		CacheInTransaction:                   conf.CacheInTransaction,
+		InvalidateOnCommit:                   conf.InvalidateOnCommit,
+		PublishExpvar:                        conf.PublishExpvar,
+		ShardRouter:                          conf.ShardRouter != nil,
+		TenantResolver:                       conf.TenantResolver != nil,
+		Compression:                          conf.Compression,
+		CompressionThreshold:                 conf.CompressionThreshold,
+		EncryptionKey:                        redacted(conf.EncryptionKey != nil),
+		EncryptionKeyProvider:                typeName(conf.EncryptionKeyProvider),
+		ChunkSize:                            conf.ChunkSize,
+		Serializer:                           typeName(conf.Serializer),
+		CacheVersion:                         conf.CacheVersion,
+		Tracer:                               typeName(conf.Tracer),
+		Hooks:                                hookNames(conf.Hooks),
+		ReadTimeout:                          conf.ReadTimeout,
+		StorageTimeout:                       conf.StorageTimeout,
+		MinQueryDuration:                     conf.MinQueryDuration,
+		CircuitBreakerThreshold:              conf.CircuitBreakerThreshold,
+		CircuitBreakerCooldown:               conf.CircuitBreakerCooldown,
+		WriteTimeout:                         conf.WriteTimeout,
+		InvalidationDebounce:                 conf.InvalidationDebounce,
+		MaxCacheValueBytes:                   conf.MaxCacheValueBytes,
+		DirtyMarkTTL:                         conf.DirtyMarkTTL,
+		OnInvalidationFailure:                conf.OnInvalidationFailure,
+		SearchCachePredicate:                 conf.SearchCachePredicate != nil,
+		VolatileOrderColumns:                 copyTableColumns(conf.VolatileOrderColumns),
+		VolatileOrderTTL:                     conf.VolatileOrderTTL,
+		AggregateCacheTTL:                    conf.AggregateCacheTTL,
+		Prefetch:                             conf.Prefetch != nil,
+		PrefetchConcurrency:                  conf.PrefetchConcurrency,
+		HotKeyRefresh:                        conf.HotKeyRefresh,
+		BloomFilter:                          copyBloomFilter(conf.BloomFilter),
+		Verifier:                             conf.Verifier,
+		EventLogLevels:                       conf.EventLogLevels,
+		Storage: StorageSnapshot{
+			Type: fmt.Sprintf("%T", c.cache),
+		},
+	}
+	if s, ok := c.cache.(storage.Snapshotter); ok {
+		snapshot.Storage.Settings = s.Snapshot()
+	}
+	return snapshot
+}
+
+// redacted returns storage.RedactedValue for a secret set, so that snapshots tell it is set without leaking it
+func redacted(set bool) string {
+	if !set {
+		return ""
+	}
+	return storage.RedactedValue
+}
+
+func typeName(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func copyBloomFilter(bloom config.BloomFilter) config.BloomFilter {
+	bloom.Tables = append([]string(nil), bloom.Tables...)
+	return bloom
+}
+
+func copyTableColumns(tableColumns map[string][]string) map[string][]string {
+	if tableColumns == nil {
+		return nil
+	}
+	copied := make(map[string][]string, len(tableColumns))
+	for table, columns := range tableColumns {
+		copied[table] = append([]string(nil), columns...)
+	}
+	return copied
+}
+
+func copyTableInts(tableInts map[string]int64) map[string]int64 {
+	if tableInts == nil {
+		return nil
+	}
+	copied := make(map[string]int64, len(tableInts))
+	for table, value := range tableInts {
+		copied[table] = value
+	}
+	return copied
+}
+
+func copyTableCacheLevel(tableLevel map[string]config.CacheLevel) map[string]config.CacheLevel {
+	if tableLevel == nil {
+		return nil
+	}
+	copied := make(map[string]config.CacheLevel, len(tableLevel))
+	for table, level := range tableLevel {
+		copied[table] = level
+	}
+	return copied
+}
+
+func copyStrengths(strengths map[string]bool) map[string]bool {
+	if strengths == nil {
+		return nil
+	}
+	copied := make(map[string]bool, len(strengths))
+	for strength, cache := range strengths {
+		copied[strength] = cache
+	}
+	return copied
+}
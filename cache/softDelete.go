@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/joykk/gorm-cache/util"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// softDeleteClause returns the clause scoping the queries of a model soft deleted through gorm.DeletedAt
+func softDeleteClause(db *gorm.DB) (gorm.SoftDeleteQueryClause, bool) {
+	if db.Statement.Schema == nil {
+		return gorm.SoftDeleteQueryClause{}, false
+	}
+	for _, c := range db.Statement.Schema.QueryClauses {
+		if sd, ok := c.(gorm.SoftDeleteQueryClause); ok && sd.Field != nil {
+			return sd, true
+		}
+	}
+	return gorm.SoftDeleteQueryClause{}, false
+}
+
+// isSoftDeleteScope reports whether expr is the condition gorm adds to the queries of a soft deleted model,
+// e.g. "deleted_at IS NULL". It keeps the rows soft deleted out of the results, which is what the primary
+// cache holds for such models, so a lookup by primary key and it can be served by the primary cache
+func isSoftDeleteScope(db *gorm.DB, expr clause.Expression) bool {
+	if db.Statement.Unscoped {
+		return false
+	}
+	eqExpr, ok := expr.(clause.Eq)
+	if !ok {
+		return false
+	}
+	sd, ok := softDeleteClause(db)
+	return ok && getColNameFromColumn(eqExpr.Column) == sd.Field.DBName && eqExpr.Value == sd.ZeroValue
+}
+
+// isUnscopedSoftDelete reports whether the statement is an Unscoped one on a soft deleted model. Its rows may
+// be soft deleted, they are kept out of the primary cache, and its search cache keys differ from scoped ones
+func isUnscopedSoftDelete(db *gorm.DB) bool {
+	if !db.Statement.Unscoped {
+		return false
+	}
+	_, ok := softDeleteClause(db)
+	return ok
+}
+
+// unscopedSQL prefixes the sql of an Unscoped query on a soft deleted model, so that its results, holding
+// soft deleted rows, are never shared with scoped queries
+func unscopedSQL(db *gorm.DB, sql string) string {
+	if !isUnscopedSoftDelete(db) {
+		return sql
+	}
+	return "unscoped:" + sql
+}
+
+// setSoftDeleted caches the rows soft deleted by db as "record not found" in the primary cache, see
+// Config.SoftDeleteNegativeCache. Restoring them is an update, which invalidates their keys.
+func (c *Gorm2Cache) setSoftDeleted(ctx context.Context, db *gorm.DB, tableName string, primaryKeys []string) error {
+	if !c.Config.SoftDeleteNegativeCache || len(primaryKeys) == 0 || db.Statement.Unscoped {
+		return nil
+	}
+	if _, ok := softDeleteClause(db); !ok {
+		return nil
+	}
+	kvs := make([]util.Kv, 0, len(primaryKeys))
+	for _, primaryKey := range primaryKeys {
+		kvs = append(kvs, util.Kv{Key: primaryKey, Value: recordNotFound, TTL: c.emptyTTL(c.tableTTL(tableName))})
+	}
+	c.Logger.CtxInfo(ctx, "[AfterDelete] cache soft deleted primary keys %v as %v", primaryKeys, recordNotFound)
+	return c.BatchSetPrimaryKeyCache(ctx, tableName, kvs)
+}
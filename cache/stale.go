@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// reloadKey marks the queries loading from the database to cache their result whether cached or not,
+// revalidations of stale entries and warm-ups
+const reloadKey = "gorm:cache:reload"
+
+func isReload(db *gorm.DB) bool {
+	val, ok := db.Get(reloadKey)
+	if !ok {
+		return false
+	}
+	reload, _ := val.(bool)
+	return reload
+}
+
+// searchCacheValue returns the search cache value of a result of rows rows serialized as data, and the ttl
+// in ms to store it with, 0 for the ttl of the table. With Config.StaleWhileRevalidate the value records
+// when its ttl ends, as "<rows>,<unix ms>|<data>", and is stored for the stale window past it
+func (c *Gorm2Cache) searchCacheValue(tableName string, rows int64, data []byte, ttl int64) (string, int64) {
+	effectiveTTL := c.entryTTL(tableName, ttl)
+	if c.Config.StaleWhileRevalidate <= 0 || effectiveTTL <= 0 {
+		return fmt.Sprintf("%d|", rows) + string(data), ttl
+	}
+	staleAt := time.Now().UnixMilli() + effectiveTTL
+	return fmt.Sprintf("%d,%d|", rows, staleAt) + string(data), effectiveTTL + c.Config.StaleWhileRevalidate
+}
+
+// parseSearchCacheValue splits a search cache value into its rows, the unix ms it turns stale at (0 if it
+// doesn't) and its serialized result
+func parseSearchCacheValue(value string) (rows int64, staleAt int64, data string, err error) {
+	pos := strings.Index(value, "|")
+	if pos < 0 {
+		return 0, 0, "", fmt.Errorf("invalid search cache value %q", value)
+	}
+	head, data := value[:pos], value[pos+1:]
+	if comma := strings.Index(head, ","); comma >= 0 {
+		if staleAt, err = strconv.ParseInt(head[comma+1:], 10, 64); err != nil {
+			return 0, 0, "", err
+		}
+		head = head[:comma]
+	}
+	if rows, err = strconv.ParseInt(head, 10, 64); err != nil {
+		return 0, 0, "", err
+	}
+	return rows, staleAt, data, nil
+}
+
+// revalidate runs the query of a search cache hit served stale again in background, so that its result
+// replaces the stale one. Revalidations skip the cache read and run outside the transaction and deadline
+// of the hit query, at most one per key at a time.
+func (c *Gorm2Cache) revalidate(db *gorm.DB, tableName string, sql string) {
+	if isReload(db) || isCacheOnly(db) {
+		return
+	}
+	key := c.searchCacheKey(c.instanceId(db.Statement.Context), tableName, sql, db.Statement.Vars...)
+	if _, running := c.revalidating.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+
+	query := reloadQuery(db)
+	dest := reflect.New(reflect.Indirect(reflect.ValueOf(db.Statement.Dest)).Type()).Interface()
+	go func() {
+		defer c.revalidating.Delete(key)
+		if err := query.Find(dest).Error; err != nil {
+			c.Logger.CtxError(query.Statement.Context, "[revalidate] revalidate stale search cache of sql %s error: %v", sql, err)
+		}
+	}()
+}
+
+// reloadQuery returns a copy of a hit query loading from the database to cache its result again, outside
+// the transaction and deadline of the hit query
+func reloadQuery(db *gorm.DB) *gorm.DB {
+	query := db.Session(&gorm.Session{Context: detachedContext{db.Statement.Context}})
+	// the copy carries the SearchCacheHit of the hit query, which would keep it from running
+	query.Error = nil
+	// the copy keeps the rendered SQL of the hit query, it must be built again from the clauses
+	query.Statement.SQL.Reset()
+	query.Statement.Vars = nil
+	query.Statement.ConnPool = db.Config.ConnPool
+	return query.Set(reloadKey, true)
+}
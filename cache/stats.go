@@ -1,53 +1,389 @@
 package cache
 
-import "sync/atomic"
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/joykk/gorm-cache/util"
+)
 
 type StatsAccessor interface {
 	HitCount() uint64
 	MissCount() uint64
 	LookupCount() uint64
 	HitRate() float64
+	HitCounts() HitCounts
+	TableStats(tableName string) TableStats
+	ResetCounter(counter Counter)
+}
+
+// HitCounts the hits broken down by how they were served, they add up to HitCount
+type HitCounts struct {
+	Primary        uint64 `json:"primary"`        // rows served from the primary cache
+	Search         uint64 `json:"search"`         // results served from the search cache
+	SingleFlight   uint64 `json:"singleFlight"`   // results shared by the load of a concurrent identical query
+	RecordNotFound uint64 `json:"recordNotFound"` // lookups answered by a cached "record not found"
+	Request        uint64 `json:"request"`        // results served from the request cache, see WithRequestCache
+}
+
+// hitKind how a hit was served, see HitCounts
+type hitKind int
+
+const (
+	hitPrimary hitKind = iota
+	hitSearch
+	hitSingleFlight
+	hitRecordNotFound
+	hitRequest
+)
+
+// hitKindOf returns how a hit was served by the error BeforeQuery left the query with
+func hitKindOf(err error) hitKind {
+	switch {
+	case errors.Is(err, util.SingleFlightHit):
+		return hitSingleFlight
+	case errors.Is(err, util.PrimaryCacheHit):
+		return hitPrimary
+	case errors.Is(err, util.RecordNotFoundCacheHit):
+		return hitRecordNotFound
+	case errors.Is(err, util.RequestCacheHit):
+		return hitRequest
+	default:
+		return hitSearch
+	}
+}
+
+// Counter a counter of the cache, reset by ResetCounter
+type Counter int
+
+const (
+	// CounterLookups the hits, along with their breakdown, and the misses, reset at once so that the hit rate
+	// never mixes counts from before and after. The hits and misses of each table are reset too
+	CounterLookups Counter = iota
+	CounterInvalidations
+	CounterErrors
+	CounterDroppedWrites
+	CounterSkippedTooLarge
+	CounterShadowDivergences
+	CounterBloomFilterRejects
+	// CounterVerifications the hits verified by config.CacheConfig.Verifier, along with their divergences
+	CounterVerifications
+	CounterLockedReadBypasses
+)
+
+// cacheKind the cache, primary or search, the counts of a table are broken down by
+type cacheKind int
+
+const (
+	kindPrimary cacheKind = iota
+	kindSearch
+)
+
+// TableStats the counts of a table broken down by cache
+type TableStats struct {
+	Primary KindStats `json:"primary"`
+	Search  KindStats `json:"search"`
+}
+
+// KindStats the counts of a cache of a table. Lookups of queries falling through to the database count
+// as misses of the search cache, unless the table is only cached by primary key
+type KindStats struct {
+	HitCount          uint64 `json:"hitCount"`
+	MissCount         uint64 `json:"missCount"`
+	SetCount          uint64 `json:"setCount"`
+	InvalidationCount uint64 `json:"invalidationCount"`
 }
 
 // statistics
 type stats struct {
+	lookups atomic.Value // of *lookupCounts, replaced as a whole on reset
+	tables  sync.Map     // table name to *tableCounts
+
+	invalidationCount uint64
+	errorCount        uint64
+	droppedWriteCount uint64
+	skippedTooLarge   uint64
+	shadowDivergence  uint64
+	bloomRejects      uint64
+	verified          uint64
+	verifyDivergence  uint64
+	lockedBypasses    uint64
+}
+
+// lookupCounts the hits and misses counted since the last reset
+type lookupCounts struct {
 	hitCount  uint64
 	missCount uint64
+	hitKinds  [5]uint64 // by hitKind, only counted for the lookups of the instance
+}
+
+// tableCounts the counts of a table, by cache kind
+type tableCounts [2]kindCounts
+
+type kindCounts struct {
+	lookups           atomic.Value // of *lookupCounts, replaced as a whole on reset
+	setCount          uint64
+	invalidationCount uint64
+}
+
+func loadLookupCounts(lookups *atomic.Value) *lookupCounts {
+	if counts, ok := lookups.Load().(*lookupCounts); ok {
+		return counts
+	}
+	lookups.CompareAndSwap(nil, &lookupCounts{})
+	return lookups.Load().(*lookupCounts)
+}
+
+func (st *stats) lookupCounts() *lookupCounts {
+	return loadLookupCounts(&st.lookups)
+}
+
+func (st *stats) kindCounts(tableName string, kind cacheKind) *kindCounts {
+	counts, ok := st.tables.Load(tableName)
+	if !ok {
+		counts, _ = st.tables.LoadOrStore(tableName, &tableCounts{})
+	}
+	return &counts.(*tableCounts)[kind]
 }
 
+// ResetHitCount resets the hit and miss counts at once, the hit rate never mixes counts from before and after.
+// The hits and misses of each table are reset too
 func (st *stats) ResetHitCount() {
-	atomic.StoreUint64(&st.hitCount, 0)
-	atomic.StoreUint64(&st.missCount, 0)
+	st.lookups.Store(&lookupCounts{})
+	st.tables.Range(func(_, counts interface{}) bool {
+		for kind := range counts.(*tableCounts) {
+			counts.(*tableCounts)[kind].lookups.Store(&lookupCounts{})
+		}
+		return true
+	})
+}
+
+// countTableLookup counts a hit or miss of a cache of the table
+func (st *stats) countTableLookup(tableName string, kind cacheKind, hit bool) {
+	counts := loadLookupCounts(&st.kindCounts(tableName, kind).lookups)
+	if hit {
+		atomic.AddUint64(&counts.hitCount, 1)
+	} else {
+		atomic.AddUint64(&counts.missCount, 1)
+	}
+}
+
+// countTableSets counts entries written to a cache of the table
+func (st *stats) countTableSets(tableName string, kind cacheKind, n int) {
+	atomic.AddUint64(&st.kindCounts(tableName, kind).setCount, uint64(n))
+}
+
+// countTableInvalidation counts an invalidation of a cache of the table
+func (st *stats) countTableInvalidation(tableName string, kind cacheKind) {
+	atomic.AddUint64(&st.kindCounts(tableName, kind).invalidationCount, 1)
+}
+
+// TableStats returns the counts of the table, zero for tables never cached
+func (st *stats) TableStats(tableName string) TableStats {
+	counts, ok := st.tables.Load(tableName)
+	if !ok {
+		return TableStats{}
+	}
+	return counts.(*tableCounts).stats()
+}
+
+// tableStats returns the counts of every table counted so far
+func (st *stats) tableStats() map[string]TableStats {
+	tables := make(map[string]TableStats)
+	st.tables.Range(func(tableName, counts interface{}) bool {
+		tables[tableName.(string)] = counts.(*tableCounts).stats()
+		return true
+	})
+	return tables
+}
+
+func (counts *tableCounts) stats() TableStats {
+	return TableStats{
+		Primary: counts[kindPrimary].stats(),
+		Search:  counts[kindSearch].stats(),
+	}
+}
+
+func (counts *kindCounts) stats() KindStats {
+	lookups := loadLookupCounts(&counts.lookups)
+	return KindStats{
+		HitCount:          atomic.LoadUint64(&lookups.hitCount),
+		MissCount:         atomic.LoadUint64(&lookups.missCount),
+		SetCount:          atomic.LoadUint64(&counts.setCount),
+		InvalidationCount: atomic.LoadUint64(&counts.invalidationCount),
+	}
 }
 
 // IncrHitCount increase hit count
 func (st *stats) IncrHitCount() uint64 {
-	return atomic.AddUint64(&st.hitCount, 1)
+	return atomic.AddUint64(&st.lookupCounts().hitCount, 1)
+}
+
+// countHit counts a hit served by kind
+func (st *stats) countHit(kind hitKind) {
+	counts := st.lookupCounts()
+	atomic.AddUint64(&counts.hitKinds[kind], 1)
+	atomic.AddUint64(&counts.hitCount, 1)
 }
 
-// IncrMissCount increase miss count
+// IncrMissCount increase miss count, a lookup falling through to the database
 func (st *stats) IncrMissCount() uint64 {
-	return atomic.AddUint64(&st.missCount, 1)
+	return atomic.AddUint64(&st.lookupCounts().missCount, 1)
+}
+
+// IncrInvalidationCount increase invalidation count
+func (st *stats) IncrInvalidationCount() uint64 {
+	return atomic.AddUint64(&st.invalidationCount, 1)
+}
+
+// IncrErrorCount increase storage error count
+func (st *stats) IncrErrorCount() uint64 {
+	return atomic.AddUint64(&st.errorCount, 1)
+}
+
+// IncrDroppedWriteCount increase count of the async cache writes dropped
+func (st *stats) IncrDroppedWriteCount() uint64 {
+	return atomic.AddUint64(&st.droppedWriteCount, 1)
+}
+
+// IncrSkippedTooLargeCount increase count of the values not cached for their size or rows
+func (st *stats) IncrSkippedTooLargeCount() uint64 {
+	return atomic.AddUint64(&st.skippedTooLarge, 1)
+}
+
+// IncrShadowDivergenceCount increase count of the lookups of config.CacheConfig.ShadowMode diverging from the database
+func (st *stats) IncrShadowDivergenceCount() uint64 {
+	return atomic.AddUint64(&st.shadowDivergence, 1)
+}
+
+// IncrBloomFilterRejectCount increase count of the lookups config.CacheConfig.BloomFilter answered
+func (st *stats) IncrBloomFilterRejectCount() uint64 {
+	return atomic.AddUint64(&st.bloomRejects, 1)
+}
+
+// IncrVerifiedCount increase count of the hits config.CacheConfig.Verifier compared with the database
+func (st *stats) IncrVerifiedCount() uint64 {
+	return atomic.AddUint64(&st.verified, 1)
+}
+
+// IncrVerifyDivergenceCount increase count of the hits config.CacheConfig.Verifier found diverging from the database
+func (st *stats) IncrVerifyDivergenceCount() uint64 {
+	return atomic.AddUint64(&st.verifyDivergence, 1)
+}
+
+// IncrLockedReadBypassCount increase count of the locking reads which bypassed the cache
+func (st *stats) IncrLockedReadBypassCount() uint64 {
+	return atomic.AddUint64(&st.lockedBypasses, 1)
 }
 
 // HitCount returns hit count
 func (st *stats) HitCount() uint64 {
-	return atomic.LoadUint64(&st.hitCount)
+	return atomic.LoadUint64(&st.lookupCounts().hitCount)
 }
 
 // MissCount returns miss count
 func (st *stats) MissCount() uint64 {
-	return atomic.LoadUint64(&st.missCount)
+	return atomic.LoadUint64(&st.lookupCounts().missCount)
+}
+
+// InvalidationCount returns how many invalidations were issued to the storage
+func (st *stats) InvalidationCount() uint64 {
+	return atomic.LoadUint64(&st.invalidationCount)
+}
+
+// ErrorCount returns how many storage operations failed
+func (st *stats) ErrorCount() uint64 {
+	return atomic.LoadUint64(&st.errorCount)
+}
+
+// DroppedWriteCount returns how many async cache writes were dropped, their queue being full
+func (st *stats) DroppedWriteCount() uint64 {
+	return atomic.LoadUint64(&st.droppedWriteCount)
+}
+
+// SkippedTooLargeCount returns how many query results, or rows, weren't cached for being larger than
+// Config.MaxCacheValueBytes or having more rows than CacheMaxItemCnt
+func (st *stats) SkippedTooLargeCount() uint64 {
+	return atomic.LoadUint64(&st.skippedTooLarge)
+}
+
+// ShadowDivergenceCount returns how many results the cache would have served under config.CacheConfig.ShadowMode
+// differed from those of the database
+func (st *stats) ShadowDivergenceCount() uint64 {
+	return atomic.LoadUint64(&st.shadowDivergence)
+}
+
+// BloomFilterRejectCount returns how many primary key lookups config.CacheConfig.BloomFilter answered with
+// "record not found", their keys not being in the table. They count as hits too
+func (st *stats) BloomFilterRejectCount() uint64 {
+	return atomic.LoadUint64(&st.bloomRejects)
+}
+
+// VerifiedCount returns how many sampled hits config.CacheConfig.Verifier compared with the database
+func (st *stats) VerifiedCount() uint64 {
+	return atomic.LoadUint64(&st.verified)
+}
+
+// VerifyDivergenceCount returns how many of the hits config.CacheConfig.Verifier compared served another result
+// than the database
+func (st *stats) VerifyDivergenceCount() uint64 {
+	return atomic.LoadUint64(&st.verifyDivergence)
+}
+
+// LockedReadBypassCount returns how many locking reads (e.g. SELECT ... FOR UPDATE) of cached tables neither read
+// nor wrote the cache, see config.CacheConfig.CacheLockedReads
+func (st *stats) LockedReadBypassCount() uint64 {
+	return atomic.LoadUint64(&st.lockedBypasses)
 }
 
 // LookupCount returns lookup count
 func (st *stats) LookupCount() uint64 {
-	return st.HitCount() + st.MissCount()
+	counts := st.lookupCounts()
+	return atomic.LoadUint64(&counts.hitCount) + atomic.LoadUint64(&counts.missCount)
+}
+
+// HitCounts returns the hits broken down by how they were served
+func (st *stats) HitCounts() HitCounts {
+	counts := st.lookupCounts()
+	return HitCounts{
+		Primary:        atomic.LoadUint64(&counts.hitKinds[hitPrimary]),
+		Search:         atomic.LoadUint64(&counts.hitKinds[hitSearch]),
+		SingleFlight:   atomic.LoadUint64(&counts.hitKinds[hitSingleFlight]),
+		RecordNotFound: atomic.LoadUint64(&counts.hitKinds[hitRecordNotFound]),
+		Request:        atomic.LoadUint64(&counts.hitKinds[hitRequest]),
+	}
+}
+
+// ResetCounter resets a counter, leaving the others as they are
+func (st *stats) ResetCounter(counter Counter) {
+	switch counter {
+	case CounterLookups:
+		st.ResetHitCount()
+	case CounterInvalidations:
+		atomic.StoreUint64(&st.invalidationCount, 0)
+	case CounterErrors:
+		atomic.StoreUint64(&st.errorCount, 0)
+	case CounterDroppedWrites:
+		atomic.StoreUint64(&st.droppedWriteCount, 0)
+	case CounterSkippedTooLarge:
+		atomic.StoreUint64(&st.skippedTooLarge, 0)
+	case CounterShadowDivergences:
+		atomic.StoreUint64(&st.shadowDivergence, 0)
+	case CounterBloomFilterRejects:
+		atomic.StoreUint64(&st.bloomRejects, 0)
+	case CounterVerifications:
+		atomic.StoreUint64(&st.verified, 0)
+		atomic.StoreUint64(&st.verifyDivergence, 0)
+	case CounterLockedReadBypasses:
+		atomic.StoreUint64(&st.lockedBypasses, 0)
+	}
 }
 
 // HitRate returns rate for cache hitting
 func (st *stats) HitRate() float64 {
-	hc, mc := st.HitCount(), st.MissCount()
+	counts := st.lookupCounts()
+	hc, mc := atomic.LoadUint64(&counts.hitCount), atomic.LoadUint64(&counts.missCount)
 	total := hc + mc
 	if total == 0 {
 		return 0.0
@@ -0,0 +1,176 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	"gorm.io/gorm"
+)
+
+const tagKey = "gorm:cache:tag"
+
+// WithTag returns a session whose queries carry a logical tag, the tag becomes part of the search cache key
+// and all search cache entries carrying it can be dropped at once by InvalidateByTag.
+// Tags only apply to the search cache, rows served from the primary cache are not tagged.
+func WithTag(db *gorm.DB, tag string) *gorm.DB {
+	return WithTags(db, tag)
+}
+
+// WithTags returns a session whose queries carry the tags, in addition to those db carries already,
+// e.g. "user:123" and "tenant:acme", so that InvalidateByTag on any of them drops their search cache entries
+func WithTags(db *gorm.DB, tags ...string) *gorm.DB {
+	all := make([]string, 0)
+	for _, tag := range append(getTags(db), tags...) {
+		if tag != "" && !util.ContainString(tag, all) {
+			all = append(all, tag)
+		}
+	}
+	sort.Strings(all)
+	return db.Set(tagKey, all).Session(&gorm.Session{})
+}
+
+func getTags(db *gorm.DB) []string {
+	var tags []string
+	if val, ok := db.Get(tagKey); ok {
+		tags, _ = val.([]string)
+	}
+	contextTags := contextSettings(db).Tags
+	if len(contextTags) == 0 {
+		return tags
+	}
+	all := append([]string(nil), tags...)
+	for _, tag := range contextTags {
+		if tag != "" && !util.ContainString(tag, all) {
+			all = append(all, tag)
+		}
+	}
+	sort.Strings(all)
+	return all
+}
+
+// taggedSQL folds the query tags into the sql used for search cache key generation
+func taggedSQL(tags []string, sql string) string {
+	for idx := len(tags) - 1; idx >= 0; idx-- {
+		sql = "tag:" + tags[idx] + ":" + sql
+	}
+	return sql
+}
+
+// addTagIndex records cacheKey, expiring in ttl ms (0 for never), in the tag -> keys reverse index kept in
+// the storage. Storages implementing storage.SetStore keep it as a set added to atomically, the others as a
+// value rewritten by each instance under its own lock
+func (c *Gorm2Cache) addTagIndex(ctx context.Context, tag string, cacheKey string, ttl int64) error {
+	if setStore, ok := c.storageFor(ctx).(storage.SetStore); ok {
+		return setStore.AddToSet(ctx, c.keys.TagSetKey(c.instanceId(ctx), tag), []string{cacheKey}, ttl)
+	}
+
+	c.tagMu.Lock()
+	defer c.tagMu.Unlock()
+
+	index, err := c.getTagIndex(ctx, tag)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UnixMilli()
+	index[cacheKey] = 0
+	if ttl > 0 {
+		index[cacheKey] = now + ttl
+	}
+	indexTTL := pruneIndex(index, now)
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return c.storageFor(ctx).SetKey(ctx, util.Kv{
+		Key:   c.keys.TagIndexKey(c.instanceId(ctx), tag),
+		Value: string(data),
+		TTL:   indexTTL,
+	})
+}
+
+// getTagIndex returns the cache keys of the tag to the unix ms they expire at. Indexes written as a list of
+// keys by earlier versions are read as keys never expiring
+func (c *Gorm2Cache) getTagIndex(ctx context.Context, tag string) (map[string]int64, error) {
+	index := make(map[string]int64)
+	value, err := c.storageFor(ctx).GetValue(ctx, c.keys.TagIndexKey(c.instanceId(ctx), tag))
+	if err != nil {
+		if errors.Is(err, storage.ErrCacheNotFound) {
+			return index, nil
+		}
+		return nil, err
+	}
+	if strings.HasPrefix(value, "[") {
+		keys := make([]string, 0)
+		if err = json.Unmarshal([]byte(value), &keys); err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			index[key] = 0
+		}
+		return index, nil
+	}
+	if err = json.Unmarshal([]byte(value), &index); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// InvalidateByTag drops all search cache entries cached with the given tag
+func (c *Gorm2Cache) InvalidateByTag(ctx context.Context, tag string) error {
+	c.tagMu.Lock()
+	defer c.tagMu.Unlock()
+
+	// the value index is read on set stores too, it may have been written before they kept sets
+	index, err := c.getTagIndex(ctx, tag)
+	if err != nil {
+		return c.countError(ctx, err)
+	}
+	keys := make([]string, 0, len(index)+2)
+	for key := range index {
+		keys = append(keys, key)
+	}
+	keys = append(keys, c.keys.TagIndexKey(c.instanceId(ctx), tag))
+	if setStore, ok := c.storageFor(ctx).(storage.SetStore); ok {
+		members, err := setStore.SetMembers(ctx, c.keys.TagSetKey(c.instanceId(ctx), tag))
+		if err != nil {
+			return c.countError(ctx, err)
+		}
+		keys = append(keys, members...)
+		keys = append(keys, c.keys.TagSetKey(c.instanceId(ctx), tag))
+	}
+	c.IncrInvalidationCount()
+	for _, chunk := range util.Chunk(keys, c.Config.BatchSize) {
+		if err = c.countError(ctx, c.storageFor(ctx).BatchDeleteKeys(ctx, chunk)); err != nil {
+			return err
+		}
+	}
+	c.observeInvalidation(ctx, "", keys...)
+	return nil
+}
+
+// indexTaggedSearchCache records the search cache entry written for db with ttl in its tag indexes, if the query is tagged
+func (c *Gorm2Cache) indexTaggedSearchCache(ctx context.Context, db *gorm.DB, ttl int64, tableName string, sql string,
+	vars ...interface{}) {
+	c.indexSearchCacheTags(ctx, getTags(db), ttl, tableName, sql, vars...)
+}
+
+// indexSearchCacheTags records a search cache entry written with ttl in the index of each tag. An index lives
+// as long as its last entry
+func (c *Gorm2Cache) indexSearchCacheTags(ctx context.Context, tags []string, ttl int64, tableName string, sql string,
+	vars ...interface{}) {
+	if len(tags) == 0 {
+		return
+	}
+	ttl = c.entryTTL(tableName, ttl)
+	cacheKey := c.searchCacheKey(c.instanceId(ctx), tableName, sql, vars...)
+	for _, tag := range tags {
+		if err := c.countError(ctx, c.addTagIndex(ctx, tag, cacheKey, ttl)); err != nil {
+			c.Logger.CtxError(ctx, "[indexTaggedSearchCache] add search cache for sql %s to tag %s index error: %v", sql, tag, err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	"gorm.io/gorm"
+)
+
+type tenantContextKey struct{}
+
+// WithTenant returns a session whose queries and writes use the cache of the tenant: its keys are namespaced
+// apart from those of the other tenants, and writes only invalidate the entries of the tenant. An empty tenant
+// uses the keys of the instance, see Config.TenantResolver
+func WithTenant(db *gorm.DB, tenantID string) *gorm.DB {
+	return db.WithContext(ContextWithTenant(db.Statement.Context, tenantID))
+}
+
+// ContextWithTenant returns a context carrying the tenant like WithTenant, for the calls taking a context,
+// e.g. InvalidateSearchCache
+func ContextWithTenant(ctx context.Context, tenantID string) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, tenantContextKey{}, tenantID)
+}
+
+// tenant returns the tenant of ctx, set by WithTenant or else resolved by Config.TenantResolver
+func (c *Gorm2Cache) tenant(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	if tenantID, ok := ctx.Value(tenantContextKey{}).(string); ok {
+		return tenantID
+	}
+	if c.Config.TenantResolver != nil {
+		return c.Config.TenantResolver(ctx)
+	}
+	return ""
+}
+
+// instanceId returns the instance id the keys of ctx are built under, namespaced by its tenant if any
+func (c *Gorm2Cache) instanceId(ctx context.Context) string {
+	if tenantID := c.tenant(ctx); tenantID != "" {
+		return util.TenantInstanceId(c.InstanceId, tenantID)
+	}
+	return c.InstanceId
+}
+
+// InvalidateTenant drops all the entries cached for the tenant, of every table
+func (c *Gorm2Cache) InvalidateTenant(ctx context.Context, tenantID string) error {
+	c.broadcast(ctx, storage.Invalidation{Kind: storage.InvalidateTenant, Tenant: tenantID})
+	return c.invalidateTenant(ctx, tenantID)
+}
+
+func (c *Gorm2Cache) invalidateTenant(ctx context.Context, tenantID string) error {
+	if tenantID == "" {
+		return nil
+	}
+	// the tenant may be routed to a storage of its own
+	ctx = ContextWithTenant(ctx, tenantID)
+	c.IncrInvalidationCount()
+	deleteCtx, cancel := c.storageContext(ctx)
+	defer cancel()
+	// ended by the separator, storages matching prefixes as is would drop the tenants whose id starts with it
+	prefix := c.keys.Prefix(c.instanceId(ctx)) + ":"
+	return c.countError(ctx, c.storageFor(deleteCtx).DeleteKeysWithPrefix(deleteCtx, prefix))
+}
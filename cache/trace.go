@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+)
+
+// span names of the traced cache operations
+const (
+	spanQuery             = "gorm-cache.query"
+	spanPrimaryGet        = "gorm-cache.primary.get"
+	spanPrimarySet        = "gorm-cache.primary.set"
+	spanPrimaryInvalidate = "gorm-cache.primary.invalidate"
+	spanSearchGet         = "gorm-cache.search.get"
+	spanSearchSet         = "gorm-cache.search.set"
+	spanSearchInvalidate  = "gorm-cache.search.invalidate"
+)
+
+// noopSpan is started when no Config.Tracer is set
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, interface{}) {}
+func (noopSpan) RecordError(error)                {}
+func (noopSpan) End()                             {}
+
+// startSpan starts a span of Config.Tracer for an operation on tableName, timed for Hooks.OnOperation if set
+func (c *Gorm2Cache) startSpan(ctx context.Context, name string, tableName string) (context.Context, config.Span) {
+	var span config.Span = noopSpan{}
+	if c.Config.Tracer != nil {
+		ctx, span = c.Config.Tracer.Start(ctx, name)
+		span.SetAttribute("gorm-cache.table", tableName)
+	}
+	if c.Config.Hooks.OnOperation == nil {
+		return ctx, span
+	}
+	return ctx, &timedSpan{Span: span, cache: c, ctx: ctx, name: name, table: tableName, start: time.Now()}
+}
+
+// timedSpan reports the time from its start to its end to Hooks.OnOperation
+type timedSpan struct {
+	config.Span
+	cache *Gorm2Cache
+	ctx   context.Context
+	name  string
+	table string
+	start time.Time
+	err   error
+}
+
+func (s *timedSpan) RecordError(err error) {
+	s.err = err
+	s.Span.RecordError(err)
+}
+
+func (s *timedSpan) End() {
+	s.Span.End()
+	elapsed := time.Since(s.start)
+	hook := s.cache.Config.Hooks.OnOperation
+	s.cache.runHook(s.ctx, "OnOperation", func() { hook(s.ctx, s.name, s.table, elapsed, s.err) })
+}
+
+// endSpan ends a span, recording err unless it is a miss
+func endSpan(span config.Span, err error) {
+	if err != nil && !errors.Is(err, storage.ErrCacheNotFound) {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// queryOutcome names how the cache served a query by the error BeforeQuery left it with
+func queryOutcome(err error) string {
+	switch {
+	case errors.Is(err, util.SingleFlightHit):
+		return "single_flight_hit"
+	case errors.Is(err, util.PrimaryCacheHit):
+		return "primary_hit"
+	case errors.Is(err, util.SearchCacheHit):
+		return "search_hit"
+	case errors.Is(err, util.RecordNotFoundCacheHit):
+		return "not_found_hit"
+	case errors.Is(err, util.RequestCacheHit):
+		return "request_hit"
+	case err != nil:
+		return "error"
+	default:
+		return "miss"
+	}
+}
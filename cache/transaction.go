@@ -0,0 +1,150 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"github.com/joykk/gorm-cache/config"
+	"gorm.io/gorm"
+)
+
+// shouldCacheInTransaction reports whether the query may use the cache as far as transactions are concerned,
+// queries outside of one always may. Within a transaction they only may with CacheInTransaction.
+func (c *Gorm2Cache) shouldCacheInTransaction(db *gorm.DB) bool {
+	return c.Config.CacheInTransaction || !inTransaction(db)
+}
+
+// inTransaction reports if the statement runs in a transaction, e.g. of db.Transaction or db.Begin
+func inTransaction(db *gorm.DB) bool {
+	_, ok := db.Statement.ConnPool.(gorm.TxCommitter)
+	return ok
+}
+
+// invalidateOnCommit reports whether the invalidations of the writes within a transaction wait for it to
+// commit, with Config.InvalidateOnCommit. They can't with InvalidationFailureFailWrite, whose failures roll the
+// transaction back
+func (c *Gorm2Cache) invalidateOnCommit() bool {
+	return c.Config.InvalidateOnCommit && c.Config.OnInvalidationFailure != config.InvalidationFailureFailWrite
+}
+
+// afterCommit defers callback, invalidating the cache for a write, until the transaction the write runs in
+// commits, and drops it if the transaction rolls back: invalidating earlier would let other readers cache
+// the rows again before the write is visible to them. Writes outside of a transaction run it at once.
+func (c *Gorm2Cache) afterCommit(callback func(db *gorm.DB)) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		tx := txOf(db)
+		if tx == nil {
+			callback(db)
+			return
+		}
+		tx.onCommit(func() {
+			callback(db)
+		})
+	}
+}
+
+// txOf returns the transaction begun through txPool the statement runs in, nil if none
+func txOf(db *gorm.DB) *bufferedTx {
+	pool := db.Statement.ConnPool
+	if prepared, ok := pool.(*gorm.PreparedStmtTX); ok {
+		pool = prepared.Tx
+	}
+	tx, _ := pool.(*bufferedTx)
+	return tx
+}
+
+// txPool wraps the connection pool of the db the cache is initialized on, for the transactions begun
+// through it, either by db.Transaction, db.Begin or the default transaction of a write, to buffer the
+// invalidations of their writes
+type txPool struct {
+	gorm.ConnPool
+}
+
+func (p *txPool) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	var (
+		tx  gorm.ConnPool
+		err error
+	)
+	switch beginner := p.ConnPool.(type) {
+	case gorm.TxBeginner:
+		tx, err = beginner.BeginTx(ctx, opts)
+	case gorm.ConnPoolBeginner:
+		tx, err = beginner.BeginTx(ctx, opts)
+	default:
+		return nil, gorm.ErrInvalidTransaction
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &bufferedTx{ConnPool: tx}, nil
+}
+
+// GetDBConn implements gorm.GetDBConnector, so that db.DB() still returns the underlying *sql.DB
+func (p *txPool) GetDBConn() (*sql.DB, error) {
+	return sqlDB(p.ConnPool)
+}
+
+// bufferedTx is a transaction running the callbacks registered by afterCommit once it commits
+type bufferedTx struct {
+	gorm.ConnPool
+
+	mu        sync.Mutex
+	callbacks []func()
+}
+
+func (tx *bufferedTx) onCommit(callback func()) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	tx.callbacks = append(tx.callbacks, callback)
+}
+
+// takeCallbacks returns the callbacks registered so far, in registration order, and forgets them
+func (tx *bufferedTx) takeCallbacks() []func() {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	callbacks := tx.callbacks
+	tx.callbacks = nil
+	return callbacks
+}
+
+// Commit commits the transaction and runs its callbacks. They run even if the commit fails, whose outcome
+// isn't known, an extra invalidation is harmless while a missing one leaves stale entries
+func (tx *bufferedTx) Commit() error {
+	committer, ok := tx.ConnPool.(gorm.TxCommitter)
+	if !ok {
+		return gorm.ErrInvalidTransaction
+	}
+	err := committer.Commit()
+	for _, callback := range tx.takeCallbacks() {
+		callback()
+	}
+	return err
+}
+
+func (tx *bufferedTx) Rollback() error {
+	tx.takeCallbacks()
+	committer, ok := tx.ConnPool.(gorm.TxCommitter)
+	if !ok {
+		return gorm.ErrInvalidTransaction
+	}
+	return committer.Rollback()
+}
+
+// StmtContext implements gorm.Tx, so that prepared statement sessions can run in the transaction
+func (tx *bufferedTx) StmtContext(ctx context.Context, stmt *sql.Stmt) *sql.Stmt {
+	if inner, ok := tx.ConnPool.(gorm.Tx); ok {
+		return inner.StmtContext(ctx, stmt)
+	}
+	return stmt
+}
+
+// GetDBConn implements gorm.GetDBConnector, so that db.DB() still returns the underlying *sql.DB
+func (tx *bufferedTx) GetDBConn() (*sql.DB, error) {
+	return sqlDB(tx.ConnPool)
+}
+
+// sqlDB returns the *sql.DB of a connection pool the way db.DB() does
+func sqlDB(pool gorm.ConnPool) (*sql.DB, error) {
+	return (&gorm.DB{Config: &gorm.Config{ConnPool: pool}}).DB()
+}
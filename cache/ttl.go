@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const ttlKey = "gorm:cache:ttl"
+
+// WithTTL returns a session whose query results are cached for ttl, taking precedence
+// over the table and global ttl. A ttl not above 0 is ignored.
+func WithTTL(db *gorm.DB, ttl time.Duration) *gorm.DB {
+	return db.Set(ttlKey, ttl).Session(&gorm.Session{})
+}
+
+func getTTL(db *gorm.DB) time.Duration {
+	if val, ok := db.Get(ttlKey); ok {
+		if ttl, _ := val.(time.Duration); ttl > 0 {
+			return ttl
+		}
+	}
+	return contextSettings(db).TTL
+}
+
+// queryTTL returns the ttl in ms to cache the results of the query with (0 for the storage's, i.e. CacheTTL),
+// whether they may be search cached and the reason for both. The precedence is
+// per-query option (WithTTL), then AggregateCacheTTL for aggregate queries, then per-table config
+// (VolatileOrderColumns, TableTTL), then the global CacheTTL. Queries loading fields tagged gormCache:"-" are
+// never search cached.
+func (c *Gorm2Cache) queryTTL(db *gorm.DB, tableName string) (ttl int64, searchCacheable bool, reason string) {
+	if selectsUncachedFields(db) {
+		return 0, false, "per-query: selects fields tagged gormCache:\"-\", not search cached"
+	}
+	if queryTTL := getTTL(db); queryTTL > 0 {
+		return queryTTL.Milliseconds(), true, "per-query: WithTTL option"
+	}
+	if c.Config.AggregateCacheTTL > 0 && isAggregateQuery(db) {
+		return c.Config.AggregateCacheTTL, true, "global: aggregate query, AggregateCacheTTL"
+	}
+	if column := c.volatileOrderColumn(db, tableName); column != "" {
+		if c.Config.VolatileOrderTTL <= 0 {
+			return 0, false, fmt.Sprintf("per-table: ordered by volatile column %s of %s, not search cached",
+				column, tableName)
+		}
+		return c.Config.VolatileOrderTTL, true, fmt.Sprintf("per-table: ordered by volatile column %s of %s, VolatileOrderTTL",
+			column, tableName)
+	}
+	if ttl := c.tableTTL(tableName); ttl > 0 {
+		return ttl, true, fmt.Sprintf("per-table: TableTTL of %s", tableName)
+	}
+	if c.Config.CacheTTL <= 0 {
+		return 0, true, "global: CacheTTL is 0, never expires"
+	}
+	return 0, true, "global: CacheTTL"
+}
+
+// tableTTL returns the ttl in ms configured for the table by TableTTL, 0 if it uses CacheTTL
+func (c *Gorm2Cache) tableTTL(tableName string) int64 {
+	if ttl := c.Config.TableTTL[tableName]; ttl > 0 {
+		return ttl
+	}
+	return 0
+}
+
+// entryTTL returns the ttl in ms an entry of the table written with ttl lives for: ttl, else the TableTTL of
+// the table, else CacheTTL. 0 means it never expires
+func (c *Gorm2Cache) entryTTL(tableName string, ttl int64) int64 {
+	if ttl > 0 {
+		return ttl
+	}
+	if ttl = c.tableTTL(tableName); ttl > 0 {
+		return ttl
+	}
+	return c.Config.CacheTTL
+}
+
+// EffectiveTTL reports the ttl the results of the query built by db would be cached with, and the reason
+// naming its source: per-query option, per-table config or global default. 0 with a per-table reason means
+// the query is not search cached. Storages may still jitter the ttl. The query is not executed.
+func EffectiveTTL(c Cache, db *gorm.DB) (time.Duration, string) {
+	gormCache, ok := c.(*Gorm2Cache)
+	if !ok {
+		return 0, fmt.Sprintf("unsupported cache %T", c)
+	}
+	if db.Statement.Schema == nil && db.Statement.Table == "" {
+		model := db.Statement.Model
+		if model == nil {
+			model = db.Statement.Dest
+		}
+		if model != nil {
+			_ = db.Statement.Parse(model)
+		}
+	}
+
+	ttl, searchCacheable, reason := gormCache.queryTTL(db, getTableName(db))
+	if !searchCacheable {
+		return 0, reason
+	}
+	if ttl == 0 {
+		ttl = gormCache.Config.CacheTTL
+	}
+	return time.Duration(ttl) * time.Millisecond, reason
+}
@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"unsafe"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/modern-go/reflect2"
+)
+
+// typeHandler converts values of a registered type from and to the bytes cached for them
+type typeHandler struct {
+	typ    reflect.Type
+	encode func(ptr unsafe.Pointer) ([]byte, error)
+	decode func(data []byte, ptr unsafe.Pointer) error
+}
+
+var (
+	typeHandlersMu sync.RWMutex
+	typeHandlers   = make(map[reflect.Type]*typeHandler)
+)
+
+func init() {
+	json.RegisterExtension(&typeExtension{})
+}
+
+// RegisterType makes the default serializer serialize values of type T with encode and decode, for types of model fields
+// that don't serialize faithfully as they are, e.g. a geometry scanned from the binary format of a driver
+// whose fields are unexported. The encoded bytes are cached as a base64 string, an error encoding a value
+// leaves the result uncached. Types must be registered before they are cached, registering one twice panics.
+func RegisterType[T any](encode func(value T) ([]byte, error), decode func(data []byte) (T, error)) {
+	if encode == nil || decode == nil {
+		panic("cache: register type handler is nil")
+	}
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+
+	typeHandlersMu.Lock()
+	defer typeHandlersMu.Unlock()
+	if _, dup := typeHandlers[typ]; dup {
+		panic("cache: register type called twice for " + typ.String())
+	}
+	typeHandlers[typ] = &typeHandler{
+		typ: typ,
+		encode: func(ptr unsafe.Pointer) ([]byte, error) {
+			return encode(*(*T)(ptr))
+		},
+		decode: func(data []byte, ptr unsafe.Pointer) error {
+			value, err := decode(data)
+			if err != nil {
+				return err
+			}
+			*(*T)(ptr) = value
+			return nil
+		},
+	}
+}
+
+func getTypeHandler(typ reflect.Type) *typeHandler {
+	typeHandlersMu.RLock()
+	defer typeHandlersMu.RUnlock()
+	return typeHandlers[typ]
+}
+
+// typeExtension hands the registered types to their handlers
+type typeExtension struct {
+	jsoniter.DummyExtension
+}
+
+func (e *typeExtension) CreateEncoder(typ reflect2.Type) jsoniter.ValEncoder {
+	if handler := getTypeHandler(typ.Type1()); handler != nil {
+		return &typeEncoder{handler: handler}
+	}
+	return nil
+}
+
+func (e *typeExtension) CreateDecoder(typ reflect2.Type) jsoniter.ValDecoder {
+	if handler := getTypeHandler(typ.Type1()); handler != nil {
+		return &typeDecoder{handler: handler}
+	}
+	return nil
+}
+
+type typeEncoder struct {
+	handler *typeHandler
+}
+
+func (e *typeEncoder) IsEmpty(ptr unsafe.Pointer) bool {
+	return false
+}
+
+func (e *typeEncoder) Encode(ptr unsafe.Pointer, stream *jsoniter.Stream) {
+	data, err := e.handler.encode(ptr)
+	if err != nil {
+		stream.Error = fmt.Errorf("encode %s: %w", e.handler.typ, err)
+		return
+	}
+	stream.WriteVal(data)
+}
+
+type typeDecoder struct {
+	handler *typeHandler
+}
+
+func (d *typeDecoder) Decode(ptr unsafe.Pointer, iter *jsoniter.Iterator) {
+	var data []byte
+	iter.ReadVal(&data)
+	if iter.Error != nil {
+		return
+	}
+	if err := d.handler.decode(data, ptr); err != nil {
+		iter.ReportError("decode "+d.handler.typ.String(), err.Error())
+	}
+}
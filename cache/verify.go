@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+
+	"github.com/joykk/gorm-cache/util"
+	"gorm.io/gorm"
+)
+
+// staleHitKey marks the hits served stale under Config.StaleWhileRevalidate, which the database may have moved on from
+const staleHitKey = "gorm:cache:stale_hit"
+
+// verify runs the query of a sample of the hits served by the storage against the database in background,
+// counting the results differing from the cached one, see Config.Verifier. Hits whose table is invalidated
+// before the database answers aren't compared, the cached result may have been right when it was served
+func (c *Gorm2Cache) verify(db *gorm.DB, tableName string) {
+	if db.Error != util.PrimaryCacheHit && db.Error != util.SearchCacheHit && db.Error != util.RecordNotFoundCacheHit {
+		return
+	}
+	if stale, _ := db.InstanceGet(staleHitKey); stale == true {
+		return
+	}
+	if isReload(db) || isPrefetch(db) || rand.Float64() >= c.Config.Verifier.SampleRate {
+		return
+	}
+	destType := reflect.TypeOf(db.Statement.Dest)
+	if destType == nil || destType.Kind() != reflect.Pointer {
+		return
+	}
+	notFound := db.Error == util.RecordNotFoundCacheHit
+	var cached [][]byte
+	if !notFound {
+		values, err := c.shadowValues(db.Statement.Dest)
+		if err != nil {
+			return
+		}
+		cached = values
+	}
+	select {
+	case c.verifySlots <- struct{}{}:
+	default:
+		return
+	}
+
+	invalidations := c.tableInvalidations(tableName)
+	query := reloadQuery(db).Set(noCacheKey, true)
+	sqlObj, _ := db.InstanceGet("gorm:cache:sql")
+	sql, _ := sqlObj.(string)
+	dest := reflect.New(destType.Elem()).Interface()
+	go func() {
+		defer func() { <-c.verifySlots }()
+		ctx := query.Statement.Context
+		result := query.Find(dest)
+		// the copy of a First raises gorm.ErrRecordNotFound like the hit query
+		if result.Error != nil && !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			c.Logger.CtxError(ctx, "[verify] verify cache hit of sql %s error: %v", sql, result.Error)
+			return
+		}
+		if c.tableInvalidations(tableName) != invalidations {
+			return
+		}
+		diverged := result.RowsAffected > 0
+		if !notFound {
+			values, err := c.shadowValues(dest)
+			if err != nil {
+				return
+			}
+			diverged = !equalValues(values, cached)
+		}
+		c.IncrVerifiedCount()
+		if diverged {
+			c.IncrVerifyDivergenceCount()
+			c.Logger.CtxError(ctx, "[verify] cached result of sql %s of table %s diverged from the database",
+				sql, tableName)
+		}
+	}()
+}
+
+// tableInvalidations returns how many times the caches of the table were invalidated so far
+func (c *Gorm2Cache) tableInvalidations(tableName string) uint64 {
+	counts := c.TableStats(tableName)
+	return counts.Primary.InvalidationCount + counts.Search.InvalidationCount
+}
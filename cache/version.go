@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/util"
+)
+
+// searchVersionTTLFactor the versions of config.SearchInvalidationVersion live this many times the ttl of the
+// entries of their table, a version expiring only costs a miss of the entries cached under it
+const searchVersionTTLFactor = 10
+
+// versionedSearch reports whether writes bump the search version of their table rather than delete its keys
+func (c *Gorm2Cache) versionedSearch() bool {
+	return c.Config.SearchInvalidation == config.SearchInvalidationVersion
+}
+
+// versionedSQL folds the search versions of the tables into the sql used for search cache key generation, as
+// taggedSQL folds tags, so that bumping the version of any of them leaves the entries of the query unreachable.
+// Versions not in the storage, never bumped or expired, are started anew
+func (c *Gorm2Cache) versionedSQL(ctx context.Context, tables []string, sql string) (string, error) {
+	if !c.versionedSearch() || len(tables) == 0 {
+		return sql, nil
+	}
+	keys := make([]string, 0, len(tables))
+	for _, table := range tables {
+		keys = append(keys, c.keys.SearchVersionKey(c.instanceId(ctx), table))
+	}
+	readCtx, cancel := c.readContext(ctx)
+	versions, err := c.storageFor(readCtx).BatchGetValues(readCtx, keys)
+	cancel()
+	if err = c.countError(ctx, err); err != nil {
+		return "", err
+	}
+	for idx := len(tables) - 1; idx >= 0; idx-- {
+		version := versions[idx]
+		if version == "" {
+			// a version started at a value no key was cached under, entries of an expired one can't come back
+			if version, err = c.bumpSearchVersion(ctx, tables[idx]); err != nil {
+				return "", err
+			}
+		}
+		sql = "version:" + tables[idx] + "=" + version + ":" + sql
+	}
+	return sql, nil
+}
+
+// bumpSearchVersion moves the search version of the table to a new value, returning it
+func (c *Gorm2Cache) bumpSearchVersion(ctx context.Context, tableName string) (string, error) {
+	version := strconv.FormatInt(time.Now().UnixNano(), 36)
+	ttl := c.entryTTL(tableName, 0) * searchVersionTTLFactor
+	writeCtx, cancel := c.writeContext(ctx)
+	defer cancel()
+	err := c.storageFor(writeCtx).SetKey(writeCtx, util.Kv{
+		Key:   c.keys.SearchVersionKey(c.instanceId(ctx), tableName),
+		Value: version,
+		TTL:   ttl,
+	})
+	return version, c.countError(ctx, err)
+}
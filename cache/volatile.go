@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"strings"
+
+	"github.com/joykk/gorm-cache/util"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// volatileOrderColumn returns the first column the query orders by that is volatile for the table, "" if none
+func (c *Gorm2Cache) volatileOrderColumn(db *gorm.DB, tableName string) string {
+	volatileColumns := c.Config.VolatileOrderColumns[tableName]
+	if len(volatileColumns) == 0 {
+		return ""
+	}
+	for _, column := range getOrderColumns(db) {
+		if util.ContainString(column, volatileColumns) {
+			return column
+		}
+	}
+	return ""
+}
+
+// getOrderColumns returns the unquoted names of the columns in the ORDER BY clause,
+// e.g. Order("`users`.`updated_at` DESC, id") yields updated_at and id
+func getOrderColumns(db *gorm.DB) []string {
+	cla, ok := db.Statement.Clauses["ORDER BY"]
+	if !ok {
+		return nil
+	}
+	orderBy, ok := cla.Expression.(clause.OrderBy)
+	if !ok {
+		return nil
+	}
+	columns := make([]string, 0, len(orderBy.Columns))
+	for _, column := range orderBy.Columns {
+		if column.Column.Raw {
+			columns = append(columns, columnsFromOrderExpr(column.Column.Name)...)
+		} else {
+			columns = append(columns, column.Column.Name)
+		}
+	}
+	if orderBy.Expression != nil {
+		if expr, ok := orderBy.Expression.(clause.Expr); ok {
+			columns = append(columns, columnsFromOrderExpr(expr.SQL)...)
+		}
+	}
+	return columns
+}
+
+func columnsFromOrderExpr(expr string) []string {
+	columns := make([]string, 0)
+	for _, part := range strings.Split(expr, ",") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		column := fields[0]
+		if idx := strings.LastIndex(column, "."); idx >= 0 {
+			column = column[idx+1:]
+		}
+		columns = append(columns, strings.Trim(column, "`\"[]"))
+	}
+	return columns
+}
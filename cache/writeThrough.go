@@ -0,0 +1,130 @@
+package cache
+
+import (
+	"context"
+	"reflect"
+	"sort"
+
+	"github.com/joykk/gorm-cache/config"
+	"gorm.io/gorm"
+)
+
+// writesThrough reports whether the rows written by db may be cached in place of their primary cache entries,
+// see config.WriteThrough. Within a transaction of the application the rows may still change before the
+// commit the write is run at, only the default transaction gorm begins for the statement commits right away
+func (c *Gorm2Cache) writesThrough(db *gorm.DB) bool {
+	if c.Config.WriteStrategy != config.WriteThrough || isUnscopedSoftDelete(db) || isPartialSelect(db) {
+		return false
+	}
+	if inTransaction(db) {
+		started, _ := db.InstanceGet("gorm:started_transaction")
+		return started == true
+	}
+	return true
+}
+
+// createdRowsKnown reports whether the rows created by db hold what the database stored: an upsert may have
+// kept or merged an existing row, and columns left to sql defaults are only known once read back
+func createdRowsKnown(db *gorm.DB) bool {
+	if _, ok := db.Statement.Clauses["ON CONFLICT"]; ok {
+		return false
+	}
+	destValue := reflect.Indirect(reflect.ValueOf(db.Statement.Dest))
+	if !isModelDest(db, destValue) {
+		return false
+	}
+	values := []reflect.Value{destValue}
+	if destValue.Kind() == reflect.Slice || destValue.Kind() == reflect.Array {
+		values = values[:0]
+		for i := 0; i < destValue.Len(); i++ {
+			values = append(values, reflect.Indirect(destValue.Index(i)))
+		}
+	}
+	for _, field := range db.Statement.Schema.Fields {
+		if !field.HasDefaultValue || field.DefaultValueInterface != nil || field.PrimaryKey {
+			continue
+		}
+		for _, value := range values {
+			if _, isZero := field.ValueOf(db.Statement.Context, value); isZero {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isWholeRowSave reports whether db updates whole rows of its model from itself, as db.Save does, the model
+// then holds the row as stored
+func isWholeRowSave(db *gorm.DB) bool {
+	stmt := db.Statement
+	if len(stmt.Selects) != 1 || stmt.Selects[0] != "*" || stmt.Schema == nil {
+		return false
+	}
+	destValue := reflect.ValueOf(stmt.Dest)
+	if destValue.Kind() != reflect.Ptr || destValue.Elem().Kind() != reflect.Struct {
+		return false
+	}
+	return stmt.Dest == stmt.Model && destValue.Elem().Type() == stmt.Schema.ModelType
+}
+
+// writeThrough caches the rows written by db in place of the primary cache entries of primaryKeys, reporting
+// whether it did. The entries are invalidated first, so that other instances drop theirs, queries which read
+// the rows before the write can't cache them under PrimaryCacheVersioning, and no entry is left behind for
+// rows too large to be cached
+func (c *Gorm2Cache) writeThrough(db *gorm.DB, tableName string, primaryKeys []string,
+	failures *invalidationFailures) bool {
+	if !isModelDest(db, reflect.Indirect(reflect.ValueOf(db.Statement.Dest))) {
+		return false
+	}
+	objectKeys, objects := c.getObjectsAfterLoad(db)
+	if len(objectKeys) != len(objects) || !sameKeys(objectKeys, primaryKeys) || c.hasSoftDeleted(db, objects) {
+		return false
+	}
+	write := c.primaryCacheWrite(db, tableName, objectKeys, objects)
+	if write == nil {
+		return false
+	}
+
+	ctx := db.Statement.Context
+	c.Logger.CtxInfo(ctx, "[writeThrough] now start to write through cache for primary keys: %v", objectKeys)
+	if err := c.BatchInvalidatePrimaryCache(ctx, tableName, objectKeys); err != nil {
+		failures.add(err, func(ctx context.Context) error {
+			return c.BatchInvalidatePrimaryCache(ctx, tableName, objectKeys)
+		})
+		c.Logger.CtxError(ctx, "[writeThrough] invalidating cache for primary keys: %v error: %v", objectKeys, err)
+		return true
+	}
+	// the entries are gone already, a failed write only costs the next lookup a miss
+	_ = write(ctx)
+	return true
+}
+
+// hasSoftDeleted reports whether some of the objects are soft deleted, which lookups don't find
+func (c *Gorm2Cache) hasSoftDeleted(db *gorm.DB, objects []interface{}) bool {
+	sd, ok := softDeleteClause(db)
+	if !ok {
+		return false
+	}
+	for _, object := range objects {
+		if _, isZero := sd.Field.ValueOf(db.Statement.Context, reflect.Indirect(reflect.ValueOf(object))); !isZero {
+			return true
+		}
+	}
+	return false
+}
+
+// sameKeys reports whether a and b hold the same primary keys
+func sameKeys(a []string, b []string) bool {
+	a, b = uniqueStringSlice(a), uniqueStringSlice(b)
+	if len(a) != len(b) {
+		return false
+	}
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"context"
+	"sync"
+)
+
+const (
+	defaultAsyncWriteWorkers   = 4
+	defaultAsyncWriteQueueSize = 1024
+)
+
+// asyncWrite a cache write of Config.AsyncWrite, its value already serialized
+type asyncWrite struct {
+	ctx   context.Context
+	write func(ctx context.Context) error
+}
+
+// asyncWriter runs the cache writes of Config.AsyncWrite on a fixed number of workers
+// fed by a bounded queue, so that a slow storage can't pile up goroutines
+type asyncWriter struct {
+	mu     sync.RWMutex
+	closed bool
+	queue  chan asyncWrite
+	wg     sync.WaitGroup
+}
+
+func newAsyncWriter(workers int, queueSize int) *asyncWriter {
+	if workers <= 0 {
+		workers = defaultAsyncWriteWorkers
+	}
+	if queueSize <= 0 {
+		queueSize = defaultAsyncWriteQueueSize
+	}
+	w := &asyncWriter{queue: make(chan asyncWrite, queueSize)}
+	w.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer w.wg.Done()
+			for write := range w.queue {
+				// failures are logged and counted by the write itself
+				_ = write.write(write.ctx)
+			}
+		}()
+	}
+	return w
+}
+
+// submit queues a write, it returns false if the queue is full or the writer closed
+func (w *asyncWriter) submit(write asyncWrite) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return false
+	}
+	select {
+	case w.queue <- write:
+		return true
+	default:
+		return false
+	}
+}
+
+// depth returns how many writes are waiting for a worker
+func (w *asyncWriter) depth() int {
+	return len(w.queue)
+}
+
+// close stops accepting writes and waits for the queued ones to finish
+func (w *asyncWriter) close() {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return
+	}
+	w.closed = true
+	close(w.queue)
+	w.mu.Unlock()
+	w.wg.Wait()
+}
+
+// writeAsync hands a cache write over to the background workers, outside the deadline of the query.
+// The write is dropped rather than waited for when the queue is full.
+func (c *Gorm2Cache) writeAsync(ctx context.Context, write func(ctx context.Context) error) {
+	if c.writer.submit(asyncWrite{ctx: detachedContext{ctx}, write: write}) {
+		return
+	}
+	c.IncrDroppedWriteCount()
+	c.Logger.CtxInfo(ctx, "[writeAsync] write queue is full or closed, cache write dropped")
+}
+
+// WriteQueueDepth returns how many cache writes of AsyncWrite are waiting for a worker, always 0 without
+// AsyncWrite. A depth staying close to AsyncWriteQueueSize means writes are about to be dropped
+func (c *Gorm2Cache) WriteQueueDepth() int {
+	if c.writer == nil {
+		return 0
+	}
+	return c.writer.depth()
+}
@@ -0,0 +1,69 @@
+// Command gormcache-server serves a storage of gorm-cache over http to the storage.Remote of other services,
+// so that they share one cache without holding the credentials of its redis, e.g.
+//
+//	GORMCACHE_TOKEN=secret REDIS_PASSWORD=... go run ./cmd/gormcache-server -listen :8420 -redis localhost:6379
+//
+// Without -redis the cache is kept in the memory of the server. The token and the redis password are read from
+// the environment so that they don't show in the process list.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	"github.com/redis/go-redis/v9"
+)
+
+func main() {
+	listen := flag.String("listen", ":8420", "address to serve the remote cache on")
+	redisAddr := flag.String("redis", "", "address of the redis to cache in, the memory of the server if empty")
+	redisDB := flag.Int("redis-db", 0, "redis database to cache in")
+	keyPrefix := flag.String("key-prefix", util.GormCachePrefix, "prefix of the keys in redis")
+	ttl := flag.Int64("ttl", 60000, "ttl in ms of the keys the clients write without one")
+	debug := flag.Bool("debug", false, "log the operations of the storage")
+	flag.Parse()
+
+	var store storage.DataStorage
+	if *redisAddr != "" {
+		client := redis.NewClient(&redis.Options{Addr: *redisAddr, Password: os.Getenv("REDIS_PASSWORD"), DB: *redisDB})
+		defer client.Close()
+		store = storage.NewRedis(&storage.RedisStoreConfig{Client: client, KeyPrefix: *keyPrefix})
+	} else {
+		store = storage.NewMemSync(nil)
+	}
+	token := os.Getenv("GORMCACHE_TOKEN")
+	if token == "" {
+		fmt.Fprintln(os.Stderr, "gormcache-server: GORMCACHE_TOKEN is not set, requests are not authenticated")
+	}
+
+	server := storage.NewRemoteServer(&storage.RemoteServerConfig{Storage: store, Token: token})
+	if err := server.Init(&storage.Config{TTL: *ttl, Debug: *debug, Logger: &util.DefaultLogger{}}); err != nil {
+		fail(err)
+	}
+
+	httpServer := &http.Server{Addr: *listen, Handler: server, ReadHeaderTimeout: 10 * time.Second}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = httpServer.Shutdown(shutdownCtx)
+	}()
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "gormcache-server:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,77 @@
+package compress
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Codec compresses cache values, implementations must be safe for concurrent use
+type Codec interface {
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+const (
+	Gzip   = "gzip"
+	Zstd   = "zstd"
+	Snappy = "snappy"
+)
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = make(map[string]Codec)
+)
+
+// Register makes a codec available by name, the name is stored with every value
+// compressed by the codec, so it must not change once values are cached.
+// If Register is called twice with the same name or if codec is nil, it panics.
+func Register(name string, codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	if codec == nil {
+		panic("compress: register codec is nil")
+	}
+	if err := validName(name); err != nil {
+		panic("compress: " + err.Error())
+	}
+	if _, dup := codecs[name]; dup {
+		panic("compress: register called twice for codec " + name)
+	}
+	codecs[name] = codec
+}
+
+// Get returns the codec registered with name
+func Get(name string) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	codec, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("compress: unknown codec %q (forgotten register?)", name)
+	}
+	return codec, nil
+}
+
+// Codecs returns a sorted list of the names of the registered codecs
+func Codecs() []string {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func validName(name string) error {
+	if name == "" {
+		return fmt.Errorf("codec name is empty")
+	}
+	for _, r := range name {
+		if r == HeaderSeparator {
+			return fmt.Errorf("codec name %q contains the header separator", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package compress
+
+import (
+	"fmt"
+	"strings"
+)
+
+// HeaderSeparator delimits the codec name in front of a compressed value,
+// it never appears in the JSON values cached uncompressed.
+const HeaderSeparator = '\x1f'
+
+// Encode compresses value with the named codec and prefixes it with the codec header
+func Encode(name string, value string) (string, error) {
+	codec, err := Get(name)
+	if err != nil {
+		return "", err
+	}
+	data, err := codec.Compress([]byte(value))
+	if err != nil {
+		return "", fmt.Errorf("compress: %s compress error: %w", name, err)
+	}
+	return string(HeaderSeparator) + name + string(HeaderSeparator) + string(data), nil
+}
+
+// Decode decompresses value with the codec named in its header,
+// values without a header were cached uncompressed and are returned as is.
+func Decode(value string) (string, error) {
+	if len(value) == 0 || value[0] != HeaderSeparator {
+		return value, nil
+	}
+	end := strings.IndexRune(value[1:], HeaderSeparator)
+	if end < 0 {
+		return "", fmt.Errorf("compress: malformed value header")
+	}
+	name := value[1 : end+1]
+	codec, err := Get(name)
+	if err != nil {
+		return "", err
+	}
+	data, err := codec.Decompress([]byte(value[end+2:]))
+	if err != nil {
+		return "", fmt.Errorf("compress: %s decompress error: %w", name, err)
+	}
+	return string(data), nil
+}
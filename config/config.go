@@ -1,20 +1,46 @@
 package config
 
 import (
+	"context"
+	"time"
+
 	"github.com/joykk/gorm-cache/storage"
 	"github.com/joykk/gorm-cache/util"
+	"gorm.io/gorm"
 )
 
 type CacheConfig struct {
 	// CacheLevel there are 2 types of cache and 4 kinds of cache option
 	CacheLevel CacheLevel
 
+	// TableCacheLevel cache level of each table, keyed by table name, overriding CacheLevel, e.g. only the
+	// primary cache for a table searched by ever changing filters. Tables not listed use CacheLevel
+	TableCacheLevel map[string]CacheLevel
+
 	// CacheStorage choose proper storage medium
 	CacheStorage storage.DataStorage
 
-	// Tables only cache data within given data tables (cache all if empty)
+	// ShadowMode if true, queries are always served by the database while the cache runs as it would otherwise:
+	// lookups are counted as hits and misses (see HitRate) without serving their results, which are compared with
+	// those of the database, counting the divergent ones by ShadowDivergenceCount, and results are cached. It
+	// measures the hit rate and the correctness of the cache before it serves queries, e.g. on rollout. Storage
+	// errors never fail queries, whatever OnStorageError
+	ShadowMode bool
+
+	// InstanceId namespaces the keys of the cache in the storage, random if empty so that each instance has
+	// keys of its own. Processes sharing a storage and an InstanceId share their cache entries, and drop each
+	// other's on invalidation
+	InstanceId string
+
+	// Database name of the database cached, scoping the keys of the instance within its InstanceId as
+	// "<InstanceId>.<Database>", so that instances caching databases with identical table names may share a
+	// storage and an InstanceId without serving each other's rows. Empty leaves the keys unscoped
+	Database string
+
+	// Tables only cache data within given data tables (cache all if empty).
+	// Entries may be globs matching a family of tables, e.g. orders_*
 	Tables []string
-	// DisableTables 设置黑名单不缓存的表
+	// DisableTables 设置黑名单不缓存的表，可使用 orders_* 这样的通配符，优先于 Tables
 	DisableTables []string
 
 	// InvalidateWhenUpdate
@@ -22,34 +48,527 @@ type CacheConfig struct {
 	// else we do nothing to outdated cache.
 	InvalidateWhenUpdate bool
 
-	// AsyncWrite if true, then we will write cache in async mode
+	// WriteStrategy how the writes of InvalidateWhenUpdate update the primary cache of the rows they write,
+	// invalidating them by default, see WriteStrategy
+	WriteStrategy WriteStrategy
+
+	// PreciseSearchInvalidation if true, updates and deletes of rows by primary key only invalidate the search
+	// cache entries whose results contained those rows, looked up in an index kept next to the search cache,
+	// instead of the whole search cache of the table. Creates, writes whose primary keys aren't known and
+	// results that can't be tied to rows (Pluck, Count, empty results...) still invalidate as before.
+	// The tradeoff: a row updated so that it starts matching a cached search doesn't show up there until
+	// the entry expires. Ignored with RefreshTTLOnHit, as refreshed entries would outlive their index.
+	// Storages implementing storage.SetStore (the redis ones) keep the index as sets added to atomically,
+	// shared safely by the processes of a storage; on the others it is rewritten under a lock of the process,
+	// and entries indexed concurrently by other processes sharing the storage may be lost
+	PreciseSearchInvalidation bool
+
+	// SearchInvalidation how a write drops the search cache of its table, deleting its keys by default,
+	// see SearchInvalidationStrategy
+	SearchInvalidation SearchInvalidationStrategy
+
+	// PrimaryCacheVersioning if true, a query doesn't cache rows a concurrent write invalidated while it read them
+	// from the database: writes bump the versions of their rows, and the rows loaded by a query are only written if
+	// none of them moved past the version read before the query. It costs a read of the storage per query, and only
+	// takes effect on storages implementing storage.Versioner (Memory, MemSync, Redis and the wrappers of them)
+	PrimaryCacheVersioning bool
+
+	// AsyncWrite if true, then we will write cache in async mode: query results are serialized right away and
+	// written by a pool of AsyncWriteWorkers background workers, reads stay synchronous. Writes are dropped
+	// when AsyncWriteQueueSize writes are already waiting, call Close to flush the queued ones on shutdown
 	AsyncWrite bool
 
+	// AsyncWriteWorkers number of workers running the cache writes of AsyncWrite. 0 represents 4
+	AsyncWriteWorkers int
+
+	// AsyncWriteQueueSize number of cache writes of AsyncWrite waiting for a worker, further ones are
+	// dropped. 0 represents 1024
+	AsyncWriteQueueSize int
+
 	// CacheTTL cache ttl in ms, where 0 represents forever
 	CacheTTL int64
 
+	// TableTTL cache ttl in ms of each table, keyed by table name, e.g. longer for rarely changing tables
+	// and shorter for busy ones. Tables not listed, or listed with a ttl not above 0, use CacheTTL
+	TableTTL map[string]int64
+
+	// TTLJitter fraction of the ttl by which the expiry of each cached key is randomized either way,
+	// e.g. 0.2 expires keys between 80% and 120% of their ttl, so that keys written together don't
+	// expire together. 0 keeps the default of the storage, ±10% for the memory, gcache and redis storages
+	TTLJitter float64
+
+	// StaleWhileRevalidate stale window in ms of the search cache: entries are kept this long past their ttl,
+	// and a query hitting an entry past its ttl is served the stale result right away while it runs again in
+	// background to refresh the entry, so popular queries don't wait on the database when their entry expires.
+	// 0 expires entries at their ttl. Entries cached forever, "record not found" results and RawScan results
+	// are never stale
+	StaleWhileRevalidate int64
+
+	// RefreshTTLOnHit if true, a query hitting the cache restarts the ttl of the keys it hit, so hot keys
+	// don't expire. Storages refresh the expiry without rewriting the values (storage.Expirer), NATS can't
+	RefreshTTLOnHit bool
+
+	// FailOnStorageError if true, a query fails with util.ErrCacheStorage when the storage fails to read or
+	// write its cache, as with StorageErrorFailClosed
+	//
+	// Deprecated: set OnStorageError to StorageErrorFailClosed
+	FailOnStorageError bool
+
+	// OnStorageError what a query does when the storage fails to read or write its cache (e.g. redis is
+	// unreachable), see StorageErrorPolicy. Reads timing out after ReadTimeout are misses either way
+	OnStorageError StorageErrorPolicy
+
+	// StorageErrorHandler if set, is called with every failed storage operation and the number of operations
+	// failed in a row, reset by the next success, e.g. to page once failures persist rather than on a blip.
+	// It runs on the path of the query, whatever OnStorageError, so it should return quickly
+	StorageErrorHandler func(ctx context.Context, err error, consecutive int64)
+
+	// Broadcaster if set, invalidations are broadcast to the other instances caching the same database,
+	// which run them on their own keys, e.g. storage.NewRedisBroadcaster or storage.NewNatsBroadcaster.
+	// Leave it nil for a single instance.
+	// Broadcast invalidations are run on CacheStorage, not on storages routed by ShardRouter
+	Broadcaster storage.Broadcaster
+
 	// CacheMaxItemCnt for given query, if objects retrieved are more than this cnt,
 	// then we choose not to cache for this query. 0 represents caching all queries.
 	CacheMaxItemCnt int64
 
+	// TableCacheMaxItemCnt CacheMaxItemCnt of each table, keyed by table name, e.g. lower for tables of large
+	// rows. Tables not listed use CacheMaxItemCnt, 0 caches all queries of the table
+	TableCacheMaxItemCnt map[string]int64
+
+	// MaxCacheRows most rows a search query may return to be written to the search cache, results of more rows
+	// are served to the caller but not cached, and counted by SkippedTooLargeCount. It holds along with
+	// CacheMaxItemCnt, the lower of both applies. 0 represents no limit
+	MaxCacheRows int
+
+	// MaxCacheValueBytes most bytes of a serialized query result, or of a row for the primary cache, larger
+	// ones aren't cached, e.g. to keep multi-megabyte blobs out of redis. Results skipped for their size or
+	// their rows (see CacheMaxItemCnt) are counted by SkippedTooLargeCount. 0 caches values of any size
+	MaxCacheValueBytes int
+
+	// KeyGenerator builds the namespace and the query part of all the storage keys of the instance, e.g.
+	// util.PrefixKeyGenerator("myapp") to prefix them by the app instead of "gormcache", so that instances or
+	// apps sharing a storage never collide. Defaults to util.DefaultKeyGenerator
+	KeyGenerator util.KeyGenerator
+
+	// PrimaryKeyFunc builds the storage keys of the primary cache, e.g. adding a tenant or app version.
+	// Keys must start with the primary cache prefix of their table (util.Keys.PrimaryCachePrefix of
+	// KeyGenerator) and ":", for the table to be invalidated. Defaults to util.Keys.PrimaryCacheKey
+	PrimaryKeyFunc util.PrimaryKeyFunc
+
+	// PrimaryKeyColumns overrides, by table name, the columns rows are keyed by in the primary cache, which
+	// default to the primary key fields of the gorm schema. The values of several columns are joined by
+	// util.JoinPrimaryKey. Models lacking one of the columns aren't cached by primary key
+	PrimaryKeyColumns map[string][]string
+
+	// SearchKeyFunc builds the storage keys of the search cache, keys must start with the search cache prefix
+	// of their table (util.Keys.SearchCachePrefix of KeyGenerator) and ":". Defaults to util.Keys.SearchCacheKey
+	SearchKeyFunc util.SearchKeyFunc
+
+	// SQLNormalizer rewrites queries before their search cache keys are built, so that queries differing only in
+	// their formatting or the order of their IN lists share an entry. Defaults to util.DefaultSQLNormalizer,
+	// util.NoopSQLNormalizer keys queries as they are. Not used by SearchKeyFunc
+	SQLNormalizer util.SQLNormalizer
+
+	// BatchSize most primary keys read, written or invalidated in one storage call, larger batches are split
+	// into calls made one after another, e.g. to keep redis commands small. 0 makes a single call
+	BatchSize int
+
 	// DisableCachePenetration if true, then we will not cache nil result
 	DisableCachePenetrationProtect bool
 
+	// DisableCachePenetrationProtectTables tables whose "record not found" results are not cached, e.g. tables
+	// whose rows are inserted without going through gorm. Entries may be globs like DisableTables
+	DisableCachePenetrationProtectTables []string
+
+	// EmptyCacheTTL ttl in ms of the cached "record not found" results protecting from cache penetration,
+	// and of the empty results cached with CacheEmptyResults, usually shorter than CacheTTL so rows
+	// inserted without going through gorm show up soon. 0 caches them as long as found results
+	EmptyCacheTTL int64
+
+	// CacheEmptyResults if true, search queries matching no row, e.g. Find into a slice, cache their empty
+	// result and later identical queries are served the empty result with util.SearchCacheHit. Unlike the
+	// "record not found" results of First/Take, empty results aren't cached if false
+	CacheEmptyResults bool
+
+	// SoftDeleteNegativeCache if true, rows soft deleted through gorm.DeletedAt are cached as "record not found"
+	// in the primary cache, so that lookups of their primary key don't reach the database until they are
+	// restored. Queries with Unscoped never use the primary cache of soft deleted models
+	SoftDeleteNegativeCache bool
+
 	// DebugMode indicate if we're in debug mode (will print access log)
 	DebugMode bool
 
-	// DebugLogger
+	// DebugLogger logs the access log of DebugMode and the errors of the cache, see util.NewZapLogger,
+	// util.NewSlogLogger and util.NewGormLogger for adapters of other loggers. DefaultLogger if nil
 	DebugLogger util.LoggerInterface
 
-	// EnableSingleFlight if true, we will query first local memory cache
+	// EventLogLevels levels the hits, misses and invalidations are logged at with their table and keys, to a
+	// DebugLogger implementing util.StructuredLogger, whether in DebugMode or not
+	EventLogLevels EventLogLevels
+
+	// EnableSingleFlight if true, concurrent identical queries share one load from the cache or database,
+	// off by default. Queries are identical when they have the same search cache key (see SearchKeyFunc),
+	// i.e. the same table, SQL and vars. The waiters receive a copy of the rows loaded, or the error of
+	// the load unless SingleFlightLeaderError says otherwise
 	EnableSingleFlight bool
+
+	// SingleFlightScope if set, returns what in the context of a query affects its result without showing in its SQL,
+	// e.g. the tenant of a request served from its own database. Concurrent identical queries share a load only
+	// within the same scope, as they only do on the same connection pool and storage of ShardRouter.
+	SingleFlightScope func(ctx context.Context) string
+
+	// SingleFlightMaxWaiters caps the queries waiting on one single flight load, 0 represents no cap.
+	// Queries beyond it are handled according to SingleFlightOverflow
+	SingleFlightMaxWaiters int
+
+	// SingleFlightOverflow what queries beyond SingleFlightMaxWaiters do, loading on their own by default
+	SingleFlightOverflow SingleFlightOverflowPolicy
+
+	// SingleFlightLeaderError what the waiters of a failed single flight load do, failing with its error by default
+	SingleFlightLeaderError SingleFlightLeaderErrorPolicy
+
+	// SingleFlightShareWindow ms the result of a single flight load keeps being shared after it completes, with the
+	// identical queries coming meanwhile, sparing a burst of them even the cache lookup. 0 shares it with the
+	// queries waiting on the load only. Failed loads aren't shared, and writes through the instance to a table
+	// the result reads stop sharing it. Queries waiting on a load give up on their own context deadline anyway
+	SingleFlightShareWindow int64
+
+	// DistributedSingleFlight if true, a query missing the search cache takes a lock in the storage before
+	// loading from the database, so that across the instances sharing the storage one of them loads the query
+	// while the others poll the search cache for its result, for DistributedSingleFlightWait ms at most before
+	// loading on their own. The lock is released once the result is cached, with AsyncWrite possibly before
+	// the write lands. Needs a storage implementing storage.Locker, e.g. redis, ignored otherwise
+	DistributedSingleFlight bool
+
+	// DistributedSingleFlightWait ms a query waits on the load of another instance, and the lock of a load
+	// is held at most. 0 represents 1000
+	DistributedSingleFlightWait int64
+
+	// CacheLockedReads if true, locking reads (e.g. FOR UPDATE, FOR SHARE) use the cache like other queries.
+	// By default they bypass it, neither served from nor written to it, since they are meant to read and lock
+	// the rows as stored in the database. Bypasses are counted by LockedReadBypassCount
+	CacheLockedReads bool
+
+	// CacheLockedReadsByStrength overrides CacheLockedReads per strength of clause.Locking, e.g. {"SHARE": true}
+	CacheLockedReadsByStrength map[string]bool
+
+	// CacheInTransaction if true, queries within a transaction use the cache like other queries. By default they
+	// bypass it, since they may read rows not committed yet, which the cache would serve to other readers
+	CacheInTransaction bool
+
+	// InvalidateOnCommit if true, the invalidations of the writes within a transaction wait for it to commit and
+	// are dropped if it rolls back, so that other readers can't cache the rows again before the writes are
+	// visible to them. The db.ConnPool the cache is initialized on is wrapped to tell the transactions apart,
+	// db.DB() still returns its *sql.DB. By default writes invalidate at once. Ignored with
+	// InvalidationFailureFailWrite, whose failures roll the transaction back
+	InvalidateOnCommit bool
+
+	// PublishExpvar if true, cache counters are published as expvar variables
+	// under the "gorm-cache" map, keyed by instance id (visible at /debug/vars)
+	PublishExpvar bool
+
+	// ShardRouter if set, picks the storage serving each request from its context, e.g. to isolate
+	// a heavy tenant to its own redis. Keys are generated as usual, and invalidations go to the
+	// storage the context of the write maps to. CacheStorage serves requests routed to nil.
+	ShardRouter func(ctx context.Context) storage.DataStorage
+
+	// TenantResolver if set, returns the tenant of each request from its context, unless set by cache.WithTenant.
+	// The keys of a tenant are namespaced apart from those of the others, e.g. for tables shared by the tenants
+	// of a SaaS, and dropped at once by InvalidateTenant. Writes only invalidate the entries of their tenant,
+	// writes to rows of several tenants must invalidate the others. "" uses the keys of the instance
+	TenantResolver func(ctx context.Context) string
+
+	// Compression name of the codec compressing cached values (no compression if empty),
+	// either built in (compress.Gzip, compress.Zstd, compress.Snappy) or registered with compress.Register
+	Compression string
+
+	// CompressionThreshold values shorter than this many bytes are cached uncompressed, as compressing
+	// them saves little. 0 compresses every value. Values are read back whether compressed or not
+	CompressionThreshold int
+
+	// EncryptionKey AES key (16, 24 or 32 bytes) encrypting cached values with AES-GCM before they reach the
+	// storage, e.g. rows holding personal data cached in a shared redis. nil encrypts nothing. Values are
+	// compressed before being encrypted, values not encrypted with the key are misses
+	EncryptionKey []byte
+
+	// EncryptionKeyProvider supplies the encryption keys instead of EncryptionKey, e.g. from a KMS, so that
+	// keys can be rotated without dropping the values encrypted with the previous ones
+	EncryptionKeyProvider storage.KeyProvider
+
+	// ChunkSize values longer than this many bytes once compressed and encrypted, e.g. search results of
+	// tens of thousands of rows, are split over several keys of the storage holding at most this many bytes
+	// each, reassembled on read and deleted together, see storage.NewChunked. 0 keeps each value under a
+	// single key
+	ChunkSize int
+
+	// Serializer marshals the cached rows and search results, nil uses jsoniter honoring the gormCache
+	// struct tag and the types registered with cache.RegisterType
+	Serializer Serializer
+
+	// CacheVersion is stored along with every cached row and search result, entries written under another
+	// version are misses, e.g. set it to the release to drop the whole cache on deploy. Changes of the fields
+	// of a model already bust its entries, see Serializer
+	CacheVersion string
+
+	// Tracer starts spans around the cache lookup of queries and the reads, writes and invalidations
+	// of the storage, e.g. an adapter of an OpenTelemetry tracer. nil traces nothing
+	Tracer Tracer
+
+	// Hooks observe the hits, misses, invalidations, storage errors and operation latencies of the cache,
+	// e.g. for custom logging or metrics
+	Hooks Hooks
+
+	// ReadTimeout bounds cache reads of queries in ms, a read timing out is a miss served by the database.
+	// 0 represents StorageTimeout. Only storages honoring the context deadline are bounded.
+	ReadTimeout int64
+
+	// StorageTimeout bounds every storage operation in ms, so that a slow storage can't make cached queries
+	// slower than uncached ones. ReadTimeout and WriteTimeout override it for reads and writes, an invalidation
+	// timing out fails like any storage error. 0 represents no timeout
+	StorageTimeout int64
+
+	// CircuitBreakerThreshold storage operations failing in a row after which queries bypass the cache for
+	// CircuitBreakerCooldown, served by the database without waiting on an unreachable storage. A query then
+	// probes the storage, one per cooldown, until it succeeds and closes the breaker. Invalidations still run
+	// while the breaker is open, skipping them would leave stale entries. 0 disables the breaker
+	CircuitBreakerThreshold int
+
+	// CircuitBreakerCooldown time in ms queries bypass the cache once the circuit breaker opens.
+	// 0 represents 5000
+	CircuitBreakerCooldown int64
+
+	// WriteTimeout bounds caching query results in ms, a write timing out leaves the result uncached.
+	// 0 represents StorageTimeout. Invalidations are only bounded by StorageTimeout, giving up on them would
+	// leave stale entries.
+	WriteTimeout int64
+
+	// InvalidationDebounce window in ms coalescing the invalidations of the whole search cache of a table, e.g.
+	// during bulk inserts. The first invalidation runs right away, then results of the table aren't search
+	// cached until no invalidation came for the window, so the following invalidations have nothing to drop
+	// and are skipped. Reads in the window miss rather than see stale results. 0 runs every invalidation
+	InvalidationDebounce int64
+
+	// DirtyMarkTTL ttl in ms of the marks an update or delete sets on the primary keys of its rows before it runs,
+	// cleared once it invalidated the cache. Lookups of marked primary keys bypass the cache, so they neither read
+	// a row about to be invalidated nor cache the row as it was before the write. It should outlast the writes,
+	// transactions included, marks of writes failing to invalidate are left to expire. Lookups by primary key
+	// read the marks from the storage first. 0 sets no marks
+	DirtyMarkTTL int64
+
+	// OnInvalidationFailure what to do when invalidating cache after a write fails,
+	// logging the failure only by default
+	OnInvalidationFailure InvalidationFailurePolicy
+
+	// SearchCachePredicate if set, decides whether the result of a statement is search cached, e.g. returning
+	// false for pages deep in a listing that are rarely read again. Primary caching of the rows is unaffected
+	SearchCachePredicate func(tableName string, sql string, vars []interface{}) bool
+
+	// MinQueryDuration results of queries the database served in less than this many ms aren't search cached,
+	// so that cheap lookups, e.g. on an index, don't take cache memory from costly ones. The time runs from
+	// the cache miss to the end of the query, loading preloads included. Primary caching of the rows is
+	// unaffected. 0 search caches queries however fast
+	MinQueryDuration int64
+
+	// VolatileOrderColumns columns of each table whose values change often (e.g. updated_at), keyed by table name.
+	// A page ordered by them goes stale quickly after writes, so such search queries are cached for
+	// VolatileOrderTTL ms instead, or not search cached at all if VolatileOrderTTL is 0
+	VolatileOrderColumns map[string][]string
+
+	// VolatileOrderTTL search cache ttl in ms of queries ordered by VolatileOrderColumns, 0 skips caching them
+	VolatileOrderTTL int64
+
+	// AggregateCacheTTL search cache ttl in ms of aggregate queries: Count, selects of COUNT, SUM, AVG, MIN or
+	// MAX and GROUP BY queries. Any write to their table changes them, so they are usually cached for less
+	// than CacheTTL. 0 caches them as the other queries of their table
+	AggregateCacheTTL int64
+
+	// Prefetch if set, on a search cache hit the query it returns is run in background to warm the cache,
+	// e.g. cache.NextPage loads the page following the one hit when paging through results.
+	// It is given a copy of the hit query, returning nil skips prefetching.
+	Prefetch func(db *gorm.DB) *gorm.DB
+
+	// PrefetchConcurrency bounds the prefetches running at once, further ones are dropped. 0 represents 1
+	PrefetchConcurrency int
+
+	// HotKeyRefresh runs the most hit search queries again in background shortly before their entries expire,
+	// so that hot paths don't see the latency of a miss. Off if TopN is 0
+	HotKeyRefresh HotKeyRefresh
+
+	// BloomFilter answers the primary key lookups of its tables with "record not found" when a filter of the
+	// primary keys in the table tells they don't exist, so floods of queries for made up ids reach neither the
+	// storage nor the database. Off if Tables is empty
+	BloomFilter BloomFilter
+
+	// Verifier runs the query of a sample of the cache hits against the database too, in background, counting
+	// the results differing from the cached ones by VerifyDivergenceCount. Off if SampleRate is 0, and under
+	// ShadowMode, which compares every hit already
+	Verifier Verifier
+}
+
+// HotKeyRefresh refreshes the TopN search keys hit the most during each Interval whose entries expire within
+// the next two intervals, one query at a time. Storages that can't read the ttl of a key (see storage.TTLReader)
+// refresh them every Interval. Call Close to stop refreshing
+type HotKeyRefresh struct {
+	// TopN number of the most hit search keys refreshed each Interval, 0 disables refreshing
+	TopN int
+	// Interval in ms hits are counted over between refreshes. 0 represents 1000
+	Interval int64
+}
+
+// BloomFilter keeps a bloom filter of the primary keys of each of its Tables, built from the primary key columns
+// of the table on its first lookup by primary key and fed the keys of the rows created through gorm since.
+// Lookups are only answered once the filter is built, and only when none of their keys may exist.
+// Rows inserted otherwise (e.g. by another service) aren't found until the filter is rebuilt: raw statements
+// written through gorm and InvalidateTable drop it, as do the broadcasts of other instances, whose created keys
+// are added as their invalidations are broadcast (see Broadcaster)
+type BloomFilter struct {
+	// Tables whose primary key lookups are guarded, entries may be globs like DisableTables
+	Tables []string
+	// ExpectedItems number of primary keys a filter is sized for. 0 represents 1000000
+	ExpectedItems int
+	// FalsePositiveRate rate of the keys not in the table a filter of ExpectedItems keys lets through.
+	// 0 represents 0.01
+	FalsePositiveRate float64
+	// RebuildInterval in ms after which a filter is rebuilt in background on its next lookup, dropping the keys
+	// deleted since and adding those inserted otherwise. 0 never rebuilds it
+	RebuildInterval int64
+}
+
+// Verifier checks the correctness of the cache while it serves queries: a hit served by the storage is sampled
+// with probability SampleRate, and its query run again against the database outside the transaction and
+// deadline of the hit. Results of tables invalidated in between aren't compared
+type Verifier struct {
+	// SampleRate fraction of the hits verified, between 0 and 1. 0 verifies none
+	SampleRate float64
+	// Concurrency bounds the verifications running at once, further sampled hits aren't verified. 0 represents 1
+	Concurrency int
 }
 
 type CacheLevel int
 
 const (
-	CacheLevelOff         CacheLevel = 0
+	// CacheLevelOff caches nothing, queries go to the database
+	CacheLevelOff CacheLevel = 0
+	// CacheLevelOnlyPrimary caches rows by primary key only, e.g. for teams wary of stale search results:
+	// queries that aren't by primary key always go to the database
 	CacheLevelOnlyPrimary CacheLevel = 1
-	CacheLevelOnlySearch  CacheLevel = 2
-	CacheLevelAll         CacheLevel = 3
+	// CacheLevelOnlySearch caches the results of queries by their sql and vars only
+	CacheLevelOnlySearch CacheLevel = 2
+	// CacheLevelAll caches rows by primary key and query results
+	CacheLevelAll CacheLevel = 3
 )
+
+type InvalidationFailurePolicy int
+
+const (
+	// InvalidationFailureLogOnly logs the failure, stale entries live until they expire
+	InvalidationFailureLogOnly InvalidationFailurePolicy = 0
+	// InvalidationFailureRetryAsync retries the failed invalidations in background with backoff
+	InvalidationFailureRetryAsync InvalidationFailurePolicy = 1
+	// InvalidationFailureFailWrite fails the write, rolling back its default transaction (none with
+	// SkipDefaultTransaction). Invalidations then run before the commit, and even AsyncWrite waits for them
+	InvalidationFailureFailWrite InvalidationFailurePolicy = 2
+)
+
+type WriteStrategy int
+
+const (
+	// WriteInvalidate deletes the primary cache entries of the rows written, the next lookup of each reads it
+	// from the database
+	WriteInvalidate WriteStrategy = 0
+	// WriteThrough caches the rows created, and the rows saved whole (db.Save), in place of their entries, so
+	// that reading them after the write doesn't miss. Writes whose rows can't be told from the statement alone
+	// are invalidated as with WriteInvalidate: updates of some columns, creates with Select, Omit or ON
+	// CONFLICT or leaving columns to sql defaults, soft deleted rows, and writes within transactions begun
+	// by the application, whose rows may change before they commit. The search cache is invalidated either way
+	WriteThrough WriteStrategy = 1
+)
+
+type SearchInvalidationStrategy int
+
+const (
+	// SearchInvalidationDelete deletes the search keys of the table, which storages do key by key
+	SearchInvalidationDelete SearchInvalidationStrategy = 0
+	// SearchInvalidationVersion bumps a version of the table kept in the storage and folded into its search
+	// keys, so that the entries cached before the write are no longer reached and expire on their own. A write
+	// costs a single key instead of one per cached query, each search an extra read of the versions of its
+	// tables. The unreachable entries still count towards the size of the storage until they expire
+	SearchInvalidationVersion SearchInvalidationStrategy = 1
+)
+
+type StorageErrorPolicy int
+
+const (
+	// StorageErrorFailOpen logs the failure, the query is served by the database as a miss
+	StorageErrorFailOpen StorageErrorPolicy = 0
+	// StorageErrorFailClosed fails the query with util.ErrCacheStorage, writes only being checked without
+	// AsyncWrite
+	StorageErrorFailClosed StorageErrorPolicy = 1
+)
+
+type SingleFlightOverflowPolicy int
+
+const (
+	// SingleFlightOverflowLoad queries beyond the waiter cap load from the cache or database on their own
+	SingleFlightOverflowLoad SingleFlightOverflowPolicy = 0
+	// SingleFlightOverflowFail queries beyond the waiter cap fail with util.ErrSingleFlightBusy
+	SingleFlightOverflowFail SingleFlightOverflowPolicy = 1
+)
+
+type SingleFlightLeaderErrorPolicy int
+
+const (
+	// SingleFlightLeaderErrorFail waiters fail with the error of the load they waited on
+	SingleFlightLeaderErrorFail SingleFlightLeaderErrorPolicy = 0
+	// SingleFlightLeaderErrorRetry waiters load on their own, all of them hitting the database at once
+	SingleFlightLeaderErrorRetry SingleFlightLeaderErrorPolicy = 1
+)
+
+// Serializer converts cached rows and search results from and to the bytes stored for them
+type Serializer interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// EventLogLevels the levels of the events of the cache, util.LevelOff ones (the default) aren't logged
+type EventLogLevels struct {
+	Hit        util.Level
+	Miss       util.Level
+	Invalidate util.Level
+}
+
+// Hooks are called on cache events, nil ones are skipped. They run on the path of the query or write observed,
+// so they should return quickly. A panicking hook is recovered and logged, it doesn't fail the query
+type Hooks struct {
+	// OnHit a query is served from the cache, key is its search cache key (the cache key for GetOrSetPrimary)
+	OnHit func(ctx context.Context, table string, key string)
+	// OnMiss a query falls through to the database, key as for OnHit
+	OnMiss func(ctx context.Context, table string, key string)
+	// OnSet entries are written to the cache of the table under keys, e.g. to audit what gets cached
+	OnSet func(ctx context.Context, table string, keys []string)
+	// OnInvalidate cache keys are invalidated, keys ending with ":" are prefixes of the keys invalidated.
+	// table is empty for InvalidateByTag, whose keys may belong to several tables
+	OnInvalidate func(ctx context.Context, table string, keys []string)
+	// OnError a storage operation fails, a miss is not a failure
+	OnError func(ctx context.Context, err error)
+	// OnOperation an operation traced by Tracer ends, e.g. to record latency histograms. operation is the
+	// name of its span, e.g. "gorm-cache.search.get", err its failure, nil for a success or a miss
+	OnOperation func(ctx context.Context, operation string, table string, elapsed time.Duration, err error)
+	// OnCircuitBreak the circuit breaker of CircuitBreakerThreshold opens, or closes again once the storage
+	// recovers
+	OnCircuitBreak func(ctx context.Context, open bool)
+}
+
+// Tracer starts the span of a cache operation as a child of the span in ctx, if any
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span of a cache operation, attributes are named like gorm-cache.table
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
@@ -0,0 +1,204 @@
+// Package prometheus exports the stats of a gorm-cache as a prometheus.Collector. It is a module of its own,
+// so that the gorm-cache module doesn't depend on the prometheus client.
+package prometheus
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	_ prometheus.Collector = &Collector{}
+	_ Source               = &cache.Gorm2Cache{}
+)
+
+// Source the cache whose stats are collected, a *cache.Gorm2Cache
+type Source interface {
+	StatsSnapshot() cache.StatsSnapshot
+	CountPrimaryCache(ctx context.Context, tableName string) (int64, error)
+	CountSearchCache(ctx context.Context, tableName string) (int64, error)
+}
+
+type CollectorConfig struct {
+	Namespace   string            // prefixes the metric names, e.g. myapp_gorm_cache_hits_total
+	ConstLabels prometheus.Labels // labels every metric, e.g. to tell several caches apart
+
+	// Buckets of the operation latency histogram in seconds, prometheus.DefBuckets if not set
+	Buckets []float64
+
+	// CountKeys if true, every scrape counts the primary and search cache keys of each table, which is
+	// only possible when the storage implements storage.KeyCounter. Counting scans the storage, so the
+	// scrapes are as slow as CountPrimaryCache
+	CountKeys bool
+	// CountTimeout bounds the key counting of a scrape in ms, 1000 if not set
+	CountTimeout int64
+}
+
+// Collector collects the hits, misses, invalidations, errors and evictions of the cache it watches, in total
+// and for each table, along with the latencies of its operations when set as its Hooks.OnOperation:
+//
+//	collector := prometheus.NewCollector()
+//	conf.Hooks.OnOperation = collector.ObserveOperation
+//	c, err := cache.NewGorm2Cache(conf)
+//	collector.Watch(c.(*cache.Gorm2Cache))
+//	registry.MustRegister(collector)
+type Collector struct {
+	config *CollectorConfig
+
+	mu     sync.RWMutex
+	source Source
+
+	latency *prometheus.HistogramVec
+
+	hits              *prometheus.Desc
+	misses            *prometheus.Desc
+	invalidations     *prometheus.Desc
+	errors            *prometheus.Desc
+	evictions         *prometheus.Desc
+	droppedWrites     *prometheus.Desc
+	writeQueueDepth   *prometheus.Desc
+	circuitOpen       *prometheus.Desc
+	tableHits         *prometheus.Desc
+	tableMisses       *prometheus.Desc
+	tableSets         *prometheus.Desc
+	tableInvalidation *prometheus.Desc
+	keys              *prometheus.Desc
+}
+
+func NewCollector(config ...*CollectorConfig) *Collector {
+	conf := &CollectorConfig{}
+	if len(config) > 0 && config[0] != nil {
+		conf = config[0]
+	}
+	if conf.Buckets == nil {
+		conf.Buckets = prometheus.DefBuckets
+	}
+	if conf.CountTimeout <= 0 {
+		conf.CountTimeout = 1000
+	}
+	desc := func(name string, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(conf.Namespace, "gorm_cache", name), help, labels,
+			conf.ConstLabels)
+	}
+	return &Collector{
+		config: conf,
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   conf.Namespace,
+			Subsystem:   "gorm_cache",
+			Name:        "operation_duration_seconds",
+			Help:        "Latency of the cache operations, by operation and table.",
+			ConstLabels: conf.ConstLabels,
+			Buckets:     conf.Buckets,
+		}, []string{"operation", "table", "result"}),
+
+		hits:              desc("hits_total", "Queries served from the cache, by how they were served.", "kind"),
+		misses:            desc("misses_total", "Queries falling through to the database."),
+		invalidations:     desc("invalidations_total", "Invalidations of the cache."),
+		errors:            desc("errors_total", "Failed storage operations, misses aside."),
+		evictions:         desc("evictions_total", "Entries evicted by the storage to make room for new ones."),
+		droppedWrites:     desc("dropped_writes_total", "Cache writes dropped as the AsyncWrite queue was full."),
+		writeQueueDepth:   desc("write_queue_depth", "Cache writes waiting in the AsyncWrite queue."),
+		circuitOpen:       desc("circuit_open", "1 while the circuit breaker bypasses the storage."),
+		tableHits:         desc("table_hits_total", "Hits of a table, by cache.", "table", "cache"),
+		tableMisses:       desc("table_misses_total", "Misses of a table, by cache.", "table", "cache"),
+		tableSets:         desc("table_sets_total", "Entries of a table written, by cache.", "table", "cache"),
+		tableInvalidation: desc("table_invalidations_total", "Invalidations of a table, by cache.", "table", "cache"),
+		keys:              desc("keys", "Keys of a table stored, by cache, see CollectorConfig.CountKeys.", "table", "cache"),
+	}
+}
+
+// Watch sets the cache whose stats are collected, the stats of a previously watched cache aren't anymore
+func (c *Collector) Watch(source Source) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.source = source
+}
+
+// ObserveOperation records the latency of an operation, to be set as config.Hooks.OnOperation
+func (c *Collector) ObserveOperation(_ context.Context, operation string, table string, elapsed time.Duration,
+	err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	c.latency.WithLabelValues(operation, table, result).Observe(elapsed.Seconds())
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.latency.Describe(ch)
+	for _, desc := range []*prometheus.Desc{c.hits, c.misses, c.invalidations, c.errors, c.evictions,
+		c.droppedWrites, c.writeQueueDepth, c.circuitOpen, c.tableHits, c.tableMisses, c.tableSets,
+		c.tableInvalidation, c.keys} {
+		ch <- desc
+	}
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.latency.Collect(ch)
+
+	c.mu.RLock()
+	source := c.source
+	c.mu.RUnlock()
+	if source == nil {
+		return
+	}
+
+	stats := source.StatsSnapshot()
+	counter := func(desc *prometheus.Desc, value uint64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(value), labels...)
+	}
+	counter(c.hits, stats.HitCounts.Primary, "primary")
+	counter(c.hits, stats.HitCounts.Search, "search")
+	counter(c.hits, stats.HitCounts.SingleFlight, "single_flight")
+	counter(c.hits, stats.HitCounts.RecordNotFound, "record_not_found")
+	counter(c.hits, stats.HitCounts.Request, "request")
+	counter(c.misses, stats.MissCount)
+	counter(c.invalidations, stats.InvalidationCount)
+	counter(c.errors, stats.ErrorCount)
+	counter(c.evictions, stats.EvictionCount)
+	counter(c.droppedWrites, stats.DroppedWriteCount)
+	ch <- prometheus.MustNewConstMetric(c.writeQueueDepth, prometheus.GaugeValue, float64(stats.WriteQueueDepth))
+	circuitOpen := 0.0
+	if stats.CircuitOpen {
+		circuitOpen = 1
+	}
+	ch <- prometheus.MustNewConstMetric(c.circuitOpen, prometheus.GaugeValue, circuitOpen)
+
+	tables := make([]string, 0, len(stats.Tables))
+	for table, tableStats := range stats.Tables {
+		tables = append(tables, table)
+		for kind, kindStats := range map[string]cache.KindStats{"primary": tableStats.Primary, "search": tableStats.Search} {
+			counter(c.tableHits, kindStats.HitCount, table, kind)
+			counter(c.tableMisses, kindStats.MissCount, table, kind)
+			counter(c.tableSets, kindStats.SetCount, table, kind)
+			counter(c.tableInvalidation, kindStats.InvalidationCount, table, kind)
+		}
+	}
+	if c.config.CountKeys {
+		sort.Strings(tables)
+		c.collectKeys(ch, source, tables)
+	}
+}
+
+// collectKeys counts the keys of the tables, until a count fails, e.g. as the storage can't count them
+func (c *Collector) collectKeys(ch chan<- prometheus.Metric, source Source, tables []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(c.config.CountTimeout)*time.Millisecond)
+	defer cancel()
+	for _, table := range tables {
+		primaryCount, err := source.CountPrimaryCache(ctx, table)
+		if err != nil {
+			return
+		}
+		searchCount, err := source.CountSearchCache(ctx, table)
+		if err != nil {
+			return
+		}
+		ch <- prometheus.MustNewConstMetric(c.keys, prometheus.GaugeValue, float64(primaryCount), table, "primary")
+		ch <- prometheus.MustNewConstMetric(c.keys, prometheus.GaugeValue, float64(searchCount), table, "search")
+	}
+}
@@ -0,0 +1,83 @@
+package prometheus
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type collectedModel struct {
+	ID    int64 `gorm:"column:id;primary_key"`
+	Value int64 `gorm:"column:value"`
+}
+
+func TestCollector(t *testing.T) {
+	Convey("test the collector exports the stats and operation latencies of the cache", t, func() {
+		db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "collector.db")), &gorm.Config{
+			Logger: logger.Discard,
+		})
+		So(err, ShouldBeNil)
+		So(db.AutoMigrate(&collectedModel{}), ShouldBeNil)
+		So(db.Create(&[]collectedModel{{ID: 1, Value: 1}, {ID: 2, Value: 2}}).Error, ShouldBeNil)
+
+		collector := NewCollector(&CollectorConfig{Namespace: "test", CountKeys: true})
+		conf := &config.CacheConfig{
+			CacheLevel:           config.CacheLevelAll,
+			CacheStorage:         storage.NewMem(&storage.MemStoreConfig{MaxSize: 1000}),
+			InvalidateWhenUpdate: true,
+			CacheTTL:             5000,
+		}
+		conf.Hooks.OnOperation = collector.ObserveOperation
+		c, err := cache.NewGorm2Cache(conf)
+		So(err, ShouldBeNil)
+		So(db.Use(c), ShouldBeNil)
+		collector.Watch(c.(*cache.Gorm2Cache))
+
+		registry := prometheus.NewPedanticRegistry()
+		So(registry.Register(collector), ShouldBeNil)
+
+		for i := 0; i < 3; i++ {
+			models := make([]collectedModel, 0)
+			So(db.Where("value > ?", 0).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 2)
+		}
+		So(db.Model(&collectedModel{}).Where("id = ?", 1).Update("value", 10).Error, ShouldBeNil)
+
+		families, err := registry.Gather()
+		So(err, ShouldBeNil)
+		values := make(map[string]float64)
+		for _, family := range families {
+			for _, metric := range family.GetMetric() {
+				name := family.GetName()
+				for _, label := range metric.GetLabel() {
+					name += "," + label.GetName() + "=" + label.GetValue()
+				}
+				switch {
+				case metric.GetCounter() != nil:
+					values[name] = metric.GetCounter().GetValue()
+				case metric.GetGauge() != nil:
+					values[name] = metric.GetGauge().GetValue()
+				case metric.GetHistogram() != nil:
+					values[name] = float64(metric.GetHistogram().GetSampleCount())
+				}
+			}
+		}
+		So(values["test_gorm_cache_hits_total,kind=search"], ShouldEqual, 2)
+		So(values["test_gorm_cache_misses_total"], ShouldEqual, 1)
+		So(values["test_gorm_cache_invalidations_total"], ShouldBeGreaterThan, 0)
+		So(values["test_gorm_cache_table_hits_total,cache=search,table=collected_models"], ShouldEqual, 2)
+		So(values["test_gorm_cache_keys,cache=search,table=collected_models"], ShouldEqual, 0)
+		So(values["test_gorm_cache_operation_duration_seconds,operation=gorm-cache.search.get,result=success,"+
+			"table=collected_models"], ShouldEqual, 3)
+		So(testutil.CollectAndCount(collector, "test_gorm_cache_circuit_open"), ShouldEqual, 1)
+	})
+}
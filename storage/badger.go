@@ -0,0 +1,266 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joykk/gorm-cache/util"
+)
+
+var (
+	_ DataStorage = &Badger{}
+	_ Snapshotter = &Badger{}
+	_ KeyCounter  = &Badger{}
+	_ KeyLister   = &Badger{}
+)
+
+// defaultBadgerDeleteBatch is the number of keys deleted at once by prefix deletes, badger transactions
+// are bounded in size
+const defaultBadgerDeleteBatch = 1000
+
+// BadgerClient is the subset of an embedded on-disk key value store the Badger store is built on, e.g. a thin
+// adapter of github.com/dgraph-io/badger/v4 writing entries with badger.NewEntry(key, value).WithTTL(ttl)
+// and iterating keys with an iterator of PrefetchValues false. Keys expire by the native expiry of the store.
+type BadgerClient interface {
+	// Get returns the values of the keys found, keys missing or expired are left out
+	Get(keys []string) (map[string][]byte, error)
+	// Set writes the entries in one batch
+	Set(entries []BadgerEntry) error
+	// Delete deletes the keys in one batch, missing keys are not an error
+	Delete(keys []string) error
+	// IterateKeys calls fn with every live key starting with prefix, in key order, until fn returns false
+	IterateKeys(prefix string, fn func(key string) bool) error
+}
+
+// BadgerEntry an entry written by BadgerClient.Set
+type BadgerEntry struct {
+	Key   string
+	Value []byte
+	TTL   time.Duration // 0 never expires
+}
+
+type BadgerStoreConfig struct {
+	// KeyPrefix every key is stored under this prefix, "gormcache" if not set. Unlike the other stores it
+	// isn't random, so that the cache outlives restarts along with the files of the store: the keys of the
+	// cache are only read again if its InstanceId is set too
+	KeyPrefix string
+
+	Client BadgerClient
+
+	// DeleteBatch number of keys prefix deletes and CleanCache delete at once, 0 represents 1000
+	DeleteBatch int
+}
+
+// NewBadger creates a storage on an embedded on-disk key value store such as badger, for caches too large to
+// fit in memory or meant to outlive restarts. Prefix deletes iterate the keys of the prefix and delete
+// them in batches.
+func NewBadger(config ...*BadgerStoreConfig) *Badger {
+	if len(config) == 0 {
+		panic("badger config is required")
+	}
+	if config[0].Client == nil {
+		panic("badger client is required")
+	}
+	if config[0].KeyPrefix == "" {
+		config[0].KeyPrefix = util.GormCachePrefix
+	}
+	if config[0].DeleteBatch <= 0 {
+		config[0].DeleteBatch = defaultBadgerDeleteBatch
+	}
+	return &Badger{config: config[0]}
+}
+
+type Badger struct {
+	config *BadgerStoreConfig
+	ttl    int64
+	jitter float64
+	logger util.LoggerInterface
+
+	once sync.Once
+}
+
+func (b *Badger) Init(conf *Config) error {
+	b.once.Do(func() {
+		b.ttl = conf.TTL
+		b.jitter = conf.Jitter
+		b.logger = conf.Logger
+		b.logger.SetIsDebug(conf.Debug)
+	})
+	return nil
+}
+
+func (b *Badger) key(key string) string {
+	return b.config.KeyPrefix + ":" + key
+}
+
+// CleanCache deletes the keys under KeyPrefix, the other keys of the store are kept
+func (b *Badger) CleanCache(ctx context.Context) error {
+	if err := b.deletePrefix(ctx, b.config.KeyPrefix+":"); err != nil {
+		b.logger.CtxError(ctx, "[CleanCache] clean cache error: %v", err)
+		return err
+	}
+	return nil
+}
+
+// Ping always succeeds, the store is embedded in the process
+func (b *Badger) Ping(context.Context) error {
+	return nil
+}
+
+func (b *Badger) BatchKeyExist(ctx context.Context, keys []string) (bool, error) {
+	values, err := b.BatchGetValues(ctx, keys)
+	if err != nil {
+		return false, err
+	}
+	for _, value := range values {
+		if value == "" {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (b *Badger) KeyExists(ctx context.Context, key string) (bool, error) {
+	return b.BatchKeyExist(ctx, []string{key})
+}
+
+func (b *Badger) GetValue(ctx context.Context, key string) (string, error) {
+	values, err := b.BatchGetValues(ctx, []string{key})
+	if err != nil {
+		return "", err
+	}
+	if values[0] == "" {
+		return "", ErrCacheNotFound
+	}
+	return values[0], nil
+}
+
+func (b *Badger) BatchGetValues(ctx context.Context, keys []string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	storeKeys := make([]string, len(keys))
+	for idx, key := range keys {
+		storeKeys[idx] = b.key(key)
+	}
+	values, err := b.config.Client.Get(storeKeys)
+	if err != nil {
+		b.logger.CtxError(ctx, "[BatchGetValues] get error: %v", err)
+		return nil, err
+	}
+	strs := make([]string, len(keys))
+	for idx, storeKey := range storeKeys {
+		strs[idx] = string(values[storeKey])
+	}
+	return strs, nil
+}
+
+func (b *Badger) DeleteKeysWithPrefix(ctx context.Context, keyPrefix string) error {
+	if err := b.deletePrefix(ctx, b.key(keyPrefix)); err != nil {
+		b.logger.CtxError(ctx, "[DeleteKeysWithPrefix] delete keys error: %v", err)
+		return err
+	}
+	return nil
+}
+
+// deletePrefix deletes the keys of the store starting with prefix, DeleteBatch at a time. The keys are
+// collected before being deleted, deleting while iterating isn't allowed by badger
+func (b *Badger) deletePrefix(ctx context.Context, prefix string) error {
+	keys := make([]string, 0)
+	if err := b.config.Client.IterateKeys(prefix, func(key string) bool {
+		keys = append(keys, key)
+		return true
+	}); err != nil {
+		return err
+	}
+	for start := 0; start < len(keys); start += b.config.DeleteBatch {
+		end := start + b.config.DeleteBatch
+		if end > len(keys) {
+			end = len(keys)
+		}
+		if err := b.config.Client.Delete(keys[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Badger) DeleteKey(ctx context.Context, key string) error {
+	return b.BatchDeleteKeys(ctx, []string{key})
+}
+
+func (b *Badger) BatchDeleteKeys(ctx context.Context, keys []string) error {
+	storeKeys := make([]string, len(keys))
+	for idx, key := range keys {
+		storeKeys[idx] = b.key(key)
+	}
+	if err := b.config.Client.Delete(storeKeys); err != nil {
+		b.logger.CtxError(ctx, "[BatchDeleteKeys] delete keys error: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (b *Badger) BatchSetKeys(ctx context.Context, kvs []util.Kv) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	entries := make([]BadgerEntry, len(kvs))
+	for idx, kv := range kvs {
+		entries[idx] = BadgerEntry{Key: b.key(kv.Key), Value: []byte(kv.Value), TTL: b.expiration(kv)}
+	}
+	if err := b.config.Client.Set(entries); err != nil {
+		b.logger.CtxError(ctx, "[BatchSetKeys] set keys error: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (b *Badger) SetKey(ctx context.Context, kv util.Kv) error {
+	return b.BatchSetKeys(ctx, []util.Kv{kv})
+}
+
+// expiration returns the jittered ttl of kv, 0 if it doesn't expire
+func (b *Badger) expiration(kv util.Kv) time.Duration {
+	ttl := b.ttl
+	if kv.TTL > 0 {
+		ttl = kv.TTL
+	}
+	if ttl <= 0 {
+		return 0
+	}
+	return time.Duration(util.JitterInt64(ttl, b.jitter)) * time.Millisecond
+}
+
+func (b *Badger) CountKeysWithPrefix(ctx context.Context, keyPrefix string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	var count int64
+	err := b.config.Client.IterateKeys(b.key(keyPrefix), func(string) bool {
+		count++
+		return true
+	})
+	return count, err
+}
+
+func (b *Badger) Snapshot() map[string]interface{} {
+	return map[string]interface{}{
+		"keyPrefix":   b.config.KeyPrefix,
+		"deleteBatch": b.config.DeleteBatch,
+	}
+}
+
+func (b *Badger) ListKeysWithPrefix(ctx context.Context, keyPrefix string, limit int) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0)
+	err := b.config.Client.IterateKeys(b.key(keyPrefix), func(key string) bool {
+		keys = append(keys, strings.TrimPrefix(key, b.config.KeyPrefix+":"))
+		return len(keys) < limit
+	})
+	return keys, err
+}
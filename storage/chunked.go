@@ -0,0 +1,363 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/joykk/gorm-cache/util"
+)
+
+var (
+	_ DataStorage     = &Chunked{}
+	_ Snapshotter     = &Chunked{}
+	_ KeyCounter      = &Chunked{}
+	_ KeyLister       = &Chunked{}
+	_ TTLReader       = &Chunked{}
+	_ Expirer         = &Chunked{}
+	_ EvictionCounter = &Chunked{}
+	_ Closer          = &Chunked{}
+	_ Versioner       = &Chunked{}
+)
+
+// chunkManifestMarker starts the manifest stored under the key of a chunked value
+const chunkManifestMarker = '\x1d'
+
+// DefaultChunkSize the chunk size of a Chunked storage whose config leaves it 0
+const DefaultChunkSize = 512 << 10
+
+type ChunkedStoreConfig struct {
+	Storage   DataStorage // the storage keeping the chunks
+	ChunkSize int         // values longer than this many bytes are split into chunks of it, 0 uses DefaultChunkSize
+}
+
+// NewChunked creates a storage splitting values longer than config.ChunkSize over several keys of
+// config.Storage, e.g. search results of tens of thousands of rows cached in redis. The chunks of key are
+// stored under key#0 to key#N-1 and key holds a manifest of them, so reads reassemble the value and
+// deletes drop the chunks along with it. A value whose chunks are missing, expired or overwritten by another
+// write is a miss. Chunks are stored as keys of their own, counted and listed with the others
+func NewChunked(config ...*ChunkedStoreConfig) *Chunked {
+	if len(config) == 0 {
+		panic("chunked config is required")
+	}
+	if config[0].Storage == nil {
+		panic("chunked storage is required")
+	}
+	conf := *config[0]
+	if conf.ChunkSize <= 0 {
+		conf.ChunkSize = DefaultChunkSize
+	}
+	return &Chunked{config: &conf}
+}
+
+type Chunked struct {
+	config *ChunkedStoreConfig
+	logger util.LoggerInterface
+
+	once sync.Once
+}
+
+// chunkManifest describes the chunks of a value
+type chunkManifest struct {
+	count    int
+	length   int
+	checksum uint32
+}
+
+func (m chunkManifest) String() string {
+	return fmt.Sprintf("%c%d:%d:%08x", chunkManifestMarker, m.count, m.length, m.checksum)
+}
+
+func (c *Chunked) Init(conf *Config) error {
+	var err error
+	c.once.Do(func() {
+		c.logger = conf.Logger
+		err = c.config.Storage.Init(conf)
+	})
+	return err
+}
+
+func (c *Chunked) CleanCache(ctx context.Context) error {
+	return c.config.Storage.CleanCache(ctx)
+}
+
+func (c *Chunked) Ping(ctx context.Context) error {
+	return c.config.Storage.Ping(ctx)
+}
+
+func (c *Chunked) BatchKeyExist(ctx context.Context, keys []string) (bool, error) {
+	return c.config.Storage.BatchKeyExist(ctx, keys)
+}
+
+func (c *Chunked) KeyExists(ctx context.Context, key string) (bool, error) {
+	return c.config.Storage.KeyExists(ctx, key)
+}
+
+func (c *Chunked) GetValue(ctx context.Context, key string) (string, error) {
+	value, err := c.config.Storage.GetValue(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	manifest, ok := parseChunkManifest(value)
+	if !ok {
+		return value, nil
+	}
+	chunks, err := c.config.Storage.BatchGetValues(ctx, chunkKeys(key, manifest.count))
+	if err != nil {
+		return "", err
+	}
+	value, err = joinChunks(manifest, chunks)
+	if err != nil {
+		c.logger.CtxInfo(ctx, "[GetValue] chunks of key %s: %v", key, err)
+		return "", ErrCacheNotFound
+	}
+	return value, nil
+}
+
+func (c *Chunked) BatchGetValues(ctx context.Context, keys []string) ([]string, error) {
+	values, err := c.config.Storage.BatchGetValues(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	// the chunks of all the chunked values are read at once
+	manifests := make(map[int]chunkManifest)
+	allChunkKeys := make([]string, 0)
+	for idx, value := range values {
+		if manifest, ok := parseChunkManifest(value); ok {
+			manifests[idx] = manifest
+			allChunkKeys = append(allChunkKeys, chunkKeys(keys[idx], manifest.count)...)
+		}
+	}
+	if len(manifests) == 0 {
+		return values, nil
+	}
+	chunks, err := c.config.Storage.BatchGetValues(ctx, allChunkKeys)
+	if err != nil {
+		return nil, err
+	}
+	for idx := range values {
+		manifest, ok := manifests[idx]
+		if !ok {
+			continue
+		}
+		if values[idx], err = joinChunks(manifest, chunks[:manifest.count]); err != nil {
+			c.logger.CtxInfo(ctx, "[BatchGetValues] chunks of key %s: %v", keys[idx], err)
+			values[idx] = ""
+		}
+		chunks = chunks[manifest.count:]
+	}
+	return values, nil
+}
+
+func (c *Chunked) DeleteKeysWithPrefix(ctx context.Context, keyPrefix string) error {
+	// chunks start with the key of their value
+	return c.config.Storage.DeleteKeysWithPrefix(ctx, keyPrefix)
+}
+
+func (c *Chunked) DeleteKey(ctx context.Context, key string) error {
+	return c.BatchDeleteKeys(ctx, []string{key})
+}
+
+// BatchDeleteKeys deletes the keys along with the chunks their manifests list. The keys are deleted even if
+// the manifests can't be read, the chunks left then can't be read back and expire with their ttl
+func (c *Chunked) BatchDeleteKeys(ctx context.Context, keys []string) error {
+	withChunks, err := c.withChunkKeys(ctx, keys)
+	if err != nil {
+		c.logger.CtxError(ctx, "[BatchDeleteKeys] read manifests error: %v", err)
+		withChunks = keys
+	}
+	return c.config.Storage.BatchDeleteKeys(ctx, withChunks)
+}
+
+func (c *Chunked) BatchSetKeys(ctx context.Context, kvs []util.Kv) error {
+	return c.config.Storage.BatchSetKeys(ctx, c.split(kvs))
+}
+
+func (c *Chunked) SetKey(ctx context.Context, kv util.Kv) error {
+	split := c.split([]util.Kv{kv})
+	if len(split) == 1 {
+		return c.config.Storage.SetKey(ctx, split[0])
+	}
+	return c.config.Storage.BatchSetKeys(ctx, split)
+}
+
+// split replaces the values longer than the chunk size by their chunks followed by their manifest, so that
+// readers of the manifest find the chunks already written. Values starting like a manifest are chunked all
+// the same, so that reads don't mistake them for one
+func (c *Chunked) split(kvs []util.Kv) []util.Kv {
+	split := make([]util.Kv, 0, len(kvs))
+	for _, kv := range kvs {
+		if len(kv.Value) <= c.config.ChunkSize && (len(kv.Value) == 0 || kv.Value[0] != chunkManifestMarker) {
+			split = append(split, kv)
+			continue
+		}
+		manifest := chunkManifest{
+			count:    (len(kv.Value) + c.config.ChunkSize - 1) / c.config.ChunkSize,
+			length:   len(kv.Value),
+			checksum: crc32.ChecksumIEEE([]byte(kv.Value)),
+		}
+		for idx, key := range chunkKeys(kv.Key, manifest.count) {
+			end := (idx + 1) * c.config.ChunkSize
+			if end > len(kv.Value) {
+				end = len(kv.Value)
+			}
+			split = append(split, util.Kv{Key: key, Value: kv.Value[idx*c.config.ChunkSize : end], TTL: kv.TTL})
+		}
+		split = append(split, util.Kv{Key: kv.Key, Value: manifest.String(), TTL: kv.TTL})
+	}
+	return split
+}
+
+// withChunkKeys returns keys followed by the keys of the chunks of their values
+func (c *Chunked) withChunkKeys(ctx context.Context, keys []string) ([]string, error) {
+	values, err := c.config.Storage.BatchGetValues(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	withChunks := keys
+	for idx, value := range values {
+		if manifest, ok := parseChunkManifest(value); ok {
+			if len(withChunks) == len(keys) {
+				withChunks = append(make([]string, 0, len(keys)+manifest.count), keys...)
+			}
+			withChunks = append(withChunks, chunkKeys(keys[idx], manifest.count)...)
+		}
+	}
+	return withChunks, nil
+}
+
+func chunkKeys(key string, count int) []string {
+	keys := make([]string, count)
+	for idx := range keys {
+		keys[idx] = key + "#" + strconv.Itoa(idx)
+	}
+	return keys
+}
+
+func parseChunkManifest(value string) (chunkManifest, bool) {
+	if len(value) == 0 || value[0] != chunkManifestMarker {
+		return chunkManifest{}, false
+	}
+	parts := strings.Split(value[1:], ":")
+	if len(parts) != 3 {
+		return chunkManifest{}, false
+	}
+	count, err := strconv.Atoi(parts[0])
+	if err != nil || count <= 0 {
+		return chunkManifest{}, false
+	}
+	length, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return chunkManifest{}, false
+	}
+	checksum, err := strconv.ParseUint(parts[2], 16, 32)
+	if err != nil {
+		return chunkManifest{}, false
+	}
+	return chunkManifest{count: count, length: length, checksum: uint32(checksum)}, true
+}
+
+// joinChunks reassembles the value of manifest, chunks of concurrent writes of the key mixed with its own
+// fail the checksum
+func joinChunks(manifest chunkManifest, chunks []string) (string, error) {
+	var b strings.Builder
+	b.Grow(manifest.length)
+	for idx, chunk := range chunks {
+		if chunk == "" {
+			return "", fmt.Errorf("chunk %d of %d is missing", idx, manifest.count)
+		}
+		b.WriteString(chunk)
+	}
+	value := b.String()
+	if len(value) != manifest.length || crc32.ChecksumIEEE([]byte(value)) != manifest.checksum {
+		return "", errors.New("chunks don't match their manifest")
+	}
+	return value, nil
+}
+
+// ExpireKeys refreshes the expiry of the keys and of their chunks if the underlying storage can, and does
+// nothing otherwise
+func (c *Chunked) ExpireKeys(ctx context.Context, keys []string, ttl int64) error {
+	expirer, ok := c.config.Storage.(Expirer)
+	if !ok {
+		return nil
+	}
+	withChunks, err := c.withChunkKeys(ctx, keys)
+	if err != nil {
+		return err
+	}
+	return expirer.ExpireKeys(ctx, withChunks, ttl)
+}
+
+func (c *Chunked) BumpVersion(ctx context.Context, sequenceKey string, versionKeys []string, ttl int64) (int64, error) {
+	versioner, ok := c.config.Storage.(Versioner)
+	if !ok {
+		return 0, fmt.Errorf("%T can't keep versions", c.config.Storage)
+	}
+	return versioner.BumpVersion(ctx, sequenceKey, versionKeys, ttl)
+}
+
+// SetKeysIfVersion writes the chunks unconditionally and the manifests if their version allows it, chunks
+// of manifests not written can't be read back and expire with their ttl
+func (c *Chunked) SetKeysIfVersion(ctx context.Context, kvs []util.Kv, versionKeys [][]string, version int64) ([]bool, error) {
+	versioner, ok := c.config.Storage.(Versioner)
+	if !ok {
+		return nil, fmt.Errorf("%T can't keep versions", c.config.Storage)
+	}
+	chunks := make([]util.Kv, 0)
+	manifests := make([]util.Kv, 0, len(kvs))
+	for _, kv := range kvs {
+		split := c.split([]util.Kv{kv})
+		chunks = append(chunks, split[:len(split)-1]...)
+		manifests = append(manifests, split[len(split)-1])
+	}
+	if len(chunks) > 0 {
+		if err := c.config.Storage.BatchSetKeys(ctx, chunks); err != nil {
+			return nil, err
+		}
+	}
+	return versioner.SetKeysIfVersion(ctx, manifests, versionKeys, version)
+}
+
+func (c *Chunked) CountKeysWithPrefix(ctx context.Context, keyPrefix string) (int64, error) {
+	counter, ok := c.config.Storage.(KeyCounter)
+	if !ok {
+		return 0, fmt.Errorf("%T can't count keys", c.config.Storage)
+	}
+	return counter.CountKeysWithPrefix(ctx, keyPrefix)
+}
+
+func (c *Chunked) ListKeysWithPrefix(ctx context.Context, keyPrefix string, limit int) ([]string, error) {
+	lister, ok := c.config.Storage.(KeyLister)
+	if !ok {
+		return nil, fmt.Errorf("%T can't list keys", c.config.Storage)
+	}
+	return lister.ListKeysWithPrefix(ctx, keyPrefix, limit)
+}
+
+func (c *Chunked) KeyTTL(ctx context.Context, key string) (int64, error) {
+	reader, ok := c.config.Storage.(TTLReader)
+	if !ok {
+		return 0, fmt.Errorf("%T can't read ttls", c.config.Storage)
+	}
+	return reader.KeyTTL(ctx, key)
+}
+
+func (c *Chunked) EvictionCount() uint64 {
+	return evictionCount(c.config.Storage)
+}
+
+func (c *Chunked) Close() error {
+	return closeStorage(c.config.Storage)
+}
+
+func (c *Chunked) Snapshot() map[string]interface{} {
+	return map[string]interface{}{
+		"chunkSize": c.config.ChunkSize,
+		"storage":   describeStorage(c.config.Storage),
+	}
+}
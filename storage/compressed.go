@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/joykk/gorm-cache/compress"
+	"github.com/joykk/gorm-cache/util"
+)
+
+var (
+	_ DataStorage     = &Compressed{}
+	_ Snapshotter     = &Compressed{}
+	_ KeyCounter      = &Compressed{}
+	_ KeyLister       = &Compressed{}
+	_ TTLReader       = &Compressed{}
+	_ Expirer         = &Compressed{}
+	_ EvictionCounter = &Compressed{}
+	_ Closer          = &Compressed{}
+	_ Versioner       = &Compressed{}
+)
+
+type CompressedStoreConfig struct {
+	Storage   DataStorage // the storage keeping the compressed values
+	Codec     string      // name of a codec registered in the compress package
+	Threshold int         // values shorter than this many bytes are kept uncompressed, 0 compresses all of them
+}
+
+// NewCompressed creates a storage compressing values before they reach config.Storage.
+// Every value carries the name of its codec, so values written with another codec,
+// or uncompressed, are still read back correctly.
+func NewCompressed(config ...*CompressedStoreConfig) *Compressed {
+	if len(config) == 0 {
+		panic("compressed config is required")
+	}
+	if config[0].Storage == nil {
+		panic("compressed storage is required")
+	}
+	return &Compressed{config: config[0]}
+}
+
+type Compressed struct {
+	config *CompressedStoreConfig
+	logger util.LoggerInterface
+
+	once sync.Once
+}
+
+func (c *Compressed) Init(conf *Config) error {
+	var err error
+	c.once.Do(func() {
+		c.logger = conf.Logger
+		if _, err = compress.Get(c.config.Codec); err != nil {
+			return
+		}
+		err = c.config.Storage.Init(conf)
+	})
+	return err
+}
+
+func (c *Compressed) CleanCache(ctx context.Context) error {
+	return c.config.Storage.CleanCache(ctx)
+}
+
+func (c *Compressed) Ping(ctx context.Context) error {
+	return c.config.Storage.Ping(ctx)
+}
+
+func (c *Compressed) BatchKeyExist(ctx context.Context, keys []string) (bool, error) {
+	return c.config.Storage.BatchKeyExist(ctx, keys)
+}
+
+func (c *Compressed) KeyExists(ctx context.Context, key string) (bool, error) {
+	return c.config.Storage.KeyExists(ctx, key)
+}
+
+func (c *Compressed) GetValue(ctx context.Context, key string) (string, error) {
+	value, err := c.config.Storage.GetValue(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	return compress.Decode(value)
+}
+
+func (c *Compressed) BatchGetValues(ctx context.Context, keys []string) ([]string, error) {
+	values, err := c.config.Storage.BatchGetValues(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	for idx, value := range values {
+		if values[idx], err = compress.Decode(value); err != nil {
+			c.logger.CtxError(ctx, "[BatchGetValues] decode value of key %s error: %v", keys[idx], err)
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func (c *Compressed) DeleteKeysWithPrefix(ctx context.Context, keyPrefix string) error {
+	return c.config.Storage.DeleteKeysWithPrefix(ctx, keyPrefix)
+}
+
+func (c *Compressed) DeleteKey(ctx context.Context, key string) error {
+	return c.config.Storage.DeleteKey(ctx, key)
+}
+
+func (c *Compressed) BatchDeleteKeys(ctx context.Context, keys []string) error {
+	return c.config.Storage.BatchDeleteKeys(ctx, keys)
+}
+
+func (c *Compressed) BatchSetKeys(ctx context.Context, kvs []util.Kv) error {
+	encoded := make([]util.Kv, 0, len(kvs))
+	for _, kv := range kvs {
+		value, err := c.encode(kv.Value)
+		if err != nil {
+			return err
+		}
+		encoded = append(encoded, util.Kv{Key: kv.Key, Value: value, TTL: kv.TTL})
+	}
+	return c.config.Storage.BatchSetKeys(ctx, encoded)
+}
+
+func (c *Compressed) SetKey(ctx context.Context, kv util.Kv) error {
+	value, err := c.encode(kv.Value)
+	if err != nil {
+		return err
+	}
+	return c.config.Storage.SetKey(ctx, util.Kv{Key: kv.Key, Value: value, TTL: kv.TTL})
+}
+
+// encode compresses value unless it is below the threshold. Small values starting like a header
+// are compressed all the same, so that reads don't mistake them for compressed ones.
+func (c *Compressed) encode(value string) (string, error) {
+	if len(value) < c.config.Threshold && (len(value) == 0 || value[0] != compress.HeaderSeparator) {
+		return value, nil
+	}
+	return compress.Encode(c.config.Codec, value)
+}
+
+// ExpireKeys refreshes the expiry if the underlying storage can, and does nothing otherwise
+func (c *Compressed) ExpireKeys(ctx context.Context, keys []string, ttl int64) error {
+	if expirer, ok := c.config.Storage.(Expirer); ok {
+		return expirer.ExpireKeys(ctx, keys, ttl)
+	}
+	return nil
+}
+
+func (c *Compressed) BumpVersion(ctx context.Context, sequenceKey string, versionKeys []string, ttl int64) (int64, error) {
+	versioner, ok := c.config.Storage.(Versioner)
+	if !ok {
+		return 0, fmt.Errorf("%T can't keep versions", c.config.Storage)
+	}
+	return versioner.BumpVersion(ctx, sequenceKey, versionKeys, ttl)
+}
+
+func (c *Compressed) SetKeysIfVersion(ctx context.Context, kvs []util.Kv, versionKeys [][]string, version int64) ([]bool, error) {
+	versioner, ok := c.config.Storage.(Versioner)
+	if !ok {
+		return nil, fmt.Errorf("%T can't keep versions", c.config.Storage)
+	}
+	encoded := make([]util.Kv, 0, len(kvs))
+	for _, kv := range kvs {
+		value, err := c.encode(kv.Value)
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, util.Kv{Key: kv.Key, Value: value, TTL: kv.TTL})
+	}
+	return versioner.SetKeysIfVersion(ctx, encoded, versionKeys, version)
+}
+
+func (c *Compressed) CountKeysWithPrefix(ctx context.Context, keyPrefix string) (int64, error) {
+	counter, ok := c.config.Storage.(KeyCounter)
+	if !ok {
+		return 0, fmt.Errorf("%T can't count keys", c.config.Storage)
+	}
+	return counter.CountKeysWithPrefix(ctx, keyPrefix)
+}
+
+func (c *Compressed) EvictionCount() uint64 {
+	return evictionCount(c.config.Storage)
+}
+
+func (c *Compressed) Close() error {
+	return closeStorage(c.config.Storage)
+}
+
+func (c *Compressed) Snapshot() map[string]interface{} {
+	return map[string]interface{}{
+		"codec":     c.config.Codec,
+		"threshold": c.config.Threshold,
+		"storage":   describeStorage(c.config.Storage),
+	}
+}
+
+func (c *Compressed) ListKeysWithPrefix(ctx context.Context, keyPrefix string, limit int) ([]string, error) {
+	lister, ok := c.config.Storage.(KeyLister)
+	if !ok {
+		return nil, fmt.Errorf("%T can't list keys", c.config.Storage)
+	}
+	return lister.ListKeysWithPrefix(ctx, keyPrefix, limit)
+}
+
+func (c *Compressed) KeyTTL(ctx context.Context, key string) (int64, error) {
+	reader, ok := c.config.Storage.(TTLReader)
+	if !ok {
+		return 0, fmt.Errorf("%T can't read ttls", c.config.Storage)
+	}
+	return reader.KeyTTL(ctx, key)
+}
@@ -0,0 +1,393 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joykk/gorm-cache/util"
+)
+
+var (
+	_ DataStorage = &Dynamo{}
+	_ Snapshotter = &Dynamo{}
+	_ KeyCounter  = &Dynamo{}
+	_ KeyLister   = &Dynamo{}
+	_ TTLReader   = &Dynamo{}
+	_ Expirer     = &Dynamo{}
+)
+
+const (
+	// dynamoBatchGetSize and dynamoBatchWriteSize are the item limits of BatchGetItem and BatchWriteItem
+	dynamoBatchGetSize   = 100
+	dynamoBatchWriteSize = 25
+
+	// defaultDynamoPrefixSegments groups the keys of the default key layout by table and kind of cache,
+	// e.g. "gormcache:<instance>:s:<table>"
+	defaultDynamoPrefixSegments = 4
+)
+
+// DynamoClient is the subset of DynamoDB the Dynamo store is built on, e.g. a thin adapter of the DynamoDB
+// client of github.com/aws/aws-sdk-go-v2. The store expects a single table of items with:
+//
+//   - a string partition key holding DynamoItem.Key
+//   - a string attribute holding DynamoItem.Prefix, the partition key of a global secondary index whose sort
+//     key is the partition key of the table, so that the keys of a prefix are queried rather than scanned
+//   - a string attribute holding DynamoItem.Value
+//   - a number attribute holding DynamoItem.ExpiresAt, set as the TTL attribute of the table
+//
+// Batches are sized within the limits of DynamoDB, adapters retry the unprocessed keys of a batch.
+type DynamoClient interface {
+	// BatchGet returns the items of the keys found (BatchGetItem), at most 100 keys at a time. Items expired
+	// but not yet deleted by DynamoDB may be returned, the store skips them
+	BatchGet(ctx context.Context, keys []string) (map[string]DynamoItem, error)
+	// BatchPut writes the items (BatchWriteItem), at most 25 at a time
+	BatchPut(ctx context.Context, items []DynamoItem) error
+	// BatchDelete deletes the keys (BatchWriteItem), at most 25 at a time, missing keys are not an error
+	BatchDelete(ctx context.Context, keys []string) error
+	// QueryKeys calls fn with the keys of the items of prefix starting with keyPrefix, a Query of the prefix
+	// index with begins_with on its sort key, page after page until fn returns false
+	QueryKeys(ctx context.Context, prefix string, keyPrefix string, fn func(keys []string) bool) error
+	// ScanKeys calls fn with the keys of the table starting with keyPrefix, a Scan filtered with begins_with,
+	// page after page until fn returns false
+	ScanKeys(ctx context.Context, keyPrefix string, fn func(keys []string) bool) error
+}
+
+// DynamoItem an item of the table of a Dynamo store
+type DynamoItem struct {
+	Key       string
+	Prefix    string // the first DynamoStoreConfig.PrefixSegments segments of the key, see Dynamo
+	Value     string
+	ExpiresAt int64 // unix seconds the item expires at, 0 never expires
+}
+
+type DynamoStoreConfig struct {
+	KeyPrefix string // every key is stored under this prefix, it will be random if not set
+
+	Client DynamoClient
+
+	// PrefixSegments number of ":" separated segments of a cache key stored as the prefix of its item. Prefix
+	// deletes of at least as many segments query the prefix index, shorter ones (CleanCache, deletes of every
+	// table of an instance) scan the table. 0 represents 4, a table and kind of cache of the default key layout
+	PrefixSegments int
+}
+
+// NewDynamo creates a storage on a DynamoDB table, for deployments without redis. Keys expire by the TTL
+// attribute of the table, rounded up to the second, and expired items DynamoDB hasn't deleted yet are misses.
+// Items are grouped by the prefix of their key, see DynamoStoreConfig.PrefixSegments, so that invalidating
+// the cache of a table queries its keys instead of scanning the table.
+func NewDynamo(config ...*DynamoStoreConfig) *Dynamo {
+	if len(config) == 0 {
+		panic("dynamo config is required")
+	}
+	if config[0].Client == nil {
+		panic("dynamo client is required")
+	}
+	if config[0].KeyPrefix == "" {
+		config[0].KeyPrefix = util.GormCachePrefix + ":" + util.GenInstanceId()
+	}
+	if config[0].PrefixSegments <= 0 {
+		config[0].PrefixSegments = defaultDynamoPrefixSegments
+	}
+	return &Dynamo{config: config[0]}
+}
+
+type Dynamo struct {
+	config *DynamoStoreConfig
+	ttl    int64
+	jitter float64
+	logger util.LoggerInterface
+
+	once sync.Once
+}
+
+func (d *Dynamo) Init(conf *Config) error {
+	d.once.Do(func() {
+		d.ttl = conf.TTL
+		d.jitter = conf.Jitter
+		d.logger = conf.Logger
+		d.logger.SetIsDebug(conf.Debug)
+	})
+	return nil
+}
+
+func (d *Dynamo) key(key string) string {
+	return d.config.KeyPrefix + ":" + key
+}
+
+// prefix returns the prefix the items of keyPrefix are grouped by, false if keyPrefix has less segments
+// than PrefixSegments and its keys span several prefixes
+func (d *Dynamo) prefix(keyPrefix string) (string, bool) {
+	segments := strings.SplitN(keyPrefix, ":", d.config.PrefixSegments+1)
+	if len(segments) <= d.config.PrefixSegments {
+		return "", false
+	}
+	return d.key(strings.Join(segments[:d.config.PrefixSegments], ":")), true
+}
+
+// itemPrefix returns the prefix of the item of key, keys with less segments are their own prefix
+func (d *Dynamo) itemPrefix(key string) string {
+	if prefix, ok := d.prefix(key); ok {
+		return prefix
+	}
+	return d.key(key)
+}
+
+// CleanCache deletes the keys under KeyPrefix scanning the table, the other items of the table are kept
+func (d *Dynamo) CleanCache(ctx context.Context) error {
+	if err := d.deleteKeys(ctx, d.config.KeyPrefix+":"); err != nil {
+		d.logger.CtxError(ctx, "[CleanCache] clean cache error: %v", err)
+		return err
+	}
+	return nil
+}
+
+// Ping reads a key, the table is reachable if the read succeeds, found or not
+func (d *Dynamo) Ping(ctx context.Context) error {
+	_, err := d.config.Client.BatchGet(ctx, []string{d.key("ping")})
+	return err
+}
+
+func (d *Dynamo) BatchKeyExist(ctx context.Context, keys []string) (bool, error) {
+	values, err := d.BatchGetValues(ctx, keys)
+	if err != nil {
+		return false, err
+	}
+	for _, value := range values {
+		if value == "" {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (d *Dynamo) KeyExists(ctx context.Context, key string) (bool, error) {
+	return d.BatchKeyExist(ctx, []string{key})
+}
+
+func (d *Dynamo) GetValue(ctx context.Context, key string) (string, error) {
+	values, err := d.BatchGetValues(ctx, []string{key})
+	if err != nil {
+		return "", err
+	}
+	if values[0] == "" {
+		return "", ErrCacheNotFound
+	}
+	return values[0], nil
+}
+
+func (d *Dynamo) BatchGetValues(ctx context.Context, keys []string) ([]string, error) {
+	items, err := d.getItems(ctx, keys)
+	if err != nil {
+		d.logger.CtxError(ctx, "[BatchGetValues] get error: %v", err)
+		return nil, err
+	}
+	values := make([]string, len(keys))
+	for idx, key := range keys {
+		values[idx] = items[d.key(key)].Value
+	}
+	return values, nil
+}
+
+// getItems returns the live items of keys by their stored key, 100 keys at a time
+func (d *Dynamo) getItems(ctx context.Context, keys []string) (map[string]DynamoItem, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	items := make(map[string]DynamoItem, len(keys))
+	for start := 0; start < len(keys); start += dynamoBatchGetSize {
+		end := start + dynamoBatchGetSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		storeKeys := make([]string, 0, end-start)
+		for _, key := range keys[start:end] {
+			storeKeys = append(storeKeys, d.key(key))
+		}
+		found, err := d.config.Client.BatchGet(ctx, storeKeys)
+		if err != nil {
+			return nil, err
+		}
+		for storeKey, item := range found {
+			// DynamoDB deletes expired items lazily, up to days later
+			if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+				continue
+			}
+			items[storeKey] = item
+		}
+	}
+	return items, nil
+}
+
+func (d *Dynamo) DeleteKeysWithPrefix(ctx context.Context, keyPrefix string) error {
+	if err := d.deleteKeys(ctx, d.key(separated(keyPrefix))); err != nil {
+		d.logger.CtxError(ctx, "[DeleteKeysWithPrefix] delete keys error: %v", err)
+		return err
+	}
+	return nil
+}
+
+// deleteKeys deletes the stored keys starting with storePrefix, collected before being deleted so that
+// the deletes don't shift the pages of the query
+func (d *Dynamo) deleteKeys(ctx context.Context, storePrefix string) error {
+	keys := make([]string, 0)
+	if err := d.iterateKeys(ctx, storePrefix, func(page []string) bool {
+		keys = append(keys, page...)
+		return true
+	}); err != nil {
+		return err
+	}
+	return d.deleteStoreKeys(ctx, keys)
+}
+
+// iterateKeys calls fn with the stored keys starting with storePrefix, querying the prefix index if they
+// share a prefix and scanning the table otherwise
+func (d *Dynamo) iterateKeys(ctx context.Context, storePrefix string, fn func(keys []string) bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if prefix, ok := d.prefix(strings.TrimPrefix(storePrefix, d.config.KeyPrefix+":")); ok {
+		return d.config.Client.QueryKeys(ctx, prefix, storePrefix, fn)
+	}
+	return d.config.Client.ScanKeys(ctx, storePrefix, fn)
+}
+
+func (d *Dynamo) deleteStoreKeys(ctx context.Context, storeKeys []string) error {
+	for start := 0; start < len(storeKeys); start += dynamoBatchWriteSize {
+		end := start + dynamoBatchWriteSize
+		if end > len(storeKeys) {
+			end = len(storeKeys)
+		}
+		if err := d.config.Client.BatchDelete(ctx, storeKeys[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Dynamo) DeleteKey(ctx context.Context, key string) error {
+	return d.BatchDeleteKeys(ctx, []string{key})
+}
+
+func (d *Dynamo) BatchDeleteKeys(ctx context.Context, keys []string) error {
+	storeKeys := make([]string, len(keys))
+	for idx, key := range keys {
+		storeKeys[idx] = d.key(key)
+	}
+	if err := d.deleteStoreKeys(ctx, storeKeys); err != nil {
+		d.logger.CtxError(ctx, "[BatchDeleteKeys] delete keys error: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (d *Dynamo) BatchSetKeys(ctx context.Context, kvs []util.Kv) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for start := 0; start < len(kvs); start += dynamoBatchWriteSize {
+		end := start + dynamoBatchWriteSize
+		if end > len(kvs) {
+			end = len(kvs)
+		}
+		items := make([]DynamoItem, 0, end-start)
+		for _, kv := range kvs[start:end] {
+			items = append(items, DynamoItem{
+				Key:       d.key(kv.Key),
+				Prefix:    d.itemPrefix(kv.Key),
+				Value:     kv.Value,
+				ExpiresAt: d.expiresAt(kv),
+			})
+		}
+		if err := d.config.Client.BatchPut(ctx, items); err != nil {
+			d.logger.CtxError(ctx, "[BatchSetKeys] set keys error: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Dynamo) SetKey(ctx context.Context, kv util.Kv) error {
+	return d.BatchSetKeys(ctx, []util.Kv{kv})
+}
+
+// expiresAt returns the unix seconds kv expires at with its jittered ttl, rounded up, 0 if it doesn't expire
+func (d *Dynamo) expiresAt(kv util.Kv) int64 {
+	ttl := d.ttl
+	if kv.TTL > 0 {
+		ttl = kv.TTL
+	}
+	if ttl <= 0 {
+		return 0
+	}
+	expiresAt := time.Now().UnixMilli() + util.JitterInt64(ttl, d.jitter)
+	return (expiresAt + 999) / 1000
+}
+
+func (d *Dynamo) CountKeysWithPrefix(ctx context.Context, keyPrefix string) (int64, error) {
+	var count int64
+	err := d.iterateKeys(ctx, d.key(keyPrefix), func(keys []string) bool {
+		count += int64(len(keys))
+		return true
+	})
+	return count, err
+}
+
+func (d *Dynamo) ListKeysWithPrefix(ctx context.Context, keyPrefix string, limit int) ([]string, error) {
+	keys := make([]string, 0)
+	err := d.iterateKeys(ctx, d.key(keyPrefix), func(page []string) bool {
+		for _, key := range page {
+			if len(keys) >= limit {
+				return false
+			}
+			keys = append(keys, strings.TrimPrefix(key, d.config.KeyPrefix+":"))
+		}
+		return len(keys) < limit
+	})
+	return keys, err
+}
+
+func (d *Dynamo) KeyTTL(ctx context.Context, key string) (int64, error) {
+	items, err := d.getItems(ctx, []string{key})
+	if err != nil {
+		return 0, err
+	}
+	item, ok := items[d.key(key)]
+	if !ok {
+		return 0, ErrCacheNotFound
+	}
+	if item.ExpiresAt == 0 {
+		return 0, nil
+	}
+	ttl := item.ExpiresAt*1000 - time.Now().UnixMilli()
+	if ttl <= 0 {
+		// expires within the current second
+		ttl = 1
+	}
+	return ttl, nil
+}
+
+// ExpireKeys writes the items of the cached keys back with their new expiry, DynamoDB can't update the
+// expiry of items in batches
+func (d *Dynamo) ExpireKeys(ctx context.Context, keys []string, ttl int64) error {
+	items, err := d.getItems(ctx, keys)
+	if err != nil {
+		return err
+	}
+	kvs := make([]util.Kv, 0, len(items))
+	for _, key := range keys {
+		if item, ok := items[d.key(key)]; ok {
+			kvs = append(kvs, util.Kv{Key: key, Value: item.Value, TTL: ttl})
+		}
+	}
+	return d.BatchSetKeys(ctx, kvs)
+}
+
+func (d *Dynamo) Snapshot() map[string]interface{} {
+	return map[string]interface{}{
+		"keyPrefix":      d.config.KeyPrefix,
+		"prefixSegments": d.config.PrefixSegments,
+	}
+}
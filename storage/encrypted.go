@@ -0,0 +1,334 @@
+package storage
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/joykk/gorm-cache/util"
+)
+
+var (
+	_ DataStorage     = &Encrypted{}
+	_ Snapshotter     = &Encrypted{}
+	_ KeyCounter      = &Encrypted{}
+	_ KeyLister       = &Encrypted{}
+	_ TTLReader       = &Encrypted{}
+	_ Expirer         = &Encrypted{}
+	_ EvictionCounter = &Encrypted{}
+	_ Closer          = &Encrypted{}
+	_ Versioner       = &Encrypted{}
+	_ KeyProvider     = StaticKey(nil)
+)
+
+// encryptionSeparator delimits the key id in front of an encrypted value, key ids must not contain it
+const encryptionSeparator = '\x1e'
+
+// KeyProvider supplies the AES keys of an Encrypted storage, e.g. fetched from a KMS. Keys are 16, 24 or
+// 32 bytes long, for AES-128, AES-192 or AES-256. The key of an id must never change, the id is stored
+// with every value encrypted by the key to decrypt it after a rotation
+type KeyProvider interface {
+	// CurrentKey returns the key new values are encrypted with, and its id
+	CurrentKey(ctx context.Context) (id string, key []byte, err error)
+	// Key returns the key of id, values of unknown ids are misses
+	Key(ctx context.Context, id string) ([]byte, error)
+}
+
+// StaticKey is a KeyProvider of a single key, its id derived from the key so that values encrypted
+// with a key replaced since are misses
+type StaticKey []byte
+
+func (k StaticKey) CurrentKey(context.Context) (string, []byte, error) {
+	return k.id(), k, nil
+}
+
+func (k StaticKey) Key(_ context.Context, id string) ([]byte, error) {
+	if id != k.id() {
+		return nil, fmt.Errorf("unknown encryption key %q", id)
+	}
+	return k, nil
+}
+
+func (k StaticKey) id() string {
+	sum := sha256.Sum256(k)
+	return hex.EncodeToString(sum[:4])
+}
+
+type EncryptedStoreConfig struct {
+	Storage DataStorage // the storage keeping the encrypted values
+	Keys    KeyProvider // the keys values are encrypted with
+}
+
+// NewEncrypted creates a storage encrypting values with AES-GCM before they reach config.Storage, e.g.
+// a redis shared with other teams. Values are bound to their key: a value copied under another key, tampered
+// with, encrypted with an unknown key or not encrypted at all is a miss. Keys are stored in clear.
+func NewEncrypted(config ...*EncryptedStoreConfig) *Encrypted {
+	if len(config) == 0 {
+		panic("encrypted config is required")
+	}
+	if config[0].Storage == nil {
+		panic("encrypted storage is required")
+	}
+	if config[0].Keys == nil {
+		panic("encrypted keys are required")
+	}
+	return &Encrypted{config: config[0]}
+}
+
+type Encrypted struct {
+	config *EncryptedStoreConfig
+	logger util.LoggerInterface
+	aeads  sync.Map // key id -> cipher.AEAD
+
+	once sync.Once
+}
+
+func (e *Encrypted) Init(conf *Config) error {
+	var err error
+	e.once.Do(func() {
+		e.logger = conf.Logger
+		// fail early on a missing or malformed key rather than on every write
+		var id string
+		var key []byte
+		if id, key, err = e.config.Keys.CurrentKey(context.Background()); err != nil {
+			return
+		}
+		if _, err = e.aead(id, key); err != nil {
+			return
+		}
+		err = e.config.Storage.Init(conf)
+	})
+	return err
+}
+
+func (e *Encrypted) CleanCache(ctx context.Context) error {
+	return e.config.Storage.CleanCache(ctx)
+}
+
+func (e *Encrypted) Ping(ctx context.Context) error {
+	return e.config.Storage.Ping(ctx)
+}
+
+func (e *Encrypted) BatchKeyExist(ctx context.Context, keys []string) (bool, error) {
+	return e.config.Storage.BatchKeyExist(ctx, keys)
+}
+
+func (e *Encrypted) KeyExists(ctx context.Context, key string) (bool, error) {
+	return e.config.Storage.KeyExists(ctx, key)
+}
+
+func (e *Encrypted) GetValue(ctx context.Context, key string) (string, error) {
+	value, err := e.config.Storage.GetValue(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	value, err = e.decrypt(ctx, key, value)
+	if err != nil {
+		e.logger.CtxError(ctx, "[GetValue] decrypt value of key %s error: %v", key, err)
+		return "", ErrCacheNotFound
+	}
+	return value, nil
+}
+
+func (e *Encrypted) BatchGetValues(ctx context.Context, keys []string) ([]string, error) {
+	values, err := e.config.Storage.BatchGetValues(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	for idx, value := range values {
+		if value == "" {
+			continue
+		}
+		if values[idx], err = e.decrypt(ctx, keys[idx], value); err != nil {
+			e.logger.CtxError(ctx, "[BatchGetValues] decrypt value of key %s error: %v", keys[idx], err)
+			values[idx] = ""
+		}
+	}
+	return values, nil
+}
+
+func (e *Encrypted) DeleteKeysWithPrefix(ctx context.Context, keyPrefix string) error {
+	return e.config.Storage.DeleteKeysWithPrefix(ctx, keyPrefix)
+}
+
+func (e *Encrypted) DeleteKey(ctx context.Context, key string) error {
+	return e.config.Storage.DeleteKey(ctx, key)
+}
+
+func (e *Encrypted) BatchDeleteKeys(ctx context.Context, keys []string) error {
+	return e.config.Storage.BatchDeleteKeys(ctx, keys)
+}
+
+func (e *Encrypted) BatchSetKeys(ctx context.Context, kvs []util.Kv) error {
+	encrypted := make([]util.Kv, 0, len(kvs))
+	for _, kv := range kvs {
+		value, err := e.encrypt(ctx, kv.Key, kv.Value)
+		if err != nil {
+			return err
+		}
+		encrypted = append(encrypted, util.Kv{Key: kv.Key, Value: value, TTL: kv.TTL})
+	}
+	return e.config.Storage.BatchSetKeys(ctx, encrypted)
+}
+
+func (e *Encrypted) SetKey(ctx context.Context, kv util.Kv) error {
+	value, err := e.encrypt(ctx, kv.Key, kv.Value)
+	if err != nil {
+		return err
+	}
+	return e.config.Storage.SetKey(ctx, util.Kv{Key: kv.Key, Value: value, TTL: kv.TTL})
+}
+
+// encrypt seals value with the current key, its storage key as additional data. The value is stored
+// as the key id, the nonce and the ciphertext
+func (e *Encrypted) encrypt(ctx context.Context, key string, value string) (string, error) {
+	id, secret, err := e.config.Keys.CurrentKey(ctx)
+	if err != nil {
+		return "", err
+	}
+	aead, err := e.aead(id, secret)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(value)+aead.Overhead())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(key))
+	return string(encryptionSeparator) + id + string(encryptionSeparator) + string(sealed), nil
+}
+
+func (e *Encrypted) decrypt(ctx context.Context, key string, value string) (string, error) {
+	if len(value) == 0 || value[0] != encryptionSeparator {
+		return "", fmt.Errorf("value isn't encrypted")
+	}
+	end := strings.IndexRune(value[1:], encryptionSeparator)
+	if end < 0 {
+		return "", fmt.Errorf("malformed encrypted value header")
+	}
+	id, sealed := value[1:end+1], value[end+2:]
+	aead, ok := e.cachedAEAD(id)
+	if !ok {
+		secret, err := e.config.Keys.Key(ctx, id)
+		if err != nil {
+			return "", err
+		}
+		if aead, err = e.aead(id, secret); err != nil {
+			return "", err
+		}
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("encrypted value too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	data, err := aead.Open(nil, []byte(nonce), []byte(ciphertext), []byte(key))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (e *Encrypted) cachedAEAD(id string) (cipher.AEAD, bool) {
+	aead, ok := e.aeads.Load(id)
+	if !ok {
+		return nil, false
+	}
+	return aead.(cipher.AEAD), true
+}
+
+// aead returns the cipher of the key of id, built on first use
+func (e *Encrypted) aead(id string, secret []byte) (cipher.AEAD, error) {
+	if aead, ok := e.cachedAEAD(id); ok {
+		return aead, nil
+	}
+	if strings.ContainsRune(id, encryptionSeparator) {
+		return nil, fmt.Errorf("encryption key id %q contains the header separator", id)
+	}
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	e.aeads.Store(id, aead)
+	return aead, nil
+}
+
+// ExpireKeys refreshes the expiry if the underlying storage can, and does nothing otherwise
+func (e *Encrypted) ExpireKeys(ctx context.Context, keys []string, ttl int64) error {
+	if expirer, ok := e.config.Storage.(Expirer); ok {
+		return expirer.ExpireKeys(ctx, keys, ttl)
+	}
+	return nil
+}
+
+func (e *Encrypted) BumpVersion(ctx context.Context, sequenceKey string, versionKeys []string, ttl int64) (int64, error) {
+	versioner, ok := e.config.Storage.(Versioner)
+	if !ok {
+		return 0, fmt.Errorf("%T can't keep versions", e.config.Storage)
+	}
+	return versioner.BumpVersion(ctx, sequenceKey, versionKeys, ttl)
+}
+
+func (e *Encrypted) SetKeysIfVersion(ctx context.Context, kvs []util.Kv, versionKeys [][]string, version int64) ([]bool, error) {
+	versioner, ok := e.config.Storage.(Versioner)
+	if !ok {
+		return nil, fmt.Errorf("%T can't keep versions", e.config.Storage)
+	}
+	encrypted := make([]util.Kv, 0, len(kvs))
+	for _, kv := range kvs {
+		value, err := e.encrypt(ctx, kv.Key, kv.Value)
+		if err != nil {
+			return nil, err
+		}
+		encrypted = append(encrypted, util.Kv{Key: kv.Key, Value: value, TTL: kv.TTL})
+	}
+	return versioner.SetKeysIfVersion(ctx, encrypted, versionKeys, version)
+}
+
+func (e *Encrypted) CountKeysWithPrefix(ctx context.Context, keyPrefix string) (int64, error) {
+	counter, ok := e.config.Storage.(KeyCounter)
+	if !ok {
+		return 0, fmt.Errorf("%T can't count keys", e.config.Storage)
+	}
+	return counter.CountKeysWithPrefix(ctx, keyPrefix)
+}
+
+func (e *Encrypted) ListKeysWithPrefix(ctx context.Context, keyPrefix string, limit int) ([]string, error) {
+	lister, ok := e.config.Storage.(KeyLister)
+	if !ok {
+		return nil, fmt.Errorf("%T can't list keys", e.config.Storage)
+	}
+	return lister.ListKeysWithPrefix(ctx, keyPrefix, limit)
+}
+
+func (e *Encrypted) KeyTTL(ctx context.Context, key string) (int64, error) {
+	reader, ok := e.config.Storage.(TTLReader)
+	if !ok {
+		return 0, fmt.Errorf("%T can't read ttls", e.config.Storage)
+	}
+	return reader.KeyTTL(ctx, key)
+}
+
+func (e *Encrypted) EvictionCount() uint64 {
+	return evictionCount(e.config.Storage)
+}
+
+func (e *Encrypted) Close() error {
+	return closeStorage(e.config.Storage)
+}
+
+func (e *Encrypted) Snapshot() map[string]interface{} {
+	return map[string]interface{}{
+		"keys":    fmt.Sprintf("%T", e.config.Keys),
+		"storage": describeStorage(e.config.Storage),
+	}
+}
@@ -0,0 +1,286 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/joykk/gorm-cache/util"
+)
+
+var (
+	_ DataStorage     = &Fallback{}
+	_ Snapshotter     = &Fallback{}
+	_ KeyCounter      = &Fallback{}
+	_ KeyLister       = &Fallback{}
+	_ TTLReader       = &Fallback{}
+	_ EvictionCounter = &Fallback{}
+	_ Closer          = &Fallback{}
+)
+
+type FallbackStoreConfig struct {
+	Remote DataStorage // the store serving reads as long as it answers within Timeout
+	Local  DataStorage // in-process tier used when Remote is slow, a memory store if not set
+
+	// Timeout bounds remote reads, a read still pending after it is served by Local,
+	// and a Local miss is reported as a cache miss so the query goes to the database
+	Timeout time.Duration
+}
+
+// NewFallback creates a two tier storage that bounds the tail latency of a slow remote store.
+// Writes go to both tiers and reads answered by the remote store populate the local one.
+func NewFallback(config ...*FallbackStoreConfig) *Fallback {
+	if len(config) == 0 {
+		panic("fallback config is required")
+	}
+	if config[0].Remote == nil {
+		panic("fallback remote storage is required")
+	}
+	if config[0].Timeout <= 0 {
+		panic("fallback timeout must be positive")
+	}
+	if config[0].Local == nil {
+		config[0].Local = NewMem(DefaultMemStoreConfig)
+	}
+	return &Fallback{config: config[0]}
+}
+
+type Fallback struct {
+	config *FallbackStoreConfig
+	logger util.LoggerInterface
+
+	once sync.Once
+}
+
+func (f *Fallback) Init(conf *Config) error {
+	var err error
+	f.once.Do(func() {
+		f.logger = conf.Logger
+		if err = f.config.Remote.Init(conf); err != nil {
+			return
+		}
+		err = f.config.Local.Init(conf)
+	})
+	return err
+}
+
+// raceRead returns the remote result if it arrives within the timeout, the local one otherwise
+func raceRead[T any](ctx context.Context, f *Fallback, op string,
+	remote func(ctx context.Context) (T, error), local func(ctx context.Context) (T, error)) (T, bool, error) {
+	type result struct {
+		value T
+		err   error
+	}
+	remoteCtx, cancel := context.WithCancel(ctx)
+	done := make(chan result, 1)
+	go func() {
+		value, err := remote(remoteCtx)
+		done <- result{value: value, err: err}
+	}()
+
+	timer := time.NewTimer(f.config.Timeout)
+	defer timer.Stop()
+	select {
+	case res := <-done:
+		cancel()
+		return res.value, true, res.err
+	case <-timer.C:
+		// the remote read is abandoned, the local tier answers instead
+		cancel()
+		f.logger.CtxInfo(ctx, "[%s] remote read timed out after %v, falling back to local", op, f.config.Timeout)
+		value, err := local(ctx)
+		return value, false, err
+	}
+}
+
+func (f *Fallback) CleanCache(ctx context.Context) error {
+	if err := f.config.Remote.CleanCache(ctx); err != nil {
+		return err
+	}
+	return f.config.Local.CleanCache(ctx)
+}
+
+// Ping checks the remote storage, the local one only answers while the remote is slow
+func (f *Fallback) Ping(ctx context.Context) error {
+	return f.config.Remote.Ping(ctx)
+}
+
+func (f *Fallback) BatchKeyExist(ctx context.Context, keys []string) (bool, error) {
+	exists, _, err := raceRead(ctx, f, "BatchKeyExist", func(ctx context.Context) (bool, error) {
+		return f.config.Remote.BatchKeyExist(ctx, keys)
+	}, func(ctx context.Context) (bool, error) {
+		return f.config.Local.BatchKeyExist(ctx, keys)
+	})
+	return exists, err
+}
+
+func (f *Fallback) KeyExists(ctx context.Context, key string) (bool, error) {
+	exists, _, err := raceRead(ctx, f, "KeyExists", func(ctx context.Context) (bool, error) {
+		return f.config.Remote.KeyExists(ctx, key)
+	}, func(ctx context.Context) (bool, error) {
+		return f.config.Local.KeyExists(ctx, key)
+	})
+	return exists, err
+}
+
+func (f *Fallback) GetValue(ctx context.Context, key string) (string, error) {
+	value, fromRemote, err := raceRead(ctx, f, "GetValue", func(ctx context.Context) (string, error) {
+		return f.config.Remote.GetValue(ctx, key)
+	}, func(ctx context.Context) (string, error) {
+		return f.config.Local.GetValue(ctx, key)
+	})
+	if err == nil && fromRemote {
+		f.populateLocal(ctx, []util.Kv{{Key: key, Value: value}})
+	}
+	return value, err
+}
+
+func (f *Fallback) BatchGetValues(ctx context.Context, keys []string) ([]string, error) {
+	values, fromRemote, err := raceRead(ctx, f, "BatchGetValues", func(ctx context.Context) ([]string, error) {
+		return f.config.Remote.BatchGetValues(ctx, keys)
+	}, func(ctx context.Context) ([]string, error) {
+		return f.config.Local.BatchGetValues(ctx, keys)
+	})
+	if err == nil && fromRemote {
+		kvs := make([]util.Kv, 0, len(values))
+		for idx, value := range values {
+			if value != "" {
+				kvs = append(kvs, util.Kv{Key: keys[idx], Value: value})
+			}
+		}
+		f.populateLocal(ctx, kvs)
+	}
+	return values, err
+}
+
+func (f *Fallback) populateLocal(ctx context.Context, kvs []util.Kv) {
+	if len(kvs) == 0 {
+		return
+	}
+	if err := f.config.Local.BatchSetKeys(ctx, kvs); err != nil {
+		f.logger.CtxError(ctx, "[populateLocal] set local keys error: %v", err)
+	}
+}
+
+// ExpireKeys refreshes the expiry in the tiers that can refresh it
+func (f *Fallback) ExpireKeys(ctx context.Context, keys []string, ttl int64) error {
+	if expirer, ok := f.config.Remote.(Expirer); ok {
+		if err := expirer.ExpireKeys(ctx, keys, ttl); err != nil {
+			return err
+		}
+	}
+	if expirer, ok := f.config.Local.(Expirer); ok {
+		return expirer.ExpireKeys(ctx, keys, ttl)
+	}
+	return nil
+}
+
+func (f *Fallback) DeleteKeysWithPrefix(ctx context.Context, keyPrefix string) error {
+	if err := f.config.Remote.DeleteKeysWithPrefix(ctx, keyPrefix); err != nil {
+		return err
+	}
+	return f.config.Local.DeleteKeysWithPrefix(ctx, keyPrefix)
+}
+
+func (f *Fallback) DeleteKey(ctx context.Context, key string) error {
+	if err := f.config.Remote.DeleteKey(ctx, key); err != nil {
+		return err
+	}
+	return f.config.Local.DeleteKey(ctx, key)
+}
+
+func (f *Fallback) BatchDeleteKeys(ctx context.Context, keys []string) error {
+	if err := f.config.Remote.BatchDeleteKeys(ctx, keys); err != nil {
+		return err
+	}
+	return f.config.Local.BatchDeleteKeys(ctx, keys)
+}
+
+func (f *Fallback) BatchSetKeys(ctx context.Context, kvs []util.Kv) error {
+	if err := f.config.Remote.BatchSetKeys(ctx, kvs); err != nil {
+		return err
+	}
+	return f.config.Local.BatchSetKeys(ctx, kvs)
+}
+
+func (f *Fallback) SetKey(ctx context.Context, kv util.Kv) error {
+	if err := f.config.Remote.SetKey(ctx, kv); err != nil {
+		return err
+	}
+	return f.config.Local.SetKey(ctx, kv)
+}
+
+// CountKeysWithPrefix counts the keys of the remote store, the local one only holds a part of them
+func (f *Fallback) CountKeysWithPrefix(ctx context.Context, keyPrefix string) (int64, error) {
+	counter, ok := f.config.Remote.(KeyCounter)
+	if !ok {
+		return 0, fmt.Errorf("%T can't count keys", f.config.Remote)
+	}
+	return counter.CountKeysWithPrefix(ctx, keyPrefix)
+}
+
+// EvictionCount returns the evictions of both tiers
+func (f *Fallback) EvictionCount() uint64 {
+	return evictionCount(f.config.Remote) + evictionCount(f.config.Local)
+}
+
+// Close closes both tiers, returning the first error
+func (f *Fallback) Close() error {
+	remoteErr := closeStorage(f.config.Remote)
+	if err := closeStorage(f.config.Local); remoteErr == nil {
+		return err
+	}
+	return remoteErr
+}
+
+func (f *Fallback) Snapshot() map[string]interface{} {
+	return map[string]interface{}{
+		"timeout": f.config.Timeout.String(),
+		"remote":  describeStorage(f.config.Remote),
+		"local":   describeStorage(f.config.Local),
+	}
+}
+
+// evictionCount returns the evictions of a wrapped storage, 0 if it doesn't count them
+func evictionCount(s DataStorage) uint64 {
+	if counter, ok := s.(EvictionCounter); ok {
+		return counter.EvictionCount()
+	}
+	return 0
+}
+
+// closeStorage closes a wrapped storage if it is a Closer
+func closeStorage(s DataStorage) error {
+	if closer, ok := s.(Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// describeStorage describes a wrapped storage in the same shape as a top level one
+func describeStorage(s DataStorage) map[string]interface{} {
+	description := map[string]interface{}{"type": fmt.Sprintf("%T", s)}
+	if snapshotter, ok := s.(Snapshotter); ok {
+		description["settings"] = snapshotter.Snapshot()
+	}
+	return description
+}
+
+// ListKeysWithPrefix lists the keys of the remote store, the local one only holds a part of them
+func (f *Fallback) ListKeysWithPrefix(ctx context.Context, keyPrefix string, limit int) ([]string, error) {
+	lister, ok := f.config.Remote.(KeyLister)
+	if !ok {
+		return nil, fmt.Errorf("%T can't list keys", f.config.Remote)
+	}
+	return lister.ListKeysWithPrefix(ctx, keyPrefix, limit)
+}
+
+// KeyTTL reads the expiry of the remote store, local copies may expire earlier
+func (f *Fallback) KeyTTL(ctx context.Context, key string) (int64, error) {
+	reader, ok := f.config.Remote.(TTLReader)
+	if !ok {
+		return 0, fmt.Errorf("%T can't read ttls", f.config.Remote)
+	}
+	return reader.KeyTTL(ctx, key)
+}
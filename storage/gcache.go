@@ -9,7 +9,12 @@ import (
 	"time"
 )
 
-var _ DataStorage = &Gcache{}
+var (
+	_ DataStorage = &Gcache{}
+	_ KeyCounter  = &Gcache{}
+	_ KeyLister   = &Gcache{}
+	_ Expirer     = &Gcache{}
+)
 
 func NewGcache(builder *gcache.CacheBuilder) *Gcache {
 	if builder == nil {
@@ -21,6 +26,8 @@ func NewGcache(builder *gcache.CacheBuilder) *Gcache {
 type Gcache struct {
 	builder *gcache.CacheBuilder
 	cache   gcache.Cache
+	ttl     int64
+	jitter  float64
 	sync.RWMutex
 
 	once sync.Once
@@ -29,9 +36,11 @@ type Gcache struct {
 func (g *Gcache) Init(config *Config) error {
 	g.once.Do(func() {
 		if config.TTL != 0 {
-			g.builder.Expiration(time.Duration(config.TTL) * time.Microsecond)
+			g.builder.Expiration(time.Duration(config.TTL) * time.Millisecond)
 		}
 		g.cache = g.builder.Build()
+		g.ttl = config.TTL
+		g.jitter = config.Jitter
 	})
 	return nil
 }
@@ -57,6 +66,9 @@ func (g *Gcache) GetValue(ctx context.Context, key string) (string, error) {
 	g.RLock()
 	defer g.RUnlock()
 	v, err := g.cache.Get(key)
+	if err == gcache.KeyNotFoundError {
+		return "", ErrCacheNotFound
+	}
 	if err != nil {
 		return "", err
 	}
@@ -66,13 +78,16 @@ func (g *Gcache) GetValue(ctx context.Context, key string) (string, error) {
 func (g *Gcache) BatchGetValues(ctx context.Context, keys []string) ([]string, error) {
 	g.RLock()
 	defer g.RUnlock()
-	values := make([]string, 0, len(keys))
-	for _, key := range keys {
+	values := make([]string, len(keys))
+	for idx, key := range keys {
 		v, err := g.cache.Get(key)
+		if err == gcache.KeyNotFoundError {
+			continue
+		}
 		if err != nil {
 			return nil, err
 		}
-		values = append(values, v.(string))
+		values[idx] = v.(string)
 	}
 	return values, nil
 }
@@ -84,6 +99,11 @@ func (g *Gcache) CleanCache(ctx context.Context) error {
 	return nil
 }
 
+// Ping always succeeds, the store lives in the process
+func (g *Gcache) Ping(context.Context) error {
+	return nil
+}
+
 func (g *Gcache) DeleteKeysWithPrefix(ctx context.Context, keyPrefix string) error {
 	g.Lock()
 	defer g.Unlock()
@@ -116,7 +136,7 @@ func (g *Gcache) BatchSetKeys(ctx context.Context, kvs []util.Kv) error {
 	g.Lock()
 	defer g.Unlock()
 	for _, kv := range kvs {
-		if err := g.SetKey(ctx, kv); err != nil {
+		if err := g.set(kv); err != nil {
 			return err
 		}
 	}
@@ -126,5 +146,69 @@ func (g *Gcache) BatchSetKeys(ctx context.Context, kvs []util.Kv) error {
 func (g *Gcache) SetKey(ctx context.Context, kv util.Kv) error {
 	g.Lock()
 	defer g.Unlock()
+	return g.set(kv)
+}
+
+func (g *Gcache) set(kv util.Kv) error {
+	ttl := kv.TTL
+	if ttl <= 0 && g.jitter > 0 {
+		// the builder's expiration isn't randomized, expire the key explicitly
+		ttl = g.ttl
+	}
+	if ttl > 0 {
+		return g.cache.SetWithExpire(kv.Key, kv.Value, time.Duration(util.JitterInt64(ttl, g.jitter))*time.Millisecond)
+	}
 	return g.cache.Set(kv.Key, kv.Value)
 }
+
+// ExpireKeys sets the keys again with a new expiration, gcache can't change the expiration of a key in place
+func (g *Gcache) ExpireKeys(ctx context.Context, keys []string, ttl int64) error {
+	if ttl <= 0 {
+		ttl = g.ttl
+	}
+	if ttl <= 0 {
+		return nil
+	}
+	g.Lock()
+	defer g.Unlock()
+	for _, key := range keys {
+		value, err := g.cache.Get(key)
+		if err == gcache.KeyNotFoundError {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err = g.cache.SetWithExpire(key, value, time.Duration(util.JitterInt64(ttl, g.jitter))*time.Millisecond); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *Gcache) CountKeysWithPrefix(ctx context.Context, keyPrefix string) (int64, error) {
+	g.RLock()
+	defer g.RUnlock()
+	var count int64
+	for _, k := range g.cache.Keys(true) {
+		if key, ok := k.(string); ok && strings.HasPrefix(key, keyPrefix) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (g *Gcache) ListKeysWithPrefix(ctx context.Context, keyPrefix string, limit int) ([]string, error) {
+	g.RLock()
+	defer g.RUnlock()
+	keys := make([]string, 0)
+	for _, k := range g.cache.Keys(true) {
+		if len(keys) >= limit {
+			break
+		}
+		if key, ok := k.(string); ok && strings.HasPrefix(key, keyPrefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
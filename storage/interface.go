@@ -11,7 +11,10 @@ var (
 )
 
 type Config struct {
-	TTL    int64
+	TTL int64
+	// Jitter fraction of the ttl each key's expiry is randomized by either way at write time,
+	// 0 keeps the storage's own randomization
+	Jitter float64
 	Debug  bool
 	Logger util.LoggerInterface
 }
@@ -19,17 +22,144 @@ type Config struct {
 type DataStorage interface {
 	Init(config *Config) error
 	CleanCache(ctx context.Context) error
+	// Ping reports whether the storage is reachable, within the deadline of ctx. Storages living in the
+	// process return nil, used by Gorm2Cache.Ping e.g. for readiness probes
+	Ping(ctx context.Context) error
 
 	// read
 	BatchKeyExist(ctx context.Context, keys []string) (bool, error)
 	KeyExists(ctx context.Context, key string) (bool, error)
 	GetValue(ctx context.Context, key string) (string, error)
+	// BatchGetValues returns exactly one value per requested key, in the order of keys.
+	// Keys that are not cached are reported as empty strings at their position.
 	BatchGetValues(ctx context.Context, keys []string) ([]string, error)
 
 	// write
+	// DeleteKeysWithPrefix deletes the keys starting with keyPrefix followed by ":", keyPrefix may end with
+	// that separator already
 	DeleteKeysWithPrefix(ctx context.Context, keyPrefix string) error
 	DeleteKey(ctx context.Context, key string) error
 	BatchDeleteKeys(ctx context.Context, keys []string) error
 	BatchSetKeys(ctx context.Context, kvs []util.Kv) error
 	SetKey(ctx context.Context, kv util.Kv) error
 }
+
+// Snapshotter is implemented by storages that can describe their settings,
+// it is used by Gorm2Cache.ConfigSnapshot. Credentials must never be included.
+type Snapshotter interface {
+	Snapshot() map[string]interface{}
+}
+
+// KeyCounter is implemented by storages that can count their keys, it is used for diagnostics
+// by Gorm2Cache.DebugBundle, CountPrimaryCache and CountSearchCache.
+// Stores holding many keys should count them in batches rather than listing them all at once.
+type KeyCounter interface {
+	CountKeysWithPrefix(ctx context.Context, keyPrefix string) (int64, error)
+}
+
+// KeyLister is implemented by storages that can list their keys, it is used by Gorm2Cache.AdminHandler to
+// browse the cache. Listing scans the keys, it is meant for debugging rather than serving queries.
+type KeyLister interface {
+	// ListKeysWithPrefix returns up to limit keys starting with keyPrefix, in no particular order
+	ListKeysWithPrefix(ctx context.Context, keyPrefix string, limit int) ([]string, error)
+}
+
+// TTLReader is implemented by storages that can tell when their keys expire, it is used by
+// Gorm2Cache.AdminHandler to inspect a key.
+type TTLReader interface {
+	// KeyTTL returns the ms left before key expires, 0 if it never expires, ErrCacheNotFound if it isn't cached
+	KeyTTL(ctx context.Context, key string) (int64, error)
+}
+
+// EvictionCounter is implemented by bounded storages, it is reported by Gorm2Cache.EvictionCount
+type EvictionCounter interface {
+	// EvictionCount returns how many entries were evicted to make room for new ones
+	EvictionCount() uint64
+}
+
+// Expirer is implemented by storages that can refresh the expiry of keys without rewriting their values,
+// it is used by Config.RefreshTTLOnHit.
+type Expirer interface {
+	// ExpireKeys makes the cached keys expire ttl ms from now, 0 uses the ttl passed to Init.
+	// Keys that are not cached are skipped.
+	ExpireKeys(ctx context.Context, keys []string, ttl int64) error
+}
+
+// Versioner is implemented by storages that can write keys unless a version kept alongside them is newer,
+// checking and writing atomically, it is used by config.CacheConfig.PrimaryCacheVersioning.
+// Versions are integers kept as strings, a missing version counts as 0
+type Versioner interface {
+	// BumpVersion increments the version of sequenceKey, which doesn't expire, and sets the versionKeys to the
+	// new version for ttl ms (0 uses the ttl of the storage, like util.Kv), returning it
+	BumpVersion(ctx context.Context, sequenceKey string, versionKeys []string, ttl int64) (int64, error)
+	// SetKeysIfVersion writes each kv of kvs unless one of its versionKeys, versionKeys[i] for kvs[i], holds a
+	// version above version, reporting which kvs were written
+	SetKeysIfVersion(ctx context.Context, kvs []util.Kv, versionKeys [][]string, version int64) ([]bool, error)
+}
+
+// Closer is implemented by storages to run when the cache is done with them, it is called by
+// Gorm2Cache.Close on the storages of the config.
+type Closer interface {
+	Close() error
+}
+
+// Locker is implemented by storages that can hold locks shared by the instances using them, it is used
+// by Config.DistributedSingleFlight.
+type Locker interface {
+	// TryLock takes the lock of key for ttl ms on behalf of token unless another token holds it,
+	// reporting whether it was taken
+	TryLock(ctx context.Context, key string, token string, ttl int64) (bool, error)
+	// Unlock releases the lock of key if token still holds it
+	Unlock(ctx context.Context, key string, token string) error
+}
+
+// SetStore is implemented by storages holding sets of strings, it keeps the tag indexes of the search cache
+// so that the instances sharing the storage add to them atomically. Sets are deleted like other keys.
+type SetStore interface {
+	// AddToSet adds the members to the set of key, which then lives at least ttl ms from now, forever if ttl
+	// is not above 0
+	AddToSet(ctx context.Context, key string, members []string, ttl int64) error
+	// SetMembers returns the members of the set of key, none if it doesn't exist
+	SetMembers(ctx context.Context, key string) ([]string, error)
+}
+
+// InvalidationKind what an Invalidation drops
+type InvalidationKind int
+
+const (
+	// InvalidateSearch drops the search cache of the table
+	InvalidateSearch InvalidationKind = 0
+	// InvalidatePrimaryKeys drops the primary cache of the primary keys of the table
+	InvalidatePrimaryKeys InvalidationKind = 1
+	// InvalidateAllPrimary drops the whole primary cache of the table
+	InvalidateAllPrimary InvalidationKind = 2
+	// InvalidateSearchDependents drops the search cache entries of the table containing the rows of the
+	// primary keys, see config.CacheConfig.PreciseSearchInvalidation
+	InvalidateSearchDependents InvalidationKind = 3
+	// InvalidateTenant drops all the entries of the tenant
+	InvalidateTenant InvalidationKind = 4
+	// InvalidateSearchQuery drops the search cache entry of a single query of the table
+	InvalidateSearchQuery InvalidationKind = 5
+)
+
+// Invalidation an invalidation run by an instance, broadcast for the others to run it on their own keys
+type Invalidation struct {
+	Source      string           `json:"source"` // random token of the instance broadcasting it, not its InstanceId
+	Kind        InvalidationKind `json:"kind"`
+	Table       string           `json:"table"`
+	PrimaryKeys []string         `json:"primaryKeys,omitempty"`
+	Tenant      string           `json:"tenant,omitempty"` // tenant of the invalidated entries, see cache.WithTenant
+	Query       string           `json:"query,omitempty"`  // query part of the search cache key of InvalidateSearchQuery
+}
+
+// Broadcaster carries invalidations between the instances caching the same database,
+// it is used by Config.Broadcaster.
+type Broadcaster interface {
+	Publish(ctx context.Context, invalidation Invalidation) error
+	// Subscribe calls handle with every invalidation published from then on, including the subscriber's
+	// own ones, until the returned function is called
+	Subscribe(ctx context.Context, handle func(invalidation Invalidation)) (unsubscribe func() error, err error)
+}
+
+// RedactedValue replaces sensitive settings in snapshots
+const RedactedValue = "******"
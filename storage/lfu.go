@@ -0,0 +1,285 @@
+package storage
+
+import (
+	"container/heap"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/karlseguin/ccache/v3"
+)
+
+// memItem an entry of a shard of Memory, *ccache.Item satisfies it
+type memItem interface {
+	Key() string
+	Value() *memEntry
+	Expired() bool
+	Expires() time.Time
+	TTL() time.Duration
+	Extend(duration time.Duration)
+}
+
+// memShard the entries of a shard of Memory, kept by ccache under EvictionLRU and by an lfuShard under
+// EvictionLFU. Get marks the entry as used, the other reads don't
+type memShard interface {
+	Get(key string) memItem
+	GetWithoutPromote(key string) memItem
+	Set(key string, value *memEntry, ttl time.Duration)
+	Delete(key string) bool
+	DeletePrefix(prefix string) int
+	DeleteFunc(matches func(key string, item memItem) bool) int
+	ForEachFunc(matches func(key string, item memItem) bool)
+	Clear()
+	Stop()
+	GetDropped() int
+}
+
+// lruShard adapts ccache to memShard, nil items are returned as nil interfaces
+type lruShard struct {
+	*ccache.Cache[*memEntry]
+}
+
+func (s lruShard) Get(key string) memItem {
+	if item := s.Cache.Get(key); item != nil {
+		return item
+	}
+	return nil
+}
+
+func (s lruShard) GetWithoutPromote(key string) memItem {
+	if item := s.Cache.GetWithoutPromote(key); item != nil {
+		return item
+	}
+	return nil
+}
+
+func (s lruShard) Set(key string, value *memEntry, ttl time.Duration) {
+	s.Cache.Set(key, value, ttl)
+}
+
+func (s lruShard) DeleteFunc(matches func(key string, item memItem) bool) int {
+	return s.Cache.DeleteFunc(func(key string, item *ccache.Item[*memEntry]) bool {
+		return matches(key, item)
+	})
+}
+
+func (s lruShard) ForEachFunc(matches func(key string, item memItem) bool) {
+	s.Cache.ForEachFunc(func(key string, item *ccache.Item[*memEntry]) bool {
+		return matches(key, item)
+	})
+}
+
+type lfuItem struct {
+	key     string
+	value   *memEntry
+	expires int64  // unix ns, atomic as Extend runs without the lock of the shard
+	hits    uint64 // reads and writes of the entry
+	used    uint64 // tick of the shard at the last of them
+	index   int    // in the heap of the shard
+}
+
+func (i *lfuItem) Key() string {
+	return i.key
+}
+
+func (i *lfuItem) Value() *memEntry {
+	return i.value
+}
+
+func (i *lfuItem) Expired() bool {
+	return atomic.LoadInt64(&i.expires) < time.Now().UnixNano()
+}
+
+func (i *lfuItem) Expires() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&i.expires))
+}
+
+func (i *lfuItem) TTL() time.Duration {
+	return time.Duration(atomic.LoadInt64(&i.expires) - time.Now().UnixNano())
+}
+
+func (i *lfuItem) Extend(duration time.Duration) {
+	atomic.StoreInt64(&i.expires, time.Now().Add(duration).UnixNano())
+}
+
+// lfuHeap the items of a shard, the least frequently used first, the least recently used of them on a tie
+type lfuHeap []*lfuItem
+
+func (h lfuHeap) Len() int {
+	return len(h)
+}
+
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].hits != h[j].hits {
+		return h[i].hits < h[j].hits
+	}
+	return h[i].used < h[j].used
+}
+
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *lfuHeap) Push(x interface{}) {
+	item := x.(*lfuItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return item
+}
+
+// lfuShard a shard of EvictionLFU. Past maxSize it evicts its least frequently used entries, sparing the one
+// just written so that a new entry gets the chance to be read before it competes with the others
+type lfuShard struct {
+	mu       sync.Mutex
+	maxSize  int64 // in the sizes of the entries, as ccache
+	size     int64
+	items    map[string]*lfuItem
+	heap     lfuHeap
+	tick     uint64
+	dropped  int
+	onDelete func(item memItem) // called with the entries evicted, may be nil
+}
+
+func newLFUShard(maxSize int64, onDelete func(item memItem)) *lfuShard {
+	return &lfuShard{maxSize: maxSize, items: make(map[string]*lfuItem), onDelete: onDelete}
+}
+
+// use counts a read or write of item, the lock must be held
+func (s *lfuShard) use(item *lfuItem) {
+	s.tick++
+	item.hits++
+	item.used = s.tick
+	heap.Fix(&s.heap, item.index)
+}
+
+func (s *lfuShard) Get(key string) memItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.items[key]
+	if !ok {
+		return nil
+	}
+	s.use(item)
+	return item
+}
+
+func (s *lfuShard) GetWithoutPromote(key string) memItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if item, ok := s.items[key]; ok {
+		return item
+	}
+	return nil
+}
+
+func (s *lfuShard) Set(key string, value *memEntry, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.items[key]
+	if ok {
+		s.size -= item.value.size
+		item.value = value
+		atomic.StoreInt64(&item.expires, time.Now().Add(ttl).UnixNano())
+	} else {
+		item = &lfuItem{key: key, value: value, expires: time.Now().Add(ttl).UnixNano()}
+		s.items[key] = item
+		heap.Push(&s.heap, item)
+	}
+	s.size += value.size
+	s.use(item)
+	s.prune(item)
+}
+
+// prune evicts entries until the shard fits in maxSize, the entry kept is evicted last
+func (s *lfuShard) prune(kept *lfuItem) {
+	for s.size > s.maxSize && len(s.heap) > 0 {
+		victim := s.heap[0]
+		if victim == kept && len(s.heap) > 1 {
+			// the least used of the children of the root is the next least used entry
+			victim = s.heap[1]
+			if len(s.heap) > 2 && s.heap.Less(2, 1) {
+				victim = s.heap[2]
+			}
+		}
+		s.remove(victim)
+		s.dropped++
+		if s.onDelete != nil {
+			s.onDelete(victim)
+		}
+	}
+}
+
+// remove drops item from the shard, the lock must be held
+func (s *lfuShard) remove(item *lfuItem) {
+	heap.Remove(&s.heap, item.index)
+	delete(s.items, item.key)
+	s.size -= item.value.size
+}
+
+func (s *lfuShard) Delete(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.items[key]
+	if ok {
+		s.remove(item)
+	}
+	return ok
+}
+
+func (s *lfuShard) DeletePrefix(prefix string) int {
+	return s.DeleteFunc(func(key string, _ memItem) bool {
+		return strings.HasPrefix(key, prefix)
+	})
+}
+
+func (s *lfuShard) DeleteFunc(matches func(key string, item memItem) bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	for key, item := range s.items {
+		if matches(key, item) {
+			s.remove(item)
+			count++
+		}
+	}
+	return count
+}
+
+func (s *lfuShard) ForEachFunc(matches func(key string, item memItem) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, item := range s.items {
+		if !matches(key, item) {
+			return
+		}
+	}
+}
+
+func (s *lfuShard) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items = make(map[string]*lfuItem)
+	s.heap = nil
+	s.size = 0
+}
+
+// Stop does nothing, the shard has no worker
+func (s *lfuShard) Stop() {}
+
+// GetDropped returns the entries evicted since the last call, as ccache
+func (s *lfuShard) GetDropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dropped := s.dropped
+	s.dropped = 0
+	return dropped
+}
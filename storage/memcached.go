@@ -0,0 +1,360 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joykk/gorm-cache/util"
+)
+
+var (
+	_ DataStorage = &Memcached{}
+	_ Snapshotter = &Memcached{}
+)
+
+const (
+	// defaultMemcachedPrefixSegments is the number of ":" separated segments of the prefixes gorm-cache
+	// deletes keys by, e.g. "gormcache:<instance id>:s:<table>"
+	defaultMemcachedPrefixSegments = 4
+	// memcachedMaxRelativeExpiration is the longest expiration memcached takes as seconds from now, longer
+	// ones are taken as unix timestamps
+	memcachedMaxRelativeExpiration = 30 * 24 * 60 * 60
+)
+
+// MemcachedClient is the subset of a memcached client the Memcached store is built on, e.g. a thin adapter
+// of github.com/bradfitz/gomemcache. Expirations are in seconds as memcached takes them, 0 never expires.
+type MemcachedClient interface {
+	// GetMulti returns the values of the keys found, keys missing are left out
+	GetMulti(keys []string) (map[string][]byte, error)
+	Set(key string, value []byte, expiration int32) error
+	// Add stores the value only if the key is missing, reporting whether it did
+	Add(key string, value []byte, expiration int32) (bool, error)
+	// Increment increments the number stored at the key, reporting whether the key was found
+	Increment(key string, delta uint64) (uint64, bool, error)
+	// Delete deletes the key, a missing key is not an error
+	Delete(key string) error
+	Ping() error
+}
+
+type MemcachedStoreConfig struct {
+	KeyPrefix string // every key is stored under this prefix, it will be random if not set
+
+	Client MemcachedClient
+
+	// PrefixSegments number of ":" separated segments of a key making up the prefix it is deleted by,
+	// 0 represents 4, the layout of the keys of gorm-cache
+	PrefixSegments int
+}
+
+// NewMemcached creates a storage on memcached. Memcached can't list its keys, so prefix deletes are emulated
+// with generations: every key is stored under the generation of its prefix, which DeleteKeysWithPrefix bumps
+// so that the keys stored under the previous one are never read again and age out by their ttl or the LRU of
+// memcached. The keys of a tenant are stored under the generation of their table too, two segments further.
+// Prefixes other than these, and CleanCache, bump the generation of the whole store instead. Keys are hashed,
+// as memcached keys can't hold spaces or exceed 250 bytes, and reading or writing any key costs an extra round
+// trip for the generations.
+func NewMemcached(config ...*MemcachedStoreConfig) *Memcached {
+	if len(config) == 0 {
+		panic("memcached config is required")
+	}
+	if config[0].Client == nil {
+		panic("memcached client is required")
+	}
+	if config[0].KeyPrefix == "" {
+		config[0].KeyPrefix = util.GormCachePrefix + ":" + util.GenInstanceId()
+	}
+	if config[0].PrefixSegments <= 0 {
+		config[0].PrefixSegments = defaultMemcachedPrefixSegments
+	}
+	return &Memcached{config: config[0]}
+}
+
+type Memcached struct {
+	config *MemcachedStoreConfig
+	ttl    int64
+	jitter float64
+	logger util.LoggerInterface
+
+	once sync.Once
+}
+
+func (m *Memcached) Init(conf *Config) error {
+	m.once.Do(func() {
+		m.ttl = conf.TTL
+		m.jitter = conf.Jitter
+		m.logger = conf.Logger
+		m.logger.SetIsDebug(conf.Debug)
+	})
+	return nil
+}
+
+// storeGenerationKey is the memcached key of the generation of the whole store
+func (m *Memcached) storeGenerationKey() string {
+	return m.config.KeyPrefix + ":g"
+}
+
+// generationKey is the memcached key of the generation of a key prefix
+func (m *Memcached) generationKey(keyPrefix string) string {
+	return m.config.KeyPrefix + ":g:" + hashMemcachedKey(keyPrefix)
+}
+
+// prefixesOf returns the prefixes key is deleted by, see keyPrefixes
+func (m *Memcached) prefixesOf(key string) []string {
+	return keyPrefixes(key, m.config.PrefixSegments)
+}
+
+// keyPrefixes returns the prefixes a key is deleted by with generations: its first segments, and for the keys
+// of a tenant (see util.TenantInstanceId), whose first segments are the prefix of the tenant, the prefix of its
+// table too, two segments longer, so that invalidating a table of the tenant doesn't drop the whole store
+func keyPrefixes(key string, segments int) []string {
+	prefix := keySegments(key, segments)
+	if segments > 1 && strings.HasSuffix(keySegments(key, segments-1), ":"+util.TenantSegment) {
+		return []string{prefix, keySegments(key, segments+2)}
+	}
+	return []string{prefix}
+}
+
+// separated returns keyPrefix followed by the ":" separating it from the rest of the keys, which it may end
+// with already
+func separated(keyPrefix string) string {
+	return strings.TrimSuffix(keyPrefix, ":") + ":"
+}
+
+// keySegments returns the first segments ":" separated segments of key, the whole key if it has fewer
+func keySegments(key string, segments int) string {
+	idx := 0
+	for i := 0; i < segments; i++ {
+		next := strings.Index(key[idx:], ":")
+		if next < 0 {
+			return key
+		}
+		idx += next + 1
+	}
+	return key[:idx-1]
+}
+
+func hashMemcachedKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// generations returns the current value of each generation key. Generations missing, never bumped or
+// evicted, are started at the current time, so that a generation evicted and started again never returns
+// to a value keys were stored under
+func (m *Memcached) generations(genKeys []string) (map[string]string, error) {
+	values, err := m.config.Client.GetMulti(genKeys)
+	if err != nil {
+		return nil, err
+	}
+	gens := make(map[string]string, len(genKeys))
+	missing := make([]string, 0)
+	for _, genKey := range genKeys {
+		if value, ok := values[genKey]; ok {
+			gens[genKey] = string(value)
+		} else if _, seen := gens[genKey]; !seen {
+			gens[genKey] = ""
+			missing = append(missing, genKey)
+		}
+	}
+	if len(missing) == 0 {
+		return gens, nil
+	}
+	for _, genKey := range missing {
+		if _, err = m.config.Client.Add(genKey, newMemcachedGeneration(), 0); err != nil {
+			return nil, err
+		}
+	}
+	// another instance may have started the generation first, its value is the one kept
+	values, err = m.config.Client.GetMulti(missing)
+	if err != nil {
+		return nil, err
+	}
+	for _, genKey := range missing {
+		gens[genKey] = string(values[genKey])
+	}
+	return gens, nil
+}
+
+func newMemcachedGeneration() []byte {
+	return []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+}
+
+// keys returns the memcached keys the keys are stored under in the current generations
+func (m *Memcached) keys(keys []string) ([]string, error) {
+	genKeys := make([]string, 0, len(keys)+1)
+	genKeys = append(genKeys, m.storeGenerationKey())
+	for _, key := range keys {
+		for _, prefix := range m.prefixesOf(key) {
+			genKeys = append(genKeys, m.generationKey(prefix))
+		}
+	}
+	gens, err := m.generations(genKeys)
+	if err != nil {
+		return nil, err
+	}
+	storeGen := gens[m.storeGenerationKey()]
+	mcKeys := make([]string, len(keys))
+	for idx, key := range keys {
+		versioned := storeGen
+		for _, prefix := range m.prefixesOf(key) {
+			versioned += ":" + gens[m.generationKey(prefix)]
+		}
+		mcKeys[idx] = m.config.KeyPrefix + ":" + hashMemcachedKey(versioned+":"+key)
+	}
+	return mcKeys, nil
+}
+
+// bump moves the generation to a new value, dropping every key stored under the previous one
+func (m *Memcached) bump(genKey string) error {
+	_, found, err := m.config.Client.Increment(genKey, 1)
+	if err != nil || found {
+		return err
+	}
+	// a missing generation is started anew, at a value no key was stored under
+	_, err = m.config.Client.Add(genKey, newMemcachedGeneration(), 0)
+	return err
+}
+
+// CleanCache bumps the generation of the store, memcached isn't flushed so the keys of others are kept
+func (m *Memcached) CleanCache(ctx context.Context) error {
+	if err := m.bump(m.storeGenerationKey()); err != nil {
+		m.logger.CtxError(ctx, "[CleanCache] clean cache error: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (m *Memcached) Ping(_ context.Context) error {
+	return m.config.Client.Ping()
+}
+
+func (m *Memcached) BatchKeyExist(ctx context.Context, keys []string) (bool, error) {
+	values, err := m.BatchGetValues(ctx, keys)
+	if err != nil {
+		return false, err
+	}
+	for _, value := range values {
+		if value == "" {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (m *Memcached) KeyExists(ctx context.Context, key string) (bool, error) {
+	return m.BatchKeyExist(ctx, []string{key})
+}
+
+func (m *Memcached) GetValue(ctx context.Context, key string) (string, error) {
+	values, err := m.BatchGetValues(ctx, []string{key})
+	if err != nil {
+		return "", err
+	}
+	if values[0] == "" {
+		return "", ErrCacheNotFound
+	}
+	return values[0], nil
+}
+
+func (m *Memcached) BatchGetValues(ctx context.Context, keys []string) ([]string, error) {
+	mcKeys, err := m.keys(keys)
+	if err != nil {
+		m.logger.CtxError(ctx, "[BatchGetValues] get generations error: %v", err)
+		return nil, err
+	}
+	values, err := m.config.Client.GetMulti(mcKeys)
+	if err != nil {
+		m.logger.CtxError(ctx, "[BatchGetValues] get error: %v", err)
+		return nil, err
+	}
+	strs := make([]string, len(keys))
+	for idx, mcKey := range mcKeys {
+		strs[idx] = string(values[mcKey])
+	}
+	return strs, nil
+}
+
+// DeleteKeysWithPrefix bumps the generation of the prefix, or of the whole store for prefixes keys aren't
+// stored by
+func (m *Memcached) DeleteKeysWithPrefix(ctx context.Context, keyPrefix string) error {
+	genKey := m.storeGenerationKey()
+	keyPrefix = strings.TrimSuffix(keyPrefix, ":")
+	if keyPrefix != "" && util.ContainString(keyPrefix, m.prefixesOf(keyPrefix+":")) {
+		genKey = m.generationKey(keyPrefix)
+	}
+	if err := m.bump(genKey); err != nil {
+		m.logger.CtxError(ctx, "[DeleteKeysWithPrefix] bump generation error: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (m *Memcached) DeleteKey(ctx context.Context, key string) error {
+	return m.BatchDeleteKeys(ctx, []string{key})
+}
+
+func (m *Memcached) BatchDeleteKeys(ctx context.Context, keys []string) error {
+	mcKeys, err := m.keys(keys)
+	if err != nil {
+		m.logger.CtxError(ctx, "[BatchDeleteKeys] get generations error: %v", err)
+		return err
+	}
+	for _, mcKey := range mcKeys {
+		if err = m.config.Client.Delete(mcKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Memcached) BatchSetKeys(ctx context.Context, kvs []util.Kv) error {
+	keys := make([]string, 0, len(kvs))
+	for _, kv := range kvs {
+		keys = append(keys, kv.Key)
+	}
+	mcKeys, err := m.keys(keys)
+	if err != nil {
+		m.logger.CtxError(ctx, "[BatchSetKeys] get generations error: %v", err)
+		return err
+	}
+	for idx, kv := range kvs {
+		if err = m.config.Client.Set(mcKeys[idx], []byte(kv.Value), m.expiration(kv)); err != nil {
+			m.logger.CtxError(ctx, "[BatchSetKeys] set keys error: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Memcached) SetKey(ctx context.Context, kv util.Kv) error {
+	return m.BatchSetKeys(ctx, []util.Kv{kv})
+}
+
+// expiration returns the jittered ttl of kv as memcached takes it: seconds, rounded up, or the unix time
+// it expires at past 30 days, 0 if it doesn't expire
+func (m *Memcached) expiration(kv util.Kv) int32 {
+	ttl := m.ttl
+	if kv.TTL > 0 {
+		ttl = kv.TTL
+	}
+	if ttl <= 0 {
+		return 0
+	}
+	seconds := (util.JitterInt64(ttl, m.jitter) + 999) / 1000
+	if seconds > memcachedMaxRelativeExpiration {
+		return int32(time.Now().Unix() + seconds)
+	}
+	return int32(seconds)
+}
+
+func (m *Memcached) Snapshot() map[string]interface{} {
+	return map[string]interface{}{
+		"keyPrefix":      m.config.KeyPrefix,
+		"prefixSegments": m.config.PrefixSegments,
+	}
+}
@@ -1,59 +1,586 @@
 package storage
 
 import (
+	"bufio"
 	"context"
-	"fmt"
-	"github.com/karlseguin/ccache/v3"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/dgraph-io/ristretto"
+	"github.com/karlseguin/ccache/v3"
+
 	"github.com/joykk/gorm-cache/util"
 )
 
-var _ DataStorage = &Memory{}
+var (
+	_ DataStorage     = &Memory{}
+	_ Snapshotter     = &Memory{}
+	_ KeyCounter      = &Memory{}
+	_ KeyLister       = &Memory{}
+	_ TTLReader       = &Memory{}
+	_ Expirer         = &Memory{}
+	_ EvictionCounter = &Memory{}
+	_ Closer          = &Memory{}
+	_ Versioner       = &Memory{}
+)
 
 type MemStoreConfig struct {
-	MaxSize int64 // maximal items in primary cache
+	MaxSize int64 // maximal items in primary cache, entries are evicted by EvictionPolicy beyond it
+
+	// MaxMemoryBytes bounds the bytes taken by the keys and values held, with an estimated overhead per
+	// entry; entries are evicted by EvictionPolicy beyond it. 0 leaves the store bounded by MaxSize
+	// only. NewMem accounts entries in KiB, so a large value written to a full store may evict a few more
+	// entries than needed
+	MaxMemoryBytes int64
+
+	// EvictionPolicy picks the entries evicted past MaxSize or MaxMemoryBytes, the least recently used by default
+	EvictionPolicy EvictionPolicy
+
+	// Engine holds the entries, ccache by default. MemEngineRistretto keeps them in a ristretto cache of
+	// MaxMemoryBytes, see MemEngine
+	Engine MemEngine
+
+	// Evictions receives an event for every entry removed from the store.
+	// Sends never block the eviction path, events are dropped when the channel is full.
+	Evictions chan<- EvictionEvent
+
+	// ShardCount number of shards the keys are spread over by hash, each with a lock, an eviction order and,
+	// under EvictionLRU, a background worker of its own, to cut contention under many concurrent goroutines.
+	// MaxSize and MaxMemoryBytes are split evenly between the shards, so the entries evicted are picked among
+	// those of their shard rather than of the store. 0 represents 1, ignored by NewMemSync
+	ShardCount int
+
+	// CleanupInterval in ms each shard removes its expired entries at, reporting them to Evictions. 0 leaves
+	// them until they are read, or evicted to make room. Ignored by NewMemSync
+	CleanupInterval int64
+
+	// SnapshotFile the entries are saved to by Close and loaded back from by Init, so that a restarted process
+	// doesn't start with a cold cache. A missing file loads nothing, one failing to load is logged.
+	// Empty saves nothing. Ignored by NewMemSync
+	SnapshotFile string
 }
 
 var DefaultMemStoreConfig = &MemStoreConfig{
 	MaxSize: 1000,
 }
 
+// EvictionPolicy the entries a memory store evicts to make room for new ones
+type EvictionPolicy int
+
+const (
+	EvictionLRU EvictionPolicy = 0 // the least recently used entries
+	// EvictionLFU the least frequently used entries, the least recently used of them on a tie. Entries read
+	// often once outlive the ones read often lately until they expire, so it suits hot sets that hardly change
+	EvictionLFU EvictionPolicy = 1
+)
+
+func (p EvictionPolicy) String() string {
+	switch p {
+	case EvictionLRU:
+		return "lru"
+	case EvictionLFU:
+		return "lfu"
+	}
+	return "unknown"
+}
+
+// MemEngine the cache a memory store holds its entries in
+type MemEngine int
+
+const (
+	MemEngineCcache MemEngine = 0 // ccache, or the LFU shards of the store under EvictionLFU
+	// MemEngineRistretto a cache of github.com/dgraph-io/ristretto bounded by MaxMemoryBytes, as by
+	// NewRistretto: its admission policy keeps the most frequently used entries and it holds up better under
+	// many concurrent goroutines. It can't list its keys, so the store can't count, list or save them, nor
+	// report its evictions. EvictionPolicy, Evictions, ShardCount, CleanupInterval and SnapshotFile are ignored
+	MemEngineRistretto MemEngine = 1
+)
+
+func (e MemEngine) String() string {
+	switch e {
+	case MemEngineCcache:
+		return "ccache"
+	case MemEngineRistretto:
+		return "ristretto"
+	}
+	return "unknown"
+}
+
+// errRistrettoEngine is returned by the operations MemEngineRistretto can't run
+var errRistrettoEngine = errors.New("the ristretto engine of the memory store can't list its keys")
+
+type EvictionReason int
+
+const (
+	EvictionReasonExpired  EvictionReason = 1 // entry expired
+	EvictionReasonCapacity EvictionReason = 2 // entry dropped to make room for new ones
+	EvictionReasonExplicit EvictionReason = 3 // entry deleted or invalidated
+
+	evictionReasonHandled EvictionReason = -1 // entry overwritten or its removal already reported by the store
+)
+
+func (r EvictionReason) String() string {
+	switch r {
+	case EvictionReasonExpired:
+		return "expired"
+	case EvictionReasonCapacity:
+		return "capacity"
+	case EvictionReasonExplicit:
+		return "explicit"
+	}
+	return "unknown"
+}
+
+type EvictionEvent struct {
+	Key    string
+	Reason EvictionReason
+}
+
+const (
+	// memEntryOverhead estimates the bytes an entry takes in a memory store besides its key and value
+	memEntryOverhead = 128
+	// memSizeUnit is the unit ccache accounts entries in under MaxMemoryBytes: ccache prunes as many entries
+	// as the units it is over its size, so bytes would have it prune far too many
+	memSizeUnit = 1 << 10
+)
+
+// memSequenceTTL the time the sequences of BumpVersion are kept for, as good as forever
+const memSequenceTTL = 100 * 365 * 24 * time.Hour
+
+// entryBytes returns the bytes an entry is accounted for by MaxMemoryBytes
+func entryBytes(key string, value string) int64 {
+	return int64(len(key)+len(value)) + memEntryOverhead
+}
+
+// memEntry is the value kept in ccache, removal is set right before
+// the store itself removes or replaces the entry so that OnDelete only reports evictions made by ccache
+type memEntry struct {
+	value   string
+	removal int32
+	size    int64 // the size ccache accounts the entry for
+}
+
+// Size implements ccache.Sized
+func (e *memEntry) Size() int64 {
+	return e.size
+}
+
+// NewMem creates an in-process store. Reads and writes fail with the context error once their context is
+// done, deletes always run, dropping them would leave stale entries
 func NewMem(config ...*MemStoreConfig) *Memory {
 	if len(config) == 0 {
 		config = append(config, DefaultMemStoreConfig)
 	}
-	return &Memory{config: config[0]}
+	m := &Memory{config: config[0]}
+	if m.config.Engine == MemEngineRistretto {
+		m.ristretto = newRistrettoEngine(m.config)
+	}
+	return m
+}
+
+// newRistrettoEngine returns the store of MemEngineRistretto, sizing its admission counters to ten times the
+// entries it may hold: MaxSize, or an entry a KiB
+func newRistrettoEngine(config *MemStoreConfig) *Ristretto {
+	if config.MaxMemoryBytes <= 0 {
+		panic("MaxMemoryBytes is required by the ristretto engine")
+	}
+	entries := config.MaxSize
+	if entries <= 0 {
+		entries = ceilDiv(config.MaxMemoryBytes, memSizeUnit)
+	}
+	cache, err := ristretto.NewCache(&ristretto.Config{
+		NumCounters:        10 * entries,
+		MaxCost:            config.MaxMemoryBytes,
+		BufferItems:        64,
+		IgnoreInternalCost: true, // entries cost memEntryOverhead already
+	})
+	if err != nil {
+		panic(err)
+	}
+	return NewRistretto(&RistrettoStoreConfig{Cache: cache})
 }
 
 type Memory struct {
-	config *MemStoreConfig
+	config    *MemStoreConfig
+	ristretto *Ristretto // the store of MemEngineRistretto, the operations are passed to
 
-	cache *ccache.Cache[string]
-	ttl   int64
+	shards       []memShard // see MemStoreConfig.ShardCount
+	ttl          int64
+	jitter       float64
+	minEntrySize int64 // in memSizeUnit, so that MaxSize entries at most fit in MaxMemoryBytes
+
+	evictionMu sync.Mutex
+	evictions  uint64 // capacity evictions collected from ccache so far
+
+	versionMu sync.Mutex // serializes BumpVersion and SetKeysIfVersion
+
+	stop     chan struct{} // closed by Close to end the cleanup of the shards
+	stopOnce sync.Once
 
 	once sync.Once
 }
 
 func (m *Memory) Init(conf *Config) error {
+	if m.ristretto != nil {
+		return m.ristretto.Init(conf)
+	}
 	m.once.Do(func() {
-		c := ccache.New(ccache.Configure[string]().MaxSize(m.config.MaxSize))
-		m.cache = c
+		shardCount := m.config.ShardCount
+		if shardCount <= 0 {
+			shardCount = 1
+		}
+		// every hit marks its entry as used, and only the entries over MaxSize are evicted
+		maxSize := ceilDiv(m.config.MaxSize, int64(shardCount))
+		if m.config.MaxMemoryBytes > 0 {
+			// both bounds are kept by one size: each entry counts for at least 1/MaxSize of MaxMemoryBytes
+			maxSize = ceilDiv(ceilDiv(m.config.MaxMemoryBytes, memSizeUnit), int64(shardCount))
+			if m.config.MaxSize > 0 {
+				m.minEntrySize = (m.config.MaxMemoryBytes + memSizeUnit - 1) / memSizeUnit / m.config.MaxSize
+			}
+		}
+		var onDelete func(item memItem)
+		if m.config.Evictions != nil {
+			onDelete = m.notifyEviction
+		}
+		newShard := func() memShard {
+			return newLFUShard(maxSize, onDelete)
+		}
+		if m.config.EvictionPolicy != EvictionLFU {
+			cacheConf := ccache.Configure[*memEntry]().MaxSize(maxSize).GetsPerPromote(1).ItemsToPrune(1)
+			if onDelete != nil {
+				cacheConf = cacheConf.OnDelete(func(item *ccache.Item[*memEntry]) { onDelete(item) })
+			}
+			newShard = func() memShard {
+				return lruShard{Cache: ccache.New(cacheConf)}
+			}
+		}
+		m.shards = make([]memShard, 0, shardCount)
+		m.stop = make(chan struct{})
+		for i := 0; i < shardCount; i++ {
+			shard := newShard()
+			m.shards = append(m.shards, shard)
+			if m.config.CleanupInterval > 0 {
+				go m.cleanup(shard, time.Duration(m.config.CleanupInterval)*time.Millisecond)
+			}
+		}
 		m.ttl = conf.TTL
+		m.jitter = conf.Jitter
+		if m.config.SnapshotFile != "" {
+			m.loadFile(conf.Logger)
+		}
 	})
 	return nil
 }
 
+// memSnapshotEntry an entry in the snapshot of a memory store, one json object per line
+type memSnapshotEntry struct {
+	Key      string `json:"k"`
+	Value    string `json:"v"`
+	ExpireAt int64  `json:"e"` // unix ms
+}
+
+// Save writes the live entries of the store to w, with their expiries, for Load to restore them
+func (m *Memory) Save(w io.Writer) error {
+	if m.ristretto != nil {
+		return errRistrettoEngine
+	}
+	buffered := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffered)
+	var err error
+	m.forEach(func(key string, item memItem) bool {
+		err = encoder.Encode(memSnapshotEntry{Key: key, Value: item.Value().value, ExpireAt: item.Expires().UnixMilli()})
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	return buffered.Flush()
+}
+
+// Load adds the entries saved by Save to the store, keeping their expiries, entries expired since are skipped
+func (m *Memory) Load(r io.Reader) error {
+	if m.ristretto != nil {
+		return errRistrettoEngine
+	}
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var entry memSnapshotEntry
+		if err := decoder.Decode(&entry); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		ttl := time.Until(time.UnixMilli(entry.ExpireAt))
+		if ttl <= 0 {
+			continue
+		}
+		m.setFor(util.Kv{Key: entry.Key, Value: entry.Value}, ttl)
+	}
+}
+
+// Close saves the store to MemStoreConfig.SnapshotFile, through a temporary file so that a failed save leaves
+// the previous snapshot whole, and stops the cleanup and the workers of the shards. The store can't be used
+// after it is closed
+func (m *Memory) Close() error {
+	if m.ristretto != nil {
+		m.ristretto.config.Cache.(*ristretto.Cache).Close()
+		return nil
+	}
+	if m.shards == nil {
+		return nil
+	}
+	defer m.stopShards()
+	if m.config.SnapshotFile == "" {
+		return nil
+	}
+	tmp := m.config.SnapshotFile + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err = m.Save(file); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, m.config.SnapshotFile)
+}
+
+// stopShards ends the cleanup goroutines and stops the ccache worker of every shard, only once
+func (m *Memory) stopShards() {
+	m.stopOnce.Do(func() {
+		close(m.stop)
+		for _, shard := range m.shards {
+			shard.Stop()
+		}
+	})
+}
+
+func (m *Memory) loadFile(logger util.LoggerInterface) {
+	file, err := os.Open(m.config.SnapshotFile)
+	if err == nil {
+		err = m.Load(file)
+		_ = file.Close()
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) && logger != nil {
+		logger.CtxError(context.Background(), "[Memory] load snapshot %s error: %v", m.config.SnapshotFile, err)
+	}
+}
+
+func ceilDiv(a int64, b int64) int64 {
+	return (a + b - 1) / b
+}
+
+// shard returns the shard of key, picked by its FNV-1a hash
+func (m *Memory) shard(key string) memShard {
+	if len(m.shards) == 1 {
+		return m.shards[0]
+	}
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+	return m.shards[hash%uint32(len(m.shards))]
+}
+
+// cleanup removes the expired entries of the shard every interval, until the store is closed
+func (m *Memory) cleanup(shard memShard, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+		shard.DeleteFunc(func(key string, item memItem) bool {
+			if !item.Expired() {
+				return false
+			}
+			markHandled(item)
+			m.notify(key, EvictionReasonExpired)
+			return true
+		})
+	}
+}
+
+// deleteFunc deletes the entries of every shard matches returns true for, reporting them as explicit removals
+func (m *Memory) deleteFunc(matches func(key string) bool) {
+	for _, shard := range m.shards {
+		shard.DeleteFunc(func(key string, item memItem) bool {
+			if !matches(key) {
+				return false
+			}
+			markHandled(item)
+			m.notify(key, EvictionReasonExplicit)
+			return true
+		})
+	}
+}
+
+// forEach calls fn with the live entries of every shard until it returns false
+func (m *Memory) forEach(fn func(key string, item memItem) bool) {
+	for _, shard := range m.shards {
+		more := true
+		shard.ForEachFunc(func(key string, item memItem) bool {
+			if item.Expired() {
+				return true
+			}
+			more = fn(key, item)
+			return more
+		})
+		if !more {
+			return
+		}
+	}
+}
+
+func (m *Memory) notifyEviction(item memItem) {
+	if EvictionReason(atomic.LoadInt32(&item.Value().removal)) == evictionReasonHandled {
+		return
+	}
+	if item.Expired() {
+		m.notify(item.Key(), EvictionReasonExpired)
+	} else {
+		m.notify(item.Key(), EvictionReasonCapacity)
+	}
+}
+
+func (m *Memory) notify(key string, reason EvictionReason) {
+	if m.config.Evictions == nil {
+		return
+	}
+	select {
+	case m.config.Evictions <- EvictionEvent{Key: key, Reason: reason}:
+	default:
+	}
+}
+
+// markHandled keeps OnDelete from reporting item, the store reports removals it makes itself
+// since ccache skips OnDelete for entries its worker has not tracked yet
+func markHandled(item memItem) {
+	atomic.StoreInt32(&item.Value().removal, int32(evictionReasonHandled))
+}
+
+// get returns the live item for key, expired items are removed on access
+func (m *Memory) get(key string) memItem {
+	item := m.shard(key).Get(key)
+	if item == nil {
+		return nil
+	}
+	if item.Expired() {
+		m.remove(key, EvictionReasonExpired)
+		return nil
+	}
+	return item
+}
+
+func (m *Memory) remove(key string, reason EvictionReason) {
+	shard := m.shard(key)
+	item := shard.GetWithoutPromote(key)
+	if item == nil {
+		return
+	}
+	markHandled(item)
+	if shard.Delete(key) {
+		m.notify(key, reason)
+	}
+}
+
+func (m *Memory) set(kv util.Kv) {
+	m.setFor(kv, m.expiration(kv.TTL))
+}
+
+// setFor caches kv for ttl, as is
+func (m *Memory) setFor(kv util.Kv, ttl time.Duration) {
+	shard := m.shard(kv.Key)
+	if item := shard.GetWithoutPromote(kv.Key); item != nil {
+		markHandled(item)
+	}
+	shard.Set(kv.Key, &memEntry{value: kv.Value, size: m.entrySize(kv)}, ttl)
+}
+
+// entrySize returns the size ccache accounts kv for, in entries or, with MaxMemoryBytes, in memSizeUnit
+func (m *Memory) entrySize(kv util.Kv) int64 {
+	if m.config.MaxMemoryBytes <= 0 {
+		return 1
+	}
+	size := (entryBytes(kv.Key, kv.Value) + memSizeUnit - 1) / memSizeUnit
+	if size < m.minEntrySize {
+		return m.minEntrySize
+	}
+	return size
+}
+
+// expiration returns the jittered ttl of a key cached for ttl ms, 0 uses the store's ttl
+func (m *Memory) expiration(ttl int64) time.Duration {
+	if ttl > 0 {
+		return time.Duration(util.JitterInt64(ttl, m.jitter)) * time.Millisecond
+	}
+	if m.ttl > 0 {
+		return time.Duration(util.JitterInt64(m.ttl, m.jitter)) * time.Millisecond
+	}
+	return time.Duration(util.RandFloatingInt64(24)) * time.Hour
+}
+
+func (m *Memory) ExpireKeys(ctx context.Context, keys []string, ttl int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if m.ristretto != nil {
+		return m.ristretto.ExpireKeys(ctx, keys, ttl)
+	}
+	for _, key := range keys {
+		if item := m.get(key); item != nil {
+			item.Extend(m.expiration(ttl))
+		}
+	}
+	return nil
+}
+
 func (m *Memory) CleanCache(ctx context.Context) error {
-	m.cache.Clear()
+	if m.ristretto != nil {
+		return m.ristretto.CleanCache(ctx)
+	}
+	if m.config.Evictions == nil {
+		for _, shard := range m.shards {
+			shard.Clear()
+		}
+		return nil
+	}
+	m.deleteFunc(func(string) bool {
+		return true
+	})
+	return nil
+}
+
+// Ping always succeeds, the store lives in the process
+func (m *Memory) Ping(context.Context) error {
 	return nil
 }
 
 func (m *Memory) BatchKeyExist(ctx context.Context, keys []string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	if m.ristretto != nil {
+		return m.ristretto.BatchKeyExist(ctx, keys)
+	}
 	for _, key := range keys {
-		item := m.cache.Get(key)
-		if item == nil || item.Expired() {
+		if m.get(key) == nil {
 			return false, nil
 		}
 	}
@@ -61,65 +588,232 @@ func (m *Memory) BatchKeyExist(ctx context.Context, keys []string) (bool, error)
 }
 
 func (m *Memory) KeyExists(ctx context.Context, key string) (bool, error) {
-	item := m.cache.Get(key)
-	return item != nil && !item.Expired(), nil
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	if m.ristretto != nil {
+		return m.ristretto.KeyExists(ctx, key)
+	}
+	return m.get(key) != nil, nil
 }
 
 func (m *Memory) GetValue(ctx context.Context, key string) (string, error) {
-	item := m.cache.Get(key)
-	if item == nil || item.Expired() {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if m.ristretto != nil {
+		return m.ristretto.GetValue(ctx, key)
+	}
+	item := m.get(key)
+	if item == nil {
 		return "", ErrCacheNotFound
 	}
-	return item.Value(), nil
+	return item.Value().value, nil
 }
 
 func (m *Memory) BatchGetValues(ctx context.Context, keys []string) ([]string, error) {
-	values := make([]string, 0, len(keys))
-	for _, key := range keys {
-		item := m.cache.Get(key)
-		if item != nil && !item.Expired() {
-			values = append(values, item.Value())
-		}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if m.ristretto != nil {
+		return m.ristretto.BatchGetValues(ctx, keys)
 	}
-	if len(values) != len(keys) {
-		return nil, fmt.Errorf("cannot get items")
+	values := make([]string, len(keys))
+	for idx, key := range keys {
+		if item := m.get(key); item != nil {
+			values[idx] = item.Value().value
+		}
 	}
 	return values, nil
 }
 
 func (m *Memory) DeleteKeysWithPrefix(ctx context.Context, keyPrefix string) error {
-	m.cache.DeletePrefix(keyPrefix)
+	if m.ristretto != nil {
+		return m.ristretto.DeleteKeysWithPrefix(ctx, keyPrefix)
+	}
+	if m.config.Evictions == nil {
+		for _, shard := range m.shards {
+			shard.DeletePrefix(keyPrefix)
+		}
+		return nil
+	}
+	m.deleteFunc(func(key string) bool {
+		return strings.HasPrefix(key, keyPrefix)
+	})
 	return nil
 }
 
 func (m *Memory) DeleteKey(ctx context.Context, key string) error {
-	m.cache.Delete(key)
+	if m.ristretto != nil {
+		return m.ristretto.DeleteKey(ctx, key)
+	}
+	m.remove(key, EvictionReasonExplicit)
 	return nil
 }
 
 func (m *Memory) BatchDeleteKeys(ctx context.Context, keys []string) error {
+	if m.ristretto != nil {
+		return m.ristretto.BatchDeleteKeys(ctx, keys)
+	}
 	for _, key := range keys {
-		m.cache.Delete(key)
+		m.remove(key, EvictionReasonExplicit)
 	}
 	return nil
 }
 
 func (m *Memory) BatchSetKeys(ctx context.Context, kvs []util.Kv) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if m.ristretto != nil {
+		return m.ristretto.BatchSetKeys(ctx, kvs)
+	}
 	for _, kv := range kvs {
-		if m.ttl > 0 {
-			m.cache.Set(kv.Key, kv.Value, time.Duration(util.RandFloatingInt64(m.ttl))*time.Millisecond)
-		} else {
-			m.cache.Set(kv.Key, kv.Value, time.Duration(util.RandFloatingInt64(24))*time.Hour)
-		}
+		m.set(kv)
 	}
 	return nil
 }
 
 func (m *Memory) SetKey(ctx context.Context, kv util.Kv) error {
-	if m.ttl > 0 {
-		m.cache.Set(kv.Key, kv.Value, time.Duration(util.RandFloatingInt64(m.ttl))*time.Millisecond)
-	} else {
-		m.cache.Set(kv.Key, kv.Value, time.Duration(util.RandFloatingInt64(24))*time.Hour)
+	if err := ctx.Err(); err != nil {
+		return err
 	}
+	if m.ristretto != nil {
+		return m.ristretto.SetKey(ctx, kv)
+	}
+	m.set(kv)
 	return nil
 }
+
+func (m *Memory) CountKeysWithPrefix(ctx context.Context, keyPrefix string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if m.ristretto != nil {
+		return 0, util.ErrNotCountable
+	}
+	var count int64
+	m.forEach(func(key string, _ memItem) bool {
+		if strings.HasPrefix(key, keyPrefix) {
+			count++
+		}
+		return true
+	})
+	return count, nil
+}
+
+func (m *Memory) BumpVersion(ctx context.Context, sequenceKey string, versionKeys []string, ttl int64) (int64, error) {
+	if m.ristretto != nil {
+		return m.ristretto.BumpVersion(ctx, sequenceKey, versionKeys, ttl)
+	}
+	m.versionMu.Lock()
+	defer m.versionMu.Unlock()
+	var version int64
+	if item := m.get(sequenceKey); item != nil {
+		version = parseVersion(item.Value().value)
+	}
+	version++
+	value := strconv.FormatInt(version, 10)
+	m.setFor(util.Kv{Key: sequenceKey, Value: value}, memSequenceTTL)
+	for _, key := range versionKeys {
+		m.set(util.Kv{Key: key, Value: value, TTL: ttl})
+	}
+	return version, nil
+}
+
+func (m *Memory) SetKeysIfVersion(ctx context.Context, kvs []util.Kv, versionKeys [][]string, version int64) ([]bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if m.ristretto != nil {
+		return m.ristretto.SetKeysIfVersion(ctx, kvs, versionKeys, version)
+	}
+	m.versionMu.Lock()
+	defer m.versionMu.Unlock()
+	written := make([]bool, len(kvs))
+	for idx, kv := range kvs {
+		written[idx] = !versionAbove(versionKeys[idx], version, func(key string) string {
+			if item := m.get(key); item != nil {
+				return item.Value().value
+			}
+			return ""
+		})
+		if written[idx] {
+			m.set(kv)
+		}
+	}
+	return written, nil
+}
+
+// versionAbove reports whether one of the keys holds a version above version, read by get
+func versionAbove(keys []string, version int64, get func(key string) string) bool {
+	for _, key := range keys {
+		if parseVersion(get(key)) > version {
+			return true
+		}
+	}
+	return false
+}
+
+// parseVersion returns the version held by value, 0 for none
+func parseVersion(value string) int64 {
+	version, _ := strconv.ParseInt(value, 10, 64)
+	return version
+}
+
+// EvictionCount returns how many entries were evicted to make room for new ones
+func (m *Memory) EvictionCount() uint64 {
+	if m.ristretto != nil {
+		return 0
+	}
+	m.evictionMu.Lock()
+	defer m.evictionMu.Unlock()
+	for _, shard := range m.shards {
+		m.evictions += uint64(shard.GetDropped())
+	}
+	return m.evictions
+}
+
+func (m *Memory) Snapshot() map[string]interface{} {
+	return map[string]interface{}{
+		"maxSize":         m.config.MaxSize,
+		"maxMemoryBytes":  m.config.MaxMemoryBytes,
+		"evictionPolicy":  m.config.EvictionPolicy.String(),
+		"engine":          m.config.Engine.String(),
+		"shardCount":      m.config.ShardCount,
+		"cleanupInterval": m.config.CleanupInterval,
+		"snapshotFile":    m.config.SnapshotFile,
+	}
+}
+
+func (m *Memory) ListKeysWithPrefix(ctx context.Context, keyPrefix string, limit int) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if m.ristretto != nil {
+		return nil, errRistrettoEngine
+	}
+	keys := make([]string, 0)
+	m.forEach(func(key string, _ memItem) bool {
+		if strings.HasPrefix(key, keyPrefix) {
+			keys = append(keys, key)
+		}
+		return len(keys) < limit
+	})
+	return keys, nil
+}
+
+// KeyTTL returns the ms left before key expires, keys cached without a ttl live about a day in the store
+func (m *Memory) KeyTTL(ctx context.Context, key string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if m.ristretto != nil {
+		return m.ristretto.KeyTTL(ctx, key)
+	}
+	item := m.shard(key).GetWithoutPromote(key)
+	if item == nil || item.Expired() {
+		return 0, ErrCacheNotFound
+	}
+	return item.TTL().Milliseconds(), nil
+}
@@ -0,0 +1,408 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joykk/gorm-cache/util"
+)
+
+var (
+	_ DataStorage     = &MemSync{}
+	_ Snapshotter     = &MemSync{}
+	_ KeyCounter      = &MemSync{}
+	_ KeyLister       = &MemSync{}
+	_ TTLReader       = &MemSync{}
+	_ Expirer         = &MemSync{}
+	_ EvictionCounter = &MemSync{}
+	_ Versioner       = &MemSync{}
+)
+
+// Clock tells MemSync the current time
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// ManualClock is a Clock that only moves when told to, for tests
+type ManualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+type memSyncEntry struct {
+	key      string
+	value    string
+	expireAt time.Time // zero if the entry never expires
+	bytes    int64     // accounted by MaxMemoryBytes
+	hits     uint64    // reads and writes of the entry, counted by EvictionLFU
+}
+
+// NewMemSync returns a deterministic in-memory store meant for tests. Every operation completes
+// before returning: there is no background goroutine, entries expire lazily once clock passes
+// their TTL (which is not randomized unless Config.Jitter is set), and when MaxSize or MaxMemoryBytes is
+// reached entries are evicted by MemStoreConfig.EvictionPolicy, scanning the store under EvictionLFU.
+// Evictions are reported synchronously. A nil clock uses the system time.
+func NewMemSync(clock Clock, config ...*MemStoreConfig) *MemSync {
+	if clock == nil {
+		clock = systemClock{}
+	}
+	if len(config) == 0 {
+		config = append(config, DefaultMemStoreConfig)
+	}
+	return &MemSync{config: config[0], clock: clock}
+}
+
+type MemSync struct {
+	config *MemStoreConfig
+	clock  Clock
+	ttl    int64
+	jitter float64
+
+	mu        sync.Mutex
+	entries   map[string]*list.Element // of *memSyncEntry
+	order     *list.List               // most recently used first
+	bytes     int64                    // of the entries held, accounted by MaxMemoryBytes
+	evictions uint64
+
+	once sync.Once
+}
+
+func (m *MemSync) Init(conf *Config) error {
+	m.once.Do(func() {
+		m.entries = make(map[string]*list.Element)
+		m.order = list.New()
+		m.ttl = conf.TTL
+		m.jitter = conf.Jitter
+	})
+	return nil
+}
+
+func (m *MemSync) notify(key string, reason EvictionReason) {
+	if m.config.Evictions == nil {
+		return
+	}
+	select {
+	case m.config.Evictions <- EvictionEvent{Key: key, Reason: reason}:
+	default:
+	}
+}
+
+func (m *MemSync) expired(entry *memSyncEntry) bool {
+	return !entry.expireAt.IsZero() && !m.clock.Now().Before(entry.expireAt)
+}
+
+// get returns the live entry for key and marks it as recently used, expired entries are removed on access
+func (m *MemSync) get(key string) *memSyncEntry {
+	elem, ok := m.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := elem.Value.(*memSyncEntry)
+	if m.expired(entry) {
+		m.remove(elem, EvictionReasonExpired)
+		return nil
+	}
+	m.order.MoveToFront(elem)
+	entry.hits++
+	return entry
+}
+
+func (m *MemSync) remove(elem *list.Element, reason EvictionReason) {
+	entry := m.order.Remove(elem).(*memSyncEntry)
+	delete(m.entries, entry.key)
+	m.bytes -= entry.bytes
+	if reason == EvictionReasonCapacity {
+		m.evictions++
+	}
+	m.notify(entry.key, reason)
+}
+
+func (m *MemSync) set(kv util.Kv) {
+	key := kv.Key
+	entry := &memSyncEntry{key: key, value: kv.Value, expireAt: m.expireAt(kv.TTL), bytes: entryBytes(key, kv.Value), hits: 1}
+	m.bytes += entry.bytes
+	elem, ok := m.entries[key]
+	if ok {
+		m.bytes -= elem.Value.(*memSyncEntry).bytes
+		entry.hits += elem.Value.(*memSyncEntry).hits
+		elem.Value = entry
+		m.order.MoveToFront(elem)
+	} else {
+		elem = m.order.PushFront(entry)
+		m.entries[key] = elem
+	}
+	for m.overCapacity() {
+		m.remove(m.victim(elem), EvictionReasonCapacity)
+	}
+}
+
+// victim returns the entry to evict by EvictionPolicy. Under EvictionLFU it is the least frequently used,
+// the least recently used of them on a tie, sparing the entry kept unless it is the only one
+func (m *MemSync) victim(kept *list.Element) *list.Element {
+	if m.config.EvictionPolicy != EvictionLFU {
+		return m.order.Back()
+	}
+	var victim *list.Element
+	for elem := m.order.Back(); elem != nil; elem = elem.Prev() {
+		if elem != kept && (victim == nil || elem.Value.(*memSyncEntry).hits < victim.Value.(*memSyncEntry).hits) {
+			victim = elem
+		}
+	}
+	if victim == nil {
+		return kept
+	}
+	return victim
+}
+
+// overCapacity reports whether the store holds more than MaxSize entries or MaxMemoryBytes bytes
+func (m *MemSync) overCapacity() bool {
+	if m.order.Len() == 0 {
+		return false
+	}
+	return (m.config.MaxSize > 0 && int64(m.order.Len()) > m.config.MaxSize) ||
+		(m.config.MaxMemoryBytes > 0 && m.bytes > m.config.MaxMemoryBytes)
+}
+
+// expireAt returns when a key cached now for ttl ms expires, 0 uses the store's ttl.
+// The zero time means never.
+func (m *MemSync) expireAt(ttl int64) time.Time {
+	if ttl <= 0 {
+		ttl = m.ttl
+	}
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	if m.jitter > 0 {
+		ttl = util.JitterInt64(ttl, m.jitter)
+	}
+	return m.clock.Now().Add(time.Duration(ttl) * time.Millisecond)
+}
+
+func (m *MemSync) ExpireKeys(ctx context.Context, keys []string, ttl int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range keys {
+		if entry := m.get(key); entry != nil {
+			entry.expireAt = m.expireAt(ttl)
+		}
+	}
+	return nil
+}
+
+// deleteMatching removes the entries matching keyPrefix from the least recently used on,
+// expired ones are reported as such
+func (m *MemSync) deleteMatching(keyPrefix string) {
+	for elem := m.order.Back(); elem != nil; {
+		prev := elem.Prev()
+		entry := elem.Value.(*memSyncEntry)
+		if strings.HasPrefix(entry.key, keyPrefix) {
+			if m.expired(entry) {
+				m.remove(elem, EvictionReasonExpired)
+			} else {
+				m.remove(elem, EvictionReasonExplicit)
+			}
+		}
+		elem = prev
+	}
+}
+
+func (m *MemSync) CleanCache(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deleteMatching("")
+	return nil
+}
+
+// Ping always succeeds, the store lives in the process
+func (m *MemSync) Ping(context.Context) error {
+	return nil
+}
+
+func (m *MemSync) BatchKeyExist(ctx context.Context, keys []string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range keys {
+		if m.get(key) == nil {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (m *MemSync) KeyExists(ctx context.Context, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.get(key) != nil, nil
+}
+
+func (m *MemSync) GetValue(ctx context.Context, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry := m.get(key)
+	if entry == nil {
+		return "", ErrCacheNotFound
+	}
+	return entry.value, nil
+}
+
+func (m *MemSync) BatchGetValues(ctx context.Context, keys []string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	values := make([]string, len(keys))
+	for idx, key := range keys {
+		if entry := m.get(key); entry != nil {
+			values[idx] = entry.value
+		}
+	}
+	return values, nil
+}
+
+func (m *MemSync) DeleteKeysWithPrefix(ctx context.Context, keyPrefix string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deleteMatching(keyPrefix)
+	return nil
+}
+
+func (m *MemSync) DeleteKey(ctx context.Context, key string) error {
+	return m.BatchDeleteKeys(ctx, []string{key})
+}
+
+func (m *MemSync) BatchDeleteKeys(ctx context.Context, keys []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range keys {
+		if elem, ok := m.entries[key]; ok {
+			m.remove(elem, EvictionReasonExplicit)
+		}
+	}
+	return nil
+}
+
+func (m *MemSync) BatchSetKeys(ctx context.Context, kvs []util.Kv) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, kv := range kvs {
+		m.set(kv)
+	}
+	return nil
+}
+
+func (m *MemSync) SetKey(ctx context.Context, kv util.Kv) error {
+	return m.BatchSetKeys(ctx, []util.Kv{kv})
+}
+
+func (m *MemSync) CountKeysWithPrefix(ctx context.Context, keyPrefix string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var count int64
+	for key, elem := range m.entries {
+		if strings.HasPrefix(key, keyPrefix) && !m.expired(elem.Value.(*memSyncEntry)) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MemSync) BumpVersion(ctx context.Context, sequenceKey string, versionKeys []string, ttl int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var version int64
+	if entry := m.get(sequenceKey); entry != nil {
+		version = parseVersion(entry.value)
+	}
+	version++
+	value := strconv.FormatInt(version, 10)
+	m.set(util.Kv{Key: sequenceKey, Value: value, TTL: memSequenceTTL.Milliseconds()})
+	for _, key := range versionKeys {
+		m.set(util.Kv{Key: key, Value: value, TTL: ttl})
+	}
+	return version, nil
+}
+
+func (m *MemSync) SetKeysIfVersion(ctx context.Context, kvs []util.Kv, versionKeys [][]string, version int64) ([]bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	written := make([]bool, len(kvs))
+	for idx, kv := range kvs {
+		written[idx] = !versionAbove(versionKeys[idx], version, func(key string) string {
+			if entry := m.get(key); entry != nil {
+				return entry.value
+			}
+			return ""
+		})
+		if written[idx] {
+			m.set(kv)
+		}
+	}
+	return written, nil
+}
+
+// EvictionCount returns how many entries were evicted to make room for new ones
+func (m *MemSync) EvictionCount() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.evictions
+}
+
+func (m *MemSync) Snapshot() map[string]interface{} {
+	return map[string]interface{}{
+		"maxSize":        m.config.MaxSize,
+		"maxMemoryBytes": m.config.MaxMemoryBytes,
+		"evictionPolicy": m.config.EvictionPolicy.String(),
+	}
+}
+
+func (m *MemSync) ListKeysWithPrefix(ctx context.Context, keyPrefix string, limit int) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := make([]string, 0)
+	for key, elem := range m.entries {
+		if len(keys) >= limit {
+			break
+		}
+		if strings.HasPrefix(key, keyPrefix) && !m.expired(elem.Value.(*memSyncEntry)) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (m *MemSync) KeyTTL(ctx context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	elem, ok := m.entries[key]
+	if !ok || m.expired(elem.Value.(*memSyncEntry)) {
+		return 0, ErrCacheNotFound
+	}
+	expireAt := elem.Value.(*memSyncEntry).expireAt
+	if expireAt.IsZero() {
+		return 0, nil
+	}
+	return expireAt.Sub(m.clock.Now()).Milliseconds(), nil
+}
@@ -0,0 +1,264 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/joykk/gorm-cache/util"
+	"github.com/nats-io/nats.go"
+)
+
+var (
+	_ DataStorage = &NatsKV{}
+	_ KeyCounter  = &NatsKV{}
+	_ KeyLister   = &NatsKV{}
+)
+
+type NatsKVStoreConfig struct {
+	Bucket   string // bucket name, created with the cache TTL as max-age if it doesn't exist
+	Replicas int    // replicas of a newly created bucket
+
+	Conn    *nats.Conn // if Conn is not nil, URL and Options will be ignored
+	URL     string
+	Options []nats.Option
+}
+
+// NewNatsKV creates a storage backed by a NATS JetStream key-value bucket.
+// Expiry is bucket wide: the TTL passed to Init becomes the max-age of a newly created bucket,
+// and per key TTLs are ignored.
+func NewNatsKV(config ...*NatsKVStoreConfig) *NatsKV {
+	if len(config) == 0 {
+		panic("nats kv config is required")
+	}
+	if config[0].Bucket == "" {
+		panic("nats kv bucket is required")
+	}
+	return &NatsKV{config: config[0]}
+}
+
+type NatsKV struct {
+	config *NatsKVStoreConfig
+	conn   *nats.Conn
+	kv     nats.KeyValue
+	logger util.LoggerInterface
+
+	once sync.Once
+}
+
+func (n *NatsKV) Init(conf *Config) error {
+	var err error
+	n.once.Do(func() {
+		n.logger = conf.Logger
+		n.logger.SetIsDebug(conf.Debug)
+
+		n.conn = n.config.Conn
+		if n.conn == nil {
+			n.conn, err = nats.Connect(n.config.URL, n.config.Options...)
+			if err != nil {
+				n.logger.CtxError(context.Background(), "[Init] connect nats error: %v", err)
+				return
+			}
+		}
+		var js nats.JetStreamContext
+		js, err = n.conn.JetStream()
+		if err != nil {
+			n.logger.CtxError(context.Background(), "[Init] get jetstream context error: %v", err)
+			return
+		}
+		n.kv, err = js.KeyValue(n.config.Bucket)
+		if errors.Is(err, nats.ErrBucketNotFound) {
+			n.kv, err = js.CreateKeyValue(&nats.KeyValueConfig{
+				Bucket:   n.config.Bucket,
+				TTL:      time.Duration(conf.TTL) * time.Millisecond,
+				Replicas: n.config.Replicas,
+			})
+		}
+		if err != nil {
+			n.logger.CtxError(context.Background(), "[Init] bind kv bucket %s error: %v", n.config.Bucket, err)
+		}
+	})
+	return err
+}
+
+// encodeKey maps cache keys, which may contain any character, to valid kv keys
+func encodeKey(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeKey(key string) (string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(key)
+	return string(data), err
+}
+
+// keysWithPrefix lists the encoded keys whose decoded form starts with keyPrefix
+func (n *NatsKV) keysWithPrefix(keyPrefix string) ([]string, error) {
+	keys, err := n.kv.Keys()
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	matched := make([]string, 0, len(keys))
+	for _, key := range keys {
+		decoded, err := decodeKey(key)
+		if err != nil || !strings.HasPrefix(decoded, keyPrefix) {
+			continue
+		}
+		matched = append(matched, key)
+	}
+	return matched, nil
+}
+
+func (n *NatsKV) CleanCache(ctx context.Context) error {
+	return n.DeleteKeysWithPrefix(ctx, "")
+}
+
+// Ping makes a round trip to the nats server, bounded by the deadline of ctx if any
+func (n *NatsKV) Ping(ctx context.Context) error {
+	if n.conn == nil || n.kv == nil {
+		return errors.New("nats kv is not initialized")
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		return n.conn.Flush()
+	}
+	return n.conn.FlushWithContext(ctx)
+}
+
+func (n *NatsKV) BatchKeyExist(ctx context.Context, keys []string) (bool, error) {
+	for _, key := range keys {
+		exists, err := n.KeyExists(ctx, key)
+		if err != nil || !exists {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (n *NatsKV) KeyExists(ctx context.Context, key string) (bool, error) {
+	_, err := n.GetValue(ctx, key)
+	if errors.Is(err, ErrCacheNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (n *NatsKV) GetValue(ctx context.Context, key string) (string, error) {
+	entry, err := n.kv.Get(encodeKey(key))
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return "", ErrCacheNotFound
+	}
+	if err != nil {
+		n.logger.CtxError(ctx, "[GetValue] get key %s error: %v", key, err)
+		return "", err
+	}
+	return string(entry.Value()), nil
+}
+
+func (n *NatsKV) BatchGetValues(ctx context.Context, keys []string) ([]string, error) {
+	values := make([]string, len(keys))
+	err := n.concurrently(keys, func(idx int, key string) error {
+		value, err := n.GetValue(ctx, key)
+		if errors.Is(err, ErrCacheNotFound) {
+			return nil
+		}
+		values[idx] = value
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func (n *NatsKV) CountKeysWithPrefix(ctx context.Context, keyPrefix string) (int64, error) {
+	keys, err := n.keysWithPrefix(keyPrefix)
+	if err != nil {
+		n.logger.CtxError(ctx, "[CountKeysWithPrefix] list keys error: %v", err)
+		return 0, err
+	}
+	return int64(len(keys)), nil
+}
+
+func (n *NatsKV) DeleteKeysWithPrefix(ctx context.Context, keyPrefix string) error {
+	keys, err := n.keysWithPrefix(keyPrefix)
+	if err != nil {
+		n.logger.CtxError(ctx, "[DeleteKeysWithPrefix] list keys error: %v", err)
+		return err
+	}
+	return n.concurrently(keys, func(_ int, key string) error {
+		return n.kv.Purge(key)
+	})
+}
+
+func (n *NatsKV) DeleteKey(ctx context.Context, key string) error {
+	return n.kv.Purge(encodeKey(key))
+}
+
+func (n *NatsKV) BatchDeleteKeys(ctx context.Context, keys []string) error {
+	return n.concurrently(keys, func(_ int, key string) error {
+		return n.DeleteKey(ctx, key)
+	})
+}
+
+func (n *NatsKV) BatchSetKeys(ctx context.Context, kvs []util.Kv) error {
+	keys := make([]string, 0, len(kvs))
+	for _, kv := range kvs {
+		keys = append(keys, kv.Key)
+	}
+	return n.concurrently(keys, func(idx int, _ string) error {
+		return n.SetKey(ctx, kvs[idx])
+	})
+}
+
+func (n *NatsKV) SetKey(ctx context.Context, kv util.Kv) error {
+	_, err := n.kv.PutString(encodeKey(kv.Key), kv.Value)
+	return err
+}
+
+// concurrently runs fn for every key in its own goroutine and collects the errors
+func (n *NatsKV) concurrently(keys []string, fn func(idx int, key string) error) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs error
+	)
+	wg.Add(len(keys))
+	for idx, key := range keys {
+		go func(idx int, key string) {
+			defer wg.Done()
+			if err := fn(idx, key); err != nil {
+				mu.Lock()
+				errs = multierror.Append(errs, err)
+				mu.Unlock()
+			}
+		}(idx, key)
+	}
+	wg.Wait()
+	return errs
+}
+
+func (n *NatsKV) ListKeysWithPrefix(ctx context.Context, keyPrefix string, limit int) ([]string, error) {
+	encoded, err := n.keysWithPrefix(keyPrefix)
+	if err != nil {
+		n.logger.CtxError(ctx, "[ListKeysWithPrefix] list keys error: %v", err)
+		return nil, err
+	}
+	if len(encoded) > limit {
+		encoded = encoded[:limit]
+	}
+	keys := make([]string, 0, len(encoded))
+	for _, key := range encoded {
+		decoded, err := decodeKey(key)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, decoded)
+	}
+	return keys, nil
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+var _ Broadcaster = &NatsBroadcaster{}
+
+type NatsBroadcasterConfig struct {
+	Subject string // subject invalidations are published on, DefaultBroadcastChannel if not set
+
+	Conn    *nats.Conn // if Conn is not nil, URL and Options will be ignored
+	URL     string
+	Options []nats.Option
+}
+
+// NewNatsBroadcaster creates a broadcaster publishing invalidations on a core NATS subject. The connection
+// is made on first use when Conn isn't set. Like redis pub/sub, messages published while an instance is
+// disconnected are lost to it.
+func NewNatsBroadcaster(config ...*NatsBroadcasterConfig) *NatsBroadcaster {
+	if len(config) == 0 {
+		panic("nats broadcaster config is required")
+	}
+	if config[0].Subject == "" {
+		config[0].Subject = DefaultBroadcastChannel
+	}
+	return &NatsBroadcaster{config: config[0]}
+}
+
+type NatsBroadcaster struct {
+	config *NatsBroadcasterConfig
+
+	once    sync.Once
+	conn    *nats.Conn
+	connErr error
+}
+
+func (b *NatsBroadcaster) connect() (*nats.Conn, error) {
+	b.once.Do(func() {
+		b.conn = b.config.Conn
+		if b.conn == nil {
+			b.conn, b.connErr = nats.Connect(b.config.URL, b.config.Options...)
+		}
+	})
+	return b.conn, b.connErr
+}
+
+func (b *NatsBroadcaster) Publish(_ context.Context, invalidation Invalidation) error {
+	conn, err := b.connect()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(invalidation)
+	if err != nil {
+		return err
+	}
+	return conn.Publish(b.config.Subject, data)
+}
+
+func (b *NatsBroadcaster) Subscribe(ctx context.Context, handle func(invalidation Invalidation)) (func() error, error) {
+	conn, err := b.connect()
+	if err != nil {
+		return nil, err
+	}
+	sub, err := conn.Subscribe(b.config.Subject, func(msg *nats.Msg) {
+		invalidation := Invalidation{}
+		if err := json.Unmarshal(msg.Data, &invalidation); err != nil {
+			return
+		}
+		handle(invalidation)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// wait for the server to register the subscription, so that no invalidation published after
+	// Subscribe returns is missed
+	if _, ok := ctx.Deadline(); ok {
+		err = conn.FlushWithContext(ctx)
+	} else {
+		err = conn.Flush()
+	}
+	if err != nil {
+		_ = sub.Unsubscribe()
+		return nil, err
+	}
+	return sub.Unsubscribe, nil
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/joykk/gorm-cache/util"
+)
+
+var (
+	_ DataStorage = &Noop{}
+	_ KeyCounter  = &Noop{}
+	_ KeyLister   = &Noop{}
+)
+
+// NewNoop creates a storage caching nothing: writes succeed without storing anything and every read
+// is a miss, so all queries are served by the database. Useful in tests, or to turn caching off
+// without detaching the plugin.
+func NewNoop() *Noop {
+	return &Noop{}
+}
+
+type Noop struct{}
+
+func (n *Noop) Init(*Config) error {
+	return nil
+}
+
+func (n *Noop) CleanCache(context.Context) error {
+	return nil
+}
+
+func (n *Noop) Ping(context.Context) error {
+	return nil
+}
+
+func (n *Noop) BatchKeyExist(context.Context, []string) (bool, error) {
+	return false, nil
+}
+
+func (n *Noop) KeyExists(context.Context, string) (bool, error) {
+	return false, nil
+}
+
+func (n *Noop) GetValue(context.Context, string) (string, error) {
+	return "", ErrCacheNotFound
+}
+
+// BatchGetValues reports every key as not cached, one empty value per key as DataStorage requires
+func (n *Noop) BatchGetValues(_ context.Context, keys []string) ([]string, error) {
+	return make([]string, len(keys)), nil
+}
+
+func (n *Noop) DeleteKeysWithPrefix(context.Context, string) error {
+	return nil
+}
+
+func (n *Noop) DeleteKey(context.Context, string) error {
+	return nil
+}
+
+func (n *Noop) BatchDeleteKeys(context.Context, []string) error {
+	return nil
+}
+
+func (n *Noop) BatchSetKeys(context.Context, []util.Kv) error {
+	return nil
+}
+
+func (n *Noop) SetKey(context.Context, util.Kv) error {
+	return nil
+}
+
+func (n *Noop) CountKeysWithPrefix(context.Context, string) (int64, error) {
+	return 0, nil
+}
+
+func (n *Noop) ListKeysWithPrefix(context.Context, string, int) ([]string, error) {
+	return nil, nil
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"strings"
 	"sync"
 	"time"
 
@@ -9,13 +10,105 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
-var _ DataStorage = &Redis{}
+var (
+	_ DataStorage = &Redis{}
+	_ Snapshotter = &Redis{}
+	_ KeyCounter  = &Redis{}
+	_ Expirer     = &Redis{}
+	_ Locker      = &Redis{}
+	_ SetStore    = &Redis{}
+	_ KeyLister   = &Redis{}
+	_ TTLReader   = &Redis{}
+	_ Versioner   = &Redis{}
+)
+
+// unlockScript deletes a lock only if it is still held by the token, a lock that expired and was taken by
+// another token is kept
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// addToSetScript adds ARGV[2:] to the set KEYS[1] and makes it live at least ARGV[1] ms, forever for 0.
+// Its expiry is only ever extended, the set must outlive every entry it indexes
+var addToSetScript = redis.NewScript(`
+local existed = redis.call("EXISTS", KEYS[1])
+redis.call("SADD", KEYS[1], unpack(ARGV, 2))
+local ttl = tonumber(ARGV[1])
+if ttl <= 0 then
+	redis.call("PERSIST", KEYS[1])
+elseif existed == 0 then
+	redis.call("PEXPIRE", KEYS[1], ttl)
+else
+	local current = redis.call("PTTL", KEYS[1])
+	if current >= 0 and current < ttl then
+		redis.call("PEXPIRE", KEYS[1], ttl)
+	end
+end
+return 1
+`)
+
+// bumpVersionScript increments the version of KEYS[1] and sets KEYS[2:] to it for ARGV[1] ms, forever for 0
+var bumpVersionScript = redis.NewScript(`
+local version = redis.call("INCR", KEYS[1])
+local ttl = tonumber(ARGV[1])
+for i = 2, #KEYS do
+	if ttl > 0 then
+		redis.call("SET", KEYS[i], version, "PX", ttl)
+	else
+		redis.call("SET", KEYS[i], version)
+	end
+end
+return version
+`)
+
+// setIfVersionScript sets KEYS[1] to ARGV[1] for ARGV[2] ms (forever for 0) unless one of KEYS[2:] holds a
+// version above ARGV[3], returning 1 if it was set
+var setIfVersionScript = redis.NewScript(`
+local version = tonumber(ARGV[3])
+for i = 2, #KEYS do
+	local current = tonumber(redis.call("GET", KEYS[i]) or "0") or 0
+	if current > version then
+		return 0
+	end
+end
+local ttl = tonumber(ARGV[2])
+if ttl > 0 then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ttl)
+else
+	redis.call("SET", KEYS[1], ARGV[1])
+end
+return 1
+`)
+
+// addToSetArgs returns the arguments of addToSetScript
+func addToSetArgs(members []string, ttl int64) []interface{} {
+	args := make([]interface{}, 0, len(members)+1)
+	args = append(args, ttl)
+	for _, member := range members {
+		args = append(args, member)
+	}
+	return args
+}
+
+// redisScanCount is the COUNT hint of SCAN and the size of the batches keys are deleted in
+const redisScanCount = 1000
+
+// defaultRedisBatchSize is the most keys of a MGET, MSET or DEL by default, see RedisStoreConfig.BatchSize
+const defaultRedisBatchSize = 500
 
 type RedisStoreConfig struct {
-	KeyPrefix string // key prefix will be random if not set
+	KeyPrefix string // every key is stored under this prefix, it will be random if not set
 
 	Client  *redis.Client // if Client is not nil, Options will be ignored
 	Options *redis.Options
+
+	// BatchSize most keys read, written or deleted by a single MGET, MSET or DEL. Larger batches are split
+	// into several commands sent together in one pipeline, a round trip still, without a huge command holding
+	// up redis. 0 represents 500
+	BatchSize int
 }
 
 func NewRedis(config ...*RedisStoreConfig) *Redis {
@@ -27,6 +120,10 @@ func NewRedis(config ...*RedisStoreConfig) *Redis {
 	}
 	r := &Redis{
 		keyPrefix: config[0].KeyPrefix,
+		batchSize: config[0].BatchSize,
+	}
+	if r.batchSize <= 0 {
+		r.batchSize = defaultRedisBatchSize
 	}
 	if config[0].Client != nil {
 		r.client = config[0].Client
@@ -39,11 +136,12 @@ func NewRedis(config ...*RedisStoreConfig) *Redis {
 type Redis struct {
 	client    *redis.Client
 	ttl       int64
+	jitter    float64
 	logger    util.LoggerInterface
 	keyPrefix string
+	batchSize int
 
 	batchExistSha string
-	cleanCacheSha string
 
 	once sync.Once
 }
@@ -52,6 +150,7 @@ func (r *Redis) Init(conf *Config) error {
 	var err error
 	r.once.Do(func() {
 		r.ttl = conf.TTL
+		r.jitter = conf.Jitter
 		r.logger = conf.Logger
 		r.logger.SetIsDebug(conf.Debug)
 		err = r.initScripts()
@@ -69,13 +168,6 @@ func (r *Redis) initScripts() error {
 		end
 		return 1`
 
-	cleanCacheScript := `
-		local keys = redis.call('keys', ARGV[1])
-		for i=1,#keys,5000 do 
-			redis.call('del', 'defaultKey', unpack(keys, i, math.min(i+4999, #keys)))
-		end
-		return 1`
-
 	result := r.client.ScriptLoad(context.Background(), batchKeyExistScript)
 	if result.Err() != nil {
 		r.logger.CtxError(context.Background(), "[initScripts] init script 1 error: %v", result.Err())
@@ -83,28 +175,78 @@ func (r *Redis) initScripts() error {
 	}
 	r.batchExistSha = result.Val()
 	r.logger.CtxInfo(context.Background(), "[initScripts] init batch exist script sha1: %s", r.batchExistSha)
+	return nil
+}
 
-	result = r.client.ScriptLoad(context.Background(), cleanCacheScript)
-	if result.Err() != nil {
-		r.logger.CtxError(context.Background(), "[initScripts] init script 2 error: %v", result.Err())
-		return result.Err()
+// key returns the redis key that key is stored under
+func (r *Redis) key(key string) string {
+	return r.keyPrefix + ":" + key
+}
+
+func (r *Redis) keys(keys []string) []string {
+	prefixed := make([]string, len(keys))
+	for idx, key := range keys {
+		prefixed[idx] = r.key(key)
 	}
-	r.cleanCacheSha = result.Val()
-	r.logger.CtxInfo(context.Background(), "[initScripts] init clean cache script sha1: %s", r.cleanCacheSha)
-	return nil
+	return prefixed
 }
 
+// deleteMatching deletes the keys matching pattern, scanning them incrementally rather than with KEYS
+// so that a large keyspace doesn't block the server
+func (r *Redis) deleteMatching(ctx context.Context, pattern string) error {
+	batch := make([]string, 0, redisScanCount)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := r.client.Unlink(ctx, batch...).Err()
+		batch = batch[:0]
+		return err
+	}
+	iter := r.client.Scan(ctx, 0, pattern, redisScanCount).Iterator()
+	for iter.Next(ctx) {
+		batch = append(batch, iter.Val())
+		if len(batch) == redisScanCount {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// escapePattern escapes the glob characters of a literal key prefix for MATCH
+func escapePattern(prefix string) string {
+	var b strings.Builder
+	for _, ch := range prefix {
+		switch ch {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(ch)
+	}
+	return b.String()
+}
+
+// CleanCache deletes the keys under the key prefix only, keys of others sharing the database are kept
 func (r *Redis) CleanCache(ctx context.Context) error {
-	result := r.client.EvalSha(ctx, r.cleanCacheSha, []string{"0"}, r.keyPrefix+":*")
-	if result.Err() != nil {
-		r.logger.CtxError(ctx, "[CleanCache] clean cache error: %v", result.Err())
-		return result.Err()
+	if err := r.deleteMatching(ctx, escapePattern(r.key(""))+"*"); err != nil {
+		r.logger.CtxError(ctx, "[CleanCache] clean cache error: %v", err)
+		return err
 	}
 	return nil
 }
 
+// Ping sends a PING to redis, bounded by the deadline of ctx
+func (r *Redis) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
 func (r *Redis) BatchKeyExist(ctx context.Context, keys []string) (bool, error) {
-	result := r.client.EvalSha(ctx, r.batchExistSha, keys)
+	result := r.client.EvalSha(ctx, r.batchExistSha, r.keys(keys))
 	if result.Err() != nil {
 		r.logger.CtxError(ctx, "[BatchKeyExist] eval script error: %v", result.Err())
 		return false, result.Err()
@@ -113,7 +255,7 @@ func (r *Redis) BatchKeyExist(ctx context.Context, keys []string) (bool, error)
 }
 
 func (r *Redis) KeyExists(ctx context.Context, key string) (bool, error) {
-	result := r.client.Exists(ctx, key)
+	result := r.client.Exists(ctx, r.key(key))
 	if result.Err() != nil {
 		r.logger.CtxError(ctx, "[KeyExists] exists error: %v", result.Err())
 		return false, result.Err()
@@ -125,7 +267,7 @@ func (r *Redis) KeyExists(ctx context.Context, key string) (bool, error) {
 }
 
 func (r *Redis) GetValue(ctx context.Context, key string) (data string, err error) {
-	data, err = r.client.Get(ctx, key).Result()
+	data, err = r.client.Get(ctx, r.key(key)).Result()
 	if err == redis.Nil {
 		err = ErrCacheNotFound
 	}
@@ -133,46 +275,85 @@ func (r *Redis) GetValue(ctx context.Context, key string) (data string, err erro
 }
 
 func (r *Redis) BatchGetValues(ctx context.Context, keys []string) ([]string, error) {
-	result := r.client.MGet(ctx, keys...)
-	if result.Err() != nil {
-		r.logger.CtxError(ctx, "[BatchGetValues] mget error: %v", result.Err())
-		return nil, result.Err()
-	}
-	slice := result.Val()
-	strs := make([]string, 0, len(slice))
-	for _, obj := range slice {
-		if obj != nil {
-			strs = append(strs, obj.(string))
+	chunks := util.Chunk(r.keys(keys), r.batchSize)
+	results := make([]*redis.SliceCmd, 0, len(chunks))
+	err := r.batch(ctx, len(chunks), func(cmdable redis.Cmdable) []redis.Cmder {
+		cmds := make([]redis.Cmder, 0, len(chunks))
+		for _, chunk := range chunks {
+			result := cmdable.MGet(ctx, chunk...)
+			results = append(results, result)
+			cmds = append(cmds, result)
+		}
+		return cmds
+	})
+	if err != nil {
+		r.logger.CtxError(ctx, "[BatchGetValues] mget error: %v", err)
+		return nil, err
+	}
+	strs := make([]string, 0, len(keys))
+	for _, result := range results {
+		for _, obj := range result.Val() {
+			value, _ := obj.(string)
+			strs = append(strs, value)
 		}
 	}
 	return strs, nil
 }
 
+// batch runs the commands issued by queue, sent alone if there is a single one, else together in a pipeline.
+// It returns the first error of the commands
+func (r *Redis) batch(ctx context.Context, commands int, queue func(cmdable redis.Cmdable) []redis.Cmder) error {
+	if commands == 1 {
+		return queue(r.client)[0].Err()
+	}
+	_, err := r.client.Pipelined(ctx, func(pipeliner redis.Pipeliner) error {
+		queue(pipeliner)
+		return nil
+	})
+	return err
+}
+
 func (r *Redis) DeleteKeysWithPrefix(ctx context.Context, keyPrefix string) error {
-	result := r.client.EvalSha(ctx, r.cleanCacheSha, []string{"0"}, keyPrefix+":*")
-	return result.Err()
+	if err := r.deleteMatching(ctx, escapePattern(r.key(separated(keyPrefix)))+"*"); err != nil {
+		r.logger.CtxError(ctx, "[DeleteKeysWithPrefix] delete keys error: %v", err)
+		return err
+	}
+	return nil
 }
 
 func (r *Redis) DeleteKey(ctx context.Context, key string) error {
-	return r.client.Del(ctx, key).Err()
+	return r.client.Del(ctx, r.key(key)).Err()
 }
 
 func (r *Redis) BatchDeleteKeys(ctx context.Context, keys []string) error {
-	return r.client.Del(ctx, keys...).Err()
+	chunks := util.Chunk(r.keys(keys), r.batchSize)
+	return r.batch(ctx, len(chunks), func(cmdable redis.Cmdable) []redis.Cmder {
+		cmds := make([]redis.Cmder, 0, len(chunks))
+		for _, chunk := range chunks {
+			cmds = append(cmds, cmdable.Del(ctx, chunk...))
+		}
+		return cmds
+	})
 }
 
 func (r *Redis) BatchSetKeys(ctx context.Context, kvs []util.Kv) error {
-	if r.ttl == 0 {
-		spreads := make([]interface{}, 0, len(kvs))
-		for _, kv := range kvs {
-			spreads = append(spreads, kv.Key)
-			spreads = append(spreads, kv.Value)
-		}
-		return r.client.MSet(ctx, spreads...).Err()
+	if r.ttl == 0 && !hasTTL(kvs) {
+		chunks := util.Chunk(kvs, r.batchSize)
+		return r.batch(ctx, len(chunks), func(cmdable redis.Cmdable) []redis.Cmder {
+			cmds := make([]redis.Cmder, 0, len(chunks))
+			for _, chunk := range chunks {
+				spreads := make([]interface{}, 0, 2*len(chunk))
+				for _, kv := range chunk {
+					spreads = append(spreads, r.key(kv.Key), kv.Value)
+				}
+				cmds = append(cmds, cmdable.MSet(ctx, spreads...))
+			}
+			return cmds
+		})
 	}
 	_, err := r.client.Pipelined(ctx, func(pipeliner redis.Pipeliner) error {
 		for _, kv := range kvs {
-			result := pipeliner.Set(ctx, kv.Key, kv.Value, time.Duration(util.RandFloatingInt64(r.ttl))*time.Millisecond)
+			result := pipeliner.Set(ctx, r.key(kv.Key), kv.Value, r.expiration(kv))
 			if result.Err() != nil {
 				r.logger.CtxError(ctx, "[BatchSetKeys] set key %s error: %v", kv.Key, result.Err())
 				return result.Err()
@@ -184,5 +365,151 @@ func (r *Redis) BatchSetKeys(ctx context.Context, kvs []util.Kv) error {
 }
 
 func (r *Redis) SetKey(ctx context.Context, kv util.Kv) error {
-	return r.client.Set(ctx, kv.Key, kv.Value, time.Duration(util.RandFloatingInt64(r.ttl))*time.Millisecond).Err()
+	return r.client.Set(ctx, r.key(kv.Key), kv.Value, r.expiration(kv)).Err()
+}
+
+// expiration returns the jittered ttl of kv, 0 if it doesn't expire
+func (r *Redis) expiration(kv util.Kv) time.Duration {
+	ttl := r.ttl
+	if kv.TTL > 0 {
+		ttl = kv.TTL
+	}
+	return time.Duration(util.JitterInt64(ttl, r.jitter)) * time.Millisecond
+}
+
+// ExpireKeys refreshes the expiry of the keys with PEXPIRE, keys without a ttl are left as they are
+func (r *Redis) ExpireKeys(ctx context.Context, keys []string, ttl int64) error {
+	expiration := r.expiration(util.Kv{TTL: ttl})
+	if expiration <= 0 {
+		return nil
+	}
+	_, err := r.client.Pipelined(ctx, func(pipeliner redis.Pipeliner) error {
+		for _, key := range keys {
+			pipeliner.PExpire(ctx, r.key(key), expiration)
+		}
+		return nil
+	})
+	if err != nil {
+		r.logger.CtxError(ctx, "[ExpireKeys] pexpire keys error: %v", err)
+	}
+	return err
+}
+
+func hasTTL(kvs []util.Kv) bool {
+	for _, kv := range kvs {
+		if kv.TTL > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Redis) CountKeysWithPrefix(ctx context.Context, keyPrefix string) (int64, error) {
+	var count int64
+	iter := r.client.Scan(ctx, 0, escapePattern(r.key(keyPrefix))+"*", redisScanCount).Iterator()
+	for iter.Next(ctx) {
+		count++
+	}
+	if err := iter.Err(); err != nil {
+		r.logger.CtxError(ctx, "[CountKeysWithPrefix] scan keys error: %v", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+func (r *Redis) Snapshot() map[string]interface{} {
+	opts := r.client.Options()
+	snapshot := map[string]interface{}{
+		"addr":      opts.Addr,
+		"db":        opts.DB,
+		"keyPrefix": r.keyPrefix,
+		"batchSize": r.batchSize,
+		"tls":       opts.TLSConfig != nil,
+	}
+	if opts.Username != "" {
+		snapshot["username"] = RedactedValue
+	}
+	if opts.Password != "" || opts.CredentialsProvider != nil {
+		snapshot["password"] = RedactedValue
+	}
+	return snapshot
+}
+
+func (r *Redis) TryLock(ctx context.Context, key string, token string, ttl int64) (bool, error) {
+	return r.client.SetNX(ctx, r.key(key), token, time.Duration(ttl)*time.Millisecond).Result()
+}
+
+func (r *Redis) Unlock(ctx context.Context, key string, token string) error {
+	return unlockScript.Run(ctx, r.client, []string{r.key(key)}, token).Err()
+}
+
+func (r *Redis) AddToSet(ctx context.Context, key string, members []string, ttl int64) error {
+	if len(members) == 0 {
+		return nil
+	}
+	return addToSetScript.Run(ctx, r.client, []string{r.key(key)}, addToSetArgs(members, ttl)...).Err()
+}
+
+func (r *Redis) BumpVersion(ctx context.Context, sequenceKey string, versionKeys []string, ttl int64) (int64, error) {
+	keys := append([]string{r.key(sequenceKey)}, r.keys(versionKeys)...)
+	return bumpVersionScript.Run(ctx, r.client, keys, r.expiration(util.Kv{TTL: ttl}).Milliseconds()).Int64()
+}
+
+func (r *Redis) SetKeysIfVersion(ctx context.Context, kvs []util.Kv, versionKeys [][]string, version int64) ([]bool, error) {
+	cmds := make([]*redis.Cmd, 0, len(kvs))
+	err := r.batch(ctx, len(kvs), func(cmdable redis.Cmdable) []redis.Cmder {
+		queued := make([]redis.Cmder, 0, len(kvs))
+		for idx, kv := range kvs {
+			keys := append([]string{r.key(kv.Key)}, r.keys(versionKeys[idx])...)
+			cmd := setIfVersionScript.Eval(ctx, cmdable, keys, kv.Value, r.expiration(kv).Milliseconds(), version)
+			cmds = append(cmds, cmd)
+			queued = append(queued, cmd)
+		}
+		return queued
+	})
+	if err != nil {
+		return nil, err
+	}
+	written := make([]bool, len(kvs))
+	for idx, cmd := range cmds {
+		set, _ := cmd.Int()
+		written[idx] = set == 1
+	}
+	return written, nil
+}
+
+func (r *Redis) SetMembers(ctx context.Context, key string) ([]string, error) {
+	return r.client.SMembers(ctx, r.key(key)).Result()
+}
+
+func (r *Redis) ListKeysWithPrefix(ctx context.Context, keyPrefix string, limit int) ([]string, error) {
+	keys := make([]string, 0)
+	iter := r.client.Scan(ctx, 0, escapePattern(r.key(keyPrefix))+"*", redisScanCount).Iterator()
+	for len(keys) < limit && iter.Next(ctx) {
+		keys = append(keys, strings.TrimPrefix(iter.Val(), r.keyPrefix+":"))
+	}
+	if err := iter.Err(); err != nil {
+		r.logger.CtxError(ctx, "[ListKeysWithPrefix] scan keys error: %v", err)
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (r *Redis) KeyTTL(ctx context.Context, key string) (int64, error) {
+	return pttl(r.client.PTTL(ctx, r.key(key)))
+}
+
+// pttl converts the reply of PTTL, which is -2 for missing keys and -1 for keys without expiry
+func pttl(cmd *redis.DurationCmd) (int64, error) {
+	ttl, err := cmd.Result()
+	if err != nil {
+		return 0, err
+	}
+	switch ttl {
+	case -2:
+		return 0, ErrCacheNotFound
+	case -1:
+		return 0, nil
+	}
+	return ttl.Milliseconds(), nil
 }
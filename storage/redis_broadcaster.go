@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/joykk/gorm-cache/util"
+	"github.com/redis/go-redis/v9"
+)
+
+var _ Broadcaster = &RedisBroadcaster{}
+
+// DefaultBroadcastChannel is the channel invalidations are broadcast on if none is set
+const DefaultBroadcastChannel = util.GormCachePrefix + ":invalidations"
+
+type RedisBroadcasterConfig struct {
+	Channel string // pub/sub channel, DefaultBroadcastChannel if not set
+
+	Client  *redis.Client // if Client is not nil, Options will be ignored
+	Options *redis.Options
+}
+
+// NewRedisBroadcaster creates a broadcaster publishing invalidations on a redis pub/sub channel.
+// Messages published while an instance is disconnected are lost to it.
+func NewRedisBroadcaster(config ...*RedisBroadcasterConfig) *RedisBroadcaster {
+	if len(config) == 0 {
+		panic("redis broadcaster config is required")
+	}
+	if config[0].Channel == "" {
+		config[0].Channel = DefaultBroadcastChannel
+	}
+	b := &RedisBroadcaster{channel: config[0].Channel}
+	if config[0].Client != nil {
+		b.client = config[0].Client
+		return b
+	}
+	b.client = redis.NewClient(config[0].Options)
+	return b
+}
+
+type RedisBroadcaster struct {
+	client  *redis.Client
+	channel string
+}
+
+func (b *RedisBroadcaster) Publish(ctx context.Context, invalidation Invalidation) error {
+	data, err := json.Marshal(invalidation)
+	if err != nil {
+		return err
+	}
+	return b.client.Publish(ctx, b.channel, data).Err()
+}
+
+func (b *RedisBroadcaster) Subscribe(ctx context.Context, handle func(invalidation Invalidation)) (func() error, error) {
+	pubsub := b.client.Subscribe(ctx, b.channel)
+	// wait for the subscription, so that no invalidation published after Subscribe returns is missed
+	if _, err := pubsub.Receive(ctx); err != nil {
+		_ = pubsub.Close()
+		return nil, err
+	}
+	go func() {
+		for msg := range pubsub.Channel() {
+			invalidation := Invalidation{}
+			if err := json.Unmarshal([]byte(msg.Payload), &invalidation); err != nil {
+				continue
+			}
+			handle(invalidation)
+		}
+	}()
+	return pubsub.Close, nil
+}
@@ -0,0 +1,331 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/joykk/gorm-cache/util"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	_ DataStorage = &RedisCluster{}
+	_ Snapshotter = &RedisCluster{}
+	_ KeyCounter  = &RedisCluster{}
+	_ Expirer     = &RedisCluster{}
+	_ Locker      = &RedisCluster{}
+	_ SetStore    = &RedisCluster{}
+	_ KeyLister   = &RedisCluster{}
+	_ TTLReader   = &RedisCluster{}
+)
+
+type RedisClusterStoreConfig struct {
+	KeyPrefix string // every key is stored under this prefix, it will be random if not set
+
+	Client  *redis.ClusterClient // if Client is not nil, Options will be ignored
+	Options *redis.ClusterOptions
+}
+
+// NewRedisCluster creates a storage on a redis cluster. Keys spread over the slots of the cluster, so commands
+// are issued key by key in pipelines rather than as multi-key commands, which fail across slots, and prefix
+// deletes scan every master.
+func NewRedisCluster(config ...*RedisClusterStoreConfig) *RedisCluster {
+	if len(config) == 0 {
+		panic("redis cluster config is required")
+	}
+	if config[0].KeyPrefix == "" {
+		config[0].KeyPrefix = util.GormCachePrefix + ":" + util.GenInstanceId()
+	}
+	r := &RedisCluster{
+		keyPrefix: config[0].KeyPrefix,
+	}
+	if config[0].Client != nil {
+		r.client = config[0].Client
+		return r
+	}
+	if config[0].Options == nil {
+		panic("redis cluster options are required")
+	}
+	r.client = redis.NewClusterClient(config[0].Options)
+	return r
+}
+
+type RedisCluster struct {
+	client    *redis.ClusterClient
+	ttl       int64
+	jitter    float64
+	logger    util.LoggerInterface
+	keyPrefix string
+
+	once sync.Once
+}
+
+func (r *RedisCluster) Init(conf *Config) error {
+	r.once.Do(func() {
+		r.ttl = conf.TTL
+		r.jitter = conf.Jitter
+		r.logger = conf.Logger
+		r.logger.SetIsDebug(conf.Debug)
+	})
+	return nil
+}
+
+// key returns the redis key that key is stored under
+func (r *RedisCluster) key(key string) string {
+	return r.keyPrefix + ":" + key
+}
+
+// deleteMatching deletes the keys matching pattern on every master, scanning them incrementally. Keys found
+// together may lie in different slots of the master, so they are unlinked one by one in a pipeline
+func (r *RedisCluster) deleteMatching(ctx context.Context, pattern string) error {
+	return r.client.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+		batch := make([]string, 0, redisScanCount)
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			_, err := master.Pipelined(ctx, func(pipeliner redis.Pipeliner) error {
+				for _, key := range batch {
+					pipeliner.Unlink(ctx, key)
+				}
+				return nil
+			})
+			batch = batch[:0]
+			return err
+		}
+		iter := master.Scan(ctx, 0, pattern, redisScanCount).Iterator()
+		for iter.Next(ctx) {
+			batch = append(batch, iter.Val())
+			if len(batch) == redisScanCount {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return err
+		}
+		return flush()
+	})
+}
+
+// CleanCache deletes the keys under the key prefix only, keys of others sharing the cluster are kept
+func (r *RedisCluster) CleanCache(ctx context.Context) error {
+	if err := r.deleteMatching(ctx, escapePattern(r.key(""))+"*"); err != nil {
+		r.logger.CtxError(ctx, "[CleanCache] clean cache error: %v", err)
+		return err
+	}
+	return nil
+}
+
+// Ping sends a PING to every node of the cluster, bounded by the deadline of ctx
+func (r *RedisCluster) Ping(ctx context.Context) error {
+	return r.client.ForEachShard(ctx, func(ctx context.Context, shard *redis.Client) error {
+		return shard.Ping(ctx).Err()
+	})
+}
+
+func (r *RedisCluster) BatchKeyExist(ctx context.Context, keys []string) (bool, error) {
+	cmds, err := r.client.Pipelined(ctx, func(pipeliner redis.Pipeliner) error {
+		for _, key := range keys {
+			pipeliner.Exists(ctx, r.key(key))
+		}
+		return nil
+	})
+	if err != nil {
+		r.logger.CtxError(ctx, "[BatchKeyExist] exists error: %v", err)
+		return false, err
+	}
+	for _, cmd := range cmds {
+		if cmd.(*redis.IntCmd).Val() == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (r *RedisCluster) KeyExists(ctx context.Context, key string) (bool, error) {
+	result := r.client.Exists(ctx, r.key(key))
+	if result.Err() != nil {
+		r.logger.CtxError(ctx, "[KeyExists] exists error: %v", result.Err())
+		return false, result.Err()
+	}
+	return result.Val() == 1, nil
+}
+
+func (r *RedisCluster) GetValue(ctx context.Context, key string) (data string, err error) {
+	data, err = r.client.Get(ctx, r.key(key)).Result()
+	if err == redis.Nil {
+		err = ErrCacheNotFound
+	}
+	return
+}
+
+func (r *RedisCluster) BatchGetValues(ctx context.Context, keys []string) ([]string, error) {
+	cmds, err := r.client.Pipelined(ctx, func(pipeliner redis.Pipeliner) error {
+		for _, key := range keys {
+			pipeliner.Get(ctx, r.key(key))
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		r.logger.CtxError(ctx, "[BatchGetValues] get error: %v", err)
+		return nil, err
+	}
+	strs := make([]string, len(keys))
+	for idx, cmd := range cmds {
+		strs[idx] = cmd.(*redis.StringCmd).Val()
+	}
+	return strs, nil
+}
+
+func (r *RedisCluster) DeleteKeysWithPrefix(ctx context.Context, keyPrefix string) error {
+	if err := r.deleteMatching(ctx, escapePattern(r.key(separated(keyPrefix)))+"*"); err != nil {
+		r.logger.CtxError(ctx, "[DeleteKeysWithPrefix] delete keys error: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (r *RedisCluster) DeleteKey(ctx context.Context, key string) error {
+	return r.client.Del(ctx, r.key(key)).Err()
+}
+
+func (r *RedisCluster) BatchDeleteKeys(ctx context.Context, keys []string) error {
+	_, err := r.client.Pipelined(ctx, func(pipeliner redis.Pipeliner) error {
+		for _, key := range keys {
+			pipeliner.Del(ctx, r.key(key))
+		}
+		return nil
+	})
+	return err
+}
+
+func (r *RedisCluster) BatchSetKeys(ctx context.Context, kvs []util.Kv) error {
+	_, err := r.client.Pipelined(ctx, func(pipeliner redis.Pipeliner) error {
+		for _, kv := range kvs {
+			pipeliner.Set(ctx, r.key(kv.Key), kv.Value, r.expiration(kv))
+		}
+		return nil
+	})
+	if err != nil {
+		r.logger.CtxError(ctx, "[BatchSetKeys] set keys error: %v", err)
+	}
+	return err
+}
+
+func (r *RedisCluster) SetKey(ctx context.Context, kv util.Kv) error {
+	return r.client.Set(ctx, r.key(kv.Key), kv.Value, r.expiration(kv)).Err()
+}
+
+// expiration returns the jittered ttl of kv, 0 if it doesn't expire
+func (r *RedisCluster) expiration(kv util.Kv) time.Duration {
+	ttl := r.ttl
+	if kv.TTL > 0 {
+		ttl = kv.TTL
+	}
+	return time.Duration(util.JitterInt64(ttl, r.jitter)) * time.Millisecond
+}
+
+// ExpireKeys refreshes the expiry of the keys with PEXPIRE, keys without a ttl are left as they are
+func (r *RedisCluster) ExpireKeys(ctx context.Context, keys []string, ttl int64) error {
+	expiration := r.expiration(util.Kv{TTL: ttl})
+	if expiration <= 0 {
+		return nil
+	}
+	_, err := r.client.Pipelined(ctx, func(pipeliner redis.Pipeliner) error {
+		for _, key := range keys {
+			pipeliner.PExpire(ctx, r.key(key), expiration)
+		}
+		return nil
+	})
+	if err != nil {
+		r.logger.CtxError(ctx, "[ExpireKeys] pexpire keys error: %v", err)
+	}
+	return err
+}
+
+// CountKeysWithPrefix counts the keys with the prefix on every master
+func (r *RedisCluster) CountKeysWithPrefix(ctx context.Context, keyPrefix string) (int64, error) {
+	var count int64
+	err := r.client.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+		iter := master.Scan(ctx, 0, escapePattern(r.key(keyPrefix))+"*", redisScanCount).Iterator()
+		for iter.Next(ctx) {
+			atomic.AddInt64(&count, 1)
+		}
+		return iter.Err()
+	})
+	if err != nil {
+		r.logger.CtxError(ctx, "[CountKeysWithPrefix] scan keys error: %v", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+func (r *RedisCluster) TryLock(ctx context.Context, key string, token string, ttl int64) (bool, error) {
+	return r.client.SetNX(ctx, r.key(key), token, time.Duration(ttl)*time.Millisecond).Result()
+}
+
+func (r *RedisCluster) Unlock(ctx context.Context, key string, token string) error {
+	return unlockScript.Run(ctx, r.client, []string{r.key(key)}, token).Err()
+}
+
+func (r *RedisCluster) AddToSet(ctx context.Context, key string, members []string, ttl int64) error {
+	if len(members) == 0 {
+		return nil
+	}
+	return addToSetScript.Run(ctx, r.client, []string{r.key(key)}, addToSetArgs(members, ttl)...).Err()
+}
+
+func (r *RedisCluster) SetMembers(ctx context.Context, key string) ([]string, error) {
+	return r.client.SMembers(ctx, r.key(key)).Result()
+}
+
+func (r *RedisCluster) Snapshot() map[string]interface{} {
+	opts := r.client.Options()
+	snapshot := map[string]interface{}{
+		"addrs":     opts.Addrs,
+		"keyPrefix": r.keyPrefix,
+		"tls":       opts.TLSConfig != nil,
+	}
+	if opts.Username != "" {
+		snapshot["username"] = RedactedValue
+	}
+	if opts.Password != "" {
+		snapshot["password"] = RedactedValue
+	}
+	return snapshot
+}
+
+// ListKeysWithPrefix lists the keys with the prefix master by master, up to limit in total
+func (r *RedisCluster) ListKeysWithPrefix(ctx context.Context, keyPrefix string, limit int) ([]string, error) {
+	var mu sync.Mutex
+	keys := make([]string, 0)
+	err := r.client.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+		iter := master.Scan(ctx, 0, escapePattern(r.key(keyPrefix))+"*", redisScanCount).Iterator()
+		for iter.Next(ctx) {
+			mu.Lock()
+			full := len(keys) >= limit
+			if !full {
+				keys = append(keys, strings.TrimPrefix(iter.Val(), r.keyPrefix+":"))
+			}
+			mu.Unlock()
+			if full {
+				break
+			}
+		}
+		return iter.Err()
+	})
+	if err != nil {
+		r.logger.CtxError(ctx, "[ListKeysWithPrefix] scan keys error: %v", err)
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (r *RedisCluster) KeyTTL(ctx context.Context, key string) (int64, error) {
+	return pttl(r.client.PTTL(ctx, r.key(key)))
+}
@@ -0,0 +1,401 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/joykk/gorm-cache/util"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	_ DataStorage = &ShardedRedis{}
+	_ Snapshotter = &ShardedRedis{}
+	_ KeyCounter  = &ShardedRedis{}
+	_ Expirer     = &ShardedRedis{}
+	_ Locker      = &ShardedRedis{}
+	_ SetStore    = &ShardedRedis{}
+	_ KeyLister   = &ShardedRedis{}
+	_ TTLReader   = &ShardedRedis{}
+)
+
+// defaultShardReplicas is the points of each shard on the hash ring by default, see ShardedRedisStoreConfig.Replicas
+const defaultShardReplicas = 160
+
+type ShardedRedisStoreConfig struct {
+	KeyPrefix string // every key is stored under this prefix on its shard, it will be random if not set
+
+	Clients []*redis.Client // the clients of the shards, if not empty Options will be ignored
+	Options []*redis.Options
+
+	// Replicas points of each shard on the hash ring, more spread the keys more evenly. 0 represents 160
+	Replicas int
+
+	// BatchSize see RedisStoreConfig.BatchSize, for each shard
+	BatchSize int
+}
+
+// NewShardedRedis creates a storage spreading its keys over several standalone redis, e.g. for caches too large
+// for one. Keys are routed by consistent hashing on the addresses of the shards, so that adding or removing a
+// shard only moves the keys of its share of the ring, whatever the order of the shards. Batches are split by
+// shard and sent to all of them at once, prefix deletes and counts run on every shard.
+//
+// Keys of a shard that is down fail as they would on a single redis, use ShardStats to tell which one.
+// Versions of config.CacheConfig.PrimaryCacheVersioning span keys of several shards, they aren't kept.
+func NewShardedRedis(config ...*ShardedRedisStoreConfig) *ShardedRedis {
+	if len(config) == 0 {
+		panic("sharded redis config is required")
+	}
+	conf := config[0]
+	if conf.KeyPrefix == "" {
+		conf.KeyPrefix = util.GormCachePrefix + ":" + util.GenInstanceId()
+	}
+	if conf.Replicas <= 0 {
+		conf.Replicas = defaultShardReplicas
+	}
+	clients := conf.Clients
+	if len(clients) == 0 {
+		for _, options := range conf.Options {
+			clients = append(clients, redis.NewClient(options))
+		}
+	}
+	if len(clients) == 0 {
+		panic("sharded redis clients are required")
+	}
+	s := &ShardedRedis{keyPrefix: conf.KeyPrefix}
+	for _, client := range clients {
+		s.shards = append(s.shards, &redisShard{
+			Redis: NewRedis(&RedisStoreConfig{KeyPrefix: conf.KeyPrefix, Client: client, BatchSize: conf.BatchSize}),
+			addr:  client.Options().Addr,
+		})
+	}
+	for idx, shard := range s.shards {
+		for replica := 0; replica < conf.Replicas; replica++ {
+			s.ring = append(s.ring, ringPoint{hash: crc32.ChecksumIEEE([]byte(shard.addr + "#" + strconv.Itoa(replica))), shard: idx})
+		}
+	}
+	sort.Slice(s.ring, func(i, j int) bool { return s.ring[i].hash < s.ring[j].hash })
+	return s
+}
+
+type ShardedRedis struct {
+	shards    []*redisShard
+	ring      []ringPoint // sorted by hash
+	keyPrefix string
+}
+
+// ringPoint a point of a shard on the hash ring, it owns the hashes up to its own
+type ringPoint struct {
+	hash  uint32
+	shard int
+}
+
+// redisShard a shard of a ShardedRedis, counting the operations routed to it
+type redisShard struct {
+	*Redis
+	addr       string
+	operations uint64
+	errors     uint64
+}
+
+// count counts an operation of the shard, misses aren't failures
+func (r *redisShard) count(err error) error {
+	atomic.AddUint64(&r.operations, 1)
+	if err != nil && !errors.Is(err, ErrCacheNotFound) {
+		atomic.AddUint64(&r.errors, 1)
+	}
+	return err
+}
+
+// ShardStats the health and the counts of a shard of a ShardedRedis
+type ShardStats struct {
+	Addr       string  `json:"addr"`
+	Healthy    bool    `json:"healthy"`
+	Error      string  `json:"error,omitempty"` // why the shard didn't answer the ping
+	Operations uint64  `json:"operations"`      // operations routed to the shard
+	Errors     uint64  `json:"errors"`          // operations of the shard that failed, misses aside
+	Share      float64 `json:"share"`           // share of the hash ring, of the keys, routed to the shard
+}
+
+func (s *ShardedRedis) Init(conf *Config) error {
+	for _, shard := range s.shards {
+		if err := shard.Init(conf); err != nil {
+			return fmt.Errorf("init shard %s: %w", shard.addr, err)
+		}
+	}
+	return nil
+}
+
+// shard returns the shard key is stored on, the one of the first point of the ring from its hash on
+func (s *ShardedRedis) shard(key string) *redisShard {
+	hash := crc32.ChecksumIEEE([]byte(key))
+	idx := sort.Search(len(s.ring), func(i int) bool { return s.ring[i].hash >= hash })
+	if idx == len(s.ring) {
+		idx = 0
+	}
+	return s.shards[s.ring[idx].shard]
+}
+
+// group splits the indices of keys by the shard they are stored on
+func (s *ShardedRedis) group(keys []string) map[*redisShard][]int {
+	groups := make(map[*redisShard][]int)
+	for idx, key := range keys {
+		shard := s.shard(key)
+		groups[shard] = append(groups[shard], idx)
+	}
+	return groups
+}
+
+// eachShard runs fn on the shards at once, combining their errors
+func eachShard(shards []*redisShard, fn func(shard *redisShard) error) error {
+	var (
+		mu   sync.Mutex
+		errs error
+		wg   sync.WaitGroup
+	)
+	for _, shard := range shards {
+		wg.Add(1)
+		go func(shard *redisShard) {
+			defer wg.Done()
+			if err := shard.count(fn(shard)); err != nil {
+				mu.Lock()
+				errs = multierror.Append(errs, fmt.Errorf("shard %s: %w", shard.addr, err))
+				mu.Unlock()
+			}
+		}(shard)
+	}
+	wg.Wait()
+	return errs
+}
+
+// eachGroup runs fn on the shards of keys at once with the indices of their keys
+func (s *ShardedRedis) eachGroup(keys []string, fn func(shard *redisShard, indices []int) error) error {
+	groups := s.group(keys)
+	shards := make([]*redisShard, 0, len(groups))
+	for shard := range groups {
+		shards = append(shards, shard)
+	}
+	return eachShard(shards, func(shard *redisShard) error {
+		return fn(shard, groups[shard])
+	})
+}
+
+func pickKeys(keys []string, indices []int) []string {
+	picked := make([]string, 0, len(indices))
+	for _, idx := range indices {
+		picked = append(picked, keys[idx])
+	}
+	return picked
+}
+
+func (s *ShardedRedis) CleanCache(ctx context.Context) error {
+	return eachShard(s.shards, func(shard *redisShard) error {
+		return shard.CleanCache(ctx)
+	})
+}
+
+func (s *ShardedRedis) Ping(ctx context.Context) error {
+	return eachShard(s.shards, func(shard *redisShard) error {
+		return shard.Ping(ctx)
+	})
+}
+
+func (s *ShardedRedis) BatchKeyExist(ctx context.Context, keys []string) (bool, error) {
+	var missing int32
+	err := s.eachGroup(keys, func(shard *redisShard, indices []int) error {
+		exist, err := shard.BatchKeyExist(ctx, pickKeys(keys, indices))
+		if err == nil && !exist {
+			atomic.StoreInt32(&missing, 1)
+		}
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return atomic.LoadInt32(&missing) == 0, nil
+}
+
+func (s *ShardedRedis) KeyExists(ctx context.Context, key string) (bool, error) {
+	shard := s.shard(key)
+	exists, err := shard.KeyExists(ctx, key)
+	return exists, shard.count(err)
+}
+
+func (s *ShardedRedis) GetValue(ctx context.Context, key string) (string, error) {
+	shard := s.shard(key)
+	value, err := shard.GetValue(ctx, key)
+	return value, shard.count(err)
+}
+
+func (s *ShardedRedis) BatchGetValues(ctx context.Context, keys []string) ([]string, error) {
+	values := make([]string, len(keys))
+	err := s.eachGroup(keys, func(shard *redisShard, indices []int) error {
+		shardValues, err := shard.BatchGetValues(ctx, pickKeys(keys, indices))
+		if err != nil {
+			return err
+		}
+		for i, idx := range indices {
+			values[idx] = shardValues[i]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func (s *ShardedRedis) DeleteKeysWithPrefix(ctx context.Context, keyPrefix string) error {
+	return eachShard(s.shards, func(shard *redisShard) error {
+		return shard.DeleteKeysWithPrefix(ctx, keyPrefix)
+	})
+}
+
+func (s *ShardedRedis) DeleteKey(ctx context.Context, key string) error {
+	shard := s.shard(key)
+	return shard.count(shard.DeleteKey(ctx, key))
+}
+
+func (s *ShardedRedis) BatchDeleteKeys(ctx context.Context, keys []string) error {
+	return s.eachGroup(keys, func(shard *redisShard, indices []int) error {
+		return shard.BatchDeleteKeys(ctx, pickKeys(keys, indices))
+	})
+}
+
+func (s *ShardedRedis) BatchSetKeys(ctx context.Context, kvs []util.Kv) error {
+	keys := make([]string, 0, len(kvs))
+	for _, kv := range kvs {
+		keys = append(keys, kv.Key)
+	}
+	return s.eachGroup(keys, func(shard *redisShard, indices []int) error {
+		shardKvs := make([]util.Kv, 0, len(indices))
+		for _, idx := range indices {
+			shardKvs = append(shardKvs, kvs[idx])
+		}
+		return shard.BatchSetKeys(ctx, shardKvs)
+	})
+}
+
+func (s *ShardedRedis) SetKey(ctx context.Context, kv util.Kv) error {
+	shard := s.shard(kv.Key)
+	return shard.count(shard.SetKey(ctx, kv))
+}
+
+func (s *ShardedRedis) ExpireKeys(ctx context.Context, keys []string, ttl int64) error {
+	return s.eachGroup(keys, func(shard *redisShard, indices []int) error {
+		return shard.ExpireKeys(ctx, pickKeys(keys, indices), ttl)
+	})
+}
+
+func (s *ShardedRedis) CountKeysWithPrefix(ctx context.Context, keyPrefix string) (int64, error) {
+	var count int64
+	err := eachShard(s.shards, func(shard *redisShard) error {
+		shardCount, err := shard.CountKeysWithPrefix(ctx, keyPrefix)
+		atomic.AddInt64(&count, shardCount)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (s *ShardedRedis) ListKeysWithPrefix(ctx context.Context, keyPrefix string, limit int) ([]string, error) {
+	var (
+		mu   sync.Mutex
+		keys []string
+	)
+	err := eachShard(s.shards, func(shard *redisShard) error {
+		shardKeys, err := shard.ListKeysWithPrefix(ctx, keyPrefix, limit)
+		mu.Lock()
+		keys = append(keys, shardKeys...)
+		mu.Unlock()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys, nil
+}
+
+func (s *ShardedRedis) KeyTTL(ctx context.Context, key string) (int64, error) {
+	shard := s.shard(key)
+	ttl, err := shard.KeyTTL(ctx, key)
+	return ttl, shard.count(err)
+}
+
+func (s *ShardedRedis) TryLock(ctx context.Context, key string, token string, ttl int64) (bool, error) {
+	shard := s.shard(key)
+	locked, err := shard.TryLock(ctx, key, token, ttl)
+	return locked, shard.count(err)
+}
+
+func (s *ShardedRedis) Unlock(ctx context.Context, key string, token string) error {
+	shard := s.shard(key)
+	return shard.count(shard.Unlock(ctx, key, token))
+}
+
+func (s *ShardedRedis) AddToSet(ctx context.Context, key string, members []string, ttl int64) error {
+	shard := s.shard(key)
+	return shard.count(shard.AddToSet(ctx, key, members, ttl))
+}
+
+func (s *ShardedRedis) SetMembers(ctx context.Context, key string) ([]string, error) {
+	shard := s.shard(key)
+	members, err := shard.SetMembers(ctx, key)
+	return members, shard.count(err)
+}
+
+// ShardStats pings every shard, returning their health along with the counts of their operations, in the order
+// of the shards
+func (s *ShardedRedis) ShardStats(ctx context.Context) []ShardStats {
+	shares := make([]float64, len(s.shards))
+	for idx, point := range s.ring {
+		// a point owns the hashes from the previous one, the first one those from the last one round the ring
+		previous := s.ring[(idx+len(s.ring)-1)%len(s.ring)].hash
+		shares[point.shard] += float64(point.hash-previous) / (1 << 32)
+	}
+	stats := make([]ShardStats, len(s.shards))
+	var wg sync.WaitGroup
+	for idx, shard := range s.shards {
+		wg.Add(1)
+		go func(idx int, shard *redisShard) {
+			defer wg.Done()
+			err := shard.Ping(ctx)
+			stats[idx] = ShardStats{
+				Addr:       shard.addr,
+				Healthy:    err == nil,
+				Operations: atomic.LoadUint64(&shard.operations),
+				Errors:     atomic.LoadUint64(&shard.errors),
+				Share:      shares[idx],
+			}
+			if err != nil {
+				stats[idx].Error = err.Error()
+			}
+		}(idx, shard)
+	}
+	wg.Wait()
+	return stats
+}
+
+func (s *ShardedRedis) Snapshot() map[string]interface{} {
+	shards := make([]map[string]interface{}, 0, len(s.shards))
+	for _, shard := range s.shards {
+		shards = append(shards, shard.Snapshot())
+	}
+	return map[string]interface{}{
+		"keyPrefix": s.keyPrefix,
+		"replicas":  len(s.ring) / len(s.shards),
+		"shards":    shards,
+	}
+}
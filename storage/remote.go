@@ -0,0 +1,360 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joykk/gorm-cache/util"
+)
+
+var (
+	_ DataStorage = &Remote{}
+	_ Snapshotter = &Remote{}
+	_ KeyCounter  = &Remote{}
+	_ KeyLister   = &Remote{}
+	_ TTLReader   = &Remote{}
+	_ Expirer     = &Remote{}
+	_ Versioner   = &Remote{}
+	_ Locker      = &Remote{}
+)
+
+// ErrRemoteUnsupported is returned by Remote when the storage behind the RemoteServer doesn't implement the
+// operation, e.g. TryLock of a memory storage
+var ErrRemoteUnsupported = errors.New("operation not supported by the storage of the remote cache server")
+
+// defaultRemoteTimeout bounds the requests of a Remote without an HTTPClient, on top of the deadline of their ctx
+const defaultRemoteTimeout = 5 * time.Second
+
+// the operations of the remote cache protocol, each served as POST <Endpoint>/<operation>
+const (
+	remotePing         = "ping"
+	remoteClean        = "clean"
+	remoteExists       = "exists"
+	remoteGet          = "get"
+	remoteBatchGet     = "batch-get"
+	remoteSet          = "set"
+	remoteDelete       = "delete"
+	remoteDeletePrefix = "delete-prefix"
+	remoteCount        = "count"
+	remoteList         = "list"
+	remoteTTL          = "ttl"
+	remoteExpire       = "expire"
+	remoteBumpVersion  = "bump-version"
+	remoteSetIfVersion = "set-if-version"
+	remoteLock         = "lock"
+	remoteUnlock       = "unlock"
+)
+
+// remoteRequest the body of a request to a RemoteServer, the fields used depend on the operation
+type remoteRequest struct {
+	Key         string     `json:"key,omitempty"`
+	Keys        []string   `json:"keys,omitempty"`
+	Prefix      string     `json:"prefix,omitempty"`
+	Kvs         []remoteKv `json:"kvs,omitempty"`
+	TTL         int64      `json:"ttl,omitempty"`
+	Limit       int        `json:"limit,omitempty"`
+	Token       string     `json:"token,omitempty"`
+	VersionKeys [][]string `json:"versionKeys,omitempty"`
+	Version     int64      `json:"version,omitempty"`
+}
+
+type remoteKv struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	TTL   int64  `json:"ttl,omitempty"`
+}
+
+// remoteResponse the body of a response of a RemoteServer, Error is set on failure only
+type remoteResponse struct {
+	Error   string   `json:"error,omitempty"`
+	Exists  bool     `json:"exists,omitempty"`
+	Value   string   `json:"value,omitempty"`
+	Values  []string `json:"values,omitempty"`
+	Keys    []string `json:"keys,omitempty"`
+	Count   int64    `json:"count,omitempty"`
+	TTL     int64    `json:"ttl,omitempty"`
+	Version int64    `json:"version,omitempty"`
+	Written []bool   `json:"written,omitempty"`
+	Locked  bool     `json:"locked,omitempty"`
+}
+
+type RemoteStoreConfig struct {
+	// Endpoint base url of the RemoteServer, e.g. http://gormcache:8420
+	Endpoint string
+	// Token sent as a bearer token, the RemoteServerConfig.Token of the server
+	Token string
+
+	// HTTPClient sends the requests, one with a 5s timeout if nil
+	HTTPClient *http.Client
+}
+
+// NewRemote creates a storage on a RemoteServer, so that services share the storage of one gorm-cache server
+// without holding its credentials (e.g. of redis) themselves. Each operation is a round trip to the server,
+// within the deadline of its ctx. Keys written without a ttl get the one passed to Init rather than the one of
+// the server. Operations the storage of the server doesn't implement fail with ErrRemoteUnsupported.
+func NewRemote(config ...*RemoteStoreConfig) *Remote {
+	if len(config) == 0 {
+		panic("remote config is required")
+	}
+	if config[0].Endpoint == "" {
+		panic("remote endpoint is required")
+	}
+	r := &Remote{
+		config:   config[0],
+		endpoint: strings.TrimSuffix(config[0].Endpoint, "/"),
+		client:   config[0].HTTPClient,
+	}
+	if r.client == nil {
+		r.client = &http.Client{Timeout: defaultRemoteTimeout}
+	}
+	return r
+}
+
+type Remote struct {
+	config   *RemoteStoreConfig
+	endpoint string
+	client   *http.Client
+	ttl      int64
+	jitter   float64
+	logger   util.LoggerInterface
+
+	once sync.Once
+}
+
+func (r *Remote) Init(conf *Config) error {
+	r.once.Do(func() {
+		r.ttl = conf.TTL
+		r.jitter = conf.Jitter
+		r.logger = conf.Logger
+		r.logger.SetIsDebug(conf.Debug)
+	})
+	return nil
+}
+
+// call runs the operation on the server, ErrCacheNotFound and ErrRemoteUnsupported are reported as such
+func (r *Remote) call(ctx context.Context, operation string, request *remoteRequest) (*remoteResponse, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.endpoint+"/"+operation, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.config.Token)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	response := &remoteResponse{}
+	if err = json.NewDecoder(resp.Body).Decode(response); err != nil {
+		return nil, fmt.Errorf("remote cache %s: status %d: %w", operation, resp.StatusCode, err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return response, nil
+	case http.StatusNotFound:
+		return nil, ErrCacheNotFound
+	case http.StatusNotImplemented:
+		return nil, ErrRemoteUnsupported
+	default:
+		return nil, fmt.Errorf("remote cache %s: status %d: %s", operation, resp.StatusCode, response.Error)
+	}
+}
+
+// expiration returns the jittered ttl of a key written with ttl, the one passed to Init if 0
+func (r *Remote) expiration(ttl int64) int64 {
+	if ttl <= 0 {
+		ttl = r.ttl
+	}
+	if ttl <= 0 {
+		return 0
+	}
+	return util.JitterInt64(ttl, r.jitter)
+}
+
+func (r *Remote) CleanCache(ctx context.Context) error {
+	if _, err := r.call(ctx, remoteClean, &remoteRequest{}); err != nil {
+		r.logger.CtxError(ctx, "[CleanCache] clean cache error: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (r *Remote) Ping(ctx context.Context) error {
+	_, err := r.call(ctx, remotePing, &remoteRequest{})
+	return err
+}
+
+func (r *Remote) BatchKeyExist(ctx context.Context, keys []string) (bool, error) {
+	response, err := r.call(ctx, remoteExists, &remoteRequest{Keys: keys})
+	if err != nil {
+		r.logger.CtxError(ctx, "[BatchKeyExist] check keys error: %v", err)
+		return false, err
+	}
+	return response.Exists, nil
+}
+
+func (r *Remote) KeyExists(ctx context.Context, key string) (bool, error) {
+	return r.BatchKeyExist(ctx, []string{key})
+}
+
+func (r *Remote) GetValue(ctx context.Context, key string) (string, error) {
+	response, err := r.call(ctx, remoteGet, &remoteRequest{Key: key})
+	if err != nil {
+		if !errors.Is(err, ErrCacheNotFound) {
+			r.logger.CtxError(ctx, "[GetValue] get value error: %v", err)
+		}
+		return "", err
+	}
+	return response.Value, nil
+}
+
+func (r *Remote) BatchGetValues(ctx context.Context, keys []string) ([]string, error) {
+	response, err := r.call(ctx, remoteBatchGet, &remoteRequest{Keys: keys})
+	if err != nil {
+		r.logger.CtxError(ctx, "[BatchGetValues] get values error: %v", err)
+		return nil, err
+	}
+	if len(response.Values) != len(keys) {
+		return nil, fmt.Errorf("remote cache %s: %d values for %d keys", remoteBatchGet, len(response.Values), len(keys))
+	}
+	return response.Values, nil
+}
+
+func (r *Remote) DeleteKeysWithPrefix(ctx context.Context, keyPrefix string) error {
+	if _, err := r.call(ctx, remoteDeletePrefix, &remoteRequest{Prefix: keyPrefix}); err != nil {
+		r.logger.CtxError(ctx, "[DeleteKeysWithPrefix] delete keys with prefix %s error: %v", keyPrefix, err)
+		return err
+	}
+	return nil
+}
+
+func (r *Remote) DeleteKey(ctx context.Context, key string) error {
+	return r.BatchDeleteKeys(ctx, []string{key})
+}
+
+func (r *Remote) BatchDeleteKeys(ctx context.Context, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if _, err := r.call(ctx, remoteDelete, &remoteRequest{Keys: keys}); err != nil {
+		r.logger.CtxError(ctx, "[BatchDeleteKeys] delete keys error: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (r *Remote) BatchSetKeys(ctx context.Context, kvs []util.Kv) error {
+	if len(kvs) == 0 {
+		return nil
+	}
+	if _, err := r.call(ctx, remoteSet, &remoteRequest{Kvs: r.remoteKvs(kvs)}); err != nil {
+		r.logger.CtxError(ctx, "[BatchSetKeys] set keys error: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (r *Remote) SetKey(ctx context.Context, kv util.Kv) error {
+	return r.BatchSetKeys(ctx, []util.Kv{kv})
+}
+
+func (r *Remote) remoteKvs(kvs []util.Kv) []remoteKv {
+	sent := make([]remoteKv, len(kvs))
+	for idx, kv := range kvs {
+		sent[idx] = remoteKv{Key: kv.Key, Value: kv.Value, TTL: r.expiration(kv.TTL)}
+	}
+	return sent
+}
+
+func (r *Remote) CountKeysWithPrefix(ctx context.Context, keyPrefix string) (int64, error) {
+	response, err := r.call(ctx, remoteCount, &remoteRequest{Prefix: keyPrefix})
+	if err != nil {
+		return 0, err
+	}
+	return response.Count, nil
+}
+
+func (r *Remote) ListKeysWithPrefix(ctx context.Context, keyPrefix string, limit int) ([]string, error) {
+	response, err := r.call(ctx, remoteList, &remoteRequest{Prefix: keyPrefix, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+	return response.Keys, nil
+}
+
+func (r *Remote) KeyTTL(ctx context.Context, key string) (int64, error) {
+	response, err := r.call(ctx, remoteTTL, &remoteRequest{Key: key})
+	if err != nil {
+		return 0, err
+	}
+	return response.TTL, nil
+}
+
+func (r *Remote) ExpireKeys(ctx context.Context, keys []string, ttl int64) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if _, err := r.call(ctx, remoteExpire, &remoteRequest{Keys: keys, TTL: r.expiration(ttl)}); err != nil {
+		r.logger.CtxError(ctx, "[ExpireKeys] expire keys error: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (r *Remote) BumpVersion(ctx context.Context, sequenceKey string, versionKeys []string, ttl int64) (int64, error) {
+	response, err := r.call(ctx, remoteBumpVersion, &remoteRequest{Key: sequenceKey, Keys: versionKeys, TTL: r.expiration(ttl)})
+	if err != nil {
+		r.logger.CtxError(ctx, "[BumpVersion] bump version of %s error: %v", sequenceKey, err)
+		return 0, err
+	}
+	return response.Version, nil
+}
+
+func (r *Remote) SetKeysIfVersion(ctx context.Context, kvs []util.Kv, versionKeys [][]string, version int64) ([]bool, error) {
+	response, err := r.call(ctx, remoteSetIfVersion, &remoteRequest{Kvs: r.remoteKvs(kvs), VersionKeys: versionKeys, Version: version})
+	if err != nil {
+		r.logger.CtxError(ctx, "[SetKeysIfVersion] set keys error: %v", err)
+		return nil, err
+	}
+	if len(response.Written) != len(kvs) {
+		return nil, fmt.Errorf("remote cache %s: %d results for %d keys", remoteSetIfVersion, len(response.Written), len(kvs))
+	}
+	return response.Written, nil
+}
+
+func (r *Remote) TryLock(ctx context.Context, key string, token string, ttl int64) (bool, error) {
+	response, err := r.call(ctx, remoteLock, &remoteRequest{Key: key, Token: token, TTL: ttl})
+	if err != nil {
+		return false, err
+	}
+	return response.Locked, nil
+}
+
+func (r *Remote) Unlock(ctx context.Context, key string, token string) error {
+	_, err := r.call(ctx, remoteUnlock, &remoteRequest{Key: key, Token: token})
+	return err
+}
+
+func (r *Remote) Snapshot() map[string]interface{} {
+	snapshot := map[string]interface{}{
+		"endpoint": r.endpoint,
+	}
+	if r.config.Token != "" {
+		snapshot["token"] = RedactedValue
+	}
+	return snapshot
+}
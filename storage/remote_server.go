@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/joykk/gorm-cache/util"
+)
+
+var _ http.Handler = &RemoteServer{}
+
+// defaultRemoteMaxBodyBytes bounds the requests a RemoteServer reads
+const defaultRemoteMaxBodyBytes = 32 << 20
+
+type RemoteServerConfig struct {
+	// Storage the requests of the clients are served from, e.g. redis
+	Storage DataStorage
+
+	// Token the clients must send as a bearer token, see RemoteStoreConfig.Token. Requests are not
+	// authenticated if empty, the server must then only be reachable by trusted services
+	Token string
+
+	// MaxBodyBytes largest request read, 0 represents 32MB
+	MaxBodyBytes int64
+}
+
+// NewRemoteServer creates the http server of Remote clients, serving the operations of a storage so that the
+// services using it don't hold its credentials. Init must be called before serving, it initializes the storage.
+// Mount it on a listener of its own, e.g. http.ListenAndServe(":8420", server), see cmd/gormcache-server
+func NewRemoteServer(config ...*RemoteServerConfig) *RemoteServer {
+	if len(config) == 0 {
+		panic("remote server config is required")
+	}
+	if config[0].Storage == nil {
+		panic("remote server storage is required")
+	}
+	if config[0].MaxBodyBytes <= 0 {
+		config[0].MaxBodyBytes = defaultRemoteMaxBodyBytes
+	}
+	return &RemoteServer{config: config[0]}
+}
+
+type RemoteServer struct {
+	config *RemoteServerConfig
+	logger util.LoggerInterface
+}
+
+// Init initializes the storage served, keys written without a ttl by the clients expire by conf.TTL
+func (s *RemoteServer) Init(conf *Config) error {
+	s.logger = conf.Logger
+	return s.config.Storage.Init(conf)
+}
+
+func (s *RemoteServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeRemoteResponse(w, http.StatusMethodNotAllowed, &remoteResponse{Error: "method not allowed"})
+		return
+	}
+	if !s.authorized(req) {
+		writeRemoteResponse(w, http.StatusUnauthorized, &remoteResponse{Error: "unauthorized"})
+		return
+	}
+	request := &remoteRequest{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, s.config.MaxBodyBytes)).Decode(request); err != nil {
+		writeRemoteResponse(w, http.StatusBadRequest, &remoteResponse{Error: err.Error()})
+		return
+	}
+
+	ctx := req.Context()
+	operation := strings.TrimPrefix(req.URL.Path, "/")
+	response, err := s.serve(ctx, operation, request)
+	switch {
+	case err == nil:
+		writeRemoteResponse(w, http.StatusOK, response)
+	case errors.Is(err, ErrCacheNotFound):
+		writeRemoteResponse(w, http.StatusNotFound, &remoteResponse{Error: err.Error()})
+	case errors.Is(err, ErrRemoteUnsupported):
+		writeRemoteResponse(w, http.StatusNotImplemented, &remoteResponse{Error: err.Error()})
+	case errors.Is(err, errRemoteUnknownOperation):
+		writeRemoteResponse(w, http.StatusBadRequest, &remoteResponse{Error: err.Error()})
+	default:
+		s.logger.CtxError(ctx, "[RemoteServer] %s error: %v", operation, err)
+		writeRemoteResponse(w, http.StatusInternalServerError, &remoteResponse{Error: err.Error()})
+	}
+}
+
+// authorized reports whether the request carries the token of the server
+func (s *RemoteServer) authorized(req *http.Request) bool {
+	if s.config.Token == "" {
+		return true
+	}
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.config.Token)) == 1
+}
+
+var errRemoteUnknownOperation = errors.New("unknown operation")
+
+// serve runs the operation of a request on the storage
+func (s *RemoteServer) serve(ctx context.Context, operation string, request *remoteRequest) (*remoteResponse, error) {
+	store := s.config.Storage
+	response := &remoteResponse{}
+	var err error
+	switch operation {
+	case remotePing:
+		err = store.Ping(ctx)
+	case remoteClean:
+		err = store.CleanCache(ctx)
+	case remoteExists:
+		response.Exists, err = store.BatchKeyExist(ctx, request.Keys)
+	case remoteGet:
+		response.Value, err = store.GetValue(ctx, request.Key)
+	case remoteBatchGet:
+		response.Values, err = store.BatchGetValues(ctx, request.Keys)
+	case remoteSet:
+		err = store.BatchSetKeys(ctx, storedKvs(request.Kvs))
+	case remoteDelete:
+		err = store.BatchDeleteKeys(ctx, request.Keys)
+	case remoteDeletePrefix:
+		err = store.DeleteKeysWithPrefix(ctx, request.Prefix)
+	case remoteCount:
+		counter, ok := store.(KeyCounter)
+		if !ok {
+			return nil, ErrRemoteUnsupported
+		}
+		response.Count, err = counter.CountKeysWithPrefix(ctx, request.Prefix)
+	case remoteList:
+		lister, ok := store.(KeyLister)
+		if !ok {
+			return nil, ErrRemoteUnsupported
+		}
+		response.Keys, err = lister.ListKeysWithPrefix(ctx, request.Prefix, request.Limit)
+	case remoteTTL:
+		reader, ok := store.(TTLReader)
+		if !ok {
+			return nil, ErrRemoteUnsupported
+		}
+		response.TTL, err = reader.KeyTTL(ctx, request.Key)
+	case remoteExpire:
+		expirer, ok := store.(Expirer)
+		if !ok {
+			return nil, ErrRemoteUnsupported
+		}
+		err = expirer.ExpireKeys(ctx, request.Keys, request.TTL)
+	case remoteBumpVersion:
+		versioner, ok := store.(Versioner)
+		if !ok {
+			return nil, ErrRemoteUnsupported
+		}
+		response.Version, err = versioner.BumpVersion(ctx, request.Key, request.Keys, request.TTL)
+	case remoteSetIfVersion:
+		versioner, ok := store.(Versioner)
+		if !ok {
+			return nil, ErrRemoteUnsupported
+		}
+		response.Written, err = versioner.SetKeysIfVersion(ctx, storedKvs(request.Kvs), request.VersionKeys, request.Version)
+	case remoteLock:
+		locker, ok := store.(Locker)
+		if !ok {
+			return nil, ErrRemoteUnsupported
+		}
+		response.Locked, err = locker.TryLock(ctx, request.Key, request.Token, request.TTL)
+	case remoteUnlock:
+		locker, ok := store.(Locker)
+		if !ok {
+			return nil, ErrRemoteUnsupported
+		}
+		err = locker.Unlock(ctx, request.Key, request.Token)
+	default:
+		return nil, errRemoteUnknownOperation
+	}
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func storedKvs(kvs []remoteKv) []util.Kv {
+	stored := make([]util.Kv, len(kvs))
+	for idx, kv := range kvs {
+		stored[idx] = util.Kv{Key: kv.Key, Value: kv.Value, TTL: kv.TTL}
+	}
+	return stored
+}
+
+func writeRemoteResponse(w http.ResponseWriter, status int, response *remoteResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,272 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joykk/gorm-cache/util"
+)
+
+var (
+	_ DataStorage = &Ristretto{}
+	_ Snapshotter = &Ristretto{}
+	_ Expirer     = &Ristretto{}
+	_ TTLReader   = &Ristretto{}
+	_ Versioner   = &Ristretto{}
+)
+
+// RistrettoCache is the subset of an in-process cache the Ristretto store is built on, *ristretto.Cache of
+// github.com/dgraph-io/ristretto implements it as it is. Sets may be buffered until Wait returns
+type RistrettoCache interface {
+	Get(key interface{}) (interface{}, bool)
+	SetWithTTL(key interface{}, value interface{}, cost int64, ttl time.Duration) bool
+	Del(key interface{})
+	Clear()
+	Wait()
+}
+
+// RistrettoTTLCache is implemented by caches telling the ttl left of their keys, as *ristretto.Cache does,
+// it is needed by Ristretto.KeyTTL
+type RistrettoTTLCache interface {
+	// GetTTL returns the ttl left of key, 0 if it never expires, and whether it is cached
+	GetTTL(key interface{}) (time.Duration, bool)
+}
+
+type RistrettoStoreConfig struct {
+	// Cache the cache holding the entries. Each entry costs the bytes of its key and value plus an estimated
+	// overhead of 128 bytes, so its MaxCost is the bytes the store may take
+	Cache RistrettoCache
+
+	// PrefixSegments number of ":" separated segments of a key making up the prefix it is deleted by,
+	// 0 represents 4, the layout of the keys of gorm-cache
+	PrefixSegments int
+}
+
+// NewRistretto creates an in-process store on ristretto, whose admission policy keeps the most frequently
+// used entries and whose sharded design holds up under many concurrent readers. Ristretto can't list its
+// keys, so prefix deletes are emulated with generations as by NewMemcached, kept in the process here: keys of
+// an older generation are never read again and are evicted by ristretto or their ttl. Sets wait for the
+// buffers of ristretto to be applied, so a key set is read back right away.
+func NewRistretto(config ...*RistrettoStoreConfig) *Ristretto {
+	if len(config) == 0 {
+		panic("ristretto config is required")
+	}
+	if config[0].Cache == nil {
+		panic("ristretto cache is required")
+	}
+	if config[0].PrefixSegments <= 0 {
+		config[0].PrefixSegments = defaultMemcachedPrefixSegments
+	}
+	return &Ristretto{config: config[0], generations: make(map[string]uint64)}
+}
+
+type Ristretto struct {
+	config *RistrettoStoreConfig
+	ttl    int64
+	jitter float64
+
+	mu              sync.RWMutex
+	storeGeneration uint64
+	generations     map[string]uint64 // generation of each prefix deleted so far, keyed by prefix
+
+	versionMu sync.Mutex // serializes BumpVersion and SetKeysIfVersion
+
+	once sync.Once
+}
+
+func (r *Ristretto) Init(conf *Config) error {
+	r.once.Do(func() {
+		r.ttl = conf.TTL
+		r.jitter = conf.Jitter
+	})
+	return nil
+}
+
+// key returns the ristretto key key is stored under in the current generations
+func (r *Ristretto) key(key string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	versioned := strconv.FormatUint(r.storeGeneration, 10)
+	for _, prefix := range keyPrefixes(key, r.config.PrefixSegments) {
+		versioned += ":" + strconv.FormatUint(r.generations[prefix], 10)
+	}
+	return versioned + ":" + key
+}
+
+func (r *Ristretto) get(key string) (string, bool) {
+	value, ok := r.config.Cache.Get(r.key(key))
+	if !ok {
+		return "", false
+	}
+	return value.(string), true
+}
+
+func (r *Ristretto) set(kv util.Kv, ttl int64) {
+	r.config.Cache.SetWithTTL(r.key(kv.Key), kv.Value, entryBytes(kv.Key, kv.Value), r.expiration(ttl))
+}
+
+// expiration returns the jittered ttl of a key cached for ttl ms, 0 uses the store's ttl and never expires
+// without one
+func (r *Ristretto) expiration(ttl int64) time.Duration {
+	if ttl <= 0 {
+		ttl = r.ttl
+	}
+	if ttl <= 0 {
+		return 0
+	}
+	return time.Duration(util.JitterInt64(ttl, r.jitter)) * time.Millisecond
+}
+
+// CleanCache bumps the generation of the store and clears the cache
+func (r *Ristretto) CleanCache(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.storeGeneration++
+	r.config.Cache.Clear()
+	return nil
+}
+
+// Ping always succeeds, the store lives in the process
+func (r *Ristretto) Ping(context.Context) error {
+	return nil
+}
+
+func (r *Ristretto) BatchKeyExist(ctx context.Context, keys []string) (bool, error) {
+	for _, key := range keys {
+		if _, ok := r.get(key); !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (r *Ristretto) KeyExists(ctx context.Context, key string) (bool, error) {
+	_, ok := r.get(key)
+	return ok, nil
+}
+
+func (r *Ristretto) GetValue(ctx context.Context, key string) (string, error) {
+	value, ok := r.get(key)
+	if !ok {
+		return "", ErrCacheNotFound
+	}
+	return value, nil
+}
+
+func (r *Ristretto) BatchGetValues(ctx context.Context, keys []string) ([]string, error) {
+	values := make([]string, len(keys))
+	for idx, key := range keys {
+		values[idx], _ = r.get(key)
+	}
+	return values, nil
+}
+
+// DeleteKeysWithPrefix bumps the generation of the prefix, or of the whole store for prefixes keys aren't
+// stored by
+func (r *Ristretto) DeleteKeysWithPrefix(ctx context.Context, keyPrefix string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keyPrefix = strings.TrimSuffix(keyPrefix, ":")
+	if keyPrefix != "" && util.ContainString(keyPrefix, keyPrefixes(keyPrefix+":", r.config.PrefixSegments)) {
+		r.generations[keyPrefix]++
+	} else {
+		r.storeGeneration++
+	}
+	return nil
+}
+
+func (r *Ristretto) DeleteKey(ctx context.Context, key string) error {
+	r.config.Cache.Del(r.key(key))
+	return nil
+}
+
+func (r *Ristretto) BatchDeleteKeys(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		r.config.Cache.Del(r.key(key))
+	}
+	return nil
+}
+
+func (r *Ristretto) BatchSetKeys(ctx context.Context, kvs []util.Kv) error {
+	for _, kv := range kvs {
+		r.set(kv, kv.TTL)
+	}
+	r.config.Cache.Wait()
+	return nil
+}
+
+func (r *Ristretto) SetKey(ctx context.Context, kv util.Kv) error {
+	return r.BatchSetKeys(ctx, []util.Kv{kv})
+}
+
+// ExpireKeys sets the keys again with a new expiration, ristretto can't change the expiration of a key in place
+func (r *Ristretto) ExpireKeys(ctx context.Context, keys []string, ttl int64) error {
+	for _, key := range keys {
+		if value, ok := r.get(key); ok {
+			r.set(util.Kv{Key: key, Value: value}, ttl)
+		}
+	}
+	r.config.Cache.Wait()
+	return nil
+}
+
+// KeyTTL returns the ms left before key expires, it fails if the Cache doesn't implement RistrettoTTLCache
+func (r *Ristretto) KeyTTL(ctx context.Context, key string) (int64, error) {
+	ttlCache, ok := r.config.Cache.(RistrettoTTLCache)
+	if !ok {
+		return 0, fmt.Errorf("%T can't tell the ttl of its keys", r.config.Cache)
+	}
+	ttl, found := ttlCache.GetTTL(r.key(key))
+	if !found {
+		return 0, ErrCacheNotFound
+	}
+	if ttl <= 0 {
+		return 0, nil
+	}
+	if ttl < time.Millisecond {
+		// expires within the current ms
+		return 1, nil
+	}
+	return ttl.Milliseconds(), nil
+}
+
+func (r *Ristretto) BumpVersion(ctx context.Context, sequenceKey string, versionKeys []string, ttl int64) (int64, error) {
+	r.versionMu.Lock()
+	defer r.versionMu.Unlock()
+	value, _ := r.get(sequenceKey)
+	version := parseVersion(value) + 1
+	value = strconv.FormatInt(version, 10)
+	r.set(util.Kv{Key: sequenceKey, Value: value}, memSequenceTTL.Milliseconds())
+	for _, key := range versionKeys {
+		r.set(util.Kv{Key: key, Value: value}, ttl)
+	}
+	r.config.Cache.Wait()
+	return version, nil
+}
+
+func (r *Ristretto) SetKeysIfVersion(ctx context.Context, kvs []util.Kv, versionKeys [][]string, version int64) ([]bool, error) {
+	r.versionMu.Lock()
+	defer r.versionMu.Unlock()
+	written := make([]bool, len(kvs))
+	for idx, kv := range kvs {
+		written[idx] = !versionAbove(versionKeys[idx], version, func(key string) string {
+			value, _ := r.get(key)
+			return value
+		})
+		if written[idx] {
+			r.set(kv, kv.TTL)
+		}
+	}
+	r.config.Cache.Wait()
+	return written, nil
+}
+
+func (r *Ristretto) Snapshot() map[string]interface{} {
+	return map[string]interface{}{
+		"prefixSegments": r.config.PrefixSegments,
+	}
+}
@@ -0,0 +1,278 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/joykk/gorm-cache/util"
+)
+
+var (
+	_ DataStorage     = &Tiered{}
+	_ Snapshotter     = &Tiered{}
+	_ Expirer         = &Tiered{}
+	_ KeyCounter      = &Tiered{}
+	_ KeyLister       = &Tiered{}
+	_ TTLReader       = &Tiered{}
+	_ EvictionCounter = &Tiered{}
+	_ Closer          = &Tiered{}
+)
+
+// DefaultTieredLocalTTL ttl in ms of the local tier of a Tiered storage when LocalTTL isn't set
+const DefaultTieredLocalTTL = 1000
+
+type TieredStoreConfig struct {
+	Local  DataStorage // in-process tier serving reads first, a memory store if not set
+	Remote DataStorage // the store shared by all instances, serving the reads the local tier misses
+
+	// LocalTTL ttl in ms of the keys in the local tier, DefaultTieredLocalTTL if not above 0.
+	// Keys with a shorter ttl keep it
+	LocalTTL int64
+}
+
+// NewTiered creates a two tier storage saving the round trip to a remote store on hot keys.
+// Reads are served by the local tier first, the keys it misses are read from the remote one and
+// promoted to the local tier. Writes and deletes go through to both tiers.
+//
+// Another instance writing or invalidating a key only clears its own local tier, so this one may serve
+// the key as it was for up to LocalTTL. Setting a Config.Broadcaster has every instance run the
+// invalidations on its own local tier as well, leaving only the window of the broadcast delay.
+func NewTiered(config ...*TieredStoreConfig) *Tiered {
+	if len(config) == 0 {
+		panic("tiered config is required")
+	}
+	if config[0].Remote == nil {
+		panic("tiered remote storage is required")
+	}
+	if config[0].Local == nil {
+		config[0].Local = NewMem(DefaultMemStoreConfig)
+	}
+	if config[0].LocalTTL <= 0 {
+		config[0].LocalTTL = DefaultTieredLocalTTL
+	}
+	return &Tiered{config: config[0]}
+}
+
+type Tiered struct {
+	config *TieredStoreConfig
+	logger util.LoggerInterface
+
+	once sync.Once
+}
+
+func (t *Tiered) Init(conf *Config) error {
+	var err error
+	t.once.Do(func() {
+		t.logger = conf.Logger
+		if err = t.config.Remote.Init(conf); err != nil {
+			return
+		}
+		localConf := *conf
+		localConf.TTL = t.config.LocalTTL
+		err = t.config.Local.Init(&localConf)
+	})
+	return err
+}
+
+// localTTL returns the ttl in ms of a key written with ttl to the local tier
+func (t *Tiered) localTTL(ttl int64) int64 {
+	if ttl > 0 && ttl < t.config.LocalTTL {
+		return ttl
+	}
+	return t.config.LocalTTL
+}
+
+func (t *Tiered) localKvs(kvs []util.Kv) []util.Kv {
+	localKvs := make([]util.Kv, 0, len(kvs))
+	for _, kv := range kvs {
+		localKvs = append(localKvs, util.Kv{Key: kv.Key, Value: kv.Value, TTL: t.localTTL(kv.TTL)})
+	}
+	return localKvs
+}
+
+func (t *Tiered) CleanCache(ctx context.Context) error {
+	if err := t.config.Remote.CleanCache(ctx); err != nil {
+		return err
+	}
+	return t.config.Local.CleanCache(ctx)
+}
+
+// Ping checks the remote storage, the local layer lives in the process
+func (t *Tiered) Ping(ctx context.Context) error {
+	return t.config.Remote.Ping(ctx)
+}
+
+func (t *Tiered) BatchKeyExist(ctx context.Context, keys []string) (bool, error) {
+	if exists, err := t.config.Local.BatchKeyExist(ctx, keys); err == nil && exists {
+		return true, nil
+	}
+	return t.config.Remote.BatchKeyExist(ctx, keys)
+}
+
+func (t *Tiered) KeyExists(ctx context.Context, key string) (bool, error) {
+	if exists, err := t.config.Local.KeyExists(ctx, key); err == nil && exists {
+		return true, nil
+	}
+	return t.config.Remote.KeyExists(ctx, key)
+}
+
+func (t *Tiered) GetValue(ctx context.Context, key string) (string, error) {
+	value, err := t.config.Local.GetValue(ctx, key)
+	if err == nil {
+		return value, nil
+	}
+	if !errors.Is(err, ErrCacheNotFound) {
+		t.logger.CtxError(ctx, "[GetValue] get local key %s error: %v", key, err)
+	}
+	value, err = t.config.Remote.GetValue(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	t.promote(ctx, []util.Kv{{Key: key, Value: value}})
+	return value, nil
+}
+
+func (t *Tiered) BatchGetValues(ctx context.Context, keys []string) ([]string, error) {
+	values, err := t.config.Local.BatchGetValues(ctx, keys)
+	if err != nil {
+		t.logger.CtxError(ctx, "[BatchGetValues] get local keys error: %v", err)
+		values = make([]string, len(keys))
+	}
+	missed := make([]string, 0, len(keys))
+	for idx, value := range values {
+		if value == "" {
+			missed = append(missed, keys[idx])
+		}
+	}
+	if len(missed) == 0 {
+		return values, nil
+	}
+
+	remoteValues, err := t.config.Remote.BatchGetValues(ctx, missed)
+	if err != nil {
+		return nil, err
+	}
+	kvs := make([]util.Kv, 0, len(missed))
+	next := 0
+	for idx, value := range values {
+		if value != "" {
+			continue
+		}
+		values[idx] = remoteValues[next]
+		if remoteValues[next] != "" {
+			kvs = append(kvs, util.Kv{Key: keys[idx], Value: remoteValues[next]})
+		}
+		next++
+	}
+	t.promote(ctx, kvs)
+	return values, nil
+}
+
+// promote caches the values read from the remote tier in the local one
+func (t *Tiered) promote(ctx context.Context, kvs []util.Kv) {
+	if len(kvs) == 0 {
+		return
+	}
+	if err := t.config.Local.BatchSetKeys(ctx, t.localKvs(kvs)); err != nil {
+		t.logger.CtxError(ctx, "[promote] set local keys error: %v", err)
+	}
+}
+
+// ExpireKeys refreshes the expiry in the tiers that can refresh it, capped by LocalTTL in the local one
+func (t *Tiered) ExpireKeys(ctx context.Context, keys []string, ttl int64) error {
+	if expirer, ok := t.config.Remote.(Expirer); ok {
+		if err := expirer.ExpireKeys(ctx, keys, ttl); err != nil {
+			return err
+		}
+	}
+	if expirer, ok := t.config.Local.(Expirer); ok {
+		return expirer.ExpireKeys(ctx, keys, t.localTTL(ttl))
+	}
+	return nil
+}
+
+func (t *Tiered) DeleteKeysWithPrefix(ctx context.Context, keyPrefix string) error {
+	if err := t.config.Remote.DeleteKeysWithPrefix(ctx, keyPrefix); err != nil {
+		return err
+	}
+	return t.config.Local.DeleteKeysWithPrefix(ctx, keyPrefix)
+}
+
+func (t *Tiered) DeleteKey(ctx context.Context, key string) error {
+	if err := t.config.Remote.DeleteKey(ctx, key); err != nil {
+		return err
+	}
+	return t.config.Local.DeleteKey(ctx, key)
+}
+
+func (t *Tiered) BatchDeleteKeys(ctx context.Context, keys []string) error {
+	if err := t.config.Remote.BatchDeleteKeys(ctx, keys); err != nil {
+		return err
+	}
+	return t.config.Local.BatchDeleteKeys(ctx, keys)
+}
+
+func (t *Tiered) BatchSetKeys(ctx context.Context, kvs []util.Kv) error {
+	if err := t.config.Remote.BatchSetKeys(ctx, kvs); err != nil {
+		return err
+	}
+	return t.config.Local.BatchSetKeys(ctx, t.localKvs(kvs))
+}
+
+func (t *Tiered) SetKey(ctx context.Context, kv util.Kv) error {
+	if err := t.config.Remote.SetKey(ctx, kv); err != nil {
+		return err
+	}
+	return t.config.Local.SetKey(ctx, util.Kv{Key: kv.Key, Value: kv.Value, TTL: t.localTTL(kv.TTL)})
+}
+
+// CountKeysWithPrefix counts the keys of the remote tier, the local one only holds a part of them
+func (t *Tiered) CountKeysWithPrefix(ctx context.Context, keyPrefix string) (int64, error) {
+	counter, ok := t.config.Remote.(KeyCounter)
+	if !ok {
+		return 0, fmt.Errorf("%T can't count keys", t.config.Remote)
+	}
+	return counter.CountKeysWithPrefix(ctx, keyPrefix)
+}
+
+// EvictionCount returns the evictions of both tiers
+func (t *Tiered) EvictionCount() uint64 {
+	return evictionCount(t.config.Remote) + evictionCount(t.config.Local)
+}
+
+// Close closes both tiers, returning the first error
+func (t *Tiered) Close() error {
+	remoteErr := closeStorage(t.config.Remote)
+	if err := closeStorage(t.config.Local); remoteErr == nil {
+		return err
+	}
+	return remoteErr
+}
+
+func (t *Tiered) Snapshot() map[string]interface{} {
+	return map[string]interface{}{
+		"localTTL": t.config.LocalTTL,
+		"remote":   describeStorage(t.config.Remote),
+		"local":    describeStorage(t.config.Local),
+	}
+}
+
+// ListKeysWithPrefix lists the keys of the remote tier, the local one only holds a part of them
+func (t *Tiered) ListKeysWithPrefix(ctx context.Context, keyPrefix string, limit int) ([]string, error) {
+	lister, ok := t.config.Remote.(KeyLister)
+	if !ok {
+		return nil, fmt.Errorf("%T can't list keys", t.config.Remote)
+	}
+	return lister.ListKeysWithPrefix(ctx, keyPrefix, limit)
+}
+
+// KeyTTL reads the expiry of the remote tier, local copies may expire earlier
+func (t *Tiered) KeyTTL(ctx context.Context, key string) (int64, error) {
+	reader, ok := t.config.Remote.(TTLReader)
+	if !ok {
+		return 0, fmt.Errorf("%T can't read ttls", t.config.Remote)
+	}
+	return reader.KeyTTL(ctx, key)
+}
@@ -0,0 +1,85 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAdminHandler(t *testing.T) {
+	Convey("test the admin handler inspects and purges the cache", t, func() {
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelAll,
+			CacheStorage: storage.NewMemSync(nil),
+			CacheTTL:     5000,
+		})
+		So(err, ShouldBeNil)
+		gc := asGorm2Cache(c)
+		server := httptest.NewServer(gc.AdminHandler())
+		defer server.Close()
+
+		request := func(method string, path string, query url.Values, dest interface{}) int {
+			req, err := http.NewRequest(method, server.URL+path+"?"+query.Encode(), nil)
+			So(err, ShouldBeNil)
+			resp, err := http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			if dest != nil {
+				So(json.NewDecoder(resp.Body).Decode(dest), ShouldBeNil)
+			}
+			return resp.StatusCode
+		}
+		get := func() {
+			So(db.Where("id = ?", 7).First(&TestModel{}).Error, ShouldBeNil)
+		}
+		get()
+		get()
+
+		stats := map[string]interface{}{}
+		So(request(http.MethodGet, "/stats", nil, &stats), ShouldEqual, http.StatusOK)
+		So(stats["hitCount"], ShouldEqual, 1)
+
+		listed := struct{ Keys []string }{}
+		So(request(http.MethodGet, "/keys", url.Values{"table": {TestModelTableName}}, &listed), ShouldEqual, http.StatusOK)
+		So(len(listed.Keys), ShouldEqual, 1)
+		key := listed.Keys[0]
+
+		inspected := struct {
+			Value string
+			TTL   *int64
+		}{}
+		So(request(http.MethodGet, "/key", url.Values{"key": {key}}, &inspected), ShouldEqual, http.StatusOK)
+		So(inspected.Value, ShouldContainSubstring, "7")
+		So(*inspected.TTL, ShouldBeGreaterThan, 0)
+
+		Convey("keys of other instances are rejected", func() {
+			So(request(http.MethodGet, "/key", url.Values{"key": {"other:1"}}, nil), ShouldEqual, http.StatusBadRequest)
+			So(request(http.MethodGet, "/keys", url.Values{"prefix": {"other:"}}, nil), ShouldEqual, http.StatusBadRequest)
+			So(request(http.MethodGet, "/purge", url.Values{"key": {key}}, nil), ShouldEqual, http.StatusMethodNotAllowed)
+		})
+
+		Convey("purge a key", func() {
+			So(request(http.MethodPost, "/purge", url.Values{"key": {key}}, nil), ShouldEqual, http.StatusNoContent)
+			So(request(http.MethodGet, "/key", url.Values{"key": {key}}, nil), ShouldEqual, http.StatusNotFound)
+			get()
+			So(gc.HitCounts().Primary, ShouldEqual, 1)
+		})
+
+		Convey("purge a table", func() {
+			models := make([]TestModel, 0)
+			So(db.Where("value1 < ?", 3).Find(&models).Error, ShouldBeNil)
+			So(request(http.MethodGet, "/keys", url.Values{"table": {TestModelTableName}, "kind": {"search"}}, &listed), ShouldEqual, http.StatusOK)
+			So(len(listed.Keys), ShouldEqual, 2)
+
+			So(request(http.MethodDelete, "/purge", url.Values{"table": {TestModelTableName}}, nil), ShouldEqual, http.StatusNoContent)
+			So(request(http.MethodGet, "/keys", url.Values{}, &listed), ShouldEqual, http.StatusOK)
+			So(listed.Keys, ShouldBeEmpty)
+		})
+	})
+}
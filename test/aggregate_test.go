@@ -0,0 +1,62 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAggregateCache(t *testing.T) {
+	Convey("test aggregate queries are cached for AggregateCacheTTL and invalidated by writes to any row", t, func() {
+		defer originalDB.Model(&TestModel{ID: 151}).Update("value2", 151)
+
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:                config.CacheLevelOnlySearch,
+			CacheStorage:              storage.NewMemSync(nil),
+			CacheTTL:                  5000,
+			AggregateCacheTTL:         100,
+			PreciseSearchInvalidation: true,
+			InvalidateWhenUpdate:      true,
+		})
+		So(err, ShouldBeNil)
+
+		count := func() {
+			var n int64
+			So(db.Model(&TestModel{}).Where("value1 BETWEEN ? AND ?", 151, 155).Count(&n).Error, ShouldBeNil)
+			So(n, ShouldEqual, 5)
+		}
+		find := func() {
+			models := make([]TestModel, 0)
+			So(db.Where("value1 BETWEEN ? AND ?", 151, 155).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 5)
+		}
+		sum := func() int64 {
+			model := TestModel{}
+			So(db.Select("MAX(id) AS id, SUM(value2) AS value2").Where("value1 BETWEEN ? AND ?", 151, 153).
+				Find(&model).Error, ShouldBeNil)
+			So(model.ID, ShouldEqual, 153)
+			return model.Value2
+		}
+
+		count()
+		find()
+		count()
+		find()
+		So(c.HitCount(), ShouldEqual, 2)
+
+		time.Sleep(150 * time.Millisecond)
+		count()
+		find()
+		So(c.HitCount(), ShouldEqual, 3)
+
+		So(sum(), ShouldEqual, 456)
+		So(sum(), ShouldEqual, 456)
+		So(c.HitCount(), ShouldEqual, 4)
+		// the result holds the id of another row than the one written, it depends on all of them
+		So(db.Model(&TestModel{ID: 151}).Update("value2", 1000).Error, ShouldBeNil)
+		So(sum(), ShouldEqual, 1305)
+	})
+}
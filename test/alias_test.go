@@ -0,0 +1,52 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type aliasedRow struct {
+	ID     int64
+	Value8 int64
+}
+
+func TestAliasedTableInvalidation(t *testing.T) {
+	Convey("test write to the underlying table invalidates aliased table queries", t, func() {
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:           config.CacheLevelOnlySearch,
+			CacheStorage:         storage.NewGcache(gcache.New(1000)),
+			InvalidateWhenUpdate: true,
+			CacheTTL:             5000,
+		})
+		So(err, ShouldBeNil)
+
+		original := &TestModel{}
+		So(originalDB.Where("id = ?", 42).First(original).Error, ShouldBeNil)
+		defer originalDB.Model(&TestModel{}).Where("id = ?", 42).Update("value8", original.Value8)
+
+		queryAliased := func() []aliasedRow {
+			rows := make([]aliasedRow, 0)
+			err := db.Table("gorm_cache_model AS m").Select("m.id, m.value8").
+				Where("m.id BETWEEN ? AND ?", 41, 43).Order("m.id").Find(&rows).Error
+			So(err, ShouldBeNil)
+			So(len(rows), ShouldEqual, 3)
+			return rows
+		}
+
+		queryAliased()
+		So(c.HitCount(), ShouldEqual, 0)
+		queryAliased()
+		So(c.HitCount(), ShouldEqual, 1)
+
+		err = db.Model(&TestModel{}).Where("id = ?", 42).Update("value8", original.Value8+1000).Error
+		So(err, ShouldBeNil)
+
+		rows := queryAliased()
+		So(c.HitCount(), ShouldEqual, 1)
+		So(rows[1].Value8, ShouldEqual, original.Value8+1000)
+	})
+}
@@ -0,0 +1,96 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAssociationWrites(t *testing.T) {
+	confs := map[string]*config.CacheConfig{
+		"prefix delete": {},
+		"precise":       {PreciseSearchInvalidation: true},
+		"version":       {SearchInvalidation: config.SearchInvalidationVersion},
+	}
+	for name, conf := range confs {
+		conf.CacheLevel = config.CacheLevelAll
+		conf.CacheStorage = storage.NewMemSync(nil)
+		conf.CacheTTL = 5000
+		conf.InvalidateWhenUpdate = true
+
+		Convey("test association writes to a join table invalidate the queries preloading or joining it, "+name, t, func() {
+			So(originalDB.AutoMigrate(&UserModel{}, &RoleModel{}), ShouldBeNil)
+			defer originalDB.Migrator().DropTable(&UserModel{}, &RoleModel{}, UserRolesTableName)
+			So(originalDB.Create(&UserModel{ID: 1, Name: "u", Roles: []RoleModel{{ID: 1, Name: "r1"}, {ID: 2, Name: "r2"}}}).Error, ShouldBeNil)
+			So(originalDB.Create(&RoleModel{ID: 3, Name: "r3"}).Error, ShouldBeNil)
+
+			c, db, err := newCacheDB(conf)
+			So(err, ShouldBeNil)
+			user := UserModel{ID: 1}
+			roles := func(count int) {
+				users := make([]UserModel, 0)
+				So(db.Preload("Roles").Where("name = ?", "u").Find(&users).Error, ShouldBeNil)
+				So(len(users[0].Roles), ShouldEqual, count)
+				models := make([]RoleModel, 0)
+				So(db.Model(&user).Association("Roles").Find(&models), ShouldBeNil)
+				So(len(models), ShouldEqual, count)
+				So(db.Model(&user).Association("Roles").Count(), ShouldEqual, count)
+			}
+			roles(2)
+			roles(2)
+			So(c.TableStats(UserModelTableName).Search.HitCount, ShouldEqual, 1)
+
+			So(db.Model(&user).Association("Roles").Append(&RoleModel{ID: 3, Name: "r3"}), ShouldBeNil)
+			roles(3)
+			So(db.Model(&user).Association("Roles").Replace(&RoleModel{ID: 1, Name: "r1"}), ShouldBeNil)
+			roles(1)
+			roles(1)
+			So(db.Model(&user).Association("Roles").Delete(&RoleModel{ID: 1}), ShouldBeNil)
+			roles(0)
+			So(db.Model(&user).Association("Roles").Append(&RoleModel{ID: 2, Name: "r2"}), ShouldBeNil)
+			roles(1)
+			So(db.Model(&user).Association("Roles").Clear(), ShouldBeNil)
+			roles(0)
+		})
+	}
+
+	Convey("test association writes to a has many table invalidate its search and primary cache", t, func() {
+		So(originalDB.AutoMigrate(&AuthorModel{}, &BookModel{}), ShouldBeNil)
+		defer originalDB.Migrator().DropTable(&AuthorModel{}, &BookModel{})
+		So(originalDB.Create(&AuthorModel{ID: 1, Name: "a", Books: []BookModel{{ID: 1, Title: "b1"}, {ID: 2, Title: "b2"}}}).Error, ShouldBeNil)
+
+		_, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:           config.CacheLevelAll,
+			CacheStorage:         storage.NewMemSync(nil),
+			CacheTTL:             5000,
+			InvalidateWhenUpdate: true,
+		})
+		So(err, ShouldBeNil)
+		author := AuthorModel{ID: 1}
+		books := func(count int) {
+			authors := make([]AuthorModel, 0)
+			So(db.Preload("Books").Where("name = ?", "a").Find(&authors).Error, ShouldBeNil)
+			So(len(authors[0].Books), ShouldEqual, count)
+			So(db.Model(&author).Association("Books").Count(), ShouldEqual, count)
+		}
+		bookAuthor := func() int64 {
+			book := BookModel{}
+			So(db.Where("id = ?", 2).First(&book).Error, ShouldBeNil)
+			return book.AuthorID
+		}
+		books(2)
+		So(bookAuthor(), ShouldEqual, 1)
+
+		So(db.Model(&author).Association("Books").Replace(&BookModel{ID: 1, Title: "b1"}), ShouldBeNil)
+		books(1)
+		So(bookAuthor(), ShouldEqual, 0)
+		So(db.Model(&author).Association("Books").Append(&BookModel{ID: 2, Title: "b2"}), ShouldBeNil)
+		books(2)
+		So(bookAuthor(), ShouldEqual, 1)
+		So(db.Model(&author).Association("Books").Clear(), ShouldBeNil)
+		books(0)
+		So(bookAuthor(), ShouldEqual, 0)
+	})
+}
@@ -0,0 +1,59 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// gatedStorage holds every key write until its gate is closed
+type gatedStorage struct {
+	*storage.MemSync
+	gate chan struct{}
+}
+
+func (s *gatedStorage) SetKey(ctx context.Context, kv util.Kv) error {
+	<-s.gate
+	return s.MemSync.SetKey(ctx, kv)
+}
+
+func TestAsyncWrite(t *testing.T) {
+	Convey("test async writes are queued to workers, dropped when the queue is full and flushed on close", t, func() {
+		store := &gatedStorage{MemSync: storage.NewMemSync(nil), gate: make(chan struct{})}
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:          config.CacheLevelOnlySearch,
+			CacheStorage:        store,
+			CacheTTL:            5000,
+			AsyncWrite:          true,
+			AsyncWriteWorkers:   1,
+			AsyncWriteQueueSize: 1,
+		})
+		So(err, ShouldBeNil)
+		gorm2Cache := asGorm2Cache(c)
+
+		// the single worker holds at most one write and the queue another, the third is dropped
+		for value := 31; value <= 33; value++ {
+			models := make([]TestModel, 0)
+			So(db.Where("value1 = ?", value).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 1)
+		}
+		So(gorm2Cache.DroppedWriteCount(), ShouldBeGreaterThanOrEqualTo, 1)
+		So(gorm2Cache.WriteQueueDepth(), ShouldEqual, 1)
+
+		close(store.gate)
+		So(gorm2Cache.Close(), ShouldBeNil)
+		So(gorm2Cache.WriteQueueDepth(), ShouldEqual, 0)
+		keys, err := store.CountKeysWithPrefix(context.Background(), util.GenSearchCachePrefix(gorm2Cache.InstanceId, TestModelTableName))
+		So(err, ShouldBeNil)
+		So(keys+int64(gorm2Cache.DroppedWriteCount()), ShouldEqual, 3)
+
+		// writes after close are dropped as well
+		dropped := gorm2Cache.DroppedWriteCount()
+		So(db.Where("value1 = ?", 34).Find(&[]TestModel{}).Error, ShouldBeNil)
+		So(gorm2Cache.DroppedWriteCount(), ShouldEqual, dropped+1)
+	})
+}
@@ -0,0 +1,74 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBackfillPrimaryFromSearch(t *testing.T) {
+	Convey("test search results backfill the primary cache of a string primary key model", t, func() {
+		So(originalDB.AutoMigrate(&StringPKModel{}), ShouldBeNil)
+		defer originalDB.Migrator().DropTable(&StringPKModel{})
+
+		score := int64(7)
+		rows := []StringPKModel{
+			{Code: "alpha", Name: "team-a", Score: &score},
+			{Code: "beta", Name: "team-a"},
+			{Code: "gamma", Name: "team-b"},
+		}
+		So(originalDB.Create(&rows).Error, ShouldBeNil)
+
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelAll,
+			CacheStorage: storage.NewGcache(gcache.New(1000)),
+			CacheTTL:     5000,
+		})
+		So(err, ShouldBeNil)
+		gc := asGorm2Cache(c)
+
+		Convey("model results are keyed by the schema primary field", func() {
+			models := make([]StringPKModel, 0)
+			So(db.Where("name = ?", "team-a").Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 2)
+
+			exists, err := gc.BatchPrimaryKeyExists(context.Background(), StringPKModelTableName, []string{"alpha", "beta"})
+			So(err, ShouldBeNil)
+			So(exists, ShouldBeTrue)
+
+			model := &StringPKModel{}
+			So(db.Where("code = ?", "alpha").First(model).Error, ShouldBeNil)
+			So(c.HitCount(), ShouldEqual, 1)
+			So(model.Name, ShouldEqual, "team-a")
+			So(*model.Score, ShouldEqual, 7)
+		})
+
+		Convey("map results are keyed by the declared key column", func() {
+			results := make([]map[string]interface{}, 0)
+			So(cache.WithKeyColumn(db, "code").Model(&StringPKModel{}).Where("name = ?", "team-b").Find(&results).Error, ShouldBeNil)
+			So(len(results), ShouldEqual, 1)
+
+			model := &StringPKModel{}
+			So(db.Where("code = ?", "gamma").First(model).Error, ShouldBeNil)
+			So(c.HitCount(), ShouldEqual, 1)
+			So(model.Name, ShouldEqual, "team-b")
+			So(model.Score, ShouldBeNil)
+		})
+
+		Convey("partial map rows are not backfilled", func() {
+			results := make([]map[string]interface{}, 0)
+			err := cache.WithKeyColumn(db, "code").Model(&StringPKModel{}).Select("code").Where("name = ?", "team-b").Find(&results).Error
+			So(err, ShouldBeNil)
+			So(len(results), ShouldEqual, 1)
+
+			exists, err := gc.BatchPrimaryKeyExists(context.Background(), StringPKModelTableName, []string{"gamma"})
+			So(err, ShouldBeNil)
+			So(exists, ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,141 @@
+package test
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeBadger serves storage.BadgerClient from a map, recording the ttl of each entry without expiring it
+type fakeBadger struct {
+	mu      sync.Mutex
+	entries map[string]storage.BadgerEntry
+	deletes int
+}
+
+func newFakeBadger() *fakeBadger {
+	return &fakeBadger{entries: make(map[string]storage.BadgerEntry)}
+}
+
+func (f *fakeBadger) Get(keys []string) (map[string][]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	values := make(map[string][]byte)
+	for _, key := range keys {
+		if entry, ok := f.entries[key]; ok {
+			values[key] = entry.Value
+		}
+	}
+	return values, nil
+}
+
+func (f *fakeBadger) Set(entries []storage.BadgerEntry) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, entry := range entries {
+		f.entries[entry.Key] = entry
+	}
+	return nil
+}
+
+func (f *fakeBadger) Delete(keys []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deletes++
+	for _, key := range keys {
+		delete(f.entries, key)
+	}
+	return nil
+}
+
+func (f *fakeBadger) IterateKeys(prefix string, fn func(key string) bool) error {
+	f.mu.Lock()
+	keys := make([]string, 0)
+	for key := range f.entries {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	f.mu.Unlock()
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !fn(key) {
+			break
+		}
+	}
+	return nil
+}
+
+func TestBadgerStorage(t *testing.T) {
+	Convey("test the badger storage", t, func() {
+		client := newFakeBadger()
+		So(client.Set([]storage.BadgerEntry{{Key: "foreign:key", Value: []byte("kept")}}), ShouldBeNil)
+
+		store := storage.NewBadger(&storage.BadgerStoreConfig{KeyPrefix: "app", Client: client, DeleteBatch: 2})
+		So(store.Init(&storage.Config{TTL: 5000, Logger: &util.DefaultLogger{}}), ShouldBeNil)
+		ctx := context.Background()
+
+		Convey("keys are read, written with their ttl and deleted", func() {
+			So(store.BatchSetKeys(ctx, []util.Kv{{Key: "k1", Value: "v1"}, {Key: "k2", Value: "v2", TTL: 200}}), ShouldBeNil)
+			// expirations are jittered by 10% by default
+			So(client.entries["app:k1"].TTL, ShouldBeBetweenOrEqual, 4500*time.Millisecond, 5500*time.Millisecond)
+			So(client.entries["app:k2"].TTL, ShouldBeBetweenOrEqual, 180*time.Millisecond, 220*time.Millisecond)
+
+			values, err := store.BatchGetValues(ctx, []string{"k1", "k3", "k2"})
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []string{"v1", "", "v2"})
+
+			So(store.DeleteKey(ctx, "k1"), ShouldBeNil)
+			_, err = store.GetValue(ctx, "k1")
+			So(err, ShouldEqual, storage.ErrCacheNotFound)
+		})
+
+		Convey("prefix deletes delete the keys of the prefix in batches", func() {
+			So(store.BatchSetKeys(ctx, []util.Kv{
+				{Key: "s:t1:0", Value: "v"}, {Key: "s:t1:1", Value: "v"}, {Key: "s:t1:2", Value: "v"}, {Key: "s:t2:0", Value: "v"},
+			}), ShouldBeNil)
+			count, err := store.CountKeysWithPrefix(ctx, "s:t1")
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 3)
+
+			So(store.DeleteKeysWithPrefix(ctx, "s:t1"), ShouldBeNil)
+			So(client.deletes, ShouldEqual, 2)
+			values, err := store.BatchGetValues(ctx, []string{"s:t1:0", "s:t1:2", "s:t2:0"})
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []string{"", "", "v"})
+
+			Convey("and clean cache the keys under the prefix of the store only", func() {
+				So(store.CleanCache(ctx), ShouldBeNil)
+				So(len(client.entries), ShouldEqual, 1)
+				So(string(client.entries["foreign:key"].Value), ShouldEqual, "kept")
+			})
+		})
+
+		Convey("the cache of an instance is read again after a restart", func() {
+			query := func() cache.Cache {
+				c, db, err := newCacheDB(&config.CacheConfig{
+					CacheLevel:   config.CacheLevelOnlySearch,
+					CacheStorage: storage.NewBadger(&storage.BadgerStoreConfig{Client: client}),
+					CacheTTL:     5000,
+					InstanceId:   "persistent",
+				})
+				So(err, ShouldBeNil)
+				models := make([]TestModel, 0)
+				So(db.Where("value1 = ?", 187).Find(&models).Error, ShouldBeNil)
+				So(len(models), ShouldEqual, 1)
+				return c
+			}
+			So(query().HitCount(), ShouldEqual, 0)
+			So(query().HitCount(), ShouldEqual, 1)
+		})
+	})
+}
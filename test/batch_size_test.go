@@ -0,0 +1,74 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// batchRecordingStorage records the number of keys of each batch call
+type batchRecordingStorage struct {
+	*storage.MemSync
+	mu      sync.Mutex
+	batches map[string][]int
+}
+
+func (s *batchRecordingStorage) record(op string, keys int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches[op] = append(s.batches[op], keys)
+}
+
+func (s *batchRecordingStorage) BatchGetValues(ctx context.Context, keys []string) ([]string, error) {
+	s.record("get", len(keys))
+	return s.MemSync.BatchGetValues(ctx, keys)
+}
+
+func (s *batchRecordingStorage) BatchSetKeys(ctx context.Context, kvs []util.Kv) error {
+	s.record("set", len(kvs))
+	return s.MemSync.BatchSetKeys(ctx, kvs)
+}
+
+func (s *batchRecordingStorage) BatchDeleteKeys(ctx context.Context, keys []string) error {
+	s.record("delete", len(keys))
+	return s.MemSync.BatchDeleteKeys(ctx, keys)
+}
+
+func TestBatchSize(t *testing.T) {
+	Convey("test primary keys are read, written and invalidated in chunks of BatchSize", t, func() {
+		store := &batchRecordingStorage{MemSync: storage.NewMemSync(nil), batches: make(map[string][]int)}
+		c, _, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelAll,
+			CacheStorage: store,
+			CacheTTL:     5000,
+			BatchSize:    3,
+		})
+		So(err, ShouldBeNil)
+		gorm2Cache := asGorm2Cache(c)
+		ctx := context.Background()
+
+		kvs := make([]util.Kv, 0, 7)
+		for i := 1; i <= 7; i++ {
+			kvs = append(kvs, util.Kv{Key: fmt.Sprint(i), Value: fmt.Sprintf("v%d", i)})
+		}
+		So(gorm2Cache.BatchSetPrimaryKeyCache(ctx, "batch", kvs), ShouldBeNil)
+		So(store.batches["set"], ShouldResemble, []int{3, 3, 1})
+
+		values, err := gorm2Cache.BatchGetPrimaryCache(ctx, "batch", []string{"7", "6", "8", "5", "4", "3", "2", "1"})
+		So(err, ShouldBeNil)
+		So(values, ShouldResemble, []string{"v7", "v6", "", "v5", "v4", "v3", "v2", "v1"})
+		So(store.batches["get"], ShouldResemble, []int{3, 3, 2})
+
+		So(gorm2Cache.BatchInvalidatePrimaryCache(ctx, "batch", []string{"1", "2", "3", "4"}), ShouldBeNil)
+		So(store.batches["delete"], ShouldResemble, []int{3, 1})
+		values, err = gorm2Cache.BatchGetPrimaryCache(ctx, "batch", []string{"3", "4", "5"})
+		So(err, ShouldBeNil)
+		So(values, ShouldResemble, []string{"", "", "v5"})
+	})
+}
@@ -0,0 +1,144 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/glebarez/sqlite"
+	"github.com/joykk/gorm-cache/bench"
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/redis/go-redis/v9"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openBenchDB returns a func opening a database of its own for the bench package, which closes each one it opens
+func openBenchDB(tb testing.TB) func() (*gorm.DB, error) {
+	f, err := os.CreateTemp("", "gormCacheBench.*.db")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	_ = f.Close()
+	tb.Cleanup(func() {
+		_ = os.Remove(f.Name())
+	})
+	return func() (*gorm.DB, error) {
+		return gorm.Open(sqlite.Open(f.Name()), &gorm.Config{Logger: logger.Discard})
+	}
+}
+
+func TestBenchRun(t *testing.T) {
+	Convey("test the bench package runs every backend, result size and concurrency", t, func() {
+		mr := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		defer client.Close()
+
+		conf := &bench.Config{
+			Open:        openBenchDB(t),
+			Backends:    []bench.Backend{bench.Uncached(), bench.Memory(), bench.Redis(client)},
+			Rows:        300,
+			ResultSizes: []int{1, 20},
+			Concurrency: []int{1, 4},
+			Queries:     200,
+			Keys:        20,
+			Seed:        7,
+		}
+		results, err := bench.Run(context.Background(), conf)
+		So(err, ShouldBeNil)
+		So(len(results), ShouldEqual, 12)
+		for _, result := range results {
+			So(result.Errors, ShouldEqual, 0)
+			So(result.Queries, ShouldEqual, 200)
+			So(result.P50, ShouldBeLessThanOrEqualTo, result.P99)
+			if result.Backend == "uncached" {
+				So(result.HitRate, ShouldEqual, 0)
+			} else {
+				// 20 keys missed about once each
+				So(result.HitRate, ShouldBeGreaterThanOrEqualTo, 0.8)
+			}
+		}
+
+		var out bytes.Buffer
+		So(bench.WriteResults(&out, results), ShouldBeNil)
+		So(out.String(), ShouldContainSubstring, "p50 speedup")
+		So(out.String(), ShouldContainSubstring, "redis")
+
+		// the table is dropped
+		db, err := conf.Open()
+		So(err, ShouldBeNil)
+		So(db.Migrator().HasTable(bench.TableName), ShouldBeFalse)
+	})
+
+	Convey("test the queries of a seed are reproducible and stay within the table", t, func() {
+		starts := bench.Starts(7, 1000, 50, 300, 20)
+		So(starts, ShouldResemble, bench.Starts(7, 1000, 50, 300, 20))
+		So(starts, ShouldNotResemble, bench.Starts(8, 1000, 50, 300, 20))
+		distinct := make(map[int64]bool)
+		for _, start := range starts {
+			So(start, ShouldBeBetweenOrEqual, 1, 281)
+			distinct[start] = true
+		}
+		So(len(distinct), ShouldBeLessThanOrEqualTo, 50)
+	})
+}
+
+func BenchmarkQuery(b *testing.B) {
+	open := openBenchDB(b)
+	seedDB, err := open()
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err = bench.Prepare(seedDB, 10000, 256); err != nil {
+		b.Fatal(err)
+	}
+	mr := miniredis.RunT(b)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	for _, backend := range []bench.Backend{bench.Uncached(), bench.Memory(), bench.Redis(client)} {
+		for _, size := range []int{1, 10, 100} {
+			b.Run(fmt.Sprintf("%s/rows=%d", backend.Name, size), func(b *testing.B) {
+				db, err := open()
+				if err != nil {
+					b.Fatal(err)
+				}
+				if backend.NewStorage != nil {
+					c, err := newBenchCache(backend)
+					if err != nil {
+						b.Fatal(err)
+					}
+					if err = db.Use(c); err != nil {
+						b.Fatal(err)
+					}
+				}
+				starts := bench.Starts(1, 10000, 1000, 10000, size)
+				b.ReportAllocs()
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					i := 0
+					for pb.Next() {
+						if err := bench.Query(db, starts[i%len(starts)], size); err != nil {
+							b.Error(err)
+							return
+						}
+						i++
+					}
+				})
+			})
+		}
+	}
+}
+
+func newBenchCache(backend bench.Backend) (cache.Cache, error) {
+	return cache.NewGorm2Cache(&config.CacheConfig{
+		CacheLevel:   config.CacheLevelAll,
+		CacheStorage: backend.NewStorage(),
+		CacheTTL:     60000,
+	})
+}
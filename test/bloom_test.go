@@ -0,0 +1,105 @@
+package test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestBloomFilter(t *testing.T) {
+	Convey("test the bloom filter answers lookups of primary keys not in the table", t, func() {
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:           config.CacheLevelAll,
+			CacheStorage:         storage.NewMemSync(nil),
+			CacheTTL:             5000,
+			InvalidateWhenUpdate: true,
+			BloomFilter: config.BloomFilter{
+				Tables:        []string{TestModelTableName},
+				ExpectedItems: 1000,
+			},
+		})
+		So(err, ShouldBeNil)
+		gc := asGorm2Cache(c)
+		defer originalDB.Where("id >= ?", 100000).Delete(&TestModel{})
+
+		lookup := func(id int64) error {
+			return db.Where("id = ?", id).First(&TestModel{}).Error
+		}
+		// waits for the filter to be built, its lookups reaching the database meanwhile
+		rejected := func(id int64) bool {
+			before := gc.BloomFilterRejectCount()
+			deadline := time.Now().Add(2 * time.Second)
+			for {
+				So(errors.Is(lookup(id), gorm.ErrRecordNotFound), ShouldBeTrue)
+				if gc.BloomFilterRejectCount() > before || time.Now().After(deadline) {
+					return gc.BloomFilterRejectCount() > before
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+		So(rejected(100001), ShouldBeTrue)
+
+		model := TestModel{}
+		So(db.Where("id = ?", 65).First(&model).Error, ShouldBeNil)
+		So(model.Value1, ShouldEqual, 65)
+
+		models := []TestModel{{ID: 1}}
+		So(db.Where("id IN ?", []int64{100002, 100003}).Find(&models).Error, ShouldBeNil)
+		So(len(models), ShouldEqual, 0)
+		So(db.Where("id IN ?", []int64{65, 100004}).Find(&models).Error, ShouldBeNil)
+		So(len(models), ShouldEqual, 1)
+		rejects := gc.BloomFilterRejectCount()
+		So(rejects, ShouldEqual, 2)
+
+		tx := cache.Explain(db).Where("id = ?", 100005).First(&TestModel{})
+		So(errors.Is(tx.Error, gorm.ErrRecordNotFound), ShouldBeTrue)
+		So(cache.GetExplain(tx).Decisions, ShouldContain, "bloom filter tells keys [100005] don't exist")
+
+		// rows created through gorm are added to the filter
+		So(db.Create(&TestModel{ID: 100006, Value1: 100006}).Error, ShouldBeNil)
+		So(lookup(100006), ShouldBeNil)
+
+		// rows inserted by raw statements drop it until it is built again
+		So(db.Exec("INSERT INTO "+TestModelTableName+" (id, value1) VALUES (?, ?)", 100007, 100007).Error, ShouldBeNil)
+		So(lookup(100007), ShouldBeNil)
+		So(rejected(100008), ShouldBeTrue)
+		So(lookup(100007), ShouldBeNil)
+		So(lookup(100006), ShouldBeNil)
+	})
+
+	Convey("test the bloom filter is rebuilt after its interval, dropping the keys deleted", t, func() {
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelOnlyPrimary,
+			CacheStorage: storage.NewMemSync(nil),
+			CacheTTL:     5000,
+			BloomFilter: config.BloomFilter{
+				Tables:          []string{"gorm_cache_*"},
+				RebuildInterval: 100,
+			},
+		})
+		So(err, ShouldBeNil)
+		gc := asGorm2Cache(c)
+		So(originalDB.Create(&TestModel{ID: 100010, Value1: 100010}).Error, ShouldBeNil)
+		defer originalDB.Where("id >= ?", 100000).Delete(&TestModel{})
+
+		So(errors.Is(db.Where("id = ?", 100011).First(&TestModel{}).Error, gorm.ErrRecordNotFound), ShouldBeTrue)
+		So(originalDB.Where("id = ?", 100010).Delete(&TestModel{}).Error, ShouldBeNil)
+
+		// the filter of the first build still holds the key deleted, the next one doesn't
+		deadline := time.Now().Add(2 * time.Second)
+		for gc.BloomFilterRejectCount() == 0 && time.Now().Before(deadline) {
+			_ = db.Where("id = ?", 100010).First(&TestModel{}).Error
+			time.Sleep(10 * time.Millisecond)
+		}
+		So(gc.BloomFilterRejectCount(), ShouldBeGreaterThan, 0)
+
+		gc.ResetCounter(cache.CounterBloomFilterRejects)
+		So(gc.BloomFilterRejectCount(), ShouldEqual, 0)
+	})
+}
@@ -0,0 +1,124 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/redis/go-redis/v9"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestBroadcastInvalidation(t *testing.T) {
+	Convey("test invalidations of an instance are run by the others sharing the storage", t, func() {
+		mr := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		newInstance := func(broadcast bool) (cache.Cache, *gorm.DB) {
+			conf := &config.CacheConfig{
+				CacheLevel:           config.CacheLevelAll,
+				CacheStorage:         storage.NewRedis(&storage.RedisStoreConfig{KeyPrefix: "shared", Client: client}),
+				CacheTTL:             5000,
+				InvalidateWhenUpdate: true,
+			}
+			if broadcast {
+				conf.Broadcaster = storage.NewRedisBroadcaster(&storage.RedisBroadcasterConfig{Client: client})
+			}
+			c, db, err := newCacheDB(conf)
+			So(err, ShouldBeNil)
+			return c, db
+		}
+		a, dbA := newInstance(true)
+		defer asGorm2Cache(a).Close()
+		b, dbB := newInstance(true)
+		defer asGorm2Cache(b).Close()
+		single, dbSingle := newInstance(false)
+
+		// returns value9 of row 107 read by search and by primary key
+		query := func(db *gorm.DB) (string, string) {
+			models := make([]TestModel, 0)
+			So(db.Where("value1 BETWEEN ? AND ?", 106, 108).Order("id").Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 3)
+			model := TestModel{}
+			So(db.Where("id = ?", 107).First(&model).Error, ShouldBeNil)
+			return models[1].Value9, model.Value9
+		}
+		// the search caches the primary cache of its rows, the primary key query hits from the start
+		for i := 0; i < 2; i++ {
+			query(dbB)
+			query(dbSingle)
+		}
+		So(b.HitCount(), ShouldEqual, 3)
+		So(single.HitCount(), ShouldEqual, 3)
+
+		So(dbA.Model(&TestModel{}).Where("id = ?", 107).Update("value9", "broadcast").Error, ShouldBeNil)
+		defer originalDB.Model(&TestModel{}).Where("id = ?", 107).Update("value9", "107")
+
+		deadline := time.Now().Add(time.Second)
+		searched, primary := query(dbB)
+		for (searched != "broadcast" || primary != "broadcast") && time.Now().Before(deadline) {
+			time.Sleep(20 * time.Millisecond)
+			searched, primary = query(dbB)
+		}
+		So(searched, ShouldEqual, "broadcast")
+		So(primary, ShouldEqual, "broadcast")
+
+		// an instance without a broadcaster keeps serving its own entries
+		searched, primary = query(dbSingle)
+		So(searched, ShouldEqual, "107")
+		So(primary, ShouldEqual, "107")
+
+		// the instance broadcasting an invalidation doesn't run it twice
+		So(asGorm2Cache(a).InvalidationCount(), ShouldEqual, 2)
+	})
+}
+
+func TestNatsBroadcastInvalidation(t *testing.T) {
+	Convey("test invalidations are broadcast over nats to instances caching in their own memory", t, func() {
+		ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1})
+		So(err, ShouldBeNil)
+		go ns.Start()
+		defer ns.Shutdown()
+		So(ns.ReadyForConnections(5*time.Second), ShouldBeTrue)
+
+		newInstance := func() (cache.Cache, *gorm.DB) {
+			c, db, err := newCacheDB(&config.CacheConfig{
+				CacheLevel:           config.CacheLevelAll,
+				CacheStorage:         storage.NewMemSync(nil),
+				CacheTTL:             60000,
+				InvalidateWhenUpdate: true,
+				Broadcaster:          storage.NewNatsBroadcaster(&storage.NatsBroadcasterConfig{URL: ns.ClientURL()}),
+			})
+			So(err, ShouldBeNil)
+			return c, db
+		}
+		a, dbA := newInstance()
+		defer asGorm2Cache(a).Close()
+		b, dbB := newInstance()
+		defer asGorm2Cache(b).Close()
+
+		query := func() string {
+			model := TestModel{}
+			So(dbB.Where("id = ?", 200).First(&model).Error, ShouldBeNil)
+			return model.Value9
+		}
+		So(query(), ShouldEqual, "200")
+		So(query(), ShouldEqual, "200")
+		So(b.HitCount(), ShouldEqual, 1)
+
+		So(dbA.Model(&TestModel{}).Where("id = ?", 200).Update("value9", "nats").Error, ShouldBeNil)
+		defer originalDB.Model(&TestModel{}).Where("id = ?", 200).Update("value9", "200")
+
+		deadline := time.Now().Add(time.Second)
+		value := query()
+		for value != "nats" && time.Now().Before(deadline) {
+			time.Sleep(20 * time.Millisecond)
+			value = query()
+		}
+		So(value, ShouldEqual, "nats")
+	})
+}
@@ -0,0 +1,82 @@
+package test
+
+import (
+	"context"
+	"database/sql"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bluele/gcache"
+	"github.com/glebarez/sqlite"
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+// countingConnPool counts the statements reaching the database
+type countingConnPool struct {
+	gorm.ConnPool
+	count int64
+}
+
+func (p *countingConnPool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	atomic.AddInt64(&p.count, 1)
+	return p.ConnPool.QueryContext(ctx, query, args...)
+}
+
+func (p *countingConnPool) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	atomic.AddInt64(&p.count, 1)
+	return p.ConnPool.QueryRowContext(ctx, query, args...)
+}
+
+func (p *countingConnPool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	atomic.AddInt64(&p.count, 1)
+	return p.ConnPool.ExecContext(ctx, query, args...)
+}
+
+func TestCacheOnly(t *testing.T) {
+	Convey("test cache only queries never touch the database", t, func() {
+		pool := &countingConnPool{ConnPool: originalDB.ConnPool}
+		db, err := gorm.Open(&sqlite.Dialector{Conn: pool}, &gorm.Config{})
+		So(err, ShouldBeNil)
+		c, err := cache.NewGorm2Cache(&config.CacheConfig{
+			CacheLevel:         config.CacheLevelAll,
+			CacheStorage:       storage.NewGcache(gcache.New(1000)),
+			CacheTTL:           5000,
+			EnableSingleFlight: true,
+		})
+		So(err, ShouldBeNil)
+		So(db.Use(c), ShouldBeNil)
+		atomic.StoreInt64(&pool.count, 0)
+
+		Convey("cold key misses without a database query", func() {
+			models := make([]TestModel, 0)
+			err := cache.CacheOnly(db).Where("value1 BETWEEN ? AND ?", 1, 5).Find(&models).Error
+			So(err, ShouldEqual, util.ErrCacheOnlyMiss)
+			So(len(models), ShouldEqual, 0)
+
+			model := &TestModel{}
+			err = cache.CacheOnly(db).Where("id = ?", 3).First(model).Error
+			So(err, ShouldEqual, util.ErrCacheOnlyMiss)
+			So(model.ID, ShouldEqual, 0)
+
+			So(atomic.LoadInt64(&pool.count), ShouldEqual, 0)
+		})
+
+		Convey("warm key is served from the cache", func() {
+			models := make([]TestModel, 0)
+			So(db.Where("value1 BETWEEN ? AND ?", 1, 5).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 5)
+			queried := atomic.LoadInt64(&pool.count)
+
+			cached := make([]TestModel, 0)
+			err := cache.CacheOnly(db).Where("value1 BETWEEN ? AND ?", 1, 5).Find(&cached).Error
+			So(err, ShouldBeNil)
+			So(cached, ShouldResemble, models)
+			So(atomic.LoadInt64(&pool.count), ShouldEqual, queried)
+		})
+	})
+}
@@ -0,0 +1,112 @@
+package test
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestChunkedStorage(t *testing.T) {
+	Convey("test values longer than the chunk size are split and reassembled", t, func() {
+		ctx := context.Background()
+		inner := storage.NewMemSync(nil)
+		store := storage.NewChunked(&storage.ChunkedStoreConfig{Storage: inner, ChunkSize: 10})
+		So(store.Init(&storage.Config{TTL: 5000, Logger: &util.DefaultLogger{}}), ShouldBeNil)
+
+		large := strings.Repeat("0123456789", 3) + "abc"
+		marked := "\x1dsmall"
+		So(store.BatchSetKeys(ctx, []util.Kv{{Key: "large", Value: large}, {Key: "small", Value: "small"}, {Key: "marked", Value: marked}}), ShouldBeNil)
+
+		raws, err := inner.BatchGetValues(ctx, []string{"large", "large#0", "large#3", "large#4", "small", "small#0"})
+		So(err, ShouldBeNil)
+		So(raws[0], ShouldNotContainSubstring, "0123")
+		So(raws[1], ShouldEqual, "0123456789")
+		So(raws[2], ShouldEqual, "abc")
+		So(raws[3:], ShouldResemble, []string{"", "small", ""})
+
+		value, err := store.GetValue(ctx, "large")
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, large)
+		values, err := store.BatchGetValues(ctx, []string{"small", "large", "missing", "marked"})
+		So(err, ShouldBeNil)
+		So(values, ShouldResemble, []string{"small", large, "", marked})
+
+		Convey("values whose chunks are missing or mixed with another write are misses", func() {
+			So(inner.SetKey(ctx, util.Kv{Key: "large#1", Value: "9876543210"}), ShouldBeNil)
+			_, err = store.GetValue(ctx, "large")
+			So(err, ShouldEqual, storage.ErrCacheNotFound)
+
+			So(inner.DeleteKey(ctx, "large#1"), ShouldBeNil)
+			values, err := store.BatchGetValues(ctx, []string{"large", "small"})
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []string{"", "small"})
+		})
+
+		Convey("deletes drop the chunks along with the value", func() {
+			So(store.BatchDeleteKeys(ctx, []string{"large", "small"}), ShouldBeNil)
+			keys, err := inner.ListKeysWithPrefix(ctx, "", 100)
+			So(err, ShouldBeNil)
+			sort.Strings(keys)
+			So(keys, ShouldResemble, []string{"marked", "marked#0"})
+
+			So(store.DeleteKey(ctx, "marked"), ShouldBeNil)
+			exists, err := inner.BatchKeyExist(ctx, []string{"marked#0"})
+			So(err, ShouldBeNil)
+			So(exists, ShouldBeFalse)
+		})
+	})
+
+	Convey("test large search results are cached in chunks and invalidated with them", t, func() {
+		store := storage.NewMemSync(nil)
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelOnlySearch,
+			CacheStorage: store,
+			CacheTTL:     5000,
+			InstanceId:   "chunked",
+			ChunkSize:    256,
+		})
+		So(err, ShouldBeNil)
+
+		// rows of its own, the shared ones may be deleted by other tests
+		rows := make([]TestModel, 0, 50)
+		for i := int64(1); i <= 50; i++ {
+			rows = append(rows, TestModel{Value1: 910100 + i, Value9: strings.Repeat("v", 20)})
+		}
+		So(originalDB.Create(&rows).Error, ShouldBeNil)
+		defer originalDB.Where("value1 BETWEEN ? AND ?", 910101, 910150).Delete(&TestModel{})
+
+		query := func() []TestModel {
+			models := make([]TestModel, 0)
+			So(db.Where("value1 BETWEEN ? AND ?", 910101, 910150).Find(&models).Error, ShouldBeNil)
+			return models
+		}
+		models := query()
+		So(len(models), ShouldEqual, 50)
+		So(query(), ShouldResemble, models)
+		So(c.HitCount(), ShouldEqual, 1)
+
+		ctx := context.Background()
+		keys, err := store.ListKeysWithPrefix(ctx, "", 1000)
+		So(err, ShouldBeNil)
+		chunks := 0
+		for _, key := range keys {
+			if strings.Contains(key, "#") {
+				chunks++
+			}
+		}
+		So(chunks, ShouldBeGreaterThan, 1)
+
+		So(asGorm2Cache(c).InvalidateSearchCache(ctx, "gorm_cache_model"), ShouldBeNil)
+		keys, err = store.ListKeysWithPrefix(ctx, "", 1000)
+		So(err, ShouldBeNil)
+		for _, key := range keys {
+			So(key, ShouldNotContainSubstring, "#")
+		}
+	})
+}
@@ -0,0 +1,158 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/compress"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// reverseCodec is a toy codec reversing the bytes, it counts its calls
+type reverseCodec struct {
+	compressed   int64
+	decompressed int64
+}
+
+func reverse(data []byte) []byte {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[len(data)-1-i] = b
+	}
+	return out
+}
+
+func (r *reverseCodec) Compress(data []byte) ([]byte, error) {
+	atomic.AddInt64(&r.compressed, 1)
+	return reverse(data), nil
+}
+
+func (r *reverseCodec) Decompress(data []byte) ([]byte, error) {
+	atomic.AddInt64(&r.decompressed, 1)
+	return reverse(data), nil
+}
+
+var testReverseCodec = &reverseCodec{}
+
+func init() {
+	compress.Register("reverse", testReverseCodec)
+}
+
+func TestCompressionCodecs(t *testing.T) {
+	Convey("test values round trip through registered codecs", t, func() {
+		ctx := context.Background()
+		for _, codec := range []string{compress.Gzip, compress.Zstd, compress.Snappy, "reverse"} {
+			inner := storage.NewGcache(gcache.New(100))
+			store := storage.NewCompressed(&storage.CompressedStoreConfig{Storage: inner, Codec: codec})
+			So(store.Init(&storage.Config{TTL: 5000, Logger: &util.DefaultLogger{}}), ShouldBeNil)
+
+			value := `5|[{"id":1,"value9":"` + strings.Repeat("a", 100) + `"}]`
+			So(store.SetKey(ctx, util.Kv{Key: "k", Value: value}), ShouldBeNil)
+
+			raw, err := inner.GetValue(ctx, "k")
+			So(err, ShouldBeNil)
+			So(raw, ShouldStartWith, string(compress.HeaderSeparator)+codec+string(compress.HeaderSeparator))
+
+			got, err := store.GetValue(ctx, "k")
+			So(err, ShouldBeNil)
+			So(got, ShouldEqual, value)
+
+			// values cached without compression are still readable
+			So(inner.SetKey(ctx, util.Kv{Key: "plain", Value: "recordNotFound"}), ShouldBeNil)
+			values, err := store.BatchGetValues(ctx, []string{"k", "plain", "missing"})
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []string{value, "recordNotFound", ""})
+		}
+		So(atomic.LoadInt64(&testReverseCodec.compressed), ShouldBeGreaterThan, 0)
+		So(atomic.LoadInt64(&testReverseCodec.decompressed), ShouldBeGreaterThan, 0)
+	})
+
+	Convey("test values below the threshold are kept uncompressed", t, func() {
+		ctx := context.Background()
+		inner := storage.NewGcache(gcache.New(100))
+		store := storage.NewCompressed(&storage.CompressedStoreConfig{Storage: inner, Codec: compress.Gzip, Threshold: 64})
+		So(store.Init(&storage.Config{TTL: 5000, Logger: &util.DefaultLogger{}}), ShouldBeNil)
+
+		small := `1|[{"id":1}]`
+		large := `5|[{"id":1,"value9":"` + strings.Repeat("a", 100) + `"}]`
+		header := string(compress.HeaderSeparator) + "raw"
+		So(store.BatchSetKeys(ctx, []util.Kv{{Key: "small", Value: small}, {Key: "large", Value: large}, {Key: "header", Value: header}}), ShouldBeNil)
+
+		raws, err := inner.BatchGetValues(ctx, []string{"small", "large", "header"})
+		So(err, ShouldBeNil)
+		So(raws[0], ShouldEqual, small)
+		So(raws[1], ShouldStartWith, string(compress.HeaderSeparator)+compress.Gzip)
+		So(raws[2], ShouldStartWith, string(compress.HeaderSeparator)+compress.Gzip)
+
+		values, err := store.BatchGetValues(ctx, []string{"small", "large", "header"})
+		So(err, ShouldBeNil)
+		So(values, ShouldResemble, []string{small, large, header})
+	})
+
+	Convey("test cache queries with a custom codec", t, func() {
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelAll,
+			CacheStorage: storage.NewGcache(gcache.New(1000)),
+			CacheTTL:     5000,
+			Compression:  "reverse",
+		})
+		So(err, ShouldBeNil)
+
+		models := make([]TestModel, 0)
+		So(db.Where("value1 BETWEEN ? AND ?", 141, 145).Find(&models).Error, ShouldBeNil)
+		cached := make([]TestModel, 0)
+		So(db.Where("value1 BETWEEN ? AND ?", 141, 145).Find(&cached).Error, ShouldBeNil)
+		So(c.HitCount(), ShouldEqual, 1)
+		So(cached, ShouldResemble, models)
+	})
+
+	Convey("test unregistered codec is rejected at init", t, func() {
+		_, _, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelAll,
+			CacheStorage: storage.NewGcache(gcache.New(1000)),
+			Compression:  "lz4",
+		})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "lz4")
+	})
+}
+
+// BenchmarkCompression compresses a realistic search result of 200 rows, reporting the compressed
+// size as a percentage of the original one
+func BenchmarkCompression(b *testing.B) {
+	models := make([]TestModel, 0, 200)
+	for i := int64(1); i <= 200; i++ {
+		models = append(models, TestModel{
+			ID: i, Value1: i, Value2: i * 7, Value3: i % 13, Value4: i * 1000, Value5: i,
+			Value6: i % 2, Value7: 1700000000 + i, Value8: i * 31, Value9: fmt.Sprintf("user-%d@example.com", i),
+		})
+	}
+	data, err := json.Marshal(models)
+	if err != nil {
+		b.Fatal(err)
+	}
+	value := fmt.Sprintf("%d|%s", len(models), data)
+
+	for _, codec := range []string{compress.Gzip, compress.Zstd, compress.Snappy} {
+		b.Run(codec, func(b *testing.B) {
+			b.SetBytes(int64(len(value)))
+			var encoded string
+			for i := 0; i < b.N; i++ {
+				if encoded, err = compress.Encode(codec, value); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(len(value)), "raw-bytes")
+			b.ReportMetric(float64(len(encoded)), "stored-bytes")
+			b.ReportMetric(100*float64(len(encoded))/float64(len(value)), "%-of-raw")
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	"github.com/redis/go-redis/v9"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// uncountableStorage hides the KeyCounter of the storage it wraps
+type uncountableStorage struct {
+	storage.DataStorage
+}
+
+func TestCountCache(t *testing.T) {
+	Convey("test counting the cache entries of a table", t, func() {
+		mr := miniredis.RunT(t)
+		stores := map[string]func() storage.DataStorage{
+			"memory": func() storage.DataStorage {
+				return storage.NewMem(storage.DefaultMemStoreConfig)
+			},
+			"redis": func() storage.DataStorage {
+				return storage.NewRedis(&storage.RedisStoreConfig{Options: &redis.Options{Addr: mr.Addr()}})
+			},
+		}
+		for name, newStore := range stores {
+			Convey(name, func() {
+				c, db, err := newCacheDB(&config.CacheConfig{
+					CacheLevel:   config.CacheLevelAll,
+					CacheStorage: newStore(),
+					CacheTTL:     5000,
+				})
+				So(err, ShouldBeNil)
+				gorm2Cache := asGorm2Cache(c)
+				ctx := context.Background()
+
+				for _, bounds := range [][]int{{111, 113}, {111, 114}} {
+					models := make([]TestModel, 0)
+					So(db.Where("value1 BETWEEN ? AND ?", bounds[0], bounds[1]).Find(&models).Error, ShouldBeNil)
+				}
+				primaryCount, err := gorm2Cache.CountPrimaryCache(ctx, TestModelTableName)
+				So(err, ShouldBeNil)
+				So(primaryCount, ShouldEqual, 4)
+				searchCount, err := gorm2Cache.CountSearchCache(ctx, TestModelTableName)
+				So(err, ShouldBeNil)
+				So(searchCount, ShouldEqual, 2)
+
+				So(gorm2Cache.InvalidateSearchCache(ctx, TestModelTableName), ShouldBeNil)
+				searchCount, err = gorm2Cache.CountSearchCache(ctx, TestModelTableName)
+				So(err, ShouldBeNil)
+				So(searchCount, ShouldEqual, 0)
+				primaryCount, err = gorm2Cache.CountPrimaryCache(ctx, TestModelTableName)
+				So(err, ShouldBeNil)
+				So(primaryCount, ShouldEqual, 4)
+			})
+		}
+
+		Convey("storages unable to count fail", func() {
+			c, err := cache.NewGorm2Cache(&config.CacheConfig{
+				CacheLevel:   config.CacheLevelAll,
+				CacheStorage: &uncountableStorage{storage.NewMem(storage.DefaultMemStoreConfig)},
+				CacheTTL:     5000,
+			})
+			So(err, ShouldBeNil)
+			_, err = asGorm2Cache(c).CountPrimaryCache(context.Background(), TestModelTableName)
+			So(err, ShouldEqual, util.ErrNotCountable)
+		})
+	})
+}
@@ -1,6 +1,8 @@
 package test
 
 import (
+	"context"
+
 	. "github.com/smartystreets/goconvey/convey"
 
 	"github.com/joykk/gorm-cache/cache"
@@ -26,3 +28,28 @@ func testSearchCreate(cache cache.Cache, db *gorm.DB) {
 	So(result.Error, ShouldBeNil)
 	So(cache.HitCount(), ShouldEqual, 0)
 }
+
+func testPrimaryCreateThenDelete(cache cache.Cache, db *gorm.DB) {
+	err := cache.ResetCache()
+	So(err, ShouldBeNil)
+
+	id := int64(10001)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&TestModel{ID: id, Value1: id}).Error; err != nil {
+			return err
+		}
+		// populate the primary cache with the uncommitted row
+		if err := tx.Where("id = ?", id).First(new(TestModel)).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&TestModel{ID: id}).Error
+	})
+	So(err, ShouldBeNil)
+
+	exists, err := asGorm2Cache(cache).BatchPrimaryKeyExists(context.Background(), TestModelTableName, []string{"10001"})
+	So(err, ShouldBeNil)
+	So(exists, ShouldBeFalse)
+
+	result := db.Where("id = ?", id).First(new(TestModel))
+	So(result.Error, ShouldEqual, gorm.ErrRecordNotFound)
+}
@@ -0,0 +1,74 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// prefixDeleteCountingStorage counts the deletes of keys with the prefix
+type prefixDeleteCountingStorage struct {
+	*storage.MemSync
+	prefix  string
+	deletes int64
+}
+
+func (s *prefixDeleteCountingStorage) DeleteKeysWithPrefix(ctx context.Context, keyPrefix string) error {
+	if strings.HasPrefix(keyPrefix, s.prefix) {
+		atomic.AddInt64(&s.deletes, 1)
+	}
+	return s.MemSync.DeleteKeysWithPrefix(ctx, keyPrefix)
+}
+
+func TestInvalidationDebounce(t *testing.T) {
+	Convey("test invalidations of the search cache of a table are coalesced within the debounce window", t, func() {
+		So(originalDB.AutoMigrate(&UUIDModel{}), ShouldBeNil)
+		defer originalDB.Migrator().DropTable(&UUIDModel{})
+		So(originalDB.Create(&UUIDModel{UUID: "seed"}).Error, ShouldBeNil)
+
+		store := &prefixDeleteCountingStorage{MemSync: storage.NewMemSync(nil)}
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:           config.CacheLevelOnlySearch,
+			CacheStorage:         store,
+			CacheTTL:             5000,
+			InvalidateWhenUpdate: true,
+			InvalidationDebounce: 300,
+		})
+		So(err, ShouldBeNil)
+		store.prefix = util.GenSearchCachePrefix(asGorm2Cache(c).InstanceId, UUIDModelTableName)
+		count := func() int {
+			models := make([]UUIDModel, 0)
+			So(db.Find(&models).Error, ShouldBeNil)
+			return len(models)
+		}
+
+		So(count(), ShouldEqual, 1)
+		So(count(), ShouldEqual, 1)
+		So(c.HitCount(), ShouldEqual, 1)
+
+		// only the first insert drops the search cache, the following reads are neither stale nor cached
+		for i := 0; i < 20; i++ {
+			So(db.Create(&UUIDModel{UUID: fmt.Sprintf("d-%d", i)}).Error, ShouldBeNil)
+			So(count(), ShouldEqual, i+2)
+		}
+		So(atomic.LoadInt64(&store.deletes), ShouldEqual, 1)
+		So(c.HitCount(), ShouldEqual, 1)
+
+		// once the window passed results are cached and invalidated again
+		time.Sleep(400 * time.Millisecond)
+		So(count(), ShouldEqual, 21)
+		So(count(), ShouldEqual, 21)
+		So(c.HitCount(), ShouldEqual, 2)
+		So(db.Create(&UUIDModel{UUID: "d-20"}).Error, ShouldBeNil)
+		So(atomic.LoadInt64(&store.deletes), ShouldEqual, 2)
+		So(count(), ShouldEqual, 22)
+	})
+}
@@ -0,0 +1,69 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/redis/go-redis/v9"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDebugBundle(t *testing.T) {
+	Convey("test debug bundle is redacted json with all sections", t, func() {
+		mr := miniredis.RunT(t)
+		mr.RequireUserAuth("cache-user", "s3cret-password")
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel: config.CacheLevelAll,
+			CacheStorage: storage.NewRedis(&storage.RedisStoreConfig{Options: &redis.Options{
+				Addr:     mr.Addr(),
+				Username: "cache-user",
+				Password: "s3cret-password",
+			}}),
+			CacheTTL: 5000,
+		})
+		So(err, ShouldBeNil)
+
+		models := make([]TestModel, 0)
+		So(db.Where("id IN ?", []int64{1, 2, 3}).Find(&models).Error, ShouldBeNil)
+		So(len(models), ShouldEqual, 3)
+		So(db.Where("id IN ?", []int64{1, 2, 3}).Find(&models).Error, ShouldBeNil)
+
+		reader, err := asGorm2Cache(c).DebugBundle(context.Background())
+		So(err, ShouldBeNil)
+		data, err := io.ReadAll(reader)
+		So(err, ShouldBeNil)
+
+		bundle := make(map[string]json.RawMessage)
+		So(json.Unmarshal(data, &bundle), ShouldBeNil)
+		for _, section := range []string{"generatedAt", "config", "stats", "inventory"} {
+			So(bundle, ShouldContainKey, section)
+		}
+		So(string(data), ShouldNotContainSubstring, "s3cret-password")
+		So(string(data), ShouldNotContainSubstring, "cache-user")
+		So(string(data), ShouldNotContainSubstring, `"value1"`)
+
+		stats := make(map[string]interface{})
+		So(json.Unmarshal(bundle["stats"], &stats), ShouldBeNil)
+		So(stats["hitCount"], ShouldEqual, 1)
+
+		inventory := struct {
+			Countable bool
+			Tables    []struct {
+				Table        string
+				PrimaryCount *int64
+				SearchCount  *int64
+			}
+		}{}
+		So(json.Unmarshal(bundle["inventory"], &inventory), ShouldBeNil)
+		So(inventory.Countable, ShouldBeTrue)
+		So(len(inventory.Tables), ShouldEqual, 1)
+		So(inventory.Tables[0].Table, ShouldEqual, "gorm_cache_model")
+		So(*inventory.Tables[0].PrimaryCount, ShouldEqual, 3)
+		So(*inventory.Tables[0].SearchCount, ShouldEqual, 1)
+	})
+}
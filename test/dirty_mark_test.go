@@ -0,0 +1,63 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDirtyMark(t *testing.T) {
+	Convey("test lookups of rows marked by a write bypass the cache until it invalidated them", t, func() {
+		defer originalDB.Model(&TestModel{ID: 91}).Update("value2", 91)
+
+		Convey("marks of writes not invalidating are left to expire", func() {
+			c, db, err := newCacheDB(&config.CacheConfig{
+				CacheLevel:   config.CacheLevelOnlyPrimary,
+				CacheStorage: storage.NewMemSync(nil),
+				CacheTTL:     5000,
+				DirtyMarkTTL: 100,
+			})
+			So(err, ShouldBeNil)
+
+			get := func() TestModel {
+				model := TestModel{}
+				So(db.Where("id = ?", 91).First(&model).Error, ShouldBeNil)
+				return model
+			}
+			get()
+			So(get().Value2, ShouldEqual, 91)
+			So(c.HitCount(), ShouldEqual, 1)
+
+			So(db.Model(&TestModel{ID: 91}).Update("value2", 1000).Error, ShouldBeNil)
+			So(get().Value2, ShouldEqual, 1000)
+			So(get().Value2, ShouldEqual, 1000)
+			So(c.HitCount(), ShouldEqual, 1)
+
+			time.Sleep(150 * time.Millisecond)
+			get()
+			So(c.HitCount(), ShouldEqual, 2)
+		})
+
+		Convey("marks are cleared once the write invalidated the cache", func() {
+			c, db, err := newCacheDB(&config.CacheConfig{
+				CacheLevel:           config.CacheLevelOnlyPrimary,
+				CacheStorage:         storage.NewMemSync(nil),
+				CacheTTL:             5000,
+				InvalidateWhenUpdate: true,
+				DirtyMarkTTL:         5000,
+			})
+			So(err, ShouldBeNil)
+
+			So(db.Model(&TestModel{ID: 91}).Update("value2", 1000).Error, ShouldBeNil)
+			for i := 0; i < 2; i++ {
+				model := TestModel{}
+				So(db.Where("id = ?", 91).First(&model).Error, ShouldBeNil)
+				So(model.Value2, ShouldEqual, 1000)
+			}
+			So(c.HitCount(), ShouldEqual, 1)
+		})
+	})
+}
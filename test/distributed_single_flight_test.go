@@ -0,0 +1,66 @@
+package test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/glebarez/sqlite"
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/redis/go-redis/v9"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestDistributedSingleFlight(t *testing.T) {
+	Convey("test instances sharing a storage load a query once", t, func() {
+		mr := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		pool := &slowConnPool{ConnPool: originalDB.ConnPool}
+		newInstance := func() (cache.Cache, *gorm.DB) {
+			db, err := gorm.Open(&sqlite.Dialector{Conn: pool}, &gorm.Config{})
+			So(err, ShouldBeNil)
+			c, err := cache.NewGorm2Cache(&config.CacheConfig{
+				CacheLevel:              config.CacheLevelOnlySearch,
+				CacheStorage:            storage.NewRedis(&storage.RedisStoreConfig{KeyPrefix: "shared", Client: client}),
+				InstanceId:              "fleet",
+				CacheTTL:                5000,
+				DistributedSingleFlight: true,
+			})
+			So(err, ShouldBeNil)
+			So(db.Use(c), ShouldBeNil)
+			return c, db
+		}
+		a, dbA := newInstance()
+		b, dbB := newInstance()
+
+		var wg sync.WaitGroup
+		results := make([][]TestModel, 2)
+		for i, db := range []*gorm.DB{dbA, dbB} {
+			wg.Add(1)
+			go func(i int, db *gorm.DB) {
+				defer wg.Done()
+				if i == 1 {
+					// starts while the first instance loads
+					time.Sleep(50 * time.Millisecond)
+				}
+				models := make([]TestModel, 0)
+				if err := db.Where("value1 BETWEEN ? AND ?", 181, 183).Find(&models).Error; err == nil {
+					results[i] = models
+				}
+			}(i, db)
+		}
+		wg.Wait()
+
+		So(atomic.LoadInt64(&pool.count), ShouldEqual, 1)
+		So(len(results[0]), ShouldEqual, 3)
+		So(results[1], ShouldResemble, results[0])
+		So(a.MissCount(), ShouldEqual, 1)
+		So(b.HitCount(), ShouldEqual, 1)
+		So(mr.Keys(), ShouldHaveLength, 1) // the lock was released
+	})
+}
@@ -0,0 +1,216 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeDynamo serves storage.DynamoClient from a map, paging queries and scans by 2 keys, without expiring items
+type fakeDynamo struct {
+	mu      sync.Mutex
+	items   map[string]storage.DynamoItem
+	queries int
+	scans   int
+}
+
+func newFakeDynamo() *fakeDynamo {
+	return &fakeDynamo{items: make(map[string]storage.DynamoItem)}
+}
+
+func (f *fakeDynamo) BatchGet(_ context.Context, keys []string) (map[string]storage.DynamoItem, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	items := make(map[string]storage.DynamoItem)
+	for _, key := range keys {
+		if item, ok := f.items[key]; ok {
+			items[key] = item
+		}
+	}
+	return items, nil
+}
+
+func (f *fakeDynamo) BatchPut(_ context.Context, items []storage.DynamoItem) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(items) > 25 {
+		return errors.New("too many items")
+	}
+	for _, item := range items {
+		f.items[item.Key] = item
+	}
+	return nil
+}
+
+func (f *fakeDynamo) BatchDelete(_ context.Context, keys []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(keys) > 25 {
+		return errors.New("too many keys")
+	}
+	for _, key := range keys {
+		delete(f.items, key)
+	}
+	return nil
+}
+
+func (f *fakeDynamo) QueryKeys(_ context.Context, prefix string, keyPrefix string, fn func(keys []string) bool) error {
+	f.mu.Lock()
+	f.queries++
+	f.mu.Unlock()
+	return f.page(func(item storage.DynamoItem) bool {
+		return item.Prefix == prefix && strings.HasPrefix(item.Key, keyPrefix)
+	}, fn)
+}
+
+func (f *fakeDynamo) ScanKeys(_ context.Context, keyPrefix string, fn func(keys []string) bool) error {
+	f.mu.Lock()
+	f.scans++
+	f.mu.Unlock()
+	return f.page(func(item storage.DynamoItem) bool {
+		return strings.HasPrefix(item.Key, keyPrefix)
+	}, fn)
+}
+
+func (f *fakeDynamo) page(match func(item storage.DynamoItem) bool, fn func(keys []string) bool) error {
+	f.mu.Lock()
+	keys := make([]string, 0)
+	for key, item := range f.items {
+		if match(item) {
+			keys = append(keys, key)
+		}
+	}
+	f.mu.Unlock()
+	sort.Strings(keys)
+	for start := 0; start < len(keys); start += 2 {
+		end := start + 2
+		if end > len(keys) {
+			end = len(keys)
+		}
+		if !fn(keys[start:end]) {
+			break
+		}
+	}
+	return nil
+}
+
+func TestDynamoStorage(t *testing.T) {
+	Convey("test the dynamo storage", t, func() {
+		client := newFakeDynamo()
+		So(client.BatchPut(context.Background(), []storage.DynamoItem{{Key: "foreign:key", Prefix: "foreign", Value: "kept"}}), ShouldBeNil)
+
+		store := storage.NewDynamo(&storage.DynamoStoreConfig{KeyPrefix: "app", Client: client, PrefixSegments: 2})
+		So(store.Init(&storage.Config{TTL: 5000, Logger: &util.DefaultLogger{}}), ShouldBeNil)
+		ctx := context.Background()
+
+		Convey("keys are read, written with their expiry and deleted", func() {
+			kvs := make([]util.Kv, 0)
+			for i := 0; i < 30; i++ {
+				kvs = append(kvs, util.Kv{Key: "s:t1:" + string(rune('a'+i)), Value: "v"})
+			}
+			kvs = append(kvs, util.Kv{Key: "k2", Value: "v2", TTL: 200})
+			So(store.BatchSetKeys(ctx, kvs), ShouldBeNil)
+			So(client.items["app:s:t1:a"].Prefix, ShouldEqual, "app:s:t1")
+			// 5s jittered by up to 10%, rounded up to the second
+			expiresAt := client.items["app:s:t1:a"].ExpiresAt
+			So(expiresAt, ShouldBeBetweenOrEqual, time.Now().Unix()+4, time.Now().Unix()+7)
+			So(client.items["app:k2"].Prefix, ShouldEqual, "app:k2")
+
+			values, err := store.BatchGetValues(ctx, []string{"s:t1:a", "k3", "k2"})
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []string{"v", "", "v2"})
+			readAt := time.Now().UnixMilli()
+			ttl, err := store.KeyTTL(ctx, "s:t1:a")
+			So(err, ShouldBeNil)
+			So(ttl, ShouldBeBetweenOrEqual, 3000, expiresAt*1000-readAt)
+
+			So(store.DeleteKey(ctx, "k2"), ShouldBeNil)
+			_, err = store.GetValue(ctx, "k2")
+			So(err, ShouldEqual, storage.ErrCacheNotFound)
+		})
+
+		Convey("expiring keys writes their items back with the new expiry", func() {
+			So(store.SetKey(ctx, util.Kv{Key: "k", Value: "v", TTL: 1000}), ShouldBeNil)
+			So(store.ExpireKeys(ctx, []string{"k", "missing"}, 60000), ShouldBeNil)
+			So(client.items["app:k"].Value, ShouldEqual, "v")
+			So(client.items["app:k"].ExpiresAt, ShouldBeGreaterThanOrEqualTo, time.Now().Unix()+50)
+			_, found := client.items["app:missing"]
+			So(found, ShouldBeFalse)
+			ttl, err := store.KeyTTL(ctx, "k")
+			So(err, ShouldBeNil)
+			So(ttl, ShouldBeGreaterThan, 50000)
+		})
+
+		Convey("expired items not deleted yet are misses", func() {
+			So(client.BatchPut(ctx, []storage.DynamoItem{{Key: "app:old", Prefix: "app:old", Value: "v", ExpiresAt: time.Now().Unix() - 1}}), ShouldBeNil)
+			_, err := store.GetValue(ctx, "old")
+			So(err, ShouldEqual, storage.ErrCacheNotFound)
+		})
+
+		Convey("prefix deletes query the keys of their prefix, shorter ones scan", func() {
+			So(store.BatchSetKeys(ctx, []util.Kv{
+				{Key: "s:t1:0", Value: "v"}, {Key: "s:t1:1", Value: "v"}, {Key: "s:t1:2", Value: "v"}, {Key: "s:t2:0", Value: "v"},
+			}), ShouldBeNil)
+			count, err := store.CountKeysWithPrefix(ctx, "s:t1:")
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 3)
+			keys, err := store.ListKeysWithPrefix(ctx, "s:t1:", 2)
+			So(err, ShouldBeNil)
+			So(keys, ShouldResemble, []string{"s:t1:0", "s:t1:1"})
+
+			So(store.DeleteKeysWithPrefix(ctx, "s:t1"), ShouldBeNil)
+			So(client.queries, ShouldEqual, 3)
+			So(client.scans, ShouldEqual, 0)
+			values, err := store.BatchGetValues(ctx, []string{"s:t1:0", "s:t1:2", "s:t2:0"})
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []string{"", "", "v"})
+
+			So(store.DeleteKeysWithPrefix(ctx, "s"), ShouldBeNil)
+			So(client.scans, ShouldEqual, 1)
+			_, err = store.GetValue(ctx, "s:t2:0")
+			So(err, ShouldEqual, storage.ErrCacheNotFound)
+
+			Convey("and clean cache the keys under the prefix of the store only", func() {
+				So(store.SetKey(ctx, util.Kv{Key: "k", Value: "v"}), ShouldBeNil)
+				So(store.CleanCache(ctx), ShouldBeNil)
+				So(len(client.items), ShouldEqual, 1)
+				So(client.items["foreign:key"].Value, ShouldEqual, "kept")
+			})
+		})
+
+		Convey("the search cache of a table is invalidated by a query of its prefix", func() {
+			defer originalDB.Model(&TestModel{ID: 188}).Update("value2", 188)
+			var c cache.Cache
+			c, db, err := newCacheDB(&config.CacheConfig{
+				CacheLevel:           config.CacheLevelOnlySearch,
+				CacheStorage:         storage.NewDynamo(&storage.DynamoStoreConfig{Client: client}),
+				CacheTTL:             5000,
+				InvalidateWhenUpdate: true,
+			})
+			So(err, ShouldBeNil)
+			search := func() int64 {
+				models := make([]TestModel, 0)
+				So(db.Where("value1 = ?", 188).Find(&models).Error, ShouldBeNil)
+				So(len(models), ShouldEqual, 1)
+				return models[0].Value2
+			}
+			So(search(), ShouldEqual, 188)
+			So(search(), ShouldEqual, 188)
+			So(c.HitCount(), ShouldEqual, 1)
+
+			So(db.Model(&TestModel{ID: 188}).Update("value2", 1000).Error, ShouldBeNil)
+			So(client.scans, ShouldEqual, 0)
+			So(search(), ShouldEqual, 1000)
+		})
+	})
+}
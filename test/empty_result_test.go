@@ -0,0 +1,51 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestCacheEmptyResults(t *testing.T) {
+	for _, cacheEmpty := range []bool{true, false} {
+		Convey("test zero rows matched are cached apart from primary keys not found", t, func() {
+			c, db, err := newCacheDB(&config.CacheConfig{
+				CacheLevel:        config.CacheLevelAll,
+				CacheStorage:      storage.NewGcache(gcache.New(1000)),
+				CacheTTL:          5000,
+				EmptyCacheTTL:     1000,
+				CacheEmptyResults: cacheEmpty,
+			})
+			So(err, ShouldBeNil)
+			gorm2Cache := asGorm2Cache(c)
+			ctx := context.Background()
+
+			for i := 0; i < 2; i++ {
+				models := []TestModel{{Value1: 1}}
+				So(db.Where("value1 = ?", -1).Find(&models).Error, ShouldBeNil)
+				So(models, ShouldBeEmpty)
+			}
+			searchKeys, err := gorm2Cache.CountSearchCache(ctx, TestModelTableName)
+			So(err, ShouldBeNil)
+			if cacheEmpty {
+				So(c.HitCount(), ShouldEqual, 1)
+				So(searchKeys, ShouldEqual, 1)
+			} else {
+				So(c.HitCount(), ShouldEqual, 0)
+				So(searchKeys, ShouldEqual, 0)
+			}
+
+			// primary keys not found are cached whatever CacheEmptyResults
+			hits := c.HitCount()
+			for i := 0; i < 2; i++ {
+				So(db.Where("id = ?", -1).First(&TestModel{}).Error, ShouldEqual, gorm.ErrRecordNotFound)
+			}
+			So(c.HitCount(), ShouldEqual, hits+1)
+		})
+	}
+}
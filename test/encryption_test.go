@@ -0,0 +1,130 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/joykk/gorm-cache/compress"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+// rotatingKeys is a KeyProvider encrypting with the last of its keys
+type rotatingKeys struct {
+	ids  []string
+	keys map[string][]byte
+}
+
+func (r *rotatingKeys) CurrentKey(context.Context) (string, []byte, error) {
+	id := r.ids[len(r.ids)-1]
+	return id, r.keys[id], nil
+}
+
+func (r *rotatingKeys) Key(_ context.Context, id string) ([]byte, error) {
+	key, ok := r.keys[id]
+	if !ok {
+		return nil, errors.New("unknown key")
+	}
+	return key, nil
+}
+
+func TestEncryptedStorage(t *testing.T) {
+	Convey("test values are encrypted and bound to their key", t, func() {
+		ctx := context.Background()
+		inner := storage.NewMemSync(nil)
+		keys := &rotatingKeys{ids: []string{"k1"}, keys: map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}}
+		store := storage.NewEncrypted(&storage.EncryptedStoreConfig{Storage: inner, Keys: keys})
+		So(store.Init(&storage.Config{TTL: 5000, Logger: &util.DefaultLogger{}}), ShouldBeNil)
+
+		So(store.SetKey(ctx, util.Kv{Key: "a", Value: `{"name":"alice"}`}), ShouldBeNil)
+		raw, err := inner.GetValue(ctx, "a")
+		So(err, ShouldBeNil)
+		So(raw, ShouldNotContainSubstring, "alice")
+		value, err := store.GetValue(ctx, "a")
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, `{"name":"alice"}`)
+
+		Convey("values moved, tampered with or not encrypted are misses", func() {
+			tampered := []byte(raw)
+			tampered[len(tampered)-1] ^= 1
+			So(inner.BatchSetKeys(ctx, []util.Kv{
+				{Key: "b", Value: raw}, {Key: "c", Value: string(tampered)}, {Key: "d", Value: `{"name":"mallory"}`},
+			}), ShouldBeNil)
+			for _, key := range []string{"b", "c", "d"} {
+				_, err = store.GetValue(ctx, key)
+				So(err, ShouldEqual, storage.ErrCacheNotFound)
+			}
+			values, err := store.BatchGetValues(ctx, []string{"a", "b", "c", "d"})
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []string{`{"name":"alice"}`, "", "", ""})
+		})
+
+		Convey("values encrypted before a rotation are still read", func() {
+			keys.ids = append(keys.ids, "k2")
+			keys.keys["k2"] = bytes.Repeat([]byte{2}, 16)
+			So(store.SetKey(ctx, util.Kv{Key: "b", Value: "bob"}), ShouldBeNil)
+			values, err := store.BatchGetValues(ctx, []string{"a", "b"})
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []string{`{"name":"alice"}`, "bob"})
+
+			delete(keys.keys, "k1")
+			rotated := storage.NewEncrypted(&storage.EncryptedStoreConfig{Storage: inner, Keys: keys})
+			So(rotated.Init(&storage.Config{TTL: 5000, Logger: &util.DefaultLogger{}}), ShouldBeNil)
+			_, err = rotated.GetValue(ctx, "a")
+			So(err, ShouldEqual, storage.ErrCacheNotFound)
+		})
+
+		Convey("malformed keys are rejected on init", func() {
+			store := storage.NewEncrypted(&storage.EncryptedStoreConfig{Storage: inner, Keys: storage.StaticKey("short")})
+			So(store.Init(&storage.Config{TTL: 5000, Logger: &util.DefaultLogger{}}), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestEncryptionKey(t *testing.T) {
+	Convey("test rows are cached encrypted, compressed first", t, func() {
+		store := storage.NewMemSync(nil)
+		newDB := func(key string) (*gorm.DB, func() uint64) {
+			c, db, err := newCacheDB(&config.CacheConfig{
+				CacheLevel:    config.CacheLevelAll,
+				CacheStorage:  store,
+				CacheTTL:      5000,
+				InstanceId:    "encrypted",
+				Compression:   compress.Gzip,
+				EncryptionKey: []byte(strings.Repeat(key, 32)),
+			})
+			So(err, ShouldBeNil)
+			return db, c.HitCount
+		}
+		query := func(db *gorm.DB) {
+			models := make([]TestModel, 0)
+			So(db.Where("value1 BETWEEN ? AND ?", 141, 143).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 3)
+			So(models[0].Value2, ShouldEqual, 141)
+		}
+
+		db, hits := newDB("a")
+		query(db)
+		query(db)
+		So(hits(), ShouldEqual, 1)
+
+		keys, err := store.ListKeysWithPrefix(context.Background(), "", 10)
+		So(err, ShouldBeNil)
+		So(keys, ShouldNotBeEmpty)
+		for _, key := range keys {
+			raw, err := store.GetValue(context.Background(), key)
+			So(err, ShouldBeNil)
+			So(raw, ShouldNotContainSubstring, "141")
+		}
+
+		otherDB, otherHits := newDB("b")
+		query(otherDB)
+		So(otherHits(), ShouldEqual, 0)
+	})
+}
@@ -0,0 +1,95 @@
+package test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestExplain(t *testing.T) {
+	Convey("test explained queries report the decisions of the cache", t, func() {
+		_, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:         config.CacheLevelAll,
+			CacheStorage:       storage.NewMemSync(nil),
+			CacheTTL:           5000,
+			MaxCacheValueBytes: 1000,
+		})
+		So(err, ShouldBeNil)
+
+		models := make([]TestModel, 0)
+		tx := db.Where("value1 BETWEEN ? AND ?", 151, 153).Find(&models)
+		So(tx.Error, ShouldBeNil)
+		So(cache.GetExplain(tx), ShouldBeNil)
+
+		Convey("a search missed then hit", func() {
+			search := func() *cache.ExplainReport {
+				models := make([]TestModel, 0)
+				tx := cache.Explain(db).Where("value1 BETWEEN ? AND ?", 161, 163).Find(&models)
+				So(tx.Error, ShouldBeNil)
+				So(len(models), ShouldEqual, 3)
+				return cache.GetExplain(tx)
+			}
+			report := search()
+			So(report.Table, ShouldEqual, TestModelTableName)
+			So(report.ShouldCache, ShouldBeTrue)
+			So(report.Key, ShouldStartWith, "gormcache:")
+			So(report.Outcome, ShouldEqual, "miss")
+			So(report.Decisions, ShouldContain, "search cache miss")
+			So(report.Decisions, ShouldContain, "search cache written with 3 rows")
+			So(report.Skipped, ShouldContain, "primary cache: no primary key in the where clause")
+
+			hit := search()
+			So(hit.Outcome, ShouldEqual, "search_hit")
+			So(hit.Key, ShouldEqual, report.Key)
+			So(hit.Decisions, ShouldContain, "search cache hit")
+			So(hit.String(), ShouldStartWith, "table "+TestModelTableName+": search_hit\n  - ")
+		})
+
+		Convey("a lookup by primary key missed then hit", func() {
+			for _, outcome := range []string{"miss", "primary_hit"} {
+				model := TestModel{}
+				tx := cache.Explain(db).Where("id = ?", 171).First(&model)
+				So(tx.Error, ShouldBeNil)
+				So(cache.GetExplain(tx).Outcome, ShouldEqual, outcome)
+			}
+		})
+
+		Convey("the reasons the cache was skipped", func() {
+			models := make([]TestModel, 0)
+			tx := cache.Explain(cache.NoCache(db)).Where("value1 > ?", 0).Find(&models)
+			So(tx.Error, ShouldBeNil)
+			report := cache.GetExplain(tx)
+			So(report.Key, ShouldBeEmpty)
+			So(report.Skipped, ShouldResemble, []string{"cache bypassed, no cache requested by NoCache or a /* nocache */ hint"})
+
+			tx = cache.Explain(db).Where("value1 > ?", 0).Find(&models)
+			So(tx.Error, ShouldBeNil)
+			report = cache.GetExplain(tx)
+			So(report.Outcome, ShouldEqual, "miss")
+			skippedTooLarge := false
+			for _, reason := range report.Skipped {
+				skippedTooLarge = skippedTooLarge || strings.Contains(reason, "larger than MaxCacheValueBytes")
+			}
+			So(skippedTooLarge, ShouldBeTrue)
+		})
+	})
+
+	Convey("test explained queries of tables not cached", t, func() {
+		_, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:    config.CacheLevelAll,
+			CacheStorage:  storage.NewMemSync(nil),
+			DisableTables: []string{TestModelTableName},
+		})
+		So(err, ShouldBeNil)
+		model := TestModel{}
+		tx := cache.Explain(db).Where("id = ?", 172).First(&model)
+		So(tx.Error, ShouldBeNil)
+		report := cache.GetExplain(tx)
+		So(report.ShouldCache, ShouldBeFalse)
+		So(report.Skipped, ShouldResemble, []string{"table " + TestModelTableName + " is denied by DisableTables"})
+	})
+}
@@ -0,0 +1,49 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"testing"
+
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestExpvarCounters(t *testing.T) {
+	Convey("test cache counters published as expvar", t, func() {
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:           config.CacheLevelOnlySearch,
+			CacheStorage:         storage.NewGcache(gcache.New(1000)),
+			InvalidateWhenUpdate: true,
+			CacheTTL:             5000,
+			PublishExpvar:        true,
+		})
+		So(err, ShouldBeNil)
+		instanceId := asGorm2Cache(c).InstanceId
+
+		readCounters := func() map[string]uint64 {
+			m, ok := expvar.Get(cache.ExpvarName).(*expvar.Map)
+			So(ok, ShouldBeTrue)
+			v := m.Get(instanceId)
+			So(v, ShouldNotBeNil)
+			counters := make(map[string]uint64)
+			So(json.Unmarshal([]byte(v.String()), &counters), ShouldBeNil)
+			return counters
+		}
+
+		models := make([]TestModel, 0)
+		So(db.Where("value1 BETWEEN ? AND ?", 1, 5).Find(&models).Error, ShouldBeNil)
+		So(db.Where("value1 BETWEEN ? AND ?", 1, 5).Find(&models).Error, ShouldBeNil)
+		So(asGorm2Cache(c).InvalidateSearchCache(context.Background(), "gorm_cache_model"), ShouldBeNil)
+
+		counters := readCounters()
+		So(counters["hits"], ShouldEqual, 1)
+		So(counters["misses"], ShouldEqual, 1)
+		So(counters["invalidations"], ShouldEqual, 1)
+		So(counters["errors"], ShouldEqual, 0)
+	})
+}
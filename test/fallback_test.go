@@ -0,0 +1,108 @@
+package test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// slowStorage delays reads by delay and writes and prefix deletes by writeDelay nanoseconds,
+// an operation whose context is done first fails with the context error
+type slowStorage struct {
+	storage.DataStorage
+	delay      int64
+	writeDelay int64
+}
+
+func (s *slowStorage) wait(ctx context.Context, delay *int64) error {
+	select {
+	case <-time.After(time.Duration(atomic.LoadInt64(delay))):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *slowStorage) GetValue(ctx context.Context, key string) (string, error) {
+	if err := s.wait(ctx, &s.delay); err != nil {
+		return "", err
+	}
+	return s.DataStorage.GetValue(ctx, key)
+}
+
+func (s *slowStorage) BatchGetValues(ctx context.Context, keys []string) ([]string, error) {
+	if err := s.wait(ctx, &s.delay); err != nil {
+		return nil, err
+	}
+	return s.DataStorage.BatchGetValues(ctx, keys)
+}
+
+func (s *slowStorage) SetKey(ctx context.Context, kv util.Kv) error {
+	if err := s.wait(ctx, &s.writeDelay); err != nil {
+		return err
+	}
+	return s.DataStorage.SetKey(ctx, kv)
+}
+
+func (s *slowStorage) BatchSetKeys(ctx context.Context, kvs []util.Kv) error {
+	if err := s.wait(ctx, &s.writeDelay); err != nil {
+		return err
+	}
+	return s.DataStorage.BatchSetKeys(ctx, kvs)
+}
+
+func (s *slowStorage) DeleteKeysWithPrefix(ctx context.Context, keyPrefix string) error {
+	if err := s.wait(ctx, &s.writeDelay); err != nil {
+		return err
+	}
+	return s.DataStorage.DeleteKeysWithPrefix(ctx, keyPrefix)
+}
+
+func TestFallbackStorage(t *testing.T) {
+	Convey("test fallback storage serves slow remote reads from memory", t, func() {
+		remote := &slowStorage{DataStorage: storage.NewGcache(gcache.New(100))}
+		store := storage.NewFallback(&storage.FallbackStoreConfig{
+			Remote:  remote,
+			Timeout: 20 * time.Millisecond,
+		})
+		So(store.Init(&storage.Config{TTL: 5000, Logger: &util.DefaultLogger{}}), ShouldBeNil)
+		ctx := context.Background()
+
+		So(store.SetKey(ctx, util.Kv{Key: "both", Value: "v1"}), ShouldBeNil)
+		So(remote.DataStorage.SetKey(ctx, util.Kv{Key: "remote-only", Value: "v2"}), ShouldBeNil)
+
+		Convey("fast remote answers and populates memory", func() {
+			value, err := store.GetValue(ctx, "remote-only")
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, "v2")
+
+			atomic.StoreInt64(&remote.delay, int64(time.Second))
+			value, err = store.GetValue(ctx, "remote-only")
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, "v2")
+		})
+
+		Convey("slow remote falls back to memory within the timeout", func() {
+			atomic.StoreInt64(&remote.delay, int64(time.Second))
+
+			start := time.Now()
+			value, err := store.GetValue(ctx, "both")
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, "v1")
+
+			values, err := store.BatchGetValues(ctx, []string{"both", "remote-only"})
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []string{"v1", ""})
+
+			_, err = store.GetValue(ctx, "remote-only")
+			So(err, ShouldEqual, storage.ErrCacheNotFound)
+			So(time.Since(start), ShouldBeLessThan, 500*time.Millisecond)
+		})
+	})
+}
@@ -0,0 +1,98 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func TestFirstOrCreate(t *testing.T) {
+	for _, level := range []config.CacheLevel{config.CacheLevelOnlyPrimary, config.CacheLevelOnlySearch, config.CacheLevelAll} {
+		Convey("test first or create keeps the cache consistent", t, func() {
+			c, db, err := newCacheDB(&config.CacheConfig{
+				CacheLevel:           level,
+				CacheStorage:         storage.NewGcache(gcache.New(1000)),
+				InvalidateWhenUpdate: true,
+				CacheTTL:             5000,
+				CacheEmptyResults:    true,
+			})
+			So(err, ShouldBeNil)
+
+			Convey("find branch", func() {
+				model := &TestModel{}
+				So(db.Where("value1 = ?", 77).FirstOrCreate(model).Error, ShouldBeNil)
+				So(model.ID, ShouldEqual, 77)
+				model = &TestModel{}
+				So(db.Where("value1 = ?", 77).FirstOrCreate(model).Error, ShouldBeNil)
+				So(model.ID, ShouldEqual, 77)
+				if level != config.CacheLevelOnlyPrimary {
+					So(c.HitCount(), ShouldEqual, 1)
+				}
+			})
+
+			Convey("create branch", func() {
+				value := int64(900000 + level)
+				defer originalDB.Where("value1 = ?", value).Delete(&TestModel{})
+
+				// cache the empty result of the exact query FirstOrCreate runs
+				firstQuery := func() *gorm.DB {
+					return db.Where(&TestModel{Value1: value}).Limit(1).Order(clause.OrderByColumn{
+						Column: clause.Column{Table: clause.CurrentTable, Name: clause.PrimaryKey},
+					})
+				}
+				So(firstQuery().Find(&TestModel{}).RowsAffected, ShouldEqual, 0)
+				So(firstQuery().Find(&TestModel{}).RowsAffected, ShouldEqual, 0)
+				if level != config.CacheLevelOnlyPrimary {
+					So(c.HitCount(), ShouldEqual, 1)
+				}
+
+				created := &TestModel{}
+				So(db.Where(&TestModel{Value1: value}).FirstOrCreate(created).Error, ShouldBeNil)
+				So(created.ID, ShouldNotEqual, 0)
+				So(created.Value1, ShouldEqual, value)
+
+				found := &TestModel{}
+				So(db.Where(&TestModel{Value1: value}).FirstOrCreate(found).Error, ShouldBeNil)
+				So(found.ID, ShouldEqual, created.ID)
+
+				var count int64
+				So(originalDB.Model(&TestModel{}).Where("value1 = ?", value).Count(&count).Error, ShouldBeNil)
+				So(count, ShouldEqual, 1)
+
+				byID := &TestModel{}
+				So(db.Where("id = ?", created.ID).First(byID).Error, ShouldBeNil)
+				So(byID.Value1, ShouldEqual, value)
+			})
+
+			Convey("create branch caches the created row", func() {
+				if level == config.CacheLevelOnlySearch {
+					return
+				}
+				id := int64(910000 + level)
+				defer originalDB.Delete(&TestModel{ID: id})
+
+				lookup := func() *TestModel {
+					model := &TestModel{}
+					So(db.Where(&TestModel{ID: id}).Attrs(TestModel{Value1: 5}).FirstOrCreate(model).Error, ShouldBeNil)
+					So(model.ID, ShouldEqual, id)
+					So(model.Value1, ShouldEqual, 5)
+					return model
+				}
+				lookup()
+				So(c.HitCounts().Primary, ShouldEqual, 0)
+				lookup()
+				So(c.HitCounts().Primary, ShouldEqual, 1)
+
+				byID := &TestModel{}
+				So(db.Where("id = ?", id).First(byID).Error, ShouldBeNil)
+				So(byID.Value1, ShouldEqual, 5)
+				So(c.HitCounts().Primary, ShouldEqual, 2)
+			})
+		})
+	}
+}
@@ -19,6 +19,8 @@ func TestPrimaryCacheFunctionality(t *testing.T) {
 		testPrimaryUpdate(primaryCache, primaryDB)
 
 		testPrimaryDelete(primaryCache, primaryDB)
+
+		testPrimaryCreateThenDelete(primaryCache, primaryDB)
 	})
 }
 
@@ -35,5 +37,7 @@ func TestSearchCacheFunctionality(t *testing.T) {
 		testSearchDelete(searchCache, searchDB)
 
 		testSearchUpdate(searchCache, searchDB)
+
+		testSearchPluck(searchCache, searchDB)
 	})
 }
@@ -0,0 +1,51 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGcacheTTL(t *testing.T) {
+	Convey("test the ttl of Gcache is in milliseconds", t, func() {
+		ctx := context.Background()
+		s := storage.NewGcache(gcache.New(100))
+		So(s.Init(&storage.Config{TTL: 1000}), ShouldBeNil)
+		So(s.SetKey(ctx, util.Kv{Key: "key", Value: "value"}), ShouldBeNil)
+
+		// read as microseconds the key expired after 1ms
+		time.Sleep(50 * time.Millisecond)
+		value, err := s.GetValue(ctx, "key")
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, "value")
+	})
+}
+
+func TestGcacheBatchSetKeys(t *testing.T) {
+	Convey("test Gcache.BatchSetKeys sets all keys without deadlocking", t, func() {
+		ctx := context.Background()
+		s := storage.NewGcache(gcache.New(100))
+		So(s.Init(&storage.Config{TTL: 1000}), ShouldBeNil)
+
+		done := make(chan error, 1)
+		go func() {
+			done <- s.BatchSetKeys(ctx, []util.Kv{{Key: "key1", Value: "value1"}, {Key: "key2", Value: "value2"}})
+		}()
+		var err error
+		select {
+		case err = <-done:
+		case <-time.After(time.Second):
+			err = context.DeadlineExceeded
+		}
+		So(err, ShouldBeNil)
+
+		values, err := s.BatchGetValues(ctx, []string{"key1", "key2"})
+		So(err, ShouldBeNil)
+		So(values, ShouldResemble, []string{"value1", "value2"})
+	})
+}
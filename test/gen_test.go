@@ -0,0 +1,106 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// testModelDo mimics the DO gorm.io/gen generates for TestModel: it wraps a *gorm.DB on the model, each call
+// returning a DO of its own, and its fields build table qualified clause expressions
+type testModelDo struct {
+	db *gorm.DB
+}
+
+func newTestModelDo(db *gorm.DB) *testModelDo {
+	return &testModelDo{db: db.Model(&TestModel{})}
+}
+
+func (d *testModelDo) UnderlyingDB() *gorm.DB { return d.db }
+
+func (d *testModelDo) ReplaceDB(db *gorm.DB) { d.db = db }
+
+func (d *testModelDo) WithContext(ctx context.Context) *testModelDo {
+	return &testModelDo{db: d.db.WithContext(ctx)}
+}
+
+func (d *testModelDo) Where(conds ...clause.Expression) *testModelDo {
+	return &testModelDo{db: d.db.Clauses(clause.Where{Exprs: conds})}
+}
+
+func (d *testModelDo) First() (*TestModel, error) {
+	model := &TestModel{}
+	return model, d.db.First(model).Error
+}
+
+func (d *testModelDo) Find() ([]*TestModel, error) {
+	models := make([]*TestModel, 0)
+	return models, d.db.Find(&models).Error
+}
+
+func genColumn(name string) clause.Column {
+	return clause.Column{Table: TestModelTableName, Name: name}
+}
+
+func TestGenQueries(t *testing.T) {
+	Convey("test queries of the code generated by gorm gen are cached and take per-query options", t, func() {
+		clock := storage.NewManualClock(time.Unix(0, 0))
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelAll,
+			CacheStorage: storage.NewMemSync(clock),
+			CacheTTL:     5000,
+		})
+		So(err, ShouldBeNil)
+		q := newTestModelDo(db)
+		ctx := context.Background()
+
+		for i := 0; i < 2; i++ {
+			model, err := q.WithContext(ctx).Where(clause.Eq{Column: genColumn("id"), Value: 61}).First()
+			So(err, ShouldBeNil)
+			So(model.Value1, ShouldEqual, 61)
+		}
+		So(c.HitCounts().Primary, ShouldEqual, 1)
+		for i := 0; i < 2; i++ {
+			models, err := q.WithContext(ctx).Where(clause.IN{Column: genColumn("id"), Values: []interface{}{62, 63}}).Find()
+			So(err, ShouldBeNil)
+			So(len(models), ShouldEqual, 2)
+		}
+		So(c.HitCounts().Primary, ShouldEqual, 2)
+		for i := 0; i < 2; i++ {
+			models, err := q.WithContext(ctx).Where(clause.Gte{Column: genColumn("value1"), Value: 198}).Find()
+			So(err, ShouldBeNil)
+			So(len(models), ShouldEqual, 3)
+		}
+		So(c.HitCounts().Search, ShouldEqual, 1)
+
+		_, err = cache.GenOptions(q.WithContext(ctx), cache.NoCache).Where(clause.Eq{Column: genColumn("id"), Value: 61}).First()
+		So(err, ShouldBeNil)
+		_, err = cache.GenOptions(q.WithContext(ctx), cache.DisableCache).Where(clause.Eq{Column: genColumn("id"), Value: 61}).First()
+		So(err, ShouldBeNil)
+		So(c.HitCount(), ShouldEqual, 3)
+
+		_, err = cache.GenOptions(q.WithContext(ctx), cache.CacheOnly).Where(clause.Eq{Column: genColumn("id"), Value: 64}).First()
+		So(err, ShouldEqual, util.ErrCacheOnlyMiss)
+
+		withTTL := func(db *gorm.DB) *gorm.DB { return cache.WithTTL(db, time.Minute) }
+		for i := 0; i < 2; i++ {
+			_, err = cache.GenOptions(q.WithContext(ctx), withTTL).Where(clause.Gte{Column: genColumn("value1"), Value: 199}).Find()
+			So(err, ShouldBeNil)
+			clock.Advance(10 * time.Second)
+		}
+		So(c.HitCounts().Search, ShouldEqual, 2)
+
+		// the shared DO is left as it was, its entries of the CacheTTL expired
+		_, err = q.WithContext(ctx).Where(clause.Eq{Column: genColumn("id"), Value: 61}).First()
+		So(err, ShouldBeNil)
+		So(c.HitCount(), ShouldEqual, 4)
+	})
+}
@@ -0,0 +1,131 @@
+package test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGetOrSetPrimary(t *testing.T) {
+	Convey("test get or set primary runs the loader once for concurrent callers", t, func() {
+		c, _, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelOnlyPrimary,
+			CacheStorage: storage.NewGcache(gcache.New(1000)),
+			CacheTTL:     5000,
+		})
+		So(err, ShouldBeNil)
+		gc := asGorm2Cache(c)
+		ctx := context.Background()
+
+		var loads int64
+		loader := func() (string, error) {
+			atomic.AddInt64(&loads, 1)
+			time.Sleep(50 * time.Millisecond)
+			return `{"id":7}`, nil
+		}
+
+		callers := 20
+		values := make([]string, callers)
+		errs := make([]error, callers)
+		var wg sync.WaitGroup
+		wg.Add(callers)
+		for i := 0; i < callers; i++ {
+			go func(i int) {
+				defer wg.Done()
+				values[i], errs[i] = gc.GetOrSetPrimary(ctx, "gorm_cache_model", "7", loader)
+			}(i)
+		}
+		wg.Wait()
+
+		So(atomic.LoadInt64(&loads), ShouldEqual, 1)
+		for i := 0; i < callers; i++ {
+			So(errs[i], ShouldBeNil)
+			So(values[i], ShouldEqual, `{"id":7}`)
+		}
+
+		exists, err := gc.BatchPrimaryKeyExists(ctx, "gorm_cache_model", []string{"7"})
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeTrue)
+
+		value, err := gc.GetOrSetPrimary(ctx, "gorm_cache_model", "7", loader)
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, `{"id":7}`)
+		So(atomic.LoadInt64(&loads), ShouldEqual, 1)
+	})
+}
+
+func TestStoreLoadModel(t *testing.T) {
+	Convey("test models stored and loaded outside gorm share the primary cache with queries", t, func() {
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelOnlyPrimary,
+			CacheStorage: storage.NewMemSync(nil),
+			CacheTTL:     5000,
+		})
+		So(err, ShouldBeNil)
+		gc := asGorm2Cache(c)
+		ctx := context.Background()
+
+		// stored rows are served to queries
+		So(gc.StoreModel(ctx, &TestModel{ID: 71, Value1: 710, Value2: 71}), ShouldBeNil)
+		model := &TestModel{}
+		So(db.Where("id = ?", 71).First(model).Error, ShouldBeNil)
+		So(model.Value1, ShouldEqual, 710)
+		So(c.HitCount(), ShouldEqual, 1)
+
+		// rows cached by queries are loaded
+		So(db.Where("id = ?", 72).First(&TestModel{}).Error, ShouldBeNil)
+		loaded := &TestModel{}
+		So(gc.LoadModel(ctx, loaded, 72), ShouldBeNil)
+		So(loaded.ID, ShouldEqual, 72)
+		So(loaded.Value1, ShouldEqual, 72)
+		So(c.HitCount(), ShouldEqual, 2)
+
+		So(gc.LoadModel(ctx, &TestModel{}, 73), ShouldEqual, storage.ErrCacheNotFound)
+		So(gc.LoadModel(ctx, &TestModel{}, 72, 1), ShouldNotBeNil)
+		So(gc.LoadModel(ctx, &[]TestModel{}, 72), ShouldNotBeNil)
+	})
+}
+
+func TestTypedHelpers(t *testing.T) {
+	Convey("test the typed helpers query through the cache", t, func() {
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelAll,
+			CacheStorage: storage.NewMemSync(nil),
+			CacheTTL:     5000,
+		})
+		So(err, ShouldBeNil)
+		gc := asGorm2Cache(c)
+		ctx := context.Background()
+
+		for i := 0; i < 2; i++ {
+			models, err := cache.FindCached[TestModel](ctx, db.Where("value1 BETWEEN ? AND ?", 81, 83).Order("id"), gc)
+			So(err, ShouldBeNil)
+			So(len(models), ShouldEqual, 3)
+			So(models[2].Value2, ShouldEqual, 83)
+		}
+		So(c.HitCount(), ShouldEqual, 1)
+
+		for i := 0; i < 2; i++ {
+			model, err := cache.FirstCached[TestModel](ctx, nil, gc, "id = ?", 84)
+			So(err, ShouldBeNil)
+			So(model.Value1, ShouldEqual, 84)
+		}
+		So(c.HitCount(), ShouldEqual, 2)
+
+		model, err := cache.LoadCached[TestModel](ctx, gc, 84)
+		So(err, ShouldBeNil)
+		So(model.ID, ShouldEqual, 84)
+
+		_, err = cache.FirstCached[TestModel](ctx, originalDB, gc, "id = ?", 84)
+		So(err, ShouldEqual, util.ErrNotAttached)
+	})
+}
@@ -0,0 +1,166 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHooks(t *testing.T) {
+	Convey("test hooks observe hits, misses, invalidations and errors", t, func() {
+		var mu sync.Mutex
+		events := make([]string, 0)
+		keys := make([]string, 0)
+		record := func(event string, eventKeys ...string) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, event)
+			keys = append(keys, eventKeys...)
+		}
+		var hookErr error
+
+		store := &failingDeleteStorage{DataStorage: storage.NewGcache(gcache.New(1000))}
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelOnlySearch,
+			CacheStorage: store,
+			CacheTTL:     5000,
+			Hooks: config.Hooks{
+				OnHit: func(ctx context.Context, table string, key string) {
+					record("hit:"+table, key)
+				},
+				OnMiss: func(ctx context.Context, table string, key string) {
+					record("miss:"+table, key)
+				},
+				OnInvalidate: func(ctx context.Context, table string, keys []string) {
+					record("invalidate:"+table, keys...)
+				},
+				OnError: func(ctx context.Context, err error) {
+					record("error")
+					hookErr = err
+				},
+			},
+		})
+		So(err, ShouldBeNil)
+		gorm2Cache := asGorm2Cache(c)
+		ctx := context.Background()
+
+		for i := 0; i < 2; i++ {
+			models := make([]TestModel, 0)
+			So(db.Where("value1 BETWEEN ? AND ?", 16, 19).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 4)
+		}
+		So(events, ShouldResemble, []string{"miss:" + TestModelTableName, "hit:" + TestModelTableName})
+		So(keys[0], ShouldEqual, keys[1])
+		So(strings.Contains(keys[0], TestModelTableName), ShouldBeTrue)
+
+		So(gorm2Cache.InvalidateSearchCache(ctx, TestModelTableName), ShouldBeNil)
+		So(gorm2Cache.BatchInvalidatePrimaryCache(ctx, TestModelTableName, []string{"16", "17"}), ShouldBeNil)
+		So(events[2:], ShouldResemble, []string{"invalidate:" + TestModelTableName, "invalidate:" + TestModelTableName})
+		So(len(keys), ShouldEqual, 5)
+		So(strings.HasSuffix(keys[2], ":"), ShouldBeTrue)
+
+		atomic.StoreInt32(&store.failing, 1)
+		So(gorm2Cache.InvalidateSearchCache(ctx, TestModelTableName), ShouldNotBeNil)
+		So(events[4:], ShouldResemble, []string{"error"})
+		So(errors.Is(hookErr, errDeleteFailed), ShouldBeTrue)
+	})
+
+	Convey("test the set hook observes the keys written to the cache", t, func() {
+		var mu sync.Mutex
+		missed, set := "", make([]string, 0)
+		_, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelOnlySearch,
+			CacheStorage: storage.NewGcache(gcache.New(1000)),
+			CacheTTL:     5000,
+			Hooks: config.Hooks{
+				OnMiss: func(ctx context.Context, table string, key string) {
+					missed = key
+				},
+				OnSet: func(ctx context.Context, table string, keys []string) {
+					mu.Lock()
+					defer mu.Unlock()
+					if table == TestModelTableName {
+						set = append(set, keys...)
+					}
+				},
+			},
+		})
+		So(err, ShouldBeNil)
+
+		models := make([]TestModel, 0)
+		So(db.Where("value1 BETWEEN ? AND ?", 26, 29).Find(&models).Error, ShouldBeNil)
+		So(len(models), ShouldEqual, 4)
+		setKeys := func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]string(nil), set...)
+		}
+		for start := time.Now(); len(setKeys()) == 0 && time.Since(start) < time.Second; {
+			time.Sleep(10 * time.Millisecond)
+		}
+		So(setKeys(), ShouldResemble, []string{missed})
+	})
+
+	Convey("test a panicking hook doesn't fail the query", t, func() {
+		var calls int64
+		_, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelOnlySearch,
+			CacheStorage: storage.NewGcache(gcache.New(1000)),
+			CacheTTL:     5000,
+			Hooks: config.Hooks{
+				OnMiss: func(ctx context.Context, table string, key string) {
+					atomic.AddInt64(&calls, 1)
+					panic("buggy observer")
+				},
+			},
+		})
+		So(err, ShouldBeNil)
+
+		models := make([]TestModel, 0)
+		So(db.Where("value1 BETWEEN ? AND ?", 16, 19).Find(&models).Error, ShouldBeNil)
+		So(len(models), ShouldEqual, 4)
+		So(atomic.LoadInt64(&calls), ShouldEqual, 1)
+	})
+}
+
+func TestOperationHook(t *testing.T) {
+	Convey("test operations are timed for OnOperation without a tracer", t, func() {
+		var mu sync.Mutex
+		operations := make(map[string]int)
+		_, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelOnlySearch,
+			CacheStorage: storage.NewGcache(gcache.New(1000)),
+			CacheTTL:     5000,
+			Hooks: config.Hooks{
+				OnOperation: func(ctx context.Context, operation string, table string, elapsed time.Duration, err error) {
+					mu.Lock()
+					defer mu.Unlock()
+					if table == TestModelTableName && elapsed >= 0 && err == nil {
+						operations[operation]++
+					}
+				},
+			},
+		})
+		So(err, ShouldBeNil)
+
+		for i := 0; i < 2; i++ {
+			models := make([]TestModel, 0)
+			So(db.Where("value1 BETWEEN ? AND ?", 61, 63).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 3)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		So(operations["gorm-cache.query"], ShouldEqual, 2)
+		So(operations["gorm-cache.search.get"], ShouldEqual, 2)
+		So(operations["gorm-cache.search.set"], ShouldEqual, 1)
+	})
+}
@@ -0,0 +1,42 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestHotKeyRefresh(t *testing.T) {
+	Convey("test the hottest search keys are refreshed before they expire", t, func() {
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:    config.CacheLevelOnlySearch,
+			CacheStorage:  storage.NewMemSync(nil),
+			CacheTTL:      300,
+			HotKeyRefresh: config.HotKeyRefresh{TopN: 1, Interval: 100},
+		})
+		So(err, ShouldBeNil)
+		defer asGorm2Cache(c).Close()
+		search := func(db *gorm.DB, from int) {
+			models := make([]TestModel, 0)
+			So(db.Where("value1 BETWEEN ? AND ?", from, from+2).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 3)
+		}
+
+		search(db, 171)
+		search(db, 181)
+		search(db, 181)
+		So(c.MissCount(), ShouldEqual, 2)
+
+		// the hot query outlives its ttl without missing, the cold one expires
+		for start := time.Now(); time.Since(start) < 700*time.Millisecond; time.Sleep(40 * time.Millisecond) {
+			search(db, 171)
+		}
+		So(c.MissCount(), ShouldEqual, 2)
+		search(db, 181)
+		So(c.MissCount(), ShouldEqual, 3)
+	})
+}
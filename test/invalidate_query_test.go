@@ -0,0 +1,46 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestInvalidateSearchCacheByQuery(t *testing.T) {
+	for _, strategy := range []config.SearchInvalidationStrategy{config.SearchInvalidationDelete, config.SearchInvalidationVersion} {
+		Convey("test invalidating a single query leaves the other search cache entries of its table", t, func() {
+			c, db, err := newCacheDB(&config.CacheConfig{
+				CacheLevel:         config.CacheLevelOnlySearch,
+				CacheStorage:       storage.NewMemSync(nil),
+				CacheTTL:           5000,
+				SearchInvalidation: strategy,
+			})
+			So(err, ShouldBeNil)
+			ctx := context.Background()
+			search := func(db *gorm.DB, from int) *gorm.DB {
+				models := make([]TestModel, 0)
+				return db.Where("value1 BETWEEN ? AND ?", from, from+2).Find(&models)
+			}
+			So(search(db, 121).Error, ShouldBeNil)
+			So(search(db, 131).Error, ShouldBeNil)
+			So(search(db, 121).Error, ShouldBeNil)
+			So(search(db, 131).Error, ShouldBeNil)
+			So(c.HitCount(), ShouldEqual, 2)
+
+			statement := search(originalDB.Session(&gorm.Session{DryRun: true}), 121).Statement
+			err = asGorm2Cache(c).InvalidateSearchCacheByQuery(ctx, TestModelTableName, statement.SQL.String(), statement.Vars...)
+			So(err, ShouldBeNil)
+
+			So(search(db, 121).Error, ShouldBeNil)
+			So(c.HitCount(), ShouldEqual, 2)
+			So(search(db, 131).Error, ShouldBeNil)
+			So(c.HitCount(), ShouldEqual, 3)
+			So(search(db, 121).Error, ShouldBeNil)
+			So(c.HitCount(), ShouldEqual, 4)
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var errDeleteFailed = errors.New("delete failed")
+
+// failingDeleteStorage fails all deletions while failing is set
+type failingDeleteStorage struct {
+	storage.DataStorage
+	failing int32
+}
+
+func (s *failingDeleteStorage) fail() error {
+	if atomic.LoadInt32(&s.failing) == 1 {
+		return errDeleteFailed
+	}
+	return nil
+}
+
+func (s *failingDeleteStorage) DeleteKeysWithPrefix(ctx context.Context, keyPrefix string) error {
+	if err := s.fail(); err != nil {
+		return err
+	}
+	return s.DataStorage.DeleteKeysWithPrefix(ctx, keyPrefix)
+}
+
+func (s *failingDeleteStorage) DeleteKey(ctx context.Context, key string) error {
+	if err := s.fail(); err != nil {
+		return err
+	}
+	return s.DataStorage.DeleteKey(ctx, key)
+}
+
+func (s *failingDeleteStorage) BatchDeleteKeys(ctx context.Context, keys []string) error {
+	if err := s.fail(); err != nil {
+		return err
+	}
+	return s.DataStorage.BatchDeleteKeys(ctx, keys)
+}
+
+func TestInvalidationFailurePolicy(t *testing.T) {
+	Convey("test policies for failed invalidations", t, func() {
+		newDB := func(policy config.InvalidationFailurePolicy) (*failingDeleteStorage, func(id int64) int64, func(id, value int64) error) {
+			store := &failingDeleteStorage{DataStorage: storage.NewGcache(gcache.New(1000))}
+			_, db, err := newCacheDB(&config.CacheConfig{
+				CacheLevel:            config.CacheLevelOnlySearch,
+				CacheStorage:          store,
+				InvalidateWhenUpdate:  true,
+				CacheTTL:              5000,
+				OnInvalidationFailure: policy,
+			})
+			So(err, ShouldBeNil)
+
+			query := func(id int64) int64 {
+				models := make([]TestModel, 0)
+				So(db.Where("id = ?", id).Find(&models).Error, ShouldBeNil)
+				So(len(models), ShouldEqual, 1)
+				return models[0].Value8
+			}
+			update := func(id, value int64) error {
+				return db.Model(&TestModel{}).Where("id = ?", id).Update("value8", value).Error
+			}
+			return store, query, update
+		}
+
+		Convey("log only keeps the write and the stale entry", func() {
+			store, query, update := newDB(config.InvalidationFailureLogOnly)
+			defer originalDB.Model(&TestModel{}).Where("id = ?", 171).Update("value8", 171)
+
+			So(query(171), ShouldEqual, 171)
+			atomic.StoreInt32(&store.failing, 1)
+			So(update(171, 5171), ShouldBeNil)
+			atomic.StoreInt32(&store.failing, 0)
+
+			So(query(171), ShouldEqual, 171)
+		})
+
+		Convey("retry async eventually clears the stale entry", func() {
+			store, query, update := newDB(config.InvalidationFailureRetryAsync)
+			defer originalDB.Model(&TestModel{}).Where("id = ?", 172).Update("value8", 172)
+
+			So(query(172), ShouldEqual, 172)
+			atomic.StoreInt32(&store.failing, 1)
+			So(update(172, 5172), ShouldBeNil)
+			So(query(172), ShouldEqual, 172)
+
+			// the first retry fails too, a later one goes through
+			time.Sleep(150 * time.Millisecond)
+			atomic.StoreInt32(&store.failing, 0)
+
+			deadline := time.Now().Add(3 * time.Second)
+			value := query(172)
+			for value != 5172 && time.Now().Before(deadline) {
+				time.Sleep(50 * time.Millisecond)
+				value = query(172)
+			}
+			So(value, ShouldEqual, 5172)
+		})
+
+		Convey("fail write returns the error and rolls the write back", func() {
+			store, query, update := newDB(config.InvalidationFailureFailWrite)
+			defer originalDB.Model(&TestModel{}).Where("id = ?", 173).Update("value8", 173)
+
+			So(query(173), ShouldEqual, 173)
+			atomic.StoreInt32(&store.failing, 1)
+			err := update(173, 5173)
+			atomic.StoreInt32(&store.failing, 0)
+			So(errors.Is(err, util.ErrInvalidationFailed), ShouldBeTrue)
+
+			model := TestModel{}
+			So(originalDB.Where("id = ?", 173).First(&model).Error, ShouldBeNil)
+			So(model.Value8, ShouldEqual, 173)
+			So(query(173), ShouldEqual, 173)
+
+			So(update(173, 5173), ShouldBeNil)
+			So(query(173), ShouldEqual, 5173)
+		})
+	})
+}
@@ -0,0 +1,71 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTTLJitter(t *testing.T) {
+	Convey("test ttl jitter spreads the expiry of keys written together", t, func() {
+		const keys = 1000 // the default size of the memory storages
+		ctx := context.Background()
+
+		// writes the keys in one batch with a ttl of 1s, returning how many are live at the given offsets
+		liveAfter := func(jitter float64, offsets ...time.Duration) []int64 {
+			clock := storage.NewManualClock(time.Unix(0, 0))
+			c, _, err := newCacheDB(&config.CacheConfig{
+				CacheLevel:   config.CacheLevelOnlySearch,
+				CacheStorage: storage.NewMemSync(clock),
+				CacheTTL:     1000,
+				TTLJitter:    jitter,
+			})
+			So(err, ShouldBeNil)
+
+			kvs := make([]util.Kv, 0, keys)
+			for i := 0; i < keys; i++ {
+				kvs = append(kvs, util.Kv{Key: fmt.Sprintf("k%d", i), Value: "v"})
+			}
+			So(asGorm2Cache(c).BatchSetPrimaryKeyCache(ctx, "jitter", kvs), ShouldBeNil)
+
+			store := asGorm2Cache(c).Config.CacheStorage.(storage.KeyCounter)
+			live := make([]int64, 0, len(offsets))
+			var elapsed time.Duration
+			for _, offset := range offsets {
+				clock.Advance(offset - elapsed)
+				elapsed = offset
+				count, err := store.CountKeysWithPrefix(ctx, "")
+				So(err, ShouldBeNil)
+				live = append(live, count)
+			}
+			return live
+		}
+
+		Convey("no jitter expires the keys together", func() {
+			So(liveAfter(0, 999*time.Millisecond, time.Second), ShouldResemble, []int64{keys, 0})
+		})
+
+		Convey("jitter spreads the expiry uniformly around the ttl", func() {
+			// expiries are uniform over [500ms, 1500ms), the bounds allow for over 5 standard deviations
+			live := liveAfter(0.5, 499*time.Millisecond, 750*time.Millisecond, time.Second,
+				1250*time.Millisecond, 1500*time.Millisecond)
+			So(live[0], ShouldEqual, keys)
+			So(live[1], ShouldBeBetween, keys*3/4-70, keys*3/4+70)
+			So(live[2], ShouldBeBetween, keys/2-80, keys/2+80)
+			So(live[3], ShouldBeBetween, keys/4-70, keys/4+70)
+			So(live[4], ShouldEqual, 0)
+		})
+
+		Convey("no jitter keeps the default randomization of the storages", func() {
+			for i := 0; i < keys; i++ {
+				So(util.JitterInt64(1000, 0), ShouldBeBetweenOrEqual, 900, 1100)
+			}
+		})
+	})
+}
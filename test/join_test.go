@@ -0,0 +1,54 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestJoin(t *testing.T) {
+	Convey("test queries joining tables are invalidated by writes to any of them", t, func() {
+		So(originalDB.AutoMigrate(&AuthorModel{}, &BookModel{}), ShouldBeNil)
+		defer originalDB.Migrator().DropTable(&AuthorModel{}, &BookModel{})
+		So(originalDB.Create(&AuthorModel{ID: 1, Name: "a", Books: []BookModel{{ID: 1, Title: "b1"}, {ID: 2, Title: "b2"}}}).Error, ShouldBeNil)
+
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:                config.CacheLevelAll,
+			CacheStorage:              storage.NewMemSync(nil),
+			CacheTTL:                  5000,
+			InvalidateWhenUpdate:      true,
+			PreciseSearchInvalidation: true,
+		})
+		So(err, ShouldBeNil)
+		bookHits := func() uint64 {
+			return c.TableStats(BookModelTableName).Search.HitCount
+		}
+		booksOf := func(join string, name string) int {
+			models := make([]BookModel, 0)
+			So(db.Joins(join).Where("a.name = ?", name).Find(&models).Error, ShouldBeNil)
+			return len(models)
+		}
+		join := "JOIN " + AuthorModelTableName + " AS a ON a.id = " + BookModelTableName + ".author_id"
+
+		So(booksOf(join, "a"), ShouldEqual, 2)
+		So(booksOf(join, "a"), ShouldEqual, 2)
+		So(bookHits(), ShouldEqual, 1)
+
+		Convey("a write to the joined table drops the result", func() {
+			So(db.Model(&AuthorModel{ID: 1}).Update("name", "z").Error, ShouldBeNil)
+			So(booksOf(join, "a"), ShouldEqual, 0)
+			So(booksOf(join, "z"), ShouldEqual, 2)
+			So(booksOf(join, "z"), ShouldEqual, 2)
+			So(bookHits(), ShouldEqual, 2)
+		})
+
+		Convey("joins of tables that can't be parsed aren't cached", func() {
+			subquery := "JOIN (SELECT * FROM " + AuthorModelTableName + ") AS a ON a.id = " + BookModelTableName + ".author_id"
+			So(booksOf(subquery, "a"), ShouldEqual, 2)
+			So(booksOf(subquery, "a"), ShouldEqual, 2)
+			So(bookHits(), ShouldEqual, 1)
+		})
+	})
+}
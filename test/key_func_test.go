@@ -0,0 +1,101 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	"github.com/redis/go-redis/v9"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestKeyFuncs(t *testing.T) {
+	Convey("test keys are built by the configured key funcs", t, func() {
+		mr := miniredis.RunT(t)
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelAll,
+			CacheStorage: storage.NewRedis(&storage.RedisStoreConfig{Options: &redis.Options{Addr: mr.Addr()}}),
+			CacheTTL:     5000,
+			PrimaryKeyFunc: func(instanceId string, tableName string, primaryKey string) string {
+				return util.GenPrimaryCacheKey(instanceId, tableName, "v2:"+primaryKey)
+			},
+			SearchKeyFunc: func(instanceId string, tableName string, sql string, vars ...interface{}) string {
+				return util.GenSearchCacheKey(instanceId, tableName, "v2:"+sql, vars...)
+			},
+		})
+		So(err, ShouldBeNil)
+
+		query := func() {
+			models := make([]TestModel, 0)
+			So(db.Where("value1 BETWEEN ? AND ?", 121, 123).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 3)
+		}
+		query()
+		query()
+		So(c.HitCount(), ShouldEqual, 1)
+
+		keys := mr.Keys()
+		So(len(keys), ShouldEqual, 4)
+		for _, key := range keys {
+			So(key, ShouldContainSubstring, ":v2:")
+		}
+
+		// the keys stay under the prefixes of their table
+		gorm2Cache := asGorm2Cache(c)
+		ctx := context.Background()
+		So(gorm2Cache.InvalidateSearchCache(ctx, TestModelTableName), ShouldBeNil)
+		So(gorm2Cache.InvalidateAllPrimaryCache(ctx, TestModelTableName), ShouldBeNil)
+		So(mr.Keys(), ShouldBeEmpty)
+	})
+}
+
+func TestKeyGenerator(t *testing.T) {
+	Convey("test instances sharing a storage and an instance id are kept apart by their key generators", t, func() {
+		store := storage.NewMemSync(nil)
+		newDB := func(prefix string) (*gorm.DB, func() uint64, *cache.Gorm2Cache) {
+			c, db, err := newCacheDB(&config.CacheConfig{
+				CacheLevel:   config.CacheLevelOnlySearch,
+				CacheStorage: store,
+				CacheTTL:     5000,
+				InstanceId:   "shared",
+				KeyGenerator: util.PrefixKeyGenerator(prefix),
+			})
+			So(err, ShouldBeNil)
+			return db, c.HitCount, asGorm2Cache(c)
+		}
+		dbA, hitsA, cacheA := newDB("app1")
+		dbB, hitsB, _ := newDB("app2")
+
+		query := func(db *gorm.DB) {
+			models := make([]TestModel, 0)
+			So(db.Where("value1 BETWEEN ? AND ?", 124, 126).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 3)
+		}
+		count := func(prefix string) int64 {
+			keys := util.NewKeys(util.PrefixKeyGenerator(prefix))
+			count, err := store.CountKeysWithPrefix(context.Background(), keys.SearchCachePrefix("shared", TestModelTableName))
+			So(err, ShouldBeNil)
+			return count
+		}
+
+		query(dbA)
+		query(dbB)
+		query(dbA)
+		query(dbB)
+		So(hitsA(), ShouldEqual, 1)
+		So(hitsB(), ShouldEqual, 1)
+		So(count("app1"), ShouldEqual, 1)
+		So(count("app2"), ShouldEqual, 1)
+
+		So(cacheA.InvalidateSearchCache(context.Background(), TestModelTableName), ShouldBeNil)
+		So(count("app1"), ShouldEqual, 0)
+		So(count("app2"), ShouldEqual, 1)
+		query(dbB)
+		So(hitsB(), ShouldEqual, 2)
+	})
+}
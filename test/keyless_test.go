@@ -0,0 +1,143 @@
+package test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// keyRecordingStorage records the keys and key prefixes of all operations
+type keyRecordingStorage struct {
+	storage.DataStorage
+	mu   sync.Mutex
+	keys []string
+}
+
+func (s *keyRecordingStorage) record(keys ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = append(s.keys, keys...)
+}
+
+func (s *keyRecordingStorage) recordedWithPrefix(prefix string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	matched := make([]string, 0)
+	for _, key := range s.keys {
+		if strings.HasPrefix(key, prefix) {
+			matched = append(matched, key)
+		}
+	}
+	return matched
+}
+
+func (s *keyRecordingStorage) BatchKeyExist(ctx context.Context, keys []string) (bool, error) {
+	s.record(keys...)
+	return s.DataStorage.BatchKeyExist(ctx, keys)
+}
+
+func (s *keyRecordingStorage) KeyExists(ctx context.Context, key string) (bool, error) {
+	s.record(key)
+	return s.DataStorage.KeyExists(ctx, key)
+}
+
+func (s *keyRecordingStorage) GetValue(ctx context.Context, key string) (string, error) {
+	s.record(key)
+	return s.DataStorage.GetValue(ctx, key)
+}
+
+func (s *keyRecordingStorage) BatchGetValues(ctx context.Context, keys []string) ([]string, error) {
+	s.record(keys...)
+	return s.DataStorage.BatchGetValues(ctx, keys)
+}
+
+func (s *keyRecordingStorage) DeleteKeysWithPrefix(ctx context.Context, keyPrefix string) error {
+	s.record(keyPrefix)
+	return s.DataStorage.DeleteKeysWithPrefix(ctx, keyPrefix)
+}
+
+func (s *keyRecordingStorage) DeleteKey(ctx context.Context, key string) error {
+	s.record(key)
+	return s.DataStorage.DeleteKey(ctx, key)
+}
+
+func (s *keyRecordingStorage) BatchDeleteKeys(ctx context.Context, keys []string) error {
+	s.record(keys...)
+	return s.DataStorage.BatchDeleteKeys(ctx, keys)
+}
+
+func (s *keyRecordingStorage) BatchSetKeys(ctx context.Context, kvs []util.Kv) error {
+	for _, kv := range kvs {
+		s.record(kv.Key)
+	}
+	return s.DataStorage.BatchSetKeys(ctx, kvs)
+}
+
+func (s *keyRecordingStorage) SetKey(ctx context.Context, kv util.Kv) error {
+	s.record(kv.Key)
+	return s.DataStorage.SetKey(ctx, kv)
+}
+
+func TestKeylessModel(t *testing.T) {
+	Convey("test models without primary key skip the primary cache", t, func() {
+		So(originalDB.Exec("CREATE VIEW IF NOT EXISTS gorm_cache_model_view AS SELECT value1, value9 FROM gorm_cache_model").Error, ShouldBeNil)
+		defer originalDB.Exec("DROP VIEW gorm_cache_model_view")
+		So(originalDB.AutoMigrate(&KeylessLinkModel{}), ShouldBeNil)
+		defer originalDB.Migrator().DropTable(&KeylessLinkModel{})
+
+		store := &keyRecordingStorage{DataStorage: storage.NewGcache(gcache.New(1000))}
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:           config.CacheLevelAll,
+			CacheStorage:         store,
+			InvalidateWhenUpdate: true,
+			CacheTTL:             5000,
+		})
+		So(err, ShouldBeNil)
+		instanceId := asGorm2Cache(c).InstanceId
+
+		Convey("views are search cached", func() {
+			query := func() {
+				rows := make([]KeylessViewModel, 0)
+				So(db.Where("value1 BETWEEN ? AND ?", 151, 153).Find(&rows).Error, ShouldBeNil)
+				So(len(rows), ShouldEqual, 3)
+				So(rows[0].Value9, ShouldEqual, "151")
+			}
+			query()
+			query()
+			So(c.HitCount(), ShouldEqual, 1)
+
+			So(store.recordedWithPrefix(util.GenPrimaryCachePrefix(instanceId, KeylessViewModelTableName)), ShouldBeEmpty)
+			So(store.recordedWithPrefix(util.GenSearchCachePrefix(instanceId, KeylessViewModelTableName)), ShouldNotBeEmpty)
+		})
+
+		Convey("writes invalidate the search cache only", func() {
+			query := func() int {
+				links := make([]KeylessLinkModel, 0)
+				So(db.Where("left_id = ?", 1).Find(&links).Error, ShouldBeNil)
+				return len(links)
+			}
+			So(query(), ShouldEqual, 0)
+
+			So(db.Create(&[]KeylessLinkModel{{LeftID: 1, RightID: 2}, {LeftID: 1, RightID: 3}}).Error, ShouldBeNil)
+			So(query(), ShouldEqual, 2)
+			So(query(), ShouldEqual, 2)
+
+			So(db.Model(&KeylessLinkModel{}).Where("left_id = ? AND right_id = ?", 1, 3).Update("right_id", 4).Error, ShouldBeNil)
+			links := make([]KeylessLinkModel, 0)
+			So(db.Where("right_id = ?", 4).Find(&links).Error, ShouldBeNil)
+			So(len(links), ShouldEqual, 1)
+
+			So(db.Where("left_id = ?", 1).Delete(&KeylessLinkModel{}).Error, ShouldBeNil)
+			So(query(), ShouldEqual, 0)
+
+			So(store.recordedWithPrefix(util.GenPrimaryCachePrefix(instanceId, KeylessLinkModelTableName)), ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,91 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm/clause"
+)
+
+func TestLockedReads(t *testing.T) {
+	Convey("test locking reads and the cache", t, func() {
+		newDB := func(conf *config.CacheConfig) func(strength string) uint64 {
+			conf.CacheLevel = config.CacheLevelAll
+			conf.CacheStorage = storage.NewGcache(gcache.New(1000))
+			conf.CacheTTL = 5000
+			c, db, err := newCacheDB(conf)
+			So(err, ShouldBeNil)
+
+			// queries twice with the given lock strength, returning the hits. SQLite renders no
+			// locking clause, so each strength reads its own rows to keep the queries apart
+			return func(strength string) uint64 {
+				base := map[string]int64{"UPDATE": 30, "SHARE": 35}[strength]
+				before := c.HitCount()
+				for i := 0; i < 2; i++ {
+					models := make([]TestModel, 0)
+					err := db.Clauses(clause.Locking{Strength: strength}).Where("value1 BETWEEN ? AND ?", base+1, base+3).Find(&models).Error
+					So(err, ShouldBeNil)
+					So(len(models), ShouldEqual, 3)
+
+					model := TestModel{}
+					So(db.Clauses(clause.Locking{Strength: strength}).Where("id = ?", base+4).First(&model).Error, ShouldBeNil)
+					So(model.Value1, ShouldEqual, base+4)
+				}
+				return c.HitCount() - before
+			}
+		}
+
+		Convey("bypass the cache by default", func() {
+			lockedQuery := newDB(&config.CacheConfig{})
+			So(lockedQuery("UPDATE"), ShouldEqual, 0)
+			So(lockedQuery("SHARE"), ShouldEqual, 0)
+		})
+
+		Convey("use the cache if enabled", func() {
+			lockedQuery := newDB(&config.CacheConfig{CacheLockedReads: true})
+			So(lockedQuery("UPDATE"), ShouldEqual, 2)
+			So(lockedQuery("SHARE"), ShouldEqual, 2)
+		})
+
+		Convey("are controlled per strength", func() {
+			lockedQuery := newDB(&config.CacheConfig{CacheLockedReadsByStrength: map[string]bool{"share": true}})
+			So(lockedQuery("UPDATE"), ShouldEqual, 0)
+			So(lockedQuery("SHARE"), ShouldEqual, 2)
+
+			lockedQuery = newDB(&config.CacheConfig{
+				CacheLockedReads:           true,
+				CacheLockedReadsByStrength: map[string]bool{"UPDATE": false},
+			})
+			So(lockedQuery("UPDATE"), ShouldEqual, 0)
+			So(lockedQuery("SHARE"), ShouldEqual, 2)
+		})
+
+		Convey("bypassing the cache are counted and leave nothing cached", func() {
+			c, db, err := newCacheDB(&config.CacheConfig{
+				CacheLevel:   config.CacheLevelAll,
+				CacheStorage: storage.NewGcache(gcache.New(1000)),
+				CacheTTL:     5000,
+			})
+			So(err, ShouldBeNil)
+			gc := asGorm2Cache(c)
+
+			model := TestModel{}
+			So(db.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", 40).First(&model).Error, ShouldBeNil)
+			So(db.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", 40).First(&model).Error, ShouldBeNil)
+			So(gc.LockedReadBypassCount(), ShouldEqual, 2)
+			So(gc.StatsSnapshot().LockedBypasses, ShouldEqual, 2)
+
+			So(db.Where("id = ?", 40).First(&model).Error, ShouldBeNil)
+			So(c.HitCount(), ShouldEqual, 0)
+			So(c.MissCount(), ShouldEqual, 1)
+			So(gc.LockedReadBypassCount(), ShouldEqual, 2)
+
+			gc.ResetCounter(cache.CounterLockedReadBypasses)
+			So(gc.LockedReadBypassCount(), ShouldEqual, 0)
+		})
+	})
+}
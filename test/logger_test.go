@@ -0,0 +1,145 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm/logger"
+)
+
+type logEntry struct {
+	level  util.Level
+	msg    string
+	fields []util.Field
+}
+
+// entryRecorder records the entries logged through a FuncLogger
+type entryRecorder struct {
+	mu      sync.Mutex
+	entries []logEntry
+}
+
+func (r *entryRecorder) log(_ context.Context, level util.Level, msg string, fields []util.Field) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, logEntry{level: level, msg: msg, fields: fields})
+}
+
+func (r *entryRecorder) events(msg string) []logEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := make([]logEntry, 0)
+	for _, entry := range r.entries {
+		if entry.msg == msg {
+			events = append(events, entry)
+		}
+	}
+	return events
+}
+
+// fakeSugaredLogger records the calls of the zap adapter
+type fakeSugaredLogger struct {
+	lines []string
+}
+
+func (l *fakeSugaredLogger) logw(level string, msg string, keysAndValues ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf("%s %s %v", level, msg, keysAndValues))
+}
+
+func (l *fakeSugaredLogger) Debugw(msg string, keysAndValues ...interface{}) {
+	l.logw("debug", msg, keysAndValues...)
+}
+
+func (l *fakeSugaredLogger) Infow(msg string, keysAndValues ...interface{}) {
+	l.logw("info", msg, keysAndValues...)
+}
+
+func (l *fakeSugaredLogger) Warnw(msg string, keysAndValues ...interface{}) {
+	l.logw("warn", msg, keysAndValues...)
+}
+
+func (l *fakeSugaredLogger) Errorw(msg string, keysAndValues ...interface{}) {
+	l.logw("error", msg, keysAndValues...)
+}
+
+// lineWriter collects the lines printed by a gorm logger
+type lineWriter struct {
+	lines []string
+}
+
+func (w *lineWriter) Printf(format string, v ...interface{}) {
+	w.lines = append(w.lines, fmt.Sprintf(format, v...))
+}
+
+func TestEventLogging(t *testing.T) {
+	Convey("test hits, misses and invalidations are logged at their levels outside of debug mode", t, func() {
+		recorder := &entryRecorder{}
+		_, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:           config.CacheLevelAll,
+			CacheStorage:         storage.NewMemSync(nil),
+			CacheTTL:             5000,
+			InvalidateWhenUpdate: true,
+			DebugLogger:          util.NewFuncLogger(recorder.log),
+			EventLogLevels: config.EventLogLevels{
+				Hit:        util.LevelDebug,
+				Invalidate: util.LevelWarn,
+			},
+		})
+		So(err, ShouldBeNil)
+
+		for i := 0; i < 2; i++ {
+			So(db.Where("id = ?", 66).First(&TestModel{}).Error, ShouldBeNil)
+		}
+		hits := recorder.events("cache hit")
+		So(len(hits), ShouldEqual, 1)
+		So(hits[0].level, ShouldEqual, util.LevelDebug)
+		So(hits[0].fields[0], ShouldResemble, util.Field{Key: "table", Value: TestModelTableName})
+		So(hits[0].fields[1].Key, ShouldEqual, "key")
+		So(recorder.events("cache miss"), ShouldBeEmpty)
+
+		So(db.Model(&TestModel{ID: 66}).Update("value2", 66).Error, ShouldBeNil)
+		invalidations := recorder.events("cache invalidate")
+		So(len(invalidations), ShouldBeGreaterThan, 0)
+		So(invalidations[0].level, ShouldEqual, util.LevelWarn)
+		// the access log of debug mode is off
+		for _, entry := range recorder.entries {
+			So(entry.msg, ShouldStartWith, "cache ")
+		}
+	})
+
+	Convey("test the adapters of other loggers", t, func() {
+		ctx := context.Background()
+		fields := []util.Field{{Key: "table", Value: "t"}, {Key: "key", Value: "k"}}
+
+		sugared := &fakeSugaredLogger{}
+		zapLogger := util.NewZapLogger(sugared)
+		zapLogger.CtxLog(ctx, util.LevelInfo, "cache hit", fields...)
+		zapLogger.CtxLog(ctx, util.LevelOff, "cache miss", fields...)
+		zapLogger.CtxInfo(ctx, "access %d", 1)
+		zapLogger.SetIsDebug(true)
+		zapLogger.CtxInfo(ctx, "access %d", 2)
+		zapLogger.CtxError(ctx, "failed %d", 3)
+		So(sugared.lines, ShouldResemble, []string{
+			"info cache hit [table t key k]",
+			"debug access 2 []",
+			"error failed 3 []",
+		})
+
+		writer := &lineWriter{}
+		gormLogger := util.NewGormLogger(logger.New(writer, logger.Config{LogLevel: logger.Warn}))
+		gormLogger.CtxLog(ctx, util.LevelInfo, "cache hit", fields...)
+		gormLogger.CtxLog(ctx, util.LevelWarn, "cache invalidate", fields...)
+		gormLogger.CtxError(ctx, "failed %s", "100%")
+		So(len(writer.lines), ShouldEqual, 2)
+		So(writer.lines[0], ShouldEndWith, "cache invalidate table=t key=k")
+		So(writer.lines[1], ShouldEndWith, "failed 100%")
+		So(strings.Contains(writer.lines[0], "[warn]"), ShouldBeTrue)
+	})
+}
@@ -0,0 +1,97 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCacheMaxItemCnt(t *testing.T) {
+	Convey("test results with more rows than CacheMaxItemCnt are not cached", t, func() {
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:      config.CacheLevelOnlySearch,
+			CacheStorage:    storage.NewGcache(gcache.New(1000)),
+			CacheTTL:        5000,
+			CacheMaxItemCnt: 3,
+		})
+		So(err, ShouldBeNil)
+
+		query := func(to int) {
+			models := make([]TestModel, 0)
+			So(db.Where("value2 BETWEEN ? AND ?", 11, to).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, to-10)
+		}
+
+		query(14)
+		query(14)
+		So(c.HitCount(), ShouldEqual, 0)
+		So(asGorm2Cache(c).SkippedTooLargeCount(), ShouldEqual, 2)
+
+		query(13)
+		query(13)
+		So(c.HitCount(), ShouldEqual, 1)
+	})
+}
+
+func TestMaxCacheValueBytes(t *testing.T) {
+	Convey("test results and rows larger than MaxCacheValueBytes are not cached", t, func() {
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:         config.CacheLevelAll,
+			CacheStorage:       storage.NewMemSync(nil),
+			CacheTTL:           5000,
+			MaxCacheValueBytes: 300,
+		})
+		So(err, ShouldBeNil)
+		gc := asGorm2Cache(c)
+
+		query := func(to int) {
+			models := make([]TestModel, 0)
+			So(db.Where("value2 BETWEEN ? AND ?", 21, to).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, to-20)
+		}
+
+		// the result of 5 rows is too large, each of its rows is not
+		query(25)
+		query(25)
+		So(c.HitCount(), ShouldEqual, 0)
+		So(gc.SkippedTooLargeCount(), ShouldEqual, 2)
+		So(db.Where("id = ?", 23).First(&TestModel{}).Error, ShouldBeNil)
+		So(gc.HitCounts().Primary, ShouldEqual, 1)
+
+		query(21)
+		query(21)
+		So(c.HitCount(), ShouldEqual, 2)
+		So(gc.SkippedTooLargeCount(), ShouldEqual, 2)
+	})
+}
+
+func TestMaxCacheRows(t *testing.T) {
+	Convey("test search results with more rows than MaxCacheRows are served but not cached", t, func() {
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelOnlySearch,
+			CacheStorage: storage.NewGcache(gcache.New(1000)),
+			CacheTTL:     5000,
+			MaxCacheRows: 3,
+		})
+		So(err, ShouldBeNil)
+
+		query := func(to int) {
+			models := make([]TestModel, 0)
+			So(db.Where("value2 BETWEEN ? AND ?", 31, to).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, to-30)
+		}
+
+		query(34)
+		query(34)
+		So(c.HitCount(), ShouldEqual, 0)
+		So(asGorm2Cache(c).SkippedTooLargeCount(), ShouldEqual, 2)
+		So(asGorm2Cache(c).ConfigSnapshot().MaxCacheRows, ShouldEqual, 3)
+
+		query(33)
+		query(33)
+		So(c.HitCount(), ShouldEqual, 1)
+	})
+}
@@ -0,0 +1,180 @@
+package test
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeMemcached serves storage.MemcachedClient from a map, ignoring expirations
+type fakeMemcached struct {
+	mu    sync.Mutex
+	items map[string][]byte
+}
+
+func newFakeMemcached() *fakeMemcached {
+	return &fakeMemcached{items: make(map[string][]byte)}
+}
+
+func (f *fakeMemcached) GetMulti(keys []string) (map[string][]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	values := make(map[string][]byte)
+	for _, key := range keys {
+		if value, ok := f.items[key]; ok {
+			values[key] = value
+		}
+	}
+	return values, nil
+}
+
+func (f *fakeMemcached) Set(key string, value []byte, _ int32) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.items[key] = value
+	return nil
+}
+
+func (f *fakeMemcached) Add(key string, value []byte, _ int32) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.items[key]; ok {
+		return false, nil
+	}
+	f.items[key] = value
+	return true, nil
+}
+
+func (f *fakeMemcached) Increment(key string, delta uint64) (uint64, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	value, ok := f.items[key]
+	if !ok {
+		return 0, false, nil
+	}
+	n, err := strconv.ParseUint(string(value), 10, 64)
+	if err != nil {
+		return 0, true, err
+	}
+	n += delta
+	f.items[key] = []byte(strconv.FormatUint(n, 10))
+	return n, true, nil
+}
+
+func (f *fakeMemcached) Delete(key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.items, key)
+	return nil
+}
+
+func (f *fakeMemcached) Ping() error {
+	return nil
+}
+
+func TestMemcachedStorage(t *testing.T) {
+	Convey("test the memcached storage", t, func() {
+		client := newFakeMemcached()
+		So(client.Set("foreign:key", []byte("kept"), 0), ShouldBeNil)
+
+		store := storage.NewMemcached(&storage.MemcachedStoreConfig{KeyPrefix: "app", Client: client})
+		So(store.Init(&storage.Config{TTL: 5000, Logger: &util.DefaultLogger{}}), ShouldBeNil)
+		ctx := context.Background()
+
+		Convey("keys are read, written and deleted", func() {
+			So(store.Ping(ctx), ShouldBeNil)
+			So(store.BatchSetKeys(ctx, []util.Kv{{Key: "k1", Value: "v1"}, {Key: "k 2", Value: "v2"}}), ShouldBeNil)
+
+			exists, err := store.BatchKeyExist(ctx, []string{"k1", "k 2"})
+			So(err, ShouldBeNil)
+			So(exists, ShouldBeTrue)
+			exists, err = store.BatchKeyExist(ctx, []string{"k1", "k3"})
+			So(err, ShouldBeNil)
+			So(exists, ShouldBeFalse)
+
+			values, err := store.BatchGetValues(ctx, []string{"k1", "k3", "k 2"})
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []string{"v1", "", "v2"})
+
+			So(store.DeleteKey(ctx, "k1"), ShouldBeNil)
+			_, err = store.GetValue(ctx, "k1")
+			So(err, ShouldEqual, storage.ErrCacheNotFound)
+		})
+
+		Convey("prefix deletes bump the generation of the prefix only", func() {
+			So(store.BatchSetKeys(ctx, []util.Kv{
+				{Key: "gormcache:1:s:t1:0", Value: "v"}, {Key: "gormcache:1:s:t1:1", Value: "v"}, {Key: "gormcache:1:s:t10:0", Value: "v"},
+			}), ShouldBeNil)
+			So(store.DeleteKeysWithPrefix(ctx, "gormcache:1:s:t1"), ShouldBeNil)
+			values, err := store.BatchGetValues(ctx, []string{"gormcache:1:s:t1:0", "gormcache:1:s:t1:1", "gormcache:1:s:t10:0"})
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []string{"", "", "v"})
+
+			Convey("and clean cache the generation of the store", func() {
+				So(store.CleanCache(ctx), ShouldBeNil)
+				_, err = store.GetValue(ctx, "gormcache:1:s:t10:0")
+				So(err, ShouldEqual, storage.ErrCacheNotFound)
+				value, _ := client.GetMulti([]string{"foreign:key"})
+				So(string(value["foreign:key"]), ShouldEqual, "kept")
+			})
+
+			Convey("and evicted generations start again at a new value", func() {
+				So(store.SetKey(ctx, util.Kv{Key: "gormcache:1:s:t2:0", Value: "v"}), ShouldBeNil)
+				// evict the generations of the prefixes, keeping the keys stored under them
+				for key := range client.items {
+					if strings.HasPrefix(key, "app:g:") {
+						delete(client.items, key)
+					}
+				}
+				_, err = store.GetValue(ctx, "gormcache:1:s:t2:0")
+				So(err, ShouldEqual, storage.ErrCacheNotFound)
+			})
+		})
+
+		Convey("the keys of a tenant are deleted by its table and by the tenant", func() {
+			keys := []string{"gormcache:1:tn:a:s:t1:0", "gormcache:1:tn:a:s:t2:0", "gormcache:1:tn:a2:s:t1:0", "gormcache:1:s:t1:0"}
+			for _, key := range keys {
+				So(store.SetKey(ctx, util.Kv{Key: key, Value: "v"}), ShouldBeNil)
+			}
+			So(store.DeleteKeysWithPrefix(ctx, "gormcache:1:tn:a:s:t1"), ShouldBeNil)
+			values, err := store.BatchGetValues(ctx, keys)
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []string{"", "v", "v", "v"})
+
+			So(store.DeleteKeysWithPrefix(ctx, "gormcache:1:tn:a:"), ShouldBeNil)
+			values, err = store.BatchGetValues(ctx, keys)
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []string{"", "", "v", "v"})
+		})
+
+		Convey("caches queries and invalidates them", func() {
+			c, db, err := newCacheDB(&config.CacheConfig{
+				CacheLevel:           config.CacheLevelAll,
+				CacheStorage:         store,
+				CacheTTL:             5000,
+				InvalidateWhenUpdate: true,
+			})
+			So(err, ShouldBeNil)
+
+			query := func() {
+				models := make([]TestModel, 0)
+				So(db.Where("value1 = ?", 197).Find(&models).Error, ShouldBeNil)
+				So(len(models), ShouldEqual, 1)
+			}
+			query()
+			query()
+			So(c.HitCount(), ShouldEqual, 1)
+
+			So(asGorm2Cache(c).InvalidateSearchCache(ctx, TestModelTableName), ShouldBeNil)
+			query()
+			So(c.HitCount(), ShouldEqual, 1)
+		})
+	})
+}
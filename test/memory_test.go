@@ -0,0 +1,322 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMemoryEvictionNotifications(t *testing.T) {
+	Convey("test memory store eviction notifications", t, func() {
+		evictions := make(chan storage.EvictionEvent, 100)
+		store := storage.NewMem(&storage.MemStoreConfig{MaxSize: 10, Evictions: evictions})
+		So(store.Init(&storage.Config{TTL: 5000}), ShouldBeNil)
+
+		ctx := context.Background()
+		So(store.SetKey(ctx, util.Kv{Key: "explicit", Value: "1"}), ShouldBeNil)
+		So(store.DeleteKey(ctx, "explicit"), ShouldBeNil)
+
+		for i := 0; i < 30; i++ {
+			So(store.SetKey(ctx, util.Kv{Key: fmt.Sprintf("key:%d", i), Value: "1"}), ShouldBeNil)
+		}
+
+		reasons := make(map[storage.EvictionReason]int)
+		timeout := time.After(time.Second)
+	collect:
+		for {
+			select {
+			case event := <-evictions:
+				reasons[event.Reason]++
+				if reasons[storage.EvictionReasonCapacity] >= 20 {
+					break collect
+				}
+			case <-timeout:
+				break collect
+			}
+		}
+		So(reasons[storage.EvictionReasonExplicit], ShouldEqual, 1)
+		So(reasons[storage.EvictionReasonCapacity], ShouldBeGreaterThanOrEqualTo, 20)
+	})
+}
+
+func TestMemoryContext(t *testing.T) {
+	Convey("test memory store operations fail once their context is done", t, func() {
+		store := storage.NewMem(&storage.MemStoreConfig{MaxSize: 10})
+		So(store.Init(&storage.Config{TTL: 5000}), ShouldBeNil)
+		So(store.SetKey(context.Background(), util.Kv{Key: "key", Value: "1"}), ShouldBeNil)
+
+		cancelled, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := store.GetValue(cancelled, "key")
+		So(err, ShouldEqual, context.Canceled)
+		_, err = store.BatchGetValues(cancelled, []string{"key"})
+		So(err, ShouldEqual, context.Canceled)
+		_, err = store.KeyExists(cancelled, "key")
+		So(err, ShouldEqual, context.Canceled)
+		So(store.SetKey(cancelled, util.Kv{Key: "other", Value: "2"}), ShouldEqual, context.Canceled)
+		So(store.BatchSetKeys(cancelled, []util.Kv{{Key: "other", Value: "2"}}), ShouldEqual, context.Canceled)
+
+		expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		defer cancel()
+		_, err = store.GetValue(expired, "key")
+		So(err, ShouldEqual, context.DeadlineExceeded)
+
+		// deletes still run, dropping an invalidation would leave the entry stale
+		So(store.DeleteKey(cancelled, "key"), ShouldBeNil)
+		exists, err := store.KeyExists(context.Background(), "key")
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeFalse)
+	})
+}
+
+func TestMemoryLRU(t *testing.T) {
+	Convey("test bounded memory stores evict the least recently used entries", t, func() {
+		ctx := context.Background()
+		stores := map[string]func(config *storage.MemStoreConfig) storage.DataStorage{
+			"memory": func(config *storage.MemStoreConfig) storage.DataStorage {
+				return storage.NewMem(config)
+			},
+			"memsync": func(config *storage.MemStoreConfig) storage.DataStorage {
+				return storage.NewMemSync(nil, config)
+			},
+		}
+		// waits for the evictions ccache applies in background
+		evicted := func(store storage.DataStorage, count uint64) uint64 {
+			counter := store.(storage.EvictionCounter)
+			for deadline := time.Now().Add(time.Second); counter.EvictionCount() < count && time.Now().Before(deadline); {
+				time.Sleep(time.Millisecond)
+			}
+			return counter.EvictionCount()
+		}
+		cached := func(store storage.DataStorage, keys ...string) []bool {
+			values, err := store.BatchGetValues(ctx, keys)
+			So(err, ShouldBeNil)
+			result := make([]bool, 0, len(values))
+			for _, value := range values {
+				result = append(result, value != "")
+			}
+			return result
+		}
+
+		for name, newStore := range stores {
+			Convey(name, func() {
+				store := newStore(&storage.MemStoreConfig{MaxSize: 10})
+				So(store.Init(&storage.Config{TTL: 5000}), ShouldBeNil)
+				for i := 0; i < 10; i++ {
+					So(store.SetKey(ctx, util.Kv{Key: fmt.Sprintf("key:%d", i), Value: "1"}), ShouldBeNil)
+				}
+				// a hit makes key:0 the most recently used
+				_, err := store.GetValue(ctx, "key:0")
+				So(err, ShouldBeNil)
+				for i := 10; i < 15; i++ {
+					So(store.SetKey(ctx, util.Kv{Key: fmt.Sprintf("key:%d", i), Value: "1"}), ShouldBeNil)
+				}
+
+				So(evicted(store, 5), ShouldEqual, 5)
+				So(cached(store, "key:1", "key:5"), ShouldResemble, []bool{false, false})
+				So(cached(store, "key:0", "key:6", "key:14"), ShouldResemble, []bool{true, true, true})
+			})
+
+			Convey(name+" bounded by bytes", func() {
+				store := newStore(&storage.MemStoreConfig{MaxMemoryBytes: 64 << 10})
+				So(store.Init(&storage.Config{TTL: 5000}), ShouldBeNil)
+				value := strings.Repeat("v", 4000)
+				for i := 0; i < 20; i++ {
+					So(store.SetKey(ctx, util.Kv{Key: fmt.Sprintf("big:%02d", i), Value: value}), ShouldBeNil)
+				}
+
+				// 15 values of 4000 bytes fit in 64KiB at most, the stores may keep fewer with their overheads
+				So(evicted(store, 5), ShouldBeGreaterThanOrEqualTo, 5)
+				So(cached(store, "big:00", "big:04"), ShouldResemble, []bool{false, false})
+				So(cached(store, "big:15", "big:19"), ShouldResemble, []bool{true, true})
+			})
+		}
+	})
+}
+
+func TestMemoryLFU(t *testing.T) {
+	Convey("test memory stores under EvictionLFU evict the least frequently used entries", t, func() {
+		ctx := context.Background()
+		stores := []storage.DataStorage{
+			storage.NewMem(&storage.MemStoreConfig{MaxSize: 10, EvictionPolicy: storage.EvictionLFU}),
+			storage.NewMemSync(nil, &storage.MemStoreConfig{MaxSize: 10, EvictionPolicy: storage.EvictionLFU}),
+		}
+		for _, store := range stores {
+			Convey(fmt.Sprintf("%T", store), func() {
+				So(store.Init(&storage.Config{TTL: 5000}), ShouldBeNil)
+				for i := 0; i < 5; i++ {
+					So(store.SetKey(ctx, util.Kv{Key: fmt.Sprintf("key:%d", i), Value: "1"}), ShouldBeNil)
+					for j := 0; j < 3; j++ {
+						_, err := store.GetValue(ctx, fmt.Sprintf("key:%d", i))
+						So(err, ShouldBeNil)
+					}
+				}
+				// written after the hot keys, the least recently used are the hot ones
+				for i := 5; i < 15; i++ {
+					So(store.SetKey(ctx, util.Kv{Key: fmt.Sprintf("key:%d", i), Value: "1"}), ShouldBeNil)
+				}
+
+				So(store.(storage.EvictionCounter).EvictionCount(), ShouldEqual, 5)
+				for i := 0; i < 15; i++ {
+					_, err := store.GetValue(ctx, fmt.Sprintf("key:%d", i))
+					So(err == nil, ShouldEqual, i < 5 || i >= 10)
+				}
+			})
+		}
+	})
+}
+
+func TestMemoryShards(t *testing.T) {
+	Convey("test a sharded memory store spreads its keys and bounds over the shards", t, func() {
+		ctx := context.Background()
+		evictions := make(chan storage.EvictionEvent, 1000)
+		store := storage.NewMem(&storage.MemStoreConfig{MaxSize: 400, ShardCount: 8, Evictions: evictions, CleanupInterval: 20})
+		So(store.Init(&storage.Config{TTL: 5000}), ShouldBeNil)
+
+		for i := 0; i < 100; i++ {
+			So(store.SetKey(ctx, util.Kv{Key: fmt.Sprintf("a:%d", i), Value: "1"}), ShouldBeNil)
+			So(store.SetKey(ctx, util.Kv{Key: fmt.Sprintf("b:%d", i), Value: "1"}), ShouldBeNil)
+		}
+		values, err := store.BatchGetValues(ctx, []string{"a:0", "b:99", "c:0"})
+		So(err, ShouldBeNil)
+		So(values, ShouldResemble, []string{"1", "1", ""})
+		count, err := store.CountKeysWithPrefix(ctx, "a:")
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 100)
+		keys, err := store.ListKeysWithPrefix(ctx, "b:", 30)
+		So(err, ShouldBeNil)
+		So(len(keys), ShouldEqual, 30)
+
+		So(store.DeleteKeysWithPrefix(ctx, "a:"), ShouldBeNil)
+		count, err = store.CountKeysWithPrefix(ctx, "")
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 100)
+
+		Convey("expired entries are removed in background", func() {
+			for len(evictions) > 0 {
+				<-evictions
+			}
+			So(store.SetKey(ctx, util.Kv{Key: "short", Value: "1", TTL: 10}), ShouldBeNil)
+			select {
+			case event := <-evictions:
+				So(event, ShouldResemble, storage.EvictionEvent{Key: "short", Reason: storage.EvictionReasonExpired})
+			case <-time.After(time.Second):
+				So("expired entry not removed", ShouldBeEmpty)
+			}
+		})
+
+		Convey("closing the store stops the background removal", func() {
+			for len(evictions) > 0 {
+				<-evictions
+			}
+			So(store.SetKey(ctx, util.Kv{Key: "short", Value: "1", TTL: 10}), ShouldBeNil)
+			So(store.Close(), ShouldBeNil)
+			So(store.Close(), ShouldBeNil)
+			time.Sleep(100 * time.Millisecond)
+			So(len(evictions), ShouldEqual, 0)
+		})
+
+		Convey("each shard evicts its least recently used entries", func() {
+			So(store.CleanCache(ctx), ShouldBeNil)
+			for i := 0; i < 1000; i++ {
+				So(store.SetKey(ctx, util.Kv{Key: fmt.Sprintf("c:%d", i), Value: "1"}), ShouldBeNil)
+			}
+			for deadline := time.Now().Add(time.Second); store.EvictionCount() < 500 && time.Now().Before(deadline); {
+				time.Sleep(time.Millisecond)
+			}
+			count, err := store.CountKeysWithPrefix(ctx, "c:")
+			So(err, ShouldBeNil)
+			So(count, ShouldBeLessThanOrEqualTo, 400)
+			So(count, ShouldBeGreaterThan, 300)
+		})
+	})
+}
+
+// BenchmarkMemoryParallel reads and writes a memory store from 64 goroutines per GOMAXPROCS, one write in ten
+func TestMemorySnapshot(t *testing.T) {
+	Convey("test the memory store saves its live entries and loads them back", t, func() {
+		ctx := context.Background()
+		store := storage.NewMem(&storage.MemStoreConfig{MaxSize: 100, ShardCount: 4})
+		So(store.Init(&storage.Config{TTL: 5000}), ShouldBeNil)
+		for i := 0; i < 20; i++ {
+			So(store.SetKey(ctx, util.Kv{Key: fmt.Sprintf("key:%d", i), Value: fmt.Sprint(i)}), ShouldBeNil)
+		}
+		So(store.SetKey(ctx, util.Kv{Key: "short", Value: "v", TTL: 50}), ShouldBeNil)
+
+		var buf bytes.Buffer
+		So(store.Save(&buf), ShouldBeNil)
+		time.Sleep(100 * time.Millisecond)
+
+		loaded := storage.NewMem(&storage.MemStoreConfig{MaxSize: 100})
+		So(loaded.Init(&storage.Config{TTL: 5000}), ShouldBeNil)
+		So(loaded.Load(&buf), ShouldBeNil)
+		count, err := loaded.CountKeysWithPrefix(ctx, "")
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 20)
+		value, err := loaded.GetValue(ctx, "key:7")
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, "7")
+		ttl, err := loaded.KeyTTL(ctx, "key:7")
+		So(err, ShouldBeNil)
+		So(ttl, ShouldBeBetweenOrEqual, 3000, 6000)
+
+		Convey("through the snapshot file on close and init", func() {
+			file := filepath.Join(t.TempDir(), "cache.snapshot")
+			first := storage.NewMem(&storage.MemStoreConfig{MaxSize: 100, SnapshotFile: file})
+			So(first.Close(), ShouldBeNil)
+			So(first.Init(&storage.Config{TTL: 5000}), ShouldBeNil)
+			So(first.SetKey(ctx, util.Kv{Key: "k", Value: "v"}), ShouldBeNil)
+			So(first.Close(), ShouldBeNil)
+
+			second := storage.NewMem(&storage.MemStoreConfig{MaxSize: 100, SnapshotFile: file})
+			So(second.Init(&storage.Config{TTL: 5000, Logger: &util.DefaultLogger{}}), ShouldBeNil)
+			value, err := second.GetValue(ctx, "k")
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, "v")
+		})
+	})
+}
+
+func BenchmarkMemoryParallel(b *testing.B) {
+	ctx := context.Background()
+	keys := make([]string, 10000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("gormcache:bench:s:table:%d", i)
+	}
+	for _, shardCount := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("shards=%d", shardCount), func(b *testing.B) {
+			store := storage.NewMem(&storage.MemStoreConfig{MaxSize: int64(len(keys)), ShardCount: shardCount})
+			if err := store.Init(&storage.Config{TTL: 60000}); err != nil {
+				b.Fatal(err)
+			}
+			for _, key := range keys {
+				_ = store.SetKey(ctx, util.Kv{Key: key, Value: "value"})
+			}
+			b.SetParallelism(64)
+			b.ResetTimer()
+			var offset int64
+			b.RunParallel(func(pb *testing.PB) {
+				// goroutines start apart, not all on the same key
+				i := int(atomic.AddInt64(&offset, 997))
+				for pb.Next() {
+					key := keys[i%len(keys)]
+					if i%10 == 0 {
+						_ = store.SetKey(ctx, util.Kv{Key: key, Value: "value"})
+					} else {
+						_, _ = store.GetValue(ctx, key)
+					}
+					i += 7
+				}
+			})
+		})
+	}
+}
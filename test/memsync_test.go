@@ -0,0 +1,96 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Example_memSyncExpiry() {
+	clock := storage.NewManualClock(time.Unix(0, 0))
+	store := storage.NewMemSync(clock)
+	_ = store.Init(&storage.Config{TTL: 1000})
+
+	ctx := context.Background()
+	_ = store.SetKey(ctx, util.Kv{Key: "k", Value: "v"})
+
+	clock.Advance(999 * time.Millisecond)
+	value, err := store.GetValue(ctx, "k")
+	fmt.Println(value, err)
+
+	clock.Advance(time.Millisecond)
+	value, err = store.GetValue(ctx, "k")
+	fmt.Println(value, err)
+	// Output:
+	// v <nil>
+	//  cache not found
+}
+
+func Example_memSyncCachedQuery() {
+	clock := storage.NewManualClock(time.Unix(0, 0))
+	c, db, _ := newCacheDB(&config.CacheConfig{
+		CacheLevel:   config.CacheLevelOnlySearch,
+		CacheStorage: storage.NewMemSync(clock),
+		CacheTTL:     5000,
+	})
+
+	query := func() {
+		models := make([]TestModel, 0)
+		_ = db.Where("value1 BETWEEN ? AND ?", 191, 193).Find(&models).Error
+	}
+	query()
+	query()
+	fmt.Println("hits:", c.HitCount())
+
+	clock.Advance(5 * time.Second)
+	query()
+	fmt.Println("hits after expiry:", c.HitCount())
+	// Output:
+	// hits: 1
+	// hits after expiry: 1
+}
+
+func TestMemSyncEviction(t *testing.T) {
+	Convey("test memsync evicts deterministically and reports synchronously", t, func() {
+		events := make(chan storage.EvictionEvent, 10)
+		clock := storage.NewManualClock(time.Unix(0, 0))
+		store := storage.NewMemSync(clock, &storage.MemStoreConfig{MaxSize: 2, Evictions: events})
+		So(store.Init(&storage.Config{TTL: 1000}), ShouldBeNil)
+		ctx := context.Background()
+
+		So(store.SetKey(ctx, util.Kv{Key: "a", Value: "1"}), ShouldBeNil)
+		So(store.SetKey(ctx, util.Kv{Key: "b", Value: "2"}), ShouldBeNil)
+		_, err := store.GetValue(ctx, "a")
+		So(err, ShouldBeNil)
+
+		// b is the least recently used
+		So(store.SetKey(ctx, util.Kv{Key: "c", Value: "3"}), ShouldBeNil)
+		So(len(events), ShouldEqual, 1)
+		So(<-events, ShouldResemble, storage.EvictionEvent{Key: "b", Reason: storage.EvictionReasonCapacity})
+
+		values, err := store.BatchGetValues(ctx, []string{"a", "b", "c"})
+		So(err, ShouldBeNil)
+		So(values, ShouldResemble, []string{"1", "", "3"})
+
+		clock.Advance(time.Second)
+		count, err := store.CountKeysWithPrefix(ctx, "")
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 0)
+		So(len(events), ShouldEqual, 0) // expiry is lazy
+
+		exists, err := store.KeyExists(ctx, "c")
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeFalse)
+		So(<-events, ShouldResemble, storage.EvictionEvent{Key: "c", Reason: storage.EvictionReasonExpired})
+
+		So(store.DeleteKeysWithPrefix(ctx, ""), ShouldBeNil)
+		So(<-events, ShouldResemble, storage.EvictionEvent{Key: "a", Reason: storage.EvictionReasonExpired})
+		So(len(events), ShouldEqual, 0)
+	})
+}
@@ -1,5 +1,12 @@
 package test
 
+import (
+	"database/sql/driver"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
 type TestModel struct {
 	ID        int64  `gorm:"column:id;primary_key"`
 	Value1    int64  `gorm:"column:value1"`
@@ -21,3 +28,203 @@ const (
 func (m *TestModel) TableName() string {
 	return TestModelTableName
 }
+
+// SecretModel reads the table of TestModel, keeping Value9 out of the cache
+type SecretModel struct {
+	ID     int64  `gorm:"column:id;primary_key"`
+	Value1 int64  `gorm:"column:value1"`
+	Value9 string `gorm:"column:value9" gormCache:"-"`
+}
+
+func (m *SecretModel) TableName() string {
+	return TestModelTableName
+}
+
+type StringPKModel struct {
+	Code  string `gorm:"column:code;primary_key"`
+	Name  string `gorm:"column:name"`
+	Score *int64 `gorm:"column:score"`
+}
+
+const (
+	StringPKModelTableName = "gorm_cache_string_pk_model"
+)
+
+func (m *StringPKModel) TableName() string {
+	return StringPKModelTableName
+}
+
+// UUIDModel is keyed by a uuid column instead of id
+type UUIDModel struct {
+	UUID string `gorm:"column:uuid;primaryKey"`
+	Name string `gorm:"column:name"`
+}
+
+const (
+	UUIDModelTableName = "gorm_cache_uuid_model"
+)
+
+func (m *UUIDModel) TableName() string {
+	return UUIDModelTableName
+}
+
+// CompositeKeyModel is keyed by two columns
+type CompositeKeyModel struct {
+	TenantID int64  `gorm:"column:tenant_id;primaryKey;autoIncrement:false"`
+	Code     string `gorm:"column:code;primaryKey"`
+	Name     string `gorm:"column:name"`
+}
+
+const (
+	CompositeKeyModelTableName = "gorm_cache_composite_key_model"
+)
+
+func (m *CompositeKeyModel) TableName() string {
+	return CompositeKeyModelTableName
+}
+
+// SoftDeleteModel is deleted softly, by setting its deleted_at column
+type SoftDeleteModel struct {
+	ID        int64          `gorm:"column:id;primary_key"`
+	Name      string         `gorm:"column:name"`
+	DeletedAt gorm.DeletedAt `gorm:"column:deleted_at"`
+}
+
+const (
+	SoftDeleteModelTableName = "gorm_cache_soft_delete_model"
+)
+
+func (m *SoftDeleteModel) TableName() string {
+	return SoftDeleteModelTableName
+}
+
+// KeylessViewModel reads a view over gorm_cache_model, it has no primary key
+type KeylessViewModel struct {
+	Value1 int64  `gorm:"column:value1"`
+	Value9 string `gorm:"column:value9"`
+}
+
+const (
+	KeylessViewModelTableName = "gorm_cache_model_view"
+)
+
+func (m *KeylessViewModel) TableName() string {
+	return KeylessViewModelTableName
+}
+
+// KeylessLinkModel is a join table without primary key
+type KeylessLinkModel struct {
+	LeftID  int64 `gorm:"column:left_id"`
+	RightID int64 `gorm:"column:right_id"`
+}
+
+const (
+	KeylessLinkModelTableName = "gorm_cache_keyless_link"
+)
+
+func (m *KeylessLinkModel) TableName() string {
+	return KeylessLinkModelTableName
+}
+
+// AuthorModel has many books, preloaded by queries on authors
+type AuthorModel struct {
+	ID    int64       `gorm:"column:id;primary_key"`
+	Name  string      `gorm:"column:name"`
+	Books []BookModel `gorm:"foreignKey:AuthorID"`
+}
+
+const (
+	AuthorModelTableName = "gorm_cache_author_model"
+)
+
+func (m *AuthorModel) TableName() string {
+	return AuthorModelTableName
+}
+
+type BookModel struct {
+	ID       int64  `gorm:"column:id;primary_key"`
+	AuthorID int64  `gorm:"column:author_id"`
+	Title    string `gorm:"column:title"`
+}
+
+const (
+	BookModelTableName = "gorm_cache_book_model"
+)
+
+func (m *BookModel) TableName() string {
+	return BookModelTableName
+}
+
+// PointModel has a geometry column, as scanned by a driver into a type with unexported fields
+type PointModel struct {
+	ID       int64 `gorm:"column:id;primary_key"`
+	Location Point `gorm:"column:location"`
+}
+
+const (
+	PointModelTableName = "gorm_cache_point_model"
+)
+
+func (m *PointModel) TableName() string {
+	return PointModelTableName
+}
+
+// Point is a geometry in the well known text format of PostGIS, e.g. POINT(1.5 -2)
+type Point struct {
+	x, y float64
+}
+
+func (p *Point) Scan(src interface{}) error {
+	var text string
+	switch v := src.(type) {
+	case string:
+		text = v
+	case []byte:
+		text = string(v)
+	default:
+		return fmt.Errorf("unsupported point %T", src)
+	}
+	_, err := fmt.Sscanf(text, "POINT(%g %g)", &p.x, &p.y)
+	return err
+}
+
+func (p Point) Value() (driver.Value, error) {
+	return p.String(), nil
+}
+
+func (p Point) String() string {
+	return fmt.Sprintf("POINT(%g %g)", p.x, p.y)
+}
+
+func (Point) GormDataType() string {
+	return "text"
+}
+
+// UserModel has many roles through the join table gorm_cache_user_roles
+type UserModel struct {
+	ID    int64       `gorm:"column:id;primary_key"`
+	Name  string      `gorm:"column:name"`
+	Roles []RoleModel `gorm:"many2many:gorm_cache_user_roles;joinForeignKey:UserID;joinReferences:RoleID"`
+}
+
+const (
+	UserModelTableName = "gorm_cache_user_model"
+	UserRolesTableName = "gorm_cache_user_roles"
+)
+
+func (m *UserModel) TableName() string {
+	return UserModelTableName
+}
+
+type RoleModel struct {
+	ID   int64  `gorm:"column:id;primary_key"`
+	Name string `gorm:"column:name"`
+}
+
+const (
+	RoleModelTableName = "gorm_cache_role_model"
+)
+
+func (m *RoleModel) TableName() string {
+	return RoleModelTableName
+}
@@ -0,0 +1,66 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestMultipleInstances(t *testing.T) {
+	Convey("test caches built from the same config are independent of each other", t, func() {
+		defer originalDB.Model(&TestModel{ID: 166}).Update("value2", 166)
+
+		base := &config.CacheConfig{
+			CacheLevel:           config.CacheLevelAll,
+			CacheStorage:         storage.NewMemSync(nil),
+			CacheTTL:             5000,
+			InvalidateWhenUpdate: true,
+		}
+		mainCache, mainDB, err := newCacheDB(base)
+		So(err, ShouldBeNil)
+		analyticsConf := *base
+		analyticsConf.Database = "analytics"
+		analyticsCache, analyticsDB, err := newCacheDB(&analyticsConf)
+		So(err, ShouldBeNil)
+
+		// defaults are filled in the copies
+		So(base.DebugLogger, ShouldBeNil)
+		So(asGorm2Cache(mainCache).Config, ShouldNotEqual, base)
+		So(asGorm2Cache(mainCache).InstanceId, ShouldNotEqual, asGorm2Cache(analyticsCache).InstanceId)
+
+		search := func(db *gorm.DB) int64 {
+			models := make([]TestModel, 0)
+			So(db.Where("value1 BETWEEN ? AND ?", 166, 168).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 3)
+			return models[0].Value2
+		}
+
+		// the storage is shared, the keys aren't
+		search(mainDB)
+		search(mainDB)
+		search(analyticsDB)
+		So(mainCache.HitCount(), ShouldEqual, 1)
+		So(analyticsCache.HitCount(), ShouldEqual, 0)
+		So(analyticsCache.MissCount(), ShouldEqual, 1)
+
+		Convey("a write through another instance of the same database drops the request cache entries", func() {
+			otherCache, otherDB, err := newCacheDB(base)
+			So(err, ShouldBeNil)
+			So(asGorm2Cache(otherCache).InstanceId, ShouldNotEqual, asGorm2Cache(mainCache).InstanceId)
+
+			ctx := cache.WithRequestCache(context.Background())
+			So(search(mainDB.WithContext(ctx)), ShouldEqual, 166)
+			So(otherDB.WithContext(ctx).Model(&TestModel{ID: 166}).Update("value2", 1000).Error, ShouldBeNil)
+			// as a Broadcaster would, the keys of the instances aren't shared
+			So(asGorm2Cache(mainCache).InvalidateSearchCache(ctx, TestModelTableName), ShouldBeNil)
+
+			So(search(mainDB.WithContext(ctx)), ShouldEqual, 1000)
+			So(mainCache.HitCounts().Request, ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,64 @@
+package test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	"github.com/nats-io/nats-server/v2/server"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNatsKVStorage(t *testing.T) {
+	Convey("test nats jetstream kv storage", t, func() {
+		dir, err := os.MkdirTemp("", "gormCacheNats")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: dir})
+		So(err, ShouldBeNil)
+		go ns.Start()
+		defer ns.Shutdown()
+		So(ns.ReadyForConnections(5*time.Second), ShouldBeTrue)
+
+		store := storage.NewNatsKV(&storage.NatsKVStoreConfig{Bucket: "gorm-cache", URL: ns.ClientURL()})
+		err = store.Init(&storage.Config{TTL: 500, Logger: &util.DefaultLogger{}})
+		So(err, ShouldBeNil)
+
+		ctx := context.Background()
+		err = store.BatchSetKeys(ctx, []util.Kv{
+			{Key: "gormcache:abc:s:users:SELECT * FROM users WHERE id > ?:1", Value: "1|[]"},
+			{Key: "gormcache:abc:s:users:SELECT * FROM users WHERE id > ?:2", Value: "2|[]"},
+			{Key: "gormcache:abc:p:users:1", Value: "{}"},
+		})
+		So(err, ShouldBeNil)
+
+		value, err := store.GetValue(ctx, "gormcache:abc:s:users:SELECT * FROM users WHERE id > ?:2")
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, "2|[]")
+
+		values, err := store.BatchGetValues(ctx, []string{"gormcache:abc:p:users:1", "gormcache:abc:s:users:SELECT * FROM users WHERE id > ?:1"})
+		So(err, ShouldBeNil)
+		So(values, ShouldResemble, []string{"{}", "1|[]"})
+
+		_, err = store.GetValue(ctx, "gormcache:abc:p:users:2")
+		So(err, ShouldEqual, storage.ErrCacheNotFound)
+
+		err = store.DeleteKeysWithPrefix(ctx, "gormcache:abc:s:users")
+		So(err, ShouldBeNil)
+		exists, err := store.BatchKeyExist(ctx, []string{"gormcache:abc:s:users:SELECT * FROM users WHERE id > ?:1"})
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeFalse)
+		exists, err = store.KeyExists(ctx, "gormcache:abc:p:users:1")
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeTrue)
+
+		time.Sleep(time.Second)
+		exists, err = store.KeyExists(ctx, "gormcache:abc:p:users:1")
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeFalse)
+	})
+}
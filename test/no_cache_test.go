@@ -0,0 +1,77 @@
+package test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bluele/gcache"
+	"github.com/glebarez/sqlite"
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestNoCache(t *testing.T) {
+	Convey("test queries asking for no cache always hit the database", t, func() {
+		pool := &countingConnPool{ConnPool: originalDB.ConnPool}
+		db, err := gorm.Open(&sqlite.Dialector{Conn: pool}, &gorm.Config{})
+		So(err, ShouldBeNil)
+		c, err := cache.NewGorm2Cache(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelAll,
+			CacheStorage: storage.NewGcache(gcache.New(1000)),
+			CacheTTL:     5000,
+		})
+		So(err, ShouldBeNil)
+		So(db.Use(c), ShouldBeNil)
+		gc := asGorm2Cache(c)
+
+		assertUncached := func(query func() *gorm.DB, sql string, vars ...interface{}) {
+			atomic.StoreInt64(&pool.count, 0)
+			for i := 0; i < 2; i++ {
+				result := query()
+				So(result.Error, ShouldBeNil)
+				So(result.RowsAffected, ShouldEqual, 3)
+			}
+			So(atomic.LoadInt64(&pool.count), ShouldEqual, 2)
+			So(c.HitCount(), ShouldEqual, 0)
+			So(c.MissCount(), ShouldEqual, 0)
+			exists, err := gc.SearchKeyExists(context.Background(), "gorm_cache_model", sql, vars...)
+			So(err, ShouldBeNil)
+			So(exists, ShouldBeFalse)
+		}
+
+		Convey("sql hint", func() {
+			sql := "SELECT * FROM gorm_cache_model WHERE value1 BETWEEN ? AND ? /* NoCache */"
+			assertUncached(func() *gorm.DB {
+				models := make([]TestModel, 0)
+				return db.Raw(sql, 151, 153).Find(&models)
+			}, sql, 151, 153)
+		})
+
+		Convey("context settings surviving new sessions", func() {
+			ctx := cache.WithCacheCtx(context.Background(), cache.CacheSettings{NoCache: true})
+			assertUncached(func() *gorm.DB {
+				models := make([]TestModel, 0)
+				return db.WithContext(ctx).Session(&gorm.Session{NewDB: true}).
+					Where("value1 BETWEEN ? AND ?", 151, 153).Find(&models)
+			}, "SELECT * FROM `gorm_cache_model` WHERE value1 BETWEEN ? AND ?", 151, 153)
+		})
+
+		Convey("session flag", func() {
+			assertUncached(func() *gorm.DB {
+				models := make([]TestModel, 0)
+				return cache.NoCache(db).Where("value1 BETWEEN ? AND ?", 151, 153).Find(&models)
+			}, "SELECT * FROM `gorm_cache_model` WHERE value1 BETWEEN ? AND ?", 151, 153)
+		})
+
+		Convey("queries without the hint are still cached", func() {
+			models := make([]TestModel, 0)
+			So(db.Where("value1 BETWEEN ? AND ?", 151, 153).Find(&models).Error, ShouldBeNil)
+			So(db.Where("value1 BETWEEN ? AND ?", 151, 153).Find(&models).Error, ShouldBeNil)
+			So(c.HitCount(), ShouldEqual, 1)
+		})
+	})
+}
@@ -0,0 +1,37 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestNoopStorage(t *testing.T) {
+	Convey("test every query misses the noop storage", t, func() {
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:           config.CacheLevelAll,
+			CacheStorage:         storage.NewNoop(),
+			CacheTTL:             5000,
+			InvalidateWhenUpdate: true,
+		})
+		So(err, ShouldBeNil)
+
+		for i := 0; i < 2; i++ {
+			models := make([]TestModel, 0)
+			So(db.Where("value1 BETWEEN ? AND ?", 141, 143).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 3)
+
+			model := TestModel{}
+			So(db.Where("id = ?", 142).First(&model).Error, ShouldBeNil)
+			So(model.Value1, ShouldEqual, 142)
+
+			So(db.Where("id = ?", -142).First(&TestModel{}).Error, ShouldEqual, gorm.ErrRecordNotFound)
+		}
+		So(c.HitCount(), ShouldEqual, 0)
+		So(c.MissCount(), ShouldEqual, 6)
+		So(asGorm2Cache(c).ErrorCount(), ShouldEqual, 0)
+	})
+}
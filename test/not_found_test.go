@@ -0,0 +1,92 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestPrimaryNotFoundCache(t *testing.T) {
+	Convey("test lookups of missing primary keys are cached for EmptyCacheTTL", t, func() {
+		clock := storage.NewManualClock(time.Unix(0, 0))
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:           config.CacheLevelOnlyPrimary,
+			CacheStorage:         storage.NewMemSync(clock),
+			CacheTTL:             5000,
+			EmptyCacheTTL:        1000,
+			InvalidateWhenUpdate: true,
+		})
+		So(err, ShouldBeNil)
+
+		query := func() (TestModel, error) {
+			model := TestModel{}
+			err := db.Where("id = ?", 3001).First(&model).Error
+			return model, err
+		}
+
+		_, err = query()
+		So(err, ShouldEqual, gorm.ErrRecordNotFound)
+		So(c.HitCount(), ShouldEqual, 0)
+		_, err = query()
+		So(err, ShouldEqual, gorm.ErrRecordNotFound)
+		So(c.HitCount(), ShouldEqual, 1)
+
+		Convey("expiring before found rows", func() {
+			clock.Advance(1100 * time.Millisecond)
+			_, err = query()
+			So(err, ShouldEqual, gorm.ErrRecordNotFound)
+			So(c.HitCount(), ShouldEqual, 1)
+		})
+
+		Convey("dropped when the row is created", func() {
+			So(db.Create(&TestModel{ID: 3001, Value1: 3001}).Error, ShouldBeNil)
+			defer originalDB.Delete(&TestModel{}, 3001)
+
+			model, err := query()
+			So(err, ShouldBeNil)
+			So(model.Value1, ShouldEqual, 3001)
+		})
+
+		Convey("not served to lookups of several keys", func() {
+			models := make([]TestModel, 0)
+			So(db.Where("id IN ?", []int64{3001, 1}).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 1)
+			So(c.HitCount(), ShouldEqual, 1)
+		})
+	})
+}
+
+func TestNotFoundCacheDisabledTables(t *testing.T) {
+	Convey("test tables can opt out of caching record not found results", t, func() {
+		newDB := func(disabled []string) (cache.Cache, *gorm.DB) {
+			c, db, err := newCacheDB(&config.CacheConfig{
+				CacheLevel:                           config.CacheLevelAll,
+				CacheStorage:                         storage.NewMemSync(nil),
+				CacheTTL:                             5000,
+				DisableCachePenetrationProtectTables: disabled,
+			})
+			So(err, ShouldBeNil)
+			return c, db
+		}
+		query := func(db *gorm.DB) {
+			for _, where := range []string{"id = ?", "value1 = ?"} {
+				So(db.Where(where, 3002).First(&TestModel{}).Error, ShouldEqual, gorm.ErrRecordNotFound)
+			}
+		}
+
+		c, db := newDB([]string{"gorm_cache_*"})
+		query(db)
+		query(db)
+		So(c.HitCount(), ShouldEqual, 0)
+
+		c, db = newDB([]string{"other_table"})
+		query(db)
+		query(db)
+		So(c.HitCount(), ShouldEqual, 2)
+	})
+}
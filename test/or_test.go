@@ -0,0 +1,88 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOrConditionKeying(t *testing.T) {
+	Convey("test search cache keys of OR conditions follow the rendered SQL", t, func() {
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelOnlySearch,
+			CacheStorage: storage.NewGcache(gcache.New(1000)),
+			CacheTTL:     5000,
+		})
+		So(err, ShouldBeNil)
+
+		Convey("equivalent builder calls share an entry", func() {
+			models := make([]TestModel, 0)
+			So(db.Where("value1 = ?", 171).Or("value1 = ?", 172).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 2)
+			So(c.HitCount(), ShouldEqual, 0)
+
+			models = make([]TestModel, 0)
+			So(db.Where("value1 = ? OR value1 = ?", 171, 172).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 2)
+			So(c.HitCount(), ShouldEqual, 1)
+
+			models = make([]TestModel, 0)
+			So(db.Where(db.Where("value1 = ?", 171).Or("value1 = ?", 172)).Where("value2 = ?", 172).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 1)
+			So(c.HitCount(), ShouldEqual, 1)
+
+			models = make([]TestModel, 0)
+			So(db.Where("(value1 = ? OR value1 = ?) AND value2 = ?", 171, 172, 172).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 1)
+			So(c.HitCount(), ShouldEqual, 2)
+		})
+
+		Convey("differently rendered conditions are separate entries", func() {
+			models := make([]TestModel, 0)
+			So(db.Where(db.Where("value1 = ?", 171).Or("value1 = ?", 172)).Where("value2 = ?", 172).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 1)
+
+			// without the group AND binds tighter: value1 = 171 OR (value1 = 172 AND value2 = 172)
+			models = make([]TestModel, 0)
+			So(db.Where("value1 = ?", 171).Or("value1 = ?", 172).Where("value2 = ?", 172).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 2)
+			So(c.HitCount(), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestOrConditionPrimaryInvalidation(t *testing.T) {
+	Convey("test update with OR conditions invalidates every affected primary key", t, func() {
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:           config.CacheLevelOnlyPrimary,
+			CacheStorage:         storage.NewGcache(gcache.New(1000)),
+			InvalidateWhenUpdate: true,
+			CacheTTL:             5000,
+		})
+		So(err, ShouldBeNil)
+
+		originals := make([]TestModel, 0)
+		So(db.Where("id IN ?", []int64{181, 182}).Find(&originals).Error, ShouldBeNil)
+		So(len(originals), ShouldEqual, 2)
+		defer func() {
+			for _, model := range originals {
+				originalDB.Model(&TestModel{}).Where("id = ?", model.ID).Update("value8", model.Value8)
+			}
+		}()
+
+		model := &TestModel{}
+		So(db.Where("id = ?", 182).First(model).Error, ShouldBeNil)
+		So(c.HitCount(), ShouldEqual, 1)
+
+		err = db.Model(&TestModel{}).Where("id = ?", 181).Or("id = ?", 182).Update("value8", 5000).Error
+		So(err, ShouldBeNil)
+
+		model = &TestModel{}
+		So(db.Where("id = ?", 182).First(model).Error, ShouldBeNil)
+		So(model.Value8, ShouldEqual, 5000)
+		So(c.HitCount(), ShouldEqual, 1)
+	})
+}
@@ -0,0 +1,69 @@
+package test
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+
+	"github.com/bluele/gcache"
+	"github.com/glebarez/sqlite"
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+// recordingConnPool records the args of every query reaching the database
+type recordingConnPool struct {
+	gorm.ConnPool
+	mu   sync.Mutex
+	args [][]interface{}
+}
+
+func (p *recordingConnPool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	p.mu.Lock()
+	p.args = append(p.args, args)
+	p.mu.Unlock()
+	return p.ConnPool.QueryContext(ctx, query, args...)
+}
+
+func TestPartialPrimaryHit(t *testing.T) {
+	Convey("test a partial primary cache hit only loads the missing rows", t, func() {
+		pool := &recordingConnPool{ConnPool: originalDB.ConnPool}
+		db, err := gorm.Open(&sqlite.Dialector{Conn: pool}, &gorm.Config{})
+		So(err, ShouldBeNil)
+		c, err := cache.NewGorm2Cache(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelOnlyPrimary,
+			CacheStorage: storage.NewGcache(gcache.New(1000)),
+			CacheTTL:     5000,
+		})
+		So(err, ShouldBeNil)
+		So(db.Use(c), ShouldBeNil)
+
+		seeded := make([]TestModel, 0)
+		So(originalDB.Where("id IN (?)", []int64{54, 56}).Find(&seeded).Error, ShouldBeNil)
+		So(asGorm2Cache(c).WarmPrimaryCache(context.Background(), "", seeded), ShouldBeNil)
+
+		ids := func() []int64 {
+			models := make([]*TestModel, 0)
+			So(db.Where("id IN (?)", []int64{58, 54, 55, 56, 57}).Find(&models).Error, ShouldBeNil)
+			result := make([]int64, 0, len(models))
+			for _, model := range models {
+				So(model.Value1, ShouldEqual, model.ID)
+				result = append(result, model.ID)
+			}
+			return result
+		}
+
+		So(ids(), ShouldResemble, []int64{58, 54, 55, 56, 57})
+		So(pool.args, ShouldResemble, [][]interface{}{{"58", "55", "57"}})
+		So(c.HitCount(), ShouldEqual, 0)
+
+		// the loaded rows were cached
+		So(ids(), ShouldResemble, []int64{58, 54, 55, 56, 57})
+		So(len(pool.args), ShouldEqual, 1)
+		So(c.HitCount(), ShouldEqual, 1)
+	})
+}
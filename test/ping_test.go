@@ -0,0 +1,96 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/redis/go-redis/v9"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPing(t *testing.T) {
+	Convey("test ping reports whether the storage is reachable", t, func() {
+		ctx := context.Background()
+
+		c, _, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelAll,
+			CacheStorage: storage.NewMemSync(nil),
+			CacheTTL:     5000,
+		})
+		So(err, ShouldBeNil)
+		So(c.Ping(ctx), ShouldBeNil)
+
+		mr := miniredis.RunT(t)
+		c, _, err = newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelAll,
+			CacheStorage: storage.NewRedis(&storage.RedisStoreConfig{Options: &redis.Options{Addr: mr.Addr()}}),
+			CacheTTL:     5000,
+		})
+		So(err, ShouldBeNil)
+		So(c.Ping(ctx), ShouldBeNil)
+
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		So(c.Ping(canceled), ShouldNotBeNil)
+
+		mr.Close()
+		So(c.Ping(ctx), ShouldNotBeNil)
+	})
+}
+
+func TestHealthCheck(t *testing.T) {
+	Convey("test the health check round trips a sentinel key through the storage", t, func() {
+		ctx := context.Background()
+
+		store := storage.NewMemSync(nil)
+		c, _, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelAll,
+			CacheStorage: store,
+			CacheTTL:     5000,
+		})
+		So(err, ShouldBeNil)
+		status, err := c.HealthCheck(ctx)
+		So(err, ShouldBeNil)
+		So(status.Healthy, ShouldBeTrue)
+		So(status.Error, ShouldEqual, "")
+		// the sentinel is deleted
+		count, err := store.CountKeysWithPrefix(ctx, "")
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 0)
+
+		// storages keeping nothing are healthy
+		c, _, err = newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelAll,
+			CacheStorage: storage.NewNoop(),
+		})
+		So(err, ShouldBeNil)
+		_, err = c.HealthCheck(ctx)
+		So(err, ShouldBeNil)
+
+		mr := miniredis.RunT(t)
+		c, _, err = newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelAll,
+			CacheStorage: storage.NewRedis(&storage.RedisStoreConfig{Options: &redis.Options{Addr: mr.Addr()}}),
+			CacheTTL:     5000,
+		})
+		So(err, ShouldBeNil)
+		status, err = c.HealthCheck(ctx)
+		So(err, ShouldBeNil)
+		So(status.Latency, ShouldBeGreaterThan, 0)
+
+		mr.Close()
+		status, err = c.HealthCheck(ctx)
+		So(err, ShouldNotBeNil)
+		So(status.Healthy, ShouldBeFalse)
+		So(status.Error, ShouldNotEqual, "")
+
+		recorder := httptest.NewRecorder()
+		asGorm2Cache(c).AdminHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/health", nil))
+		So(recorder.Code, ShouldEqual, http.StatusServiceUnavailable)
+	})
+}
@@ -14,3 +14,50 @@ func testPluck(cache cache.Cache, db *gorm.DB) {
 	So(len(value9), ShouldEqual, testSize)
 	So(value9[0], ShouldEqual, "1")
 }
+
+func testSearchPluck(cache cache.Cache, db *gorm.DB) {
+	err := cache.ResetCache()
+	So(err, ShouldBeNil)
+	So(cache.HitCount(), ShouldEqual, 0)
+
+	var value9 []string
+	result := db.Model(&TestModel{}).Where("value1 BETWEEN ? AND ?", 1, 10).Pluck("value9", &value9)
+	So(result.Error, ShouldBeNil)
+	So(cache.HitCount(), ShouldEqual, 0)
+	So(len(value9), ShouldEqual, 10)
+
+	value9 = nil
+	result = db.Model(&TestModel{}).Where("value1 BETWEEN ? AND ?", 1, 10).Pluck("value9", &value9)
+	So(result.Error, ShouldBeNil)
+	So(cache.HitCount(), ShouldEqual, 1)
+	So(len(value9), ShouldEqual, 10)
+	So(value9[0], ShouldEqual, "1")
+
+	// plucking another column is a different query
+	var value1 []int64
+	result = db.Model(&TestModel{}).Where("value1 BETWEEN ? AND ?", 1, 10).Pluck("value1", &value1)
+	So(result.Error, ShouldBeNil)
+	So(cache.HitCount(), ShouldEqual, 1)
+	So(value1[9], ShouldEqual, 10)
+
+	// empty results are cached distinctly from a miss
+	var empty []string
+	result = db.Model(&TestModel{}).Where("value9 = ?", "not exists").Pluck("value9", &empty)
+	So(result.Error, ShouldBeNil)
+	So(len(empty), ShouldEqual, 0)
+	result = db.Model(&TestModel{}).Where("value9 = ?", "not exists").Pluck("value9", &empty)
+	So(result.Error, ShouldBeNil)
+	So(cache.HitCount(), ShouldEqual, 2)
+	So(len(empty), ShouldEqual, 0)
+
+	model := &TestModel{Value1: 10, Value9: "10"}
+	result = db.Create(model)
+	So(result.Error, ShouldBeNil)
+	defer db.Delete(model)
+
+	value9 = nil
+	result = db.Model(&TestModel{}).Where("value1 BETWEEN ? AND ?", 1, 10).Pluck("value9", &value9)
+	So(result.Error, ShouldBeNil)
+	So(cache.HitCount(), ShouldEqual, 2)
+	So(len(value9), ShouldEqual, 11)
+}
@@ -0,0 +1,109 @@
+package test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	"github.com/redis/go-redis/v9"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestPreciseSearchInvalidation(t *testing.T) {
+	Convey("test an update by primary key only invalidates the searches containing the row", t, func() {
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:                config.CacheLevelOnlySearch,
+			CacheStorage:              storage.NewGcache(gcache.New(1000)),
+			CacheTTL:                  5000,
+			InvalidateWhenUpdate:      true,
+			PreciseSearchInvalidation: true,
+		})
+		So(err, ShouldBeNil)
+
+		search := func(from int, to int) []TestModel {
+			models := make([]TestModel, 0)
+			So(db.Where("value1 BETWEEN ? AND ?", from, to).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, to-from+1)
+			return models
+		}
+		count := func() int64 {
+			var count int64
+			So(db.Model(&TestModel{}).Where("value1 BETWEEN ? AND ?", 165, 168).Count(&count).Error, ShouldBeNil)
+			return count
+		}
+		search(165, 166)
+		search(167, 168)
+		So(count(), ShouldEqual, 4)
+		So(c.HitCount(), ShouldEqual, 0)
+
+		So(db.Model(&TestModel{}).Where("id = ?", 165).Update("value9", "precise").Error, ShouldBeNil)
+		defer originalDB.Model(&TestModel{}).Where("id = ?", 165).Update("value9", "165")
+
+		// the search containing the row is reloaded, the unrelated one is still cached
+		So(search(165, 166)[0].Value9, ShouldEqual, "precise")
+		So(c.HitCount(), ShouldEqual, 0)
+		So(search(167, 168)[0].Value9, ShouldEqual, "167")
+		So(c.HitCount(), ShouldEqual, 1)
+		// results not tied to rows are always invalidated
+		So(count(), ShouldEqual, 4)
+		So(c.HitCount(), ShouldEqual, 1)
+
+		// without primary keys the whole search cache of the table is invalidated
+		So(db.Model(&TestModel{}).Where("value1 = ?", 166).Update("value9", "imprecise").Error, ShouldBeNil)
+		defer originalDB.Model(&TestModel{}).Where("id = ?", 166).Update("value9", "166")
+		So(search(165, 166)[1].Value9, ShouldEqual, "imprecise")
+		So(search(167, 168)[0].Value9, ShouldEqual, "167")
+		So(c.HitCount(), ShouldEqual, 1)
+	})
+}
+
+func TestPreciseSearchInvalidationSharedStorage(t *testing.T) {
+	Convey("test instances sharing a redis storage don't lose each other's index entries", t, func() {
+		mr := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		newInstance := func() *gorm.DB {
+			_, db, err := newCacheDB(&config.CacheConfig{
+				InstanceId:                "precise",
+				CacheLevel:                config.CacheLevelOnlySearch,
+				CacheStorage:              storage.NewRedis(&storage.RedisStoreConfig{KeyPrefix: "shared", Client: client}),
+				CacheTTL:                  5000,
+				InvalidateWhenUpdate:      true,
+				PreciseSearchInvalidation: true,
+			})
+			So(err, ShouldBeNil)
+			return db
+		}
+		dbs := []*gorm.DB{newInstance(), newInstance()}
+
+		// every search contains row 175, each instance caches half of them concurrently
+		const searches = 20
+		wg := sync.WaitGroup{}
+		for i := 0; i < searches; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				models := make([]TestModel, 0)
+				_ = dbs[i%2].Where("value1 BETWEEN ? AND ?", 175, 175+i).Find(&models).Error
+			}(i)
+		}
+		wg.Wait()
+
+		setKey := "shared:" + util.GenSearchDependencySetKey("precise", TestModelTableName, "175")
+		members, err := mr.SMembers(setKey)
+		So(err, ShouldBeNil)
+		So(len(members), ShouldEqual, searches)
+
+		So(dbs[0].Model(&TestModel{}).Where("id = ?", 175).Update("value9", "shared").Error, ShouldBeNil)
+		defer originalDB.Model(&TestModel{}).Where("id = ?", 175).Update("value9", "175")
+		for _, key := range mr.Keys() {
+			So(strings.Contains(key, ":s:"+TestModelTableName+":"), ShouldBeFalse)
+		}
+		So(mr.Exists(setKey), ShouldBeFalse)
+	})
+}
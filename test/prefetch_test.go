@@ -0,0 +1,73 @@
+package test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestPrefetchNextPage(t *testing.T) {
+	Convey("test a page hit prefetches the next page", t, func() {
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelOnlySearch,
+			CacheStorage: storage.NewGcache(gcache.New(1000)),
+			CacheTTL:     5000,
+			Prefetch:     cache.NextPage,
+		})
+		So(err, ShouldBeNil)
+
+		page := func(db *gorm.DB, n int) ([]TestModel, error) {
+			models := make([]TestModel, 0)
+			err := db.Where("value1 BETWEEN ? AND ?", 61, 80).Order("id").Limit(5).Offset(5 * n).Find(&models).Error
+			return models, err
+		}
+		// waits for the page to be cached, without querying the database
+		cachedPage := func(n int) []TestModel {
+			deadline := time.Now().Add(2 * time.Second)
+			for {
+				models, err := page(cache.CacheOnly(db), n)
+				if !errors.Is(err, util.ErrCacheOnlyMiss) || time.Now().After(deadline) {
+					So(err, ShouldBeNil)
+					return models
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+
+		_, err = page(db, 0)
+		So(err, ShouldBeNil)
+		_, err = page(cache.CacheOnly(db), 1)
+		So(errors.Is(err, util.ErrCacheOnlyMiss), ShouldBeTrue)
+
+		// the hit of page 0 warms page 1
+		_, err = page(db, 0)
+		So(err, ShouldBeNil)
+		So(c.HitCount(), ShouldEqual, 1)
+		models := cachedPage(1)
+		So(len(models), ShouldEqual, 5)
+		So(models[0].ID, ShouldEqual, 66)
+		So(models[4].ID, ShouldEqual, 70)
+
+		// and the hit of page 1 warms page 2
+		models, err = page(db, 1)
+		So(err, ShouldBeNil)
+		So(models[0].ID, ShouldEqual, 66)
+		models = cachedPage(2)
+		So(len(models), ShouldEqual, 5)
+		So(models[0].ID, ShouldEqual, 71)
+
+		// unpaged queries are not prefetched
+		all := make([]TestModel, 0)
+		So(db.Where("value1 BETWEEN ? AND ?", 61, 80).Find(&all).Error, ShouldBeNil)
+		So(db.Where("value1 BETWEEN ? AND ?", 61, 80).Find(&all).Error, ShouldBeNil)
+		So(len(all), ShouldEqual, 20)
+	})
+}
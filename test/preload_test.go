@@ -0,0 +1,62 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPreload(t *testing.T) {
+	Convey("test queries preloading associations cache them and are invalidated by writes to their tables", t, func() {
+		So(originalDB.AutoMigrate(&AuthorModel{}, &BookModel{}), ShouldBeNil)
+		defer originalDB.Migrator().DropTable(&AuthorModel{}, &BookModel{})
+		So(originalDB.Create(&AuthorModel{ID: 1, Name: "a", Books: []BookModel{{ID: 1, Title: "b1"}, {ID: 2, Title: "b2"}}}).Error, ShouldBeNil)
+
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:           config.CacheLevelAll,
+			CacheStorage:         storage.NewMemSync(nil),
+			CacheTTL:             5000,
+			InvalidateWhenUpdate: true,
+		})
+		So(err, ShouldBeNil)
+		authorHits := func() uint64 {
+			return c.TableStats(AuthorModelTableName).Search.HitCount
+		}
+		authors := func(preload bool) []AuthorModel {
+			models := make([]AuthorModel, 0)
+			query := db.Where("name = ?", "a")
+			if preload {
+				query = query.Preload("Books")
+			}
+			So(query.Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 1)
+			return models
+		}
+
+		// the result without books isn't served to the query preloading them
+		So(len(authors(false)[0].Books), ShouldEqual, 0)
+		So(len(authors(true)[0].Books), ShouldEqual, 2)
+		So(len(authors(true)[0].Books), ShouldEqual, 2)
+		So(len(authors(false)[0].Books), ShouldEqual, 0)
+		So(authorHits(), ShouldEqual, 2)
+
+		Convey("a write to the table of the associations drops the result", func() {
+			So(db.Create(&BookModel{ID: 3, AuthorID: 1, Title: "b3"}).Error, ShouldBeNil)
+			So(len(authors(true)[0].Books), ShouldEqual, 3)
+			So(authorHits(), ShouldEqual, 2)
+			So(len(authors(true)[0].Books), ShouldEqual, 3)
+			So(authorHits(), ShouldEqual, 3)
+		})
+
+		Convey("preloads with conditions aren't cached", func() {
+			for i := 0; i < 2; i++ {
+				models := make([]AuthorModel, 0)
+				So(db.Where("name = ?", "a").Preload("Books", "title = ?", "b1").Find(&models).Error, ShouldBeNil)
+				So(len(models[0].Books), ShouldEqual, 1)
+			}
+			So(authorHits(), ShouldEqual, 2)
+		})
+	})
+}
@@ -0,0 +1,122 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUUIDPrimaryKey(t *testing.T) {
+	Convey("test rows keyed by a uuid column are cached by it", t, func() {
+		So(originalDB.AutoMigrate(&UUIDModel{}), ShouldBeNil)
+		defer originalDB.Migrator().DropTable(&UUIDModel{})
+		So(originalDB.Create(&[]UUIDModel{{UUID: "u-1", Name: "a"}, {UUID: "u-2", Name: "b"}}).Error, ShouldBeNil)
+
+		store := storage.NewMemSync(nil)
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelOnlyPrimary,
+			CacheStorage: store,
+			CacheTTL:     5000,
+		})
+		So(err, ShouldBeNil)
+		instanceId := asGorm2Cache(c).InstanceId
+
+		for i := 0; i < 2; i++ {
+			model := UUIDModel{}
+			So(db.Where("uuid = ?", "u-1").First(&model).Error, ShouldBeNil)
+			So(model.Name, ShouldEqual, "a")
+		}
+		So(c.HitCount(), ShouldEqual, 1)
+		exists, err := store.KeyExists(context.Background(), util.GenPrimaryCacheKey(instanceId, UUIDModelTableName, "u-1"))
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeTrue)
+
+		models := make([]UUIDModel, 0)
+		So(db.Where("uuid IN (?)", []string{"u-1", "u-2"}).Find(&models).Error, ShouldBeNil)
+		So(len(models), ShouldEqual, 2)
+		So(c.HitCount(), ShouldEqual, 1)
+		So(db.Where("uuid IN (?)", []string{"u-1", "u-2"}).Find(&models).Error, ShouldBeNil)
+		So(len(models), ShouldEqual, 2)
+		So(c.HitCount(), ShouldEqual, 2)
+	})
+}
+
+func TestCompositePrimaryKey(t *testing.T) {
+	for _, columns := range [][]string{nil, {"code", "tenant_id"}} {
+		Convey("test rows of a composite key are cached by all of its columns", t, func() {
+			So(originalDB.AutoMigrate(&CompositeKeyModel{}), ShouldBeNil)
+			defer originalDB.Migrator().DropTable(&CompositeKeyModel{})
+			So(originalDB.Create(&[]CompositeKeyModel{
+				{TenantID: 1, Code: "a", Name: "x"}, {TenantID: 1, Code: "b", Name: "y"}, {TenantID: 2, Code: "a", Name: "z"},
+			}).Error, ShouldBeNil)
+
+			conf := &config.CacheConfig{
+				CacheLevel:           config.CacheLevelOnlyPrimary,
+				CacheStorage:         storage.NewMemSync(nil),
+				CacheTTL:             5000,
+				InvalidateWhenUpdate: true,
+			}
+			key := util.JoinPrimaryKey(1, "a")
+			if columns != nil {
+				conf.PrimaryKeyColumns = map[string][]string{CompositeKeyModelTableName: columns}
+				key = util.JoinPrimaryKey("a", 1)
+			}
+			c, db, err := newCacheDB(conf)
+			So(err, ShouldBeNil)
+			instanceId := asGorm2Cache(c).InstanceId
+
+			find := func(tenantID int64, code string) string {
+				model := CompositeKeyModel{}
+				So(db.Where("tenant_id = ?", tenantID).Where("code = ?", code).First(&model).Error, ShouldBeNil)
+				return model.Name
+			}
+			So(find(1, "a"), ShouldEqual, "x")
+			So(find(1, "a"), ShouldEqual, "x")
+			So(c.HitCount(), ShouldEqual, 1)
+			exists, err := conf.CacheStorage.KeyExists(context.Background(),
+				util.GenPrimaryCacheKey(instanceId, CompositeKeyModelTableName, key))
+			So(err, ShouldBeNil)
+			So(exists, ShouldBeTrue)
+
+			// rows sharing one of the columns are different keys
+			So(find(1, "b"), ShouldEqual, "y")
+			So(find(2, "a"), ShouldEqual, "z")
+			So(c.HitCount(), ShouldEqual, 1)
+
+			// a lookup by a part of the key can't be served from the primary cache
+			models := make([]CompositeKeyModel, 0)
+			So(db.Where("tenant_id = ?", 1).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 2)
+			So(c.HitCount(), ShouldEqual, 1)
+
+			So(db.Model(&CompositeKeyModel{}).Where("tenant_id = ?", 1).Where("code = ?", "a").
+				Update("name", "x2").Error, ShouldBeNil)
+			So(find(1, "a"), ShouldEqual, "x2")
+			So(find(1, "b"), ShouldEqual, "y")
+			So(c.HitCount(), ShouldEqual, 2)
+
+			// updating a slice of models invalidates the keys of its models only
+			So(db.Model(&[]CompositeKeyModel{{TenantID: 1, Code: "b"}}).Update("name", "y2").Error, ShouldBeNil)
+			So(find(1, "b"), ShouldEqual, "y2")
+			So(find(1, "a"), ShouldEqual, "x2")
+			So(c.HitCount(), ShouldEqual, 3)
+
+			// deleting a model invalidates its key only
+			deletedKey := util.JoinPrimaryKey(2, "a")
+			if columns != nil {
+				deletedKey = util.JoinPrimaryKey("a", 2)
+			}
+			So(db.Delete(&CompositeKeyModel{TenantID: 2, Code: "a"}).Error, ShouldBeNil)
+			exists, err = conf.CacheStorage.KeyExists(context.Background(),
+				util.GenPrimaryCacheKey(instanceId, CompositeKeyModelTableName, deletedKey))
+			So(err, ShouldBeNil)
+			So(exists, ShouldBeFalse)
+			So(find(1, "a"), ShouldEqual, "x2")
+			So(c.HitCount(), ShouldEqual, 4)
+		})
+	}
+}
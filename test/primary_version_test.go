@@ -0,0 +1,127 @@
+package test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	"github.com/redis/go-redis/v9"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestVersionerStorages(t *testing.T) {
+	Convey("test versioner storages only write keys whose versions didn't move past the version", t, func() {
+		mr := miniredis.RunT(t)
+		stores := map[string]storage.DataStorage{
+			"memory":  storage.NewMem(),
+			"memsync": storage.NewMemSync(nil),
+			"redis":   storage.NewRedis(&storage.RedisStoreConfig{Options: &redis.Options{Addr: mr.Addr()}}),
+		}
+		ctx := context.Background()
+		for name, store := range stores {
+			So(store.Init(&storage.Config{TTL: 5000, Logger: &util.DefaultLogger{}}), ShouldBeNil)
+			versioner := store.(storage.Versioner)
+
+			version, err := versioner.BumpVersion(ctx, name+":seq", []string{name + ":v1"}, 5000)
+			So(err, ShouldBeNil)
+			So(version, ShouldEqual, 1)
+			version, err = versioner.BumpVersion(ctx, name+":seq", []string{name + ":v2"}, 5000)
+			So(err, ShouldBeNil)
+			So(version, ShouldEqual, 2)
+
+			written, err := versioner.SetKeysIfVersion(ctx, []util.Kv{
+				{Key: name + ":k1", Value: "1"},
+				{Key: name + ":k2", Value: "2"},
+				{Key: name + ":k3", Value: "3"},
+			}, [][]string{{name + ":v1"}, {name + ":v2"}, {name + ":v3"}}, 1)
+			So(err, ShouldBeNil)
+			So(written, ShouldResemble, []bool{true, false, true})
+
+			_, err = store.GetValue(ctx, name+":k2")
+			So(err, ShouldEqual, storage.ErrCacheNotFound)
+			value, err := store.GetValue(ctx, name+":k3")
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, "3")
+		}
+	})
+}
+
+func TestPrimaryCacheVersioning(t *testing.T) {
+	newVersionedDB := func(versioning bool) (*gorm.DB, func() int64) {
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:             config.CacheLevelOnlyPrimary,
+			CacheStorage:           storage.NewMemSync(nil),
+			CacheTTL:               5000,
+			PrimaryCacheVersioning: versioning,
+		})
+		So(err, ShouldBeNil)
+		gc := asGorm2Cache(c)
+
+		// a concurrent write landing once the row was read from the database, before it is cached
+		var writes int32
+		err = db.Callback().Query().After("gorm:query").Before("gorm:cache:after_query").Register("test:concurrent_write", func(db *gorm.DB) {
+			if atomic.CompareAndSwapInt32(&writes, 0, 1) {
+				So(gc.InvalidatePrimaryCache(db.Statement.Context, TestModelTableName, "7"), ShouldBeNil)
+			}
+		})
+		So(err, ShouldBeNil)
+		return db, func() int64 {
+			count, err := gc.CountPrimaryCache(context.Background(), TestModelTableName)
+			So(err, ShouldBeNil)
+			return count
+		}
+	}
+
+	Convey("test primary cache versioning keeps rows read before a write out of the cache", t, func() {
+		db, count := newVersionedDB(true)
+		model := TestModel{}
+		So(db.Where("id = ?", 7).First(&model).Error, ShouldBeNil)
+		So(model.ID, ShouldEqual, 7)
+		So(count(), ShouldEqual, 0)
+
+		// queries started after the write cache the row again
+		So(db.Where("id = ?", 7).First(&TestModel{}).Error, ShouldBeNil)
+		So(count(), ShouldEqual, 1)
+	})
+
+	Convey("test rows read before a write are cached without primary cache versioning", t, func() {
+		db, count := newVersionedDB(false)
+		So(db.Where("id = ?", 7).First(&TestModel{}).Error, ShouldBeNil)
+		So(count(), ShouldEqual, 1)
+	})
+
+	Convey("test get or set primary doesn't cache values loaded before a write", t, func() {
+		c, _, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:             config.CacheLevelOnlyPrimary,
+			CacheStorage:           storage.NewMemSync(nil),
+			CacheTTL:               5000,
+			PrimaryCacheVersioning: true,
+		})
+		So(err, ShouldBeNil)
+		gc := asGorm2Cache(c)
+		ctx := context.Background()
+
+		value, err := gc.GetOrSetPrimary(ctx, TestModelTableName, "9", func() (string, error) {
+			So(gc.InvalidatePrimaryCache(ctx, TestModelTableName, "9"), ShouldBeNil)
+			return `{"id":9}`, nil
+		})
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, `{"id":9}`)
+		exists, err := gc.BatchPrimaryKeyExists(ctx, TestModelTableName, []string{"9"})
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeFalse)
+
+		_, err = gc.GetOrSetPrimary(ctx, TestModelTableName, "9", func() (string, error) {
+			return `{"id":9}`, nil
+		})
+		So(err, ShouldBeNil)
+		exists, err = gc.BatchPrimaryKeyExists(ctx, TestModelTableName, []string{"9"})
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeTrue)
+	})
+}
@@ -0,0 +1,71 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRawExecInvalidation(t *testing.T) {
+	Convey("test raw writes invalidate the table they write", t, func() {
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:           config.CacheLevelAll,
+			CacheStorage:         storage.NewGcache(gcache.New(1000)),
+			CacheTTL:             5000,
+			InvalidateWhenUpdate: true,
+		})
+		So(err, ShouldBeNil)
+		defer originalDB.Model(&TestModel{}).Where("id = ?", 185).Update("value9", "185")
+
+		// returns value9 of row 185 read by search and by primary key
+		query := func() (string, string) {
+			models := make([]TestModel, 0)
+			So(db.Where("value1 BETWEEN ? AND ?", 184, 186).Order("id").Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 3)
+			model := TestModel{}
+			So(db.Where("id = ?", 185).First(&model).Error, ShouldBeNil)
+			return models[1].Value9, model.Value9
+		}
+		exec := func(sql string, vars ...interface{}) {
+			So(db.Exec(sql, vars...).Error, ShouldBeNil)
+		}
+		query()
+		query()
+		So(c.HitCount(), ShouldEqual, 3)
+
+		Convey("written by a recognized statement", func() {
+			exec("UPDATE gorm_cache_model SET value9 = ? WHERE id = ?", "raw", 185)
+			searched, primary := query()
+			So(searched, ShouldEqual, "raw")
+			So(primary, ShouldEqual, "raw")
+
+			exec("update `main`.`gorm_cache_model` set value9 = ? where id = ?", "quoted", 185)
+			searched, primary = query()
+			So(searched, ShouldEqual, "quoted")
+			So(primary, ShouldEqual, "quoted")
+		})
+
+		Convey("written by an unrecognized statement", func() {
+			exec("WITH t AS (SELECT ? AS id) UPDATE gorm_cache_model SET value9 = ? WHERE id IN (SELECT id FROM t)", 185, "with")
+			searched, primary := query()
+			So(searched, ShouldEqual, "185")
+			So(primary, ShouldEqual, "185")
+
+			So(asGorm2Cache(c).InvalidateTable(context.Background(), TestModelTableName), ShouldBeNil)
+			searched, primary = query()
+			So(searched, ShouldEqual, "with")
+			So(primary, ShouldEqual, "with")
+		})
+
+		Convey("not written", func() {
+			exec("UPDATE gorm_cache_model SET value9 = ? WHERE id = ?", "none", -185)
+			So(db.Exec("SELECT 1").Error, ShouldBeNil)
+			query()
+			So(c.HitCount(), ShouldEqual, 5)
+		})
+	})
+}
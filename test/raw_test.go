@@ -0,0 +1,66 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type rawAggregate struct {
+	Cnt   int64
+	Total int64
+}
+
+func TestRawScan(t *testing.T) {
+	Convey("test raw aggregate query cached by declared tables", t, func() {
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:           config.CacheLevelOnlySearch,
+			CacheStorage:         storage.NewGcache(gcache.New(1000)),
+			InvalidateWhenUpdate: true,
+			CacheTTL:             5000,
+		})
+		So(err, ShouldBeNil)
+		gc := asGorm2Cache(c)
+		options := &cache.RawOptions{
+			Key:    "value2-total",
+			Tables: []string{"gorm_cache_model", "gorm_cache_audit"},
+		}
+		scan := func() rawAggregate {
+			result := rawAggregate{}
+			err := gc.RawScan(db.Raw("SELECT COUNT(*) AS cnt, SUM(value2) AS total FROM gorm_cache_model WHERE value1 BETWEEN ? AND ?", 131, 135), &result, options)
+			So(err, ShouldBeNil)
+			return result
+		}
+
+		original := &TestModel{}
+		So(originalDB.Where("id = ?", 133).First(original).Error, ShouldBeNil)
+		defer originalDB.Model(&TestModel{}).Where("id = ?", 133).Update("value2", original.Value2)
+
+		first := scan()
+		So(first.Cnt, ShouldEqual, 5)
+		So(first.Total, ShouldEqual, 131+132+133+134+135)
+		So(c.HitCount(), ShouldEqual, 0)
+
+		So(scan(), ShouldResemble, first)
+		So(c.HitCount(), ShouldEqual, 1)
+
+		Convey("a write to a dependency table invalidates it", func() {
+			So(db.Model(&TestModel{}).Where("id = ?", 133).Update("value2", original.Value2+100).Error, ShouldBeNil)
+			So(scan().Total, ShouldEqual, first.Total+100)
+			So(c.HitCount(), ShouldEqual, 1)
+			So(scan().Total, ShouldEqual, first.Total+100)
+			So(c.HitCount(), ShouldEqual, 2)
+		})
+
+		Convey("invalidating any declared table invalidates it", func() {
+			So(gc.InvalidateSearchCache(context.Background(), "gorm_cache_audit"), ShouldBeNil)
+			So(scan(), ShouldResemble, first)
+			So(c.HitCount(), ShouldEqual, 1)
+		})
+	})
+}
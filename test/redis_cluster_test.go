@@ -0,0 +1,85 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	"github.com/redis/go-redis/v9"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRedisClusterStorage(t *testing.T) {
+	Convey("test the redis cluster storage", t, func() {
+		// miniredis serves every slot of the cluster from a single node
+		mr := miniredis.RunT(t)
+		So(mr.Set("foreign:key", "kept"), ShouldBeNil)
+
+		store := storage.NewRedisCluster(&storage.RedisClusterStoreConfig{
+			KeyPrefix: "app",
+			Options:   &redis.ClusterOptions{Addrs: []string{mr.Addr()}},
+		})
+		So(store.Init(&storage.Config{TTL: 5000, Logger: &util.DefaultLogger{}}), ShouldBeNil)
+		ctx := context.Background()
+
+		Convey("batch operations are served key by key", func() {
+			So(store.Ping(ctx), ShouldBeNil)
+			So(store.BatchSetKeys(ctx, []util.Kv{{Key: "k1", Value: "v1"}, {Key: "k2", Value: "v2"}}), ShouldBeNil)
+			So(mr.Exists("app:k1"), ShouldBeTrue)
+
+			exists, err := store.BatchKeyExist(ctx, []string{"k1", "k2"})
+			So(err, ShouldBeNil)
+			So(exists, ShouldBeTrue)
+			exists, err = store.BatchKeyExist(ctx, []string{"k1", "k3"})
+			So(err, ShouldBeNil)
+			So(exists, ShouldBeFalse)
+
+			values, err := store.BatchGetValues(ctx, []string{"k1", "k3", "k2"})
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []string{"v1", "", "v2"})
+
+			So(store.BatchDeleteKeys(ctx, []string{"k1", "k2"}), ShouldBeNil)
+			_, err = store.GetValue(ctx, "k1")
+			So(err, ShouldEqual, storage.ErrCacheNotFound)
+		})
+
+		Convey("prefix deletes and clean cache keep the keys of others", func() {
+			So(store.BatchSetKeys(ctx, []util.Kv{
+				{Key: "gormcache:1:s:t1:0", Value: "v"}, {Key: "gormcache:1:s:t1:1", Value: "v"}, {Key: "gormcache:1:s:t10:0", Value: "v"},
+			}), ShouldBeNil)
+			So(store.DeleteKeysWithPrefix(ctx, "gormcache:1:s:t1"), ShouldBeNil)
+			count, err := store.CountKeysWithPrefix(ctx, "gormcache:1:s:")
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 1)
+
+			So(store.CleanCache(ctx), ShouldBeNil)
+			So(mr.Keys(), ShouldResemble, []string{"foreign:key"})
+		})
+
+		Convey("caches queries and invalidates them", func() {
+			c, db, err := newCacheDB(&config.CacheConfig{
+				CacheLevel:   config.CacheLevelAll,
+				CacheStorage: store,
+				CacheTTL:     5000,
+			})
+			So(err, ShouldBeNil)
+			gorm2Cache := asGorm2Cache(c)
+			prefix := util.GenSearchCachePrefix(gorm2Cache.InstanceId, TestModelTableName)
+
+			models := make([]TestModel, 0)
+			So(db.Where("value1 = ?", 198).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 1)
+			count, err := store.CountKeysWithPrefix(ctx, prefix)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 1)
+
+			So(gorm2Cache.InvalidateSearchCache(ctx, TestModelTableName), ShouldBeNil)
+			count, err = store.CountKeysWithPrefix(ctx, prefix)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 0)
+		})
+	})
+}
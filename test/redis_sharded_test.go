@@ -0,0 +1,111 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	"github.com/redis/go-redis/v9"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestShardedRedis(t *testing.T) {
+	Convey("test sharded redis spreads keys over its shards by consistent hashing", t, func() {
+		servers := make([]*miniredis.Miniredis, 0, 4)
+		options := make([]*redis.Options, 0, 4)
+		for i := 0; i < 4; i++ {
+			server := miniredis.RunT(t)
+			servers = append(servers, server)
+			options = append(options, &redis.Options{Addr: server.Addr()})
+		}
+		store := storage.NewShardedRedis(&storage.ShardedRedisStoreConfig{KeyPrefix: "sharded", Options: options[:3]})
+		So(store.Init(&storage.Config{TTL: 5000, Logger: &util.DefaultLogger{}}), ShouldBeNil)
+		ctx := context.Background()
+
+		kvs := make([]util.Kv, 0, 300)
+		keys := make([]string, 0, 300)
+		for i := 0; i < 300; i++ {
+			key := fmt.Sprintf("gormcache:inst:p:table:%d", i)
+			kvs = append(kvs, util.Kv{Key: key, Value: fmt.Sprint(i)})
+			keys = append(keys, key)
+		}
+		So(store.BatchSetKeys(ctx, kvs), ShouldBeNil)
+		// the ring hashes the addresses of the shards, random ports of miniredis, so the shares vary between runs
+		for _, server := range servers[:3] {
+			So(len(server.Keys()), ShouldBeGreaterThan, 20)
+		}
+
+		values, err := store.BatchGetValues(ctx, append(keys[:10:10], "missing"))
+		So(err, ShouldBeNil)
+		So(values, ShouldResemble, []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9", ""})
+		exists, err := store.BatchKeyExist(ctx, keys)
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeTrue)
+
+		count, err := store.CountKeysWithPrefix(ctx, "gormcache:inst:p:table:")
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 300)
+		So(store.DeleteKeysWithPrefix(ctx, "gormcache:inst:p:table"), ShouldBeNil)
+		count, err = store.CountKeysWithPrefix(ctx, "gormcache:inst:p:table:")
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 0)
+
+		Convey("adding a shard only moves the keys of its share", func() {
+			grown := storage.NewShardedRedis(&storage.ShardedRedisStoreConfig{KeyPrefix: "sharded", Options: options})
+			So(grown.Init(&storage.Config{TTL: 5000, Logger: &util.DefaultLogger{}}), ShouldBeNil)
+			So(store.BatchSetKeys(ctx, kvs), ShouldBeNil)
+
+			values, err := grown.BatchGetValues(ctx, keys)
+			So(err, ShouldBeNil)
+			kept := 0
+			for _, value := range values {
+				if value != "" {
+					kept++
+				}
+			}
+			So(kept, ShouldBeLessThan, 300)
+			// the keys not found are exactly the ones the new shard owns, none moved between the old shards
+			So(grown.BatchSetKeys(ctx, kvs), ShouldBeNil)
+			So(len(servers[3].Keys()), ShouldEqual, 300-kept)
+		})
+
+		Convey("shard stats report the health and counts of every shard", func() {
+			servers[1].Close()
+			stats := store.ShardStats(ctx)
+			So(len(stats), ShouldEqual, 3)
+			share := 0.0
+			for idx, stat := range stats {
+				So(stat.Addr, ShouldEqual, options[idx].Addr)
+				So(stat.Healthy, ShouldEqual, idx != 1)
+				So(stat.Operations, ShouldBeGreaterThan, 0)
+				share += stat.Share
+			}
+			So(stats[1].Error, ShouldNotBeEmpty)
+			So(share, ShouldAlmostEqual, 1, 0.0001)
+			So(store.Ping(ctx), ShouldNotBeNil)
+		})
+	})
+
+	Convey("test sharded redis caches queries", t, func() {
+		clients := make([]*redis.Client, 0, 2)
+		for i := 0; i < 2; i++ {
+			clients = append(clients, redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()}))
+		}
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelAll,
+			CacheStorage: storage.NewShardedRedis(&storage.ShardedRedisStoreConfig{Clients: clients}),
+			CacheTTL:     5000,
+		})
+		So(err, ShouldBeNil)
+		for i := 0; i < 2; i++ {
+			models := make([]TestModel, 0)
+			So(db.Where("id IN ?", []int64{31, 32, 33, 34}).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 4)
+		}
+		So(c.HitCount(), ShouldEqual, 1)
+	})
+}
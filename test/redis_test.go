@@ -0,0 +1,112 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	"github.com/redis/go-redis/v9"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRedisStorage(t *testing.T) {
+	Convey("test the redis storage keeps to its key prefix", t, func() {
+		mr := miniredis.RunT(t)
+		So(mr.Set("foreign:key", "kept"), ShouldBeNil)
+
+		store := storage.NewRedis(&storage.RedisStoreConfig{
+			KeyPrefix: "app",
+			Options:   &redis.Options{Addr: mr.Addr()},
+		})
+		So(store.Init(&storage.Config{TTL: 5000, Logger: &util.DefaultLogger{}}), ShouldBeNil)
+		ctx := context.Background()
+
+		Convey("keys are stored under the prefix", func() {
+			So(store.SetKey(ctx, util.Kv{Key: "k1", Value: "v1"}), ShouldBeNil)
+			So(mr.Exists("app:k1"), ShouldBeTrue)
+
+			value, err := store.GetValue(ctx, "k1")
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, "v1")
+		})
+
+		Convey("delete keys with prefix", func() {
+			kvs := make([]util.Kv, 0)
+			for i := 0; i < 2500; i++ {
+				kvs = append(kvs, util.Kv{Key: fmt.Sprintf("gormcache:1:s:t1:%d", i), Value: "v"})
+			}
+			kvs = append(kvs, util.Kv{Key: "gormcache:1:s:t2:0", Value: "v"}, util.Kv{Key: "gormcache:1:s:t10:0", Value: "v"})
+			So(store.BatchSetKeys(ctx, kvs), ShouldBeNil)
+
+			So(store.DeleteKeysWithPrefix(ctx, "gormcache:1:s:t?"), ShouldBeNil)
+			count, err := store.CountKeysWithPrefix(ctx, "gormcache:1:s:t1:")
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 2500)
+
+			So(store.DeleteKeysWithPrefix(ctx, "gormcache:1:s:t1"), ShouldBeNil)
+			count, err = store.CountKeysWithPrefix(ctx, "gormcache:1:s:t1:")
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 0)
+			So(mr.Keys(), ShouldResemble, []string{"app:gormcache:1:s:t10:0", "app:gormcache:1:s:t2:0", "foreign:key"})
+		})
+
+		Convey("clean cache keeps the keys of others", func() {
+			So(store.BatchSetKeys(ctx, []util.Kv{{Key: "k1", Value: "v1"}, {Key: "k2", Value: "v2"}}), ShouldBeNil)
+			So(store.CleanCache(ctx), ShouldBeNil)
+			So(mr.Keys(), ShouldResemble, []string{"foreign:key"})
+		})
+
+		Convey("large batches are split into commands of BatchSize keys", func() {
+			store := storage.NewRedis(&storage.RedisStoreConfig{
+				KeyPrefix: "batch",
+				Options:   &redis.Options{Addr: mr.Addr()},
+				BatchSize: 2,
+			})
+			So(store.Init(&storage.Config{Logger: &util.DefaultLogger{}}), ShouldBeNil)
+			kvs := make([]util.Kv, 0)
+			keys := make([]string, 0)
+			for i := 0; i < 5; i++ {
+				kvs = append(kvs, util.Kv{Key: fmt.Sprintf("k%d", i), Value: fmt.Sprintf("v%d", i)})
+				keys = append(keys, fmt.Sprintf("k%d", i))
+			}
+
+			commands := mr.CommandCount()
+			So(store.BatchSetKeys(ctx, kvs), ShouldBeNil)
+			So(mr.CommandCount()-commands, ShouldEqual, 3)
+
+			commands = mr.CommandCount()
+			values, err := store.BatchGetValues(ctx, append(keys, "missing"))
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []string{"v0", "v1", "v2", "v3", "v4", ""})
+			So(mr.CommandCount()-commands, ShouldEqual, 3)
+
+			So(store.BatchDeleteKeys(ctx, keys[1:]), ShouldBeNil)
+			values, err = store.BatchGetValues(ctx, keys)
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []string{"v0", "", "", "", ""})
+		})
+
+		Convey("every write expires", func() {
+			So(store.SetKey(ctx, util.Kv{Key: "k1", Value: "v1"}), ShouldBeNil)
+			So(store.SetKey(ctx, util.Kv{Key: "k2", Value: "v2", TTL: 1000}), ShouldBeNil)
+			So(store.BatchSetKeys(ctx, []util.Kv{{Key: "k3", Value: "v3"}, {Key: "k4", Value: "v4", TTL: 1000}}), ShouldBeNil)
+			for _, key := range []string{"app:k1", "app:k3"} {
+				So(mr.TTL(key), ShouldBeGreaterThan, time.Second)
+				So(mr.TTL(key), ShouldBeLessThanOrEqualTo, 5*time.Second*11/10)
+			}
+			for _, key := range []string{"app:k2", "app:k4"} {
+				So(mr.TTL(key), ShouldBeGreaterThan, 0)
+				So(mr.TTL(key), ShouldBeLessThanOrEqualTo, time.Second*11/10)
+			}
+
+			mr.FastForward(2 * time.Second)
+			So(mr.Keys(), ShouldResemble, []string{"app:k1", "app:k3", "foreign:key"})
+			mr.FastForward(4 * time.Second)
+			So(mr.Keys(), ShouldResemble, []string{"foreign:key"})
+		})
+	})
+}
@@ -0,0 +1,105 @@
+package test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/redis/go-redis/v9"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// expireCountingStorage counts the keys whose expiry is refreshed
+type expireCountingStorage struct {
+	*storage.MemSync
+	expired int64
+}
+
+func (s *expireCountingStorage) ExpireKeys(ctx context.Context, keys []string, ttl int64) error {
+	atomic.AddInt64(&s.expired, int64(len(keys)))
+	return s.MemSync.ExpireKeys(ctx, keys, ttl)
+}
+
+func TestRefreshTTLOnHit(t *testing.T) {
+	Convey("test hits restart the ttl of the keys they hit", t, func() {
+		clock := storage.NewManualClock(time.Unix(0, 0))
+		newDB := func(refresh bool) (func() uint64, *expireCountingStorage) {
+			store := &expireCountingStorage{MemSync: storage.NewMemSync(clock)}
+			c, db, err := newCacheDB(&config.CacheConfig{
+				CacheLevel:      config.CacheLevelAll,
+				CacheStorage:    store,
+				CacheTTL:        1000,
+				RefreshTTLOnHit: refresh,
+			})
+			So(err, ShouldBeNil)
+			return func() uint64 {
+				models := make([]TestModel, 0)
+				So(db.Where("value1 BETWEEN ? AND ?", 44, 46).Find(&models).Error, ShouldBeNil)
+				So(len(models), ShouldEqual, 3)
+
+				model := TestModel{}
+				So(db.Where("id = ?", 47).First(&model).Error, ShouldBeNil)
+				So(model.Value1, ShouldEqual, 47)
+				return c.HitCount()
+			}, store
+		}
+
+		Convey("refreshed on hits only", func() {
+			query, store := newDB(true)
+			So(query(), ShouldEqual, 0)
+			So(atomic.LoadInt64(&store.expired), ShouldEqual, 0)
+
+			clock.Advance(600 * time.Millisecond)
+			So(query(), ShouldEqual, 2)
+			So(atomic.LoadInt64(&store.expired), ShouldEqual, 2)
+
+			// past the ttl of the first write, but not of the refresh
+			clock.Advance(600 * time.Millisecond)
+			So(query(), ShouldEqual, 4)
+
+			clock.Advance(time.Second)
+			So(query(), ShouldEqual, 4)
+			So(atomic.LoadInt64(&store.expired), ShouldEqual, 4)
+		})
+
+		Convey("not refreshed when disabled", func() {
+			query, store := newDB(false)
+			So(query(), ShouldEqual, 0)
+			clock.Advance(600 * time.Millisecond)
+			So(query(), ShouldEqual, 2)
+			clock.Advance(600 * time.Millisecond)
+			So(query(), ShouldEqual, 2)
+			So(atomic.LoadInt64(&store.expired), ShouldEqual, 0)
+		})
+	})
+
+	Convey("test redis refreshes the expiry in place", t, func() {
+		mr := miniredis.RunT(t)
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:      config.CacheLevelOnlySearch,
+			CacheStorage:    storage.NewRedis(&storage.RedisStoreConfig{Options: &redis.Options{Addr: mr.Addr()}}),
+			CacheTTL:        10000,
+			RefreshTTLOnHit: true,
+		})
+		So(err, ShouldBeNil)
+
+		query := func() {
+			models := make([]TestModel, 0)
+			So(db.Where("value1 BETWEEN ? AND ?", 48, 50).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 3)
+		}
+		query()
+		keys := mr.Keys()
+		So(len(keys), ShouldEqual, 1)
+
+		mr.FastForward(5 * time.Second)
+		So(mr.TTL(keys[0]), ShouldBeLessThanOrEqualTo, 6*time.Second)
+		query()
+		So(c.HitCount(), ShouldEqual, 1)
+		So(mr.TTL(keys[0]), ShouldBeGreaterThanOrEqualTo, 9*time.Second)
+	})
+}
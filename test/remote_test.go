@@ -0,0 +1,120 @@
+package test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	"github.com/redis/go-redis/v9"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// newRemote serves store over http, returning a client of the server and the function stopping it
+func newRemote(store storage.DataStorage, serverToken string, clientToken string) (*storage.Remote, func()) {
+	server := storage.NewRemoteServer(&storage.RemoteServerConfig{Storage: store, Token: serverToken})
+	So(server.Init(&storage.Config{TTL: 5000, Logger: &util.DefaultLogger{}}), ShouldBeNil)
+	httpServer := httptest.NewServer(server)
+	remote := storage.NewRemote(&storage.RemoteStoreConfig{Endpoint: httpServer.URL, Token: clientToken})
+	So(remote.Init(&storage.Config{TTL: 5000, Logger: &util.DefaultLogger{}}), ShouldBeNil)
+	return remote, httpServer.Close
+}
+
+func TestRemoteStorage(t *testing.T) {
+	Convey("test the remote storage runs its operations on the storage of the server", t, func() {
+		ctx := context.Background()
+		inner := storage.NewMemSync(nil)
+		remote, stop := newRemote(inner, "secret", "secret")
+		defer stop()
+
+		So(remote.Ping(ctx), ShouldBeNil)
+		So(remote.BatchSetKeys(ctx, []util.Kv{{Key: "p:t1:1", Value: "v1"}, {Key: "p:t1:2", Value: "v2", TTL: 1000}, {Key: "p:t2:1", Value: ""}}), ShouldBeNil)
+		value, err := inner.GetValue(ctx, "p:t1:1")
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, "v1")
+
+		value, err = remote.GetValue(ctx, "p:t2:1")
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, "")
+		_, err = remote.GetValue(ctx, "p:t3:1")
+		So(err, ShouldEqual, storage.ErrCacheNotFound)
+		values, err := remote.BatchGetValues(ctx, []string{"p:t1:2", "p:t3:1", "p:t1:1"})
+		So(err, ShouldBeNil)
+		So(values, ShouldResemble, []string{"v2", "", "v1"})
+		exists, err := remote.BatchKeyExist(ctx, []string{"p:t1:1", "p:t1:2"})
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeTrue)
+
+		ttl, err := remote.KeyTTL(ctx, "p:t1:2")
+		So(err, ShouldBeNil)
+		So(ttl, ShouldBeBetweenOrEqual, 1, 1100)
+		count, err := remote.CountKeysWithPrefix(ctx, "p:t1:")
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 2)
+
+		So(remote.DeleteKeysWithPrefix(ctx, "p:t1:"), ShouldBeNil)
+		keys, err := remote.ListKeysWithPrefix(ctx, "p:", 10)
+		So(err, ShouldBeNil)
+		So(keys, ShouldResemble, []string{"p:t2:1"})
+
+		version, err := remote.BumpVersion(ctx, "seq", []string{"v:1"}, 0)
+		So(err, ShouldBeNil)
+		So(version, ShouldEqual, 1)
+		written, err := remote.SetKeysIfVersion(ctx, []util.Kv{{Key: "k1", Value: "old"}}, [][]string{{"v:1"}}, 0)
+		So(err, ShouldBeNil)
+		So(written, ShouldResemble, []bool{false})
+
+		Convey("operations the storage of the server lacks are unsupported", func() {
+			_, err := remote.TryLock(ctx, "lock", "token", 1000)
+			So(err, ShouldEqual, storage.ErrRemoteUnsupported)
+		})
+
+		Convey("requests without the token of the server are rejected", func() {
+			other, stop := newRemote(inner, "secret", "wrong")
+			defer stop()
+			So(other.Ping(ctx), ShouldNotBeNil)
+			_, err := other.GetValue(ctx, "p:t2:1")
+			So(err, ShouldNotBeNil)
+			So(err, ShouldNotEqual, storage.ErrCacheNotFound)
+		})
+
+		Convey("locks of a redis storage are served", func() {
+			mr := miniredis.RunT(t)
+			remote, stop := newRemote(storage.NewRedis(&storage.RedisStoreConfig{Options: &redis.Options{Addr: mr.Addr()}}), "", "")
+			defer stop()
+			locked, err := remote.TryLock(ctx, "lock", "a", 1000)
+			So(err, ShouldBeNil)
+			So(locked, ShouldBeTrue)
+			locked, err = remote.TryLock(ctx, "lock", "b", 1000)
+			So(err, ShouldBeNil)
+			So(locked, ShouldBeFalse)
+			So(remote.Unlock(ctx, "lock", "a"), ShouldBeNil)
+		})
+	})
+
+	Convey("test queries are cached in the storage of a remote server", t, func() {
+		inner := storage.NewMemSync(nil)
+		remote, stop := newRemote(inner, "secret", "secret")
+		defer stop()
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelAll,
+			CacheStorage: remote,
+			CacheTTL:     5000,
+			InstanceId:   "remote",
+		})
+		So(err, ShouldBeNil)
+
+		for i := 0; i < 2; i++ {
+			models := make([]TestModel, 0)
+			So(db.Where("value1 BETWEEN ? AND ?", 61, 63).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 3)
+		}
+		So(c.HitCount(), ShouldEqual, 1)
+		count, err := inner.CountKeysWithPrefix(context.Background(), "")
+		So(err, ShouldBeNil)
+		So(count, ShouldBeGreaterThan, 0)
+	})
+}
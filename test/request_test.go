@@ -0,0 +1,59 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestRequestCache(t *testing.T) {
+	Convey("test queries of a request are memoized until the request writes their table", t, func() {
+		defer originalDB.Model(&TestModel{ID: 161}).Update("value2", 161)
+
+		store := storage.NewMemSync(nil)
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:           config.CacheLevelAll,
+			CacheStorage:         store,
+			CacheTTL:             5000,
+			InvalidateWhenUpdate: true,
+		})
+		So(err, ShouldBeNil)
+		requestDB := db.WithContext(cache.WithRequestCache(context.Background()))
+		search := func(db *gorm.DB) int64 {
+			models := make([]TestModel, 0)
+			So(db.Where("value1 BETWEEN ? AND ?", 161, 163).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 3)
+			return models[0].Value2
+		}
+
+		So(search(requestDB), ShouldEqual, 161)
+		So(search(requestDB), ShouldEqual, 161)
+		So(c.HitCounts().Request, ShouldEqual, 1)
+
+		// served from memory, the storage isn't read
+		So(store.CleanCache(context.Background()), ShouldBeNil)
+		So(search(requestDB), ShouldEqual, 161)
+		So(c.HitCounts().Request, ShouldEqual, 2)
+		So(search(db), ShouldEqual, 161)
+		So(c.HitCounts().Request, ShouldEqual, 2)
+
+		Convey("a write of the request drops the results of its table", func() {
+			So(requestDB.Model(&TestModel{ID: 161}).Update("value2", 1000).Error, ShouldBeNil)
+			So(search(requestDB), ShouldEqual, 1000)
+			So(search(requestDB), ShouldEqual, 1000)
+			So(c.HitCounts().Request, ShouldEqual, 3)
+		})
+
+		Convey("record not found is memoized", func() {
+			for i := 0; i < 2; i++ {
+				So(requestDB.Where("id = ?", 100000).First(&TestModel{}).Error, ShouldEqual, gorm.ErrRecordNotFound)
+			}
+			So(c.HitCounts().RecordNotFound, ShouldEqual, 1)
+		})
+	})
+}
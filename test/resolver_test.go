@@ -0,0 +1,75 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestReplicaRouting(t *testing.T) {
+	Convey("test queries routed to a replica, as by gorm dbresolver, are cached and invalidated by writes to the source", t, func() {
+		replicaDB, err := forkDB(originalDB)
+		So(err, ShouldBeNil)
+		replica := &recordingConnPool{ConnPool: replicaDB.ConnPool}
+
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:           config.CacheLevelAll,
+			CacheStorage:         storage.NewMemSync(nil),
+			CacheTTL:             5000,
+			InvalidateWhenUpdate: true,
+		})
+		So(err, ShouldBeNil)
+		// reads outside of transactions go to the replica, like the replicas of dbresolver
+		err = db.Callback().Query().Before("gorm:query").Register("test:resolver", func(db *gorm.DB) {
+			if _, inTx := db.Statement.ConnPool.(gorm.TxCommitter); !inTx {
+				db.Statement.ConnPool = replica
+			}
+		})
+		So(err, ShouldBeNil)
+
+		get := func() TestModel {
+			model := TestModel{}
+			So(db.Where("id = ?", 131).First(&model).Error, ShouldBeNil)
+			return model
+		}
+		get()
+		get()
+		So(len(replica.args), ShouldEqual, 1)
+		So(c.HitCount(), ShouldEqual, 1)
+
+		defer originalDB.Model(&TestModel{ID: 131}).Update("value2", 131)
+		So(db.Model(&TestModel{ID: 131}).Update("value2", 1000).Error, ShouldBeNil)
+		So(get().Value2, ShouldEqual, 1000)
+		So(len(replica.args), ShouldEqual, 2)
+	})
+}
+
+func TestDatabaseScope(t *testing.T) {
+	Convey("test instances caching different databases share a storage and an InstanceId without colliding", t, func() {
+		store := storage.NewMemSync(nil)
+		newScoped := func(database string) (*gorm.DB, func() uint64) {
+			c, db, err := newCacheDB(&config.CacheConfig{
+				CacheLevel:   config.CacheLevelAll,
+				CacheStorage: store,
+				CacheTTL:     5000,
+				InstanceId:   "shared",
+				Database:     database,
+			})
+			So(err, ShouldBeNil)
+			return db, c.HitCount
+		}
+		dbA, hitsA := newScoped("a")
+		dbB, hitsB := newScoped("b")
+		dbA2, hitsA2 := newScoped("a")
+
+		for _, db := range []*gorm.DB{dbA, dbB, dbA2} {
+			So(db.Where("id = ?", 132).First(&TestModel{}).Error, ShouldBeNil)
+		}
+		So(hitsA(), ShouldEqual, 0)
+		So(hitsB(), ShouldEqual, 0)
+		So(hitsA2(), ShouldEqual, 1)
+	})
+}
@@ -0,0 +1,201 @@
+package test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeRistretto serves storage.RistrettoCache from a map, recording the cost and ttl of each entry
+type fakeRistretto struct {
+	mu      sync.Mutex
+	values  map[interface{}]interface{}
+	costs   map[interface{}]int64
+	ttls    map[interface{}]time.Duration
+	pending int // sets not applied until Wait, as ristretto buffers them
+}
+
+func newFakeRistretto() *fakeRistretto {
+	f := &fakeRistretto{}
+	f.Clear()
+	return f
+}
+
+func (f *fakeRistretto) Get(key interface{}) (interface{}, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	value, ok := f.values[key]
+	return value, ok
+}
+
+func (f *fakeRistretto) SetWithTTL(key interface{}, value interface{}, cost int64, ttl time.Duration) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.values[key], f.costs[key], f.ttls[key] = value, cost, ttl
+	f.pending++
+	return true
+}
+
+func (f *fakeRistretto) GetTTL(key interface{}) (time.Duration, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.values[key]; !ok {
+		return 0, false
+	}
+	return f.ttls[key], true
+}
+
+func (f *fakeRistretto) Del(key interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.values, key)
+}
+
+func (f *fakeRistretto) Clear() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.values = make(map[interface{}]interface{})
+	f.costs = make(map[interface{}]int64)
+	f.ttls = make(map[interface{}]time.Duration)
+}
+
+func (f *fakeRistretto) Wait() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pending = 0
+}
+
+func TestRistrettoStorage(t *testing.T) {
+	Convey("test the ristretto storage", t, func() {
+		cache := newFakeRistretto()
+		store := storage.NewRistretto(&storage.RistrettoStoreConfig{Cache: cache})
+		So(store.Init(&storage.Config{TTL: 5000, Jitter: 0.1}), ShouldBeNil)
+		ctx := context.Background()
+
+		Convey("keys are read, written with their cost and ttl and deleted", func() {
+			So(store.BatchSetKeys(ctx, []util.Kv{{Key: "k1", Value: "v1"}, {Key: "k2", Value: "v2", TTL: 200}}), ShouldBeNil)
+			So(cache.pending, ShouldEqual, 0)
+			So(cache.costs["0:0:k1"], ShouldEqual, 4+128)
+			So(cache.ttls["0:0:k1"], ShouldBeBetweenOrEqual, 4500*time.Millisecond, 5500*time.Millisecond)
+			So(cache.ttls["0:0:k2"], ShouldBeBetweenOrEqual, 180*time.Millisecond, 220*time.Millisecond)
+
+			values, err := store.BatchGetValues(ctx, []string{"k1", "k3", "k2"})
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []string{"v1", "", "v2"})
+			ttl, err := store.KeyTTL(ctx, "k2")
+			So(err, ShouldBeNil)
+			So(ttl, ShouldBeBetweenOrEqual, 180, 220)
+			_, err = store.KeyTTL(ctx, "k3")
+			So(err, ShouldEqual, storage.ErrCacheNotFound)
+
+			So(store.DeleteKey(ctx, "k1"), ShouldBeNil)
+			_, err = store.GetValue(ctx, "k1")
+			So(err, ShouldEqual, storage.ErrCacheNotFound)
+		})
+
+		Convey("prefix deletes bump the generation of the prefix only", func() {
+			So(store.BatchSetKeys(ctx, []util.Kv{
+				{Key: "gormcache:1:s:t1:0", Value: "v"}, {Key: "gormcache:1:s:t1:1", Value: "v"}, {Key: "gormcache:1:s:t10:0", Value: "v"},
+			}), ShouldBeNil)
+			So(store.DeleteKeysWithPrefix(ctx, "gormcache:1:s:t1"), ShouldBeNil)
+			values, err := store.BatchGetValues(ctx, []string{"gormcache:1:s:t1:0", "gormcache:1:s:t1:1", "gormcache:1:s:t10:0"})
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []string{"", "", "v"})
+
+			So(store.SetKey(ctx, util.Kv{Key: "gormcache:1:s:t1:0", Value: "v2"}), ShouldBeNil)
+			value, err := store.GetValue(ctx, "gormcache:1:s:t1:0")
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, "v2")
+
+			Convey("and other prefixes the generation of the store", func() {
+				So(store.DeleteKeysWithPrefix(ctx, "gormcache:1"), ShouldBeNil)
+				exists, err := store.BatchKeyExist(ctx, []string{"gormcache:1:s:t1:0"})
+				So(err, ShouldBeNil)
+				So(exists, ShouldBeFalse)
+				exists, err = store.KeyExists(ctx, "gormcache:1:s:t10:0")
+				So(err, ShouldBeNil)
+				So(exists, ShouldBeFalse)
+			})
+		})
+
+		Convey("the keys of a tenant are deleted by its table and by the tenant", func() {
+			keys := []string{"gormcache:1:tn:a:s:t1:0", "gormcache:1:tn:a:s:t2:0", "gormcache:1:tn:a2:s:t1:0", "gormcache:1:s:t1:0"}
+			for _, key := range keys {
+				So(store.SetKey(ctx, util.Kv{Key: key, Value: "v"}), ShouldBeNil)
+			}
+			So(store.DeleteKeysWithPrefix(ctx, "gormcache:1:tn:a:s:t1"), ShouldBeNil)
+			values, err := store.BatchGetValues(ctx, keys)
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []string{"", "v", "v", "v"})
+
+			So(store.DeleteKeysWithPrefix(ctx, "gormcache:1:tn:a:"), ShouldBeNil)
+			values, err = store.BatchGetValues(ctx, keys)
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []string{"", "", "v", "v"})
+		})
+
+		Convey("caches queries and invalidates them", func() {
+			c, db, err := newCacheDB(&config.CacheConfig{
+				CacheLevel:           config.CacheLevelAll,
+				CacheStorage:         store,
+				CacheTTL:             5000,
+				InvalidateWhenUpdate: true,
+			})
+			So(err, ShouldBeNil)
+
+			query := func() {
+				models := make([]TestModel, 0)
+				So(db.Where("value1 = ?", 198).Find(&models).Error, ShouldBeNil)
+				So(len(models), ShouldEqual, 1)
+			}
+			query()
+			query()
+			So(c.HitCount(), ShouldEqual, 1)
+
+			So(db.Model(&TestModel{ID: 198}).Update("value2", 198).Error, ShouldBeNil)
+			query()
+			So(c.HitCount(), ShouldEqual, 1)
+		})
+	})
+}
+
+func TestMemoryRistrettoEngine(t *testing.T) {
+	Convey("test the memory store on the ristretto engine", t, func() {
+		So(func() { storage.NewMem(&storage.MemStoreConfig{Engine: storage.MemEngineRistretto}) }, ShouldPanic)
+
+		store := storage.NewMem(&storage.MemStoreConfig{Engine: storage.MemEngineRistretto, MaxMemoryBytes: 1 << 20})
+		defer store.Close()
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:           config.CacheLevelAll,
+			CacheStorage:         store,
+			InvalidateWhenUpdate: true,
+			CacheTTL:             5000,
+		})
+		So(err, ShouldBeNil)
+		So(asGorm2Cache(c).ConfigSnapshot().Storage.Settings["engine"], ShouldEqual, "ristretto")
+
+		search := func() string {
+			models := make([]TestModel, 0)
+			So(db.Where("value1 BETWEEN ? AND ?", 121, 122).Order("id").Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 2)
+			return models[0].Value9
+		}
+		search()
+		So(search(), ShouldEqual, "121")
+		So(c.HitCount(), ShouldEqual, 1)
+
+		So(db.Model(&TestModel{}).Where("id = ?", 121).Update("value9", "ristretto").Error, ShouldBeNil)
+		defer originalDB.Model(&TestModel{}).Where("id = ?", 121).Update("value9", "121")
+		So(search(), ShouldEqual, "ristretto")
+		So(c.HitCount(), ShouldEqual, 1)
+
+		_, err = asGorm2Cache(c).CountSearchCache(context.Background(), TestModelTableName)
+		So(err, ShouldEqual, util.ErrNotCountable)
+	})
+}
@@ -0,0 +1,119 @@
+package test
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestSearchCacheKey(t *testing.T) {
+	Convey("test search cache keys tell vars apart", t, func() {
+		key := func(vars ...interface{}) string {
+			return util.GenSearchCacheKey("1", TestModelTableName, "SELECT * FROM t WHERE a = ? AND b = ?", vars...)
+		}
+		zero, one := int64(0), int64(1)
+		var nilPointer *int64
+		now := time.Now()
+
+		So(key("a:b", "c"), ShouldNotEqual, key("a", "b:c"))
+		So(key("1", 1), ShouldNotEqual, key(1, 1))
+		So(key(nil, 1), ShouldNotEqual, key(0, 1))
+		So(key(nilPointer, 1), ShouldNotEqual, key(&zero, 1))
+		So(key([]int64{1, 2}, 3), ShouldNotEqual, key([]int64{1}, []int64{2, 3}))
+		So(key(sql.NullInt64{}, 1), ShouldNotEqual, key(sql.NullInt64{Valid: true}, 1))
+
+		So(key(&one, "a"), ShouldEqual, key(1, "a"))
+		So(key(uint8(1), "a"), ShouldEqual, key(uint64(1), "a"))
+		So(key(nilPointer, 1), ShouldEqual, key(nil, 1))
+		So(key(now, 1), ShouldEqual, key(now.Round(0), 1))
+		So(key([]int64{1, 2}, "a"), ShouldEqual, key([]int64{1, 2}, "a"))
+	})
+
+	Convey("test search cache keys of queries differing in formatting or IN list order are normalized alike", t, func() {
+		key := func(sql string, vars ...interface{}) string {
+			return util.GenSearchCacheKey("1", TestModelTableName, sql, vars...)
+		}
+		So(key("SELECT *  FROM t\n WHERE a IN ( ?, ? ) AND b = ?", 2, 1, 3), ShouldEqual,
+			key("SELECT * FROM t WHERE a IN (?,?) AND b = ?", 1, 2, 3))
+		So(key("SELECT * FROM t WHERE a IN (?,?) AND b = ?", 1, 2, 3), ShouldNotEqual,
+			key("SELECT * FROM t WHERE a IN (?,?) AND b = ?", 1, 3, 2))
+		So(key("SELECT * FROM t WHERE a = $2 AND b = $1", 1, 2), ShouldEqual, key("SELECT * FROM t WHERE a = ? AND b = ?", 2, 1))
+		So(key("SELECT * FROM t WHERE a IN (3, 1, 2)"), ShouldEqual, key("SELECT * FROM t WHERE a IN (1,2,3)"))
+		So(key("SELECT * FROM t WHERE a IN ('b,a','c')"), ShouldNotEqual, key("SELECT * FROM t WHERE a IN ('c','b,a')"))
+		So(key("SELECT * FROM t WHERE a = 'x  y'"), ShouldNotEqual, key("SELECT * FROM t WHERE a = 'x y'"))
+		So(key("SELECT * FROM t WHERE a = 'IN (?,?)' AND b IN (?,?)", 2, 1), ShouldEqual,
+			key("SELECT * FROM t WHERE a = 'IN (?,?)' AND b IN (?,?)", 1, 2))
+	})
+
+	Convey("test queries differing in the order of their IN lists share search cache entries", t, func() {
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelOnlySearch,
+			CacheStorage: storage.NewGcache(gcache.New(1000)),
+			CacheTTL:     5000,
+		})
+		So(err, ShouldBeNil)
+		count := func(db *gorm.DB) int {
+			models := make([]TestModel, 0)
+			So(db.Find(&models).Error, ShouldBeNil)
+			return len(models)
+		}
+		So(count(db.Where("id IN ?", []int64{124, 125, 126})), ShouldEqual, 3)
+		So(count(db.Where("id IN ?", []int64{126, 124, 125})), ShouldEqual, 3)
+		So(c.HitCount(), ShouldEqual, 1)
+
+		Convey("unless normalization is turned off", func() {
+			c, db, err := newCacheDB(&config.CacheConfig{
+				CacheLevel:    config.CacheLevelOnlySearch,
+				CacheStorage:  storage.NewGcache(gcache.New(1000)),
+				CacheTTL:      5000,
+				SQLNormalizer: util.NoopSQLNormalizer,
+			})
+			So(err, ShouldBeNil)
+			So(count(db.Where("id IN ?", []int64{124, 125, 126})), ShouldEqual, 3)
+			So(count(db.Where("id IN ?", []int64{126, 124, 125})), ShouldEqual, 3)
+			So(c.HitCount(), ShouldEqual, 0)
+		})
+	})
+
+	Convey("test queries differing in their vars don't share search cache entries", t, func() {
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelOnlySearch,
+			CacheStorage: storage.NewGcache(gcache.New(1000)),
+			CacheTTL:     5000,
+		})
+		So(err, ShouldBeNil)
+
+		ids := func(db *gorm.DB) []int64 {
+			models := make([]TestModel, 0)
+			So(db.Order("id").Find(&models).Error, ShouldBeNil)
+			result := make([]int64, 0, len(models))
+			for _, model := range models {
+				result = append(result, model.ID)
+			}
+			return result
+		}
+
+		So(ids(db.Where("id IN ?", []int64{121, 122})), ShouldResemble, []int64{121, 122})
+		So(ids(db.Where("id IN ?", []int64{121, 123})), ShouldResemble, []int64{121, 123})
+		So(ids(db.Where("id IN ?", []int64{121, 122})), ShouldResemble, []int64{121, 122})
+		So(c.HitCount(), ShouldEqual, 1)
+
+		value := int64(131)
+		So(ids(db.Where("value1 = ?", &value)), ShouldResemble, []int64{131})
+		So(ids(db.Where("value1 = ?", 131)), ShouldResemble, []int64{131})
+		So(c.HitCount(), ShouldEqual, 2)
+
+		base := db.Where("value1 > ?", 0).Session(&gorm.Session{})
+		So(ids(base.Where("id = ?", 132)), ShouldResemble, []int64{132})
+		So(ids(base.Where("id = ?", 133)), ShouldResemble, []int64{133})
+		So(ids(base.Where("id = ?", 132)), ShouldResemble, []int64{132})
+		So(c.HitCount(), ShouldEqual, 3)
+	})
+}
@@ -0,0 +1,78 @@
+package test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestSearchCachePredicate(t *testing.T) {
+	Convey("test statements rejected by SearchCachePredicate are not search cached", t, func() {
+		tables := make([]string, 0)
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelOnlySearch,
+			CacheStorage: storage.NewGcache(gcache.New(1000)),
+			CacheTTL:     5000,
+			SearchCachePredicate: func(tableName string, sql string, vars []interface{}) bool {
+				tables = append(tables, tableName)
+				// only the first page is cached
+				return !strings.Contains(sql, "OFFSET")
+			},
+		})
+		So(err, ShouldBeNil)
+
+		page := func(offset int) []TestModel {
+			models := make([]TestModel, 0)
+			So(db.Where("value1 BETWEEN ? AND ?", 61, 99).Order("id").Limit(3).Offset(offset).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 3)
+			return models
+		}
+
+		So(page(0)[0].ID, ShouldEqual, 61)
+		So(page(0)[0].ID, ShouldEqual, 61)
+		So(c.HitCount(), ShouldEqual, 1)
+
+		So(page(3)[0].ID, ShouldEqual, 64)
+		So(page(3)[0].ID, ShouldEqual, 64)
+		So(c.HitCount(), ShouldEqual, 1)
+		So(tables, ShouldResemble, []string{TestModelTableName, TestModelTableName, TestModelTableName})
+	})
+}
+
+func TestMinQueryDuration(t *testing.T) {
+	Convey("test only the results of queries slower than MinQueryDuration are search cached", t, func() {
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:       config.CacheLevelOnlySearch,
+			CacheStorage:     storage.NewMemSync(nil),
+			CacheTTL:         5000,
+			MinQueryDuration: 20,
+		})
+		So(err, ShouldBeNil)
+		// queries on value2 take the database 30ms
+		err = db.Callback().Query().After("gorm:query").Before("gorm:after_query").Register("test:slow_query", func(db *gorm.DB) {
+			if strings.Contains(db.Statement.SQL.String(), "value2") {
+				time.Sleep(30 * time.Millisecond)
+			}
+		})
+		So(err, ShouldBeNil)
+
+		query := func(column string) {
+			models := make([]TestModel, 0)
+			So(db.Where(column+" = ?", 65).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 1)
+		}
+		query("value1")
+		query("value1")
+		So(c.HitCount(), ShouldEqual, 0)
+
+		query("value2")
+		query("value2")
+		So(c.HitCount(), ShouldEqual, 1)
+	})
+}
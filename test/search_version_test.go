@@ -0,0 +1,62 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSearchInvalidationVersion(t *testing.T) {
+	Convey("test a write bumps the search version of its table instead of deleting its search keys", t, func() {
+		defer originalDB.Model(&TestModel{ID: 151}).Update("value2", 151)
+
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:           config.CacheLevelOnlySearch,
+			CacheStorage:         storage.NewMemSync(nil),
+			CacheTTL:             5000,
+			InvalidateWhenUpdate: true,
+			SearchInvalidation:   config.SearchInvalidationVersion,
+		})
+		So(err, ShouldBeNil)
+		ctx := context.Background()
+		tableName := TestModelTableName
+		search := func() int64 {
+			models := make([]TestModel, 0)
+			So(db.Where("value1 BETWEEN ? AND ?", 151, 153).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 3)
+			return models[0].Value2
+		}
+		rawSum := func() int64 {
+			var sum int64
+			So(asGorm2Cache(c).RawScan(db.Raw("SELECT SUM(value2) FROM "+TestModelTableName+" WHERE id BETWEEN ? AND ?", 151, 153),
+				&sum, &cache.RawOptions{Tables: []string{tableName}}), ShouldBeNil)
+			return sum
+		}
+
+		So(search(), ShouldEqual, 151)
+		So(search(), ShouldEqual, 151)
+		So(rawSum(), ShouldEqual, 456)
+		So(rawSum(), ShouldEqual, 456)
+		So(c.HitCount(), ShouldEqual, 2)
+
+		So(db.Model(&TestModel{ID: 151}).Update("value2", 1000).Error, ShouldBeNil)
+		So(search(), ShouldEqual, 1000)
+		So(rawSum(), ShouldEqual, 1305)
+		So(c.HitCount(), ShouldEqual, 2)
+
+		// the entries cached before the write are left to expire
+		count, err := asGorm2Cache(c).CountSearchCache(ctx, tableName)
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 4)
+
+		So(asGorm2Cache(c).InvalidateSearchCache(ctx, tableName), ShouldBeNil)
+		So(search(), ShouldEqual, 1000)
+		So(c.HitCount(), ShouldEqual, 2)
+		So(search(), ShouldEqual, 1000)
+		So(c.HitCount(), ShouldEqual, 3)
+	})
+}
@@ -0,0 +1,84 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func TestPrimaryCachePartialSelect(t *testing.T) {
+	Convey("test queries selecting some columns neither read nor write the primary cache", t, func() {
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelOnlyPrimary,
+			CacheStorage: storage.NewGcache(gcache.New(1000)),
+			CacheTTL:     5000,
+		})
+		So(err, ShouldBeNil)
+
+		full := func(id int) TestModel {
+			model := TestModel{}
+			So(db.Where("id = ?", id).First(&model).Error, ShouldBeNil)
+			So(model.Value9, ShouldNotBeEmpty)
+			return model
+		}
+		selected := func(id int) TestModel {
+			model := TestModel{}
+			So(db.Select("id", "value1").Where("id = ?", id).First(&model).Error, ShouldBeNil)
+			So(model.Value1, ShouldEqual, id)
+			So(model.Value9, ShouldBeEmpty)
+			return model
+		}
+
+		Convey("full select then partial select", func() {
+			full(196)
+			selected(196)
+			So(c.HitCount(), ShouldEqual, 0)
+			So(full(196).Value9, ShouldEqual, "196")
+			So(c.HitCount(), ShouldEqual, 1)
+		})
+
+		Convey("partial select then full select", func() {
+			selected(197)
+			So(full(197).Value9, ShouldEqual, "197")
+			So(c.HitCount(), ShouldEqual, 0)
+
+			omitted := TestModel{}
+			So(db.Omit("value9").Where("id = ?", 197).First(&omitted).Error, ShouldBeNil)
+			So(omitted.Value9, ShouldBeEmpty)
+			So(c.HitCount(), ShouldEqual, 0)
+		})
+
+		Convey("select clauses added with Clauses", func() {
+			columns := clause.Select{Columns: []clause.Column{{Name: "id"}, {Name: "value1"}}}
+			model := TestModel{}
+			So(db.Clauses(columns).Where("id = ?", 191).First(&model).Error, ShouldBeNil)
+			So(model.Value9, ShouldBeEmpty)
+			So(full(191).Value9, ShouldEqual, "191")
+
+			models := make([]TestModel, 0)
+			So(db.Clauses(columns).Where("id IN ?", []int{192, 193}).Find(&models).Error, ShouldBeNil)
+			So(full(192).Value9, ShouldEqual, "192")
+			So(c.HitCount(), ShouldEqual, 0)
+
+			model = TestModel{}
+			So(db.Clauses(columns).Where("id = ?", 191).First(&model).Error, ShouldBeNil)
+			So(model.Value9, ShouldBeEmpty)
+			So(c.HitCount(), ShouldEqual, 0)
+		})
+
+		Convey("queries listing every column with QueryFields", func() {
+			queryFields := db.Session(&gorm.Session{QueryFields: true})
+			for i := 0; i < 2; i++ {
+				model := TestModel{}
+				So(queryFields.Where("id = ?", 194).First(&model).Error, ShouldBeNil)
+				So(model.Value9, ShouldEqual, "194")
+			}
+			So(c.HitCount(), ShouldEqual, 1)
+		})
+	})
+}
@@ -0,0 +1,91 @@
+package test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// gobSerializer caches values in the gob format, failing to unmarshal them if broken is set
+type gobSerializer struct {
+	marshals int64
+	broken   bool
+}
+
+func (s *gobSerializer) Marshal(v interface{}) ([]byte, error) {
+	atomic.AddInt64(&s.marshals, 1)
+	buf := bytes.Buffer{}
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (s *gobSerializer) Unmarshal(data []byte, v interface{}) error {
+	if s.broken {
+		return errors.New("broken serializer")
+	}
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+func TestSerializer(t *testing.T) {
+	Convey("test cached values go through the configured serializer", t, func() {
+		serializer := &gobSerializer{}
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelAll,
+			CacheStorage: storage.NewGcache(gcache.New(1000)),
+			CacheTTL:     5000,
+			Serializer:   serializer,
+		})
+		So(err, ShouldBeNil)
+
+		query := func() uint64 {
+			models := make([]*TestModel, 0)
+			So(db.Where("id IN ?", []int64{86, 87, 88}).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 3)
+			for idx, model := range models {
+				So(model.Value1, ShouldEqual, 86+idx)
+			}
+
+			model := TestModel{}
+			So(db.Where("id = ?", 89).First(&model).Error, ShouldBeNil)
+			So(model.Value1, ShouldEqual, 89)
+
+			searched := make([]TestModel, 0)
+			So(db.Where("value1 BETWEEN ? AND ?", 81, 85).Find(&searched).Error, ShouldBeNil)
+			So(len(searched), ShouldEqual, 5)
+			So(searched[4].Value9, ShouldEqual, "85")
+			return c.HitCount()
+		}
+		So(query(), ShouldEqual, 0)
+		So(atomic.LoadInt64(&serializer.marshals), ShouldBeGreaterThan, 0)
+		So(query(), ShouldEqual, 3)
+	})
+}
+
+func TestSerializerUnmarshalError(t *testing.T) {
+	Convey("test a failing serializer reports cache unmarshal errors", t, func() {
+		serializer := &gobSerializer{}
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelOnlyPrimary,
+			CacheStorage: storage.NewGcache(gcache.New(1000)),
+			CacheTTL:     5000,
+			Serializer:   serializer,
+		})
+		So(err, ShouldBeNil)
+
+		model := TestModel{}
+		So(db.Where("id = ?", 90).First(&model).Error, ShouldBeNil)
+
+		serializer.broken = true
+		err = db.Where("id = ?", 90).First(&TestModel{}).Error
+		So(errors.Is(err, util.ErrCacheUnmarshal), ShouldBeTrue)
+		So(c.HitCount(), ShouldEqual, 0)
+	})
+}
@@ -0,0 +1,47 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReadYourWrites(t *testing.T) {
+	Convey("test read your writes session", t, func() {
+		// without invalidation every write leaves a stale entry behind
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelAll,
+			CacheStorage: storage.NewGcache(gcache.New(1000)),
+			CacheTTL:     5000,
+		})
+		So(err, ShouldBeNil)
+
+		model := new(TestModel)
+		So(db.Where("id = ?", 150).First(model).Error, ShouldBeNil)
+		So(model.Value2, ShouldEqual, 150)
+		defer db.Model(&TestModel{ID: 150}).Update("value2", 150)
+
+		session := cache.ReadYourWrites(db)
+		So(session.Model(&TestModel{ID: 150}).Update("value2", 999).Error, ShouldBeNil)
+
+		model = new(TestModel)
+		So(db.Where("id = ?", 150).First(model).Error, ShouldBeNil)
+		So(model.Value2, ShouldEqual, 150)
+		So(c.HitCount(), ShouldEqual, 1)
+
+		model = new(TestModel)
+		So(session.Where("id = ?", 150).First(model).Error, ShouldBeNil)
+		So(model.Value2, ShouldEqual, 999)
+		So(c.HitCount(), ShouldEqual, 1)
+
+		cache.ClearReadYourWrites(session)
+		model = new(TestModel)
+		So(session.Where("id = ?", 150).First(model).Error, ShouldBeNil)
+		So(model.Value2, ShouldEqual, 999)
+		So(c.HitCount(), ShouldEqual, 2)
+	})
+}
@@ -0,0 +1,55 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestContextSettings(t *testing.T) {
+	Convey("test cache settings carried by the context apply through scopes and new sessions", t, func() {
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelOnlySearch,
+			CacheStorage: storage.NewMemSync(nil),
+			CacheTTL:     5000,
+		})
+		So(err, ShouldBeNil)
+		gc := asGorm2Cache(c)
+		ctx := cache.WithCacheCtx(context.Background(), cache.CacheSettings{TTL: 200 * time.Millisecond, Tags: []string{"report"}})
+		ctx = cache.WithCacheCtx(ctx, cache.CacheSettings{Tags: []string{"daily"}})
+		between := func(from int, to int) func(db *gorm.DB) *gorm.DB {
+			return func(db *gorm.DB) *gorm.DB {
+				return db.Where("value1 BETWEEN ? AND ?", from, to)
+			}
+		}
+		search := func(db *gorm.DB) error {
+			models := make([]TestModel, 0)
+			return db.Session(&gorm.Session{NewDB: true}).Scopes(between(171, 173)).Find(&models).Error
+		}
+
+		So(search(db.WithContext(ctx)), ShouldBeNil)
+		So(search(db.WithContext(ctx)), ShouldBeNil)
+		So(c.HitCount(), ShouldEqual, 1)
+
+		// tagged by both contexts, and cached for the ttl of the first
+		So(gc.InvalidateByTag(context.Background(), "daily"), ShouldBeNil)
+		So(search(db.WithContext(ctx)), ShouldBeNil)
+		So(c.HitCount(), ShouldEqual, 1)
+		time.Sleep(300 * time.Millisecond)
+		So(search(db.WithContext(ctx)), ShouldBeNil)
+		So(c.HitCount(), ShouldEqual, 1)
+
+		cacheOnly := cache.WithCacheCtx(ctx, cache.CacheSettings{CacheOnly: true})
+		models := make([]TestModel, 0)
+		err = db.WithContext(cacheOnly).Session(&gorm.Session{NewDB: true}).Scopes(between(174, 176)).Find(&models).Error
+		So(errors.Is(err, util.ErrCacheOnlyMiss), ShouldBeTrue)
+	})
+}
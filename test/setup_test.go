@@ -75,6 +75,7 @@ func TestMain(m *testing.M) {
 		InvalidateWhenUpdate: true,
 		CacheTTL:             5000,
 		CacheMaxItemCnt:      5000,
+		CacheEmptyResults:    true,
 		DebugMode:            false,
 	})
 	if err != nil {
@@ -163,3 +164,19 @@ func forkDB(db *gorm.DB) (newDB *gorm.DB, err error) {
 	})
 	return
 }
+
+// newCacheDB attaches a freshly configured cache to a fork of the original db
+func newCacheDB(conf *config.CacheConfig) (cache.Cache, *gorm.DB, error) {
+	c, err := cache.NewGorm2Cache(conf)
+	if err != nil {
+		return nil, nil, err
+	}
+	db, err := forkDB(originalDB)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = db.Use(c); err != nil {
+		return nil, nil, err
+	}
+	return c, db, nil
+}
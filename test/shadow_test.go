@@ -0,0 +1,60 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestShadowMode(t *testing.T) {
+	Convey("test shadow mode counts lookups and divergences but serves queries from the database", t, func() {
+		defer originalDB.Model(&TestModel{}).Where("id = ?", 143).Update("value2", 143)
+
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:           config.CacheLevelAll,
+			CacheStorage:         storage.NewMemSync(nil),
+			CacheTTL:             5000,
+			InvalidateWhenUpdate: true,
+			ShadowMode:           true,
+		})
+		So(err, ShouldBeNil)
+		gc := asGorm2Cache(c)
+
+		search := func() []TestModel {
+			models := make([]TestModel, 0)
+			So(db.Where("value1 BETWEEN ? AND ?", 141, 143).Order("id").Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 3)
+			return models
+		}
+		So(search()[2].Value2, ShouldEqual, 143)
+		So(search()[2].Value2, ShouldEqual, 143)
+		So(c.HitCount(), ShouldEqual, 1)
+		So(c.MissCount(), ShouldEqual, 1)
+
+		// rows by primary key, in another order than the database's, are as cached as the search
+		models := make([]TestModel, 0)
+		So(db.Where("id IN ?", []int64{143, 141}).Find(&models).Error, ShouldBeNil)
+		So(len(models), ShouldEqual, 2)
+		So(c.HitCount(), ShouldEqual, 2)
+		So(gc.ShadowDivergenceCount(), ShouldEqual, 0)
+
+		So(db.Where("id = ?", 1000001).First(&TestModel{}).Error, ShouldEqual, gorm.ErrRecordNotFound)
+		So(db.Where("id = ?", 1000001).First(&TestModel{}).Error, ShouldEqual, gorm.ErrRecordNotFound)
+		So(c.HitCount(), ShouldEqual, 3)
+		So(gc.ShadowDivergenceCount(), ShouldEqual, 0)
+
+		// a write the cache doesn't see is served all the same, the cached result is counted as divergent
+		So(originalDB.Model(&TestModel{}).Where("id = ?", 143).Update("value2", 1430).Error, ShouldBeNil)
+		So(search()[2].Value2, ShouldEqual, 1430)
+		So(c.HitCount(), ShouldEqual, 4)
+		So(gc.ShadowDivergenceCount(), ShouldEqual, 1)
+
+		// the result of the database was cached again
+		So(search()[2].Value2, ShouldEqual, 1430)
+		So(gc.ShadowDivergenceCount(), ShouldEqual, 1)
+		So(gc.StatsSnapshot().ShadowDivergence, ShouldEqual, 1)
+	})
+}
@@ -0,0 +1,71 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type tenantKey struct{}
+
+func TestShardRouter(t *testing.T) {
+	Convey("test tenants routed to separate storages are isolated", t, func() {
+		stores := map[string]*storage.Memory{
+			"heavy": storage.NewMem(&storage.MemStoreConfig{MaxSize: 1000}),
+			"light": storage.NewMem(&storage.MemStoreConfig{MaxSize: 1000}),
+		}
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:           config.CacheLevelOnlySearch,
+			InvalidateWhenUpdate: true,
+			CacheTTL:             5000,
+			ShardRouter: func(ctx context.Context) storage.DataStorage {
+				tenant, _ := ctx.Value(tenantKey{}).(string)
+				if store, ok := stores[tenant]; ok {
+					return store
+				}
+				return nil
+			},
+		})
+		So(err, ShouldBeNil)
+		for _, store := range stores {
+			// routed storages are initialized on first use, counting may come earlier
+			So(store.Init(&storage.Config{TTL: 5000}), ShouldBeNil)
+		}
+
+		heavyCtx := context.WithValue(context.Background(), tenantKey{}, "heavy")
+		lightCtx := context.WithValue(context.Background(), tenantKey{}, "light")
+		countKeys := func(tenant string) int64 {
+			count, err := stores[tenant].CountKeysWithPrefix(context.Background(), "")
+			So(err, ShouldBeNil)
+			return count
+		}
+		query := func(ctx context.Context) {
+			models := make([]TestModel, 0)
+			So(db.WithContext(ctx).Where("value1 BETWEEN ? AND ?", 121, 125).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 5)
+		}
+
+		query(heavyCtx)
+		So(countKeys("heavy"), ShouldEqual, 1)
+		So(countKeys("light"), ShouldEqual, 0)
+
+		query(lightCtx)
+		So(c.HitCount(), ShouldEqual, 0)
+		So(countKeys("light"), ShouldEqual, 1)
+
+		query(heavyCtx)
+		query(lightCtx)
+		So(c.HitCount(), ShouldEqual, 2)
+
+		// a write of the heavy tenant invalidates its own storage only
+		original := &TestModel{}
+		So(originalDB.Where("id = ?", 123).First(original).Error, ShouldBeNil)
+		defer originalDB.Model(&TestModel{}).Where("id = ?", 123).Update("value8", original.Value8)
+		So(db.WithContext(heavyCtx).Model(&TestModel{}).Where("id = ?", 123).Update("value8", 7000).Error, ShouldBeNil)
+		So(countKeys("heavy"), ShouldEqual, 0)
+		So(countKeys("light"), ShouldEqual, 1)
+	})
+}
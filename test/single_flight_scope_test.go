@@ -0,0 +1,135 @@
+package test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bluele/gcache"
+	"github.com/glebarez/sqlite"
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func tenantOf(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// tenantConnPool serves each tenant from its own database, slowly so that concurrent queries overlap
+type tenantConnPool struct {
+	gorm.ConnPool
+	tenants map[string]gorm.ConnPool
+	queries sync.Map // tenant to *int64
+}
+
+func (p *tenantConnPool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	tenant := tenantOf(ctx)
+	count, _ := p.queries.LoadOrStore(tenant, new(int64))
+	atomic.AddInt64(count.(*int64), 1)
+	time.Sleep(100 * time.Millisecond)
+	return p.tenants[tenant].QueryContext(ctx, query, args...)
+}
+
+func (p *tenantConnPool) queryCount(tenant string) int64 {
+	count, ok := p.queries.Load(tenant)
+	if !ok {
+		return 0
+	}
+	return atomic.LoadInt64(count.(*int64))
+}
+
+func TestSingleFlightScope(t *testing.T) {
+	Convey("test tenants issuing the same sql don't share a single flight load", t, func() {
+		f, err := os.CreateTemp("", "gormCacheTenant.*.db")
+		So(err, ShouldBeNil)
+		defer os.Remove(f.Name())
+		tenantDB, err := gorm.Open(sqlite.Open(f.Name()), &gorm.Config{})
+		So(err, ShouldBeNil)
+		So(tenantDB.AutoMigrate(&TestModel{}), ShouldBeNil)
+		So(tenantDB.Create(&[]TestModel{{ID: 1, Value1: 1, Value9: "b"}, {ID: 2, Value1: 2, Value9: "b"}}).Error, ShouldBeNil)
+
+		pool := &tenantConnPool{
+			ConnPool: originalDB.ConnPool,
+			tenants:  map[string]gorm.ConnPool{"a": originalDB.ConnPool, "b": tenantDB.ConnPool},
+		}
+		db, err := gorm.Open(&sqlite.Dialector{Conn: pool}, &gorm.Config{})
+		So(err, ShouldBeNil)
+
+		// queries the same sql concurrently for the given tenants
+		queryConcurrently := func(tenants ...string) [][]TestModel {
+			results := make([][]TestModel, len(tenants))
+			errs := make([]error, len(tenants))
+			var wg sync.WaitGroup
+			for idx, tenant := range tenants {
+				wg.Add(1)
+				go func(idx int, tenant string) {
+					defer wg.Done()
+					ctx := context.WithValue(context.Background(), tenantKey{}, tenant)
+					models := make([]TestModel, 0)
+					errs[idx] = db.WithContext(ctx).Where("value1 BETWEEN ? AND ?", 1, 2).Find(&models).Error
+					results[idx] = models
+				}(idx, tenant)
+			}
+			wg.Wait()
+			for idx := range tenants {
+				So(errs[idx], ShouldBeNil)
+				So(len(results[idx]), ShouldEqual, 2)
+			}
+			return results
+		}
+
+		Convey("scope from the context", func() {
+			c, err := cache.NewGorm2Cache(&config.CacheConfig{
+				CacheLevel:         config.CacheLevelOff,
+				CacheStorage:       storage.NewGcache(gcache.New(1000)),
+				EnableSingleFlight: true,
+				SingleFlightScope:  tenantOf,
+			})
+			So(err, ShouldBeNil)
+			So(db.Use(c), ShouldBeNil)
+
+			results := queryConcurrently("a", "b")
+			So(results[0][0].Value9, ShouldEqual, "1")
+			So(results[1][0].Value9, ShouldEqual, "b")
+			So(pool.queryCount("a"), ShouldEqual, 1)
+			So(pool.queryCount("b"), ShouldEqual, 1)
+
+			results = queryConcurrently("b", "b")
+			So(results[0][0].Value9, ShouldEqual, "b")
+			So(results[1][0].Value9, ShouldEqual, "b")
+			So(pool.queryCount("b"), ShouldEqual, 2)
+		})
+
+		Convey("storages routed by the context", func() {
+			shards := map[string]storage.DataStorage{
+				"a": storage.NewGcache(gcache.New(1000)),
+				"b": storage.NewGcache(gcache.New(1000)),
+			}
+			c, err := cache.NewGorm2Cache(&config.CacheConfig{
+				CacheLevel:         config.CacheLevelOnlySearch,
+				CacheStorage:       storage.NewGcache(gcache.New(1000)),
+				CacheTTL:           5000,
+				EnableSingleFlight: true,
+				ShardRouter: func(ctx context.Context) storage.DataStorage {
+					return shards[tenantOf(ctx)]
+				},
+			})
+			So(err, ShouldBeNil)
+			So(db.Use(c), ShouldBeNil)
+
+			results := queryConcurrently("a", "b")
+			So(results[0][0].Value9, ShouldEqual, "1")
+			So(results[1][0].Value9, ShouldEqual, "b")
+			So(pool.queryCount("a"), ShouldEqual, 1)
+			So(pool.queryCount("b"), ShouldEqual, 1)
+		})
+	})
+}
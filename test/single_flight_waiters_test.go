@@ -0,0 +1,241 @@
+package test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bluele/gcache"
+	"github.com/glebarez/sqlite"
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+var errLeaderFailed = errors.New("leader failed")
+
+// slowConnPool answers queries slowly, counting them
+type slowConnPool struct {
+	gorm.ConnPool
+	count int64
+}
+
+func (p *slowConnPool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	atomic.AddInt64(&p.count, 1)
+	time.Sleep(200 * time.Millisecond)
+	return p.ConnPool.QueryContext(ctx, query, args...)
+}
+
+// failingLeaderConnPool answers queries slowly, failing the first one
+type failingLeaderConnPool struct {
+	gorm.ConnPool
+	count int64
+}
+
+func (p *failingLeaderConnPool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	n := atomic.AddInt64(&p.count, 1)
+	time.Sleep(200 * time.Millisecond)
+	if n == 1 {
+		return nil, errLeaderFailed
+	}
+	return p.ConnPool.QueryContext(ctx, query, args...)
+}
+
+func TestSingleFlightWaiters(t *testing.T) {
+	Convey("test many waiters on a slow single flight load that fails", t, func() {
+		const waiters = 30
+
+		// runs a leader and then waiters onto the same slow query, returning the error of each
+		stampede := func(conf *config.CacheConfig) (*failingLeaderConnPool, error, []error) {
+			pool := &failingLeaderConnPool{ConnPool: originalDB.ConnPool}
+			db, err := gorm.Open(&sqlite.Dialector{Conn: pool}, &gorm.Config{})
+			So(err, ShouldBeNil)
+			conf.CacheLevel = config.CacheLevelOnlySearch
+			conf.CacheStorage = storage.NewGcache(gcache.New(1000))
+			conf.CacheTTL = 5000
+			conf.EnableSingleFlight = true
+			c, err := cache.NewGorm2Cache(conf)
+			So(err, ShouldBeNil)
+			So(db.Use(c), ShouldBeNil)
+
+			query := func() error {
+				models := make([]TestModel, 0)
+				return db.Where("value1 BETWEEN ? AND ?", 21, 25).Find(&models).Error
+			}
+
+			var leaderErr error
+			leaderDone := make(chan struct{})
+			go func() {
+				defer close(leaderDone)
+				leaderErr = query()
+			}()
+			for atomic.LoadInt64(&pool.count) == 0 {
+				time.Sleep(time.Millisecond)
+			}
+
+			errs := make([]error, waiters)
+			var wg sync.WaitGroup
+			for i := 0; i < waiters; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					errs[i] = query()
+				}(i)
+			}
+			wg.Wait()
+			<-leaderDone
+			return pool, leaderErr, errs
+		}
+		count := func(errs []error, target error) int {
+			n := 0
+			for _, err := range errs {
+				if errors.Is(err, target) || (target == nil && err == nil) {
+					n++
+				}
+			}
+			return n
+		}
+
+		Convey("uncapped waiters all fail with the leader", func() {
+			pool, leaderErr, errs := stampede(&config.CacheConfig{})
+			So(errors.Is(leaderErr, errLeaderFailed), ShouldBeTrue)
+			So(count(errs, errLeaderFailed), ShouldEqual, waiters)
+			So(atomic.LoadInt64(&pool.count), ShouldEqual, 1)
+		})
+
+		Convey("waiters beyond the cap load on their own", func() {
+			pool, leaderErr, errs := stampede(&config.CacheConfig{SingleFlightMaxWaiters: 10})
+			So(errors.Is(leaderErr, errLeaderFailed), ShouldBeTrue)
+			So(count(errs, errLeaderFailed), ShouldEqual, 10)
+			So(count(errs, nil), ShouldEqual, waiters-10)
+			So(atomic.LoadInt64(&pool.count), ShouldEqual, 1+waiters-10)
+		})
+
+		Convey("waiters beyond the cap fail as busy", func() {
+			pool, _, errs := stampede(&config.CacheConfig{
+				SingleFlightMaxWaiters: 10,
+				SingleFlightOverflow:   config.SingleFlightOverflowFail,
+			})
+			So(count(errs, errLeaderFailed), ShouldEqual, 10)
+			So(count(errs, util.ErrSingleFlightBusy), ShouldEqual, waiters-10)
+			So(atomic.LoadInt64(&pool.count), ShouldEqual, 1)
+		})
+
+		Convey("waiters retry on their own after the leader failed", func() {
+			pool, leaderErr, errs := stampede(&config.CacheConfig{
+				SingleFlightMaxWaiters:  10,
+				SingleFlightOverflow:    config.SingleFlightOverflowFail,
+				SingleFlightLeaderError: config.SingleFlightLeaderErrorRetry,
+			})
+			So(errors.Is(leaderErr, errLeaderFailed), ShouldBeTrue)
+			So(count(errs, nil), ShouldEqual, 10)
+			So(count(errs, util.ErrSingleFlightBusy), ShouldEqual, waiters-10)
+			So(atomic.LoadInt64(&pool.count), ShouldEqual, 1+10)
+		})
+	})
+}
+
+func TestSingleFlightSharedLoad(t *testing.T) {
+	Convey("test identical concurrent queries share one database load", t, func() {
+		const queries = 20
+
+		pool := &slowConnPool{ConnPool: originalDB.ConnPool}
+		db, err := gorm.Open(&sqlite.Dialector{Conn: pool}, &gorm.Config{})
+		So(err, ShouldBeNil)
+		c, err := cache.NewGorm2Cache(&config.CacheConfig{
+			CacheLevel:         config.CacheLevelOnlySearch,
+			CacheStorage:       storage.NewGcache(gcache.New(1000)),
+			CacheTTL:           5000,
+			EnableSingleFlight: true,
+		})
+		So(err, ShouldBeNil)
+		So(db.Use(c), ShouldBeNil)
+
+		errs := make([]error, queries)
+		results := make([][]TestModel, queries)
+		var wg sync.WaitGroup
+		for i := 0; i < queries; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = db.Where("value1 BETWEEN ? AND ?", 36, 38).Order("id").Find(&results[i]).Error
+			}(i)
+		}
+		wg.Wait()
+
+		So(atomic.LoadInt64(&pool.count), ShouldEqual, 1)
+		for i := 0; i < queries; i++ {
+			So(errs[i], ShouldBeNil)
+			So(len(results[i]), ShouldEqual, 3)
+			So(results[i][0].ID, ShouldEqual, 36)
+		}
+	})
+}
+
+func TestSingleFlightDeadlinesAndSharing(t *testing.T) {
+	Convey("test waiters give up on their own deadline and results are shared after the load", t, func() {
+		pool := &slowConnPool{ConnPool: originalDB.ConnPool}
+		db, err := gorm.Open(&sqlite.Dialector{Conn: pool}, &gorm.Config{})
+		So(err, ShouldBeNil)
+		c, err := cache.NewGorm2Cache(&config.CacheConfig{
+			CacheLevel:              config.CacheLevelOnlySearch,
+			CacheStorage:            storage.NewNoop(),
+			CacheTTL:                5000,
+			InvalidateWhenUpdate:    true,
+			EnableSingleFlight:      true,
+			SingleFlightShareWindow: 1000,
+		})
+		So(err, ShouldBeNil)
+		So(db.Use(c), ShouldBeNil)
+		defer originalDB.Model(&TestModel{ID: 179}).Update("value2", 179)
+
+		query := func(ctx context.Context) error {
+			models := make([]TestModel, 0)
+			return db.WithContext(ctx).Where("value1 BETWEEN ? AND ?", 179, 180).Find(&models).Error
+		}
+		inFlight := func() int {
+			n := 0
+			for _, count := range asGorm2Cache(c).SingleFlightInFlight() {
+				n += count
+			}
+			return n
+		}
+
+		leaderDone := make(chan error, 1)
+		go func() { leaderDone <- query(context.Background()) }()
+		for atomic.LoadInt64(&pool.count) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		waiterDone := make(chan error, 1)
+		go func() { waiterDone <- query(context.Background()) }()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		So(errors.Is(query(ctx), context.DeadlineExceeded), ShouldBeTrue)
+		So(time.Since(start), ShouldBeLessThan, 150*time.Millisecond)
+		So(inFlight(), ShouldEqual, 2)
+
+		So(<-leaderDone, ShouldBeNil)
+		So(<-waiterDone, ShouldBeNil)
+		So(inFlight(), ShouldEqual, 0)
+		So(atomic.LoadInt64(&pool.count), ShouldEqual, 1)
+
+		// the storage caches nothing, identical queries within the window share the result all the same
+		So(query(context.Background()), ShouldBeNil)
+		So(atomic.LoadInt64(&pool.count), ShouldEqual, 1)
+		So(c.HitCounts().SingleFlight, ShouldEqual, 2)
+
+		// a write to the table stops the result from being shared
+		So(db.Model(&TestModel{ID: 179}).Update("value2", 1790).Error, ShouldBeNil)
+		So(query(context.Background()), ShouldBeNil)
+		So(atomic.LoadInt64(&pool.count), ShouldEqual, 2)
+	})
+}
@@ -0,0 +1,27 @@
+//go:build go1.21
+
+package test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/joykk/gorm-cache/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSlogLogger(t *testing.T) {
+	Convey("test the slog adapter logs the fields of an entry as its attributes", t, func() {
+		var buf bytes.Buffer
+		l := util.NewSlogLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+		ctx := context.Background()
+
+		l.CtxLog(ctx, util.LevelDebug, "cache hit", util.Field{Key: "table", Value: "t"})
+		So(buf.String(), ShouldBeEmpty)
+		l.CtxLog(ctx, util.LevelWarn, "cache invalidate", util.Field{Key: "table", Value: "t"},
+			util.Field{Key: "keys", Value: []string{"a", "b"}})
+		So(buf.String(), ShouldContainSubstring, `level=WARN msg="cache invalidate" table=t keys="[a b]"`)
+	})
+}
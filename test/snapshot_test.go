@@ -0,0 +1,48 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/redis/go-redis/v9"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestConfigSnapshot(t *testing.T) {
+	Convey("test config snapshot redaction", t, func() {
+		mr := miniredis.NewMiniRedis()
+		mr.RequireUserAuth("admin", "s3cr3t")
+		So(mr.Start(), ShouldBeNil)
+		defer mr.Close()
+
+		c, err := cache.NewGorm2Cache(&config.CacheConfig{
+			CacheLevel: config.CacheLevelAll,
+			CacheStorage: storage.NewRedis(&storage.RedisStoreConfig{
+				Options: &redis.Options{Addr: mr.Addr(), Username: "admin", Password: "s3cr3t"},
+			}),
+			Tables:   []string{TestModelTableName},
+			CacheTTL: 5000,
+			Database: "main",
+		})
+		So(err, ShouldBeNil)
+
+		snapshot := asGorm2Cache(c).ConfigSnapshot()
+		So(snapshot.CacheTTL, ShouldEqual, 5000)
+		So(snapshot.Tables, ShouldResemble, []string{TestModelTableName})
+		So(snapshot.Database, ShouldEqual, "main")
+		So(snapshot.TenantResolver, ShouldBeFalse)
+		So(snapshot.Storage.Type, ShouldEqual, "*storage.Redis")
+		So(snapshot.Storage.Settings["addr"], ShouldEqual, mr.Addr())
+		So(snapshot.Storage.Settings["username"], ShouldEqual, storage.RedactedValue)
+		So(snapshot.Storage.Settings["password"], ShouldEqual, storage.RedactedValue)
+
+		data, err := json.Marshal(snapshot)
+		So(err, ShouldBeNil)
+		So(string(data), ShouldNotContainSubstring, "s3cr3t")
+		So(string(data), ShouldNotContainSubstring, "admin")
+	})
+}
@@ -0,0 +1,103 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestSoftDelete(t *testing.T) {
+	Convey("test soft deleted rows are no longer served from the cache", t, func() {
+		So(originalDB.AutoMigrate(&SoftDeleteModel{}), ShouldBeNil)
+		defer originalDB.Migrator().DropTable(&SoftDeleteModel{})
+		So(originalDB.Create(&[]SoftDeleteModel{{ID: 1, Name: "a"}, {ID: 2, Name: "a"}}).Error, ShouldBeNil)
+
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:           config.CacheLevelAll,
+			CacheStorage:         storage.NewGcache(gcache.New(1000)),
+			CacheTTL:             5000,
+			InvalidateWhenUpdate: true,
+		})
+		So(err, ShouldBeNil)
+
+		find := func(db *gorm.DB) []SoftDeleteModel {
+			models := make([]SoftDeleteModel, 0)
+			So(db.Where("name = ?", "a").Order("id").Find(&models).Error, ShouldBeNil)
+			return models
+		}
+		for i := 0; i < 2; i++ {
+			So(db.First(&SoftDeleteModel{}, 1).Error, ShouldBeNil)
+			So(len(find(db)), ShouldEqual, 2)
+		}
+		So(c.HitCount(), ShouldEqual, 2)
+
+		So(db.Delete(&SoftDeleteModel{ID: 1}).Error, ShouldBeNil)
+		So(db.First(&SoftDeleteModel{}, 1).Error, ShouldEqual, gorm.ErrRecordNotFound)
+		So(len(find(db)), ShouldEqual, 1)
+
+		Convey("unscoped queries still see the soft deleted row", func() {
+			model := SoftDeleteModel{}
+			So(db.Unscoped().First(&model, 1).Error, ShouldBeNil)
+			So(model.DeletedAt.Valid, ShouldBeTrue)
+			So(len(find(db.Unscoped())), ShouldEqual, 2)
+
+			// what unscoped queries cached doesn't leak into scoped ones
+			So(db.First(&SoftDeleteModel{}, 1).Error, ShouldEqual, gorm.ErrRecordNotFound)
+			So(len(find(db)), ShouldEqual, 1)
+		})
+
+		Convey("a row restored with Unscoped is served again", func() {
+			So(db.Unscoped().Model(&SoftDeleteModel{ID: 1}).Update("deleted_at", nil).Error, ShouldBeNil)
+			model := SoftDeleteModel{}
+			So(db.First(&model, 1).Error, ShouldBeNil)
+			So(model.DeletedAt.Valid, ShouldBeFalse)
+			So(len(find(db)), ShouldEqual, 2)
+		})
+	})
+}
+
+func TestSoftDeleteNegativeCache(t *testing.T) {
+	Convey("test soft deleted rows are cached as not found with SoftDeleteNegativeCache", t, func() {
+		So(originalDB.AutoMigrate(&SoftDeleteModel{}), ShouldBeNil)
+		defer originalDB.Migrator().DropTable(&SoftDeleteModel{})
+		So(originalDB.Create(&[]SoftDeleteModel{{ID: 1, Name: "a"}, {ID: 2, Name: "a"}}).Error, ShouldBeNil)
+
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:              config.CacheLevelOnlyPrimary,
+			CacheStorage:            storage.NewMemSync(nil),
+			CacheTTL:                5000,
+			InvalidateWhenUpdate:    true,
+			SoftDeleteNegativeCache: true,
+		})
+		So(err, ShouldBeNil)
+		gc := asGorm2Cache(c)
+
+		// scoped lookups by primary key are served by the primary cache
+		So(db.Where("id = ?", 1).First(&SoftDeleteModel{}).Error, ShouldBeNil)
+		So(db.Where("id = ?", 1).First(&SoftDeleteModel{}).Error, ShouldBeNil)
+		So(gc.HitCounts().Primary, ShouldEqual, 1)
+
+		So(db.Delete(&SoftDeleteModel{ID: 1}).Error, ShouldBeNil)
+		So(db.Where("id = ?", 1).First(&SoftDeleteModel{}).Error, ShouldEqual, gorm.ErrRecordNotFound)
+		So(gc.HitCounts().RecordNotFound, ShouldEqual, 1)
+
+		// unscoped queries neither read nor write the primary cache
+		for i := 0; i < 2; i++ {
+			model := SoftDeleteModel{}
+			So(db.Unscoped().Where("id = ?", 1).First(&model).Error, ShouldBeNil)
+			So(model.DeletedAt.Valid, ShouldBeTrue)
+		}
+		So(c.HitCount(), ShouldEqual, 2)
+		So(db.Where("id = ?", 1).First(&SoftDeleteModel{}).Error, ShouldEqual, gorm.ErrRecordNotFound)
+
+		// restoring the row drops its marker
+		So(db.Unscoped().Model(&SoftDeleteModel{ID: 1}).Update("deleted_at", nil).Error, ShouldBeNil)
+		model := SoftDeleteModel{}
+		So(db.Where("id = ?", 1).First(&model).Error, ShouldBeNil)
+		So(model.DeletedAt.Valid, ShouldBeFalse)
+	})
+}
@@ -0,0 +1,47 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	Convey("test a stale search cache hit is served while the query is revalidated in background", t, func() {
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:           config.CacheLevelOnlySearch,
+			CacheStorage:         storage.NewMemSync(nil),
+			CacheTTL:             100,
+			StaleWhileRevalidate: 60000,
+		})
+		So(err, ShouldBeNil)
+
+		query := func() string {
+			model := TestModel{}
+			So(db.Where("value1 = ?", 198).First(&model).Error, ShouldBeNil)
+			return model.Value9
+		}
+		So(query(), ShouldEqual, "198")
+
+		// changed behind the back of the cache
+		So(originalDB.Model(&TestModel{}).Where("id = ?", 198).Update("value9", "revalidated").Error, ShouldBeNil)
+		defer originalDB.Model(&TestModel{}).Where("id = ?", 198).Update("value9", "198")
+		So(query(), ShouldEqual, "198")
+
+		time.Sleep(150 * time.Millisecond)
+		So(query(), ShouldEqual, "198")
+
+		deadline := time.Now().Add(time.Second)
+		value := query()
+		for value != "revalidated" && time.Now().Before(deadline) {
+			time.Sleep(20 * time.Millisecond)
+			value = query()
+		}
+		So(value, ShouldEqual, "revalidated")
+		// the revalidations are no lookups, every query was served by the cache
+		So(c.MissCount(), ShouldEqual, 1)
+	})
+}
@@ -0,0 +1,128 @@
+package test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestHitRate(t *testing.T) {
+	Convey("test hits and misses add up to the hit rate", t, func() {
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelOnlySearch,
+			CacheStorage: storage.NewGcache(gcache.New(1000)),
+			CacheTTL:     5000,
+		})
+		So(err, ShouldBeNil)
+		So(c.HitRate(), ShouldEqual, 0)
+
+		query := func(value int) error {
+			models := make([]TestModel, 0)
+			return db.Where("value1 = ?", value).Find(&models).Error
+		}
+
+		// the first query of each value misses, the 3 following ones hit
+		for value := 96; value <= 99; value++ {
+			So(query(value), ShouldBeNil)
+		}
+		errs := make([]error, 12)
+		var wg sync.WaitGroup
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = query(96 + i%4)
+			}(i)
+		}
+		wg.Wait()
+		for _, err := range errs {
+			So(err, ShouldBeNil)
+		}
+
+		So(c.HitCount(), ShouldEqual, 12)
+		So(c.MissCount(), ShouldEqual, 4)
+		So(c.LookupCount(), ShouldEqual, 16)
+		So(c.HitRate(), ShouldEqual, 0.75)
+
+		So(c.ResetCache(), ShouldBeNil)
+		So(c.HitCount(), ShouldEqual, 0)
+		So(c.MissCount(), ShouldEqual, 0)
+		So(c.HitRate(), ShouldEqual, 0)
+
+		So(query(96), ShouldBeNil)
+		So(c.MissCount(), ShouldEqual, 1)
+	})
+}
+
+func TestTableStats(t *testing.T) {
+	Convey("test the counts of each table are broken down by cache", t, func() {
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:           config.CacheLevelAll,
+			CacheStorage:         storage.NewMemSync(nil),
+			CacheTTL:             5000,
+			InvalidateWhenUpdate: true,
+		})
+		So(err, ShouldBeNil)
+		gorm2Cache := asGorm2Cache(c)
+
+		for i := 0; i < 2; i++ {
+			So(db.Where("id = ?", 61).First(&TestModel{}).Error, ShouldBeNil)
+			So(db.Where("value1 = ?", 62).Find(&[]TestModel{}).Error, ShouldBeNil)
+		}
+		// the row is left as it is, the update still invalidates both caches
+		So(db.Model(&TestModel{ID: 63}).Update("value2", 63).Error, ShouldBeNil)
+
+		// lookups falling through to the database count as search cache misses, the rows they load are
+		// cached by primary key too
+		expected := cache.TableStats{
+			Primary: cache.KindStats{HitCount: 1, SetCount: 2, InvalidationCount: 1},
+			Search:  cache.KindStats{HitCount: 1, MissCount: 2, SetCount: 2, InvalidationCount: 1},
+		}
+		So(c.TableStats(TestModelTableName), ShouldResemble, expected)
+		snapshot := gorm2Cache.StatsSnapshot()
+		So(snapshot.HitCount, ShouldEqual, 2)
+		So(snapshot.Tables, ShouldResemble, map[string]cache.TableStats{TestModelTableName: expected})
+		So(c.TableStats("other_table"), ShouldResemble, cache.TableStats{})
+
+		gorm2Cache.ResetHitCount()
+		stats := c.TableStats(TestModelTableName)
+		So(stats.Search.HitCount+stats.Search.MissCount+stats.Primary.HitCount, ShouldEqual, 0)
+		So(stats.Search.SetCount, ShouldEqual, 2)
+	})
+}
+
+func TestHitCounts(t *testing.T) {
+	Convey("test hits are broken down by how they were served and counters reset one by one", t, func() {
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:           config.CacheLevelAll,
+			CacheStorage:         storage.NewMemSync(nil),
+			CacheTTL:             5000,
+			InvalidateWhenUpdate: true,
+		})
+		So(err, ShouldBeNil)
+
+		for i := 0; i < 2; i++ {
+			So(db.Where("id = ?", 71).First(&TestModel{}).Error, ShouldBeNil)
+			So(db.Where("value1 = ?", 72).Find(&[]TestModel{}).Error, ShouldBeNil)
+			So(db.Where("id = ?", 10071).First(&TestModel{}).Error, ShouldEqual, gorm.ErrRecordNotFound)
+		}
+		So(c.HitCounts(), ShouldResemble, cache.HitCounts{Primary: 1, Search: 1, RecordNotFound: 1})
+		So(c.HitCount(), ShouldEqual, 3)
+
+		So(db.Model(&TestModel{ID: 73}).Update("value2", 73).Error, ShouldBeNil)
+		So(asGorm2Cache(c).InvalidationCount(), ShouldBeGreaterThan, 0)
+		c.ResetCounter(cache.CounterInvalidations)
+		So(asGorm2Cache(c).InvalidationCount(), ShouldEqual, 0)
+		So(c.HitCount(), ShouldEqual, 3)
+
+		c.ResetCounter(cache.CounterLookups)
+		So(c.HitCounts(), ShouldResemble, cache.HitCounts{})
+		So(c.LookupCount(), ShouldEqual, 0)
+	})
+}
@@ -0,0 +1,204 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+var errUnreachable = errors.New("storage unreachable")
+
+// unreachableStorage fails reads and writes while they are down
+type unreachableStorage struct {
+	storage.DataStorage
+	readsDown  bool
+	writesDown bool
+}
+
+func (s *unreachableStorage) GetValue(ctx context.Context, key string) (string, error) {
+	if s.readsDown {
+		return "", errUnreachable
+	}
+	return s.DataStorage.GetValue(ctx, key)
+}
+
+func (s *unreachableStorage) BatchGetValues(ctx context.Context, keys []string) ([]string, error) {
+	if s.readsDown {
+		return nil, errUnreachable
+	}
+	return s.DataStorage.BatchGetValues(ctx, keys)
+}
+
+func (s *unreachableStorage) SetKey(ctx context.Context, kv util.Kv) error {
+	if s.writesDown {
+		return errUnreachable
+	}
+	return s.DataStorage.SetKey(ctx, kv)
+}
+
+func (s *unreachableStorage) BatchSetKeys(ctx context.Context, kvs []util.Kv) error {
+	if s.writesDown {
+		return errUnreachable
+	}
+	return s.DataStorage.BatchSetKeys(ctx, kvs)
+}
+
+func TestStorageErrors(t *testing.T) {
+	Convey("test queries when the cache storage fails", t, func() {
+		store := &unreachableStorage{DataStorage: storage.NewMemSync(nil)}
+		newDB := func(failOnStorageError bool) (cacheErrors func() uint64, query func() []error) {
+			c, db, err := newCacheDB(&config.CacheConfig{
+				CacheLevel:         config.CacheLevelAll,
+				CacheStorage:       store,
+				CacheTTL:           5000,
+				FailOnStorageError: failOnStorageError,
+			})
+			So(err, ShouldBeNil)
+
+			// runs a search, a primary and a not found query, returning their errors
+			return asGorm2Cache(c).ErrorCount, func() []error {
+				models := make([]TestModel, 0)
+				searchErr := db.Where("value1 BETWEEN ? AND ?", 54, 57).Find(&models).Error
+				if searchErr == nil {
+					So(len(models), ShouldEqual, 4)
+				}
+				model := TestModel{}
+				primaryErr := db.Where("id = ?", 58).First(&model).Error
+				if primaryErr == nil {
+					So(model.Value1, ShouldEqual, 58)
+				}
+				notFoundErr := db.Where("value1 = ?", -58).First(&TestModel{}).Error
+				return []error{searchErr, primaryErr, notFoundErr}
+			}
+		}
+
+		Convey("served by the database by default", func() {
+			cacheErrors, query := newDB(false)
+			store.readsDown, store.writesDown = true, true
+			errs := query()
+			So(errs[0], ShouldBeNil)
+			So(errs[1], ShouldBeNil)
+			So(errs[2], ShouldEqual, gorm.ErrRecordNotFound)
+			So(cacheErrors(), ShouldBeGreaterThan, 0)
+		})
+
+		Convey("reads failing the query", func() {
+			_, query := newDB(true)
+			So(query(), ShouldResemble, []error{nil, nil, gorm.ErrRecordNotFound})
+
+			store.readsDown = true
+			for _, err := range query() {
+				So(errors.Is(err, util.ErrCacheStorage), ShouldBeTrue)
+				So(err.Error(), ShouldContainSubstring, errUnreachable.Error())
+			}
+
+			store.readsDown = false
+			So(query(), ShouldResemble, []error{nil, nil, gorm.ErrRecordNotFound})
+		})
+
+		Convey("writes failing the query", func() {
+			_, query := newDB(true)
+			store.writesDown = true
+			errs := query()
+			So(errors.Is(errs[0], util.ErrCacheStorage), ShouldBeTrue)
+			So(errors.Is(errs[1], util.ErrCacheStorage), ShouldBeTrue)
+			So(errors.Is(errs[2], util.ErrCacheStorage), ShouldBeTrue)
+		})
+	})
+}
+
+func TestStorageErrorPolicy(t *testing.T) {
+	Convey("test storage failures fail closed and are reported with the failures in a row", t, func() {
+		store := &unreachableStorage{DataStorage: storage.NewMemSync(nil)}
+		consecutive := make([]int64, 0)
+		_, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:     config.CacheLevelOnlySearch,
+			CacheStorage:   store,
+			CacheTTL:       5000,
+			OnStorageError: config.StorageErrorFailClosed,
+			StorageErrorHandler: func(_ context.Context, err error, n int64) {
+				So(err, ShouldEqual, errUnreachable)
+				consecutive = append(consecutive, n)
+			},
+		})
+		So(err, ShouldBeNil)
+		query := func() error {
+			return db.Where("value1 = ?", 59).Find(&[]TestModel{}).Error
+		}
+
+		So(query(), ShouldBeNil)
+		store.readsDown = true
+		So(errors.Is(query(), util.ErrCacheStorage), ShouldBeTrue)
+		So(errors.Is(query(), util.ErrCacheStorage), ShouldBeTrue)
+		So(consecutive, ShouldResemble, []int64{1, 2})
+
+		store.readsDown = false
+		So(query(), ShouldBeNil)
+		store.readsDown = true
+		So(errors.Is(query(), util.ErrCacheStorage), ShouldBeTrue)
+		So(consecutive, ShouldResemble, []int64{1, 2, 1})
+	})
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	Convey("test queries bypass the cache while the storage keeps failing", t, func() {
+		store := &unreachableStorage{DataStorage: storage.NewMemSync(nil), readsDown: true, writesDown: true}
+		changes := make([]bool, 0)
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:              config.CacheLevelOnlySearch,
+			CacheStorage:            store,
+			CacheTTL:                5000,
+			CircuitBreakerThreshold: 2,
+			CircuitBreakerCooldown:  50,
+			Hooks: config.Hooks{
+				OnCircuitBreak: func(_ context.Context, open bool) { changes = append(changes, open) },
+			},
+		})
+		So(err, ShouldBeNil)
+		gorm2Cache := asGorm2Cache(c)
+		query := func() {
+			models := make([]TestModel, 0)
+			So(db.Where("value1 = ?", 58).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 1)
+		}
+
+		// the read and the write of the miss fail, opening the breaker
+		query()
+		So(gorm2Cache.ErrorCount(), ShouldEqual, 2)
+		So(gorm2Cache.CircuitOpen(), ShouldBeTrue)
+		So(gorm2Cache.CircuitTripCount(), ShouldEqual, 1)
+
+		// the storage isn't used until the cooldown elapses
+		query()
+		So(gorm2Cache.ErrorCount(), ShouldEqual, 2)
+		So(c.MissCount(), ShouldEqual, 1)
+
+		Convey("a probe failing keeps the breaker open for another cooldown", func() {
+			time.Sleep(60 * time.Millisecond)
+			// the probe reads and writes as any other query
+			query()
+			So(gorm2Cache.ErrorCount(), ShouldEqual, 4)
+			So(gorm2Cache.CircuitOpen(), ShouldBeTrue)
+			query()
+			So(gorm2Cache.ErrorCount(), ShouldEqual, 4)
+			So(changes, ShouldResemble, []bool{true})
+		})
+
+		Convey("a probe succeeding closes the breaker", func() {
+			store.readsDown, store.writesDown = false, false
+			time.Sleep(60 * time.Millisecond)
+			query()
+			So(gorm2Cache.CircuitOpen(), ShouldBeFalse)
+			query()
+			So(c.HitCount(), ShouldEqual, 1)
+			So(changes, ShouldResemble, []bool{true, false})
+		})
+	})
+}
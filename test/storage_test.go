@@ -0,0 +1,88 @@
+package test
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	"github.com/redis/go-redis/v9"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBatchGetValuesOrdering(t *testing.T) {
+	Convey("test batch get values keeps key order and marks misses", t, func() {
+		mr := miniredis.RunT(t)
+		stores := map[string]storage.DataStorage{
+			"memory":  storage.NewMem(),
+			"memsync": storage.NewMemSync(nil),
+			"gcache":  storage.NewGcache(gcache.New(100)),
+			"redis":   storage.NewRedis(&storage.RedisStoreConfig{Options: &redis.Options{Addr: mr.Addr()}}),
+		}
+		for name, store := range stores {
+			Convey(name, func() {
+				So(store.Init(&storage.Config{TTL: 5000, Logger: &util.DefaultLogger{}}), ShouldBeNil)
+
+				ctx := context.Background()
+				err := store.BatchSetKeys(ctx, []util.Kv{{Key: "k1", Value: "v1"}, {Key: "k3", Value: "v3"}})
+				So(err, ShouldBeNil)
+
+				values, err := store.BatchGetValues(ctx, []string{"k0", "k1", "k2", "k3", "k4"})
+				So(err, ShouldBeNil)
+				So(values, ShouldResemble, []string{"", "v1", "", "v3", ""})
+
+				values, err = store.BatchGetValues(ctx, []string{"k3", "k1"})
+				So(err, ShouldBeNil)
+				So(values, ShouldResemble, []string{"v3", "v1"})
+			})
+		}
+	})
+}
+
+func TestListKeysAndTTL(t *testing.T) {
+	Convey("test storages list their keys by prefix and report their ttl", t, func() {
+		mr := miniredis.RunT(t)
+		stores := map[string]storage.DataStorage{
+			"memory":  storage.NewMem(),
+			"memsync": storage.NewMemSync(nil),
+			"gcache":  storage.NewGcache(gcache.New(100)),
+			"redis":   storage.NewRedis(&storage.RedisStoreConfig{Options: &redis.Options{Addr: mr.Addr()}}),
+		}
+		for name, store := range stores {
+			Convey(name, func() {
+				So(store.Init(&storage.Config{TTL: 5000, Logger: &util.DefaultLogger{}}), ShouldBeNil)
+
+				ctx := context.Background()
+				err := store.BatchSetKeys(ctx, []util.Kv{
+					{Key: "p:t1:1", Value: "v1"}, {Key: "p:t1:2", Value: "v2", TTL: 1000}, {Key: "p:t2:1", Value: "v3"},
+				})
+				So(err, ShouldBeNil)
+
+				lister := store.(storage.KeyLister)
+				keys, err := lister.ListKeysWithPrefix(ctx, "p:t1:", 10)
+				So(err, ShouldBeNil)
+				sort.Strings(keys)
+				So(keys, ShouldResemble, []string{"p:t1:1", "p:t1:2"})
+
+				keys, err = lister.ListKeysWithPrefix(ctx, "p:", 2)
+				So(err, ShouldBeNil)
+				So(len(keys), ShouldEqual, 2)
+
+				reader, ok := store.(storage.TTLReader)
+				if !ok {
+					return
+				}
+				ttl, err := reader.KeyTTL(ctx, "p:t1:2")
+				So(err, ShouldBeNil)
+				So(ttl, ShouldBeGreaterThan, 0)
+				So(ttl, ShouldBeLessThanOrEqualTo, 1100) // ttls are jittered by up to 10%
+
+				_, err = reader.KeyTTL(ctx, "p:t3:1")
+				So(err, ShouldEqual, storage.ErrCacheNotFound)
+			})
+		}
+	})
+}
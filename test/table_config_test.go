@@ -0,0 +1,67 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTableCacheConfig(t *testing.T) {
+	Convey("test the cache level and max item count of a table override the global ones", t, func() {
+		store := storage.NewMemSync(nil)
+
+		Convey("a table cached by primary key only", func() {
+			c, db, err := newCacheDB(&config.CacheConfig{
+				CacheLevel:      config.CacheLevelAll,
+				TableCacheLevel: map[string]config.CacheLevel{TestModelTableName: config.CacheLevelOnlyPrimary},
+				CacheStorage:    store,
+				CacheTTL:        5000,
+			})
+			So(err, ShouldBeNil)
+			gorm2Cache := asGorm2Cache(c)
+
+			for i := 0; i < 2; i++ {
+				models := make([]TestModel, 0)
+				So(db.Where("value1 BETWEEN ? AND ?", 198, 199).Find(&models).Error, ShouldBeNil)
+				So(len(models), ShouldEqual, 2)
+			}
+			So(c.HitCount(), ShouldEqual, 0)
+			count, err := store.CountKeysWithPrefix(context.Background(), util.GenSearchCachePrefix(gorm2Cache.InstanceId, TestModelTableName))
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 0)
+
+			model := TestModel{}
+			So(db.Where("id = ?", 198).First(&model).Error, ShouldBeNil)
+			So(model.Value9, ShouldEqual, "198")
+			So(c.HitCount(), ShouldEqual, 1)
+		})
+
+		Convey("a table with its own max item count", func() {
+			c, db, err := newCacheDB(&config.CacheConfig{
+				CacheLevel:           config.CacheLevelOnlySearch,
+				CacheStorage:         store,
+				CacheTTL:             5000,
+				CacheMaxItemCnt:      100,
+				TableCacheMaxItemCnt: map[string]int64{TestModelTableName: 3},
+			})
+			So(err, ShouldBeNil)
+
+			query := func(to int) {
+				models := make([]TestModel, 0)
+				So(db.Where("value2 BETWEEN ? AND ?", 11, to).Find(&models).Error, ShouldBeNil)
+				So(len(models), ShouldEqual, to-10)
+			}
+			query(14)
+			query(14)
+			So(c.HitCount(), ShouldEqual, 0)
+
+			query(13)
+			query(13)
+			So(c.HitCount(), ShouldEqual, 1)
+		})
+	})
+}
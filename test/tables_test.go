@@ -0,0 +1,63 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTablePatterns(t *testing.T) {
+	Convey("test Tables and DisableTables match names and globs", t, func() {
+		shouldCache := func(tables []string, disableTables []string) func(tableName string) bool {
+			c, _, err := newCacheDB(&config.CacheConfig{
+				CacheLevel:    config.CacheLevelAll,
+				CacheStorage:  storage.NewGcache(gcache.New(1000)),
+				CacheTTL:      5000,
+				Tables:        tables,
+				DisableTables: disableTables,
+			})
+			So(err, ShouldBeNil)
+			return func(tableName string) bool {
+				return asGorm2Cache(c).ShouldCache(nil, tableName)
+			}
+		}
+
+		Convey("names match exactly", func() {
+			cached := shouldCache([]string{"orders", "users"}, nil)
+			So(cached("orders"), ShouldBeTrue)
+			So(cached("users"), ShouldBeTrue)
+			So(cached("orders_2023_01"), ShouldBeFalse)
+		})
+
+		Convey("globs match a family of tables", func() {
+			cached := shouldCache([]string{"orders_*", "user?"}, nil)
+			So(cached("orders_2023_01"), ShouldBeTrue)
+			So(cached("orders_2023_02"), ShouldBeTrue)
+			So(cached("users"), ShouldBeTrue)
+			So(cached("orders"), ShouldBeFalse)
+			So(cached("user_roles"), ShouldBeFalse)
+		})
+
+		Convey("DisableTables wins over overlapping Tables", func() {
+			cached := shouldCache([]string{"orders_*"}, []string{"orders_2023_*", "orders_archive"})
+			So(cached("orders_2024_01"), ShouldBeTrue)
+			So(cached("orders_2023_01"), ShouldBeFalse)
+			So(cached("orders_archive"), ShouldBeFalse)
+		})
+
+		Convey("DisableTables applies with all tables cached", func() {
+			cached := shouldCache(nil, []string{"audit_*"})
+			So(cached("orders"), ShouldBeTrue)
+			So(cached("audit_2023"), ShouldBeFalse)
+		})
+
+		Convey("a malformed glob only matches itself", func() {
+			cached := shouldCache([]string{"orders[", "users"}, nil)
+			So(cached("orders["), ShouldBeTrue)
+			So(cached("orders"), ShouldBeFalse)
+		})
+	})
+}
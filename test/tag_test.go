@@ -0,0 +1,142 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	"github.com/redis/go-redis/v9"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestInvalidateByTag(t *testing.T) {
+	Convey("test invalidate search cache by tag", t, func() {
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelOnlySearch,
+			CacheStorage: storage.NewGcache(gcache.New(1000)),
+			CacheTTL:     5000,
+		})
+		So(err, ShouldBeNil)
+
+		queryAll := func() {
+			models := make([]TestModel, 0)
+			So(cache.WithTag(db, "dashboard:revenue").Where("value1 > ?", 190).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 10)
+			So(cache.WithTag(db, "dashboard:revenue").Where("value2 BETWEEN ? AND ?", 1, 10).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 10)
+			So(cache.WithTag(db, "dashboard:users").Where("value3 = ?", 20).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 1)
+		}
+
+		queryAll()
+		So(c.HitCount(), ShouldEqual, 0)
+		queryAll()
+		So(c.HitCount(), ShouldEqual, 3)
+
+		err = asGorm2Cache(c).InvalidateByTag(context.Background(), "dashboard:revenue")
+		So(err, ShouldBeNil)
+
+		queryAll()
+		So(c.HitCount(), ShouldEqual, 4)
+	})
+}
+
+func TestTaggedSessionTTL(t *testing.T) {
+	Convey("test a session tagged and cached longer is reused by several queries", t, func() {
+		clock := storage.NewManualClock(time.Unix(0, 0))
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelOnlySearch,
+			CacheStorage: storage.NewMemSync(clock),
+			CacheTTL:     1000,
+		})
+		So(err, ShouldBeNil)
+
+		report := cache.WithTTL(cache.WithTag(db, "report"), 30*time.Second)
+		queryAll := func() {
+			models := make([]TestModel, 0)
+			So(report.Where("value1 BETWEEN ? AND ?", 181, 183).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 3)
+			So(report.Where("value1 = ?", 184).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 1)
+		}
+
+		queryAll()
+		clock.Advance(10 * time.Second)
+		queryAll()
+		So(c.HitCount(), ShouldEqual, 2)
+
+		So(asGorm2Cache(c).InvalidateByTag(context.Background(), "report"), ShouldBeNil)
+		queryAll()
+		So(c.HitCount(), ShouldEqual, 2)
+	})
+}
+
+func TestSharedTagIndex(t *testing.T) {
+	Convey("test instances sharing a redis storage add to the same tag sets", t, func() {
+		mr := miniredis.RunT(t)
+		store := storage.NewRedis(&storage.RedisStoreConfig{
+			KeyPrefix: "shared",
+			Options:   &redis.Options{Addr: mr.Addr()},
+		})
+		newDB := func() (cache.Cache, *gorm.DB) {
+			c, db, err := newCacheDB(&config.CacheConfig{
+				CacheLevel:   config.CacheLevelOnlySearch,
+				CacheStorage: store,
+				CacheTTL:     5000,
+				InstanceId:   "fleet",
+			})
+			So(err, ShouldBeNil)
+			return c, db
+		}
+		cacheA, dbA := newDB()
+		cacheB, dbB := newDB()
+
+		queryA := func() {
+			models := make([]TestModel, 0)
+			So(cache.WithTags(dbA, "user:1", "tenant:acme").Where("value1 BETWEEN ? AND ?", 191, 193).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 3)
+		}
+		queryB := func() {
+			models := make([]TestModel, 0)
+			So(cache.WithTag(cache.WithTag(dbB, "tenant:acme"), "user:2").Where("value1 = ?", 194).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 1)
+		}
+		hits := func() uint64 {
+			return cacheA.HitCount() + cacheB.HitCount()
+		}
+
+		queryA()
+		queryB()
+		queryA()
+		queryB()
+		So(hits(), ShouldEqual, 2)
+
+		tenantSet := "shared:" + util.GenTagSetKey("fleet", "tenant:acme")
+		members, err := mr.Members(tenantSet)
+		So(err, ShouldBeNil)
+		So(len(members), ShouldEqual, 2)
+		So(mr.TTL(tenantSet), ShouldBeGreaterThan, 0)
+
+		Convey("invalidating a tag drops the entries carrying it only", func() {
+			So(asGorm2Cache(cacheB).InvalidateByTag(context.Background(), "user:1"), ShouldBeNil)
+			queryA()
+			queryB()
+			So(hits(), ShouldEqual, 3)
+		})
+
+		Convey("invalidating a tag shared by the instances drops the entries of both", func() {
+			So(asGorm2Cache(cacheA).InvalidateByTag(context.Background(), "tenant:acme"), ShouldBeNil)
+			So(mr.Exists(tenantSet), ShouldBeFalse)
+			queryA()
+			queryB()
+			So(hits(), ShouldEqual, 2)
+		})
+	})
+}
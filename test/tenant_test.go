@@ -0,0 +1,97 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+type resolvedTenant struct{}
+
+func TestTenantIsolation(t *testing.T) {
+	Convey("test tenants cache and invalidate their entries apart", t, func() {
+		defer originalDB.Model(&TestModel{ID: 196}).Update("value2", 196)
+
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:           config.CacheLevelOnlySearch,
+			CacheStorage:         storage.NewMemSync(nil),
+			CacheTTL:             5000,
+			InvalidateWhenUpdate: true,
+			TenantResolver: func(ctx context.Context) string {
+				tenantID, _ := ctx.Value(resolvedTenant{}).(string)
+				return tenantID
+			},
+		})
+		So(err, ShouldBeNil)
+		search := func(db *gorm.DB) {
+			models := make([]TestModel, 0)
+			So(db.Where("value1 BETWEEN ? AND ?", 196, 198).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 3)
+		}
+		tenantA := cache.WithTenant(db, "a")
+		tenantB := db.WithContext(context.WithValue(context.Background(), resolvedTenant{}, "b"))
+
+		search(tenantA)
+		search(tenantA)
+		search(tenantB)
+		search(db)
+		So(c.HitCount(), ShouldEqual, 1)
+		search(tenantB)
+		search(db)
+		So(c.HitCount(), ShouldEqual, 3)
+
+		// a write of tenant a leaves the entries of tenant b
+		So(tenantA.Model(&TestModel{ID: 196}).Update("value2", 1000).Error, ShouldBeNil)
+		search(tenantA)
+		So(c.HitCount(), ShouldEqual, 3)
+		search(tenantB)
+		So(c.HitCount(), ShouldEqual, 4)
+
+		So(asGorm2Cache(c).InvalidateTenant(context.Background(), "b"), ShouldBeNil)
+		search(tenantB)
+		search(tenantA)
+		search(db)
+		So(c.HitCount(), ShouldEqual, 6)
+	})
+}
+
+func TestInvalidateTenantPrefix(t *testing.T) {
+	Convey("test invalidating a tenant keeps the tenants whose id it prefixes", t, func() {
+		stores := []func() storage.DataStorage{
+			func() storage.DataStorage { return storage.NewMem(&storage.MemStoreConfig{MaxSize: 1000}) },
+			func() storage.DataStorage { return storage.NewGcache(gcache.New(1000)) },
+			func() storage.DataStorage { return storage.NewMemSync(nil) },
+		}
+		for _, newStore := range stores {
+			store := newStore()
+			Convey(fmt.Sprintf("on a %T storage", store), func() {
+				c, db, err := newCacheDB(&config.CacheConfig{
+					CacheLevel:   config.CacheLevelOnlySearch,
+					CacheStorage: store,
+					CacheTTL:     5000,
+				})
+				So(err, ShouldBeNil)
+				search := func(tenantID string) {
+					models := make([]TestModel, 0)
+					So(cache.WithTenant(db, tenantID).Where("value1 BETWEEN ? AND ?", 196, 198).Find(&models).Error, ShouldBeNil)
+					So(len(models), ShouldEqual, 3)
+				}
+				search("acme")
+				search("acme2")
+
+				So(asGorm2Cache(c).InvalidateTenant(context.Background(), "acme"), ShouldBeNil)
+				search("acme2")
+				So(c.HitCount(), ShouldEqual, 1)
+				search("acme")
+				So(c.HitCount(), ShouldEqual, 1)
+			})
+		}
+	})
+}
@@ -0,0 +1,143 @@
+package test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	"github.com/redis/go-redis/v9"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestTieredStorage(t *testing.T) {
+	Convey("test the local tier serves reads in front of the remote one", t, func() {
+		ctx := context.Background()
+		clock := storage.NewManualClock(time.Unix(0, 0))
+		mr := miniredis.RunT(t)
+		local := storage.NewMemSync(clock)
+		remote := storage.NewRedis(&storage.RedisStoreConfig{KeyPrefix: "tiered", Options: &redis.Options{Addr: mr.Addr()}})
+		tiered := storage.NewTiered(&storage.TieredStoreConfig{Local: local, Remote: remote, LocalTTL: 500})
+		So(tiered.Init(&storage.Config{TTL: 10000, Logger: &util.DefaultLogger{}}), ShouldBeNil)
+
+		Convey("written through to both tiers", func() {
+			So(tiered.SetKey(ctx, util.Kv{Key: "a", Value: "1"}), ShouldBeNil)
+			So(tiered.BatchSetKeys(ctx, []util.Kv{{Key: "b", Value: "2"}}), ShouldBeNil)
+			for _, tier := range []storage.DataStorage{local, remote} {
+				values, err := tier.BatchGetValues(ctx, []string{"a", "b"})
+				So(err, ShouldBeNil)
+				So(values, ShouldResemble, []string{"1", "2"})
+			}
+			So(mr.TTL("tiered:a"), ShouldBeGreaterThan, time.Second)
+
+			// the local copies expire after LocalTTL, the remote ones are read again
+			clock.Advance(600 * time.Millisecond)
+			_, err := local.GetValue(ctx, "a")
+			So(err, ShouldEqual, storage.ErrCacheNotFound)
+			value, err := tiered.GetValue(ctx, "a")
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, "1")
+		})
+
+		Convey("promoted to the local tier on a miss", func() {
+			So(remote.BatchSetKeys(ctx, []util.Kv{{Key: "a", Value: "1"}, {Key: "b", Value: "2"}}), ShouldBeNil)
+			So(local.SetKey(ctx, util.Kv{Key: "c", Value: "3"}), ShouldBeNil)
+
+			values, err := tiered.BatchGetValues(ctx, []string{"a", "c", "d", "b"})
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []string{"1", "3", "", "2"})
+			values, err = local.BatchGetValues(ctx, []string{"a", "b", "d"})
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []string{"1", "2", ""})
+
+			// a remote change shows up once the local copy expired
+			So(remote.SetKey(ctx, util.Kv{Key: "a", Value: "changed"}), ShouldBeNil)
+			value, err := tiered.GetValue(ctx, "a")
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, "1")
+			clock.Advance(600 * time.Millisecond)
+			value, err = tiered.GetValue(ctx, "a")
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, "changed")
+
+			_, err = tiered.GetValue(ctx, "d")
+			So(err, ShouldEqual, storage.ErrCacheNotFound)
+		})
+
+		Convey("deleted from both tiers", func() {
+			So(tiered.BatchSetKeys(ctx, []util.Kv{{Key: "p:1", Value: "1"}, {Key: "p:2", Value: "2"}, {Key: "s:1", Value: "3"}}), ShouldBeNil)
+			So(tiered.DeleteKey(ctx, "p:1"), ShouldBeNil)
+			So(tiered.DeleteKeysWithPrefix(ctx, "p"), ShouldBeNil)
+			for _, tier := range []storage.DataStorage{local, remote, tiered} {
+				values, err := tier.BatchGetValues(ctx, []string{"p:1", "p:2", "s:1"})
+				So(err, ShouldBeNil)
+				So(values, ShouldResemble, []string{"", "", "3"})
+			}
+
+			So(tiered.CleanCache(ctx), ShouldBeNil)
+			for _, tier := range []storage.DataStorage{local, remote} {
+				exists, err := tier.KeyExists(ctx, "s:1")
+				So(err, ShouldBeNil)
+				So(exists, ShouldBeFalse)
+			}
+		})
+	})
+}
+
+func TestTieredBroadcastInvalidation(t *testing.T) {
+	Convey("test a broadcast invalidation evicts the local tier before its ttl", t, func() {
+		mr := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		newInstance := func(instanceId string) (cache.Cache, *gorm.DB) {
+			c, db, err := newCacheDB(&config.CacheConfig{
+				CacheLevel: config.CacheLevelAll,
+				CacheStorage: storage.NewTiered(&storage.TieredStoreConfig{
+					Remote:   storage.NewRedis(&storage.RedisStoreConfig{KeyPrefix: "shared", Client: client}),
+					LocalTTL: 60000,
+				}),
+				CacheTTL:             60000,
+				InvalidateWhenUpdate: true,
+				InstanceId:           instanceId,
+				Broadcaster:          storage.NewRedisBroadcaster(&storage.RedisBroadcasterConfig{Client: client}),
+			})
+			So(err, ShouldBeNil)
+			return c, db
+		}
+
+		for _, instanceId := range []string{"", "tiered"} {
+			instanceId := instanceId
+			Convey("of instances with the InstanceId "+strconv.Quote(instanceId), func() {
+				a, dbA := newInstance(instanceId)
+				defer asGorm2Cache(a).Close()
+				b, dbB := newInstance(instanceId)
+				defer asGorm2Cache(b).Close()
+
+				query := func() string {
+					model := TestModel{}
+					So(dbB.Where("id = ?", 199).First(&model).Error, ShouldBeNil)
+					return model.Value9
+				}
+				So(query(), ShouldEqual, "199")
+				So(query(), ShouldEqual, "199")
+				So(b.HitCount(), ShouldEqual, 1)
+
+				So(dbA.Model(&TestModel{}).Where("id = ?", 199).Update("value9", "tiered").Error, ShouldBeNil)
+				defer originalDB.Model(&TestModel{}).Where("id = ?", 199).Update("value9", "199")
+
+				deadline := time.Now().Add(time.Second)
+				value := query()
+				for value != "tiered" && time.Now().Before(deadline) {
+					time.Sleep(20 * time.Millisecond)
+					value = query()
+				}
+				So(value, ShouldEqual, "tiered")
+			})
+		}
+	})
+}
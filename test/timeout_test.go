@@ -0,0 +1,98 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStorageTimeouts(t *testing.T) {
+	Convey("test read and write timeouts bound cache operations independently", t, func() {
+		store := &slowStorage{DataStorage: storage.NewGcache(gcache.New(1000))}
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelOnlySearch,
+			CacheStorage: store,
+			CacheTTL:     5000,
+			ReadTimeout:  20,
+			WriteTimeout: 200,
+		})
+		So(err, ShouldBeNil)
+
+		query := func() {
+			models := make([]TestModel, 0)
+			So(db.Where("value1 BETWEEN ? AND ?", 161, 164).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 4)
+		}
+
+		Convey("slow reads fall through to the database", func() {
+			query()
+			query()
+			So(c.HitCount(), ShouldEqual, 1)
+
+			atomic.StoreInt64(&store.delay, int64(100*time.Millisecond))
+			start := time.Now()
+			query()
+			So(time.Since(start), ShouldBeLessThan, 100*time.Millisecond)
+			So(c.HitCount(), ShouldEqual, 1)
+			So(asGorm2Cache(c).ErrorCount(), ShouldEqual, 1)
+		})
+
+		Convey("writes slower than the read timeout still populate the cache", func() {
+			atomic.StoreInt64(&store.writeDelay, int64(50*time.Millisecond))
+			query()
+			query()
+			So(c.HitCount(), ShouldEqual, 1)
+		})
+
+		Convey("writes slower than the write timeout leave the result uncached", func() {
+			atomic.StoreInt64(&store.writeDelay, int64(time.Second))
+			start := time.Now()
+			query()
+			So(time.Since(start), ShouldBeLessThan, 500*time.Millisecond)
+
+			atomic.StoreInt64(&store.writeDelay, 0)
+			query()
+			So(c.HitCount(), ShouldEqual, 0)
+			So(asGorm2Cache(c).ErrorCount(), ShouldEqual, 1)
+		})
+	})
+
+	Convey("test the storage timeout bounds reads, writes and invalidations alike", t, func() {
+		store := &slowStorage{DataStorage: storage.NewGcache(gcache.New(1000))}
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:     config.CacheLevelOnlySearch,
+			CacheStorage:   store,
+			CacheTTL:       5000,
+			StorageTimeout: 30,
+			OnStorageError: config.StorageErrorFailClosed,
+		})
+		So(err, ShouldBeNil)
+		query := func() {
+			models := make([]TestModel, 0)
+			So(db.Where("value1 BETWEEN ? AND ?", 165, 168).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 4)
+		}
+		query()
+		query()
+		So(c.HitCount(), ShouldEqual, 1)
+
+		atomic.StoreInt64(&store.delay, int64(time.Second))
+		start := time.Now()
+		query()
+		So(time.Since(start), ShouldBeLessThan, 500*time.Millisecond)
+		So(c.HitCount(), ShouldEqual, 1)
+
+		atomic.StoreInt64(&store.writeDelay, int64(time.Second))
+		start = time.Now()
+		err = asGorm2Cache(c).InvalidateSearchCache(context.Background(), TestModelTableName)
+		So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+		So(time.Since(start), ShouldBeLessThan, 500*time.Millisecond)
+	})
+}
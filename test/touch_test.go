@@ -0,0 +1,64 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTouchPrimaryKey(t *testing.T) {
+	Convey("test touching a primary key extends the lifetime of its cached row", t, func() {
+		clock := storage.NewManualClock(time.Unix(0, 0))
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelOnlyPrimary,
+			CacheStorage: storage.NewMemSync(clock),
+			CacheTTL:     5000,
+			TableTTL:     map[string]int64{TestModelTableName: 3000},
+		})
+		So(err, ShouldBeNil)
+		gc := asGorm2Cache(c)
+		ctx := context.Background()
+		key := util.GenPrimaryCacheKey(gc.InstanceId, TestModelTableName, "181")
+
+		_, err = gc.GetKeyTTL(ctx, key)
+		So(err, ShouldEqual, storage.ErrCacheNotFound)
+		So(gc.TouchPrimaryKey(ctx, TestModelTableName, "181", time.Minute), ShouldEqual, storage.ErrCacheNotFound)
+
+		So(db.Where("id = ?", 181).First(&TestModel{}).Error, ShouldBeNil)
+		ttl, err := gc.GetKeyTTL(ctx, key)
+		So(err, ShouldBeNil)
+		So(ttl, ShouldEqual, 3*time.Second)
+
+		clock.Advance(2 * time.Second)
+		So(gc.TouchPrimaryKey(ctx, TestModelTableName, "181", time.Minute), ShouldBeNil)
+		ttl, err = gc.GetKeyTTL(ctx, key)
+		So(err, ShouldBeNil)
+		So(ttl, ShouldEqual, time.Minute)
+
+		clock.Advance(30 * time.Second)
+		So(db.Where("id = ?", 181).First(&TestModel{}).Error, ShouldBeNil)
+		So(c.HitCount(), ShouldEqual, 1)
+
+		// restarts the ttl of the table
+		So(gc.TouchPrimaryKey(ctx, TestModelTableName, "181", 0), ShouldBeNil)
+		ttl, err = gc.GetKeyTTL(ctx, key)
+		So(err, ShouldBeNil)
+		So(ttl, ShouldEqual, 3*time.Second)
+	})
+
+	Convey("test ttls of storages unable to tell them", t, func() {
+		c, _, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelOnlyPrimary,
+			CacheStorage: storage.NewGcache(gcache.New(10)),
+		})
+		So(err, ShouldBeNil)
+		_, err = asGorm2Cache(c).GetKeyTTL(context.Background(), "key")
+		So(err, ShouldEqual, util.ErrTTLUnsupported)
+	})
+}
@@ -0,0 +1,104 @@
+package test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type spanKey struct{}
+
+type recordedSpan struct {
+	mu         sync.Mutex
+	name       string
+	parent     string
+	attributes map[string]interface{}
+	ended      bool
+}
+
+func (s *recordedSpan) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attributes[key] = value
+}
+
+func (s *recordedSpan) RecordError(err error) {
+	s.SetAttribute("error", err)
+}
+
+func (s *recordedSpan) End() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ended = true
+}
+
+// recordingTracer records the spans started, keeping the name of their parent
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) (context.Context, config.Span) {
+	span := &recordedSpan{name: name, attributes: make(map[string]interface{})}
+	if parent, ok := ctx.Value(spanKey{}).(*recordedSpan); ok {
+		span.parent = parent.name
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// take returns the spans recorded so far by name, forgetting them
+func (t *recordingTracer) take() map[string]*recordedSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	spans := make(map[string]*recordedSpan)
+	for _, span := range t.spans {
+		So(span.ended, ShouldBeTrue)
+		spans[span.name] = span
+	}
+	t.spans = nil
+	return spans
+}
+
+func TestTracer(t *testing.T) {
+	Convey("test cache operations are traced", t, func() {
+		tracer := &recordingTracer{}
+		_, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelAll,
+			CacheStorage: storage.NewGcache(gcache.New(1000)),
+			CacheTTL:     5000,
+			Tracer:       tracer,
+		})
+		So(err, ShouldBeNil)
+
+		query := func() {
+			models := make([]TestModel, 0)
+			So(db.Where("value1 BETWEEN ? AND ?", 131, 133).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 3)
+		}
+
+		query()
+		spans := tracer.take()
+		So(len(spans), ShouldEqual, 4)
+		So(spans["gorm-cache.query"].attributes["gorm-cache.outcome"], ShouldEqual, "miss")
+		So(spans["gorm-cache.query"].attributes["gorm-cache.table"], ShouldEqual, TestModelTableName)
+		So(spans["gorm-cache.search.get"].parent, ShouldEqual, "gorm-cache.query")
+		So(spans["gorm-cache.search.get"].attributes["gorm-cache.hit"], ShouldEqual, false)
+		So(spans["gorm-cache.search.get"].attributes["error"], ShouldBeNil)
+		So(spans["gorm-cache.search.set"], ShouldNotBeNil)
+		So(spans["gorm-cache.primary.set"].attributes["gorm-cache.keys"], ShouldEqual, 3)
+
+		query()
+		spans = tracer.take()
+		So(len(spans), ShouldEqual, 2)
+		So(spans["gorm-cache.query"].attributes["gorm-cache.outcome"], ShouldEqual, "search_hit")
+		So(spans["gorm-cache.search.get"].attributes["gorm-cache.hit"], ShouldEqual, true)
+	})
+}
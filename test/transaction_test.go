@@ -0,0 +1,146 @@
+package test
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestCacheInTransaction(t *testing.T) {
+	Convey("test queries within a transaction", t, func() {
+		newDB := func(cacheInTransaction bool) (func() uint64, *gorm.DB) {
+			c, db, err := newCacheDB(&config.CacheConfig{
+				CacheLevel:         config.CacheLevelAll,
+				CacheStorage:       storage.NewGcache(gcache.New(1000)),
+				CacheTTL:           5000,
+				CacheInTransaction: cacheInTransaction,
+			})
+			So(err, ShouldBeNil)
+			return c.HitCount, db
+		}
+		query := func(db *gorm.DB) error {
+			models := make([]TestModel, 0)
+			if err := db.Where("value1 BETWEEN ? AND ?", 26, 28).Find(&models).Error; err != nil {
+				return err
+			}
+			So(len(models), ShouldEqual, 3)
+			return nil
+		}
+		transaction := func(db *gorm.DB) {
+			So(db.Transaction(func(tx *gorm.DB) error {
+				if err := query(tx); err != nil {
+					return err
+				}
+				return query(tx)
+			}), ShouldBeNil)
+		}
+
+		Convey("bypassing the cache by default", func() {
+			hits, db := newDB(false)
+			transaction(db)
+			So(hits(), ShouldEqual, 0)
+
+			// nothing was cached by the transaction
+			So(query(db), ShouldBeNil)
+			So(hits(), ShouldEqual, 0)
+			So(query(db), ShouldBeNil)
+			So(hits(), ShouldEqual, 1)
+
+			transaction(db)
+			So(hits(), ShouldEqual, 1)
+		})
+
+		Convey("using the cache with CacheInTransaction", func() {
+			hits, db := newDB(true)
+			transaction(db)
+			So(hits(), ShouldEqual, 1)
+			So(query(db), ShouldBeNil)
+			So(hits(), ShouldEqual, 2)
+		})
+	})
+}
+
+func TestInvalidateOnCommit(t *testing.T) {
+	Convey("test writes within a transaction invalidate the cache once it commits", t, func() {
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:           config.CacheLevelOnlySearch,
+			CacheStorage:         storage.NewMemSync(nil),
+			CacheTTL:             5000,
+			InvalidateWhenUpdate: true,
+			InvalidateOnCommit:   true,
+		})
+		So(err, ShouldBeNil)
+
+		query := func() {
+			models := make([]TestModel, 0)
+			So(db.Where("value1 = ?", 151).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 1)
+		}
+		invalidations := func() uint64 {
+			return c.TableStats(TestModelTableName).Search.InvalidationCount
+		}
+		// the row is left as it is, the update still invalidates the cache
+		update := func(tx *gorm.DB) error {
+			return tx.Model(&TestModel{ID: 151}).Update("value2", 151).Error
+		}
+
+		query()
+		query()
+		So(c.HitCount(), ShouldEqual, 1)
+
+		Convey("dropping the invalidations of a transaction rolled back", func() {
+			tx := db.Begin()
+			So(update(tx), ShouldBeNil)
+			So(invalidations(), ShouldEqual, 0)
+			So(tx.Rollback().Error, ShouldBeNil)
+			So(invalidations(), ShouldEqual, 0)
+
+			query()
+			So(c.HitCount(), ShouldEqual, 2)
+		})
+
+		Convey("issuing the invalidations of a transaction committed", func() {
+			So(db.Transaction(func(tx *gorm.DB) error {
+				if err := update(tx); err != nil {
+					return err
+				}
+				So(invalidations(), ShouldEqual, 0)
+				return update(tx)
+			}), ShouldBeNil)
+			So(invalidations(), ShouldEqual, 2)
+
+			query()
+			So(c.HitCount(), ShouldEqual, 1)
+		})
+
+		Convey("invalidating at once outside of a transaction", func() {
+			So(update(db.Session(&gorm.Session{SkipDefaultTransaction: true})), ShouldBeNil)
+			So(invalidations(), ShouldEqual, 1)
+		})
+	})
+}
+
+func TestInvalidateInTransaction(t *testing.T) {
+	Convey("test writes within a transaction invalidate the cache at once without InvalidateOnCommit", t, func() {
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:           config.CacheLevelOnlySearch,
+			CacheStorage:         storage.NewMemSync(nil),
+			CacheTTL:             5000,
+			InvalidateWhenUpdate: true,
+		})
+		So(err, ShouldBeNil)
+		// the connection pool is left as it is
+		_, ok := db.ConnPool.(*sql.DB)
+		So(ok, ShouldBeTrue)
+
+		tx := db.Begin()
+		So(tx.Model(&TestModel{ID: 152}).Update("value2", 152).Error, ShouldBeNil)
+		So(c.TableStats(TestModelTableName).Search.InvalidationCount, ShouldEqual, 1)
+		So(tx.Rollback().Error, ShouldBeNil)
+	})
+}
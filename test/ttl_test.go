@@ -0,0 +1,100 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEffectiveTTL(t *testing.T) {
+	Convey("test effective ttl follows query, table and global precedence", t, func() {
+		clock := storage.NewManualClock(time.Unix(0, 0))
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:           config.CacheLevelOnlySearch,
+			CacheStorage:         storage.NewMemSync(clock),
+			CacheTTL:             5000,
+			VolatileOrderColumns: map[string][]string{"gorm_cache_model": {"value2"}},
+			VolatileOrderTTL:     200,
+		})
+		So(err, ShouldBeNil)
+
+		Convey("global default", func() {
+			ttl, reason := cache.EffectiveTTL(c, db.Model(&TestModel{}).Where("value1 = ?", 1))
+			So(ttl, ShouldEqual, 5*time.Second)
+			So(reason, ShouldStartWith, "global:")
+		})
+
+		Convey("per-table config", func() {
+			ttl, reason := cache.EffectiveTTL(c, db.Model(&TestModel{}).Order("value2 DESC"))
+			So(ttl, ShouldEqual, 200*time.Millisecond)
+			So(reason, ShouldStartWith, "per-table:")
+			So(reason, ShouldContainSubstring, "value2")
+
+			asGorm2Cache(c).Config.VolatileOrderTTL = 0
+			defer func() { asGorm2Cache(c).Config.VolatileOrderTTL = 200 }()
+			ttl, reason = cache.EffectiveTTL(c, db.Model(&TestModel{}).Order("value2 DESC"))
+			So(ttl, ShouldEqual, 0)
+			So(reason, ShouldContainSubstring, "not search cached")
+		})
+
+		Convey("per-query option", func() {
+			ttl, reason := cache.EffectiveTTL(c, cache.WithTTL(db, time.Second).Model(&TestModel{}).Order("value2 DESC"))
+			So(ttl, ShouldEqual, time.Second)
+			So(reason, ShouldStartWith, "per-query:")
+		})
+
+		Convey("per-query ttl is applied to the cached result", func() {
+			query := func() uint64 {
+				models := make([]TestModel, 0)
+				err := cache.WithTTL(db, time.Second).Where("value1 BETWEEN ? AND ?", 141, 143).Find(&models).Error
+				So(err, ShouldBeNil)
+				So(len(models), ShouldEqual, 3)
+				return c.HitCount()
+			}
+			query()
+			So(query(), ShouldEqual, 1)
+			clock.Advance(time.Second)
+			So(query(), ShouldEqual, 1)
+		})
+	})
+}
+
+func TestTableTTL(t *testing.T) {
+	Convey("test table ttl overrides the global ttl for the table", t, func() {
+		clock := storage.NewManualClock(time.Unix(0, 0))
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelAll,
+			CacheStorage: storage.NewMemSync(clock),
+			CacheTTL:     5000,
+			TableTTL:     map[string]int64{"gorm_cache_model": 1000, "gorm_cache_other": 60000},
+		})
+		So(err, ShouldBeNil)
+
+		ttl, reason := cache.EffectiveTTL(c, db.Model(&TestModel{}).Where("value1 = ?", 1))
+		So(ttl, ShouldEqual, time.Second)
+		So(reason, ShouldEqual, "per-table: TableTTL of gorm_cache_model")
+
+		ttl, _ = cache.EffectiveTTL(c, cache.WithTTL(db, 2*time.Second).Model(&TestModel{}).Where("value1 = ?", 1))
+		So(ttl, ShouldEqual, 2*time.Second)
+
+		query := func() uint64 {
+			models := make([]TestModel, 0)
+			So(db.Where("value1 BETWEEN ? AND ?", 11, 13).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 3)
+
+			model := TestModel{}
+			So(db.Where("id = ?", 14).First(&model).Error, ShouldBeNil)
+			So(model.Value1, ShouldEqual, 14)
+			return c.HitCount()
+		}
+		So(query(), ShouldEqual, 0)
+		So(query(), ShouldEqual, 2)
+		clock.Advance(time.Second)
+		So(query(), ShouldEqual, 2)
+		So(query(), ShouldEqual, 4)
+	})
+}
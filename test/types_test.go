@@ -0,0 +1,67 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/bluele/gcache"
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func init() {
+	cache.RegisterType[Point](
+		func(p Point) ([]byte, error) {
+			return []byte(p.String()), nil
+		},
+		func(data []byte) (Point, error) {
+			p := Point{}
+			err := p.Scan(data)
+			return p, err
+		},
+	)
+}
+
+func TestRegisterType(t *testing.T) {
+	Convey("test registered types round trip through the cache", t, func() {
+		So(originalDB.AutoMigrate(&PointModel{}), ShouldBeNil)
+		defer originalDB.Migrator().DropTable(&PointModel{})
+		So(originalDB.Create(&[]PointModel{
+			{ID: 1, Location: Point{x: 1.5, y: -2}},
+			{ID: 2, Location: Point{x: 13.4, y: 52.52}},
+		}).Error, ShouldBeNil)
+
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelAll,
+			CacheStorage: storage.NewGcache(gcache.New(1000)),
+			CacheTTL:     5000,
+		})
+		So(err, ShouldBeNil)
+
+		Convey("primary cache", func() {
+			fromDB := PointModel{}
+			So(originalDB.Where("id = ?", 1).First(&fromDB).Error, ShouldBeNil)
+			So(fromDB.Location, ShouldResemble, Point{x: 1.5, y: -2})
+
+			for i := 0; i < 2; i++ {
+				model := PointModel{}
+				So(db.Where("id = ?", 1).First(&model).Error, ShouldBeNil)
+				So(model, ShouldResemble, fromDB)
+			}
+			So(c.HitCount(), ShouldEqual, 1)
+		})
+
+		Convey("search cache", func() {
+			fromDB := make([]PointModel, 0)
+			So(originalDB.Where("id > ?", 0).Order("id").Find(&fromDB).Error, ShouldBeNil)
+
+			for i := 0; i < 2; i++ {
+				models := make([]PointModel, 0)
+				So(db.Where("id > ?", 0).Order("id").Find(&models).Error, ShouldBeNil)
+				So(models, ShouldResemble, fromDB)
+			}
+			So(c.HitCount(), ShouldEqual, 1)
+		})
+	})
+}
@@ -0,0 +1,59 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUncachedFields(t *testing.T) {
+	Convey("test fields tagged gormCache:\"-\" are left out of the cache", t, func() {
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelAll,
+			CacheStorage: storage.NewMemSync(nil),
+			CacheTTL:     5000,
+		})
+		So(err, ShouldBeNil)
+
+		Convey("queries by primary key selecting them are loaded from the database", func() {
+			for i := 0; i < 2; i++ {
+				model := &SecretModel{}
+				So(db.Where("id = ?", 141).First(model).Error, ShouldBeNil)
+				So(model.Value9, ShouldEqual, "141")
+			}
+			So(c.HitCount(), ShouldEqual, 0)
+
+			// the row was cached without the field, queries omitting it are served
+			model := &SecretModel{}
+			So(db.Omit("value9").Where("id = ?", 141).First(model).Error, ShouldBeNil)
+			So(model.Value1, ShouldEqual, 141)
+			So(model.Value9, ShouldEqual, "")
+			So(c.HitCount(), ShouldEqual, 1)
+		})
+
+		Convey("searches selecting them aren't cached", func() {
+			search := func() []SecretModel {
+				models := make([]SecretModel, 0)
+				So(db.Where("value1 BETWEEN ? AND ?", 141, 142).Find(&models).Error, ShouldBeNil)
+				So(len(models), ShouldEqual, 2)
+				return models
+			}
+			So(search()[1].Value9, ShouldEqual, "142")
+			So(search()[1].Value9, ShouldEqual, "142")
+			So(c.HitCount(), ShouldEqual, 0)
+			count, err := asGorm2Cache(c).CountSearchCache(context.Background(), TestModelTableName)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 0)
+
+			for i := 0; i < 2; i++ {
+				models := make([]SecretModel, 0)
+				So(db.Select("id", "value1").Where("value1 BETWEEN ? AND ?", 141, 142).Find(&models).Error, ShouldBeNil)
+				So(len(models), ShouldEqual, 2)
+			}
+			So(c.HitCount(), ShouldEqual, 1)
+		})
+	})
+}
@@ -1,9 +1,16 @@
 package test
 
 import (
+	"context"
+	"strconv"
+	"testing"
+
 	. "github.com/smartystreets/goconvey/convey"
 
 	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
 	"gorm.io/gorm"
 )
 
@@ -64,3 +71,65 @@ func testSearchUpdate(cache cache.Cache, db *gorm.DB) {
 	So(cache.HitCount(), ShouldEqual, 1)
 	So(len(models), ShouldEqual, 2)
 }
+
+func TestUpdatePrimaryKeys(t *testing.T) {
+	Convey("test updates invalidate the primary cache of the rows they write only", t, func() {
+		store := storage.NewMemSync(nil)
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:           config.CacheLevelOnlyPrimary,
+			CacheStorage:         store,
+			CacheTTL:             5000,
+			EmptyCacheTTL:        5000,
+			InvalidateWhenUpdate: true,
+		})
+		So(err, ShouldBeNil)
+		instanceId := asGorm2Cache(c).InstanceId
+
+		cached := func(ids ...int64) []bool {
+			result := make([]bool, 0, len(ids))
+			for _, id := range ids {
+				exists, err := store.KeyExists(context.Background(),
+					util.GenPrimaryCacheKey(instanceId, TestModelTableName, strconv.FormatInt(id, 10)))
+				So(err, ShouldBeNil)
+				result = append(result, exists)
+			}
+			return result
+		}
+		models := make([]TestModel, 0)
+		So(db.Where("id IN (?)", []int64{141, 142, 143, 144}).Find(&models).Error, ShouldBeNil)
+		So(len(models), ShouldEqual, 4)
+		So(cached(141, 142, 143, 144), ShouldResemble, []bool{true, true, true, true})
+
+		// the rows are left as they are, the updates still invalidate them
+		Convey("by an IN condition", func() {
+			So(db.Model(&TestModel{}).Where("id IN ?", []int64{141, 142}).
+				Update("value2", gorm.Expr("value2")).Error, ShouldBeNil)
+			So(cached(141, 142, 143, 144), ShouldResemble, []bool{false, false, true, true})
+		})
+
+		Convey("by a condition on the quoted column of the table", func() {
+			So(db.Model(&TestModel{}).Where("`gorm_cache_model`.`id` = ?", 143).
+				Update("value2", gorm.Expr("value2")).Error, ShouldBeNil)
+			So(cached(141, 142, 143, 144), ShouldResemble, []bool{true, true, false, true})
+		})
+
+		Convey("by conditions not on the primary key", func() {
+			So(db.Model(&TestModel{}).Where("value1 = ?", 144).
+				Update("value2", gorm.Expr("value2")).Error, ShouldBeNil)
+			So(cached(141, 142, 143, 144), ShouldResemble, []bool{false, false, false, false})
+		})
+
+		Convey("along with the keys they assign", func() {
+			So(originalDB.Create(&TestModel{ID: 10051, Value1: 10051}).Error, ShouldBeNil)
+			defer originalDB.Delete(&TestModel{}, []int64{10051, 10052})
+			So(db.Where("id = ?", 10052).First(&TestModel{}).Error, ShouldEqual, gorm.ErrRecordNotFound)
+			So(cached(10052), ShouldResemble, []bool{true})
+
+			So(db.Model(&TestModel{ID: 10051}).Update("id", 10052).Error, ShouldBeNil)
+			So(cached(141, 142, 143, 144), ShouldResemble, []bool{true, true, true, true})
+			model := TestModel{}
+			So(db.Where("id = ?", 10052).First(&model).Error, ShouldBeNil)
+			So(model.Value1, ShouldEqual, 10051)
+		})
+	})
+}
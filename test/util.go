@@ -3,6 +3,8 @@ package test
 import (
 	"fmt"
 	"time"
+
+	"github.com/joykk/gorm-cache/cache"
 )
 
 func log(format string, a ...interface{}) {
@@ -18,3 +20,7 @@ func timer(name string, f func() error) error {
 	fmt.Printf("[%s] finished. cost: %.3fs\n", name, duration.Seconds())
 	return err
 }
+
+func asGorm2Cache(c cache.Cache) *cache.Gorm2Cache {
+	return c.(*cache.Gorm2Cache)
+}
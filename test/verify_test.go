@@ -0,0 +1,66 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestVerifier(t *testing.T) {
+	Convey("test the verifier compares sampled hits with the database in background", t, func() {
+		defer originalDB.Model(&TestModel{}).Where("id = ?", 177).Update("value2", 177)
+
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:           config.CacheLevelAll,
+			CacheStorage:         storage.NewMemSync(nil),
+			CacheTTL:             5000,
+			InvalidateWhenUpdate: true,
+			Verifier:             config.Verifier{SampleRate: 1},
+		})
+		So(err, ShouldBeNil)
+		gc := asGorm2Cache(c)
+
+		verified := func(n uint64) bool {
+			deadline := time.Now().Add(2 * time.Second)
+			for gc.VerifiedCount() < n && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			return gc.VerifiedCount() == n
+		}
+		search := func() []TestModel {
+			models := make([]TestModel, 0)
+			So(db.Where("value1 BETWEEN ? AND ?", 176, 178).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 3)
+			return models
+		}
+		lookup := func(id int64) error {
+			return db.Where("id = ?", id).First(&TestModel{}).Error
+		}
+
+		search()
+		search()
+		So(verified(1), ShouldBeTrue)
+		So(lookup(177), ShouldBeNil)
+		So(verified(2), ShouldBeTrue)
+		So(lookup(1000002), ShouldEqual, gorm.ErrRecordNotFound)
+		So(lookup(1000002), ShouldEqual, gorm.ErrRecordNotFound)
+		So(verified(3), ShouldBeTrue)
+		So(gc.VerifyDivergenceCount(), ShouldEqual, 0)
+
+		// a write the cache doesn't see is still served from the cache, and counted as divergent
+		So(originalDB.Model(&TestModel{}).Where("id = ?", 177).Update("value2", 1770).Error, ShouldBeNil)
+		So(search()[1].Value2, ShouldEqual, 177)
+		So(verified(4), ShouldBeTrue)
+		So(gc.VerifyDivergenceCount(), ShouldEqual, 1)
+		So(gc.StatsSnapshot().VerifyDivergence, ShouldEqual, 1)
+
+		gc.ResetCounter(cache.CounterVerifications)
+		So(gc.VerifiedCount(), ShouldEqual, 0)
+		So(gc.VerifyDivergenceCount(), ShouldEqual, 0)
+	})
+}
@@ -0,0 +1,83 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+// testModelRenamed is TestModel after a deploy renaming one of its fields
+type testModelRenamed struct {
+	ID     int64 `gorm:"primaryKey"`
+	Value1 int
+	Value3 int `gorm:"column:value2"`
+}
+
+func (m *testModelRenamed) TableName() string {
+	return TestModelTableName
+}
+
+func TestCacheVersion(t *testing.T) {
+	Convey("test entries written by another version of the model or of the cache are misses", t, func() {
+		store := storage.NewMemSync(nil)
+		newDB := func(version string) (*gorm.DB, *cache.Gorm2Cache) {
+			c, db, err := newCacheDB(&config.CacheConfig{
+				CacheLevel:   config.CacheLevelAll,
+				CacheStorage: store,
+				CacheTTL:     5000,
+				InstanceId:   "versioned",
+				CacheVersion: version,
+			})
+			So(err, ShouldBeNil)
+			return db, asGorm2Cache(c)
+		}
+		get := func(db *gorm.DB) {
+			model := TestModel{}
+			So(db.Where("id = ?", 131).First(&model).Error, ShouldBeNil)
+			So(model.Value2, ShouldEqual, 131)
+		}
+		search := func(db *gorm.DB) {
+			models := make([]TestModel, 0)
+			So(db.Where("value1 BETWEEN ? AND ?", 131, 133).Find(&models).Error, ShouldBeNil)
+			So(len(models), ShouldEqual, 3)
+		}
+
+		dbV1, cacheV1 := newDB("v1")
+		get(dbV1)
+		search(dbV1)
+		get(dbV1)
+		search(dbV1)
+		So(cacheV1.HitCount(), ShouldEqual, 2)
+
+		Convey("another cache version", func() {
+			dbV2, cacheV2 := newDB("v2")
+			get(dbV2)
+			search(dbV2)
+			So(cacheV2.HitCount(), ShouldEqual, 0)
+			So(cacheV2.MissCount(), ShouldEqual, 2)
+			get(dbV2)
+			search(dbV2)
+			So(cacheV2.HitCount(), ShouldEqual, 2)
+		})
+
+		Convey("another version of the model", func() {
+			renamed := func() {
+				model := testModelRenamed{}
+				So(dbV1.Where("id = ?", 131).First(&model).Error, ShouldBeNil)
+				So(model.Value3, ShouldEqual, 131)
+			}
+			renamed()
+			So(cacheV1.HitCount(), ShouldEqual, 2)
+			renamed()
+			So(cacheV1.HitCount(), ShouldEqual, 3)
+
+			model := TestModel{}
+			So(cacheV1.LoadModel(context.Background(), &model, 131), ShouldEqual, storage.ErrCacheNotFound)
+		})
+	})
+}
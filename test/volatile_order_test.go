@@ -0,0 +1,65 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm/clause"
+)
+
+func TestVolatileOrderColumns(t *testing.T) {
+	Convey("test search queries ordered by volatile columns", t, func() {
+		clock := storage.NewManualClock(time.Unix(0, 0))
+		newDB := func(volatileTTL int64) (func(order interface{}) uint64, func() uint64) {
+			c, db, err := newCacheDB(&config.CacheConfig{
+				CacheLevel:           config.CacheLevelOnlySearch,
+				CacheStorage:         storage.NewMemSync(clock),
+				CacheTTL:             5000,
+				VolatileOrderColumns: map[string][]string{"gorm_cache_model": {"value2"}},
+				VolatileOrderTTL:     volatileTTL,
+			})
+			So(err, ShouldBeNil)
+
+			query := func(order interface{}) uint64 {
+				models := make([]TestModel, 0)
+				So(db.Where("value1 BETWEEN ? AND ?", 131, 133).Order(order).Find(&models).Error, ShouldBeNil)
+				So(len(models), ShouldEqual, 3)
+				return c.HitCount()
+			}
+			return query, c.HitCount
+		}
+
+		Convey("are not search cached without a volatile ttl", func() {
+			query, hits := newDB(0)
+
+			query("value2 DESC")
+			So(query("value2 DESC"), ShouldEqual, 0)
+			query(clause.OrderByColumn{Column: clause.Column{Table: "gorm_cache_model", Name: "value2"}, Desc: true})
+			So(query(clause.OrderByColumn{Column: clause.Column{Table: "gorm_cache_model", Name: "value2"}, Desc: true}), ShouldEqual, 0)
+			query("`gorm_cache_model`.`id`, `value2`")
+			So(query("`gorm_cache_model`.`id`, `value2`"), ShouldEqual, 0)
+
+			query("value3 DESC")
+			So(query("value3 DESC"), ShouldEqual, 1)
+			So(hits(), ShouldEqual, 1)
+		})
+
+		Convey("are search cached for the volatile ttl", func() {
+			query, hits := newDB(100)
+
+			query("value2 DESC")
+			query("value3 DESC")
+			So(query("value2 DESC"), ShouldEqual, 1)
+			So(query("value3 DESC"), ShouldEqual, 2)
+
+			clock.Advance(100 * time.Millisecond)
+			So(query("value2 DESC"), ShouldEqual, 2)
+			So(query("value3 DESC"), ShouldEqual, 3)
+			So(query("value2 DESC"), ShouldEqual, 4)
+			So(hits(), ShouldEqual, 4)
+		})
+	})
+}
@@ -0,0 +1,66 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/joykk/gorm-cache/cache"
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	"github.com/joykk/gorm-cache/util"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestWarm(t *testing.T) {
+	Convey("test warming runs queries to fill the primary and search caches", t, func() {
+		c, db, err := newCacheDB(&config.CacheConfig{
+			CacheLevel:   config.CacheLevelAll,
+			CacheStorage: storage.NewMemSync(nil),
+			CacheTTL:     5000,
+		})
+		So(err, ShouldBeNil)
+		gorm2Cache := asGorm2Cache(c)
+		ctx := context.Background()
+
+		search := func(db *gorm.DB) *gorm.DB {
+			return db.Where("value1 BETWEEN ? AND ?", 191, 193).Order("id").Find(&[]TestModel{})
+		}
+		missing := func(db *gorm.DB) *gorm.DB {
+			return db.Where("id = ?", 3003).First(&TestModel{})
+		}
+		So(gorm2Cache.Warm(ctx, search, missing), ShouldBeNil)
+		So(c.HitCount()+c.MissCount(), ShouldEqual, 0)
+
+		models := make([]TestModel, 0)
+		So(db.Where("value1 BETWEEN ? AND ?", 191, 193).Order("id").Find(&models).Error, ShouldBeNil)
+		So(len(models), ShouldEqual, 3)
+		model := TestModel{}
+		So(db.Where("id = ?", 192).First(&model).Error, ShouldBeNil)
+		So(db.Where("id = ?", 3003).First(&TestModel{}).Error, ShouldEqual, gorm.ErrRecordNotFound)
+		So(c.HitCount(), ShouldEqual, 3)
+		So(c.MissCount(), ShouldEqual, 0)
+
+		Convey("replacing results already cached", func() {
+			So(originalDB.Model(&TestModel{}).Where("id = ?", 191).Update("value9", "warmed").Error, ShouldBeNil)
+			defer originalDB.Model(&TestModel{}).Where("id = ?", 191).Update("value9", "191")
+
+			So(gorm2Cache.Warm(ctx, search), ShouldBeNil)
+			So(db.Where("value1 BETWEEN ? AND ?", 191, 193).Order("id").Find(&models).Error, ShouldBeNil)
+			So(models[0].Value9, ShouldEqual, "warmed")
+		})
+
+		Convey("failing queries are reported", func() {
+			err := gorm2Cache.Warm(ctx, func(db *gorm.DB) *gorm.DB {
+				return db.Table("gorm_cache_missing_table").Find(&[]TestModel{})
+			}, search)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("test warming needs an attached db", t, func() {
+		c, err := cache.NewGorm2Cache(&config.CacheConfig{CacheStorage: storage.NewMemSync(nil)})
+		So(err, ShouldBeNil)
+		So(asGorm2Cache(c).Warm(context.Background()), ShouldEqual, util.ErrNotAttached)
+	})
+}
@@ -0,0 +1,72 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/joykk/gorm-cache/config"
+	"github.com/joykk/gorm-cache/storage"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestWriteStrategy(t *testing.T) {
+	Convey("test rows written through are read back from the primary cache", t, func() {
+		defer originalDB.Model(&TestModel{ID: 171}).Update("value2", 171)
+		defer originalDB.Delete(&TestModel{}, []int64{12001, 12002, 12003})
+
+		newDB := func(strategy config.WriteStrategy) (func() uint64, *gorm.DB) {
+			c, db, err := newCacheDB(&config.CacheConfig{
+				CacheLevel:           config.CacheLevelAll,
+				CacheStorage:         storage.NewMemSync(nil),
+				CacheTTL:             5000,
+				InvalidateWhenUpdate: true,
+				WriteStrategy:        strategy,
+			})
+			So(err, ShouldBeNil)
+			return c.HitCount, db
+		}
+		lookup := func(db *gorm.DB, id int64) TestModel {
+			model := TestModel{}
+			So(db.Where("id = ?", id).First(&model).Error, ShouldBeNil)
+			return model
+		}
+
+		hits, db := newDB(config.WriteThrough)
+		So(db.Create(&[]TestModel{{ID: 12001, Value1: 12001}, {ID: 12002, Value1: 12002}}).Error, ShouldBeNil)
+		So(lookup(db, 12001).Value1, ShouldEqual, 12001)
+		So(lookup(db, 12002).Value1, ShouldEqual, 12002)
+		So(hits(), ShouldEqual, 2)
+
+		model := lookup(db, 171)
+		model.Value2 = 1000
+		So(db.Save(&model).Error, ShouldBeNil)
+		So(lookup(db, 171).Value2, ShouldEqual, 1000)
+		So(hits(), ShouldEqual, 3)
+
+		Convey("writes of some columns, and within transactions, are invalidated", func() {
+			So(db.Model(&TestModel{ID: 171}).Update("value2", 1001).Error, ShouldBeNil)
+			So(lookup(db, 171).Value2, ShouldEqual, 1001)
+			So(hits(), ShouldEqual, 3)
+
+			model := lookup(db, 171)
+			model.Value2 = 1002
+			So(db.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Save(&model).Error; err != nil {
+					return err
+				}
+				// not saved, the row written through would be this one
+				model.Value2 = 1003
+				return nil
+			}), ShouldBeNil)
+			So(lookup(db, 171).Value2, ShouldEqual, 1002)
+			So(hits(), ShouldEqual, 4)
+		})
+
+		Convey("rows are invalidated by default", func() {
+			hits, db := newDB(config.WriteInvalidate)
+			So(db.Create(&TestModel{ID: 12003, Value1: 12003}).Error, ShouldBeNil)
+			So(lookup(db, 12003).Value1, ShouldEqual, 12003)
+			So(hits(), ShouldEqual, 0)
+		})
+	})
+}
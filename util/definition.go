@@ -6,18 +6,31 @@ var RecordNotFoundCacheHit = errors.New("record not found cache hit")
 var PrimaryCacheHit = errors.New("primary cache hit")
 var SearchCacheHit = errors.New("search cache hit")
 var SingleFlightHit = errors.New("single flight hit")
+var RequestCacheHit = errors.New("request cache hit")
 
 var ErrCacheUnmarshal = errors.New("cache hit, but unmarshal error")
 var ErrCacheLoadFailed = errors.New("cache hit, but load value error")
+var ErrCacheVersionMismatch = errors.New("cache hit, but written for another version of the model")
+var ErrCacheOnlyMiss = errors.New("cache only query missed, database not queried")
+var ErrInvalidationFailed = errors.New("write succeeded, but invalidating cache failed")
+var ErrSingleFlightBusy = errors.New("too many queries waiting on the same single flight load")
+var ErrCacheStorage = errors.New("cache storage error")
+var ErrNotAttached = errors.New("cache is not attached to a gorm db")
+var ErrNotCountable = errors.New("cache storage can't count its keys")
+var ErrTTLUnsupported = errors.New("cache storage can't read or refresh the ttl of its keys")
 
 type Kv struct {
 	Key   string
 	Value string
+	TTL   int64 // ttl in ms overriding the storage's, 0 uses the storage's
 }
 
 type GetGormCachePrefixFunc func() string
 
 // DefaultGetGormCachePrefixFunc 获取前缀名称
+//
+// Deprecated: it applies to every instance in the process, set config.CacheConfig.KeyGenerator instead,
+// e.g. util.PrefixKeyGenerator("myapp")
 var DefaultGetGormCachePrefixFunc = DefaultGormCachePrefix
 
 func DefaultGormCachePrefix() string {
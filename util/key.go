@@ -1,50 +1,268 @@
 package util
 
 import (
+	crand "crypto/rand"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
 	"fmt"
-	"math/rand"
+	"io"
 	"reflect"
 	"strings"
 	"time"
 )
 
+// GenInstanceId returns a random id, instances created at the same time must not share their keys
 func GenInstanceId() string {
 	charList := []byte("1234567890abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
-	rand.Seed(time.Now().Unix())
 	length := 5
+	random := make([]byte, length)
+	if _, err := crand.Read(random); err != nil {
+		panic(err)
+	}
 	str := make([]byte, 0)
 	for i := 0; i < length; i++ {
-		str = append(str, charList[rand.Intn(len(charList))])
+		str = append(str, charList[int(random[i])%len(charList)])
 	}
 	return string(str)
 }
 
+// PrimaryKeyFunc returns the storage key of the primary cache of a row
+type PrimaryKeyFunc func(instanceId string, tableName string, primaryKey string) string
+
+// SearchKeyFunc returns the storage key of the search cache of a query
+type SearchKeyFunc func(instanceId string, tableName string, sql string, vars ...interface{}) string
+
+// KeyGenerator builds the namespace and the query part of the storage keys of a cache instance, see
+// config.CacheConfig.KeyGenerator. Keys is built on it for every kind of key
+type KeyGenerator interface {
+	// Prefix returns the namespace all the keys of an instance start with, "gormcache:<instance id>" by default
+	Prefix(instanceId string) string
+	// QueryKey returns the part of a search cache key identifying a query, its sql and a hash of its vars
+	// (see HashVars) by default
+	QueryKey(sql string, vars ...interface{}) string
+}
+
+// PrefixKeyGenerator builds keys like the default generator under another prefix than "gormcache",
+// e.g. the name of the app
+type PrefixKeyGenerator string
+
+func (g PrefixKeyGenerator) Prefix(instanceId string) string {
+	return string(g) + ":" + instanceId
+}
+
+func (g PrefixKeyGenerator) QueryKey(sql string, vars ...interface{}) string {
+	return sql + ":" + HashVars(vars...)
+}
+
+// defaultKeyGenerator prefixes keys by DefaultGetGormCachePrefixFunc
+type defaultKeyGenerator struct{}
+
+func (defaultKeyGenerator) Prefix(instanceId string) string {
+	return DefaultGetGormCachePrefixFunc() + ":" + instanceId
+}
+
+func (defaultKeyGenerator) QueryKey(sql string, vars ...interface{}) string {
+	return sql + ":" + HashVars(vars...)
+}
+
+// DefaultKeyGenerator generates the keys of the instances without config.CacheConfig.KeyGenerator
+var DefaultKeyGenerator KeyGenerator = defaultKeyGenerator{}
+
+// Keys builds every kind of storage key of the cache instances from a KeyGenerator: the namespace of the
+// instance, then the kind and the table, so that the keys of a table can be deleted by prefix
+type Keys struct {
+	KeyGenerator
+	// Normalizer rewrites queries before their search cache keys are built, nil keeps them as is
+	Normalizer SQLNormalizer
+}
+
+// NewKeys returns the keys of generator, DefaultKeyGenerator if nil, normalizing queries by DefaultSQLNormalizer
+func NewKeys(generator KeyGenerator) Keys {
+	if generator == nil {
+		generator = DefaultKeyGenerator
+	}
+	return Keys{KeyGenerator: generator, Normalizer: DefaultSQLNormalizer}
+}
+
+// TenantSegment is the segment following the instance id in the keys of a tenant, see TenantInstanceId
+const TenantSegment = "tn"
+
+// TenantInstanceId returns the instance id the keys of a tenant are built under, so that they are namespaced
+// under the prefix of the instance and dropped at once by prefix
+func TenantInstanceId(instanceId string, tenantId string) string {
+	return instanceId + ":" + TenantSegment + ":" + tenantId
+}
+
+func (k Keys) PrimaryCacheKey(instanceId string, tableName string, primaryKey string) string {
+	return k.PrimaryCachePrefix(instanceId, tableName) + ":" + primaryKey
+}
+
+func (k Keys) PrimaryCachePrefix(instanceId string, tableName string) string {
+	return k.Prefix(instanceId) + ":p:" + tableName
+}
+
+func (k Keys) SearchCacheKey(instanceId string, tableName string, sql string, vars ...interface{}) string {
+	if k.Normalizer != nil {
+		sql, vars = k.Normalizer.Normalize(sql, vars)
+	}
+	return k.SearchCachePrefix(instanceId, tableName) + ":" + k.QueryKey(sql, vars...)
+}
+
+func (k Keys) SearchCachePrefix(instanceId string, tableName string) string {
+	return k.Prefix(instanceId) + ":s:" + tableName
+}
+
+// SearchVersionKey is the key of the version folded into the search keys of the table, see
+// config.SearchInvalidationVersion
+func (k Keys) SearchVersionKey(instanceId string, tableName string) string {
+	return k.Prefix(instanceId) + ":sv:" + tableName
+}
+
+// HealthCheckKey is the sentinel key written and read back by a health check of the instance, token tells
+// apart the checks of processes sharing the instance id
+func (k Keys) HealthCheckKey(instanceId string, token string) string {
+	return k.Prefix(instanceId) + ":h:" + token
+}
+
+func (k Keys) TagIndexKey(instanceId string, tag string) string {
+	return k.Prefix(instanceId) + ":t:" + tag
+}
+
+func (k Keys) TagSetKey(instanceId string, tag string) string {
+	return k.Prefix(instanceId) + ":ts:" + tag
+}
+
+func (k Keys) SearchDependencyKey(instanceId string, tableName string, primaryKey string) string {
+	return k.Prefix(instanceId) + ":d:" + tableName + ":" + primaryKey
+}
+
+func (k Keys) SearchDependencySetKey(instanceId string, tableName string, primaryKey string) string {
+	return k.Prefix(instanceId) + ":ds:" + tableName + ":" + primaryKey
+}
+
+func (k Keys) DirtyMarkKey(instanceId string, tableName string, primaryKey string) string {
+	return k.Prefix(instanceId) + ":w:" + tableName + ":" + primaryKey
+}
+
+// PrimaryVersionKey is the key of the version of the row written last, see config.CacheConfig.PrimaryCacheVersioning.
+// The empty primaryKey is the version of the writes invalidating the whole table
+func (k Keys) PrimaryVersionKey(instanceId string, tableName string, primaryKey string) string {
+	return k.Prefix(instanceId) + ":pv:" + tableName + ":" + primaryKey
+}
+
+// PrimarySequenceKey is the key of the sequence the versions of the rows of the table are taken from
+func (k Keys) PrimarySequenceKey(instanceId string, tableName string) string {
+	return k.Prefix(instanceId) + ":ps:" + tableName
+}
+
+func (k Keys) SingleFlightLockKey(instanceId string, tableName string, searchKey string) string {
+	return k.Prefix(instanceId) + ":l:" + tableName + ":" + HashVars(searchKey)
+}
+
 func GenPrimaryCacheKey(instanceId string, tableName string, primaryKey string) string {
-	return fmt.Sprintf("%s:%s:p:%s:%s", DefaultGetGormCachePrefixFunc(), instanceId, tableName, primaryKey)
+	return NewKeys(nil).PrimaryCacheKey(instanceId, tableName, primaryKey)
 }
 
+// JoinPrimaryKey returns the primary key a row is cached by from the values of its primary key columns,
+// in the order of the schema. A single value is kept as is, the values of a composite key are joined by ","
+// with backslashes and commas escaped, so that different values never share a key, e.g. ("a,b", "c") and ("a", "b,c")
+func JoinPrimaryKey(values ...interface{}) string {
+	if len(values) == 1 {
+		return fmt.Sprintf("%v", values[0])
+	}
+	parts := make([]string, 0, len(values))
+	for _, value := range values {
+		parts = append(parts, primaryKeyEscaper.Replace(fmt.Sprintf("%v", value)))
+	}
+	return strings.Join(parts, ",")
+}
+
+var primaryKeyEscaper = strings.NewReplacer(`\`, `\\`, ",", `\,`)
+
 func GenPrimaryCachePrefix(instanceId string, tableName string) string {
-	return DefaultGetGormCachePrefixFunc() + ":" + instanceId + ":p:" + tableName
+	return NewKeys(nil).PrimaryCachePrefix(instanceId, tableName)
 }
 
+// GenSearchCacheKey keys a query by its SQL, normalized by DefaultSQLNormalizer, and a hash of its vars. Vars
+// are hashed with their kind, so that e.g. "1" and 1, nil and 0, or ("a:b", "c") and ("a", "b:c") never share a
+// key, while vars bound the same, like a pointer and its value or int64(1) and 1, always do
 func GenSearchCacheKey(instanceId string, tableName string, sql string, vars ...interface{}) string {
-	buf := strings.Builder{}
-	buf.WriteString(sql)
+	return NewKeys(nil).SearchCacheKey(instanceId, tableName, sql, vars...)
+}
+
+// HashVars returns a deterministic hash of the vars bound to a query
+func HashVars(vars ...interface{}) string {
+	h := sha256.New()
 	for _, v := range vars {
-		pv := reflect.ValueOf(v)
-		if pv.Kind() == reflect.Ptr {
-			buf.WriteString(fmt.Sprintf(":%v", pv.Elem()))
-		} else {
-			buf.WriteString(fmt.Sprintf(":%v", v))
+		writeVar(h, v)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// writeVar writes a var as its type and length prefixed value, values bound alike are written alike
+func writeVar(w io.Writer, v interface{}) {
+	pv := reflect.ValueOf(v)
+	for pv.Kind() == reflect.Ptr && !pv.IsNil() {
+		pv = pv.Elem()
+	}
+	if !pv.IsValid() || (pv.Kind() == reflect.Ptr && pv.IsNil()) {
+		fmt.Fprint(w, "nil;")
+		return
+	}
+	v = pv.Interface()
+	var repr string
+	switch value := v.(type) {
+	case time.Time:
+		// the monotonic clock reading is not part of the time bound
+		repr = value.Format(time.RFC3339Nano)
+	case []byte:
+		repr = hex.EncodeToString(value)
+	case driver.Valuer:
+		bound, err := value.Value()
+		if err == nil {
+			fmt.Fprintf(w, "%T(", v)
+			writeVar(w, bound)
+			fmt.Fprint(w, ");")
+			return
+		}
+		repr = fmt.Sprintf("%v", v)
+	default:
+		// scalars are bound by kind, e.g. int64(1) and 1 alike
+		switch pv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			fmt.Fprintf(w, "int:%d;", pv.Int())
+			return
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			fmt.Fprintf(w, "uint:%d;", pv.Uint())
+			return
+		case reflect.Float32, reflect.Float64:
+			fmt.Fprintf(w, "float:%v;", pv.Float())
+			return
+		case reflect.Bool:
+			fmt.Fprintf(w, "bool:%t;", pv.Bool())
+			return
+		case reflect.String:
+			fmt.Fprintf(w, "string:%d:%s;", pv.Len(), pv.String())
+			return
+		case reflect.Slice, reflect.Array:
+			fmt.Fprintf(w, "%T[%d](", v, pv.Len())
+			for i := 0; i < pv.Len(); i++ {
+				writeVar(w, pv.Index(i).Interface())
+			}
+			fmt.Fprint(w, ");")
+			return
 		}
+		repr = fmt.Sprintf("%v", v)
 	}
-	return fmt.Sprintf("%s:%s:s:%s:%s", DefaultGetGormCachePrefixFunc(), instanceId, tableName, buf.String())
+	fmt.Fprintf(w, "%T:%d:%s;", v, len(repr), repr)
 }
 
 func GenSearchCachePrefix(instanceId string, tableName string) string {
-	return DefaultGetGormCachePrefixFunc() + ":" + instanceId + ":s:" + tableName
+	return NewKeys(nil).SearchCachePrefix(instanceId, tableName)
 }
 
+// Deprecated: single flight loads are keyed by the search cache key of the query, see config.CacheConfig.EnableSingleFlight
 func GenSingleFlightKey(tableName string, sql string, vars ...interface{}) string {
 	buf := strings.Builder{}
 	buf.WriteString(sql)
@@ -58,3 +276,30 @@ func GenSingleFlightKey(tableName string, sql string, vars ...interface{}) strin
 	}
 	return fmt.Sprintf("%s:%s", tableName, buf.String())
 }
+
+func GenTagIndexKey(instanceId string, tag string) string {
+	return NewKeys(nil).TagIndexKey(instanceId, tag)
+}
+
+// GenTagSetKey returns the key of the index of a tag kept as a set, by storages implementing storage.SetStore
+func GenTagSetKey(instanceId string, tag string) string {
+	return NewKeys(nil).TagSetKey(instanceId, tag)
+}
+
+// GenSearchDependencyKey returns the key of the index of the search cache entries containing the row of
+// primaryKey, an empty primaryKey keys the entries whose rows aren't known
+func GenSearchDependencyKey(instanceId string, tableName string, primaryKey string) string {
+	return NewKeys(nil).SearchDependencyKey(instanceId, tableName, primaryKey)
+}
+
+// GenSearchDependencySetKey returns the key of the index of GenSearchDependencyKey kept as a set, by storages
+// implementing storage.SetStore
+func GenSearchDependencySetKey(instanceId string, tableName string, primaryKey string) string {
+	return NewKeys(nil).SearchDependencySetKey(instanceId, tableName, primaryKey)
+}
+
+// GenSingleFlightLockKey returns the key of the lock taken to load the query of a search cache key
+// across instances, see config.CacheConfig.DistributedSingleFlight
+func GenSingleFlightLockKey(instanceId string, tableName string, searchKey string) string {
+	return NewKeys(nil).SingleFlightLockKey(instanceId, tableName, searchKey)
+}
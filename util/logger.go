@@ -3,6 +3,7 @@ package util
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 )
 
@@ -12,6 +13,47 @@ type LoggerInterface interface {
 	CtxError(ctx context.Context, format string, v ...interface{})
 }
 
+// StructuredLogger is implemented by loggers taking entries of a level with key/value fields, the cache logs
+// its hits, misses and invalidations to them at the levels of config.CacheConfig.EventLogLevels, whatever
+// DebugMode. DefaultLogger and the adapters built on FuncLogger implement it
+type StructuredLogger interface {
+	LoggerInterface
+	CtxLog(ctx context.Context, level Level, msg string, fields ...Field)
+}
+
+// Level the level of a structured log entry
+type Level int
+
+const (
+	// LevelOff the entry isn't logged
+	LevelOff Level = iota
+	LevelDebug
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	default:
+		return "OFF"
+	}
+}
+
+// Field a key/value pair of a structured log entry
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
 type DefaultLogger struct {
 	isDebug bool
 }
@@ -33,3 +75,21 @@ func (l *DefaultLogger) CtxError(ctx context.Context, format string, v ...interf
 		fmt.Printf(timePrefix+" [ERROR] "+format+"\n", v...)
 	}
 }
+
+func (l *DefaultLogger) CtxLog(ctx context.Context, level Level, msg string, fields ...Field) {
+	if level == LevelOff {
+		return
+	}
+	timePrefix := time.Now().Format("2006-01-02 15:04:05.999")
+	fmt.Printf("%s [%s] %s\n", timePrefix, level, appendFields(msg, fields))
+}
+
+// appendFields formats the fields after msg as key=value pairs, for loggers taking plain messages
+func appendFields(msg string, fields []Field) string {
+	var b strings.Builder
+	b.WriteString(msg)
+	for _, field := range fields {
+		_, _ = fmt.Fprintf(&b, " %s=%v", field.Key, field.Value)
+	}
+	return b.String()
+}
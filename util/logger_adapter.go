@@ -0,0 +1,99 @@
+package util
+
+import (
+	"context"
+	"fmt"
+
+	"gorm.io/gorm/logger"
+)
+
+var (
+	_ StructuredLogger = &DefaultLogger{}
+	_ StructuredLogger = &FuncLogger{}
+)
+
+// FuncLogger logs each entry with a func, the adapters of other loggers are built on it. CtxInfo logs the
+// access log of DebugMode at LevelDebug and only in debug mode, as DefaultLogger does, CtxError logs at LevelError
+type FuncLogger struct {
+	log     func(ctx context.Context, level Level, msg string, fields []Field)
+	isDebug bool
+}
+
+// NewFuncLogger adapts a logger of its own to the cache, e.g. a zerolog.Logger:
+//
+//	util.NewFuncLogger(func(ctx context.Context, level util.Level, msg string, fields []util.Field) {
+//		event := log.WithLevel(zerologLevels[level])
+//		for _, field := range fields {
+//			event = event.Interface(field.Key, field.Value)
+//		}
+//		event.Msg(msg)
+//	})
+func NewFuncLogger(log func(ctx context.Context, level Level, msg string, fields []Field)) *FuncLogger {
+	return &FuncLogger{log: log}
+}
+
+func (l *FuncLogger) SetIsDebug(debug bool) {
+	l.isDebug = debug
+}
+
+func (l *FuncLogger) CtxInfo(ctx context.Context, format string, v ...interface{}) {
+	if l.isDebug {
+		l.log(ctx, LevelDebug, fmt.Sprintf(format, v...), nil)
+	}
+}
+
+func (l *FuncLogger) CtxError(ctx context.Context, format string, v ...interface{}) {
+	l.log(ctx, LevelError, fmt.Sprintf(format, v...), nil)
+}
+
+func (l *FuncLogger) CtxLog(ctx context.Context, level Level, msg string, fields ...Field) {
+	if level != LevelOff {
+		l.log(ctx, level, msg, fields)
+	}
+}
+
+// ZapSugaredLogger is the subset of a zap logger the zap adapter logs with, *zap.SugaredLogger of
+// go.uber.org/zap implements it as it is
+type ZapSugaredLogger interface {
+	Debugw(msg string, keysAndValues ...interface{})
+	Infow(msg string, keysAndValues ...interface{})
+	Warnw(msg string, keysAndValues ...interface{})
+	Errorw(msg string, keysAndValues ...interface{})
+}
+
+// NewZapLogger logs to a zap logger, the fields of an entry passed as its key/value pairs, e.g.
+// util.NewZapLogger(zapLogger.Sugar())
+func NewZapLogger(sugared ZapSugaredLogger) *FuncLogger {
+	return NewFuncLogger(func(_ context.Context, level Level, msg string, fields []Field) {
+		keysAndValues := make([]interface{}, 0, 2*len(fields))
+		for _, field := range fields {
+			keysAndValues = append(keysAndValues, field.Key, field.Value)
+		}
+		switch level {
+		case LevelDebug:
+			sugared.Debugw(msg, keysAndValues...)
+		case LevelInfo:
+			sugared.Infow(msg, keysAndValues...)
+		case LevelWarn:
+			sugared.Warnw(msg, keysAndValues...)
+		default:
+			sugared.Errorw(msg, keysAndValues...)
+		}
+	})
+}
+
+// NewGormLogger logs to the logger of gorm, e.g. util.NewGormLogger(db.Logger). It takes plain messages, the
+// fields of an entry are appended to it as key=value pairs and debug entries logged as infos
+func NewGormLogger(gormLogger logger.Interface) *FuncLogger {
+	return NewFuncLogger(func(ctx context.Context, level Level, msg string, fields []Field) {
+		// the message is a format of gorm's logger, so the formatted entry is passed as an argument
+		switch level {
+		case LevelDebug, LevelInfo:
+			gormLogger.Info(ctx, "%s", appendFields(msg, fields))
+		case LevelWarn:
+			gormLogger.Warn(ctx, "%s", appendFields(msg, fields))
+		default:
+			gormLogger.Error(ctx, "%s", appendFields(msg, fields))
+		}
+	})
+}
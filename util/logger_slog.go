@@ -0,0 +1,33 @@
+//go:build go1.21
+
+package util
+
+import (
+	"context"
+	"log/slog"
+)
+
+// NewSlogLogger logs to a slog logger, the fields of an entry passed as its attributes, e.g.
+// util.NewSlogLogger(slog.Default())
+func NewSlogLogger(logger *slog.Logger) *FuncLogger {
+	return NewFuncLogger(func(ctx context.Context, level Level, msg string, fields []Field) {
+		attrs := make([]slog.Attr, 0, len(fields))
+		for _, field := range fields {
+			attrs = append(attrs, slog.Any(field.Key, field.Value))
+		}
+		logger.LogAttrs(ctx, slogLevel(level), msg, attrs...)
+	})
+}
+
+func slogLevel(level Level) slog.Level {
+	switch level {
+	case LevelDebug:
+		return slog.LevelDebug
+	case LevelInfo:
+		return slog.LevelInfo
+	case LevelWarn:
+		return slog.LevelWarn
+	default:
+		return slog.LevelError
+	}
+}
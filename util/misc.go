@@ -22,3 +22,29 @@ func RandFloatingInt64(v int64) int64 {
 	randNum := rand.Float64()*0.2 + 0.9
 	return int64(float64(v) * randNum)
 }
+
+// JitterInt64 randomizes v uniformly by up to jitter (a fraction of v) either way, never below 1.
+// A jitter not above 0 falls back to RandFloatingInt64.
+func JitterInt64(v int64, jitter float64) int64 {
+	if jitter <= 0 {
+		return RandFloatingInt64(v)
+	}
+	randNum := 1 - jitter + rand.Float64()*2*jitter
+	if jittered := int64(float64(v) * randNum); jittered > 0 {
+		return jittered
+	}
+	return 1
+}
+
+// Chunk splits items into consecutive slices of at most size items, a single one if size isn't above 0
+func Chunk[T any](items []T, size int) [][]T {
+	if size <= 0 || len(items) <= size {
+		return [][]T{items}
+	}
+	chunks := make([][]T, 0, (len(items)+size-1)/size)
+	for size < len(items) {
+		chunks = append(chunks, items[:size:size])
+		items = items[size:]
+	}
+	return append(chunks, items)
+}
@@ -0,0 +1,246 @@
+package util
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	_ SQLNormalizer = DefaultSQLNormalizer
+	_ SQLNormalizer = NoopSQLNormalizer
+)
+
+// SQLNormalizer rewrites the sql and vars of a query before its search cache key is built, so that queries
+// reading the same rows share an entry. The query run against the database is left as is
+type SQLNormalizer interface {
+	Normalize(sql string, vars []interface{}) (string, []interface{})
+}
+
+// SQLNormalizerFunc adapts a function to SQLNormalizer
+type SQLNormalizerFunc func(sql string, vars []interface{}) (string, []interface{})
+
+func (f SQLNormalizerFunc) Normalize(sql string, vars []interface{}) (string, []interface{}) {
+	return f(sql, vars)
+}
+
+// NoopSQLNormalizer keys queries by their sql and vars as is
+var NoopSQLNormalizer SQLNormalizer = SQLNormalizerFunc(func(sql string, vars []interface{}) (string, []interface{}) {
+	return sql, vars
+})
+
+// DefaultSQLNormalizer normalizes the search cache keys of the instances without config.CacheConfig.SQLNormalizer:
+//   - runs of whitespace out of quoted literals are collapsed to a space, dropped around "(", ")" and ","
+//   - numbered placeholders like "$1" become "?", their vars ordered and repeated as referenced
+//   - the values of IN lists of placeholders, numbers or plain string literals (without "," or escapes) are sorted
+var DefaultSQLNormalizer SQLNormalizer = SQLNormalizerFunc(normalizeSQL)
+
+var (
+	inPlaceholdersRegexp = regexp.MustCompile(`(?i)\bIN\(\?(,\?)*\)`)
+	inLiteralsRegexp     = regexp.MustCompile(`(?i)\bIN\(((-?[0-9.]+|'[^'\\,]*')(,(-?[0-9.]+|'[^'\\,]*'))*)\)`)
+)
+
+func normalizeSQL(sql string, vars []interface{}) (string, []interface{}) {
+	scanned := scanSQL(sql)
+	normalized := scanned.sql
+	if scanned.order != nil {
+		reordered := make([]interface{}, 0, len(scanned.order))
+		for _, idx := range scanned.order {
+			if idx < 0 || idx >= len(vars) {
+				// placeholders not matching the vars, keep them as bound
+				return normalized, vars
+			}
+			reordered = append(reordered, vars[idx])
+		}
+		vars = reordered
+	} else if len(scanned.placeholders) != len(vars) {
+		return normalized, vars
+	}
+
+	if len(vars) > 1 {
+		vars = scanned.sortInPlaceholders(vars)
+	}
+	return scanned.sortInLiterals(), vars
+}
+
+// scannedSQL is the sql of a query with its whitespace normalized and its placeholders located
+type scannedSQL struct {
+	sql          string
+	quoted       [][2]int // spans of the quoted literals and identifiers of sql
+	placeholders []int    // offsets of the placeholders of sql
+	order        []int    // indices of the vars bound to the placeholders, nil if they are bound in order
+}
+
+// scanSQL normalizes the whitespace and the placeholders of sql, quoted literals and identifiers are kept as is
+func scanSQL(sql string) scannedSQL {
+	var (
+		out      strings.Builder
+		scanned  scannedSQL
+		space    bool
+		numbered = hasNumberedPlaceholders(sql)
+	)
+	out.Grow(len(sql))
+	last := func() byte {
+		if out.Len() == 0 {
+			return 0
+		}
+		return out.String()[out.Len()-1]
+	}
+	for i := 0; i < len(sql); i++ {
+		ch := sql[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			space = true
+			continue
+		case ch == '\'' || ch == '"' || ch == '`':
+			end := quoteEnd(sql, i)
+			writeSpace(&out, space, last(), ch)
+			space = false
+			start := out.Len()
+			out.WriteString(sql[i:end])
+			scanned.quoted = append(scanned.quoted, [2]int{start, out.Len()})
+			i = end - 1
+			continue
+		}
+		writeSpace(&out, space, last(), ch)
+		space = false
+		switch {
+		case ch == '?' && !numbered:
+			scanned.placeholders = append(scanned.placeholders, out.Len())
+		case ch == '$' && numbered && i+1 < len(sql) && isDigit(sql[i+1]):
+			n := 0
+			for i+1 < len(sql) && isDigit(sql[i+1]) {
+				i++
+				n = n*10 + int(sql[i]-'0')
+			}
+			scanned.placeholders = append(scanned.placeholders, out.Len())
+			scanned.order = append(scanned.order, n-1)
+			ch = '?'
+		}
+		out.WriteByte(ch)
+	}
+	scanned.sql = out.String()
+	return scanned
+}
+
+// writeSpace writes the space collapsed between prev and next, unless either of them is a separator
+func writeSpace(out *strings.Builder, space bool, prev byte, next byte) {
+	if !space || prev == 0 || strings.IndexByte("(),", prev) >= 0 || strings.IndexByte("(),", next) >= 0 {
+		return
+	}
+	out.WriteByte(' ')
+}
+
+// quoteEnd returns the offset right after the literal or identifier quoted at start, doubled quotes and
+// backslashes escape the quote
+func quoteEnd(sql string, start int) int {
+	quote := sql[start]
+	for i := start + 1; i < len(sql); i++ {
+		switch sql[i] {
+		case '\\':
+			i++
+		case quote:
+			if i+1 < len(sql) && sql[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(sql)
+}
+
+// hasNumberedPlaceholders reports whether sql binds its vars by "$1" like placeholders out of quotes
+func hasNumberedPlaceholders(sql string) bool {
+	for i := 0; i < len(sql); i++ {
+		switch ch := sql[i]; {
+		case ch == '\'' || ch == '"' || ch == '`':
+			i = quoteEnd(sql, i) - 1
+		case ch == '$' && i+1 < len(sql) && isDigit(sql[i+1]):
+			return true
+		}
+	}
+	return false
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+func (s scannedSQL) inQuotes(offset int) bool {
+	for _, span := range s.quoted {
+		if offset >= span[0] && offset < span[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// sortInPlaceholders sorts the vars bound to the placeholders of every IN list by their hash representation
+func (s scannedSQL) sortInPlaceholders(vars []interface{}) []interface{} {
+	var sorted []interface{}
+	for _, match := range inPlaceholdersRegexp.FindAllStringIndex(s.sql, -1) {
+		if s.inQuotes(match[0]) {
+			continue
+		}
+		first := sort.SearchInts(s.placeholders, match[0])
+		last := sort.SearchInts(s.placeholders, match[1])
+		if last-first < 2 {
+			continue
+		}
+		if sorted == nil {
+			sorted = append([]interface{}(nil), vars...)
+		}
+		list := sorted[first:last]
+		reprs := make([]string, len(list))
+		for idx, v := range list {
+			reprs[idx] = varRepr(v)
+		}
+		sort.Sort(byRepr{vars: list, reprs: reprs})
+	}
+	if sorted == nil {
+		return vars
+	}
+	return sorted
+}
+
+// sortInLiterals sorts the values of the IN lists of numbers and plain string literals
+func (s scannedSQL) sortInLiterals() string {
+	matches := inLiteralsRegexp.FindAllStringSubmatchIndex(s.sql, -1)
+	if len(matches) == 0 {
+		return s.sql
+	}
+	var out strings.Builder
+	end := 0
+	for _, match := range matches {
+		if s.inQuotes(match[0]) {
+			continue
+		}
+		values := strings.Split(s.sql[match[2]:match[3]], ",")
+		sort.Strings(values)
+		out.WriteString(s.sql[end:match[2]])
+		out.WriteString(strings.Join(values, ","))
+		end = match[3]
+	}
+	out.WriteString(s.sql[end:])
+	return out.String()
+}
+
+// byRepr sorts vars by their hash representation
+type byRepr struct {
+	vars  []interface{}
+	reprs []string
+}
+
+func (b byRepr) Len() int           { return len(b.vars) }
+func (b byRepr) Less(i, j int) bool { return b.reprs[i] < b.reprs[j] }
+func (b byRepr) Swap(i, j int) {
+	b.vars[i], b.vars[j] = b.vars[j], b.vars[i]
+	b.reprs[i], b.reprs[j] = b.reprs[j], b.reprs[i]
+}
+
+func varRepr(v interface{}) string {
+	var repr strings.Builder
+	writeVar(&repr, v)
+	return repr.String()
+}